@@ -41,6 +41,7 @@ import (
 	"github.com/decred/slog"
 	"github.com/muesli/reflow/wordwrap"
 	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
 	"golang.org/x/text/collate"
 	"golang.org/x/text/language"
 )
@@ -74,11 +75,25 @@ type balance struct {
 	send  dcrutil.Amount
 }
 
+// ftUploadKey identifies the upload of a file to a remote user.
+type ftUploadKey struct {
+	uid clientintf.UserID
+	fid clientdb.FileID
+}
+
+// ftUploadProgress tracks the progress of an in-progress upload.
+type ftUploadProgress struct {
+	fm         rpc.FileMetadata
+	sentChunks int
+	msg        *chatMsg
+}
+
 type appState struct {
 	ctx         context.Context
 	cancel      func()
 	wg          sync.WaitGroup
 	c           *client.Client
+	db          *clientdb.DB
 	sendMsg     func(tea.Msg)
 	logBknd     *logBackend
 	log         slog.Logger
@@ -137,6 +152,7 @@ type appState struct {
 	contentMtx  sync.Mutex
 	remoteFiles map[clientintf.UserID]map[clientdb.FileID]clientdb.RemoteFile
 	progressMsg map[clientdb.FileID]*chatMsg
+	uploads     map[ftUploadKey]*ftUploadProgress
 
 	qlenMtx sync.Mutex
 	qlen    int
@@ -622,9 +638,47 @@ func (as *appState) activeWindowMsgs() string {
 	cw := as.chatWindows[as.activeCW]
 	as.chatWindowsMtx.Unlock()
 	msgs := cw.renderContent(as.winW, as.styles)
+	if cw.isGC {
+		as.markGCMsgsRead(cw)
+	} else {
+		as.markPMsRead(cw)
+	}
 	return msgs
 }
 
+// markPMsRead sends the read receipts of the PMs displayed in the given
+// window.
+func (as *appState) markPMsRead(cw *chatWindow) {
+	ids := cw.unreadMsgs()
+	if len(ids) == 0 {
+		return
+	}
+	go func() {
+		for _, id := range ids {
+			if err := as.c.MarkPMRead(cw.uid, id); err != nil {
+				as.log.Warnf("Unable to send read receipt to %s: %v",
+					cw.alias, err)
+			}
+		}
+	}()
+}
+
+// markGCMsgsRead marks the messages displayed in the given GC window as read.
+// Marking the last one is enough, as the ones before it are also considered
+// read.
+func (as *appState) markGCMsgsRead(cw *chatWindow) {
+	ids := cw.unreadMsgs()
+	if len(ids) == 0 {
+		return
+	}
+	go func() {
+		if err := as.c.MarkGCMsgRead(cw.gc, ids[len(ids)-1]); err != nil {
+			as.log.Warnf("Unable to mark messages of GC %s as read: %v",
+				cw.alias, err)
+		}
+	}()
+}
+
 func (as *appState) rmqLen() int {
 	as.qlenMtx.Lock()
 	l := as.qlen
@@ -973,6 +1027,17 @@ func (as *appState) findOrNewChatWindow(id clientintf.UserID, alias string) *cha
 
 // findChatWindow finds the existing chat window for the given user. Returns nil
 // if the chat window is not setup.
+// activeChatWindow returns the active chat window or nil if the active window
+// is not a PM or GC window.
+func (as *appState) activeChatWindow() *chatWindow {
+	as.chatWindowsMtx.Lock()
+	defer as.chatWindowsMtx.Unlock()
+	if as.activeCW < 0 || as.activeCW >= len(as.chatWindows) {
+		return nil
+	}
+	return as.chatWindows[as.activeCW]
+}
+
 func (as *appState) findChatWindow(id clientintf.UserID) *chatWindow {
 	as.chatWindowsMtx.Lock()
 	for _, cw := range as.chatWindows {
@@ -1057,6 +1122,12 @@ func (as *appState) handleRcvdText(s string, nick string) string {
 	// Cannonicalize line endings.
 	s = strescape.CannonicalizeNL(s)
 
+	return s
+}
+
+// ringBell runs the configured bell command for a received message that
+// should be notified.
+func (as *appState) ringBell(s string, nick string) {
 	if len(as.bellCmd) > 0 && as.bellCmd[0] == "*BEEP*" {
 		os.Stdout.Write([]byte("\a"))
 	} else if len(as.bellCmd) > 0 {
@@ -1079,8 +1150,33 @@ func (as *appState) handleRcvdText(s string, nick string) string {
 			}
 		}()
 	}
+}
 
-	return s
+// gcMentions returns the ids of the members of the GC mentioned (as @nick) in
+// the given message.
+func (as *appState) gcMentions(gcID zkidentity.ShortID, msg string) []clientintf.UserID {
+	if !strings.Contains(msg, "@") {
+		return nil
+	}
+	gc, err := as.c.GetGC(gcID)
+	if err != nil {
+		return nil
+	}
+
+	var mentions []clientintf.UserID
+	for _, word := range strings.Fields(msg) {
+		if len(word) < 2 || word[0] != '@' {
+			continue
+		}
+		nick := strings.TrimRight(word[1:], ".,:;!?")
+		uid, err := as.c.UIDByNick(nick)
+		if err != nil || !slices.Contains(gc.Members, uid) ||
+			slices.Contains(mentions, uid) {
+			continue
+		}
+		mentions = append(mentions, uid)
+	}
+	return mentions
 }
 
 // writeInvite writes a new invite to the given filename. This blocks until the
@@ -1108,16 +1204,25 @@ func (as *appState) writeInvite(filename string) {
 // pm sends the given pm message in the specified window. Blocks until the
 // messsage is sent to the server.
 func (as *appState) pm(cw *chatWindow, msg string) {
-	m := cw.newUnsentPM(msg)
+	as.pmReply(cw, zkidentity.ShortID{}, msg)
+}
+
+// pmReply sends the given message in the specified window as a reply to the
+// message with id replyTo. Blocks until the messsage is sent to the server.
+func (as *appState) pmReply(cw *chatWindow, replyTo zkidentity.ShortID, msg string) {
+	m := cw.newUnsentReply(replyTo, msg)
 	as.repaintIfActive(cw)
 
 	var err error
+	var id client.MsgID
 	var progrChan chan client.SendProgress
 	if cw.isGC {
 		progrChan = make(chan client.SendProgress)
-		err = as.c.GCMessage(cw.gc, msg, rpc.MessageModeNormal, progrChan)
+		mentions := as.gcMentions(cw.gc, msg)
+		id, err = as.c.GCMessageWithMentions(cw.gc, replyTo, msg,
+			rpc.MessageModeNormal, mentions, progrChan)
 	} else {
-		err = as.c.PM(cw.uid, msg)
+		id, err = as.c.PMReply(cw.uid, replyTo, msg)
 	}
 	if err != nil {
 		if cw.isGC {
@@ -1128,7 +1233,7 @@ func (as *appState) pm(cw *chatWindow, msg string) {
 				cw.alias, err)
 		}
 	} else if progrChan == nil {
-		cw.setMsgSent(m)
+		cw.setMsgSentWithID(m, id)
 		as.sendMsg(repaintActiveChat{})
 	} else {
 		for progr := range progrChan {
@@ -1138,7 +1243,7 @@ func (as *appState) pm(cw *chatWindow, msg string) {
 			as.log.Debugf("Progress on GC Message %d/%d",
 				progr.Sent, progr.Total)
 			if progr.Sent == progr.Total {
-				cw.setMsgSent(m)
+				cw.setMsgSentWithID(m, id)
 				as.sendMsg(repaintActiveChat{})
 				break
 			}
@@ -1189,7 +1294,7 @@ func (as *appState) inviteToGC(cw *chatWindow, nick string, uid clientintf.UserI
 	}
 }
 
-// kickFromGC kicks the given user from the given GC. Only works if we're the
+// kickFromGC kicks the given user from the given GC. Only works if we're an
 // admin of the GC.
 func (as *appState) kickFromGC(gcWin *chatWindow, uid clientintf.UserID,
 	userNick, reason string) {
@@ -1206,6 +1311,18 @@ func (as *appState) kickFromGC(gcWin *chatWindow, uid clientintf.UserID,
 	}
 }
 
+// modifyGCAdmins calls f to change the admins of the GC and reports the
+// result in the GC window.
+func (as *appState) modifyGCAdmins(gcWin *chatWindow, okMsg string, f func() error) {
+	err := f()
+	if err == nil {
+		gcWin.newInternalMsg(okMsg)
+		as.repaintIfActive(gcWin)
+	} else {
+		as.cwHelpMsg("Unable to modify admins of gc %q: %v", gcWin.alias, err)
+	}
+}
+
 // partFromGC withdraws the local user from the GC.
 func (as *appState) partFromGC(gcWin *chatWindow, reason string) {
 	gcName := gcWin.alias
@@ -1938,15 +2055,19 @@ func (as *appState) handleCmd(rawText string, args []string) {
 		return
 	}
 	if err != nil {
-		as.log.Errorf("Error executing %q: %v", rawText, err)
+		logText := rawText
+		if cmd.secretArgs {
+			logText = string(leader) + fullCmd
+		}
+		as.log.Errorf("Error executing %q: %v", logText, err)
 		as.cwHelpMsgs(func(pf printf) {
-			pf(renderErr("Error executing %q: %v", rawText, err))
+			pf(renderErr("Error executing %q: %v", logText, err))
 		})
 	}
 
 	// Save successful command in history file. Ignore errors here as
 	// there's nothing to do about it.
-	if storeCmd && err == nil && as.cmdHistoryFile != nil {
+	if storeCmd && err == nil && as.cmdHistoryFile != nil && !cmd.secretArgs {
 		_, _ = as.cmdHistoryFile.Write([]byte(rawText))
 		_, _ = as.cmdHistoryFile.Write([]byte("\n"))
 		_ = as.cmdHistoryFile.Sync()
@@ -1981,6 +2102,10 @@ func newAppState(sendMsg func(tea.Msg), lndLogLines *sloglinesbuffer.Buffer,
 		DownloadsRoot: args.DownloadsRoot,
 		Logger:        logBknd.logger("FDDB"),
 		ChunkSize:     rpc.MaxChunkSize,
+		Encrypt:       args.EncryptDB,
+		Driver:        args.DBDriver,
+
+		ContentDefinedChunking: args.ContentDefinedChunking,
 	})
 	if err != nil {
 		return nil, fmt.Errorf("unable to initialize DB: %v", err)
@@ -2043,6 +2168,8 @@ func newAppState(sendMsg func(tea.Msg), lndLogLines *sloglinesbuffer.Buffer,
 		ReconnectDelay: 5 * time.Second,
 		CompressLevel:  args.CompressLevel,
 
+		MultiSourceDownloads: args.MultiSourceDownloads,
+
 		CertConfirmer: func(ctx context.Context, cs *tls.ConnectionState,
 			svrID *zkidentity.PublicIdentity) error {
 			msg := msgConfirmServerCert{
@@ -2202,10 +2329,17 @@ func newAppState(sendMsg func(tea.Msg), lndLogLines *sloglinesbuffer.Buffer,
 			fromNick := strescape.Nick(user.PublicIdentity().Nick)
 			cw := as.findOrNewChatWindow(user.ID(), fromNick)
 			s := as.handleRcvdText(msg.Message, fromNick)
-			cw.newRecvdMsg(fromNick, s, ts)
+			cw.newRecvdPM(fromNick, s, msg.ID, msg.ReplyTo, ts)
 			as.repaintIfActiveWithMention(cw, hasMention(as.c.LocalNick(), s))
 		},
 
+		PMReceiptHandler: func(user *client.RemoteUser, receipt rpc.RMReceipt) {
+			cw := as.findChatWindow(user.ID())
+			if cw != nil && cw.setMsgReceipt(receipt.ID, receipt.Status) {
+				as.repaintIfActive(cw)
+			}
+		},
+
 		GCInviteHandler: func(user *client.RemoteUser, iid uint64, invite rpc.RMGroupInvite) {
 			gcName := strescape.Nick(invite.Name)
 			as.gcInvitesMtx.Lock()
@@ -2221,6 +2355,25 @@ func newAppState(sendMsg func(tea.Msg), lndLogLines *sloglinesbuffer.Buffer,
 			as.repaintIfActive(cw)
 		},
 
+		GCJoinRejected: func(user *client.RemoteUser, invite rpc.RMGroupInvite, reason string) {
+			gcName := strescape.Nick(invite.Name)
+			as.gcInvitesMtx.Lock()
+			delete(as.gcInvites, gcName)
+			as.gcInvitesMtx.Unlock()
+			as.diagMsg("%q rejected our join of gc \"%s\" (%v): %s",
+				user.Nick(), gcName, invite.ID.String(),
+				strescape.Content(reason))
+		},
+
+		GCJoinRequested: func(req clientdb.GCJoinRequest) {
+			gcName, _ := as.c.GetGCAlias(req.GC)
+			as.diagMsg("User %q (%s) requested to join GC %q. Type "+
+				"/gc approvejoin %s to approve.",
+				strescape.Nick(req.Invite.Public.Nick),
+				req.Invite.Public.Identity, gcName,
+				req.Invite.Public.Identity)
+		},
+
 		GCListUpdated: func(gc clientdb.GCAddressBookEntry) {
 			// Remove GC invite if it exists.
 			gcName, _ := as.c.GetGCAlias(gc.ID)
@@ -2233,6 +2386,14 @@ func newAppState(sendMsg func(tea.Msg), lndLogLines *sloglinesbuffer.Buffer,
 			as.repaintIfActive(cw)
 		},
 
+		GCMetadataUpdated: func(user *client.RemoteUser, md rpc.RMGroupMetadata) {
+			cw := as.findOrNewGCWindow(md.ID)
+			cw.newInternalMsg(fmt.Sprintf("%s updated the GC metadata. "+
+				"Topic: %q", strescape.Nick(user.Nick()),
+				strescape.Content(md.Topic)))
+			as.repaintIfActive(cw)
+		},
+
 		GCUserParted: func(gcid client.GCID, uid clientintf.UserID, reason string, kicked bool) {
 			cw := as.findOrNewGCWindow(gcid)
 			if uid == as.c.PublicID() {
@@ -2266,6 +2427,20 @@ func newAppState(sendMsg func(tea.Msg), lndLogLines *sloglinesbuffer.Buffer,
 			as.repaintIfActive(cw)
 		},
 
+		GCMeshKXProgress: func(progress client.GCMeshKXProgress) {
+			cw := as.findOrNewGCWindow(progress.GC)
+			if progress.Err != nil {
+				cw.newInternalMsg(fmt.Sprintf("Unable to request KX "+
+					"with member %s (%d/%d): %v", progress.Target,
+					progress.Sent, progress.Total, progress.Err))
+			} else {
+				cw.newInternalMsg(fmt.Sprintf("Requested KX with "+
+					"member %s (%d/%d)", progress.Target,
+					progress.Sent, progress.Total))
+			}
+			as.repaintIfActive(cw)
+		},
+
 		GCWithUnkxdMember: func(gcid client.GCID, uid client.UserID) {
 			// Alert about missing KX with gc member only once every
 			// 24 hours to avoid spamming win0.
@@ -2319,8 +2494,61 @@ func newAppState(sendMsg func(tea.Msg), lndLogLines *sloglinesbuffer.Buffer,
 		GCMsgHandler: func(user *client.RemoteUser, msg rpc.RMGroupMessage, ts time.Time) {
 			cw := as.findOrNewGCWindow(msg.ID)
 			s := as.handleRcvdText(msg.Message, cw.alias)
-			cw.newRecvdMsg(user.Nick(), s, ts)
-			as.repaintIfActiveWithMention(cw, hasMention(as.c.LocalNick(), s))
+			cw.newRecvdGCMsg(user.Nick(), user.ID(), s, msg.MsgID, msg.ReplyTo, ts)
+			mentioned := msg.IsMentioned(as.c.PublicID()) ||
+				hasMention(as.c.LocalNick(), s)
+			as.repaintIfActiveWithMention(cw, mentioned)
+		},
+
+		NotificationHandler: func(n client.Notification) {
+			src := strescape.Nick(n.FromNick)
+			if !n.GC.IsEmpty() {
+				src = as.findOrNewGCWindow(n.GC).alias
+			}
+			as.ringBell(strescape.Content(n.Message), src)
+		},
+
+		MessageEditHandler: func(user *client.RemoteUser, edit rpc.RMMessageEdit, ts time.Time) {
+			var cw *chatWindow
+			if edit.GC.IsEmpty() {
+				cw = as.findChatWindow(user.ID())
+			} else {
+				cw = as.findOrNewGCWindow(edit.GC)
+			}
+			if cw == nil {
+				return
+			}
+			s := as.handleRcvdText(edit.Message, cw.alias)
+			if cw.editMsg(false, user.ID(), edit.ID, s) {
+				as.repaintIfActive(cw)
+			}
+		},
+
+		MessageRetractHandler: func(user *client.RemoteUser, retract rpc.RMMessageRetract, ts time.Time) {
+			var cw *chatWindow
+			if retract.GC.IsEmpty() {
+				cw = as.findChatWindow(user.ID())
+			} else {
+				cw = as.findOrNewGCWindow(retract.GC)
+			}
+			if cw != nil && cw.retractMsg(false, user.ID(), retract.ID) {
+				as.repaintIfActive(cw)
+			}
+		},
+
+		MessageReactionHandler: func(user *client.RemoteUser, reaction rpc.RMMessageReaction,
+			reactions clientdb.Reactions, ts time.Time) {
+
+			var cw *chatWindow
+			if reaction.GC.IsEmpty() {
+				cw = as.findChatWindow(user.ID())
+			} else {
+				cw = as.findOrNewGCWindow(reaction.GC)
+			}
+			if cw != nil && cw.reactToMsg(reaction.ID, user.ID(),
+				reaction.Reaction, reaction.Remove) {
+				as.repaintIfActive(cw)
+			}
 		},
 
 		KXCompleted: func(user *client.RemoteUser) {
@@ -2539,6 +2767,53 @@ func newAppState(sendMsg func(tea.Msg), lndLogLines *sloglinesbuffer.Buffer,
 			as.repaintIfActive(cw)
 		},
 
+		FileUploadProgress: func(user *client.RemoteUser, fm rpc.FileMetadata,
+			chunkIdx int) {
+
+			cw := as.findOrNewChatWindow(user.ID(), strescape.Nick(user.Nick()))
+			totChunks := len(fm.Manifest)
+
+			key := ftUploadKey{uid: user.ID(), fid: clientdb.FileID(fm.MetadataHash())}
+			as.contentMtx.Lock()
+			up := as.uploads[key]
+			if up == nil {
+				up = &ftUploadProgress{msg: cw.newInternalMsg("")}
+				as.uploads[key] = up
+			}
+			up.fm = fm
+			if up.sentChunks < totChunks {
+				up.sentChunks++
+			}
+			if up.sentChunks == totChunks {
+				delete(as.uploads, key)
+			}
+			up.msg.msg = fmt.Sprintf("Uploaded %d/%d chunks (%.2f%%) - %q",
+				up.sentChunks, totChunks,
+				float64(up.sentChunks*100/totChunks), fm.Filename)
+			as.contentMtx.Unlock()
+
+			as.repaintIfActive(cw)
+		},
+
+		SharedFolderUpdated: func(user *client.RemoteUser, sf clientdb.SubscribedFolder) {
+			cw := as.findOrNewChatWindow(user.ID(), strescape.Nick(user.Nick()))
+			name := strescape.PathElement(sf.Name)
+			switch {
+			case sf.Removed:
+				cw.newInternalMsg(fmt.Sprintf("Folder %q is no "+
+					"longer shared", name))
+			case sf.LocalPath == "":
+				cw.newInternalMsg(fmt.Sprintf("Shared folder %q "+
+					"with %d files. Use /ft acceptfolder %s %s "+
+					"<dir> to sync it", name, len(sf.Files),
+					strescape.Nick(user.Nick()), sf.ID))
+			default:
+				cw.newInternalMsg(fmt.Sprintf("Shared folder %q "+
+					"updated to version %d", name, sf.Version))
+			}
+			as.repaintIfActive(cw)
+		},
+
 		TransitiveEvent: func(src, dst client.UserID, event client.TransitiveEvent) {
 			srcRU, err := as.c.UserByID(src)
 			if err != nil {
@@ -2591,6 +2866,18 @@ func newAppState(sendMsg func(tea.Msg), lndLogLines *sloglinesbuffer.Buffer,
 					mediatorNick, pii.Identity)
 			})
 		},
+
+		RetentionPolicyChanged: func(user *client.RemoteUser, gcID zkidentity.ShortID, policy rpc.RetentionPolicy) {
+			var cw *chatWindow
+			if gcID.IsEmpty() {
+				cw = as.findOrNewChatWindow(user.ID(), user.Nick())
+			} else {
+				cw = as.findOrNewGCWindow(gcID)
+			}
+			cw.newInternalMsg(fmt.Sprintf("%s changed the retention policy to %s",
+				strescape.Nick(user.Nick()), policy))
+			as.repaintIfActive(cw)
+		},
 	}
 
 	var cmdHistoryFile *os.File
@@ -2651,6 +2938,7 @@ func newAppState(sendMsg func(tea.Msg), lndLogLines *sloglinesbuffer.Buffer,
 		ctx:         ctx,
 		cancel:      cancel,
 		c:           c,
+		db:          db,
 		sendMsg:     sendMsg,
 		logBknd:     logBknd,
 		log:         logBknd.logger("ZTUI"),
@@ -2684,6 +2972,7 @@ func newAppState(sendMsg func(tea.Msg), lndLogLines *sloglinesbuffer.Buffer,
 
 		remoteFiles: make(map[clientintf.UserID]map[clientdb.FileID]clientdb.RemoteFile),
 		progressMsg: make(map[clientdb.FileID]*chatMsg),
+		uploads:     make(map[ftUploadKey]*ftUploadProgress),
 
 		activeCW:  activeCWDiag,
 		updatedCW: make(map[int]bool),
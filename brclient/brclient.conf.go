@@ -16,6 +16,28 @@ root = {{ .Root }}
 # 0=no compression, 9=best compression (slowest).
 # compresslevel = 4
 
+# Encrypt the client db at rest with a passphrase. The passphrase is requested
+# every time the client starts. When enabling this on an existing db, the
+# passphrase entered on the next start is used to encrypt it. Once the db is
+# encrypted, it cannot be decrypted without the passphrase.
+# encryptdb = false
+
+# Storage driver for the client db. Either "fs" (one file per record) or
+# "sqlite" (a single database file, faster for large dbs). When switching an
+# existing db to sqlite, its data is imported on the next start.
+# dbdriver = fs
+
+# Download chunks of files from every user that shares a file with the same
+# content, instead of only from the user the download was started from. When
+# enabled, the hash of downloaded files is revealed to all users.
+# multisourcedownloads = false
+
+# Split shared files into chunks with boundaries defined by their contents,
+# instead of fixed size chunks. Users that downloaded a previous version of a
+# file only need to download (and pay for) the chunks that changed. Files
+# shared this way cannot be downloaded by older clients.
+# contentdefinedchunking = false
+
 # Proxy Configuration. Also needed for accessing the server as a TOR hidden
 # service.
 # proxyaddr =
@@ -30,8 +52,9 @@ root = {{ .Root }}
 # mimetype=text/*,vi
 
 # Bell Command: executed on incoming msgs. *BEEP* outputs the terminal BEL.
-# In arguments, '$src' is replaced with the alias of the sender or GC. '$msg' is
-# replaced with the message. Some examples.
+# Messages in GCs only run the command according to the GC notify mode (see
+# /gc notify). In arguments, '$src' is replaced with the alias of the sender or
+# GC. '$msg' is replaced with the message. Some examples.
 #
 # Ring the terminal BEL.
 # bellcmd = *BEEP*
@@ -2,11 +2,14 @@ package main
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/companyzero/bisonrelay/client/clientdb"
 	"github.com/companyzero/bisonrelay/client/clientintf"
+	"github.com/companyzero/bisonrelay/internal/strescape"
 	"github.com/companyzero/bisonrelay/rpc"
 	"github.com/companyzero/bisonrelay/zkidentity"
 	"github.com/muesli/reflow/wordwrap"
@@ -21,6 +24,26 @@ type chatMsg struct {
 	help     bool
 	from     string
 	post     *rpc.PostMetadata
+
+	// id is the id of a PM or GC message. For PMs sent by the local
+	// client, receipt is the last status reported by the remote user. For
+	// received messages, read is true once they were marked as read.
+	id      zkidentity.ShortID
+	receipt rpc.ReceiptStatus
+	read    bool
+
+	// uid is the id of the sender of a received GC message.
+	uid clientintf.UserID
+
+	// replyTo is the id of the message this message replies to.
+	replyTo zkidentity.ShortID
+
+	edited    bool
+	retracted bool
+
+	// reactions are the reactions of the local and remote users to the
+	// message.
+	reactions clientdb.Reactions
 }
 
 type chatWindow struct {
@@ -50,11 +73,16 @@ func (cw *chatWindow) appendMsg(msg *chatMsg) {
 }
 
 func (cw *chatWindow) newUnsentPM(msg string) *chatMsg {
+	return cw.newUnsentReply(zkidentity.ShortID{}, msg)
+}
+
+func (cw *chatWindow) newUnsentReply(replyTo zkidentity.ShortID, msg string) *chatMsg {
 	m := &chatMsg{
-		mine: true,
-		msg:  msg,
-		ts:   time.Now(),
-		from: cw.me,
+		mine:    true,
+		msg:     msg,
+		ts:      time.Now(),
+		from:    cw.me,
+		replyTo: replyTo,
 	}
 	cw.appendMsg(m)
 	return m
@@ -86,12 +114,32 @@ func (cw *chatWindow) manyHelpMsgs(f func(printf)) {
 	cw.Unlock()
 }
 
-func (cw *chatWindow) newRecvdMsg(from, msg string, ts time.Time) *chatMsg {
+func (cw *chatWindow) newRecvdPM(from, msg string, id, replyTo zkidentity.ShortID,
+	ts time.Time) *chatMsg {
+
 	m := &chatMsg{
-		mine: false,
-		msg:  msg,
-		ts:   ts,
-		from: from,
+		mine:    false,
+		msg:     msg,
+		ts:      ts,
+		from:    from,
+		id:      id,
+		replyTo: replyTo,
+	}
+	cw.appendMsg(m)
+	return m
+}
+
+func (cw *chatWindow) newRecvdGCMsg(from string, uid clientintf.UserID, msg string,
+	id, replyTo zkidentity.ShortID, ts time.Time) *chatMsg {
+
+	m := &chatMsg{
+		mine:    false,
+		msg:     msg,
+		ts:      ts,
+		from:    from,
+		id:      id,
+		uid:     uid,
+		replyTo: replyTo,
 	}
 	cw.appendMsg(m)
 	return m
@@ -104,6 +152,205 @@ func (cw *chatWindow) setMsgSent(msg *chatMsg) {
 	cw.Unlock()
 }
 
+func (cw *chatWindow) setMsgSentWithID(msg *chatMsg, id zkidentity.ShortID) {
+	cw.Lock()
+	msg.sent = true
+	msg.id = id
+	cw.Unlock()
+}
+
+// setMsgReceipt updates the receipt status of the sent PM with the given id.
+// Returns true if the status of the msg was modified.
+func (cw *chatWindow) setMsgReceipt(id zkidentity.ShortID, status rpc.ReceiptStatus) bool {
+	cw.Lock()
+	defer cw.Unlock()
+	for i := len(cw.msgs) - 1; i >= 0; i-- {
+		msg := cw.msgs[i]
+		if !msg.mine || msg.id != id {
+			continue
+		}
+
+		// A read msg was also delivered.
+		if msg.receipt == rpc.ReceiptStatusRead {
+			return false
+		}
+		msg.receipt = status
+		return true
+	}
+	return false
+}
+
+// findMsg returns the message with the given id. If mine is false, the message
+// must have been received from uid. Must be called with the window locked.
+func (cw *chatWindow) findMsg(mine bool, uid clientintf.UserID, id zkidentity.ShortID) *chatMsg {
+	if id.IsEmpty() {
+		return nil
+	}
+	for i := len(cw.msgs) - 1; i >= 0; i-- {
+		msg := cw.msgs[i]
+		if msg.id != id || msg.mine != mine || msg.retracted {
+			continue
+		}
+		if !mine && cw.isGC && msg.uid != uid {
+			continue
+		}
+		return msg
+	}
+	return nil
+}
+
+// editMsg replaces the contents of the message with the given id. Returns true
+// if the message was found.
+func (cw *chatWindow) editMsg(mine bool, uid clientintf.UserID, id zkidentity.ShortID, text string) bool {
+	cw.Lock()
+	defer cw.Unlock()
+	msg := cw.findMsg(mine, uid, id)
+	if msg == nil {
+		return false
+	}
+	msg.msg = text
+	msg.edited = true
+	return true
+}
+
+// retractMsg removes the contents of the message with the given id. Returns
+// true if the message was found.
+func (cw *chatWindow) retractMsg(mine bool, uid clientintf.UserID, id zkidentity.ShortID) bool {
+	cw.Lock()
+	defer cw.Unlock()
+	msg := cw.findMsg(mine, uid, id)
+	if msg == nil {
+		return false
+	}
+	msg.msg = "(message retracted)"
+	msg.retracted = true
+	return true
+}
+
+// reactToMsg adds (or removes, if remove is true) the reaction of uid to the
+// message with the given id. Returns true if the message was found.
+func (cw *chatWindow) reactToMsg(id zkidentity.ShortID, uid clientintf.UserID,
+	reaction string, remove bool) bool {
+
+	cw.Lock()
+	defer cw.Unlock()
+	for i := len(cw.msgs) - 1; i >= 0; i-- {
+		msg := cw.msgs[i]
+		if msg.id != id || msg.retracted || msg.internal || msg.help {
+			continue
+		}
+		if msg.reactions == nil {
+			msg.reactions = make(clientdb.Reactions)
+		}
+		msg.reactions.Apply(uid, reaction, remove)
+		return true
+	}
+	return false
+}
+
+// formatReactions returns the reactions as a list of reactions followed by the
+// number of users that reacted with them, ordered by reaction.
+func formatReactions(reactions clientdb.Reactions) string {
+	keys := make([]string, 0, len(reactions))
+	for k, uids := range reactions {
+		if len(uids) > 0 {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for i, k := range keys {
+		if i > 0 {
+			b.WriteRune(' ')
+		}
+		fmt.Fprintf(&b, "%s %d", strescape.Content(k), len(reactions[k]))
+	}
+	return b.String()
+}
+
+// lastMsgIDFrom returns the id of the last message sent by the user with the
+// given nick that can be replied to.
+func (cw *chatWindow) lastMsgIDFrom(nick string) zkidentity.ShortID {
+	cw.Lock()
+	defer cw.Unlock()
+	for i := len(cw.msgs) - 1; i >= 0; i-- {
+		msg := cw.msgs[i]
+		if msg.from == nick && !msg.retracted && !msg.id.IsEmpty() {
+			return msg.id
+		}
+	}
+	return zkidentity.ShortID{}
+}
+
+// replyQuote returns the line that quotes the message replied to by msg. Must
+// be called with the window locked.
+func (cw *chatWindow) replyQuote(msg *chatMsg) string {
+	var quoted *chatMsg
+	for _, m := range cw.msgs {
+		if m.id == msg.replyTo && !m.internal && !m.help {
+			quoted = m
+			break
+		}
+	}
+	if quoted == nil {
+		return "┌ reply to an unknown message"
+	}
+
+	from := quoted.from
+	if quoted.mine {
+		from = cw.me
+	}
+	return fmt.Sprintf("┌ <%s> %s", from, quoteSnippet(quoted.msg))
+}
+
+// describeMsg returns a short description of the message with the given id,
+// suitable for listing messages.
+func (cw *chatWindow) describeMsg(id zkidentity.ShortID) string {
+	cw.Lock()
+	defer cw.Unlock()
+	for _, m := range cw.msgs {
+		if m.id != id || m.internal || m.help {
+			continue
+		}
+		from := m.from
+		if m.mine {
+			from = cw.me
+		}
+		return fmt.Sprintf("<%s> %s", from, quoteSnippet(m.msg))
+	}
+	return fmt.Sprintf("message %s (not in history)", id)
+}
+
+// lastSentMsgID returns the id of the last message sent by the local client
+// that can still be edited or retracted.
+func (cw *chatWindow) lastSentMsgID() zkidentity.ShortID {
+	cw.Lock()
+	defer cw.Unlock()
+	for i := len(cw.msgs) - 1; i >= 0; i-- {
+		msg := cw.msgs[i]
+		if msg.mine && msg.sent && !msg.retracted && !msg.id.IsEmpty() {
+			return msg.id
+		}
+	}
+	return zkidentity.ShortID{}
+}
+
+// unreadMsgs returns the ids of the received messages that were not marked as
+// read yet and marks them as read.
+func (cw *chatWindow) unreadMsgs() []zkidentity.ShortID {
+	var res []zkidentity.ShortID
+	cw.Lock()
+	for _, msg := range cw.msgs {
+		if msg.mine || msg.read || msg.id.IsEmpty() {
+			continue
+		}
+		msg.read = true
+		res = append(res, msg.id)
+	}
+	cw.Unlock()
+	return res
+}
+
 func (cw *chatWindow) renderPost(winW int, styles *theme, b *strings.Builder, msg *chatMsg) {
 	b.WriteString(styles.timestamp.Render(msg.ts.Format("15:04:05 ")))
 	b.WriteString("<")
@@ -132,6 +379,12 @@ func (cw *chatWindow) renderContent(winW int, styles *theme) string {
 			continue
 		}
 
+		if !msg.replyTo.IsEmpty() {
+			quote := "         " + cw.replyQuote(msg)
+			b.WriteString(styles.help.Render(quote))
+			b.WriteRune('\n')
+		}
+
 		prefix := styles.timestamp.Render(msg.ts.Format("15:04:05 "))
 		wrapW := winW
 		if msg.help {
@@ -151,7 +404,7 @@ func (cw *chatWindow) renderContent(winW int, styles *theme) string {
 		}
 
 		style := styles.msg
-		if msg.help {
+		if msg.help || msg.retracted {
 			style = styles.help
 		} else if (msg.mine || msg.internal) && !msg.sent {
 			style = styles.unsent
@@ -160,6 +413,18 @@ func (cw *chatWindow) renderContent(winW int, styles *theme) string {
 		// Render the entire msg. Needed because the prefix breaks
 		// styling in the first line when wrapping is needed.
 		renderedMsg := style.Render(msg.msg)
+		switch msg.receipt {
+		case rpc.ReceiptStatusDelivered:
+			renderedMsg += styles.timestamp.Render(" ✓")
+		case rpc.ReceiptStatusRead:
+			renderedMsg += styles.timestamp.Render(" ✓✓")
+		}
+		if msg.edited && !msg.retracted {
+			renderedMsg += styles.timestamp.Render(" (edited)")
+		}
+		if r := formatReactions(msg.reactions); r != "" && !msg.retracted {
+			renderedMsg += styles.timestamp.Render(" [" + r + "]")
+		}
 		lines := strings.Split(prefix+renderedMsg, "\n")
 		for _, line := range lines {
 			// Wrap on the window.
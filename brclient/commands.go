@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -39,6 +40,10 @@ type tuicmd struct {
 	// operations.
 	usableOffline bool
 
+	// secretArgs is set for commands that receive secrets (passphrases,
+	// etc) as arguments and that must not be stored in the history file.
+	secretArgs bool
+
 	handler    func(args []string, as *appState) error
 	rawHandler func(rawCmd string, args []string, as *appState) error
 	completer  func(prevArgs []string, arg string, as *appState) []string
@@ -491,6 +496,65 @@ var gcCommands = []tuicmd{
 			go as.inviteToGC(cw, args[1], uid)
 			return nil
 		},
+	}, {
+		cmd:           "invites",
+		usableOffline: true,
+		usage:         "<gc name>",
+		descr:         "List the outstanding invites to join the given gc",
+		long: []string{
+			"Only invites sent by the local client that have not been accepted and have not expired are listed.",
+		},
+		handler: func(args []string, as *appState) error {
+			if len(args) < 1 {
+				return usageError{"gc name cannot be empty"}
+			}
+			gcID, err := as.c.GCIDByName(args[0])
+			if err != nil {
+				return err
+			}
+			invites, err := as.c.ListSentGCInvites(gcID)
+			if err != nil {
+				return err
+			}
+			as.cwHelpMsgs(func(pf printf) {
+				if len(invites) == 0 {
+					pf("No outstanding invites for GC %q", args[0])
+					return
+				}
+				pf("Outstanding invites for GC %q", args[0])
+				for _, inv := range invites {
+					nick, _ := as.c.UserNick(inv.User)
+					pf("%s %q - expires %s", inv.User,
+						strescape.Nick(nick),
+						time.Unix(inv.Invite.Expires, 0).Format(ISO8601DateTime))
+				}
+			})
+			return nil
+		},
+	}, {
+		cmd:   "revokeinvite",
+		usage: "<gc name> <nick>",
+		descr: "Revoke the outstanding invites for the user to join the given gc",
+		handler: func(args []string, as *appState) error {
+			if len(args) < 2 {
+				return usageError{"gc name and nick must be specified"}
+			}
+			gcID, err := as.c.GCIDByName(args[0])
+			if err != nil {
+				return err
+			}
+			uid, err := as.c.UIDByNick(args[1])
+			if err != nil {
+				return err
+			}
+			if err := as.c.RevokeGCInvite(gcID, uid); err != nil {
+				return err
+			}
+			cw := as.findOrNewGCWindow(gcID)
+			cw.newInternalMsg(fmt.Sprintf("Revoked invite to %s", args[1]))
+			as.repaintIfActive(cw)
+			return nil
+		},
 	},
 	{
 		cmd:     "msg",
@@ -797,21 +861,21 @@ var gcCommands = []tuicmd{
 			return nil
 		},
 	}, {
-		cmd:           "ignore",
-		usableOffline: true,
-		usage:         "<gc> <user>",
-		descr:         "Ignore a user's messages in this specific GC",
+		cmd:   "addadmin",
+		usage: "<gc> <nick> [mod]",
+		descr: "Make the given user an admin (or moderator) of the specified GC",
 		long: []string{
-			"This also stops sending messages to the specified user in this GC",
+			"Admins may invite and kick members. Moderators (when 'mod' is specified) may only kick members that are not admins or moderators.",
+			"Only the owner of the GC may add admins.",
 		},
 		handler: func(args []string, as *appState) error {
 			if len(args) < 1 {
 				return usageError{msg: "gc name cannot be empty"}
 			}
 			if len(args) < 2 {
-				return usageError{msg: "user cannot be empty"}
+				return usageError{msg: "nick cannot be empty"}
 			}
-
+			moderator := len(args) > 2 && args[2] == "mod"
 			gcID, err := as.c.GCIDByName(args[0])
 			if err != nil {
 				return err
@@ -820,28 +884,28 @@ var gcCommands = []tuicmd{
 			if err != nil {
 				return err
 			}
-
 			gcWin := as.findOrNewGCWindow(gcID)
-			if err := as.c.AddToGCBlockList(gcID, uid); err != nil {
-				return err
+			role := "an admin"
+			if moderator {
+				role = "a moderator"
 			}
-			gcWin.newInternalMsg(fmt.Sprintf("Ignored user %s in GC", args[1]))
-			as.repaintIfActive(gcWin)
+			go as.modifyGCAdmins(gcWin, fmt.Sprintf("Made %s %s of the GC",
+				args[1], role), func() error {
+				return as.c.AddGCAdmin(gcID, uid, moderator)
+			})
 			return nil
 		},
 	}, {
-		cmd:           "unignore",
-		usableOffline: true,
-		usage:         "<gc> <user>",
-		descr:         "Un-ignore a user's messages in this specific GC",
+		cmd:   "removeadmin",
+		usage: "<gc> <nick>",
+		descr: "Remove the admin or moderator role of the given user in the specified GC",
 		handler: func(args []string, as *appState) error {
 			if len(args) < 1 {
 				return usageError{msg: "gc name cannot be empty"}
 			}
 			if len(args) < 2 {
-				return usageError{msg: "user cannot be empty"}
+				return usageError{msg: "nick cannot be empty"}
 			}
-
 			gcID, err := as.c.GCIDByName(args[0])
 			if err != nil {
 				return err
@@ -850,213 +914,1155 @@ var gcCommands = []tuicmd{
 			if err != nil {
 				return err
 			}
-
 			gcWin := as.findOrNewGCWindow(gcID)
-			if err := as.c.RemoveFromGCBlockList(gcID, uid); err != nil {
-				return err
-			}
-			gcWin.newInternalMsg(fmt.Sprintf("Un-ignored user %s in GC", args[1]))
-			as.repaintIfActive(gcWin)
+			go as.modifyGCAdmins(gcWin, fmt.Sprintf("Removed admin role of %s",
+				args[1]), func() error {
+				return as.c.RemoveGCAdmin(gcID, uid)
+			})
 			return nil
 		},
 	}, {
-		cmd:           "alias",
-		usableOffline: true,
-		usage:         "<existing gc> <new alias>",
-		descr:         "Modify the local alias of a GC",
+		cmd:   "transferowner",
+		usage: "<gc> <nick>",
+		descr: "Make the given user the owner of the specified GC",
+		long: []string{
+			"The local client remains an admin of the GC after the transfer.",
+		},
 		handler: func(args []string, as *appState) error {
 			if len(args) < 1 {
-				return usageError{msg: "existing gc name cannot be empty"}
+				return usageError{msg: "gc name cannot be empty"}
 			}
 			if len(args) < 2 {
-				return usageError{msg: "new gc alias cannot be empty"}
+				return usageError{msg: "nick cannot be empty"}
 			}
-
 			gcID, err := as.c.GCIDByName(args[0])
 			if err != nil {
 				return err
 			}
-			newAlias := args[1]
-			if err := as.c.AliasGC(gcID, newAlias); err != nil {
+			uid, err := as.c.UIDByNick(args[1])
+			if err != nil {
 				return err
 			}
-
 			gcWin := as.findOrNewGCWindow(gcID)
-			gcWin.newInternalMsg(fmt.Sprintf("Renamed GC to %s", newAlias))
-			gcWin.alias = newAlias // FIXME: this is racing.
-			as.repaintIfActive(gcWin)
+			go as.modifyGCAdmins(gcWin, fmt.Sprintf("Transferred ownership "+
+				"of the GC to %s", args[1]), func() error {
+				return as.c.TransferGCOwnership(gcID, uid)
+			})
 			return nil
 		},
-	},
-}
-
-var ftCommands = []tuicmd{
-	{
-		cmd:           "share",
-		usableOffline: true,
-		usage:         "<filename> <cost> [<nick>]",
-		descr:         "Share the given file for distribution",
+	}, {
+		cmd:   "resync",
+		usage: "<gc>",
+		descr: "Resync the list of members of the specified GC",
 		long: []string{
-			"Imports the passed file into the local FTP repository. The cost is specified in DCR.",
-			"If a nick or user ID is specified, the file is shared only to that user.",
-			"By default, the passed cost is *added* to the estimated upload cost. Use \"=<amount>\" to directly specify the full cost",
-		},
-		completer: func(args []string, arg string, as *appState) []string {
-			return fileCompleter(arg)
+			"When the local client is an admin of the GC, the local list is sent to all members. Otherwise, the current list is requested from the owner of the GC.",
 		},
 		handler: func(args []string, as *appState) error {
 			if len(args) < 1 {
-				return usageError{msg: "filename cannot be empty"}
-			}
-			if len(args) < 2 {
-				return usageError{msg: "cost cannot be empty"}
-			}
-			if len(args[1]) < 1 {
-				return usageError{msg: "cost cannot be the empty string"}
-			}
-
-			filename, err := homedir.Expand(args[0])
-			if err != nil {
-				return err
+				return usageError{msg: "gc name cannot be empty"}
 			}
-			stat, err := os.Stat(filename)
+			gcID, err := as.c.GCIDByName(args[0])
 			if err != nil {
 				return err
 			}
-
-			var dcrCost, dcrUploadCost float64
-			// Figure out upload cost.
-			feeRate, _ := as.serverPaymentRates()
-			size := stat.Size()
-			uploadCost, err := clientintf.EstimateUploadCost(size, feeRate)
-			if err != nil {
+			if err := as.c.ResyncGC(gcID); err != nil {
 				return err
 			}
-			dcrUploadCost = float64(uploadCost) / 1e11
-
-			if args[1][0] == '=' {
-				// Exact cost specified.
-				dcrCost, err = strconv.ParseFloat(args[1][1:], 64)
-				if err != nil {
-					return err
-				}
-			} else {
-				// Upload cost + overcharge
-				dcrCost, err = strconv.ParseFloat(args[1], 64)
-				if err != nil {
-					return err
-				}
-				dcrCost += dcrUploadCost
-			}
-
-			var uid *clientintf.UserID
-			with := ""
-			if len(args) > 2 {
-				id, err := as.c.UIDByNick(args[2])
-				if err != nil {
-					return err
-				}
-				uid = &id
-				with = fmt.Sprintf(" with %q", args[2])
-			}
-			atomCost := uint64(dcrCost * 1e8)
-			sf, _, err := as.c.ShareFile(filename, uid, atomCost, false, "")
-			as.cwHelpMsg("Shared file %q for %.8f DCR (est. cost %.8f DCR)%s. FID: %s",
-				sf.Filename, dcrCost, dcrUploadCost, with,
-				sf.FID)
-			return err
-
+			gcWin := as.findOrNewGCWindow(gcID)
+			gcWin.newInternalMsg("Resyncing list of GC members")
+			as.repaintIfActive(gcWin)
+			return nil
 		},
 	}, {
-		cmd:           "list",
-		usableOffline: true,
-		aliases:       []string{"ls", "l"},
-		usage:         "<nick> <*|shared|global> [<filename_regex>]",
-		descr:         "List files of a remote peer",
+		cmd:   "meshkx",
+		usage: "<gc>",
+		descr: "KX with all members of the GC that are not KX'd yet",
+		long: []string{
+			"The KX is mediated by the admins of the GC. Members with a recent KX attempt are skipped.",
+			"This is done automatically when joining GCs and when the list of members is updated.",
+		},
 		handler: func(args []string, as *appState) error {
 			if len(args) < 1 {
-				return usageError{msg: "nick cannot be empty"}
-			}
-			if len(args) < 2 {
-				return usageError{msg: "dir cannot be empty"}
+				return usageError{msg: "gc name cannot be empty"}
 			}
-
-			uid, err := as.c.UIDByNick(args[0])
+			gcID, err := as.c.GCIDByName(args[0])
 			if err != nil {
 				return err
 			}
-
-			cw := as.findOrNewChatWindow(uid, args[0])
-			var filter string
-			if len(args) > 2 {
-				filter = args[2]
-			}
-			go as.listUserContent(cw, args[1], filter)
+			go func() {
+				err := as.c.MeshKXGC(gcID)
+				if err != nil {
+					as.diagMsg("Unable to KX with GC members: %v", err)
+				}
+			}()
 			return nil
 		},
 	}, {
-		cmd:   "get",
-		usage: "<nick> [<filename> | <FID>]",
-		descr: "Fetch the given file from the remote peer",
+		cmd:   "createlink",
+		usage: "<gc> <filename> [<hours>] [approve]",
+		descr: "Create a link that allows anyone to join the GC",
 		long: []string{
-			"The file can be referenced either as a filename (in which case the local client must have had a /ft ls issued first) or a full file ID.",
-			"If the file requires payment, the remote peer will send an invoice that will be automatically paid before actually receiving the file's contents",
+			"The link is written to the specified file and expires after the given number of hours (default 24). Anyone that has the link may redeem it with /gc redeemlink, even if they have not performed KX with the local client.",
+			"When 'approve' is specified, requests to join the GC must be approved with /gc approvejoin.",
 		},
-		handler: func(args []string, as *appState) error {
-			if len(args) < 1 {
-				return usageError{msg: "nick cannot be empty"}
+		completer: func(args []string, arg string, as *appState) []string {
+			if len(args) == 1 {
+				return fileCompleter(arg)
 			}
+			return nil
+		},
+		handler: func(args []string, as *appState) error {
 			if len(args) < 2 {
-				return usageError{msg: "filename cannot be empty"}
+				return usageError{msg: "gc name and filename must be specified"}
 			}
-
-			uid, err := as.c.UIDByNick(args[0])
+			gcID, err := as.c.GCIDByName(args[0])
+			if err != nil {
+				return err
+			}
+			filename, err := homedir.Expand(args[1])
 			if err != nil {
 				return err
 			}
+			hours := 24
+			if len(args) > 2 {
+				hours, err = strconv.Atoi(args[2])
+				if err != nil || hours <= 0 {
+					return usageError{msg: "hours must be a positive number"}
+				}
+			}
+			needsApproval := len(args) > 3 && args[3] == "approve"
 
-			cw := as.findOrNewChatWindow(uid, args[0])
-			go as.getUserContent(cw, args[1])
+			w := new(bytes.Buffer)
+			expiration := time.Duration(hours) * time.Hour
+			link, err := as.c.CreateGCJoinLink(gcID, expiration, 10,
+				needsApproval, w)
+			if err != nil {
+				return err
+			}
+			if err := os.WriteFile(filename, w.Bytes(), 0o600); err != nil {
+				return err
+			}
+			gcWin := as.findOrNewGCWindow(gcID)
+			gcWin.newInternalMsg(fmt.Sprintf("Created join link at %q "+
+				"(expires %s)", filename, time.Unix(link.Expires, 0).Format(ISO8601DateTime)))
+			as.repaintIfActive(gcWin)
 			return nil
 		},
 	}, {
-		cmd:           "estimatecost",
-		usableOffline: true,
-		aliases:       []string{"estcost"},
-		descr:         "Estimate cost of uploading the specified file",
-		usage:         "<filepath>",
+		cmd:   "redeemlink",
+		usage: "<filename>",
+		descr: "Redeem a GC join link",
+		long: []string{
+			"This performs KX with the admin that created the link if needed and joins the GC after the admin sends the invitation.",
+		},
 		completer: func(args []string, arg string, as *appState) []string {
 			return fileCompleter(arg)
 		},
 		handler: func(args []string, as *appState) error {
 			if len(args) < 1 {
-				return usageError{msg: "file path cannot be empty"}
+				return usageError{msg: "filename must be specified"}
 			}
-
-			fpath := args[0]
-			stat, err := os.Stat(fpath)
+			filename, err := homedir.Expand(args[0])
 			if err != nil {
 				return err
 			}
-
-			feeRate, _ := as.serverPaymentRates()
-			size := stat.Size()
-			cost, err := clientintf.EstimateUploadCost(size, feeRate)
+			f, err := os.Open(filename)
 			if err != nil {
 				return err
 			}
-			as.cwHelpMsg("Cost to upload file (%d B): %s", size,
-				dcrutil.Amount(cost/1e3))
-
-			return nil
-		},
-	}, {
-		cmd:           "unshare",
-		usableOffline: true,
-		usage:         "<file> [<user>]",
-		descr:         "Unshare a file",
-		handler: func(args []string, as *appState) error {
-			if len(args) < 1 {
+			defer f.Close()
+			link, err := as.c.ReadGCJoinLink(f)
+			if err != nil {
+				return err
+			}
+			as.cwHelpMsgs(func(pf printf) {
+				pf("")
+				pf("Requesting to join GC %q", strescape.Nick(link.Name))
+				pf("Admin: %q (%s)", strescape.Nick(link.Admin.Nick),
+					link.Admin.Identity)
+				if link.NeedsApproval {
+					pf("The admin must approve the request")
+				}
+			})
+			go func() {
+				err := as.c.RedeemGCJoinLink(link)
+				if err != nil {
+					as.cwHelpMsg("Unable to redeem join link: %v", err)
+				}
+			}()
+			return nil
+		},
+	}, {
+		cmd:           "joinrequests",
+		usableOffline: true,
+		descr:         "List requests to join GCs that need approval",
+		handler: func(args []string, as *appState) error {
+			reqs, err := as.c.ListGCJoinRequests()
+			if err != nil {
+				return err
+			}
+			as.cwHelpMsgs(func(pf printf) {
+				pf("")
+				pf("GC join requests")
+				for _, req := range reqs {
+					gcName, _ := as.c.GetGCAlias(req.GC)
+					pf("%s %q (%s) - %s", req.Received.Format(ISO8601DateTime),
+						strescape.Nick(req.Invite.Public.Nick),
+						req.Invite.Public.Identity, gcName)
+				}
+			})
+			return nil
+		},
+	}, {
+		cmd:   "approvejoin",
+		usage: "<user id>",
+		descr: "Approve a request to join a GC made through a join link",
+		handler: func(args []string, as *appState) error {
+			if len(args) < 1 {
+				return usageError{msg: "user id must be specified"}
+			}
+			var uid clientintf.UserID
+			if err := uid.FromString(args[0]); err != nil {
+				return err
+			}
+			go func() {
+				err := as.c.ApproveGCJoinRequest(uid)
+				if err != nil {
+					as.cwHelpMsg("Unable to approve join request: %v", err)
+				}
+			}()
+			return nil
+		},
+	}, {
+		cmd:           "rejectjoin",
+		usableOffline: true,
+		usage:         "<user id>",
+		descr:         "Reject a request to join a GC made through a join link",
+		handler: func(args []string, as *appState) error {
+			if len(args) < 1 {
+				return usageError{msg: "user id must be specified"}
+			}
+			var uid clientintf.UserID
+			if err := uid.FromString(args[0]); err != nil {
+				return err
+			}
+			if err := as.c.RejectGCJoinRequest(uid); err != nil {
+				return err
+			}
+			as.cwHelpMsg("Rejected join request from %s", uid)
+			return nil
+		},
+	}, {
+		cmd:           "ignore",
+		usableOffline: true,
+		usage:         "<gc> <user>",
+		descr:         "Ignore a user's messages in this specific GC",
+		long: []string{
+			"This also stops sending messages to the specified user in this GC",
+		},
+		handler: func(args []string, as *appState) error {
+			if len(args) < 1 {
+				return usageError{msg: "gc name cannot be empty"}
+			}
+			if len(args) < 2 {
+				return usageError{msg: "user cannot be empty"}
+			}
+
+			gcID, err := as.c.GCIDByName(args[0])
+			if err != nil {
+				return err
+			}
+			uid, err := as.c.UIDByNick(args[1])
+			if err != nil {
+				return err
+			}
+
+			gcWin := as.findOrNewGCWindow(gcID)
+			if err := as.c.AddToGCBlockList(gcID, uid); err != nil {
+				return err
+			}
+			gcWin.newInternalMsg(fmt.Sprintf("Ignored user %s in GC", args[1]))
+			as.repaintIfActive(gcWin)
+			return nil
+		},
+	}, {
+		cmd:           "unignore",
+		usableOffline: true,
+		usage:         "<gc> <user>",
+		descr:         "Un-ignore a user's messages in this specific GC",
+		handler: func(args []string, as *appState) error {
+			if len(args) < 1 {
+				return usageError{msg: "gc name cannot be empty"}
+			}
+			if len(args) < 2 {
+				return usageError{msg: "user cannot be empty"}
+			}
+
+			gcID, err := as.c.GCIDByName(args[0])
+			if err != nil {
+				return err
+			}
+			uid, err := as.c.UIDByNick(args[1])
+			if err != nil {
+				return err
+			}
+
+			gcWin := as.findOrNewGCWindow(gcID)
+			if err := as.c.RemoveFromGCBlockList(gcID, uid); err != nil {
+				return err
+			}
+			gcWin.newInternalMsg(fmt.Sprintf("Un-ignored user %s in GC", args[1]))
+			as.repaintIfActive(gcWin)
+			return nil
+		},
+	}, {
+		cmd:           "alias",
+		usableOffline: true,
+		usage:         "<existing gc> <new alias>",
+		descr:         "Modify the local alias of a GC",
+		handler: func(args []string, as *appState) error {
+			if len(args) < 1 {
+				return usageError{msg: "existing gc name cannot be empty"}
+			}
+			if len(args) < 2 {
+				return usageError{msg: "new gc alias cannot be empty"}
+			}
+
+			gcID, err := as.c.GCIDByName(args[0])
+			if err != nil {
+				return err
+			}
+			newAlias := args[1]
+			if err := as.c.AliasGC(gcID, newAlias); err != nil {
+				return err
+			}
+
+			gcWin := as.findOrNewGCWindow(gcID)
+			gcWin.newInternalMsg(fmt.Sprintf("Renamed GC to %s", newAlias))
+			gcWin.alias = newAlias // FIXME: this is racing.
+			as.repaintIfActive(gcWin)
+			return nil
+		},
+	}, {
+		cmd:           "notify",
+		usableOffline: true,
+		usage:         "<gc> [all | mentions | muted]",
+		descr:         "Show or modify the notification policy of a GC",
+		long: []string{
+			"With 'all', every message received in the GC runs the bell command. With 'mentions', only messages that mention the local user do. With 'muted', no messages do.",
+			"Mention GC members in messages with @nick.",
+		},
+		handler: func(args []string, as *appState) error {
+			if len(args) < 1 {
+				return usageError{msg: "gc name cannot be empty"}
+			}
+			gcID, err := as.c.GCIDByName(args[0])
+			if err != nil {
+				return err
+			}
+			if len(args) < 2 {
+				mode, err := as.c.GetGCNotifyMode(gcID)
+				if err != nil {
+					return err
+				}
+				as.cwHelpMsg("Notify mode of GC %s: %s", args[0], mode)
+				return nil
+			}
+
+			mode := clientdb.GCNotifyMode(args[1])
+			if !mode.IsValid() {
+				return usageError{msg: fmt.Sprintf("invalid notify mode %q", args[1])}
+			}
+			if err := as.c.SetGCNotifyMode(gcID, mode); err != nil {
+				return err
+			}
+			as.cwHelpMsg("Set notify mode of GC %s to %s", args[0], mode)
+			return nil
+		},
+	}, {
+		cmd:           "topic",
+		usableOffline: true,
+		usage:         "<gc> [<topic>]",
+		descr:         "Show or modify the topic of a GC",
+		long: []string{
+			"Only GC admins may modify the topic, which is then sent to all GC members.",
+		},
+		handler: func(args []string, as *appState) error {
+			if len(args) < 1 {
+				return usageError{msg: "gc name cannot be empty"}
+			}
+			gcID, err := as.c.GCIDByName(args[0])
+			if err != nil {
+				return err
+			}
+			if len(args) < 2 {
+				md, err := as.c.GetGCMetadata(gcID)
+				if err != nil {
+					return err
+				}
+				as.cwHelpMsgs(func(pf printf) {
+					pf("Topic of GC %s: %s", args[0],
+						strescape.Content(md.Topic))
+					if md.Description != "" {
+						pf("Description: %s",
+							strescape.Content(md.Description))
+					}
+					if md.Rules != "" {
+						pf("Rules: %s", strescape.Content(md.Rules))
+					}
+				})
+				return nil
+			}
+
+			topic := strings.Join(args[1:], " ")
+			if err := as.c.SetGCTopic(gcID, topic); err != nil {
+				return err
+			}
+			gcWin := as.findOrNewGCWindow(gcID)
+			gcWin.newInternalMsg(fmt.Sprintf("Changed topic to %q", topic))
+			as.repaintIfActive(gcWin)
+			return nil
+		},
+	}, {
+		cmd:   "pin",
+		usage: "<gc> [<nick>]",
+		descr: "List pinned messages or pin the last message sent by nick in a GC",
+		long: []string{
+			"Only GC admins may pin messages. Use your own nick to pin your last message.",
+		},
+		handler: func(args []string, as *appState) error {
+			if len(args) < 1 {
+				return usageError{msg: "gc name cannot be empty"}
+			}
+			gcID, err := as.c.GCIDByName(args[0])
+			if err != nil {
+				return err
+			}
+			gcWin := as.findOrNewGCWindow(gcID)
+			if len(args) < 2 {
+				md, err := as.c.GetGCMetadata(gcID)
+				if err != nil {
+					return err
+				}
+				as.cwHelpMsgs(func(pf printf) {
+					pf("Pinned messages of GC %s", args[0])
+					for i, id := range md.Pinned {
+						pf("%d. %s", i, gcWin.describeMsg(id))
+					}
+				})
+				return nil
+			}
+
+			id := gcWin.lastMsgIDFrom(args[1])
+			if id.IsEmpty() {
+				return fmt.Errorf("no message from %q to pin", args[1])
+			}
+			if err := as.c.PinGCMessage(gcID, id, true); err != nil {
+				return err
+			}
+			gcWin.newInternalMsg(fmt.Sprintf("Pinned %s", gcWin.describeMsg(id)))
+			as.repaintIfActive(gcWin)
+			return nil
+		},
+	}, {
+		cmd:   "unpin",
+		usage: "<gc> <index>",
+		descr: "Unpin a message of a GC",
+		long: []string{
+			"The index is the one listed in /gc pin <gc>.",
+		},
+		handler: func(args []string, as *appState) error {
+			if len(args) < 2 {
+				return usageError{msg: "gc name and index must be specified"}
+			}
+			gcID, err := as.c.GCIDByName(args[0])
+			if err != nil {
+				return err
+			}
+			idx, err := strconv.Atoi(args[1])
+			if err != nil {
+				return usageError{msg: fmt.Sprintf("invalid index: %v", err)}
+			}
+			md, err := as.c.GetGCMetadata(gcID)
+			if err != nil {
+				return err
+			}
+			if idx < 0 || idx >= len(md.Pinned) {
+				return fmt.Errorf("no pinned message with index %d", idx)
+			}
+			id := md.Pinned[idx]
+			if err := as.c.PinGCMessage(gcID, id, false); err != nil {
+				return err
+			}
+			gcWin := as.findOrNewGCWindow(gcID)
+			gcWin.newInternalMsg(fmt.Sprintf("Unpinned %s", gcWin.describeMsg(id)))
+			as.repaintIfActive(gcWin)
+			return nil
+		},
+	}, {
+		cmd:           "retention",
+		usableOffline: true,
+		usage:         "<gc> [forever | afterread | <n>d]",
+		descr:         "Show or modify the message retention policy of a GC",
+		long: []string{
+			"Only GC admins may modify the policy, which is then honored by all GC members.",
+			"With afterread, messages are not stored after they are displayed. With <n>d, messages are removed after n days.",
+		},
+		handler: func(args []string, as *appState) error {
+			if len(args) < 1 {
+				return usageError{msg: "gc name cannot be empty"}
+			}
+			gcID, err := as.c.GCIDByName(args[0])
+			if err != nil {
+				return err
+			}
+			if len(args) < 2 {
+				gc, err := as.c.GetGC(gcID)
+				if err != nil {
+					return err
+				}
+				as.cwHelpMsg("Retention policy of GC %s: %s",
+					args[0], gc.Retention)
+				return nil
+			}
+
+			policy, err := parseRetentionPolicy(args[1])
+			if err != nil {
+				return usageError{msg: err.Error()}
+			}
+			if err := as.c.SetGCRetention(gcID, policy); err != nil {
+				return err
+			}
+			gcWin := as.findOrNewGCWindow(gcID)
+			gcWin.newInternalMsg(fmt.Sprintf("Changed retention policy to %s", policy))
+			as.repaintIfActive(gcWin)
+			return nil
+		},
+	},
+}
+
+var ftCommands = []tuicmd{
+	{
+		cmd:           "share",
+		usableOffline: true,
+		usage:         "<filename> <cost> [<nick>]",
+		descr:         "Share the given file for distribution",
+		long: []string{
+			"Imports the passed file into the local FTP repository. The cost is specified in DCR.",
+			"If a nick or user ID is specified, the file is shared only to that user.",
+			"By default, the passed cost is *added* to the estimated upload cost. Use \"=<amount>\" to directly specify the full cost",
+		},
+		completer: func(args []string, arg string, as *appState) []string {
+			return fileCompleter(arg)
+		},
+		handler: func(args []string, as *appState) error {
+			if len(args) < 1 {
+				return usageError{msg: "filename cannot be empty"}
+			}
+			if len(args) < 2 {
+				return usageError{msg: "cost cannot be empty"}
+			}
+			if len(args[1]) < 1 {
+				return usageError{msg: "cost cannot be the empty string"}
+			}
+
+			filename, err := homedir.Expand(args[0])
+			if err != nil {
+				return err
+			}
+			stat, err := os.Stat(filename)
+			if err != nil {
+				return err
+			}
+
+			var dcrCost, dcrUploadCost float64
+			// Figure out upload cost.
+			feeRate, _ := as.serverPaymentRates()
+			size := stat.Size()
+			uploadCost, err := clientintf.EstimateUploadCost(size, feeRate)
+			if err != nil {
+				return err
+			}
+			dcrUploadCost = float64(uploadCost) / 1e11
+
+			if args[1][0] == '=' {
+				// Exact cost specified.
+				dcrCost, err = strconv.ParseFloat(args[1][1:], 64)
+				if err != nil {
+					return err
+				}
+			} else {
+				// Upload cost + overcharge
+				dcrCost, err = strconv.ParseFloat(args[1], 64)
+				if err != nil {
+					return err
+				}
+				dcrCost += dcrUploadCost
+			}
+
+			var uid *clientintf.UserID
+			with := ""
+			if len(args) > 2 {
+				id, err := as.c.UIDByNick(args[2])
+				if err != nil {
+					return err
+				}
+				uid = &id
+				with = fmt.Sprintf(" with %q", args[2])
+			}
+			atomCost := uint64(dcrCost * 1e8)
+			sf, _, err := as.c.ShareFile(filename, uid, atomCost, false, "")
+			as.cwHelpMsg("Shared file %q for %.8f DCR (est. cost %.8f DCR)%s. FID: %s",
+				sf.Filename, dcrCost, dcrUploadCost, with,
+				sf.FID)
+			return err
+
+		},
+	}, {
+		cmd:           "list",
+		usableOffline: true,
+		aliases:       []string{"ls", "l"},
+		usage:         "<nick> <*|shared|global> [<filename_regex>]",
+		descr:         "List files of a remote peer",
+		handler: func(args []string, as *appState) error {
+			if len(args) < 1 {
+				return usageError{msg: "nick cannot be empty"}
+			}
+			if len(args) < 2 {
+				return usageError{msg: "dir cannot be empty"}
+			}
+
+			uid, err := as.c.UIDByNick(args[0])
+			if err != nil {
+				return err
+			}
+
+			cw := as.findOrNewChatWindow(uid, args[0])
+			var filter string
+			if len(args) > 2 {
+				filter = args[2]
+			}
+			go as.listUserContent(cw, args[1], filter)
+			return nil
+		},
+	}, {
+		cmd:   "get",
+		usage: "<nick> [<filename> | <FID>]",
+		descr: "Fetch the given file from the remote peer",
+		long: []string{
+			"The file can be referenced either as a filename (in which case the local client must have had a /ft ls issued first) or a full file ID.",
+			"If the file requires payment, the remote peer will send an invoice that will be automatically paid before actually receiving the file's contents",
+		},
+		handler: func(args []string, as *appState) error {
+			if len(args) < 1 {
+				return usageError{msg: "nick cannot be empty"}
+			}
+			if len(args) < 2 {
+				return usageError{msg: "filename cannot be empty"}
+			}
+
+			uid, err := as.c.UIDByNick(args[0])
+			if err != nil {
+				return err
+			}
+
+			cw := as.findOrNewChatWindow(uid, args[0])
+			go as.getUserContent(cw, args[1])
+			return nil
+		},
+	}, {
+		cmd:   "sources",
+		usage: "<FID>",
+		descr: "Look for other users that share the file being downloaded",
+		long: []string{
+			"Asks all users whether they share a file with the same contents as the given in-progress download. Chunks of the file are then also downloaded from the users that have it.",
+			"Requires the multisourcedownloads config option to be enabled. Note that this reveals the hash of the file to all users.",
+		},
+		handler: func(args []string, as *appState) error {
+			if len(args) < 1 {
+				return usageError{msg: "FID cannot be empty"}
+			}
+			var fid clientdb.FileID
+			if err := fid.FromString(args[0]); err != nil {
+				return err
+			}
+			if err := as.c.FindFileSources(fid); err != nil {
+				return err
+			}
+			as.cwHelpMsg("Looking for other sources of file %s", fid)
+			return nil
+		},
+	}, {
+		cmd:           "transfers",
+		usableOffline: true,
+		descr:         "List in-progress downloads and uploads",
+		handler: func(args []string, as *appState) error {
+			fds, err := as.c.ListDownloads()
+			if err != nil {
+				return err
+			}
+			cups, err := as.c.ListUploads()
+			if err != nil {
+				return err
+			}
+
+			// Group the outstanding chunk uploads by user and file.
+			pending := make(map[ftUploadKey]int)
+			var pendingKeys []ftUploadKey
+			for _, cup := range cups {
+				key := ftUploadKey{uid: cup.UID, fid: cup.FID}
+				if _, ok := pending[key]; !ok {
+					pendingKeys = append(pendingKeys, key)
+				}
+				pending[key]++
+			}
+
+			as.contentMtx.Lock()
+			uploads := make(map[ftUploadKey]ftUploadProgress, len(as.uploads))
+			for key, up := range as.uploads {
+				uploads[key] = *up
+				if _, ok := pending[key]; !ok {
+					pendingKeys = append(pendingKeys, key)
+				}
+			}
+			as.contentMtx.Unlock()
+
+			nickOrID := func(uid clientintf.UserID) string {
+				nick, _ := as.c.UserNick(uid)
+				if nick == "" {
+					return uid.String()
+				}
+				return strescape.Nick(nick)
+			}
+
+			as.cwHelpMsgs(func(pf printf) {
+				pf("")
+				pf("Downloads")
+				for _, fd := range fds {
+					prio, _ := as.c.GetTransferPriority(fd.FID)
+					status := "active"
+					if fd.Paused {
+						status = "paused"
+					}
+					pf("%s - %s (%s, priority %s)", fd.FID,
+						nickOrID(fd.UID), status, prio)
+					if fd.Metadata == nil {
+						pf("  (no data)")
+						continue
+					}
+					downChunks := fd.CountChunks(clientdb.ChunkStateDownloaded)
+					totalChunks := len(fd.Metadata.Manifest)
+					progress := float64(downChunks) / float64(totalChunks) * 100
+					pf("  Filename: %q", fd.Metadata.Filename)
+					pf("  Progress: %.2f (%d/%d)", progress,
+						downChunks, totalChunks)
+					for _, src := range fd.Sources {
+						pf("  Source: %s", nickOrID(src.UID))
+					}
+				}
+
+				pf("")
+				pf("Uploads")
+				for _, key := range pendingKeys {
+					prio, _ := as.c.GetTransferPriority(key.fid)
+					pf("%s - %s (priority %s)", key.fid,
+						nickOrID(key.uid), prio)
+					if up, ok := uploads[key]; ok {
+						totalChunks := len(up.fm.Manifest)
+						progress := float64(up.sentChunks) / float64(totalChunks) * 100
+						pf("  Filename: %q", up.fm.Filename)
+						pf("  Progress: %.2f (%d/%d)", progress,
+							up.sentChunks, totalChunks)
+					}
+					if n := pending[key]; n > 0 {
+						pf("  Outstanding chunks: %d", n)
+					}
+				}
+			})
+			return nil
+		},
+	}, {
+		cmd:   "pause",
+		usage: "<FID>",
+		descr: "Pause an in-progress download",
+		handler: func(args []string, as *appState) error {
+			if len(args) < 1 {
+				return usageError{msg: "FID cannot be empty"}
+			}
+			var fid clientdb.FileID
+			if err := fid.FromString(args[0]); err != nil {
+				return err
+			}
+			if err := as.c.PauseDownload(fid); err != nil {
+				return err
+			}
+			as.cwHelpMsg("Paused download of file %s", fid)
+			return nil
+		},
+	}, {
+		cmd:   "resume",
+		usage: "<FID>",
+		descr: "Resume a paused download",
+		handler: func(args []string, as *appState) error {
+			if len(args) < 1 {
+				return usageError{msg: "FID cannot be empty"}
+			}
+			var fid clientdb.FileID
+			if err := fid.FromString(args[0]); err != nil {
+				return err
+			}
+			if err := as.c.ResumeDownload(fid); err != nil {
+				return err
+			}
+			as.cwHelpMsg("Resumed download of file %s", fid)
+			return nil
+		},
+	}, {
+		cmd:           "cancel",
+		usableOffline: true,
+		usage:         "<FID>",
+		descr:         "Cancel an in-progress download",
+		long: []string{
+			"Cancels the download of the given file and removes the chunks that were already downloaded.",
+		},
+		handler: func(args []string, as *appState) error {
+			if len(args) < 1 {
+				return usageError{msg: "FID cannot be empty"}
+			}
+			var fid clientdb.FileID
+			if err := fid.FromString(args[0]); err != nil {
+				return err
+			}
+			if err := as.c.CancelDownload(fid); err != nil {
+				return err
+			}
+			as.contentMtx.Lock()
+			delete(as.progressMsg, fid)
+			as.contentMtx.Unlock()
+			as.cwHelpMsg("Canceled download of file %s", fid)
+			return nil
+		},
+	}, {
+		cmd:           "cancelupload",
+		usableOffline: true,
+		usage:         "<nick> <FID>",
+		descr:         "Cancel an in-progress upload to a user",
+		long: []string{
+			"Further requests for chunks of the file from the user are rejected, until the user requests the file again. Chunks that were already paid for are still sent.",
+		},
+		handler: func(args []string, as *appState) error {
+			if len(args) < 2 {
+				return usageError{msg: "nick and FID cannot be empty"}
+			}
+			ru, err := as.c.UserByNick(args[0])
+			if err != nil {
+				return err
+			}
+			var fid clientdb.FileID
+			if err := fid.FromString(args[1]); err != nil {
+				return err
+			}
+			if err := as.c.CancelUpload(ru.ID(), fid); err != nil {
+				return err
+			}
+			as.contentMtx.Lock()
+			delete(as.uploads, ftUploadKey{uid: ru.ID(), fid: fid})
+			as.contentMtx.Unlock()
+			as.cwHelpMsg("Canceled upload of file %s to %s", fid,
+				strescape.Nick(ru.Nick()))
+			return nil
+		},
+	}, {
+		cmd:           "priority",
+		usableOffline: true,
+		usage:         "<FID> [low|normal|high]",
+		descr:         "Show or set the priority of the transfers of a file",
+		long: []string{
+			"The priority applies to the download of the file or, if the file is shared by the local client, to its uploads to all users.",
+		},
+		handler: func(args []string, as *appState) error {
+			if len(args) < 1 {
+				return usageError{msg: "FID cannot be empty"}
+			}
+			var fid clientdb.FileID
+			if err := fid.FromString(args[0]); err != nil {
+				return err
+			}
+			if len(args) < 2 {
+				prio, err := as.c.GetTransferPriority(fid)
+				if err != nil {
+					return err
+				}
+				as.cwHelpMsg("Priority of transfers of file %s: %s", fid, prio)
+				return nil
+			}
+			prio := clientdb.TransferPriority(args[1])
+			if !prio.IsValid() {
+				return usageError{msg: fmt.Sprintf("invalid priority %q", args[1])}
+			}
+			if err := as.c.SetTransferPriority(fid, prio); err != nil {
+				return err
+			}
+			as.cwHelpMsg("Set priority of transfers of file %s to %s", fid, prio)
+			return nil
+		},
+	}, {
+		cmd:   "sharefolder",
+		usage: "<dir> <nick or gc> [<cost>]",
+		descr: "Share a folder with a user or the members of a GC",
+		long: []string{
+			"Shares all files inside the given dir. The dir is periodically scanned for changes, which are automatically sent to the user or GC members the folder is shared with.",
+			"The optional cost is specified in DCR and applies to each file of the folder.",
+		},
+		completer: func(args []string, arg string, as *appState) []string {
+			if len(args) == 0 {
+				return fileCompleter(arg)
+			}
+			return nil
+		},
+		handler: func(args []string, as *appState) error {
+			if len(args) < 2 {
+				return usageError{msg: "dir and nick or gc cannot be empty"}
+			}
+			dir, err := homedir.Expand(args[0])
+			if err != nil {
+				return err
+			}
+			var dcrCost float64
+			if len(args) > 2 {
+				dcrCost, err = strconv.ParseFloat(args[2], 64)
+				if err != nil {
+					return err
+				}
+			}
+
+			var uid *clientintf.UserID
+			var gcID *zkidentity.ShortID
+			if id, err := as.c.UIDByNick(args[1]); err == nil {
+				uid = &id
+			} else if id, err := as.c.GCIDByName(args[1]); err == nil {
+				gcID = &id
+			} else {
+				return fmt.Errorf("%q is not a user or gc", args[1])
+			}
+
+			atomCost := uint64(dcrCost * 1e8)
+			sf, err := as.c.ShareFolder(dir, uid, gcID, atomCost)
+			if err != nil {
+				return err
+			}
+			as.cwHelpMsg("Shared folder %q with %q (%d files). ID: %s",
+				sf.Name, args[1], len(sf.Files), sf.ID)
+			return nil
+		},
+	}, {
+		cmd:           "unsharefolder",
+		usableOffline: true,
+		usage:         "<folder id>",
+		descr:         "Stop sharing a local folder",
+		handler: func(args []string, as *appState) error {
+			if len(args) < 1 {
+				return usageError{msg: "folder id cannot be empty"}
+			}
+			var id zkidentity.ShortID
+			if err := id.FromString(args[0]); err != nil {
+				return err
+			}
+			if err := as.c.UnshareFolder(id); err != nil {
+				return err
+			}
+			as.cwHelpMsg("Stopped sharing folder %s", id)
+			return nil
+		},
+	}, {
+		cmd:   "syncfolder",
+		usage: "<folder id>",
+		descr: "Scan a local shared folder for changes",
+		handler: func(args []string, as *appState) error {
+			if len(args) < 1 {
+				return usageError{msg: "folder id cannot be empty"}
+			}
+			var id zkidentity.ShortID
+			if err := id.FromString(args[0]); err != nil {
+				return err
+			}
+			if err := as.c.SyncSharedFolder(id); err != nil {
+				return err
+			}
+			as.cwHelpMsg("Scanned folder %s for changes", id)
+			return nil
+		},
+	}, {
+		cmd:           "folders",
+		usableOffline: true,
+		descr:         "List shared folders",
+		handler: func(args []string, as *appState) error {
+			sfs, err := as.c.ListSharedFolders()
+			if err != nil {
+				return err
+			}
+			subs, err := as.c.ListSubscribedFolders()
+			if err != nil {
+				return err
+			}
+			nickOrID := func(uid clientintf.UserID) string {
+				nick, _ := as.c.UserNick(uid)
+				if nick == "" {
+					return uid.String()
+				}
+				return strescape.Nick(nick)
+			}
+			as.cwHelpMsgs(func(pf printf) {
+				pf("")
+				pf("Local shared folders")
+				for _, sf := range sfs {
+					with := ""
+					if sf.UID != nil {
+						with = nickOrID(*sf.UID)
+					} else if sf.GC != nil {
+						with = "gc " + sf.GC.String()
+						if alias, err := as.c.GetGCAlias(*sf.GC); err == nil {
+							with = "gc " + strescape.Nick(alias)
+						}
+					}
+					pf("%s - %q shared with %s", sf.ID, sf.Path, with)
+					pf("  Version: %d, Files: %d, Cost: %s per file",
+						sf.Version, len(sf.Files), dcrutil.Amount(sf.Cost))
+				}
+
+				pf("")
+				pf("Folders shared by remote users")
+				for _, sf := range subs {
+					status := "not synced"
+					switch {
+					case sf.Removed:
+						status = "no longer shared"
+					case sf.LocalPath != "":
+						status = fmt.Sprintf("synced to %q, %d "+
+							"pending files", sf.LocalPath,
+							len(sf.PendingFiles()))
+					}
+					pf("%s - %q by %s (%s)", sf.ID,
+						strescape.PathElement(sf.Name),
+						nickOrID(sf.Owner), status)
+					pf("  Version: %d, Files: %d", sf.Version, len(sf.Files))
+				}
+			})
+			return nil
+		},
+	}, {
+		cmd:           "acceptfolder",
+		usableOffline: true,
+		usage:         "<nick> <folder id> <local dir>",
+		descr:         "Sync a folder shared by a user to a local dir",
+		long: []string{
+			"New and changed files of the folder are automatically downloaded (without confirmation) to the local dir, paying for their chunks as usual.",
+		},
+		completer: func(args []string, arg string, as *appState) []string {
+			if len(args) == 2 {
+				return fileCompleter(arg)
+			}
+			return nil
+		},
+		handler: func(args []string, as *appState) error {
+			if len(args) < 3 {
+				return usageError{msg: "nick, folder id and local dir cannot be empty"}
+			}
+			uid, err := as.c.UIDByNick(args[0])
+			if err != nil {
+				return err
+			}
+			var id zkidentity.ShortID
+			if err := id.FromString(args[1]); err != nil {
+				return err
+			}
+			dir, err := homedir.Expand(args[2])
+			if err != nil {
+				return err
+			}
+			if err := as.c.AcceptSharedFolder(uid, id, dir); err != nil {
+				return err
+			}
+			as.cwHelpMsg("Syncing folder %s to %q", id, dir)
+			return nil
+		},
+	}, {
+		cmd:           "removefolder",
+		usableOffline: true,
+		usage:         "<nick> <folder id>",
+		descr:         "Stop syncing a folder shared by a user",
+		long: []string{
+			"Files that were already synced are kept.",
+		},
+		handler: func(args []string, as *appState) error {
+			if len(args) < 2 {
+				return usageError{msg: "nick and folder id cannot be empty"}
+			}
+			uid, err := as.c.UIDByNick(args[0])
+			if err != nil {
+				return err
+			}
+			var id zkidentity.ShortID
+			if err := id.FromString(args[1]); err != nil {
+				return err
+			}
+			if err := as.c.RemoveSubscribedFolder(uid, id); err != nil {
+				return err
+			}
+			as.cwHelpMsg("Stopped syncing folder %s", id)
+			return nil
+		},
+	}, {
+		cmd:           "reclaim",
+		usableOffline: true,
+		descr:         "Remove stored chunks no longer used by shared files or downloads",
+		handler: func(args []string, as *appState) error {
+			stats, err := as.c.ReclaimContentSpace()
+			if err != nil {
+				return err
+			}
+			as.cwHelpMsg("Removed %d unused chunks (%s)", stats.Chunks,
+				hbytes(int64(stats.Bytes)))
+			return nil
+		},
+	}, {
+		cmd:           "estimatecost",
+		usableOffline: true,
+		aliases:       []string{"estcost"},
+		descr:         "Estimate cost of uploading the specified file",
+		usage:         "<filepath>",
+		completer: func(args []string, arg string, as *appState) []string {
+			return fileCompleter(arg)
+		},
+		handler: func(args []string, as *appState) error {
+			if len(args) < 1 {
+				return usageError{msg: "file path cannot be empty"}
+			}
+
+			fpath := args[0]
+			stat, err := os.Stat(fpath)
+			if err != nil {
+				return err
+			}
+
+			feeRate, _ := as.serverPaymentRates()
+			size := stat.Size()
+			cost, err := clientintf.EstimateUploadCost(size, feeRate)
+			if err != nil {
+				return err
+			}
+			as.cwHelpMsg("Cost to upload file (%d B): %s", size,
+				dcrutil.Amount(cost/1e3))
+
+			return nil
+		},
+	}, {
+		cmd:           "unshare",
+		usableOffline: true,
+		usage:         "<file> [<user>]",
+		descr:         "Unshare a file",
+		handler: func(args []string, as *appState) error {
+			if len(args) < 1 {
 				return usageError{msg: "file cannot be empty"}
 			}
 			var user *clientintf.UserID
@@ -1167,95 +2173,297 @@ var postCommands = []tuicmd{
 			if len(args) < 1 {
 				return usageError{msg: "nick cannot be empty"}
 			}
-			uid, err := as.c.UIDByNick(args[0])
+			uid, err := as.c.UIDByNick(args[0])
+			if err != nil {
+				return err
+			}
+			cw := as.findOrNewChatWindow(uid, args[0])
+			go as.unsubscribeToPosts(cw)
+			return nil
+		},
+	}, {
+		cmd:     "list",
+		aliases: []string{"ls"},
+		usage:   "<nick>",
+		descr:   "List the posts made by the specified user",
+		handler: func(args []string, as *appState) error {
+			if len(args) < 1 {
+				return usageError{msg: "nick cannot be empty"}
+			}
+			uid, err := as.c.UIDByNick(args[0])
+			if err != nil {
+				return err
+			}
+			cw := as.findOrNewChatWindow(uid, args[0])
+			go func() {
+				err := as.c.ListUserPosts(uid)
+				if err != nil {
+					cw.newInternalMsg(fmt.Sprintf("Unable to list user posts: %v", err))
+					as.repaintIfActive(cw)
+				}
+			}()
+			cw.newInternalMsg("Listing user posts")
+			as.repaintIfActive(cw)
+			return nil
+		},
+	}, {
+		cmd:   "get",
+		usage: "<nick> <post id>",
+		descr: "Fetch post written by a remote user",
+		long:  []string{"The local client must already be a subscriber of the remote user's posts to be able to fetch an older post."},
+		handler: func(args []string, as *appState) error {
+			if len(args) < 1 {
+				return usageError{msg: "nick cannot be empty"}
+			}
+			if len(args) < 2 {
+				return usageError{msg: "post id cannot be empty"}
+			}
+
+			uid, err := as.c.UIDByNick(args[0])
+			if err != nil {
+				return err
+			}
+			var pid clientintf.PostID
+			if err := pid.FromString(args[1]); err != nil {
+				return err
+			}
+
+			cw := as.findOrNewChatWindow(uid, args[0])
+			go as.getUserPost(cw, pid)
+			return nil
+		},
+	}, {
+		cmd:   "relay",
+		usage: "<from user> <post id> <to user>",
+		descr: "Relay a post made by the from user to the to user",
+		handler: func(args []string, as *appState) error {
+			if len(args) < 1 {
+				return usageError{msg: "from user cannot be empty"}
+			}
+			if len(args) < 2 {
+				return usageError{msg: "post id cannot be empty"}
+			}
+			if len(args) < 3 {
+				return usageError{msg: "to user cannot be empty"}
+			}
+
+			fromUID, err := as.c.UIDByNick(args[0])
+			if err != nil {
+				return fmt.Errorf("from user: %v", err)
+			}
+			var pid clientintf.PostID
+			if err := pid.FromString(args[1]); err != nil {
+				return err
+			}
+			toUID, err := as.c.UIDByNick(args[2])
+			if err != nil {
+				return fmt.Errorf("to user: %v", err)
+			}
+
+			cw := as.findOrNewChatWindow(toUID, args[2])
+			go as.relayPost(fromUID, pid, cw)
+			return nil
+		},
+	}, {
+		cmd:     "react",
+		usage:   "<nick> <post id> <reaction>",
+		descr:   "React to a post written by a remote user",
+		long:    []string{"The reaction is an emoji or a short code (for example, :+1:)."},
+		handler: postReactHandler(false),
+	}, {
+		cmd:     "unreact",
+		usage:   "<nick> <post id> <reaction>",
+		descr:   "Remove a reaction to a post written by a remote user",
+		handler: postReactHandler(true),
+	},
+}
+
+// postReactHandler returns the handler for the commands that add (or remove, if
+// remove is true) a reaction to a post.
+func postReactHandler(remove bool) func(args []string, as *appState) error {
+	return func(args []string, as *appState) error {
+		if len(args) < 1 {
+			return usageError{msg: "nick cannot be empty"}
+		}
+		if len(args) < 2 {
+			return usageError{msg: "post id cannot be empty"}
+		}
+		if len(args) < 3 {
+			return usageError{msg: "reaction cannot be empty"}
+		}
+
+		uid, err := as.c.UIDByNick(args[0])
+		if err != nil {
+			return err
+		}
+		var pid clientintf.PostID
+		if err := pid.FromString(args[1]); err != nil {
+			return err
+		}
+		return as.c.ReactToPost(uid, pid, args[2], remove)
+	}
+}
+
+// msgReactHandler returns the handler for the commands that add (or remove, if
+// remove is true) a reaction to the last message sent by a user in the current
+// window.
+func msgReactHandler(remove bool) func(args []string, as *appState) error {
+	return func(args []string, as *appState) error {
+		cw := as.activeChatWindow()
+		if cw == nil {
+			return fmt.Errorf("current window is not a chat window")
+		}
+		if len(args) < 1 {
+			return usageError{msg: "nick cannot be empty"}
+		}
+		if len(args) < 2 {
+			return usageError{msg: "reaction cannot be empty"}
+		}
+		id := cw.lastMsgIDFrom(args[0])
+		if id.IsEmpty() {
+			return fmt.Errorf("no message from %q to react to", args[0])
+		}
+		var err error
+		if cw.isGC {
+			err = as.c.ReactToGCMessage(cw.gc, id, args[1], remove)
+		} else {
+			err = as.c.ReactToPM(cw.uid, id, args[1], remove)
+		}
+		if err != nil {
+			return err
+		}
+		cw.reactToMsg(id, as.c.PublicID(), args[1], remove)
+		as.repaintIfActive(cw)
+		return nil
+	}
+}
+
+var devicesCommands = []tuicmd{
+	{
+		cmd:           "list",
+		usableOffline: true,
+		descr:         "List the linked devices of the local identity",
+		handler: func(args []string, as *appState) error {
+			links, err := as.c.ListDevices()
+			if err != nil {
+				return err
+			}
+			as.cwHelpMsgs(func(pf printf) {
+				pf("")
+				if as.c.IsLinkedDevice() {
+					for _, link := range links {
+						pf("Linked to primary device as %q (%s) since %s",
+							link.Name, link.DeviceID,
+							link.Created.Format(ISO8601DateTime))
+					}
+					if len(links) == 0 {
+						pf("This device was unlinked from its primary device")
+					}
+					return
+				}
+				if len(links) == 0 {
+					pf("No linked devices")
+					return
+				}
+				pf("Linked devices")
+				for _, link := range links {
+					pf("%s - %q (linked %s)", link.DeviceID, link.Name,
+						link.Created.Format(ISO8601DateTime))
+				}
+			})
+			return nil
+		},
+	}, {
+		cmd:        "pair",
+		secretArgs: true,
+		descr:      "Create a pairing file to link a new device to the local identity",
+		usage:      "<device name> <filename> <passphrase>",
+		long: []string{
+			"The pairing file must be imported on the new device with '/devices link'. Once linked, PMs, GC messages and posts are received on all devices, and messages sent from any device are sent through this (primary) device, which must be online for the linked devices to work.",
+			"The pairing file contains the local identity. Keep it as secure as a backup and remove it after the new device is linked.",
+		},
+		handler: func(args []string, as *appState) error {
+			if len(args) < 1 {
+				return usageError{msg: "device name must be specified"}
+			}
+			if len(args) < 2 {
+				return usageError{msg: "filename must be specified"}
+			}
+			if len(args) < 3 {
+				return usageError{msg: "passphrase must be specified"}
+			}
+
+			filename, err := homedir.Expand(args[1])
+			if err != nil {
+				return err
+			}
+			f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
 			if err != nil {
 				return err
 			}
-			cw := as.findOrNewChatWindow(uid, args[0])
-			go as.unsubscribeToPosts(cw)
-			return nil
-		},
-	}, {
-		cmd:     "list",
-		aliases: []string{"ls"},
-		usage:   "<nick>",
-		descr:   "List the posts made by the specified user",
-		handler: func(args []string, as *appState) error {
-			if len(args) < 1 {
-				return usageError{msg: "nick cannot be empty"}
+			link, err := as.c.PairDevice(args[0], f, []byte(args[2]))
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
 			}
-			uid, err := as.c.UIDByNick(args[0])
 			if err != nil {
+				os.Remove(filename)
 				return err
 			}
-			cw := as.findOrNewChatWindow(uid, args[0])
-			go func() {
-				err := as.c.ListUserPosts(uid)
-				if err != nil {
-					cw.newInternalMsg(fmt.Sprintf("Unable to list user posts: %v", err))
-					as.repaintIfActive(cw)
-				}
-			}()
-			cw.newInternalMsg("Listing user posts")
-			as.repaintIfActive(cw)
+			as.cwHelpMsg("Created pairing file %s for device %q (%s)",
+				filename, link.Name, link.DeviceID)
 			return nil
 		},
 	}, {
-		cmd:   "get",
-		usage: "<nick> <post id>",
-		descr: "Fetch post written by a remote user",
-		long:  []string{"The local client must already be a subscriber of the remote user's posts to be able to fetch an older post."},
+		cmd:           "link",
+		usableOffline: true,
+		secretArgs:    true,
+		descr:         "Link this client to a primary device using a pairing file",
+		usage:         "<filename> <passphrase>",
+		long: []string{
+			"The pairing is imported the next time brclient is started and it replaces ALL of the existing client state.",
+		},
 		handler: func(args []string, as *appState) error {
 			if len(args) < 1 {
-				return usageError{msg: "nick cannot be empty"}
+				return usageError{msg: "filename must be specified"}
 			}
 			if len(args) < 2 {
-				return usageError{msg: "post id cannot be empty"}
+				return usageError{msg: "passphrase must be specified"}
 			}
 
-			uid, err := as.c.UIDByNick(args[0])
+			filename, err := homedir.Expand(args[0])
 			if err != nil {
 				return err
 			}
-			var pid clientintf.PostID
-			if err := pid.FromString(args[1]); err != nil {
+			f, err := os.Open(filename)
+			if err != nil {
 				return err
 			}
-
-			cw := as.findOrNewChatWindow(uid, args[0])
-			go as.getUserPost(cw, pid)
+			defer f.Close()
+			if err := as.c.ImportDevicePairing(f, []byte(args[1])); err != nil {
+				return err
+			}
+			as.cwHelpMsg("Device will be linked when brclient is restarted")
 			return nil
 		},
 	}, {
-		cmd:   "relay",
-		usage: "<from user> <post id> <to user>",
-		descr: "Relay a post made by the from user to the to user",
+		cmd:   "revoke",
+		descr: "Unlink a device from the local identity",
+		usage: "<device id>",
+		long: []string{
+			"The revoked device stops receiving and sending messages on behalf of the local identity.",
+		},
 		handler: func(args []string, as *appState) error {
 			if len(args) < 1 {
-				return usageError{msg: "from user cannot be empty"}
-			}
-			if len(args) < 2 {
-				return usageError{msg: "post id cannot be empty"}
-			}
-			if len(args) < 3 {
-				return usageError{msg: "to user cannot be empty"}
-			}
-
-			fromUID, err := as.c.UIDByNick(args[0])
-			if err != nil {
-				return fmt.Errorf("from user: %v", err)
+				return usageError{msg: "device id must be specified"}
 			}
-			var pid clientintf.PostID
-			if err := pid.FromString(args[1]); err != nil {
+			var id zkidentity.ShortID
+			if err := id.FromString(args[0]); err != nil {
 				return err
 			}
-			toUID, err := as.c.UIDByNick(args[2])
-			if err != nil {
-				return fmt.Errorf("to user: %v", err)
+			if err := as.c.RevokeDevice(id); err != nil {
+				return err
 			}
-
-			cw := as.findOrNewChatWindow(toUID, args[2])
-			go as.relayPost(fromUID, pid, cw)
+			as.cwHelpMsg("Revoked device %s", id)
 			return nil
 		},
 	},
@@ -1968,6 +3176,188 @@ var commands = []tuicmd{
 			}
 			return as.c.Ignore(uid, false)
 		},
+	}, {
+		cmd:           "retention",
+		usableOffline: true,
+		usage:         "<nick> [forever | afterread | <n>d]",
+		descr:         "Show or modify the message retention policy with a user",
+		long: []string{
+			"The policy is sent to the user, so that both sides honor it.",
+			"With afterread, messages are not stored after they are displayed. With <n>d, messages are removed after n days.",
+		},
+		handler: func(args []string, as *appState) error {
+			if len(args) < 1 {
+				return usageError{msg: "user cannot be empty"}
+			}
+			uid, err := as.c.UIDByNick(args[0])
+			if err != nil {
+				return err
+			}
+			if len(args) < 2 {
+				policy, err := as.c.PMRetention(uid)
+				if err != nil {
+					return err
+				}
+				as.cwHelpMsg("Retention policy with %s: %s",
+					args[0], policy)
+				return nil
+			}
+
+			policy, err := parseRetentionPolicy(args[1])
+			if err != nil {
+				return usageError{msg: err.Error()}
+			}
+			if err := as.c.SetPMRetention(uid, policy); err != nil {
+				return err
+			}
+			cw := as.findOrNewChatWindow(uid, args[0])
+			cw.newInternalMsg(fmt.Sprintf("Changed retention policy to %s", policy))
+			as.repaintIfActive(cw)
+			return nil
+		},
+	}, {
+		cmd:           "receipts",
+		usableOffline: true,
+		usage:         "<nick> [on | off]",
+		descr:         "Show or modify whether PM receipts are sent to a user",
+		long: []string{
+			"Receipts tell the user when their PMs were delivered to and read by the local client.",
+		},
+		handler: func(args []string, as *appState) error {
+			if len(args) < 1 {
+				return usageError{msg: "user cannot be empty"}
+			}
+			uid, err := as.c.UIDByNick(args[0])
+			if err != nil {
+				return err
+			}
+			if len(args) < 2 {
+				send, err := as.c.PMReceipts(uid)
+				if err != nil {
+					return err
+				}
+				if send {
+					as.cwHelpMsg("Sending PM receipts to %s", args[0])
+				} else {
+					as.cwHelpMsg("Not sending PM receipts to %s", args[0])
+				}
+				return nil
+			}
+
+			var send bool
+			switch args[1] {
+			case "on":
+				send = true
+			case "off":
+			default:
+				return usageError{msg: "receipts must be on or off"}
+			}
+			if err := as.c.SetPMReceipts(uid, send); err != nil {
+				return err
+			}
+			as.cwHelpMsg("Set sending PM receipts to %s: %s", args[0], args[1])
+			return nil
+		},
+	}, {
+		cmd:   "reply",
+		usage: "<nick> <message>",
+		descr: "Reply to the last message sent by nick in the current window",
+		long: []string{
+			"The message replied to is quoted above the reply. Use your own nick to reply to your last message.",
+		},
+		rawHandler: func(rawCmd string, args []string, as *appState) error {
+			cw := as.activeChatWindow()
+			if cw == nil {
+				return fmt.Errorf("current window is not a chat window")
+			}
+			if len(args) < 1 {
+				return usageError{msg: "nick cannot be empty"}
+			}
+			_, msg := popNArgs(rawCmd, 2) // cmd + nick
+			if msg == "" {
+				return usageError{msg: "message cannot be empty"}
+			}
+			replyTo := cw.lastMsgIDFrom(args[0])
+			if replyTo.IsEmpty() {
+				return fmt.Errorf("no message from %q to reply to", args[0])
+			}
+			go as.pmReply(cw, replyTo, msg)
+			return nil
+		},
+	}, {
+		cmd:   "react",
+		usage: "<nick> <reaction>",
+		descr: "React to the last message sent by nick in the current window",
+		long: []string{
+			"The reaction is an emoji or a short code (for example, :+1:). Use your own nick to react to your last message.",
+		},
+		handler: msgReactHandler(false),
+	}, {
+		cmd:     "unreact",
+		usage:   "<nick> <reaction>",
+		descr:   "Remove a reaction to the last message sent by nick in the current window",
+		handler: msgReactHandler(true),
+	}, {
+		cmd:   "edit",
+		usage: "<new message>",
+		descr: "Replace the last message sent in the current window",
+		long: []string{
+			"Only messages sent during the current session may be edited. The remote users will see the new contents of the message.",
+		},
+		rawHandler: func(rawCmd string, args []string, as *appState) error {
+			cw := as.activeChatWindow()
+			if cw == nil {
+				return fmt.Errorf("current window is not a chat window")
+			}
+			_, msg := popNArgs(rawCmd, 1)
+			if msg == "" {
+				return usageError{msg: "new message cannot be empty"}
+			}
+			id := cw.lastSentMsgID()
+			if id.IsEmpty() {
+				return fmt.Errorf("no sent message to edit")
+			}
+			var err error
+			if cw.isGC {
+				err = as.c.EditGCMessage(cw.gc, id, msg)
+			} else {
+				err = as.c.EditPM(cw.uid, id, msg)
+			}
+			if err != nil {
+				return err
+			}
+			cw.editMsg(true, clientintf.UserID{}, id, msg)
+			as.repaintIfActive(cw)
+			return nil
+		},
+	}, {
+		cmd:   "retract",
+		descr: "Remove the last message sent in the current window",
+		long: []string{
+			"Only messages sent during the current session may be retracted. The remote users are asked to remove the message from their history.",
+		},
+		handler: func(args []string, as *appState) error {
+			cw := as.activeChatWindow()
+			if cw == nil {
+				return fmt.Errorf("current window is not a chat window")
+			}
+			id := cw.lastSentMsgID()
+			if id.IsEmpty() {
+				return fmt.Errorf("no sent message to retract")
+			}
+			var err error
+			if cw.isGC {
+				err = as.c.RetractGCMessage(cw.gc, id)
+			} else {
+				err = as.c.RetractPM(cw.uid, id)
+			}
+			if err != nil {
+				return err
+			}
+			cw.retractMsg(true, clientintf.UserID{}, id)
+			as.repaintIfActive(cw)
+			return nil
+		},
 	}, {
 		cmd:   "block",
 		usage: "<nick>",
@@ -2421,6 +3811,157 @@ var commands = []tuicmd{
 			as.cwHelpMsg("Cleared payment stats%s", forUser)
 			return nil
 		},
+	}, {
+		cmd:           "search",
+		usableOffline: true,
+		descr:         "Search the PM, GC and post history",
+		usage:         "[type:pm|gc|post|comment] [user:<nick>] [gc:<name>] <terms...>",
+		long: []string{
+			"Returns the most recent messages that contain all of the search terms.",
+			"Only messages received or sent after search was enabled are searchable.",
+		},
+		handler: func(args []string, as *appState) error {
+			filters := clientdb.SearchFilters{Limit: 50}
+			var terms []string
+			for _, arg := range args {
+				switch {
+				case strings.HasPrefix(arg, "type:"):
+					typ := clientdb.SearchResultType(arg[5:])
+					filters.Types = append(filters.Types, typ)
+				case strings.HasPrefix(arg, "user:"):
+					uid, err := as.c.UIDByNick(arg[5:])
+					if err != nil {
+						return err
+					}
+					filters.UID = &uid
+				case strings.HasPrefix(arg, "gc:"):
+					gcID, err := as.c.GCIDByName(arg[3:])
+					if err != nil {
+						return err
+					}
+					filters.GCID = &gcID
+				default:
+					terms = append(terms, arg)
+				}
+			}
+			if len(terms) == 0 {
+				return usageError{msg: "search terms cannot be empty"}
+			}
+
+			res, err := as.c.Search(strings.Join(terms, " "), filters)
+			if err != nil {
+				return err
+			}
+
+			as.cwHelpMsgs(func(pf printf) {
+				pf("")
+				pf("Search results (%d)", len(res))
+				for _, r := range res {
+					var where string
+					switch r.Type {
+					case clientdb.SearchTypeGC:
+						where, _ = as.c.GetGCAlias(r.GCID)
+						if where == "" {
+							where = r.GCID.String()
+						}
+					default:
+						where, _ = as.c.UserNick(r.UID)
+						if where == "" {
+							where = r.UID.String()
+						}
+					}
+					pf("%s %s %s <%s> %s",
+						r.Timestamp.Format(ISO8601DateTime),
+						r.Type, strescape.Nick(where),
+						strescape.Nick(r.From),
+						strescape.Content(r.Text))
+				}
+			})
+			return nil
+		},
+	}, {
+		cmd:           "backup",
+		usableOffline: true,
+		secretArgs:    true,
+		descr:         "Create an encrypted backup of the full client state",
+		usage:         "<filename> <passphrase>",
+		long: []string{
+			"The backup includes the local identity, address book, ratchets, GCs, posts, shared files and ongoing KXs. It can be restored on a different machine with the /restore command.",
+			"If the client db is encrypted, the db passphrase will also be needed after restoring the backup.",
+		},
+		handler: func(args []string, as *appState) error {
+			if len(args) < 1 {
+				return usageError{msg: "filename must be specified"}
+			}
+			if len(args) < 2 {
+				return usageError{msg: "passphrase must be specified"}
+			}
+
+			filename, err := homedir.Expand(args[0])
+			if err != nil {
+				return err
+			}
+			f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+			if err != nil {
+				return err
+			}
+			err = as.c.ExportBackup(f, []byte(args[1]))
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				os.Remove(filename)
+				return err
+			}
+			as.cwHelpMsg("Created backup %s", filename)
+			return nil
+		},
+	}, {
+		cmd:           "restore",
+		usableOffline: true,
+		secretArgs:    true,
+		descr:         "Restore the client state from a backup",
+		usage:         "<filename> <passphrase>",
+		long: []string{
+			"The backup is restored the next time brclient is started and it replaces ALL of the existing client state.",
+			"The ratchets with every user are reset after the backup is restored. Do NOT keep using the client from which the backup was created.",
+		},
+		handler: func(args []string, as *appState) error {
+			if len(args) < 1 {
+				return usageError{msg: "filename must be specified"}
+			}
+			if len(args) < 2 {
+				return usageError{msg: "passphrase must be specified"}
+			}
+
+			filename, err := homedir.Expand(args[0])
+			if err != nil {
+				return err
+			}
+			f, err := os.Open(filename)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			if err := as.c.ImportBackup(f, []byte(args[1])); err != nil {
+				return err
+			}
+			as.cwHelpMsg("Backup will be restored when brclient is restarted")
+			return nil
+		},
+	}, {
+		cmd:           "devices",
+		usableOffline: true,
+		usage:         "[sub]",
+		descr:         "Linked devices commands",
+		sub:           devicesCommands,
+		completer: func(args []string, arg string, as *appState) []string {
+			if len(args) == 0 {
+				return cmdCompleter(devicesCommands, arg, false)
+			}
+			return nil
+		},
+		handler: handleWithSubcmd(devicesCommands, "list"),
 	}, {
 		cmd:           "info",
 		usableOffline: true,
@@ -72,6 +72,11 @@ type config struct {
 	CPUProfile     string
 	CPUProfileHz   int
 	LogPings       bool
+	EncryptDB      bool
+	DBDriver       string
+
+	MultiSourceDownloads   bool
+	ContentDefinedChunking bool
 
 	ProxyAddr    string
 	ProxyUser    string
@@ -225,6 +230,10 @@ func loadConfig() (*config, error) {
 	flagWinPin := fs.String("winpin", "", "Comma delimited list of DM and GC windows to launch on start")
 	flagBlinkCursor := fs.Bool("blinkcursor", true, "Blink cursor")
 	flagBellCmd := fs.String("bellcmd", "", "Bell command on new msgs")
+	flagEncryptDB := fs.Bool("encryptdb", false, "Encrypt the client db at rest")
+	flagDBDriver := fs.String("dbdriver", "fs", "Storage driver for the client db (fs or sqlite)")
+	flagMultiSourceDownloads := fs.Bool("multisourcedownloads", false, "Download files from all users that share them")
+	flagContentDefinedChunking := fs.Bool("contentdefinedchunking", false, "Chunk shared files with boundaries defined by their contents")
 
 	flagProxyAddr := fs.String("proxyaddr", "", "")
 	flagProxyUser := fs.String("proxyuser", "", "")
@@ -311,6 +320,8 @@ func loadConfig() (*config, error) {
 		CPUProfile:     *flagCPUProfile,
 		CPUProfileHz:   *flagCPUProfileHz,
 		LogPings:       *flagLogPings,
+		EncryptDB:      *flagEncryptDB,
+		DBDriver:       *flagDBDriver,
 		ProxyAddr:      *flagProxyAddr,
 		ProxyUser:      *flagProxyUser,
 		ProxyPass:      *flagProxyPass,
@@ -320,6 +331,9 @@ func loadConfig() (*config, error) {
 		MinSendBal:     minSendBal,
 		WinPin:         winpin,
 		MimeMap:        mimeMap,
+
+		MultiSourceDownloads:   *flagMultiSourceDownloads,
+		ContentDefinedChunking: *flagContentDefinedChunking,
 	}, nil
 }
 
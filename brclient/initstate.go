@@ -5,6 +5,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/charmbracelet/bubbles/textinput"
 	"github.com/charmbracelet/bubbles/viewport"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
@@ -18,6 +19,11 @@ type initStepState struct {
 	msgConfCert *msgConfirmServerCert
 	viewport    viewport.Model
 	focusIdx    int
+
+	txtPass   textinput.Model
+	newPass   string
+	unlocking bool
+	unlockErr string
 }
 
 func (ins initStepState) Init() tea.Cmd {
@@ -44,6 +50,64 @@ func (ins initStepState) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		return ins, tea.Quit
 	}
 
+	// The DB must be unlocked before the services can be started.
+	if ins.as.db.NeedsUnlock() {
+		switch msg := msg.(type) {
+		case tea.WindowSizeMsg:
+			ins.as.winW = msg.Width
+			ins.as.winH = msg.Height
+			ins.updateLogLines()
+			return ins, nil
+
+		case unlockDBResult:
+			ins.unlocking = false
+			if msg.err != nil {
+				ins.unlockErr = msg.err.Error()
+				return ins, nil
+			}
+			return ins, ins.as.runAsCmd
+
+		case logUpdated:
+			ins.updateLogLines()
+			return ins, nil
+		}
+
+		if ins.unlocking {
+			return ins, nil
+		}
+
+		ins.txtPass, cmd = ins.txtPass.Update(msg)
+		if !isEnterMsg(msg) {
+			return ins, cmd
+		}
+
+		pass := ins.txtPass.Value()
+		ins.txtPass.SetValue("")
+		ins.unlockErr = ""
+
+		// New passphrases need to be confirmed.
+		if !ins.as.db.IsEncrypted() {
+			if ins.newPass == "" {
+				ins.newPass = pass
+				ins.txtPass.Prompt = "Confirm DB Passphrase: "
+				return ins, nil
+			}
+			ins.txtPass.Prompt = "DB Passphrase: "
+			if pass != ins.newPass {
+				ins.newPass = ""
+				ins.unlockErr = "passphrases do not match"
+				return ins, nil
+			}
+			ins.newPass = ""
+		}
+
+		ins.unlocking = true
+		db := ins.as.db
+		return ins, func() tea.Msg {
+			return unlockDBResult{err: db.UnlockDB([]byte(pass))}
+		}
+	}
+
 	if ins.msgConfCert != nil {
 		switch msg := msg.(type) {
 		case tea.KeyMsg:
@@ -144,6 +208,19 @@ func (ins initStepState) View() string {
 		msg = "Initializing client..."
 		content = ins.viewport.View()
 
+	case ins.unlocking:
+		msg = "Unlocking client database..."
+		content = ins.viewport.View()
+
+	case ins.as.db.NeedsUnlock():
+		msg = "Unlock client database"
+		if !ins.as.db.IsEncrypted() {
+			msg = "Enter new passphrase to encrypt the client database"
+		}
+		content = ins.txtPass.View() + "\n\n" +
+			ins.as.styles.err.Render(ins.unlockErr) + "\n\n" +
+			ins.viewport.View()
+
 	case ins.msgConfCert != nil:
 		msg = "Confirm Server Certificates"
 		conf := ins.msgConfCert
@@ -183,7 +260,18 @@ func (ins initStepState) View() string {
 }
 
 func newInitStepState(as *appState, msgConfCert *msgConfirmServerCert) initStepState {
-	ins := initStepState{as: as, msgConfCert: msgConfCert}
+	txtPass := textinput.New()
+	txtPass.Placeholder = ""
+	txtPass.Prompt = "DB Passphrase: "
+	txtPass.EchoCharacter = '*'
+	txtPass.EchoMode = textinput.EchoPassword
+	txtPass.PromptStyle = as.styles.focused
+	txtPass.TextStyle = as.styles.focused
+	txtPass.Width = 100
+	txtPass.SetCursorMode(textinput.CursorBlink)
+	txtPass.Focus()
+
+	ins := initStepState{as: as, msgConfCert: msgConfCert, txtPass: txtPass}
 	ins.updateLogLines()
 	return ins
 }
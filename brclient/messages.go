@@ -146,6 +146,10 @@ type unlockDcrlndResult struct {
 	err error
 }
 
+type unlockDBResult struct {
+	err error
+}
+
 type lnChainSyncUpdate struct {
 	update *initchainsyncrpc.ChainSyncUpdate
 	err    error
@@ -48,6 +48,7 @@ type postWindow struct {
 	comments   []*comment
 	myComments []string
 	hearts     int
+	reactions  clientdb.Reactions
 	summ       clientdb.PostSummary
 	author     string
 	relayedBy  string
@@ -145,6 +146,7 @@ func (pw *postWindow) updatePost() {
 		pw.comments = pw.comments[:0]
 	}
 	pw.hearts = 0
+	pw.reactions = clientdb.PostReactions(status)
 
 	pw.debug = ""
 
@@ -301,7 +303,13 @@ func (pw *postWindow) renderPost() {
 	write(styles.help.Render("Received "))
 	write(styles.timestampHelp.Render(date))
 	//write(styles.help.Render(pf(" - %d ♥", pw.hearts)))
-	write("\n\n")
+	write("\n")
+	if r := formatReactions(pw.reactions); r != "" {
+		write(styles.help.Render("Reactions "))
+		write(styles.timestampHelp.Render(r))
+		write("\n")
+	}
+	write("\n")
 
 	content := strings.TrimSpace(attr[rpc.RMPMain])
 	if content == "" {
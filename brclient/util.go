@@ -13,6 +13,7 @@ import (
 	"strings"
 
 	tea "github.com/charmbracelet/bubbletea"
+	"github.com/companyzero/bisonrelay/rpc"
 	"github.com/companyzero/bisonrelay/zkidentity"
 	"github.com/decred/dcrlnd"
 	"github.com/decred/dcrlnd/lnrpc"
@@ -318,3 +319,39 @@ func programByMimeType(mimeMap map[string]string, t string) string {
 	}
 	return ""
 }
+
+// parseRetentionPolicy parses a retention policy specified as "forever",
+// "afterread" or a number of days ("<n>d").
+func parseRetentionPolicy(s string) (rpc.RetentionPolicy, error) {
+	switch s {
+	case "forever":
+		return rpc.RetentionPolicy{Mode: rpc.RetentionModeForever}, nil
+	case "afterread":
+		return rpc.RetentionPolicy{Mode: rpc.RetentionModeAfterRead}, nil
+	}
+	days, err := strconv.ParseUint(strings.TrimSuffix(s, "d"), 10, 32)
+	if err != nil || days == 0 {
+		return rpc.RetentionPolicy{}, fmt.Errorf("invalid retention "+
+			"policy %q: must be forever, afterread or <n>d", s)
+	}
+	return rpc.RetentionPolicy{Mode: rpc.RetentionModeDays, Days: uint32(days)}, nil
+}
+
+// maxQuoteSnippetLen is the maximum number of characters of a message shown
+// when quoting it.
+const maxQuoteSnippetLen = 50
+
+// quoteSnippet returns the first line of msg, truncated to be shown as a
+// quote of the message.
+func quoteSnippet(msg string) string {
+	line, _, more := strings.Cut(msg, "\n")
+	runes := []rune(line)
+	if len(runes) > maxQuoteSnippetLen {
+		runes = runes[:maxQuoteSnippetLen]
+		more = true
+	}
+	if more {
+		return string(runes) + "…"
+	}
+	return line
+}
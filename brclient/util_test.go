@@ -2,8 +2,10 @@ package main
 
 import (
 	"reflect"
+	"strings"
 	"testing"
 
+	"github.com/companyzero/bisonrelay/rpc"
 	"github.com/companyzero/bisonrelay/zkidentity"
 )
 
@@ -80,3 +82,62 @@ func TestReplaceEmbeds(t *testing.T) {
 		})
 	}
 }
+
+func TestParseRetentionPolicy(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    rpc.RetentionPolicy
+		wantErr bool
+	}{
+		{s: "forever", want: rpc.RetentionPolicy{}},
+		{s: "afterread", want: rpc.RetentionPolicy{Mode: rpc.RetentionModeAfterRead}},
+		{s: "7d", want: rpc.RetentionPolicy{Mode: rpc.RetentionModeDays, Days: 7}},
+		{s: "30", want: rpc.RetentionPolicy{Mode: rpc.RetentionModeDays, Days: 30}},
+		{s: "0d", wantErr: true},
+		{s: "-1d", wantErr: true},
+		{s: "never", wantErr: true},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.s, func(t *testing.T) {
+			got, err := parseRetentionPolicy(tc.s)
+			if tc.wantErr != (err != nil) {
+				t.Fatalf("unexpected error: got %v, want error %v",
+					err, tc.wantErr)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("unexpected policy: got %v, want %v",
+					got, tc.want)
+			}
+		})
+	}
+}
+
+func TestQuoteSnippet(t *testing.T) {
+	long := strings.Repeat("a", maxQuoteSnippetLen)
+	tests := []struct {
+		name string
+		msg  string
+		want string
+	}{
+		{name: "empty", msg: "", want: ""},
+		{name: "short", msg: "hello", want: "hello"},
+		{name: "max len", msg: long, want: long},
+		{name: "too long", msg: long + "b", want: long + "…"},
+		{name: "multiline", msg: "first\nsecond", want: "first…"},
+		{name: "unicode", msg: strings.Repeat("é", maxQuoteSnippetLen+1),
+			want: strings.Repeat("é", maxQuoteSnippetLen) + "…"},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			got := quoteSnippet(tc.msg)
+			if got != tc.want {
+				t.Fatalf("unexpected snippet: got %q, want %q",
+					got, tc.want)
+			}
+		})
+	}
+}
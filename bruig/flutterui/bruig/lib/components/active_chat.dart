@@ -124,7 +124,9 @@ class ReceivedSentPM extends StatefulWidget {
   final ChatEventModel evnt;
   final String nick;
   final int timestamp;
-  const ReceivedSentPM(this.evnt, this.nick, this.timestamp, {Key? key})
+  final String? replyQuote;
+  const ReceivedSentPM(this.evnt, this.nick, this.timestamp,
+      {this.replyQuote, Key? key})
       : super(key: key);
 
   @override
@@ -231,6 +233,25 @@ class _ReceivedSentPMState extends State<ReceivedSentPM> {
               )
             ]),
             const SizedBox(height: 10),
+            ...(widget.replyQuote != null
+                ? [
+                    Container(
+                      padding: const EdgeInsets.only(left: 5),
+                      margin: const EdgeInsets.only(bottom: 5),
+                      decoration: BoxDecoration(
+                          border: Border(
+                              left: BorderSide(
+                                  color: darkTextColor, width: 2))),
+                      child: Text(widget.replyQuote!,
+                          maxLines: 1,
+                          overflow: TextOverflow.ellipsis,
+                          style: TextStyle(
+                              fontSize: 11,
+                              color: darkTextColor,
+                              fontStyle: FontStyle.italic)),
+                    )
+                  ]
+                : []),
             MarkdownBody(
                 styleSheet: MarkdownStyleSheet(
                   p: TextStyle(
@@ -274,7 +295,9 @@ class _ReceivedSentPMState extends State<ReceivedSentPM> {
 class PMW extends StatelessWidget {
   final ChatEventModel evnt;
   final String nick;
-  const PMW(this.evnt, this.nick, {Key? key}) : super(key: key);
+  final String? replyQuote;
+  const PMW(this.evnt, this.nick, {this.replyQuote, Key? key})
+      : super(key: key);
 
   @override
   Widget build(BuildContext context) {
@@ -284,14 +307,17 @@ class PMW extends StatelessWidget {
       timestamp =
           evnt.source?.nick == null ? event.timestamp : event.timestamp * 1000;
     }
-    return ReceivedSentPM(evnt, evnt.source?.nick ?? nick, timestamp);
+    return ReceivedSentPM(evnt, evnt.source?.nick ?? nick, timestamp,
+        replyQuote: replyQuote);
   }
 }
 
 class GCMW extends StatelessWidget {
   final ChatEventModel evnt;
   final String nick;
-  const GCMW(this.evnt, this.nick, {Key? key}) : super(key: key);
+  final String? replyQuote;
+  const GCMW(this.evnt, this.nick, {this.replyQuote, Key? key})
+      : super(key: key);
 
   @override
   Widget build(BuildContext context) {
@@ -301,7 +327,8 @@ class GCMW extends StatelessWidget {
       timestamp =
           evnt.source?.nick == null ? event.timestamp : event.timestamp * 1000;
     }
-    return ReceivedSentPM(evnt, evnt.source?.nick ?? nick, timestamp);
+    return ReceivedSentPM(evnt, evnt.source?.nick ?? nick, timestamp,
+        replyQuote: replyQuote);
   }
 }
 
@@ -694,10 +721,33 @@ class Event extends StatelessWidget {
   const Event(this.chat, this.event, this.nick, this.scrollToBottom, {Key? key})
       : super(key: key);
 
+  // replyQuote returns the quote of the message replied to by the event, if
+  // it is a reply.
+  String? replyQuote() {
+    var e = event.event;
+    String? replyTo;
+    if (e is PM) {
+      replyTo = e.replyTo;
+    } else if (e is GCMsg) {
+      replyTo = e.replyTo;
+    }
+    if (isEmptyMsgID(replyTo)) {
+      return null;
+    }
+
+    var quoted = chat.findMsg(replyTo!);
+    if (quoted == null) {
+      return "Reply to an unknown message";
+    }
+    var quotedNick = quoted.source?.nick ?? nick;
+    var line = quoted.event.msg.split("\n")[0];
+    return "$quotedNick: $line";
+  }
+
   @override
   Widget build(BuildContext context) {
     if (event.event is PM) {
-      return PMW(event, nick);
+      return PMW(event, nick, replyQuote: replyQuote());
     }
 
     if (event.event is InflightTip) {
@@ -705,10 +755,10 @@ class Event extends StatelessWidget {
     }
 
     if (event.event is GCMsg) {
-      return GCMW(event, nick);
+      return GCMW(event, nick, replyQuote: replyQuote());
     }
 
-    if (event.event is GCUserEvent) {
+    if (event.event is GCUserEvent || event.event is GCJoinRejected) {
       return GCUserEventW(event);
     }
 
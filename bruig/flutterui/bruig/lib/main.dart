@@ -24,6 +24,7 @@ import 'package:bruig/screens/needs_out_channel.dart';
 import 'package:bruig/screens/new_config.dart';
 import 'package:bruig/screens/new_gc.dart';
 import 'package:bruig/screens/shutdown.dart';
+import 'package:bruig/screens/unlock_db.dart';
 import 'package:bruig/screens/unlock_ln.dart';
 import 'package:bruig/screens/verify_invite.dart';
 import 'package:bruig/screens/verify_server.dart';
@@ -196,6 +197,11 @@ class _AppState extends State<App> with WindowListener {
               .pushNamed('/startup/verifyServer', arguments: cert);
           break;
 
+        case NTDBNeedsUnlock:
+          var args = ntf.payload as DBNeedsUnlock;
+          navkey.currentState!.pushNamed('/unlockDB', arguments: args);
+          break;
+
         case NTLNConfPayReqRecvChan:
           var est = ntf.payload as LNReqChannelEstValue;
           navkey.currentState!
@@ -244,6 +250,7 @@ class _AppState extends State<App> with WindowListener {
               routes: {
                 '/': (context) => const AppStartingLoadScreen(),
                 '/initLocalID': (context) => const InitLocalIDScreen(),
+                '/unlockDB': (context) => const UnlockDBScreen(),
                 '/startup/verifyServer': (context) =>
                     const VerifyServerScreen(),
                 '/verifyInvite': (context) => const VerifyInviteScreen(),
@@ -84,6 +84,11 @@ class ChatEventModel extends ChangeNotifier {
   }
 }
 
+// isEmptyMsgID returns true if the message id is not set. Messages without an
+// id are sent by older clients.
+bool isEmptyMsgID(String? id) =>
+    id == null || id == "" || id.replaceAll("0", "") == "";
+
 class ChatModel extends ChangeNotifier {
   final String id; // RemoteUID or GC ID
   final bool isGC;
@@ -118,6 +123,17 @@ class ChatModel extends ChangeNotifier {
     notifyListeners();
   }
 
+  // findMsg returns the PM or GC message with the given id.
+  ChatEventModel? findMsg(String id) {
+    for (var m in _msgs) {
+      var e = m.event;
+      if ((e is PM && e.id == id) || (e is GCMsg && e.msgID == id)) {
+        return m;
+      }
+    }
+    return null;
+  }
+
   void payTip(double amount) async {
     var tip = await Golib.payTip(id, amount);
     _msgs.add(ChatEventModel(tip, this));
@@ -0,0 +1,148 @@
+import 'package:bruig/components/snackbars.dart';
+import 'package:bruig/components/buttons.dart';
+import 'package:flutter/material.dart';
+import 'package:golib_plugin/definitions.dart';
+import 'package:golib_plugin/golib_plugin.dart';
+
+class UnlockDBScreen extends StatefulWidget {
+  const UnlockDBScreen({Key? key}) : super(key: key);
+
+  @override
+  UnlockDBScreenState createState() {
+    return UnlockDBScreenState();
+  }
+}
+
+class UnlockDBScreenState extends State<UnlockDBScreen> {
+  final _formKey = GlobalKey<FormState>();
+  final TextEditingController passCtrl = TextEditingController();
+  bool unlocking = false;
+
+  @override
+  void dispose() {
+    passCtrl.dispose();
+    super.dispose();
+  }
+
+  void unlockPressed() async {
+    if (unlocking) return;
+    if (!_formKey.currentState!.validate()) return;
+
+    setState(() => unlocking = true);
+    try {
+      await Golib.unlockDB(passCtrl.text);
+    } catch (exception) {
+      // Keep the screen open, so that the passphrase can be retried.
+      showErrorSnackbar(context, 'Unable to unlock database: $exception');
+      setState(() => unlocking = false);
+      return;
+    }
+
+    Navigator.pop(context);
+  }
+
+  @override
+  Widget build(BuildContext context) {
+    final args = ModalRoute.of(context)!.settings.arguments as DBNeedsUnlock;
+    var backgroundColor = const Color(0xFF19172C);
+    var cardColor = const Color(0xFF05031A);
+    var textColor = const Color(0xFF8E8D98);
+    var secondaryTextColor = const Color(0xFFE4E3E6);
+
+    return Scaffold(
+        body: Container(
+            color: backgroundColor,
+            child: Stack(children: [
+              Container(
+                  decoration: const BoxDecoration(
+                      image: DecorationImage(
+                          fit: BoxFit.fill,
+                          image: AssetImage("assets/images/loading-bg.png")))),
+              Container(
+                decoration: BoxDecoration(
+                    gradient: LinearGradient(
+                        begin: Alignment.bottomLeft,
+                        end: Alignment.topRight,
+                        colors: [
+                      cardColor,
+                      const Color(0xFF07051C),
+                      backgroundColor.withOpacity(0.34),
+                    ],
+                        stops: const [
+                      0,
+                      0.17,
+                      1
+                    ])),
+                padding: const EdgeInsets.all(10),
+                child: Column(children: [
+                  const SizedBox(height: 89),
+                  Text(
+                      args.encrypted
+                          ? "Unlock Database"
+                          : "Encrypt Database",
+                      style: TextStyle(
+                          color: textColor,
+                          fontSize: 34,
+                          fontWeight: FontWeight.w200)),
+                  const SizedBox(height: 20),
+                  Text(
+                      args.encrypted
+                          ? "Enter the passphrase of the database"
+                          : "Choose the passphrase used to encrypt the database",
+                      style: TextStyle(
+                          color: secondaryTextColor,
+                          fontSize: 21,
+                          fontWeight: FontWeight.w300)),
+                  const SizedBox(height: 34),
+                  Container(
+                      padding: const EdgeInsets.all(40),
+                      constraints: const BoxConstraints(maxWidth: 500),
+                      child: Form(
+                          key: _formKey,
+                          child: Column(children: [
+                            Wrap(
+                              runSpacing: 10,
+                              children: <Widget>[
+                                TextFormField(
+                                  controller: passCtrl,
+                                  obscureText: true,
+                                  autofocus: true,
+                                  decoration: const InputDecoration(
+                                      icon: Icon(Icons.lock),
+                                      labelText: 'Passphrase'),
+                                  onFieldSubmitted: (_) => unlockPressed(),
+                                  validator: (String? value) {
+                                    if (value == null || value.isEmpty) {
+                                      return 'Cannot be blank';
+                                    }
+                                    return null;
+                                  },
+                                ),
+                                if (!args.encrypted)
+                                  TextFormField(
+                                    obscureText: true,
+                                    decoration: const InputDecoration(
+                                        icon: Icon(Icons.lock_outline),
+                                        labelText: 'Confirm Passphrase'),
+                                    validator: (String? value) {
+                                      if (value != passCtrl.text) {
+                                        return 'Passphrases do not match';
+                                      }
+                                      return null;
+                                    },
+                                  ),
+                                Container(height: 20),
+                                Center(
+                                    child: LoadingScreenButton(
+                                  onPressed:
+                                      !unlocking ? unlockPressed : null,
+                                  text: args.encrypted ? "Unlock" : "Encrypt",
+                                ))
+                              ],
+                            )
+                          ]))),
+                ]),
+              )
+            ])));
+  }
+}
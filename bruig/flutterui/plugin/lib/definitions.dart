@@ -81,6 +81,15 @@ class ServerCert {
       _$ServerCertFromJson(json);
 }
 
+@JsonSerializable()
+class DBNeedsUnlock {
+  final bool encrypted;
+  const DBNeedsUnlock(this.encrypted);
+
+  factory DBNeedsUnlock.fromJson(Map<String, dynamic> json) =>
+      _$DBNeedsUnlockFromJson(json);
+}
+
 const connStateOffline = 0;
 const connStateCheckingWallet = 1;
 const connStateOnline = 2;
@@ -142,8 +151,13 @@ class ChatEvent {
 class PM extends ChatEvent {
   final bool mine;
   final int timestamp;
+  @JsonKey(includeIfNull: false)
+  final String? id;
+  @JsonKey(name: "reply_to", includeIfNull: false)
+  final String? replyTo;
 
-  const PM(sid, msg, this.mine, this.timestamp) : super(sid, msg);
+  const PM(sid, msg, this.mine, this.timestamp, {this.id, this.replyTo})
+      : super(sid, msg);
 
   factory PM.fromJson(Map<String, dynamic> json) => _$PMFromJson(json);
   Map<String, dynamic> toJson() => _$PMToJson(this);
@@ -196,12 +210,31 @@ class GCInvitation extends ChatEvent {
       _$GCInvitationFromJson(json);
 }
 
+@JsonSerializable()
+class GCJoinRejected extends ChatEvent {
+  final RemoteUser inviter;
+  final String gc;
+  final String name;
+  final String reason;
+  GCJoinRejected(this.inviter, this.gc, this.name, this.reason)
+      : super(inviter.uid, "Rejected our request to join GC '$name': $reason");
+
+  factory GCJoinRejected.fromJson(Map<String, dynamic> json) =>
+      _$GCJoinRejectedFromJson(json);
+}
+
 @JsonSerializable()
 class GCMsg extends ChatEvent {
   @JsonKey(name: "sender_uid")
   final String senderUID;
   final int timestamp;
-  const GCMsg(this.senderUID, sid, msg, this.timestamp) : super(sid, msg);
+  @JsonKey(name: "msg_id")
+  final String? msgID;
+  @JsonKey(name: "reply_to")
+  final String? replyTo;
+  const GCMsg(this.senderUID, sid, msg, this.timestamp,
+      {this.msgID, this.replyTo})
+      : super(sid, msg);
 
   factory GCMsg.fromJson(Map<String, dynamic> json) => _$GCMsgFromJson(json);
 }
@@ -210,7 +243,9 @@ class GCMsg extends ChatEvent {
 class GCMsgToSend {
   final String gc;
   final String msg;
-  GCMsgToSend(this.gc, this.msg);
+  @JsonKey(name: "reply_to", includeIfNull: false)
+  final String? replyTo;
+  GCMsgToSend(this.gc, this.msg, {this.replyTo});
   Map<String, dynamic> toJson() => _$GCMsgToSendToJson(this);
 }
 
@@ -1367,6 +1402,10 @@ abstract class PluginPlatform {
     await asyncCall(CTSkipWalletCheck, "");
   }
 
+  Future<void> unlockDB(String passphrase) async {
+    await asyncCall(CTUnlockDB, passphrase);
+  }
+
   Future<void> replyConfServerCert(bool accept) async {
     if (accept) {
       await asyncCall(CTAcceptServerCert, null);
@@ -1390,8 +1429,8 @@ abstract class PluginPlatform {
     return GCAddressBookEntry.fromJson(res);
   }
 
-  Future<void> sendToGC(String gc, String msg) =>
-      asyncCall(CTGCMsg, GCMsgToSend(gc, msg));
+  Future<void> sendToGC(String gc, String msg, {String? replyTo}) =>
+      asyncCall(CTGCMsg, GCMsgToSend(gc, msg, replyTo: replyTo));
 
   Future<List<GCAddressBookEntry>> listGCs() async {
     var res = await asyncCall(CTListGCs, null);
@@ -1829,6 +1868,48 @@ const int CTLNGetNodeInfo = 0x5f;
 const int CTCreateLockFile = 0x60;
 const int CTCloseLockFile = 0x61;
 const int CTSkipWalletCheck = 0x62;
+const int CTUnlockDB = 0x63;
+const int CTSearch = 0x64;
+const int CTReadHistory = 0x65;
+const int CTExportBackup = 0x66;
+const int CTImportBackup = 0x67;
+const int CTListDevices = 0x68;
+const int CTPairDevice = 0x69;
+const int CTImportDevicePairing = 0x6a;
+const int CTRevokeDevice = 0x6b;
+const int CTSetRetention = 0x6c;
+const int CTGetRetention = 0x6d;
+const int CTMarkPMRead = 0x6e;
+const int CTSetPMReceipts = 0x6f;
+const int CTEditMessage = 0x70;
+const int CTRetractMessage = 0x71;
+const int CTGCAddAdmin = 0x72;
+const int CTGCRemoveAdmin = 0x73;
+const int CTGCTransferOwner = 0x74;
+const int CTGCGetMetadata = 0x75;
+const int CTGCSetMetadata = 0x76;
+const int CTGCPinMessage = 0x77;
+const int CTReactToMessage = 0x78;
+const int CTReactToPost = 0x79;
+const int CTGCSetNotifyMode = 0x7a;
+const int CTGCGetNotifyMode = 0x7b;
+const int CTFTFindSources = 0x7c;
+const int CTFTPauseDownload = 0x7d;
+const int CTFTResumeDownload = 0x7e;
+const int CTFTCancelDownload = 0x7f;
+const int CTFTCancelUpload = 0x80;
+const int CTFTSetTransferPriority = 0x81;
+const int CTFTGetTransferPriority = 0x82;
+const int CTFTListUploads = 0x83;
+const int CTFTShareFolder = 0x84;
+const int CTFTUnshareFolder = 0x85;
+const int CTFTSyncSharedFolder = 0x86;
+const int CTFTListSharedFolders = 0x87;
+const int CTFTListSubscribedFolders = 0x88;
+const int CTFTAcceptSharedFolder = 0x89;
+const int CTFTUnsubscribeFolder = 0x8a;
+const int CTFTReclaimContentSpace = 0x8b;
+const int CTMarkGCMsgRead = 0x8c;
 
 const int notificationsStartID = 0x1000;
 
@@ -1859,3 +1940,13 @@ const int NTLNDcrlndStopped = 0x1018;
 const int NTClientStopped = 0x1019;
 const int NTUserPostsList = 0x101a;
 const int NTUserContentList = 0x101b;
+const int NTDBNeedsUnlock = 0x101c;
+const int NTPMReceipt = 0x101d;
+const int NTMessageEdited = 0x101e;
+const int NTMessageRetracted = 0x101f;
+const int NTGCMetadataUpdated = 0x1020;
+const int NTMessageReaction = 0x1021;
+const int NTNotification = 0x1022;
+const int NTFileUploadProgress = 0x1023;
+const int NTSharedFolderUpdated = 0x1024;
+const int NTGCJoinRejected = 0x1025;
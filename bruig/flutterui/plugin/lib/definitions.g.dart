@@ -64,6 +64,16 @@ Map<String, dynamic> _$ServerCertToJson(ServerCert instance) =>
       'outer_fingerprint': instance.outerFingerprint,
     };
 
+DBNeedsUnlock _$DBNeedsUnlockFromJson(Map<String, dynamic> json) =>
+    DBNeedsUnlock(
+      json['encrypted'] as bool,
+    );
+
+Map<String, dynamic> _$DBNeedsUnlockToJson(DBNeedsUnlock instance) =>
+    <String, dynamic>{
+      'encrypted': instance.encrypted,
+    };
+
 ServerSessionState _$ServerSessionStateFromJson(Map<String, dynamic> json) =>
     ServerSessionState(
       json['state'] as int,
@@ -107,14 +117,28 @@ PM _$PMFromJson(Map<String, dynamic> json) => PM(
       json['msg'],
       json['mine'] as bool,
       json['timestamp'] as int,
+      id: json['id'] as String?,
+      replyTo: json['reply_to'] as String?,
     );
 
-Map<String, dynamic> _$PMToJson(PM instance) => <String, dynamic>{
-      'sid': instance.sid,
-      'msg': instance.msg,
-      'mine': instance.mine,
-      'timestamp': instance.timestamp,
-    };
+Map<String, dynamic> _$PMToJson(PM instance) {
+  final val = <String, dynamic>{
+    'sid': instance.sid,
+    'msg': instance.msg,
+    'mine': instance.mine,
+    'timestamp': instance.timestamp,
+  };
+
+  void writeNotNull(String key, dynamic value) {
+    if (value != null) {
+      val[key] = value;
+    }
+  }
+
+  writeNotNull('id', instance.id);
+  writeNotNull('reply_to', instance.replyTo);
+  return val;
+}
 
 InviteToGC _$InviteToGCFromJson(Map<String, dynamic> json) => InviteToGC(
       json['gc'] as String,
@@ -172,11 +196,29 @@ Map<String, dynamic> _$GCInvitationToJson(GCInvitation instance) =>
       'name': instance.name,
     };
 
+GCJoinRejected _$GCJoinRejectedFromJson(Map<String, dynamic> json) =>
+    GCJoinRejected(
+      RemoteUser.fromJson(json['inviter'] as Map<String, dynamic>),
+      json['gc'] as String,
+      json['name'] as String,
+      json['reason'] as String,
+    );
+
+Map<String, dynamic> _$GCJoinRejectedToJson(GCJoinRejected instance) =>
+    <String, dynamic>{
+      'inviter': instance.inviter,
+      'gc': instance.gc,
+      'name': instance.name,
+      'reason': instance.reason,
+    };
+
 GCMsg _$GCMsgFromJson(Map<String, dynamic> json) => GCMsg(
       json['sender_uid'] as String,
       json['sid'],
       json['msg'],
       json['timestamp'] as int,
+      msgID: json['msg_id'] as String?,
+      replyTo: json['reply_to'] as String?,
     );
 
 Map<String, dynamic> _$GCMsgToJson(GCMsg instance) => <String, dynamic>{
@@ -184,18 +226,31 @@ Map<String, dynamic> _$GCMsgToJson(GCMsg instance) => <String, dynamic>{
       'msg': instance.msg,
       'sender_uid': instance.senderUID,
       'timestamp': instance.timestamp,
+      'msg_id': instance.msgID,
+      'reply_to': instance.replyTo,
     };
 
 GCMsgToSend _$GCMsgToSendFromJson(Map<String, dynamic> json) => GCMsgToSend(
       json['gc'] as String,
       json['msg'] as String,
+      replyTo: json['reply_to'] as String?,
     );
 
-Map<String, dynamic> _$GCMsgToSendToJson(GCMsgToSend instance) =>
-    <String, dynamic>{
-      'gc': instance.gc,
-      'msg': instance.msg,
-    };
+Map<String, dynamic> _$GCMsgToSendToJson(GCMsgToSend instance) {
+  final val = <String, dynamic>{
+    'gc': instance.gc,
+    'msg': instance.msg,
+  };
+
+  void writeNotNull(String key, dynamic value) {
+    if (value != null) {
+      val[key] = value;
+    }
+  }
+
+  writeNotNull('reply_to', instance.replyTo);
+  return val;
+}
 
 GCRemoveUserArgs _$GCRemoveUserArgsFromJson(Map<String, dynamic> json) =>
     GCRemoveUserArgs(
@@ -160,6 +160,10 @@ mixin BaseDesktopPlatform on NtfStreams {
         ntfConfs.add(ConfNotification(cmd, ServerCert.fromJson(payload)));
         break;
 
+      case NTDBNeedsUnlock:
+        ntfConfs.add(ConfNotification(cmd, DBNeedsUnlock.fromJson(payload)));
+        break;
+
       case NTServerSessChanged:
         ntfServerSess.add(ServerSessionState.fromJson(payload));
         break;
@@ -179,6 +183,10 @@ mixin BaseDesktopPlatform on NtfStreams {
             evnt.uid, evnt.gc, "Accepted our invitation to join the GC"));
         break;
 
+      case NTGCJoinRejected:
+        ntfChatEvents.add(GCJoinRejected.fromJson(payload));
+        break;
+
       case NTGCListUpdated:
         var gc = GCAddressBookEntry.fromJson(payload);
         ntfGCListUpdates.add(gc);
@@ -294,7 +294,8 @@ class MockPlugin with NtfStreams /*implements PluginPlatform*/ {
 
   Future<List<GCAddressBookEntry>> listGCs() async => throw "unimplemented";
 
-  Future<ChatEvent> sendToGC(String gc, String msg) async {
+  Future<ChatEvent> sendToGC(String gc, String msg,
+      {String? replyTo}) async {
     throw "unimplemented";
 
     /*
@@ -8,6 +8,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"os"
 	"path/filepath"
 	"strings"
 	"sync"
@@ -31,6 +32,7 @@ import (
 
 type clientCtx struct {
 	c      *client.Client
+	db     *clientdb.DB
 	lnpc   *client.DcrlnPaymentClient
 	ctx    context.Context
 	cancel func()
@@ -92,6 +94,10 @@ func handleInitClient(handle uint32, args InitClient) error {
 		DownloadsRoot: args.DownloadsDir,
 		Logger:        logBknd.logger("FDDB"),
 		ChunkSize:     rpc.MaxChunkSize,
+		Encrypt:       args.EncryptDB,
+		Driver:        args.DBDriver,
+
+		ContentDefinedChunking: args.ContentDefinedChunking,
 	})
 	if err != nil {
 		return fmt.Errorf("unable to initialize DB: %v", err)
@@ -127,6 +133,8 @@ func handleInitClient(handle uint32, args InitClient) error {
 		ReconnectDelay: 5 * time.Second,
 		CompressLevel:  4,
 
+		MultiSourceDownloads: args.MultiSourceDownloads,
+
 		CertConfirmer: func(ctx context.Context, cs *tls.ConnectionState,
 			svrID *zkidentity.PublicIdentity) error {
 
@@ -211,10 +219,26 @@ func handleInitClient(handle uint32, args InitClient) error {
 		},
 
 		PMHandler: func(user *client.RemoteUser, msg rpc.RMPrivateMessage, ts time.Time) {
-			pm := PM{UID: user.ID(), Msg: msg.Message, TimeStamp: ts.Unix()}
+			pm := PM{
+				UID:       user.ID(),
+				Msg:       msg.Message,
+				TimeStamp: ts.Unix(),
+				ID:        msg.ID,
+				ReplyTo:   msg.ReplyTo,
+			}
 			notify(NTPM, pm, nil)
 		},
 
+		PMReceiptHandler: func(user *client.RemoteUser, receipt rpc.RMReceipt) {
+			r := PMReceipt{
+				UID:       user.ID(),
+				ID:        receipt.ID,
+				Status:    receipt.Status,
+				TimeStamp: receipt.Timestamp,
+			}
+			notify(NTPMReceipt, r, nil)
+		},
+
 		GCInviteHandler: func(user *client.RemoteUser, iid uint64, invite rpc.RMGroupInvite) {
 			pubid := user.PublicIdentity()
 			inv := GCInvitation{
@@ -230,6 +254,17 @@ func handleInitClient(handle uint32, args InitClient) error {
 			notify(NTUserAcceptedGCInvite, inv, nil)
 		},
 
+		GCJoinRejected: func(user *client.RemoteUser, invite rpc.RMGroupInvite, reason string) {
+			pubid := user.PublicIdentity()
+			rej := GCJoinRejected{
+				Inviter: remoteUserFromPII(&pubid),
+				GC:      invite.ID,
+				Name:    invite.Name,
+				Reason:  reason,
+			}
+			notify(NTGCJoinRejected, rej, nil)
+		},
+
 		GCListUpdated: func(gc clientdb.GCAddressBookEntry) {
 			name, err := c.GetGCAlias(gc.ID)
 			if err != nil {
@@ -243,16 +278,71 @@ func handleInitClient(handle uint32, args InitClient) error {
 			notify(NTGCListUpdated, gce, nil)
 		},
 
+		GCMetadataUpdated: func(user *client.RemoteUser, md rpc.RMGroupMetadata) {
+			notify(NTGCMetadataUpdated, gcMetadataFromRM(&md), nil)
+		},
+
 		GCMsgHandler: func(user *client.RemoteUser, msg rpc.RMGroupMessage, ts time.Time) {
 			gcm := GCMessage{
 				SenderUID: user.ID(),
 				ID:        msg.ID.String(),
 				Msg:       msg.Message,
 				TimeStamp: ts.Unix(),
+				MsgID:     msg.MsgID,
+				ReplyTo:   msg.ReplyTo,
+				Mentions:  msg.Mentions,
 			}
 			notify(NTGCMessage, gcm, nil)
 		},
 
+		NotificationHandler: func(n client.Notification) {
+			v := Notification{
+				Type:      string(n.Type),
+				From:      n.From,
+				FromNick:  n.FromNick,
+				GC:        n.GC,
+				MsgID:     n.MsgID,
+				Message:   n.Message,
+				TimeStamp: n.Timestamp.Unix(),
+			}
+			notify(NTNotification, v, nil)
+		},
+
+		MessageEditHandler: func(user *client.RemoteUser, edit rpc.RMMessageEdit, ts time.Time) {
+			mu := MessageUpdate{
+				UID:       user.ID(),
+				GC:        edit.GC,
+				ID:        edit.ID,
+				Msg:       edit.Message,
+				TimeStamp: ts.Unix(),
+			}
+			notify(NTMessageEdited, mu, nil)
+		},
+
+		MessageRetractHandler: func(user *client.RemoteUser, retract rpc.RMMessageRetract, ts time.Time) {
+			mu := MessageUpdate{
+				UID:       user.ID(),
+				GC:        retract.GC,
+				ID:        retract.ID,
+				TimeStamp: ts.Unix(),
+			}
+			notify(NTMessageRetracted, mu, nil)
+		},
+
+		MessageReactionHandler: func(user *client.RemoteUser, reaction rpc.RMMessageReaction,
+			reactions clientdb.Reactions, ts time.Time) {
+			mr := MessageReaction{
+				UID:       user.ID(),
+				GC:        reaction.GC,
+				ID:        reaction.ID,
+				Reaction:  reaction.Reaction,
+				Remove:    reaction.Remove,
+				Reactions: reactions,
+				TimeStamp: ts.Unix(),
+			}
+			notify(NTMessageReaction, mr, nil)
+		},
+
 		KXCompleted: func(user *client.RemoteUser) {
 			pii := user.PublicIdentity()
 			notify(NTKXCompleted, remoteUserFromPII(&pii), nil)
@@ -348,6 +438,20 @@ func handleInitClient(handle uint32, args InitClient) error {
 			notify(NTFileDownloadProgress, fdp, nil)
 		},
 
+		FileUploadProgress: func(user *client.RemoteUser, fm rpc.FileMetadata, chunkIdx int) {
+			fup := FileUploadProgress{
+				UID:      user.ID(),
+				FID:      fm.MetadataHash(),
+				Metadata: fm,
+				ChunkIdx: chunkIdx,
+			}
+			notify(NTFileUploadProgress, fup, nil)
+		},
+
+		SharedFolderUpdated: func(user *client.RemoteUser, sf clientdb.SubscribedFolder) {
+			notify(NTSharedFolderUpdated, sf, nil)
+		},
+
 		FileDownloadCompleted: func(user *client.RemoteUser,
 			fm rpc.FileMetadata, diskPath string) {
 			rf := clientdb.RemoteFile{
@@ -367,6 +471,7 @@ func handleInitClient(handle uint32, args InitClient) error {
 	ctx, cancel := context.WithCancel(context.Background())
 	cctx = &clientCtx{
 		c:      c,
+		db:     db,
 		lnpc:   lnpc,
 		ctx:    ctx,
 		cancel: cancel,
@@ -382,6 +487,12 @@ func handleInitClient(handle uint32, args InitClient) error {
 	cs[handle] = cctx
 
 	go func() {
+		// The client only starts running after the db is unlocked
+		// with CTUnlockDB.
+		if db.NeedsUnlock() {
+			notify(NTDBNeedsUnlock, DBNeedsUnlock{Encrypted: db.IsEncrypted()}, nil)
+		}
+
 		err := c.Run(ctx)
 		if errors.Is(err, context.Canceled) {
 			err = nil
@@ -455,8 +566,10 @@ func handleClientCmd(cc *clientCtx, cmd *cmd) (interface{}, error) {
 			return nil, err
 		}
 
-		err := c.PM(pm.UID, pm.Msg)
-		return nil, err
+		if !pm.ReplyTo.IsEmpty() {
+			return c.PMReply(pm.UID, pm.ReplyTo, pm.Msg)
+		}
+		return c.PMWithID(pm.UID, pm.Msg)
 
 	case CTAddressBook:
 		return c.AddressBook(), nil
@@ -473,6 +586,13 @@ func handleClientCmd(cc *clientCtx, cmd *cmd) (interface{}, error) {
 		cc.certConfChan <- true
 		return nil, nil
 
+	case CTUnlockDB:
+		var pass string
+		if err := cmd.decode(&pass); err != nil {
+			return nil, err
+		}
+		return nil, cc.db.UnlockDB([]byte(pass))
+
 	case CTRejectServerCert:
 		cc.certConfChan <- false
 		return nil, nil
@@ -526,7 +646,15 @@ func handleClientCmd(cc *clientCtx, cmd *cmd) (interface{}, error) {
 		if err := cmd.decode(&gcm); err != nil {
 			return nil, err
 		}
-		return nil, c.GCMessage(gcm.GC, gcm.Msg, rpc.MessageModeNormal, nil)
+		if len(gcm.Mentions) > 0 {
+			return c.GCMessageWithMentions(gcm.GC, gcm.ReplyTo, gcm.Msg,
+				rpc.MessageModeNormal, gcm.Mentions, nil)
+		}
+		if !gcm.ReplyTo.IsEmpty() {
+			return c.GCMessageReply(gcm.GC, gcm.ReplyTo, gcm.Msg,
+				rpc.MessageModeNormal, nil)
+		}
+		return c.GCMessageWithID(gcm.GC, gcm.Msg, rpc.MessageModeNormal, nil)
 
 	case CTListGCs:
 		gcl, err := c.ListGCs()
@@ -1175,6 +1303,328 @@ func handleClientCmd(cc *clientCtx, cmd *cmd) (interface{}, error) {
 
 	case CTSkipWalletCheck:
 		go func() { cc.skipWalletCheckChan <- struct{}{} }()
+
+	case CTSearch:
+		var args SearchArgs
+		if err := cmd.decode(&args); err != nil {
+			return nil, err
+		}
+		return c.Search(args.Query, args.Filters)
+
+	case CTReadHistory:
+		var args ReadHistoryArgs
+		if err := cmd.decode(&args); err != nil {
+			return nil, err
+		}
+		if args.IsGC {
+			return c.ReadGCHistory(args.ID, args.Before, args.Limit)
+		}
+		return c.ReadPMHistory(args.ID, args.Before, args.Limit)
+
+	case CTExportBackup:
+		var args BackupArgs
+		if err := cmd.decode(&args); err != nil {
+			return nil, err
+		}
+		f, err := os.OpenFile(args.Filepath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+		if err != nil {
+			return nil, err
+		}
+		err = c.ExportBackup(f, []byte(args.Passphrase))
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		return nil, err
+
+	case CTImportBackup:
+		var args BackupArgs
+		if err := cmd.decode(&args); err != nil {
+			return nil, err
+		}
+		f, err := os.Open(args.Filepath)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return nil, c.ImportBackup(f, []byte(args.Passphrase))
+
+	case CTListDevices:
+		return c.ListDevices()
+
+	case CTPairDevice:
+		var args PairDeviceArgs
+		if err := cmd.decode(&args); err != nil {
+			return nil, err
+		}
+		f, err := os.OpenFile(args.Filepath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+		if err != nil {
+			return nil, err
+		}
+		link, err := c.PairDevice(args.Name, f, []byte(args.Passphrase))
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		return link, err
+
+	case CTImportDevicePairing:
+		var args BackupArgs
+		if err := cmd.decode(&args); err != nil {
+			return nil, err
+		}
+		f, err := os.Open(args.Filepath)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return nil, c.ImportDevicePairing(f, []byte(args.Passphrase))
+
+	case CTRevokeDevice:
+		var id zkidentity.ShortID
+		if err := cmd.decode(&id); err != nil {
+			return nil, err
+		}
+		return nil, c.RevokeDevice(id)
+
+	case CTSetRetention:
+		var args RetentionArgs
+		if err := cmd.decode(&args); err != nil {
+			return nil, err
+		}
+		if args.IsGC {
+			return nil, c.SetGCRetention(args.ID, args.Policy)
+		}
+		return nil, c.SetPMRetention(args.ID, args.Policy)
+
+	case CTGetRetention:
+		var args RetentionArgs
+		if err := cmd.decode(&args); err != nil {
+			return nil, err
+		}
+		if args.IsGC {
+			gc, err := c.GetGC(args.ID)
+			return gc.Retention, err
+		}
+		return c.PMRetention(args.ID)
+
+	case CTMarkPMRead:
+		var args MarkPMReadArgs
+		if err := cmd.decode(&args); err != nil {
+			return nil, err
+		}
+		return nil, c.MarkPMRead(args.UID, args.ID)
+
+	case CTMarkGCMsgRead:
+		var args MarkGCMsgReadArgs
+		if err := cmd.decode(&args); err != nil {
+			return nil, err
+		}
+		return nil, c.MarkGCMsgRead(args.GC, args.ID)
+
+	case CTSetPMReceipts:
+		var args PMReceiptsArgs
+		if err := cmd.decode(&args); err != nil {
+			return nil, err
+		}
+		return nil, c.SetPMReceipts(args.UID, args.Send)
+
+	case CTEditMessage:
+		var args MessageEditArgs
+		if err := cmd.decode(&args); err != nil {
+			return nil, err
+		}
+		if args.GC.IsEmpty() {
+			return nil, c.EditPM(args.UID, args.ID, args.Msg)
+		}
+		return nil, c.EditGCMessage(args.GC, args.ID, args.Msg)
+
+	case CTRetractMessage:
+		var args MessageEditArgs
+		if err := cmd.decode(&args); err != nil {
+			return nil, err
+		}
+		if args.GC.IsEmpty() {
+			return nil, c.RetractPM(args.UID, args.ID)
+		}
+		return nil, c.RetractGCMessage(args.GC, args.ID)
+
+	case CTGCAddAdmin:
+		var args GCAdminArgs
+		if err := cmd.decode(&args); err != nil {
+			return nil, err
+		}
+		return nil, c.AddGCAdmin(args.GC, args.UID, args.Moderator)
+
+	case CTGCRemoveAdmin:
+		var args GCAdminArgs
+		if err := cmd.decode(&args); err != nil {
+			return nil, err
+		}
+		return nil, c.RemoveGCAdmin(args.GC, args.UID)
+
+	case CTGCTransferOwner:
+		var args GCAdminArgs
+		if err := cmd.decode(&args); err != nil {
+			return nil, err
+		}
+		return nil, c.TransferGCOwnership(args.GC, args.UID)
+
+	case CTGCGetMetadata:
+		var args zkidentity.ShortID
+		if err := cmd.decode(&args); err != nil {
+			return nil, err
+		}
+		md, err := c.GetGCMetadata(args)
+		if err != nil {
+			return nil, err
+		}
+		return gcMetadataFromRM(&md), nil
+
+	case CTGCSetMetadata:
+		var args GCMetadata
+		if err := cmd.decode(&args); err != nil {
+			return nil, err
+		}
+		return nil, c.ModifyGCMetadata(args.GC, func(md *rpc.RMGroupMetadata) error {
+			md.Topic = args.Topic
+			md.Description = args.Description
+			md.Rules = args.Rules
+			md.AvatarHash = args.AvatarHash
+			return nil
+		})
+
+	case CTGCPinMessage:
+		var args GCPinMessageArgs
+		if err := cmd.decode(&args); err != nil {
+			return nil, err
+		}
+		return nil, c.PinGCMessage(args.GC, args.ID, args.Pin)
+
+	case CTReactToMessage:
+		var args MessageReactionArgs
+		if err := cmd.decode(&args); err != nil {
+			return nil, err
+		}
+		if args.GC.IsEmpty() {
+			return nil, c.ReactToPM(args.UID, args.ID, args.Reaction, args.Remove)
+		}
+		return nil, c.ReactToGCMessage(args.GC, args.ID, args.Reaction, args.Remove)
+
+	case CTReactToPost:
+		var args PostReactionArgs
+		if err := cmd.decode(&args); err != nil {
+			return nil, err
+		}
+		return nil, c.ReactToPost(args.From, args.PID, args.Reaction, args.Remove)
+
+	case CTGCSetNotifyMode:
+		var args GCNotifyModeArgs
+		if err := cmd.decode(&args); err != nil {
+			return nil, err
+		}
+		return nil, c.SetGCNotifyMode(args.GC, args.Mode)
+
+	case CTGCGetNotifyMode:
+		var args zkidentity.ShortID
+		if err := cmd.decode(&args); err != nil {
+			return nil, err
+		}
+		return c.GetGCNotifyMode(args)
+
+	case CTFTFindSources:
+		var fid zkidentity.ShortID
+		if err := cmd.decode(&fid); err != nil {
+			return nil, err
+		}
+		return nil, c.FindFileSources(fid)
+
+	case CTFTPauseDownload:
+		var fid zkidentity.ShortID
+		if err := cmd.decode(&fid); err != nil {
+			return nil, err
+		}
+		return nil, c.PauseDownload(fid)
+
+	case CTFTResumeDownload:
+		var fid zkidentity.ShortID
+		if err := cmd.decode(&fid); err != nil {
+			return nil, err
+		}
+		return nil, c.ResumeDownload(fid)
+
+	case CTFTCancelDownload:
+		var fid zkidentity.ShortID
+		if err := cmd.decode(&fid); err != nil {
+			return nil, err
+		}
+		return nil, c.CancelDownload(fid)
+
+	case CTFTCancelUpload:
+		var args CancelUploadArgs
+		if err := cmd.decode(&args); err != nil {
+			return nil, err
+		}
+		return nil, c.CancelUpload(args.UID, args.FID)
+
+	case CTFTSetTransferPriority:
+		var args TransferPriorityArgs
+		if err := cmd.decode(&args); err != nil {
+			return nil, err
+		}
+		return nil, c.SetTransferPriority(args.FID, args.Priority)
+
+	case CTFTGetTransferPriority:
+		var fid zkidentity.ShortID
+		if err := cmd.decode(&fid); err != nil {
+			return nil, err
+		}
+		return c.GetTransferPriority(fid)
+
+	case CTFTListUploads:
+		return c.ListUploads()
+
+	case CTFTShareFolder:
+		var args ShareFolderArgs
+		if err := cmd.decode(&args); err != nil {
+			return nil, err
+		}
+		return c.ShareFolder(args.Dir, args.UID, args.GC, args.Cost)
+
+	case CTFTUnshareFolder:
+		var id zkidentity.ShortID
+		if err := cmd.decode(&id); err != nil {
+			return nil, err
+		}
+		return nil, c.UnshareFolder(id)
+
+	case CTFTSyncSharedFolder:
+		var id zkidentity.ShortID
+		if err := cmd.decode(&id); err != nil {
+			return nil, err
+		}
+		return nil, c.SyncSharedFolder(id)
+
+	case CTFTListSharedFolders:
+		return c.ListSharedFolders()
+
+	case CTFTListSubscribedFolders:
+		return c.ListSubscribedFolders()
+
+	case CTFTAcceptSharedFolder:
+		var args SubscribedFolderArgs
+		if err := cmd.decode(&args); err != nil {
+			return nil, err
+		}
+		return nil, c.AcceptSharedFolder(args.Owner, args.ID, args.LocalDir)
+
+	case CTFTUnsubscribeFolder:
+		var args SubscribedFolderArgs
+		if err := cmd.decode(&args); err != nil {
+			return nil, err
+		}
+		return nil, c.RemoveSubscribedFolder(args.Owner, args.ID)
+
+	case CTFTReclaimContentSpace:
+		return c.ReclaimContentSpace()
 	}
 
 	return nil, nil
@@ -96,6 +96,48 @@ const (
 	CTCreateLockFile                  = 0x60
 	CTCloseLockFile                   = 0x61
 	CTSkipWalletCheck                 = 0x62
+	CTUnlockDB                        = 0x63
+	CTSearch                          = 0x64
+	CTReadHistory                     = 0x65
+	CTExportBackup                    = 0x66
+	CTImportBackup                    = 0x67
+	CTListDevices                     = 0x68
+	CTPairDevice                      = 0x69
+	CTImportDevicePairing             = 0x6a
+	CTRevokeDevice                    = 0x6b
+	CTSetRetention                    = 0x6c
+	CTGetRetention                    = 0x6d
+	CTMarkPMRead                      = 0x6e
+	CTSetPMReceipts                   = 0x6f
+	CTEditMessage                     = 0x70
+	CTRetractMessage                  = 0x71
+	CTGCAddAdmin                      = 0x72
+	CTGCRemoveAdmin                   = 0x73
+	CTGCTransferOwner                 = 0x74
+	CTGCGetMetadata                   = 0x75
+	CTGCSetMetadata                   = 0x76
+	CTGCPinMessage                    = 0x77
+	CTReactToMessage                  = 0x78
+	CTReactToPost                     = 0x79
+	CTGCSetNotifyMode                 = 0x7a
+	CTGCGetNotifyMode                 = 0x7b
+	CTFTFindSources                   = 0x7c
+	CTFTPauseDownload                 = 0x7d
+	CTFTResumeDownload                = 0x7e
+	CTFTCancelDownload                = 0x7f
+	CTFTCancelUpload                  = 0x80
+	CTFTSetTransferPriority           = 0x81
+	CTFTGetTransferPriority           = 0x82
+	CTFTListUploads                   = 0x83
+	CTFTShareFolder                   = 0x84
+	CTFTUnshareFolder                 = 0x85
+	CTFTSyncSharedFolder              = 0x86
+	CTFTListSharedFolders             = 0x87
+	CTFTListSubscribedFolders         = 0x88
+	CTFTAcceptSharedFolder            = 0x89
+	CTFTUnsubscribeFolder             = 0x8a
+	CTFTReclaimContentSpace           = 0x8b
+	CTMarkGCMsgRead                   = 0x8c
 
 	NTInviteReceived         = 0x1001
 	NTInviteAccepted         = 0x1002
@@ -124,6 +166,16 @@ const (
 	NTClientStopped          = 0x1019
 	NTUserPostsList          = 0x101a
 	NTUserContentList        = 0x101b
+	NTDBNeedsUnlock          = 0x101c
+	NTPMReceipt              = 0x101d
+	NTMessageEdited          = 0x101e
+	NTMessageRetracted       = 0x101f
+	NTGCMetadataUpdated      = 0x1020
+	NTMessageReaction        = 0x1021
+	NTNotification           = 0x1022
+	NTFileUploadProgress     = 0x1023
+	NTSharedFolderUpdated    = 0x1024
+	NTGCJoinRejected         = 0x1025
 )
 
 type cmd struct {
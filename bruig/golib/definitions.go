@@ -20,6 +20,17 @@ type InitClient struct {
 	MsgsRoot       string `json:"msgs_root"`
 	DebugLevel     string `json:"debug_level"`
 	WantsLogNtfns  bool   `json:"wants_log_ntfns"`
+	EncryptDB      bool   `json:"encrypt_db"`
+	DBDriver       string `json:"db_driver"`
+
+	MultiSourceDownloads   bool `json:"multi_source_downloads"`
+	ContentDefinedChunking bool `json:"content_defined_chunking"`
+}
+
+type DBNeedsUnlock struct {
+	// Encrypted is false when the db is not yet encrypted and the
+	// passphrase sent in CTUnlockDB will be used to encrypt it.
+	Encrypted bool `json:"encrypted"`
 }
 
 type IDInit struct {
@@ -49,10 +60,19 @@ type ServerSessState struct {
 }
 
 type PM struct {
-	UID       clientintf.UserID `json:"sid"` // sid == source id
-	Msg       string            `json:"msg"`
-	Mine      bool              `json:"mine"`
-	TimeStamp int64             `json:"timestamp"`
+	UID       clientintf.UserID  `json:"sid"` // sid == source id
+	Msg       string             `json:"msg"`
+	Mine      bool               `json:"mine"`
+	TimeStamp int64              `json:"timestamp"`
+	ID        zkidentity.ShortID `json:"id"`
+	ReplyTo   zkidentity.ShortID `json:"reply_to"`
+}
+
+type PMReceipt struct {
+	UID       clientintf.UserID  `json:"uid"`
+	ID        zkidentity.ShortID `json:"id"`
+	Status    rpc.ReceiptStatus  `json:"status"`
+	TimeStamp int64              `json:"timestamp"`
 }
 
 type RemoteUser struct {
@@ -86,16 +106,43 @@ type GCInvitation struct {
 	Name    string     `json:"name"`
 }
 
+type GCJoinRejected struct {
+	Inviter RemoteUser         `json:"inviter"`
+	GC      zkidentity.ShortID `json:"gc"`
+	Name    string             `json:"name"`
+	Reason  string             `json:"reason"`
+}
+
 type GCMessage struct {
-	SenderUID clientdb.UserID `json:"sender_uid"`
-	ID        string          `json:"sid"` // sid == source id == gc name
-	Msg       string          `json:"msg"`
-	TimeStamp int64           `json:"timestamp"`
+	SenderUID clientdb.UserID    `json:"sender_uid"`
+	ID        string             `json:"sid"` // sid == source id == gc name
+	Msg       string             `json:"msg"`
+	TimeStamp int64              `json:"timestamp"`
+	MsgID     zkidentity.ShortID `json:"msg_id"`
+	ReplyTo   zkidentity.ShortID `json:"reply_to"`
+	Mentions  []clientdb.UserID  `json:"mentions"`
 }
 
 type GCMessageToSend struct {
-	GC  zkidentity.ShortID `json:"gc"`
-	Msg string             `json:"msg"`
+	GC       zkidentity.ShortID  `json:"gc"`
+	Msg      string              `json:"msg"`
+	ReplyTo  zkidentity.ShortID  `json:"reply_to"`
+	Mentions []clientintf.UserID `json:"mentions"`
+}
+
+type GCNotifyModeArgs struct {
+	GC   zkidentity.ShortID    `json:"gc"`
+	Mode clientdb.GCNotifyMode `json:"mode"`
+}
+
+type Notification struct {
+	Type      string             `json:"type"`
+	From      clientintf.UserID  `json:"from"`
+	FromNick  string             `json:"from_nick"`
+	GC        zkidentity.ShortID `json:"gc"`
+	MsgID     zkidentity.ShortID `json:"msg_id"`
+	Message   string             `json:"message"`
+	TimeStamp int64              `json:"timestamp"`
 }
 
 type GCRemoveUserArgs struct {
@@ -167,6 +214,36 @@ type FileDownloadProgress struct {
 	NbMissingChunks int               `json:"nb_missing_chunks"`
 }
 
+type FileUploadProgress struct {
+	UID      clientintf.UserID `json:"uid"`
+	FID      clientdb.FileID   `json:"fid"`
+	Metadata rpc.FileMetadata  `json:"metadata"`
+	ChunkIdx int               `json:"chunk_idx"`
+}
+
+type CancelUploadArgs struct {
+	UID clientintf.UserID `json:"uid"`
+	FID clientdb.FileID   `json:"fid"`
+}
+
+type TransferPriorityArgs struct {
+	FID      clientdb.FileID           `json:"fid"`
+	Priority clientdb.TransferPriority `json:"priority"`
+}
+
+type ShareFolderArgs struct {
+	Dir  string              `json:"dir"`
+	UID  *clientintf.UserID  `json:"uid,omitempty"`
+	GC   *zkidentity.ShortID `json:"gc,omitempty"`
+	Cost uint64              `json:"cost"`
+}
+
+type SubscribedFolderArgs struct {
+	Owner    clientintf.UserID  `json:"owner"`
+	ID       zkidentity.ShortID `json:"id"`
+	LocalDir string             `json:"local_dir,omitempty"`
+}
+
 type LNBalances struct {
 	Channel *lnrpc.ChannelBalanceResponse `json:"channel"`
 	Wallet  *lnrpc.WalletBalanceResponse  `json:"wallet"`
@@ -248,3 +325,121 @@ type LocalRenameArgs struct {
 	NewName string             `json:"new_name"`
 	IsGC    bool               `json:"is_gc"`
 }
+
+type SearchArgs struct {
+	Query   string                 `json:"query"`
+	Filters clientdb.SearchFilters `json:"filters"`
+}
+
+type ReadHistoryArgs struct {
+	ID     zkidentity.ShortID `json:"id"`
+	IsGC   bool               `json:"is_gc"`
+	Before uint64             `json:"before"`
+	Limit  int                `json:"limit"`
+}
+
+type BackupArgs struct {
+	Filepath   string `json:"filepath"`
+	Passphrase string `json:"passphrase"`
+}
+
+type PairDeviceArgs struct {
+	Name       string `json:"name"`
+	Filepath   string `json:"filepath"`
+	Passphrase string `json:"passphrase"`
+}
+
+type RetentionArgs struct {
+	ID     zkidentity.ShortID  `json:"id"`
+	IsGC   bool                `json:"is_gc"`
+	Policy rpc.RetentionPolicy `json:"policy"`
+}
+
+type MarkPMReadArgs struct {
+	UID clientintf.UserID  `json:"uid"`
+	ID  zkidentity.ShortID `json:"id"`
+}
+
+type MarkGCMsgReadArgs struct {
+	GC zkidentity.ShortID `json:"gc"`
+	ID zkidentity.ShortID `json:"id"`
+}
+
+type PMReceiptsArgs struct {
+	UID  clientintf.UserID `json:"uid"`
+	Send bool              `json:"send"`
+}
+
+type MessageEditArgs struct {
+	UID clientintf.UserID  `json:"uid"`
+	GC  zkidentity.ShortID `json:"gc"`
+	ID  zkidentity.ShortID `json:"id"`
+	Msg string             `json:"msg"`
+}
+
+type MessageUpdate struct {
+	UID       clientintf.UserID  `json:"uid"`
+	GC        zkidentity.ShortID `json:"gc"`
+	ID        zkidentity.ShortID `json:"id"`
+	Msg       string             `json:"msg"`
+	TimeStamp int64              `json:"timestamp"`
+}
+
+type MessageReactionArgs struct {
+	UID      clientintf.UserID  `json:"uid"`
+	GC       zkidentity.ShortID `json:"gc"`
+	ID       zkidentity.ShortID `json:"id"`
+	Reaction string             `json:"reaction"`
+	Remove   bool               `json:"remove"`
+}
+
+type PostReactionArgs struct {
+	From     clientintf.UserID `json:"from"`
+	PID      clientintf.PostID `json:"pid"`
+	Reaction string            `json:"reaction"`
+	Remove   bool              `json:"remove"`
+}
+
+type MessageReaction struct {
+	UID       clientintf.UserID              `json:"uid"`
+	GC        zkidentity.ShortID             `json:"gc"`
+	ID        zkidentity.ShortID             `json:"id"`
+	Reaction  string                         `json:"reaction"`
+	Remove    bool                           `json:"remove"`
+	Reactions map[string][]clientintf.UserID `json:"reactions"`
+	TimeStamp int64                          `json:"timestamp"`
+}
+
+type GCAdminArgs struct {
+	GC        zkidentity.ShortID `json:"gc"`
+	UID       clientintf.UserID  `json:"uid"`
+	Moderator bool               `json:"moderator"`
+}
+
+type GCMetadata struct {
+	GC          zkidentity.ShortID   `json:"gc"`
+	Generation  uint64               `json:"generation"`
+	Topic       string               `json:"topic"`
+	Description string               `json:"description"`
+	Rules       string               `json:"rules"`
+	AvatarHash  []byte               `json:"avatar_hash"`
+	Pinned      []zkidentity.ShortID `json:"pinned"`
+}
+
+func gcMetadataFromRM(md *rpc.RMGroupMetadata) GCMetadata {
+	return GCMetadata{
+		GC:          md.ID,
+		Generation:  md.Generation,
+		Topic:       md.Topic,
+		Description: md.Description,
+		Rules:       md.Rules,
+		AvatarHash:  md.AvatarHash,
+		Pinned:      md.Pinned,
+	}
+}
+
+type GCPinMessageArgs struct {
+	GC  zkidentity.ShortID `json:"gc"`
+	ID  zkidentity.ShortID `json:"id"`
+	Pin bool               `json:"pin"`
+}
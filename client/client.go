@@ -94,10 +94,22 @@ type Config struct {
 	// GCJoinHandler is called when a user has joined a GC we administer.
 	GCJoinHandler func(user *RemoteUser, gc clientdb.GCAddressBookEntry)
 
+	// GCJoinRejected is called when the admin of a GC rejected our
+	// acceptance of an invite to join the GC.
+	GCJoinRejected func(user *RemoteUser, invite rpc.RMGroupInvite, reason string)
+
+	// GCJoinRequested is called when a user redeemed a GC join link that
+	// requires approval from the local client.
+	GCJoinRequested func(req clientdb.GCJoinRequest)
+
 	// GCListUpdated is called when we receive remote updates for a GC from
 	// the GC admin.
 	GCListUpdated func(gc clientdb.GCAddressBookEntry)
 
+	// GCMetadataUpdated is called when a GC admin changes the metadata
+	// (topic, description, pinned messages, etc) of a GC.
+	GCMetadataUpdated func(user *RemoteUser, md rpc.RMGroupMetadata)
+
 	// GCUserParted is called when a user was removed from a GC. Kicked
 	// signals whether it was the user that left or whether the admin
 	// kicked the user.
@@ -110,10 +122,38 @@ type Config struct {
 	// the specified user.
 	GCMsgHandler func(user *RemoteUser, msg rpc.RMGroupMessage, ts time.Time)
 
+	// NotificationHandler is called for received messages that should be
+	// notified to the local user (for example, with a desktop alert),
+	// according to the notification policy of the conversation.
+	NotificationHandler func(n Notification)
+
 	// GCWithUnkxdMember is called when an attempt to send a GC message
 	// failed due to a GC member being unkxd with the local client.
 	GCWithUnkxdMember func(gcid GCID, uid UserID)
 
+	// GCMeshKXProgress is called after each request to mediate KX with a
+	// GC member that is not KX'd with the local client.
+	GCMeshKXProgress func(progress GCMeshKXProgress)
+
+	// PMReceiptHandler is called when a remote user sends a receipt for a
+	// PM sent by the local client.
+	PMReceiptHandler func(user *RemoteUser, receipt rpc.RMReceipt)
+
+	// MessageEditHandler is called when a remote user edits a PM or GC
+	// message they previously sent.
+	MessageEditHandler func(user *RemoteUser, edit rpc.RMMessageEdit, ts time.Time)
+
+	// MessageRetractHandler is called when a remote user retracts a PM or
+	// GC message they previously sent.
+	MessageRetractHandler func(user *RemoteUser, retract rpc.RMMessageRetract, ts time.Time)
+
+	// MessageReactionHandler is called when a remote user adds or removes
+	// a reaction to a PM or GC message. Reactions contains all the
+	// reactions to the message, when the message is stored in the local
+	// history.
+	MessageReactionHandler func(user *RemoteUser, reaction rpc.RMMessageReaction,
+		reactions clientdb.Reactions, ts time.Time)
+
 	// KXCompleted is called when a KX processed completed with a remote
 	// user.
 	KXCompleted func(user *RemoteUser)
@@ -122,6 +162,12 @@ type Config struct {
 	// with a new user.
 	KXSuggestion func(user *RemoteUser, pii zkidentity.PublicIdentity)
 
+	// RetentionPolicyChanged is called when a remote user changes the
+	// retention policy of the messages exchanged with the local client
+	// (when gcID is empty) or the GC admin changes the retention policy
+	// of a GC.
+	RetentionPolicyChanged func(user *RemoteUser, gcID zkidentity.ShortID, policy rpc.RetentionPolicy)
+
 	// PostsListReceived is called when we receive the list of posts from
 	// a remote user.
 	PostsListReceived func(user *RemoteUser, postList rpc.RMListPostsReply)
@@ -162,6 +208,14 @@ type Config struct {
 	// download process.
 	FileDownloadProgress func(user *RemoteUser, fm rpc.FileMetadata, nbMissingChunks int)
 
+	// FileUploadProgress is called reporting the progress of a file
+	// upload process, whenever a chunk of the file is sent to the user.
+	FileUploadProgress func(user *RemoteUser, fm rpc.FileMetadata, chunkIdx int)
+
+	// SharedFolderUpdated is called whenever a remote user shares a folder
+	// with the local client or updates the manifest of a shared folder.
+	SharedFolderUpdated func(user *RemoteUser, sf clientdb.SubscribedFolder)
+
 	// TransitiveEvent is called whenever a request is made by source for
 	// the local client to forward a message to dst.
 	TransitiveEvent func(src, dst UserID, event TransitiveEvent)
@@ -183,6 +237,34 @@ type Config struct {
 	// GCMMaxDelay is the max delay after which GC messages are delivered
 	// without any caching.
 	GCMMaxDelay time.Duration
+
+	// GCMeshKXDelay is the delay between consecutive requests to mediate
+	// KX with members of a GC. Defaults to 5 seconds.
+	GCMeshKXDelay time.Duration
+
+	// GCInviteExpiration is how long GC invites sent by the local client
+	// remain valid. Defaults to 24 hours.
+	GCInviteExpiration time.Duration
+
+	// GCJoinLinkRetryInterval is how long to wait for the invite from the
+	// admin of a redeemed GC join link before sending the request to join
+	// the GC again, through the next slot of the link. Defaults to 1 hour.
+	GCJoinLinkRetryInterval time.Duration
+
+	// MultiSourceDownloads enables fetching chunks of file downloads from
+	// every KX'd user that shares a file with the same content. When
+	// enabled, the content hash of downloaded files is revealed to all
+	// remote users.
+	MultiSourceDownloads bool
+
+	// MultiSourceChunkTimeout is how long to wait for a chunk requested
+	// from one of the sources of a multi-source download before requesting
+	// it from a different source. Defaults to 1 hour.
+	MultiSourceChunkTimeout time.Duration
+
+	// SharedFoldersScanInterval is the interval between scans of the local
+	// shared folders for changes. Defaults to 1 minute.
+	SharedFoldersScanInterval time.Duration
 }
 
 func (cfg *Config) gcmInterMsgDelay() time.Duration {
@@ -199,6 +281,41 @@ func (cfg *Config) gcmMaxDelay() time.Duration {
 	return 30 * time.Second
 }
 
+func (cfg *Config) gcMeshKXDelay() time.Duration {
+	if cfg.GCMeshKXDelay > 0 {
+		return cfg.GCMeshKXDelay
+	}
+	return 5 * time.Second
+}
+
+func (cfg *Config) gcInviteExpiration() time.Duration {
+	if cfg.GCInviteExpiration > 0 {
+		return cfg.GCInviteExpiration
+	}
+	return 24 * time.Hour
+}
+
+func (cfg *Config) gcJoinLinkRetryInterval() time.Duration {
+	if cfg.GCJoinLinkRetryInterval > 0 {
+		return cfg.GCJoinLinkRetryInterval
+	}
+	return time.Hour
+}
+
+func (cfg *Config) multiSourceChunkTimeout() time.Duration {
+	if cfg.MultiSourceChunkTimeout > 0 {
+		return cfg.MultiSourceChunkTimeout
+	}
+	return time.Hour
+}
+
+func (cfg *Config) sharedFoldersScanInterval() time.Duration {
+	if cfg.SharedFoldersScanInterval > 0 {
+		return cfg.SharedFoldersScanInterval
+	}
+	return time.Minute
+}
+
 // logger creates a logger for the given subsystem in the configured backend.
 func (cfg *Config) logger(subsys string) slog.Logger {
 	if cfg.Logger == nil {
@@ -237,11 +354,50 @@ type Client struct {
 	svrLnNodeMtx sync.Mutex
 	svrLnNode    string
 
+	// svrSharedRVs is true when the current server supports shared RVs.
+	svrSharedRVsMtx sync.Mutex
+	svrSharedRVs    bool
+
+	// gcGroupRVLastPush tracks, for each GC, a chan that is closed when
+	// the last queued push to the group RVs of the GC is done. Pushes to
+	// the same GC are done one at a time, in the order they were queued.
+	gcGroupRVMtx      sync.Mutex
+	gcGroupRVLastPush map[zkidentity.ShortID]chan struct{}
+
+	// gcGroupRVRecv tracks the messages received in group RVs that are
+	// waiting for the messages pushed before them.
+	gcGroupRVRecvMtx sync.Mutex
+	gcGroupRVRecv    map[gcGroupRVRecvKey]*gcGroupRVRecvQueue
+
 	newUsersChan chan *RemoteUser
 
 	// gcAliasMap maps a local gc name to a global gc id.
 	gcAliasMtx sync.Mutex
 	gcAliasMap map[string]zkidentity.ShortID
+
+	// gcResyncs tracks the last time a resync was requested from (or sent
+	// to) each user in each GC, to avoid flooding members when their
+	// generations are out of sync.
+	gcResyncMtx sync.Mutex
+	gcResyncs   map[gcResyncKey]time.Time
+
+	// gcListsAwaitingKX tracks the handlers of GC lists that were signed
+	// by users not KX'd with the local client yet, by signer.
+	gcListsAwaitingKXMtx sync.Mutex
+	gcListsAwaitingKX    map[UserID][]func() error
+
+	// gcMeshKXRunning tracks the GCs that have a mesh KX pass running.
+	gcMeshKXMtx     sync.Mutex
+	gcMeshKXRunning map[zkidentity.ShortID]struct{}
+
+	// linkedDevice is true when this client is a linked device of an
+	// identity. It does not change after the initial db data is loaded.
+	linkedDevice bool
+
+	// devicesMtx serializes updates to the device links and deviceSendMtx
+	// serializes sends to devices.
+	devicesMtx    sync.Mutex
+	deviceSendMtx sync.Mutex
 }
 
 // New creates a new CR client with the given config.
@@ -311,6 +467,11 @@ func New(cfg Config) (*Client, error) {
 
 		abLoaded:     make(chan struct{}),
 		newUsersChan: make(chan *RemoteUser),
+		gcResyncs:    make(map[gcResyncKey]time.Time),
+
+		gcMeshKXRunning: make(map[zkidentity.ShortID]struct{}),
+
+		gcListsAwaitingKX: make(map[UserID][]func() error),
 	}
 
 	// Use the GC message cacher to collect gc messages for a few seconds
@@ -506,6 +667,9 @@ func (c *Client) loadInitialDBData(ctx context.Context) error {
 	if err := c.loadGCAliases(ctx); err != nil {
 		return err
 	}
+	if err := c.loadDeviceLinks(); err != nil {
+		return err
+	}
 
 	return nil
 }
@@ -516,7 +680,11 @@ func (c *Client) loadAddressBook(ctx context.Context) error {
 	var ab []*clientdb.AddressBookEntry
 	err := c.dbView(func(tx clientdb.ReadTx) error {
 		var err error
-		ab, err = c.db.LoadAddressBook(tx, c.id)
+		if c.linkedDevice {
+			ab, err = c.db.LoadLinkedAddressBook(tx)
+		} else {
+			ab, err = c.db.LoadAddressBook(tx, c.id)
+		}
 		return err
 	})
 	if err != nil {
@@ -525,6 +693,16 @@ func (c *Client) loadAddressBook(ctx context.Context) error {
 
 	c.log.Debugf("Loaded %d entries from the address book", len(ab))
 
+	if c.linkedDevice {
+		for _, entry := range ab {
+			if _, err := c.initLinkedRemoteUser(entry); err != nil {
+				c.log.Errorf("Unable to init remote user %s: %v",
+					entry.ID.Identity, err)
+			}
+		}
+		return nil
+	}
+
 	for _, entry := range ab {
 		_, err := c.initRemoteUser(entry.ID, entry.R, false,
 			entry.MyResetRV, entry.TheirResetRV, entry.Ignored)
@@ -534,7 +712,7 @@ func (c *Client) loadAddressBook(ctx context.Context) error {
 		}
 	}
 
-	return nil
+	return c.resetRestoredRatchets()
 }
 
 // cleanupPaidRVsDir cleans up the paid rvs dir of the db based on the
@@ -642,18 +820,71 @@ func (c *Client) UserNick(uid UserID) (string, error) {
 // PM sends a private message to the given user, identified by its public id.
 // The user must have been already KX'd with for this to work.
 func (c *Client) PM(uid UserID, msg string) error {
+	_, err := c.PMWithID(uid, msg)
+	return err
+}
+
+// PMWithID sends a private message to the given user and returns the id of the
+// message. Receipts sent by the user for this message reference this id.
+func (c *Client) PMWithID(uid UserID, msg string) (MsgID, error) {
+	id := clientintf.RandomID()
+	return id, c.pm(uid, id, MsgID{}, msg, nil)
+}
+
+// PMReply sends a private message to the given user as a reply to the message
+// with id replyTo and returns the id of the new message.
+func (c *Client) PMReply(uid UserID, replyTo MsgID, msg string) (MsgID, error) {
+	id := clientintf.RandomID()
+	return id, c.pm(uid, id, replyTo, msg, nil)
+}
+
+// pm sends a private message to the given user. replyTo is the id of the
+// message this one replies to, if any. origin is the linked device that
+// relayed the message, if it was not sent by the local client.
+func (c *Client) pm(uid UserID, id, replyTo MsgID, msg string, origin *zkidentity.ShortID) error {
 	ru, err := c.rul.byID(uid)
 	if err != nil {
 		return err
 	}
 
+	now := time.Now()
 	err = c.dbUpdate(func(tx clientdb.ReadWriteTx) error {
-		return c.db.LogPM(tx, uid, false, c.id.Public.Nick, msg, time.Now())
+		return c.db.LogPM(tx, uid, clientdb.HistoryEntry{
+			Timestamp: now,
+			From:      c.id.Public.Nick,
+			FromUID:   c.id.Public.Identity,
+			Mode:      rpc.MessageModeNormal,
+			Message:   msg,
+			MsgID:     id,
+			ReplyTo:   replyTo,
+		})
 	})
 	if err != nil {
 		return err
 	}
-	return ru.sendPM(msg)
+
+	if c.linkedDevice {
+		return c.sendToPrimary(deviceMsg{
+			Type:      deviceMsgRelayPM,
+			Timestamp: now,
+			UID:       uid,
+			MsgID:     id,
+			ReplyTo:   replyTo,
+			Message:   msg,
+		})
+	}
+	if err := ru.sendPM(id, replyTo, msg); err != nil {
+		return err
+	}
+	c.forwardToDevices(deviceMsg{
+		Type:      deviceMsgSentPM,
+		Timestamp: now,
+		UID:       uid,
+		MsgID:     id,
+		ReplyTo:   replyTo,
+		Message:   msg,
+	}, origin)
+	return nil
 }
 
 // Run runs all client goroutines until the given context is canceled.
@@ -725,6 +956,16 @@ func (c *Client) Run(ctx context.Context) error {
 
 	g.Go(func() error { return c.kxl.listenAllKXs() })
 
+	g.Go(func() error { return c.listenAllDevices() })
+
+	g.Go(func() error { return c.listenAllGCJoinLinks() })
+
+	g.Go(func() error { return c.listenAllGCSenderKeys() })
+
+	g.Go(func() error { return c.runRetentionJanitor(gctx) })
+	g.Go(func() error { return c.runGCInvitesJanitor(gctx) })
+	g.Go(func() error { return c.runGCJoinLinkRetrier(gctx) })
+
 	g.Go(func() error {
 		err := c.ck.Run(gctx)
 		if err != nil && !errors.Is(err, context.Canceled) {
@@ -784,6 +1025,12 @@ func (c *Client) Run(ctx context.Context) error {
 				}
 			}
 
+			if nextSess != nil {
+				c.svrSharedRVsMtx.Lock()
+				c.svrSharedRVs = nextSess.SupportsSharedRVs()
+				c.svrSharedRVsMtx.Unlock()
+			}
+
 			c.rmgr.BindToSession(nextSess)
 			c.q.BindToSession(nextSess)
 			c.gcmq.SessionChanged(nextSess != nil)
@@ -866,7 +1113,13 @@ func (c *Client) Run(ctx context.Context) error {
 		if err := waitAfterFirstConn(1 * time.Second); err != nil {
 			return err
 		}
-		return c.restartDownloads(gctx)
+		if err := c.restartDownloads(gctx); err != nil {
+			return err
+		}
+		if _, err := c.ReclaimContentSpace(); err != nil {
+			c.log.Errorf("Unable to reclaim content space: %v", err)
+		}
+		return c.runDownloadsJanitor(gctx)
 	})
 
 	// Restart uploads.
@@ -877,6 +1130,14 @@ func (c *Client) Run(ctx context.Context) error {
 		return c.restartUploads(gctx)
 	})
 
+	// Keep the shared folders synced.
+	g.Go(func() error {
+		if err := waitAfterFirstConn(1 * time.Second); err != nil {
+			return err
+		}
+		return c.runSharedFoldersJanitor(gctx)
+	})
+
 	// Clear old mediate id requests.
 	g.Go(func() error {
 		c.clearOldMediateIDs()
@@ -0,0 +1,60 @@
+package client
+
+import (
+	"io"
+
+	"github.com/companyzero/bisonrelay/client/clientdb"
+)
+
+// ExportBackup writes an encrypted backup of the full client state (local
+// identity, address book, ratchets, GCs, posts, shared content, KX state,
+// etc) to w. The passphrase is needed to restore the backup.
+func (c *Client) ExportBackup(w io.Writer, passphrase []byte) error {
+	return c.dbView(func(tx clientdb.ReadTx) error {
+		return c.db.ExportBackup(tx, w, passphrase)
+	})
+}
+
+// ImportBackup validates the backup and stages it to be restored. The backup
+// replaces the entire client state the next time the client is started.
+//
+// After the backup is restored, the ratchets with every user are reset, to
+// recover from any state drift that happened since the backup was created.
+func (c *Client) ImportBackup(r io.Reader, passphrase []byte) error {
+	return c.db.ImportBackup(r, passphrase)
+}
+
+// resetRestoredRatchets requests a ratchet reset with every user whose ratchet
+// was restored from a backup.
+func (c *Client) resetRestoredRatchets() error {
+	var uids []UserID
+	err := c.dbView(func(tx clientdb.ReadTx) error {
+		var err error
+		uids, err = c.db.ListRestoredRatchets(tx)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, uid := range uids {
+		uid := uid
+		go func() {
+			c.log.Infof("Resetting ratchet with user %s restored from "+
+				"backup", uid)
+			if err := c.ResetRatchet(uid); err != nil {
+				c.log.Errorf("Unable to reset ratchet with %s "+
+					"restored from backup: %v", uid, err)
+				return
+			}
+			err := c.dbUpdate(func(tx clientdb.ReadWriteTx) error {
+				return c.db.RemoveRestoredRatchet(tx, uid)
+			})
+			if err != nil {
+				c.log.Errorf("Unable to remove restored ratchet "+
+					"flag of %s: %v", uid, err)
+			}
+		}()
+	}
+	return nil
+}
@@ -254,6 +254,18 @@ func (c *Client) GetUserContent(uid UserID, fid clientdb.FileID) error {
 
 	ru.log.Infof("Starting download of file %s", fid)
 
+	// Store that we want to download this file. This is done before
+	// sending the request, so that the reply is not received before the
+	// download exists.
+	err = c.dbUpdate(func(tx clientdb.ReadWriteTx) error {
+		var err error
+		_, err = c.db.StartFileDownload(tx, uid, fid, false)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
 	// Send request for file metadata.
 	rmftg := rpc.RMFTGet{
 		FileID: fid.String(),
@@ -261,16 +273,12 @@ func (c *Client) GetUserContent(uid UserID, fid clientdb.FileID) error {
 	payEvent := fmt.Sprintf("ftget.%s", fid.ShortLogID())
 	err = ru.sendRM(rmftg, payEvent)
 	if err != nil {
-		return err
-	}
-
-	// Store that we want to download this file.
-	err = c.dbUpdate(func(tx clientdb.ReadWriteTx) error {
-		var err error
-		_, err = c.db.StartFileDownload(tx, uid, fid, false)
-		return err
-	})
-	if err != nil {
+		if cancelErr := c.dbUpdate(func(tx clientdb.ReadWriteTx) error {
+			return c.db.CancelFileDownload(tx, fid)
+		}); cancelErr != nil {
+			ru.log.Warnf("Unable to remove download of file %s: %v",
+				fid, cancelErr)
+		}
 		return err
 	}
 
@@ -301,10 +309,16 @@ func (c *Client) handleFTGet(ru *RemoteUser, ftg rpc.RMFTGet) error {
 	}
 
 	var md rpc.FileMetadata
-	err := c.dbView(func(tx clientdb.ReadTx) error {
+	err := c.dbUpdate(func(tx clientdb.ReadWriteTx) error {
 		var err error
 		_, md, err = c.db.GetSharedFileForUpload(tx, ru.ID(), fid)
-		return err
+		if err != nil {
+			return err
+		}
+
+		// A new request for the file restarts a previously canceled
+		// upload.
+		return c.db.ClearFileUploadCanceled(tx, ru.ID(), fid)
 	})
 	if err != nil {
 		if errors.Is(err, clientdb.ErrNotFound) {
@@ -324,51 +338,60 @@ func (c *Client) handleFTGet(ru *RemoteUser, ftg rpc.RMFTGet) error {
 }
 
 // requestFileChunk sends a request to a remote host for one chunk of one of
-// its files.
-func (c *Client) requestFileChunk(ru *RemoteUser, fid clientdb.FileID, chunkIdx int,
-	fm rpc.FileMetadata) error {
+// its files. The chunk must have already been marked as requested from the
+// remote user. srcFID is the ID of the file as shared by the remote user.
+func (c *Client) requestFileChunk(ru *RemoteUser, srcFID clientdb.FileID, chunkIdx int,
+	fm rpc.FileMetadata, prio clientdb.TransferPriority) error {
 
 	chunkHash := fm.Manifest[chunkIdx].Hash
 
 	if ru.log.Level() <= slog.LevelDebug {
 		ru.log.Debugf("Requesting chunk %d (%x) of file %q (%s)",
-			chunkIdx, chunkHash, fm.Filename, fid)
+			chunkIdx, chunkHash, fm.Filename, srcFID)
 	} else {
 		ru.log.Debugf("Requesting chunk %d of file %q",
 			chunkIdx, fm.Filename)
 	}
 
 	rm := rpc.RMFTGetChunk{
-		FileID: fid.String(),
+		FileID: srcFID.String(),
 		Index:  chunkIdx,
 		Hash:   chunkHash,
 	}
-	payEvent := fmt.Sprintf("ftgetchunk.%s.%d", fid.ShortLogID(), rm.Index)
-	if err := ru.sendRM(rm, payEvent); err != nil {
-		return err
+	payEvent := fmt.Sprintf("ftgetchunk.%s.%d", srcFID.ShortLogID(), rm.Index)
+	err := ru.sendRMPriority(rm, payEvent, transferRMPriority(priorityDefault, prio))
+	if err == nil {
+		return nil
 	}
 
-	err := c.dbUpdate(func(tx clientdb.ReadWriteTx) error {
-		fd, err := c.db.ReadFileDownload(tx, ru.ID(), fid)
+	// Clear the request, so that the chunk is requested again (possibly
+	// from a different source) on the next attempt.
+	dbErr := c.dbUpdate(func(tx clientdb.ReadWriteTx) error {
+		fd, err := c.db.ReadFileDownloadFromSource(tx, ru.ID(), srcFID)
 		if err != nil {
 			return err
 		}
-		return c.db.ReplaceFileDownloadChunkState(tx, &fd, chunkIdx,
-			clientdb.ChunkStateRequestedChunk)
+		if fd.ChunkSource(chunkIdx) != ru.ID() ||
+			fd.ChunkStates[chunkIdx] != clientdb.ChunkStateRequestedChunk {
+			return nil
+		}
+		return c.db.ReplaceFileDownloadChunkState(tx, &fd, chunkIdx, "")
 	})
-	if err != nil {
-		return err
+	if dbErr != nil {
+		ru.log.Errorf("Unable to clear request of chunk %d: %v",
+			chunkIdx, dbErr)
 	}
-	return nil
+	return err
 }
 
-// payFileChunkInvoice pays for the invoice to download a chunk.
+// payFileChunkInvoice pays for the invoice to download a chunk. fid is the ID
+// of the file as shared by the remote user.
 func (c *Client) payFileChunkInvoice(ru *RemoteUser, fid clientdb.FileID,
 	chunkIdx int, invoice string, matoms int64) error {
 
 	// Mark invoice as attempting to pay.
 	err := c.dbUpdate(func(tx clientdb.ReadWriteTx) error {
-		fd, err := c.db.ReadFileDownload(tx, ru.ID(), fid)
+		fd, err := c.db.ReadFileDownloadFromSource(tx, ru.ID(), fid)
 		if err != nil {
 			return err
 		}
@@ -396,7 +419,7 @@ func (c *Client) payFileChunkInvoice(ru *RemoteUser, fid clientdb.FileID,
 
 	// Record result of attempting the payment.
 	dbErr := c.dbUpdate(func(tx clientdb.ReadWriteTx) error {
-		fd, dbErr := c.db.ReadFileDownload(tx, ru.ID(), fid)
+		fd, dbErr := c.db.ReadFileDownloadFromSource(tx, ru.ID(), fid)
 		if dbErr != nil {
 			return dbErr
 		}
@@ -433,18 +456,62 @@ func (c *Client) payFileChunkInvoice(ru *RemoteUser, fid clientdb.FileID,
 	return err
 }
 
+// downloadSources returns the known remote users from which the given download
+// may be fetched.
+func (c *Client) downloadSources(fd *clientdb.FileDownload) []*RemoteUser {
+	uids := fd.SourceUIDs()
+	res := make([]*RemoteUser, 0, len(uids))
+	for _, uid := range uids {
+		if ru, err := c.rul.byID(uid); err == nil {
+			res = append(res, ru)
+		}
+	}
+	return res
+}
+
 // downloadChunks is the main workhorse for chunked file download. It is called
 // both for initial download and for restarting old downloads (on client
 // startup).
 //
 // It determines the state of each chunk of the given download and takes
-// actions as appropriate.
-func (c *Client) downloadChunks(ru *RemoteUser, fd clientdb.FileDownload) error {
+// actions as appropriate. When the download has multiple sources, missing
+// chunks are requested from each source in turn and chunks that are not
+// received in time are requested from a different source.
+func (c *Client) downloadChunks(fd clientdb.FileDownload) error {
 	if fd.Metadata == nil {
 		// Shouldn't happen, but avoid panic.
 		return fmt.Errorf("unable to start download with nil metadata")
 	}
 
+	// Figure out which users the chunks may be downloaded from. This
+	// could be empty if we removed the ratchet/user before the download
+	// completed.
+	sources := c.downloadSources(&fd)
+	if len(sources) == 0 {
+		return fmt.Errorf("no known users to download file %s from", fd.FID)
+	}
+
+	// Request chunks from each source in turn, avoiding the source that
+	// failed to send a chunk when possible.
+	var nextSource int
+	pickSource := func(avoid UserID) *RemoteUser {
+		ru := sources[nextSource%len(sources)]
+		nextSource++
+		if ru.ID() == avoid && len(sources) > 1 {
+			ru = sources[nextSource%len(sources)]
+			nextSource++
+		}
+		return ru
+	}
+	sourceByID := func(uid UserID) *RemoteUser {
+		for _, ru := range sources {
+			if ru.ID() == uid {
+				return ru
+			}
+		}
+		return nil
+	}
+
 	var missing []int
 	err := c.dbView(func(tx clientdb.ReadTx) error {
 		missing = c.db.MissingFileDownloadChunks(tx, &fd)
@@ -454,20 +521,20 @@ func (c *Client) downloadChunks(ru *RemoteUser, fd clientdb.FileDownload) error
 		return err
 	}
 
-	c.log.Infof("Starting to downloading %d missing chunks of file %q (%s)",
-		len(missing), fd.Metadata.Filename, fd.FID)
+	c.log.Infof("Starting to downloading %d missing chunks of file %q (%s) "+
+		"from %d sources", len(missing), fd.Metadata.Filename, fd.FID,
+		len(sources))
 
 	for _, chunkIdx := range missing {
 		chunkIdx := chunkIdx
 
 		// Track which action to take, depending on the current state
 		// of the chunk.
-		var actionToTake string
-		const actRequest = "request invoice"
-		const actSendPayment = "send payment"
+		var requestFrom, payTo *RemoteUser
+		var paused, canceled bool
 
 		// Helper func to log errors in goroutines.
-		logErr := func(err error, msg string) {
+		logErr := func(ru *RemoteUser, err error, msg string) {
 			if err != nil && !errors.Is(err, clientintf.ErrSubsysExiting) {
 				ru.log.Errorf(msg, err)
 			}
@@ -483,34 +550,71 @@ func (c *Client) downloadChunks(ru *RemoteUser, fd clientdb.FileDownload) error
 		// - Attempt to pay invoice succeeded, but not received chunk
 		// - Received chunk
 		err := c.dbUpdate(func(tx clientdb.ReadWriteTx) error {
-			fd, err := c.db.ReadFileDownload(tx, ru.ID(), fd.FID)
+			fd, err := c.db.ReadFileDownload(tx, fd.UID, fd.FID)
+			if errors.Is(err, clientdb.ErrNotFound) {
+				canceled = true
+				return nil
+			}
 			if err != nil {
 				return err
 			}
 
+			// The download was canceled and requested again. It
+			// will be restarted once its metadata is received.
+			if fd.Metadata == nil {
+				canceled = true
+				return nil
+			}
+
+			// Stop processing paused downloads.
+			if fd.Paused {
+				paused = true
+				return nil
+			}
+
 			if chunkIdx >= len(fd.Metadata.Manifest) {
 				// Shouldn't happen, but avoid panic.
 				return fmt.Errorf("Assertion error: chunkIdx %d >= len(manifest) %d",
 					chunkIdx, len(fd.Metadata.Manifest))
 			}
 
+			// New sources may have been found while the download
+			// is in progress.
+			if newSources := c.downloadSources(&fd); len(newSources) > 0 {
+				sources = newSources
+			}
+
+			// Chunks are only re-requested after a long time when
+			// there is a single source, to avoid sending multiple
+			// redundant requests.
+			reqTimeout := time.Hour * 24
+			if len(sources) > 1 {
+				reqTimeout = c.cfg.multiSourceChunkTimeout()
+			}
+
 			var payMAtoms int64
+			chunkSource := fd.ChunkSource(chunkIdx)
 			chunkState := fd.ChunkStates[chunkIdx]
 			switch chunkState {
 			case "":
 				// Safe to request again.
-				actionToTake = actRequest
+				requestFrom = pickSource(UserID{})
 
 			case clientdb.ChunkStateRequestedChunk:
-				// Request again if it's been at least one day
-				// since we last requested (to avoid sending
-				// multiple redundant requests).
+				// Request again if it's been too long since we
+				// last requested.
 				var chunkUpdtTime time.Time
 				if fd.ChunkUpdatedTime != nil {
 					chunkUpdtTime = fd.ChunkUpdatedTime[chunkIdx]
 				}
-				if chunkUpdtTime.Before(time.Now().Add(-time.Hour * 24)) {
-					actionToTake = actRequest
+				if chunkUpdtTime.Before(time.Now().Add(-reqTimeout)) {
+					requestFrom = pickSource(chunkSource)
+					if requestFrom.ID() != chunkSource {
+						c.log.Debugf("Chunk %d of file %s not "+
+							"received from %s. Requesting "+
+							"from %s", chunkIdx, fd.FID,
+							chunkSource, requestFrom)
+					}
 				}
 
 			case clientdb.ChunkStateHasInvoice:
@@ -522,10 +626,11 @@ func (c *Client) downloadChunks(ru *RemoteUser, fd clientdb.FileDownload) error
 					return fmt.Errorf("unable to decode chunk invoice: %v", err)
 				}
 
-				if decoded.IsExpired(0) {
-					actionToTake = actRequest
+				payTo = sourceByID(chunkSource)
+				if decoded.IsExpired(0) || payTo == nil {
+					requestFrom = pickSource(chunkSource)
+					payTo = nil
 				} else {
-					actionToTake = actSendPayment
 					payMAtoms = decoded.MAtoms
 				}
 
@@ -538,7 +643,7 @@ func (c *Client) downloadChunks(ru *RemoteUser, fd clientdb.FileDownload) error
 				// crashed, so we need to actually check in the
 				// payment client if the payment is in flight,
 				// succeeded or failed.
-				ru.log.Warnf("Chunk %d of file %s has in-flight payment",
+				c.log.Warnf("Chunk %d of file %s has in-flight payment",
 					chunkIdx, fd.FID)
 
 			case clientdb.ChunkStatePaid:
@@ -547,30 +652,43 @@ func (c *Client) downloadChunks(ru *RemoteUser, fd clientdb.FileDownload) error
 				//
 				// TODO: deal with unresponsive remotes.
 				// Re-request it?  Alert user? Ban remote?
-				ru.log.Warnf("Chunk %d of file %s was paid for "+
-					"but hasn't been received yet",
-					chunkIdx, fd.FID)
+				c.log.Warnf("Chunk %d of file %s was paid for "+
+					"but hasn't been received yet from %s",
+					chunkIdx, fd.FID, chunkSource)
 
 			case clientdb.ChunkStateDownloaded:
 				// Already downloaded chunk, nothing to do.
 			}
 
 			// Actually take an action on this chunk.
-			switch actionToTake {
-			case actRequest:
-				// Re-request it.
+			switch {
+			case requestFrom != nil:
+				// Re-request it. Mark it as requested before
+				// sending, so that concurrent calls do not
+				// request it from a different source.
+				ru := requestFrom
+				srcFID, _ := fd.SourceFID(ru.ID())
+				err := c.db.MarkFileDownloadChunkRequested(tx,
+					&fd, chunkIdx, ru.ID())
+				if err != nil {
+					return err
+				}
+				prio := c.transferPriority(tx, fd.FID)
 				go func() {
-					err := c.requestFileChunk(ru, fd.FID, chunkIdx, *fd.Metadata)
-					logErr(err, "Unable to request file chunk: %v")
+					err := c.requestFileChunk(ru, srcFID, chunkIdx,
+						*fd.Metadata, prio)
+					logErr(ru, err, "Unable to request file chunk: %v")
 				}()
 
-			case actSendPayment:
+			case payTo != nil:
 				// Attempt payment.
+				ru := payTo
+				srcFID, _ := fd.SourceFID(ru.ID())
 				go func() {
 					invoice := fd.GetChunkInvoice(chunkIdx)
-					err := c.payFileChunkInvoice(ru, fd.FID,
+					err := c.payFileChunkInvoice(ru, srcFID,
 						chunkIdx, invoice, payMAtoms)
-					logErr(err, "unable to pay for chunk: %v")
+					logErr(ru, err, "unable to pay for chunk: %v")
 				}()
 			}
 
@@ -579,6 +697,14 @@ func (c *Client) downloadChunks(ru *RemoteUser, fd clientdb.FileDownload) error
 		if err != nil {
 			return err
 		}
+		if canceled {
+			c.log.Debugf("Download of file %s canceled", fd.FID)
+			return nil
+		}
+		if paused {
+			c.log.Debugf("Download of file %s paused", fd.FID)
+			return nil
+		}
 
 		// Small sleep to bias downloading sequentially.
 		time.Sleep(100 * time.Millisecond)
@@ -611,9 +737,31 @@ func (c *Client) handleFTGetReply(ru *RemoteUser, gr rpc.RMFTGetReply) error {
 			fid)
 	}
 
+	// Reuse the local contents when the same file was already downloaded
+	// or when all of its chunks are already stored.
+	var completedFname string
+	err = c.dbUpdate(func(tx clientdb.ReadWriteTx) error {
+		var err error
+		completedFname, err = c.db.ReuseFileDownloadBlobs(tx, ru.Nick(), &fd)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if completedFname != "" {
+		ru.log.Infof("Completed file download %q (%s) from local "+
+			"contents, saved as %q", fd.Metadata.Filename, fd.FID,
+			filepath.Base(completedFname))
+		c.syncSharedFolderDownload(ru, fd.FID, completedFname)
+		if c.cfg.FileDownloadCompleted != nil {
+			c.cfg.FileDownloadCompleted(ru, *fd.Metadata, completedFname)
+		}
+		return nil
+	}
+
 	// Ask user for confirmation before downloading file (specially
-	// due to cost).
-	if c.cfg.FileDownloadConfirmer != nil {
+	// due to cost). Files of synced shared folders were already accepted.
+	if c.cfg.FileDownloadConfirmer != nil && !c.isSharedFolderFile(ru.ID(), fid) {
 		if !c.cfg.FileDownloadConfirmer(ru, gr.Metadata) {
 			// Canceled. Remove download.
 			ru.log.Infof("User canceled download of file %s", fid)
@@ -623,9 +771,20 @@ func (c *Client) handleFTGetReply(ru *RemoteUser, gr rpc.RMFTGetReply) error {
 		}
 	}
 
+	// Look for other users that have the same file.
+	if c.cfg.MultiSourceDownloads {
+		go c.findFileSources(fd)
+	}
+
+	// Paused downloads are started once they are resumed.
+	if fd.Paused {
+		ru.log.Infof("Received metadata of paused download %s", fid)
+		return nil
+	}
+
 	// Fetched metadata for the given file. Request chunks.
 	go func() {
-		err := c.downloadChunks(ru, fd)
+		err := c.downloadChunks(fd)
 		if err != nil && !errors.Is(err, clientintf.ErrSubsysExiting) {
 			ru.log.Errorf("Unable to download file chunk: %v", err)
 		}
@@ -640,9 +799,11 @@ func (c *Client) sendFileChunk(ru *RemoteUser, sf clientdb.SharedFile,
 	chunkIdx int, cid clientdb.ChunkID, tag uint32) error {
 
 	var data []byte
+	var prio clientdb.TransferPriority
 	err := c.dbView(func(tx clientdb.ReadTx) error {
 		var err error
 		data, err = c.db.GetSharedFileChunkData(tx, &sf, chunkIdx)
+		prio = c.transferPriority(tx, sf.FID)
 		return err
 	})
 	if err != nil {
@@ -656,7 +817,7 @@ func (c *Client) sendFileChunk(ru *RemoteUser, sf clientdb.SharedFile,
 		Tag:    tag,
 	}
 	payEvent := fmt.Sprintf("ftchunkupload.%s.%d", sf.FID.ShortLogID(), chunkIdx)
-	err = ru.sendRMPriority(rm, payEvent, priorityUpload)
+	err = ru.sendRMPriority(rm, payEvent, transferRMPriority(priorityUpload, prio))
 	if err != nil {
 		return err
 	}
@@ -664,9 +825,23 @@ func (c *Client) sendFileChunk(ru *RemoteUser, sf clientdb.SharedFile,
 	ru.log.Debugf("Sent chunk %d of file %s to remote user", chunkIdx, sf.FID)
 
 	// Sent successfully (to server)! Mark chunk as sent.
-	return c.dbUpdate(func(tx clientdb.ReadWriteTx) error {
-		return c.db.MarkChunkUploadSent(tx, ru.ID(), sf.FID, cid, chunkIdx)
+	var md rpc.FileMetadata
+	err = c.dbUpdate(func(tx clientdb.ReadWriteTx) error {
+		err := c.db.MarkChunkUploadSent(tx, ru.ID(), sf.FID, cid, chunkIdx)
+		if err != nil {
+			return err
+		}
+		md, err = c.db.GetSharedFileMetadata(tx, &sf)
+		return err
 	})
+	if err != nil {
+		return err
+	}
+
+	if c.cfg.FileUploadProgress != nil {
+		c.cfg.FileUploadProgress(ru, md, chunkIdx)
+	}
+	return nil
 }
 
 // ftPaymentForChunkCompleted is called as a callback when the payment for the
@@ -775,6 +950,7 @@ func (c *Client) handleFTGetChunk(ru *RemoteUser, gc rpc.RMFTGetChunk) error {
 	var f clientdb.SharedFile
 	var md rpc.FileMetadata
 	var inv string
+	var canceled bool
 	chunkIdx := gc.Index
 	err := c.dbUpdate(func(tx clientdb.ReadWriteTx) error {
 		var err error
@@ -783,6 +959,12 @@ func (c *Client) handleFTGetChunk(ru *RemoteUser, gc rpc.RMFTGetChunk) error {
 			return err
 		}
 
+		// Reject requests for chunks of canceled uploads.
+		if c.db.IsFileUploadCanceled(tx, ru.ID(), fid) {
+			canceled = true
+			return nil
+		}
+
 		// Ensure chunk index is correct.
 		if !clientintf.ChunkIndexMatches(&md, chunkIdx, gc.Hash) {
 			return fmt.Errorf("data does not hash to specified chunk index")
@@ -821,6 +1003,22 @@ func (c *Client) handleFTGetChunk(ru *RemoteUser, gc rpc.RMFTGetChunk) error {
 		return err
 	}
 
+	if canceled {
+		errStr := "upload canceled"
+		reply := rpc.RMFTGetChunkReply{
+			FileID: gc.FileID,
+			Index:  chunkIdx,
+			Tag:    gc.Tag,
+			Error:  &errStr,
+		}
+		payEvent := fmt.Sprintf("ftgetchunkreply.%s", fid.ShortLogID())
+		if err := ru.sendRM(reply, payEvent); err != nil {
+			return err
+		}
+		return fmt.Errorf("rejected request for chunk %d of file %s: %s",
+			chunkIdx, fid, errStr)
+	}
+
 	if inv == "" {
 		// No need to pay an invoice. Send chunk directly.
 		return c.sendFileChunk(ru, f, chunkIdx, cid, gc.Tag)
@@ -846,8 +1044,9 @@ func (c *Client) handleFTPayForChunk(ru *RemoteUser, pfc rpc.RMFTPayForChunk) er
 	}
 
 	chunkIdx := pfc.Index
+	var paused bool
 	err = c.dbUpdate(func(tx clientdb.ReadWriteTx) error {
-		fd, err := c.db.ReadFileDownload(tx, ru.ID(), fid)
+		fd, err := c.db.ReadFileDownloadFromSource(tx, ru.ID(), fid)
 		if err != nil {
 			return err
 		}
@@ -869,11 +1068,23 @@ func (c *Client) handleFTPayForChunk(ru *RemoteUser, pfc rpc.RMFTPayForChunk) er
 			return fmt.Errorf("already paid for chunk %d", chunkIdx)
 		}
 
+		if fd.ChunkStates[chunkIdx] == clientdb.ChunkStatePayingInvoice {
+			return fmt.Errorf("already paying for chunk %d", chunkIdx)
+		}
+
+		// Only pay the user the chunk was last requested from, to
+		// avoid paying multiple sources for the same chunk.
+		if fd.ChunkSource(chunkIdx) != ru.ID() {
+			return fmt.Errorf("chunk %d was not requested from user",
+				chunkIdx)
+		}
+
 		// TODO: check whether the invoice has a payment attempt in
 		// flight or is already expired.
 
 		// Double check amount to pay for chunk.
-		wantMAtoms := clientintf.FileChunkMAtoms(chunkIdx, fd.Metadata)
+		wantMAtoms := clientintf.FileChunkMAtoms(chunkIdx,
+			fd.SourceMetadata(ru.ID()))
 		if uint64(inv.MAtoms) != wantMAtoms {
 			return fmt.Errorf("unexpected value of invoice (got %d, want %d)",
 				inv.MAtoms, wantMAtoms)
@@ -884,12 +1095,21 @@ func (c *Client) handleFTPayForChunk(ru *RemoteUser, pfc rpc.RMFTPayForChunk) er
 		if err := c.db.ReplaceFileDownloadInvoices(tx, &fd, invoices); err != nil {
 			return err
 		}
+		paused = fd.Paused
 		return err
 	})
 	if err != nil {
 		return err
 	}
 
+	// The invoice of paused downloads is paid when the download is
+	// resumed.
+	if paused {
+		ru.log.Debugf("Delaying payment of chunk %d of paused download of "+
+			"file %s", chunkIdx, fid)
+		return nil
+	}
+
 	// Start to pay for this chunk.
 	return c.payFileChunkInvoice(ru, fid, chunkIdx, pfc.Invoice, inv.MAtoms)
 }
@@ -900,19 +1120,31 @@ func (c *Client) handleFTGetChunkReply(ru *RemoteUser, gcr rpc.RMFTGetChunkReply
 	if err := fid.FromString(gcr.FileID); err != nil {
 		return err
 	}
+	if gcr.Error != nil {
+		return fmt.Errorf("remote user replied with error to request "+
+			"for chunk %d of file %s: %s", gcr.Index, fid, *gcr.Error)
+	}
 
-	// Save the chunk.
+	// Save the chunk. The downloaded file is always attributed to the
+	// user the download was started from, even if the chunk was sent by
+	// one of the other sources.
 	var fd clientdb.FileDownload
 	var completedFname string
 	var nbMissingChunks int
+	owner := ru
 	err := c.dbUpdate(func(tx clientdb.ReadWriteTx) error {
 		var err error
-		fd, err = c.db.ReadFileDownload(tx, ru.ID(), fid)
+		fd, err = c.db.ReadFileDownloadFromSource(tx, ru.ID(), fid)
 		if err != nil {
 			return err
 		}
+		if fd.UID != ru.ID() {
+			if fdOwner, err := c.rul.byID(fd.UID); err == nil {
+				owner = fdOwner
+			}
+		}
 
-		completedFname, err = c.db.SaveFileDownloadChunk(tx, ru.Nick(), &fd, gcr.Index, gcr.Chunk)
+		completedFname, err = c.db.SaveFileDownloadChunk(tx, owner.Nick(), &fd, gcr.Index, gcr.Chunk)
 		nbMissingChunks = len(c.db.MissingFileDownloadChunks(tx, &fd))
 		return err
 	})
@@ -926,15 +1158,28 @@ func (c *Client) handleFTGetChunkReply(ru *RemoteUser, gcr rpc.RMFTGetChunkReply
 		baseName := filepath.Base(completedFname)
 		ru.log.Infof("Completed file download %q (%s, saved as %q",
 			fd.Metadata.Filename, fd.FID, baseName)
+		c.syncSharedFolderDownload(owner, fd.FID, completedFname)
 		if c.cfg.FileDownloadCompleted != nil {
-			c.cfg.FileDownloadCompleted(ru, *fd.Metadata, completedFname)
+			c.cfg.FileDownloadCompleted(owner, *fd.Metadata, completedFname)
 		}
 	} else if c.cfg.FileDownloadProgress != nil {
-		c.cfg.FileDownloadProgress(ru, *fd.Metadata, nbMissingChunks)
+		c.cfg.FileDownloadProgress(owner, *fd.Metadata, nbMissingChunks)
 	}
 	return err
 }
 
+// ReclaimContentSpace removes the locally stored chunks that are no longer
+// referenced by any shared file or in-progress download.
+func (c *Client) ReclaimContentSpace() (clientdb.BlobStats, error) {
+	var stats clientdb.BlobStats
+	err := c.dbUpdate(func(tx clientdb.ReadWriteTx) error {
+		var err error
+		stats, err = c.db.DelUnreferencedBlobs(tx)
+		return err
+	})
+	return stats, err
+}
+
 // ListDownloads lists all outstanding downloads.
 func (c *Client) ListDownloads() ([]clientdb.FileDownload, error) {
 	var fds []clientdb.FileDownload
@@ -1031,20 +1276,12 @@ func (c *Client) restartDownloads(ctx context.Context) error {
 
 	for _, fd := range fds {
 		fd := fd
-		ru, err := c.rul.byID(fd.UID)
-		if err != nil {
-			// This could happen if we removed the ratchet/user
-			// before the download completed.
-			c.log.Warnf("Outstanding download %s for unknown user %s",
-				fd.FID, fd.UID)
-			continue
-		}
 
 		// Start to re-process the download.
 		go func() {
-			err := c.downloadChunks(ru, fd)
+			err := c.downloadChunks(fd)
 			if err != nil && !errors.Is(err, clientintf.ErrSubsysExiting) {
-				ru.log.Errorf("Error downloading chunks of file %s: %v",
+				c.log.Errorf("Error downloading chunks of file %s: %v",
 					fd.FID, err)
 			}
 		}()
@@ -0,0 +1,671 @@
+package client
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/companyzero/bisonrelay/client/clientdb"
+	"github.com/companyzero/bisonrelay/client/internal/lowlevel"
+	"github.com/companyzero/bisonrelay/rpc"
+	"github.com/companyzero/bisonrelay/sw"
+	"github.com/companyzero/bisonrelay/zkidentity"
+)
+
+// Linked devices
+//
+// An identity has a single primary device, which holds the ratchets with every
+// remote user, and any number of linked devices. Each linked device talks only
+// to the primary device, through a pair of RV chains derived from the key of
+// their link. The primary device forwards the relevant RMs received from remote
+// users to its linked devices and sends the PMs and GC messages that linked
+// devices relay to it.
+
+const (
+	// Sent by linked devices to the primary device.
+	deviceMsgRelayPM      = "relaypm"
+	deviceMsgRelayGCM     = "relaygcm"
+	deviceMsgRelayReceipt = "relayreceipt"
+
+	// Sent by the primary device to linked devices.
+	deviceMsgRecvdRM     = "recvdrm"
+	deviceMsgSentPM      = "sentpm"
+	deviceMsgSentGCM     = "sentgcm"
+	deviceMsgSentEdit    = "sentedit"
+	deviceMsgSentRetract = "sentretract"
+	deviceMsgUser        = "user"
+	deviceMsgGCs         = "gcs"
+	deviceMsgUnlinked    = "unlinked"
+)
+
+// deviceMsg is a message exchanged between the primary device and one of its
+// linked devices.
+type deviceMsg struct {
+	Seq       uint64                        `json:"seq"`
+	Type      string                        `json:"type"`
+	Timestamp time.Time                     `json:"timestamp"`
+	UID       UserID                        `json:"uid"`
+	GCID      zkidentity.ShortID            `json:"gcid"`
+	Mode      rpc.MessageMode               `json:"mode,omitempty"`
+	MsgID     MsgID                         `json:"msg_id"`
+	ReplyTo   MsgID                         `json:"reply_to"`
+	Message   string                        `json:"message,omitempty"`
+	Mentions  []UserID                      `json:"mentions,omitempty"`
+	RM        []byte                        `json:"rm,omitempty"`
+	Identity  *zkidentity.PublicIdentity    `json:"identity,omitempty"`
+	GCs       []rpc.RMGroupList             `json:"gcs,omitempty"`
+	GCAliases map[string]zkidentity.ShortID `json:"gc_aliases,omitempty"`
+}
+
+// forwardedRMCmds are the commands of the RMs received from remote users that
+// the primary device forwards to its linked devices.
+var forwardedRMCmds = map[string]bool{
+	rpc.RMCPrivateMessage:  true,
+	rpc.RMCGroupMessage:    true,
+	rpc.RMCPostShare:       true,
+	rpc.RMCPostStatus:      true,
+	rpc.RMCReceipt:         true,
+	rpc.RMCMessageEdit:     true,
+	rpc.RMCMessageRetract:  true,
+	rpc.RMCMessageReaction: true,
+}
+
+// deviceRV returns the RV of the message with the given sequence number sent
+// through the link. send is true for messages sent by the local device.
+func deviceRV(link *clientdb.DeviceLink, send bool, seq uint64) lowlevel.RVID {
+	// Messages are sent to the primary device when this is the linked
+	// device and sending or when this is the primary device and receiving.
+	dir := "p2d"
+	if link.Primary == send {
+		dir = "d2p"
+	}
+
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], seq)
+	h := sha256.New()
+	h.Write([]byte("brdevicelink"))
+	h.Write(link.Key[:])
+	h.Write([]byte(dir))
+	h.Write(b[:])
+
+	var rv lowlevel.RVID
+	copy(rv[:], h.Sum(nil))
+	return rv
+}
+
+// IsLinkedDevice returns true if this client is a linked device of an identity
+// (as opposed to its primary device).
+func (c *Client) IsLinkedDevice() bool {
+	return c.linkedDevice
+}
+
+// loadDeviceLinks determines whether this client is a linked device.
+func (c *Client) loadDeviceLinks() error {
+	return c.dbView(func(tx clientdb.ReadTx) error {
+		_, err := c.db.PrimaryDeviceLink(tx)
+		if errors.Is(err, clientdb.ErrNotFound) {
+			return nil
+		}
+		c.linkedDevice = err == nil
+		return err
+	})
+}
+
+// initLinkedRemoteUser tracks a remote user of a linked device. These remote
+// users do not have a ratchet and are not run: messages to them are relayed
+// through the primary device.
+func (c *Client) initLinkedRemoteUser(entry *clientdb.AddressBookEntry) (*RemoteUser, error) {
+	ru := newRemoteUser(c.q, c.rmgr, c.db, entry.ID, c.id, nil)
+	ru.linked = true
+	ru.ignored = entry.Ignored
+	ru.compressLevel = c.cfg.CompressLevel
+	ru.log = c.cfg.logger(fmt.Sprintf("RUSR %x", entry.ID.Identity[:8]))
+	ru.logPayloads = c.cfg.logger(fmt.Sprintf("RMPL %x", entry.ID.Identity[:8]))
+	ru.rmHandler = c.handleUserRM
+
+	oldRU, err := c.rul.add(ru)
+	if errors.Is(err, alreadyHaveUserError{}) && oldRU != nil {
+		return oldRU, nil
+	}
+	return ru, err
+}
+
+// sendToDevice sends the message to the device of the given link. It returns
+// once the message has been accepted by the server.
+func (c *Client) sendToDevice(id zkidentity.ShortID, msg deviceMsg) error {
+	c.deviceSendMtx.Lock()
+	defer c.deviceSendMtx.Unlock()
+
+	var link *clientdb.DeviceLink
+	err := c.dbView(func(tx clientdb.ReadTx) error {
+		var err error
+		link, err = c.db.GetDeviceLink(tx, id)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	msg.Seq = link.SendSeq
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	sealed, err := sw.Seal(b, &link.Key)
+	if err != nil {
+		return err
+	}
+	rm := rawRM{
+		pri: priorityDefault,
+		msg: sealed,
+		rv:  deviceRV(link, true, msg.Seq),
+	}
+	if err := c.q.SendRM(rm); err != nil {
+		return err
+	}
+
+	c.devicesMtx.Lock()
+	defer c.devicesMtx.Unlock()
+	return c.dbUpdate(func(tx clientdb.ReadWriteTx) error {
+		link, err := c.db.GetDeviceLink(tx, id)
+		if errors.Is(err, clientdb.ErrNotFound) {
+			// Link was revoked while sending.
+			return nil
+		} else if err != nil {
+			return err
+		}
+		link.SendSeq = msg.Seq + 1
+		return c.db.SaveDeviceLink(tx, link)
+	})
+}
+
+// sendToPrimary sends the message to the primary device of this linked device.
+func (c *Client) sendToPrimary(msg deviceMsg) error {
+	var link *clientdb.DeviceLink
+	err := c.dbView(func(tx clientdb.ReadTx) error {
+		var err error
+		link, err = c.db.PrimaryDeviceLink(tx)
+		return err
+	})
+	if errors.Is(err, clientdb.ErrNotFound) {
+		return errDeviceUnlinked
+	}
+	if err != nil {
+		return err
+	}
+	return c.sendToDevice(link.DeviceID, msg)
+}
+
+// forwardToDevices sends the message to all linked devices of this primary
+// device, except the origin device (if specified).
+func (c *Client) forwardToDevices(msg deviceMsg, origin *zkidentity.ShortID) {
+	if c.linkedDevice {
+		return
+	}
+
+	var links []clientdb.DeviceLink
+	err := c.dbView(func(tx clientdb.ReadTx) error {
+		var err error
+		links, err = c.db.ListDeviceLinks(tx)
+		return err
+	})
+	if err != nil {
+		c.log.Errorf("Unable to list linked devices: %v", err)
+		return
+	}
+
+	for _, link := range links {
+		if origin != nil && link.DeviceID == *origin {
+			continue
+		}
+		if err := c.sendToDevice(link.DeviceID, msg); err != nil {
+			c.log.Errorf("Unable to send %q to device %s: %v",
+				msg.Type, link.DeviceID, err)
+		}
+	}
+}
+
+// forwardRMToDevices forwards an RM received from the remote user to the
+// linked devices. This is called after the RM has been handled.
+func (c *Client) forwardRMToDevices(ru *RemoteUser, h *rpc.RMHeader, rm []byte, ts time.Time) {
+	if !forwardedRMCmds[h.Command] {
+		return
+	}
+	c.forwardToDevices(deviceMsg{
+		Type:      deviceMsgRecvdRM,
+		Timestamp: ts,
+		UID:       ru.ID(),
+		RM:        rm,
+	}, nil)
+}
+
+// syncGCsToDevices sends the full list of GCs to the linked devices. This is
+// called after the GCs are modified in the primary device.
+func (c *Client) syncGCsToDevices() {
+	if c.linkedDevice {
+		return
+	}
+
+	msg := deviceMsg{Type: deviceMsgGCs, Timestamp: time.Now()}
+	var hasLinks bool
+	err := c.dbView(func(tx clientdb.ReadTx) error {
+		links, err := c.db.ListDeviceLinks(tx)
+		if err != nil || len(links) == 0 {
+			return err
+		}
+		hasLinks = true
+		gcs, err := c.db.ListGCs(tx)
+		if err != nil {
+			return err
+		}
+		for _, entry := range gcs {
+			gc, err := c.db.GetGC(tx, entry.ID)
+			if err != nil {
+				return err
+			}
+			msg.GCs = append(msg.GCs, gc)
+		}
+		msg.GCAliases, err = c.db.GetGCAliases(tx)
+		return err
+	})
+	if err != nil {
+		c.log.Errorf("Unable to list GCs to sync to devices: %v", err)
+		return
+	}
+	if !hasLinks {
+		return
+	}
+	c.forwardToDevices(msg, nil)
+}
+
+// listenDevice listens for the next message from the device of the given
+// link.
+func (c *Client) listenDevice(link *clientdb.DeviceLink) error {
+	id, seq := link.DeviceID, link.RecvSeq
+	rv := deviceRV(link, false, seq)
+	handler := func(blob lowlevel.RVBlob) error {
+		c.handleDeviceBlob(id, seq, blob)
+		return nil
+	}
+	c.log.Debugf("Listening for msg %d from device %s at RV %s", seq, id, rv)
+	return c.rmgr.Sub(rv, handler, nil)
+}
+
+// listenAllDevices listens for messages from all linked devices (or from the
+// primary device, when this is a linked device).
+func (c *Client) listenAllDevices() error {
+	var links []clientdb.DeviceLink
+	err := c.dbView(func(tx clientdb.ReadTx) error {
+		var err error
+		links, err = c.db.ListDeviceLinks(tx)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	for i := range links {
+		if err := c.listenDevice(&links[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// handleDeviceBlob handles a blob received from the device of the given link
+// at the RV of the message with the given seq.
+func (c *Client) handleDeviceBlob(id zkidentity.ShortID, seq uint64, blob lowlevel.RVBlob) {
+	var link *clientdb.DeviceLink
+	var msg deviceMsg
+	var dupe bool
+	c.devicesMtx.Lock()
+	err := c.dbUpdate(func(tx clientdb.ReadWriteTx) error {
+		var err error
+		link, err = c.db.GetDeviceLink(tx, id)
+		if err != nil {
+			return err
+		}
+		if link.RecvSeq > seq {
+			dupe = true
+			return nil
+		}
+		if link.RecvSeq != seq {
+			return fmt.Errorf("expected msg %d instead of %d",
+				link.RecvSeq, seq)
+		}
+
+		b, ok := sw.Open(blob.Decoded, &link.Key)
+		if !ok {
+			return fmt.Errorf("unable to decrypt msg %d", seq)
+		}
+		if err := json.Unmarshal(b, &msg); err != nil {
+			return fmt.Errorf("unable to decode msg %d: %v", seq, err)
+		}
+		if msg.Seq != seq {
+			return fmt.Errorf("msg has seq %d instead of %d", msg.Seq, seq)
+		}
+
+		link.RecvSeq += 1
+		return c.db.SaveDeviceLink(tx, link)
+	})
+	c.devicesMtx.Unlock()
+
+	switch {
+	case errors.Is(err, clientdb.ErrNotFound):
+		go c.rmgr.Unsub(blob.ID)
+		c.log.Debugf("Received msg from unlinked device %s", id)
+		return
+
+	case err != nil:
+		// Keep the subscription, so that handling the msg is retried
+		// when the server pushes it again.
+		c.log.Warnf("Unable to handle msg from device %s: %v", id, err)
+		return
+
+	case dupe:
+		go c.rmgr.Unsub(blob.ID)
+		c.log.Debugf("Received duplicate msg %d from device %s", seq, id)
+		return
+	}
+
+	// There's nothing else to receive in this RV.
+	go c.rmgr.Unsub(blob.ID)
+
+	// Listen for the next msg.
+	go func() {
+		if err := c.listenDevice(link); err != nil {
+			c.log.Errorf("Unable to listen to device %s: %v", id, err)
+		}
+	}()
+
+	if link.Primary {
+		err = c.handlePrimaryDeviceMsg(link, &msg)
+	} else {
+		err = c.handleLinkedDeviceMsg(link, &msg)
+	}
+	if err != nil {
+		c.log.Errorf("Unable to handle %q msg from device %s: %v",
+			msg.Type, id, err)
+	}
+}
+
+// handleLinkedDeviceMsg handles a message received by the primary device from
+// one of its linked devices.
+func (c *Client) handleLinkedDeviceMsg(link *clientdb.DeviceLink, msg *deviceMsg) error {
+	c.log.Debugf("Received %q from device %s (%q)", msg.Type,
+		link.DeviceID, link.Name)
+
+	switch msg.Type {
+	case deviceMsgRelayPM:
+		return c.pm(msg.UID, msg.MsgID, msg.ReplyTo, msg.Message, &link.DeviceID)
+
+	case deviceMsgRelayReceipt:
+		return c.MarkPMRead(msg.UID, msg.MsgID)
+
+	case deviceMsgRelayGCM:
+		return c.gcMessage(msg.GCID, msg.MsgID, msg.ReplyTo, msg.Message,
+			msg.Mode, msg.Mentions, nil, &link.DeviceID)
+
+	default:
+		return fmt.Errorf("unknown msg type %q", msg.Type)
+	}
+}
+
+// handlePrimaryDeviceMsg handles a message received by a linked device from
+// its primary device.
+func (c *Client) handlePrimaryDeviceMsg(link *clientdb.DeviceLink, msg *deviceMsg) error {
+	c.log.Debugf("Received %q from primary device", msg.Type)
+
+	switch msg.Type {
+	case deviceMsgRecvdRM:
+		ru, err := c.rul.byID(msg.UID)
+		if err != nil {
+			return err
+		}
+		h, p, err := rpc.DecomposeRM(ru.id, msg.RM)
+		if err != nil {
+			return fmt.Errorf("unable to decode forwarded RM: %v", err)
+		}
+		if !forwardedRMCmds[h.Command] {
+			return fmt.Errorf("RM %q cannot be forwarded", h.Command)
+		}
+		c.handleUserRM(ru, h, p, msg.Timestamp)
+		return nil
+
+	case deviceMsgSentPM:
+		return c.dbUpdate(func(tx clientdb.ReadWriteTx) error {
+			return c.db.LogPM(tx, msg.UID, clientdb.HistoryEntry{
+				Timestamp: msg.Timestamp,
+				From:      c.id.Public.Nick,
+				FromUID:   c.id.Public.Identity,
+				Mode:      rpc.MessageModeNormal,
+				Message:   msg.Message,
+				MsgID:     msg.MsgID,
+				ReplyTo:   msg.ReplyTo,
+			})
+		})
+
+	case deviceMsgSentGCM:
+		gcAlias, err := c.GetGCAlias(msg.GCID)
+		if err != nil {
+			gcAlias = msg.GCID.String()
+		}
+		return c.dbUpdate(func(tx clientdb.ReadWriteTx) error {
+			return c.db.LogGCMsg(tx, gcAlias, msg.GCID, clientdb.HistoryEntry{
+				Timestamp: msg.Timestamp,
+				From:      c.id.Public.Nick,
+				FromUID:   c.id.Public.Identity,
+				Mode:      msg.Mode,
+				Message:   msg.Message,
+				MsgID:     msg.MsgID,
+				ReplyTo:   msg.ReplyTo,
+				Mentions:  msg.Mentions,
+			})
+		})
+
+	case deviceMsgSentEdit, deviceMsgSentRetract:
+		retract := msg.Type == deviceMsgSentRetract
+		err := c.dbUpdate(func(tx clientdb.ReadWriteTx) error {
+			return c.updateMsgHistory(tx, msg.UID, msg.GCID, msg.MsgID,
+				c.PublicID(), msg.Message, msg.Timestamp, retract)
+		})
+		if errors.Is(err, clientdb.ErrNotFound) {
+			err = nil
+		}
+		return err
+
+	case deviceMsgUser:
+		if msg.Identity == nil || !msg.Identity.Verify() {
+			return fmt.Errorf("invalid user identity")
+		}
+		entry := &clientdb.AddressBookEntry{ID: msg.Identity}
+		err := c.dbUpdate(func(tx clientdb.ReadWriteTx) error {
+			return c.db.UpdateAddressBookEntry(tx, msg.Identity,
+				clientdb.RawRVID{}, clientdb.RawRVID{}, false)
+		})
+		if err != nil {
+			return err
+		}
+		ru, err := c.initLinkedRemoteUser(entry)
+		if err != nil {
+			return err
+		}
+		if c.cfg.KXCompleted != nil {
+			c.cfg.KXCompleted(ru)
+		}
+		return nil
+
+	case deviceMsgGCs:
+		return c.replaceGCs(msg.GCs, msg.GCAliases)
+
+	case deviceMsgUnlinked:
+		c.log.Warnf("This device was unlinked by the primary device")
+		return c.dbUpdate(func(tx clientdb.ReadWriteTx) error {
+			return c.db.RemoveDeviceLink(tx, link.DeviceID)
+		})
+
+	default:
+		return fmt.Errorf("unknown msg type %q", msg.Type)
+	}
+}
+
+// replaceGCs replaces the GCs of a linked device with the ones of the primary
+// device.
+func (c *Client) replaceGCs(gcs []rpc.RMGroupList, aliases map[string]zkidentity.ShortID) error {
+	var updated []rpc.RMGroupList
+	err := c.dbUpdate(func(tx clientdb.ReadWriteTx) error {
+		keep := make(map[zkidentity.ShortID]bool, len(gcs))
+		for _, gc := range gcs {
+			keep[gc.ID] = true
+			old, err := c.db.GetGC(tx, gc.ID)
+			if err == nil && old.Generation == gc.Generation &&
+				old.Timestamp == gc.Timestamp {
+				continue
+			}
+			if err := c.db.SaveGC(tx, gc); err != nil {
+				return err
+			}
+			updated = append(updated, gc)
+		}
+
+		existing, err := c.db.ListGCs(tx)
+		if err != nil {
+			return err
+		}
+		for _, entry := range existing {
+			if keep[entry.ID] {
+				continue
+			}
+			if err := c.db.DeleteGC(tx, entry.ID); err != nil {
+				return err
+			}
+			if _, err := c.db.SetGCAlias(tx, entry.ID, ""); err != nil {
+				return err
+			}
+		}
+
+		aliasMap, err := c.db.GetGCAliases(tx)
+		if err != nil {
+			return err
+		}
+		hasAlias := make(map[zkidentity.ShortID]bool, len(aliasMap))
+		for _, id := range aliasMap {
+			hasAlias[id] = true
+		}
+		for alias, id := range aliases {
+			if !keep[id] || hasAlias[id] {
+				continue
+			}
+			if aliasMap, err = c.db.SetGCAlias(tx, id, alias); err != nil {
+				return err
+			}
+		}
+		c.setGCAlias(aliasMap)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if c.cfg.GCListUpdated != nil {
+		for _, gc := range updated {
+			var entry clientdb.GCAddressBookEntry
+			clientdb.RMGroupListToGCEntry(&gc, &entry)
+			c.cfg.GCListUpdated(entry)
+		}
+	}
+	return nil
+}
+
+// ListDevices lists the linked devices of this (primary) device. On a linked
+// device, this returns the link to the primary device.
+func (c *Client) ListDevices() ([]clientdb.DeviceLink, error) {
+	var links []clientdb.DeviceLink
+	err := c.dbView(func(tx clientdb.ReadTx) error {
+		var err error
+		links, err = c.db.ListDeviceLinks(tx)
+		return err
+	})
+	return links, err
+}
+
+// PairDevice links a new device with the given name to this (primary) device.
+// The pairing file, which must be imported in the new device with
+// ImportDevicePairing, is written to w and encrypted with the passphrase.
+//
+// The pairing file has the local identity, so it must be kept as secure as a
+// backup.
+func (c *Client) PairDevice(name string, w io.Writer, passphrase []byte) (clientdb.DeviceLink, error) {
+	if c.linkedDevice {
+		return clientdb.DeviceLink{}, errLinkedDevice
+	}
+
+	link := clientdb.DeviceLink{
+		Name:    name,
+		Created: time.Now(),
+	}
+	if _, err := io.ReadFull(rand.Reader, link.DeviceID[:]); err != nil {
+		return link, err
+	}
+	if _, err := io.ReadFull(rand.Reader, link.Key[:]); err != nil {
+		return link, err
+	}
+
+	err := c.dbUpdate(func(tx clientdb.ReadWriteTx) error {
+		if err := c.db.ExportDevicePairing(tx, w, &link, passphrase); err != nil {
+			return err
+		}
+		return c.db.SaveDeviceLink(tx, &link)
+	})
+	if err != nil {
+		return link, err
+	}
+
+	c.log.Infof("Paired new device %s (%q)", link.DeviceID, name)
+	return link, c.listenDevice(&link)
+}
+
+// ImportDevicePairing validates the pairing file created with PairDevice in
+// the primary device and stages it to be imported. The pairing replaces the
+// entire client state the next time the client is started, after which this
+// client is a linked device of the primary device's identity.
+func (c *Client) ImportDevicePairing(r io.Reader, passphrase []byte) error {
+	return c.db.ImportDevicePairing(r, passphrase)
+}
+
+// RevokeDevice unlinks the given device from this (primary) device. The
+// revoked device no longer receives or sends messages on behalf of the local
+// identity.
+func (c *Client) RevokeDevice(id zkidentity.ShortID) error {
+	if c.linkedDevice {
+		return errLinkedDevice
+	}
+
+	// Let the device know it was unlinked. This is done before removing
+	// the link, which is needed to send the msg.
+	msg := deviceMsg{Type: deviceMsgUnlinked, Timestamp: time.Now()}
+	if err := c.sendToDevice(id, msg); err != nil {
+		c.log.Warnf("Unable to send unlink msg to device %s: %v", id, err)
+	}
+
+	var link *clientdb.DeviceLink
+	c.devicesMtx.Lock()
+	err := c.dbUpdate(func(tx clientdb.ReadWriteTx) error {
+		var err error
+		if link, err = c.db.GetDeviceLink(tx, id); err != nil {
+			return err
+		}
+		return c.db.RemoveDeviceLink(tx, id)
+	})
+	c.devicesMtx.Unlock()
+	if err != nil {
+		return err
+	}
+
+	c.log.Infof("Revoked device %s (%q)", id, link.Name)
+	return c.rmgr.Unsub(deviceRV(link, false, link.RecvSeq))
+}
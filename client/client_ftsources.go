@@ -0,0 +1,259 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/companyzero/bisonrelay/client/clientdb"
+	"github.com/companyzero/bisonrelay/client/clientintf"
+	"github.com/companyzero/bisonrelay/rpc"
+	"golang.org/x/exp/slices"
+)
+
+// The find sources flow is used to download a file from multiple users at the
+// same time:
+//
+//          Alice                                    Bob
+//         -------                                  -----
+//   findFileSources()
+//         \--------- RMFTFindSources -->
+//
+//                                              handleFTFindSources()
+//                          <-- RMFTFindSourcesReply ------/
+//
+//   handleFTFindSourcesReply()
+//   (chunks are requested from both the original user and Bob)
+//
+
+// findFileSources asks the remote users that are plausible holders of the file
+// being downloaded (other than the ones that are already sources of the
+// download) whether they share a file with the same content.
+//
+// Only the members of the GCs the original user is a member of are asked, so
+// that the request does not reveal the download to every remote user.
+func (c *Client) findFileSources(fd clientdb.FileDownload) {
+	if fd.Metadata == nil || fd.IsSentFile {
+		return
+	}
+
+	var gcs []clientdb.GCAddressBookEntry
+	err := c.dbView(func(tx clientdb.ReadTx) error {
+		var err error
+		gcs, err = c.db.ListGCs(tx)
+		return err
+	})
+	if err != nil {
+		c.log.Errorf("Unable to list GCs to find sources of file %s: %v",
+			fd.FID, err)
+		return
+	}
+
+	myID := c.PublicID()
+	var uids []UserID
+	for _, gc := range gcs {
+		if !slices.Contains(gc.Members, fd.UID) {
+			continue
+		}
+		for _, uid := range gc.Members {
+			if uid == myID || uid == fd.UID || slices.Contains(uids, uid) {
+				continue
+			}
+			if _, ok := fd.SourceFID(uid); ok {
+				continue
+			}
+			ru, err := c.rul.byID(uid)
+			if err != nil || ru.IsIgnored() {
+				continue
+			}
+			uids = append(uids, uid)
+		}
+	}
+	if len(uids) == 0 {
+		return
+	}
+
+	c.log.Debugf("Looking for sources of file %q (%s) among %d users",
+		fd.Metadata.Filename, fd.FID, len(uids))
+	rm := rpc.RMFTFindSources{Hash: fd.Metadata.Hash}
+	payEvent := fmt.Sprintf("ftfindsources.%s", fd.FID.ShortLogID())
+	err = c.sendWithSendQ(payEvent, rm, uids...)
+	if err != nil && !errors.Is(err, clientintf.ErrSubsysExiting) {
+		c.log.Errorf("Unable to send request for sources of file %s: %v",
+			fd.FID, err)
+	}
+}
+
+// FindFileSources asks the remote users that share a GC with the original user
+// whether they share a file with the same content as the outstanding download
+// of the given file, so that its chunks may be downloaded from multiple users
+// at the same time.
+func (c *Client) FindFileSources(fid clientdb.FileID) error {
+	if !c.cfg.MultiSourceDownloads {
+		return errMultiSourceDLOff
+	}
+
+	var fd clientdb.FileDownload
+	err := c.dbView(func(tx clientdb.ReadTx) error {
+		var err error
+		fd, err = c.readOutstandingDownload(tx, fid)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if fd.Metadata == nil {
+		return fmt.Errorf("metadata of file %s not received yet", fid)
+	}
+	if fd.IsSentFile {
+		return fmt.Errorf("file %s is being sent by the remote user", fid)
+	}
+	c.findFileSources(fd)
+	return nil
+}
+
+// handleFTFindSources handles a request from a remote user to list the local
+// files with a given content hash. Only files the remote user is able to
+// download are listed, and no reply is sent if there are no such files.
+func (c *Client) handleFTFindSources(ru *RemoteUser, fs rpc.RMFTFindSources) error {
+	if fs.Hash == "" {
+		return fmt.Errorf("empty hash in find sources request")
+	}
+
+	var files []rpc.FileMetadata
+	err := c.dbView(func(tx clientdb.ReadTx) error {
+		global, err := c.db.ListSharedFiles(tx, nil)
+		if err != nil {
+			return err
+		}
+		id := ru.ID()
+		shared, err := c.db.ListSharedFiles(tx, &id)
+		if err != nil {
+			return err
+		}
+		for _, md := range append(global, shared...) {
+			if md.Hash == fs.Hash {
+				files = append(files, md)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return nil
+	}
+
+	ru.log.Debugf("Replying to find sources request with %d files", len(files))
+	reply := rpc.RMFTFindSourcesReply{
+		Hash:  fs.Hash,
+		Files: files,
+	}
+	return ru.sendRM(reply, "ftfindsourcesreply")
+}
+
+// handleFTFindSourcesReply handles the list of files with the same content
+// as one of the outstanding downloads. The remote user is added as a source of
+// every matching download.
+func (c *Client) handleFTFindSourcesReply(ru *RemoteUser, fsr rpc.RMFTFindSourcesReply) error {
+	if !c.cfg.MultiSourceDownloads {
+		ru.log.Debugf("Ignoring find sources reply when multi-source " +
+			"downloads are disabled")
+		return nil
+	}
+
+	var added []clientdb.FileDownload
+	err := c.dbUpdate(func(tx clientdb.ReadWriteTx) error {
+		fds, err := c.db.ListOutstandingDownloads(tx)
+		if err != nil {
+			return err
+		}
+		for _, fd := range fds {
+			fd := fd
+			if fd.Metadata == nil || fd.IsSentFile || fd.Metadata.Hash != fsr.Hash {
+				continue
+			}
+			if _, ok := fd.SourceFID(ru.ID()); ok {
+				continue
+			}
+
+			for _, md := range fsr.Files {
+				// Avoid paying more for the chunks than what
+				// the original user requested.
+				if md.Cost > fd.Metadata.Cost {
+					ru.log.Debugf("Ignoring source of file %s "+
+						"due to higher cost (%d > %d)",
+						fd.FID, md.Cost, fd.Metadata.Cost)
+					continue
+				}
+				err := c.db.AddFileDownloadSource(tx, &fd, ru.id, md)
+				if err != nil {
+					ru.log.Debugf("Ignoring source of file %s: %v",
+						fd.FID, err)
+					continue
+				}
+				added = append(added, fd)
+				break
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Restart the downloads so that the chunks are also requested from
+	// the new source.
+	for _, fd := range added {
+		fd := fd
+		ru.log.Infof("Added user as source of download of file %q (%s)",
+			fd.Metadata.Filename, fd.FID)
+		go func() {
+			err := c.downloadChunks(fd)
+			if err != nil && !errors.Is(err, clientintf.ErrSubsysExiting) {
+				c.log.Errorf("Error downloading chunks of file %s: %v",
+					fd.FID, err)
+			}
+		}()
+	}
+	return nil
+}
+
+// runDownloadsJanitor periodically processes the outstanding downloads that
+// have multiple sources, so that chunks that were not received from one of
+// the sources (for example, because it went offline) are requested from the
+// other sources.
+func (c *Client) runDownloadsJanitor(ctx context.Context) error {
+	interval := c.cfg.multiSourceChunkTimeout()
+	for {
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return nil
+		}
+
+		var fds []clientdb.FileDownload
+		err := c.dbView(func(tx clientdb.ReadTx) error {
+			var err error
+			fds, err = c.db.ListOutstandingDownloads(tx)
+			return err
+		})
+		if err != nil {
+			c.log.Errorf("Unable to list outstanding downloads: %v", err)
+			continue
+		}
+
+		for _, fd := range fds {
+			if len(fd.Sources) == 0 || fd.Metadata == nil {
+				continue
+			}
+			err := c.downloadChunks(fd)
+			if err != nil && !errors.Is(err, clientintf.ErrSubsysExiting) {
+				c.log.Errorf("Error downloading chunks of file %s: %v",
+					fd.FID, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,391 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/companyzero/bisonrelay/client/clientdb"
+	"github.com/companyzero/bisonrelay/rpc"
+	"github.com/companyzero/bisonrelay/zkidentity"
+	"golang.org/x/exp/slices"
+)
+
+// filterGCMembers returns the ids that are members of the GC, excluding the
+// skip id.
+func filterGCMembers(gc *rpc.RMGroupList, ids []zkidentity.ShortID,
+	skip zkidentity.ShortID) []zkidentity.ShortID {
+
+	var res []zkidentity.ShortID
+	for _, id := range ids {
+		if id == skip {
+			continue
+		}
+		for i := range gc.Members {
+			if gc.Members[i] == id {
+				res = append(res, id)
+				break
+			}
+		}
+	}
+	return res
+}
+
+// signGCAdmins drops roles of users that are no longer members of the GC,
+// increments the admins generation and signs the new set of admins. This must
+// only be called by the owner of the GC.
+func (c *Client) signGCAdmins(gc *rpc.RMGroupList) {
+	owner := gc.Members[0]
+	gc.ExtraAdmins = filterGCMembers(gc, gc.ExtraAdmins, owner)
+	gc.Moderators = filterGCMembers(gc, gc.Moderators, owner)
+	gc.AdminsGeneration += 1
+	h := gc.AdminsHash()
+	gc.AdminsSig = c.id.SignMessage(h[:])
+}
+
+// gcMemberIdentity returns the public identity of the given GC member.
+func (c *Client) gcMemberIdentity(uid UserID) (*zkidentity.PublicIdentity, error) {
+	if uid == c.PublicID() {
+		return &c.id.Public, nil
+	}
+	ru, err := c.rul.byID(uid)
+	if err != nil {
+		return nil, err
+	}
+	return ru.id, nil
+}
+
+// verifyGCAdmins verifies that any changes to the owner and the set of admins
+// and moderators from oldGC to gl were signed by the owner of oldGC. When
+// oldGC is nil, gl is the first list received for the GC and its admins are
+// verified against its own owner.
+//
+// If the owner is not KX'd with the local client, an error wrapping
+// errUnknownGCSigner is returned, and the list should be handled again after
+// KX with the owner completes.
+func (c *Client) verifyGCAdmins(oldGC, gl *rpc.RMGroupList) error {
+	if len(gl.Members) == 0 {
+		return fmt.Errorf("gc list %s has no members", gl.ID)
+	}
+
+	var signer UserID
+	if oldGC != nil {
+		if gl.AdminsGeneration < oldGC.AdminsGeneration {
+			return fmt.Errorf("received gc list %s with wrong admins "+
+				"generation (%d < %d)", gl.ID, gl.AdminsGeneration,
+				oldGC.AdminsGeneration)
+		}
+		if gl.AdminsGeneration == oldGC.AdminsGeneration {
+			if gl.AdminsHash() != oldGC.AdminsHash() {
+				return fmt.Errorf("received gc list %s with modified "+
+					"admins without a new admins generation", gl.ID)
+			}
+			return nil
+		}
+		if len(oldGC.Members) == 0 {
+			return fmt.Errorf("gc %s has no owner", oldGC.ID)
+		}
+		signer = oldGC.Members[0]
+	} else {
+		if gl.AdminsGeneration == 0 && len(gl.ExtraAdmins) == 0 &&
+			len(gl.Moderators) == 0 {
+			// Only the owner is an admin.
+			return nil
+		}
+		signer = gl.Members[0]
+	}
+
+	id, err := c.gcMemberIdentity(signer)
+	if errors.Is(err, userNotFoundError{}) {
+		return unknownGCSignerError{gcID: gl.ID, uid: signer}
+	}
+	if err != nil {
+		return err
+	}
+	h := gl.AdminsHash()
+	if !id.VerifyMessage(h[:], gl.AdminsSig) {
+		return fmt.Errorf("gc list %s: %w", gl.ID, errInvalidAdminsSig)
+	}
+	return nil
+}
+
+// verifyGCKickList verifies that gl, the list sent in a kick of member from
+// the GC oldGC, only removes member from the GC. The remaining members must
+// keep their order and the admins and moderators may only drop the users that
+// are no longer members of the GC.
+func verifyGCKickList(oldGC, gl *rpc.RMGroupList, member UserID) error {
+	if gl.Name != oldGC.Name {
+		return fmt.Errorf("gc list %s in kick changed the gc name", gl.ID)
+	}
+
+	wantMembers := make([]zkidentity.ShortID, 0, len(oldGC.Members))
+	for _, uid := range oldGC.Members {
+		if uid != member {
+			wantMembers = append(wantMembers, uid)
+		}
+	}
+	if len(wantMembers) == len(oldGC.Members) {
+		return fmt.Errorf("gc list %s in kick: %w", gl.ID, errNotGCMember)
+	}
+	if !slices.Equal(gl.Members, wantMembers) {
+		return fmt.Errorf("gc list %s in kick modified other members", gl.ID)
+	}
+
+	// The owner drops the roles of users that are no longer members when
+	// kicking a user with a role.
+	sameRoles := func(newIDs, oldIDs []zkidentity.ShortID) bool {
+		return slices.Equal(newIDs, oldIDs) ||
+			slices.Equal(newIDs, filterGCMembers(gl, oldIDs, gl.Members[0]))
+	}
+	if !sameRoles(gl.ExtraAdmins, oldGC.ExtraAdmins) {
+		return fmt.Errorf("gc list %s in kick modified the admins", gl.ID)
+	}
+	if !sameRoles(gl.Moderators, oldGC.Moderators) {
+		return fmt.Errorf("gc list %s in kick modified the moderators", gl.ID)
+	}
+	return nil
+}
+
+// signGCList signs the contents of the GC list with the local identity.
+func (c *Client) signGCList(gc *rpc.RMGroupList) {
+	gc.Signer = c.PublicID()
+	h := gc.SignedHash()
+	gc.Signature = c.id.SignMessage(h[:])
+}
+
+// verifyGCListSig verifies the signature of a GC list received from the remote
+// user. The signer of the list must be allowed to produce it, according to
+// isAllowed. Lists from older clients are not signed, in which case the remote
+// user itself must be allowed to send the list, unless the admins of the
+// current GC (oldGC) were already signed by its owner.
+//
+// If the signer is not KX'd with the local client, an error wrapping
+// errUnknownGCSigner is returned, and the list should be handled again after
+// KX with the signer completes.
+func (c *Client) verifyGCListSig(ru *RemoteUser, oldGC, gl *rpc.RMGroupList,
+	isAllowed func(uid UserID) bool) error {
+
+	if gl.Signer.IsEmpty() {
+		if oldGC.AdminsSig != (zkidentity.FixedSizeSignature{}) {
+			return fmt.Errorf("gc list %s: %w", gl.ID, errUnsignedGCList)
+		}
+		if !isAllowed(ru.ID()) {
+			return fmt.Errorf("received unsigned gc list %s from "+
+				"non-admin", gl.ID)
+		}
+		return nil
+	}
+
+	if !isAllowed(gl.Signer) {
+		return fmt.Errorf("gc list %s signed by non-admin %s", gl.ID,
+			gl.Signer)
+	}
+	id, err := c.gcMemberIdentity(gl.Signer)
+	if errors.Is(err, userNotFoundError{}) {
+		return unknownGCSignerError{gcID: gl.ID, uid: gl.Signer}
+	}
+	if err != nil {
+		return fmt.Errorf("unable to verify signature of gc list %s: %v",
+			gl.ID, err)
+	}
+	h := gl.SignedHash()
+	if !id.VerifyMessage(h[:], gl.Signature) {
+		return fmt.Errorf("gc list %s: %w", gl.ID, errInvalidGCListSig)
+	}
+	return nil
+}
+
+// maxGCListsAwaitingKX is the max number of GC lists signed by a single user
+// that are kept while waiting for KX with the user to complete.
+const maxGCListsAwaitingKX = 8
+
+// queueGCListUntilKX queues handling a GC list that was signed by an admin of
+// the GC that is not KX'd with the local client yet, as reported by err.
+// handle is called again once KX with the signer completes. When meshKX is
+// true, a mesh KX pass is started in the GC to KX with the signer. Otherwise
+// (when the GC is not stored yet), a KX with the signer is requested from the
+// remote user.
+func (c *Client) queueGCListUntilKX(ru *RemoteUser, gl *rpc.RMGroupList,
+	err error, meshKX bool, handle func() error) {
+
+	signer := gl.Signer
+	var signerErr unknownGCSignerError
+	if errors.As(err, &signerErr) {
+		signer = signerErr.uid
+	}
+
+	c.gcListsAwaitingKXMtx.Lock()
+	queue := append(c.gcListsAwaitingKX[signer], handle)
+	if len(queue) > maxGCListsAwaitingKX {
+		queue = queue[len(queue)-maxGCListsAwaitingKX:]
+	}
+	c.gcListsAwaitingKX[signer] = queue
+	c.gcListsAwaitingKXMtx.Unlock()
+
+	ru.log.Infof("Delaying gc list %s signed by %s until KX with the "+
+		"signer completes", gl.ID, signer)
+	if meshKX {
+		ruID := ru.ID()
+		c.startGCMeshKX(gl.ID, &ruID, false)
+	} else if len(queue) == 1 {
+		err := c.RequestMediateIdentity(ru.ID(), signer)
+		if err != nil {
+			ru.log.Warnf("Unable to request KX with signer %s of gc "+
+				"list %s: %v", signer, gl.ID, err)
+		}
+	}
+}
+
+// handleGCListsAwaitingKX handles the GC lists signed by the given user that
+// were queued while waiting for KX with the user to complete.
+func (c *Client) handleGCListsAwaitingKX(uid UserID) {
+	c.gcListsAwaitingKXMtx.Lock()
+	queue := c.gcListsAwaitingKX[uid]
+	delete(c.gcListsAwaitingKX, uid)
+	c.gcListsAwaitingKXMtx.Unlock()
+
+	for _, handle := range queue {
+		if err := handle(); err != nil {
+			c.log.Warnf("Unable to handle gc list signed by %s after "+
+				"KX: %v", uid, err)
+		}
+	}
+}
+
+// modifyGCAdmins applies f to the GC, which must be owned by the local client,
+// then signs the new set of admins and sends the updated list to the GC
+// members.
+func (c *Client) modifyGCAdmins(gcID zkidentity.ShortID, uid UserID,
+	f func(gc *rpc.RMGroupList) error) error {
+
+	if c.linkedDevice {
+		return errLinkedDevice
+	}
+
+	var gc rpc.RMGroupList
+	err := c.dbUpdate(func(tx clientdb.ReadWriteTx) error {
+		var err error
+		gc, err = c.db.GetGC(tx, gcID)
+		if err != nil {
+			return err
+		}
+		if !gc.IsOwner(c.PublicID()) {
+			return errNotGCOwner
+		}
+		if uid == c.PublicID() {
+			return fmt.Errorf("cannot change the role of the GC owner")
+		}
+		if len(filterGCMembers(&gc, []zkidentity.ShortID{uid}, c.PublicID())) == 0 {
+			return errNotGCMember
+		}
+
+		if err := f(&gc); err != nil {
+			return err
+		}
+		c.signGCAdmins(&gc)
+		gc.Generation += 1
+		gc.Timestamp = time.Now().Unix()
+		c.signGCList(&gc)
+		return c.db.SaveGC(tx, gc)
+	})
+	if err != nil {
+		return err
+	}
+	c.syncGCsToDevices()
+
+	c.sendToGCMembers(gcID, gc.Members, "sendlist", gc, nil)
+
+	if c.cfg.GCListUpdated != nil {
+		var entry clientdb.GCAddressBookEntry
+		clientdb.RMGroupListToGCEntry(&gc, &entry)
+		c.cfg.GCListUpdated(entry)
+	}
+	return nil
+}
+
+// removeID returns ids without id.
+func removeID(ids []zkidentity.ShortID, id zkidentity.ShortID) []zkidentity.ShortID {
+	res := make([]zkidentity.ShortID, 0, len(ids))
+	for i := range ids {
+		if ids[i] != id {
+			res = append(res, ids[i])
+		}
+	}
+	return res
+}
+
+// AddGCAdmin makes the given member of the GC an admin (or a moderator, if
+// moderator is true). Admins may invite and kick members, while moderators may
+// only kick members without a role in the GC. Only the owner of the GC may
+// add admins.
+func (c *Client) AddGCAdmin(gcID zkidentity.ShortID, uid UserID, moderator bool) error {
+	err := c.modifyGCAdmins(gcID, uid, func(gc *rpc.RMGroupList) error {
+		gc.ExtraAdmins = removeID(gc.ExtraAdmins, uid)
+		gc.Moderators = removeID(gc.Moderators, uid)
+		if moderator {
+			gc.Moderators = append(gc.Moderators, uid)
+		} else {
+			gc.ExtraAdmins = append(gc.ExtraAdmins, uid)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	role := "admin"
+	if moderator {
+		role = "moderator"
+	}
+	c.log.Infof("Made user %s a %s of GC %s", uid, role, gcID)
+	return nil
+}
+
+// RemoveGCAdmin removes the admin or moderator role of the given member of the
+// GC. Only the owner of the GC may remove admins.
+func (c *Client) RemoveGCAdmin(gcID zkidentity.ShortID, uid UserID) error {
+	err := c.modifyGCAdmins(gcID, uid, func(gc *rpc.RMGroupList) error {
+		if !gc.IsAdmin(uid) && !gc.IsModerator(uid) {
+			return fmt.Errorf("user %s is not an admin of the GC", uid)
+		}
+		gc.ExtraAdmins = removeID(gc.ExtraAdmins, uid)
+		gc.Moderators = removeID(gc.Moderators, uid)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	c.log.Infof("Removed admin role of user %s in GC %s", uid, gcID)
+	return nil
+}
+
+// TransferGCOwnership makes the given member the new owner of the GC. The local
+// client remains as an admin of the GC.
+func (c *Client) TransferGCOwnership(gcID zkidentity.ShortID, uid UserID) error {
+	me := c.PublicID()
+	err := c.modifyGCAdmins(gcID, uid, func(gc *rpc.RMGroupList) error {
+		// Swap the positions of the old and new owner in the list of
+		// members, such that the new owner is Members[0].
+		members := make([]zkidentity.ShortID, len(gc.Members))
+		copy(members, gc.Members)
+		for i := range members {
+			if members[i] == uid {
+				members[i] = members[0]
+				members[0] = uid
+				break
+			}
+		}
+		gc.Members = members
+
+		gc.ExtraAdmins = append(removeID(gc.ExtraAdmins, uid), me)
+		gc.Moderators = removeID(gc.Moderators, uid)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	c.log.Infof("Transferred ownership of GC %s to user %s", gcID, uid)
+	return nil
+}
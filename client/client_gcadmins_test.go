@@ -0,0 +1,107 @@
+package client
+
+import (
+	"testing"
+
+	"github.com/companyzero/bisonrelay/rpc"
+	"github.com/companyzero/bisonrelay/zkidentity"
+)
+
+// TestVerifyGCKickList tests that the lists sent in kicks may only remove the
+// kicked member from the GC.
+func TestVerifyGCKickList(t *testing.T) {
+	owner, admin, mod := zkidentity.ShortID{1}, zkidentity.ShortID{2}, zkidentity.ShortID{3}
+	member, other, parted := zkidentity.ShortID{4}, zkidentity.ShortID{5}, zkidentity.ShortID{6}
+	oldGC := rpc.RMGroupList{
+		Name:        "gc",
+		Members:     []zkidentity.ShortID{owner, admin, mod, member, other},
+		ExtraAdmins: []zkidentity.ShortID{admin, parted},
+		Moderators:  []zkidentity.ShortID{mod},
+	}
+	ids := func(ids ...zkidentity.ShortID) []zkidentity.ShortID { return ids }
+
+	tests := []struct {
+		name   string
+		kicked zkidentity.ShortID
+		modify func(gl *rpc.RMGroupList)
+		valid  bool
+	}{{
+		name:   "kick member",
+		kicked: member,
+		modify: func(gl *rpc.RMGroupList) {
+			gl.Members = ids(owner, admin, mod, other)
+		},
+		valid: true,
+	}, {
+		name:   "kick moderator dropping roles",
+		kicked: mod,
+		modify: func(gl *rpc.RMGroupList) {
+			gl.Members = ids(owner, admin, member, other)
+			gl.ExtraAdmins = ids(admin)
+			gl.Moderators = nil
+		},
+		valid: true,
+	}, {
+		name:   "kicked user not removed",
+		kicked: member,
+		modify: func(gl *rpc.RMGroupList) {},
+	}, {
+		name:   "kicked user not a member",
+		kicked: parted,
+		modify: func(gl *rpc.RMGroupList) {},
+	}, {
+		name:   "other member removed",
+		kicked: member,
+		modify: func(gl *rpc.RMGroupList) {
+			gl.Members = ids(owner, admin, mod)
+		},
+	}, {
+		name:   "member added",
+		kicked: member,
+		modify: func(gl *rpc.RMGroupList) {
+			gl.Members = ids(owner, admin, mod, other, parted)
+		},
+	}, {
+		name:   "owner replaced",
+		kicked: member,
+		modify: func(gl *rpc.RMGroupList) {
+			gl.Members = ids(mod, admin, owner, other)
+		},
+	}, {
+		name:   "admin added",
+		kicked: member,
+		modify: func(gl *rpc.RMGroupList) {
+			gl.Members = ids(owner, admin, mod, other)
+			gl.ExtraAdmins = ids(admin, parted, mod)
+		},
+	}, {
+		name:   "moderator dropped",
+		kicked: member,
+		modify: func(gl *rpc.RMGroupList) {
+			gl.Members = ids(owner, admin, mod, other)
+			gl.Moderators = nil
+		},
+	}, {
+		name:   "name changed",
+		kicked: member,
+		modify: func(gl *rpc.RMGroupList) {
+			gl.Members = ids(owner, admin, mod, other)
+			gl.Name = "renamed"
+		},
+	}}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			gl := oldGC
+			tc.modify(&gl)
+			err := verifyGCKickList(&oldGC, &gl, tc.kicked)
+			if tc.valid && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !tc.valid && err == nil {
+				t.Fatalf("unexpected success")
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/companyzero/bisonrelay/client/clientdb"
+	"github.com/companyzero/bisonrelay/zkidentity"
+)
+
+// gcInvitesJanitorInterval is the interval between runs of the janitor that
+// removes expired GC invites.
+const gcInvitesJanitorInterval = time.Hour
+
+// removeExpiredGCInvites removes the sent and received GC invites that have
+// expired.
+func (c *Client) removeExpiredGCInvites() error {
+	var removed []*clientdb.GCInvite
+	err := c.dbUpdate(func(tx clientdb.ReadWriteTx) error {
+		var err error
+		removed, err = c.db.DelExpiredGCInvites(tx, time.Now())
+		return err
+	})
+	for _, inv := range removed {
+		c.log.Debugf("Removed expired invite %d to GC %s (user %s)",
+			inv.ID, inv.Invite.ID, inv.User)
+	}
+	return err
+}
+
+// runGCInvitesJanitor periodically removes the expired GC invites.
+func (c *Client) runGCInvitesJanitor(ctx context.Context) error {
+	for {
+		if err := c.removeExpiredGCInvites(); err != nil {
+			c.log.Errorf("Unable to remove expired GC invites: %v", err)
+		}
+
+		select {
+		case <-time.After(gcInvitesJanitorInterval):
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// listSentGCInvites returns the outstanding invites sent by the local client
+// for the given GC. Received invites are never for GCs the local client is
+// already a member of, so any invite for the GC is one sent by the local
+// client.
+func (c *Client) listSentGCInvites(tx clientdb.ReadTx, gcID zkidentity.ShortID) ([]*clientdb.GCInvite, error) {
+	if _, err := c.db.GetGC(tx, gcID); err != nil {
+		return nil, err
+	}
+	invites, err := c.db.ListGCInvites(tx, gcID.String())
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	var res []*clientdb.GCInvite
+	for _, inv := range invites {
+		if inv.Invite.ID == gcID && !inv.Invite.IsExpired(now) {
+			res = append(res, inv)
+		}
+	}
+	return res, nil
+}
+
+// ListSentGCInvites returns the invites sent by the local client for the given
+// GC which were not yet accepted by the invitees and have not expired.
+func (c *Client) ListSentGCInvites(gcID zkidentity.ShortID) ([]*clientdb.GCInvite, error) {
+	var invites []*clientdb.GCInvite
+	err := c.dbView(func(tx clientdb.ReadTx) error {
+		var err error
+		invites, err = c.listSentGCInvites(tx, gcID)
+		return err
+	})
+	return invites, err
+}
+
+// RevokeGCInvite revokes the outstanding invites sent to the given user to join
+// the GC. Attempts by the user to join the GC with the revoked invites are
+// rejected.
+func (c *Client) RevokeGCInvite(gcID zkidentity.ShortID, uid UserID) error {
+	var revoked int
+	err := c.dbUpdate(func(tx clientdb.ReadWriteTx) error {
+		invites, err := c.listSentGCInvites(tx, gcID)
+		if err != nil {
+			return err
+		}
+		for _, inv := range invites {
+			if inv.User != uid {
+				continue
+			}
+			if err := c.db.DelGCInvite(tx, inv.ID); err != nil {
+				return err
+			}
+			revoked += 1
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if revoked == 0 {
+		return fmt.Errorf("no outstanding invites to user %s for GC %s",
+			uid, gcID)
+	}
+
+	c.log.Infof("Revoked %d invites to user %s for GC %s", revoked, uid, gcID)
+	return nil
+}
@@ -0,0 +1,477 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/companyzero/bisonrelay/client/clientdb"
+	"github.com/companyzero/bisonrelay/client/clientintf"
+	"github.com/companyzero/bisonrelay/client/internal/lowlevel"
+	"github.com/companyzero/bisonrelay/rpc"
+	"github.com/companyzero/bisonrelay/zkidentity"
+)
+
+// CreateGCJoinLink creates a link that allows anyone that has it to join the
+// GC, until the link expires. The link may be redeemed at most slots times.
+// When needsApproval is true, the GCJoinRequested handler is called when a
+// user redeems the link and the user is only invited to the GC after
+// ApproveGCJoinRequest is called.
+//
+// The link is written to w, if it is specified.
+func (c *Client) CreateGCJoinLink(gcID zkidentity.ShortID, expiration time.Duration,
+	slots uint32, needsApproval bool, w io.Writer) (rpc.OOBGCJoinLink, error) {
+
+	if c.linkedDevice {
+		return rpc.OOBGCJoinLink{}, errLinkedDevice
+	}
+	if slots == 0 {
+		return rpc.OOBGCJoinLink{}, fmt.Errorf("join link must have at least one slot")
+	}
+
+	link := rpc.OOBGCJoinLink{
+		GC:            gcID,
+		Admin:         c.id.Public,
+		Slots:         slots,
+		Expires:       time.Now().Add(expiration).Unix(),
+		NeedsApproval: needsApproval,
+	}
+	link.Seed = clientintf.RandomID()
+
+	dbLink := clientdb.GCJoinLink{}
+	err := c.dbUpdate(func(tx clientdb.ReadWriteTx) error {
+		gc, err := c.db.GetGC(tx, gcID)
+		if err != nil {
+			return err
+		}
+		if !gc.IsAdmin(c.PublicID()) {
+			return fmt.Errorf("cannot create gc join link: not an "+
+				"admin of gc %s", gcID)
+		}
+		link.Name = gc.Name
+		h := link.Hash()
+		link.Signature = c.id.SignMessage(h[:])
+		dbLink.Link = link
+		return c.db.SaveGCJoinLink(tx, &dbLink)
+	})
+	if err != nil {
+		return link, err
+	}
+
+	if w != nil {
+		if err := json.NewEncoder(w).Encode(link); err != nil {
+			return link, fmt.Errorf("unable to encode gc join link: %w", err)
+		}
+	}
+
+	if err := c.listenGCJoinLink(&dbLink); err != nil {
+		return link, err
+	}
+	c.log.Infof("Created join link for GC %q with %d slots (expires %s)",
+		link.Name, slots, time.Unix(link.Expires, 0).Format(time.RFC3339))
+	return link, nil
+}
+
+// ReadGCJoinLink decodes a GC join link from the given reader. Note the link is
+// not acted upon until RedeemGCJoinLink is called.
+func (c *Client) ReadGCJoinLink(r io.Reader) (rpc.OOBGCJoinLink, error) {
+	var link rpc.OOBGCJoinLink
+	if err := json.NewDecoder(r).Decode(&link); err != nil {
+		return link, err
+	}
+	if !link.Verify() {
+		return link, errInvalidGCJoinLink
+	}
+	return link, nil
+}
+
+// listenGCJoinLink subscribes to the unused slots of the join link.
+func (c *Client) listenGCJoinLink(link *clientdb.GCJoinLink) error {
+	seed := link.Link.Seed
+	for slot := uint32(0); slot < link.Link.Slots; slot++ {
+		if link.IsSlotUsed(slot) {
+			continue
+		}
+		slot := slot
+		handler := func(blob lowlevel.RVBlob) error {
+			go func() {
+				err := c.handleGCJoinRequest(seed, slot, blob)
+				if err != nil && !errors.Is(err, clientintf.ErrSubsysExiting) {
+					c.log.Errorf("Unable to handle request to join "+
+						"GC with link %s: %v", seed, err)
+				}
+			}()
+			return nil
+		}
+		if err := c.rmgr.Sub(link.Link.SlotRV(slot), handler, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// listenAllGCJoinLinks removes expired join links and subscribes to the slots
+// of the remaining ones.
+func (c *Client) listenAllGCJoinLinks() error {
+	var links []clientdb.GCJoinLink
+	now := time.Now().Unix()
+	err := c.dbUpdate(func(tx clientdb.ReadWriteTx) error {
+		all, err := c.db.ListGCJoinLinks(tx)
+		if err != nil {
+			return err
+		}
+		for _, link := range all {
+			if link.Link.Expires > now {
+				links = append(links, link)
+				continue
+			}
+			c.log.Debugf("Removing expired join link %s of GC %s",
+				link.Link.Seed, link.Link.GC)
+			if err := c.db.RemoveGCJoinLink(tx, link.Link.Seed); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for i := range links {
+		if err := c.listenGCJoinLink(&links[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// handleGCJoinRequest handles a request to join a GC received in one of the
+// slots of a join link.
+//
+// The slot is only marked as used (and unsubscribed) once a valid request from
+// a new user is saved. Otherwise, the slot may still receive requests from
+// other users.
+func (c *Client) handleGCJoinRequest(seed zkidentity.ShortID, slot uint32,
+	blob lowlevel.RVBlob) error {
+
+	decoded, err := rpc.DecryptOOB(blob.Decoded, &c.id.PrivateKey)
+	if err != nil {
+		return err
+	}
+	jr, ok := decoded.(rpc.RMOGCJoinRequest)
+	if !ok {
+		return fmt.Errorf("invalid gc join request type: %T", decoded)
+	}
+
+	var link *clientdb.GCJoinLink
+	var repeated bool
+	req := clientdb.GCJoinRequest{
+		GC:       jr.GC,
+		Seed:     jr.Seed,
+		Invite:   jr.Invite,
+		Received: time.Now(),
+	}
+	uid := jr.Invite.Public.Identity
+	err = c.dbUpdate(func(tx clientdb.ReadWriteTx) error {
+		var err error
+		link, err = c.db.GetGCJoinLink(tx, seed)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case jr.Seed != seed || jr.GC != link.Link.GC:
+			return fmt.Errorf("gc join request does not match link")
+		case link.Link.Expires < time.Now().Unix():
+			return errExpiredGCJoinLink
+		case !jr.Invite.Public.VerifyIdentity():
+			return fmt.Errorf("gc join request has invalid identity")
+		case c.db.IsBlocked(tx, uid):
+			return fmt.Errorf("gc join request from blocked user %s", uid)
+		case link.IsSlotUsed(slot):
+			// Already handled.
+			repeated = true
+			return nil
+		case link.HasUser(uid):
+			// The user is retrying a previous request.
+			repeated = true
+			return nil
+		}
+
+		link.UsedSlots = append(link.UsedSlots, slot)
+		link.Users = append(link.Users, uid)
+		if err := c.db.SaveGCJoinLink(tx, link); err != nil {
+			return err
+		}
+		if link.Link.NeedsApproval {
+			return c.db.SaveGCJoinRequest(tx, &req)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if repeated {
+		c.log.Debugf("Ignoring repeated request of user %q (%s) to join "+
+			"GC %q via join link", jr.Invite.Public.Nick, uid,
+			link.Link.Name)
+		return nil
+	}
+
+	if err := c.rmgr.Unsub(blob.ID); err != nil {
+		c.log.Warnf("Unable to unsubscribe from gc join link slot: %v", err)
+	}
+
+	c.log.Infof("User %q (%s) requested to join GC %q via join link",
+		jr.Invite.Public.Nick, uid, link.Link.Name)
+	if link.Link.NeedsApproval {
+		if c.cfg.GCJoinRequested != nil {
+			c.cfg.GCJoinRequested(req)
+		}
+		return nil
+	}
+	return c.acceptGCJoinRequest(&req)
+}
+
+// acceptGCJoinRequest invites the user that made the request to the GC. If the
+// user is not known yet, KX is performed first.
+func (c *Client) acceptGCJoinRequest(req *clientdb.GCJoinRequest) error {
+	uid := req.Invite.Public.Identity
+	if _, err := c.rul.byID(uid); err == nil {
+		return c.InviteToGroupChat(req.GC, uid)
+	}
+
+	err := c.dbUpdate(func(tx clientdb.ReadWriteTx) error {
+		act := clientdb.PostKXAction{
+			Type:      clientdb.PKXActionInviteGC,
+			DateAdded: time.Now(),
+			Data:      req.GC.String(),
+		}
+		return c.db.AddUniquePostKXAction(tx, uid, act)
+	})
+	if err != nil {
+		return err
+	}
+	return c.kxl.acceptInvite(req.Invite, false)
+}
+
+// ListGCJoinRequests lists the requests to join GCs that need approval.
+func (c *Client) ListGCJoinRequests() ([]clientdb.GCJoinRequest, error) {
+	var reqs []clientdb.GCJoinRequest
+	err := c.dbView(func(tx clientdb.ReadTx) error {
+		var err error
+		reqs, err = c.db.ListGCJoinRequests(tx)
+		return err
+	})
+	return reqs, err
+}
+
+// ApproveGCJoinRequest approves the request of the given user to join a GC via
+// a join link. This invites the user to the GC, after performing KX if needed.
+func (c *Client) ApproveGCJoinRequest(uid UserID) error {
+	var req *clientdb.GCJoinRequest
+	err := c.dbUpdate(func(tx clientdb.ReadWriteTx) error {
+		var err error
+		req, err = c.db.GetGCJoinRequest(tx, uid)
+		if err != nil {
+			return err
+		}
+		return c.db.RemoveGCJoinRequest(tx, uid)
+	})
+	if err != nil {
+		return err
+	}
+	c.log.Infof("Approved request of %q (%s) to join GC %s",
+		req.Invite.Public.Nick, uid, req.GC)
+	return c.acceptGCJoinRequest(req)
+}
+
+// RejectGCJoinRequest removes the request of the given user to join a GC via a
+// join link.
+func (c *Client) RejectGCJoinRequest(uid UserID) error {
+	return c.dbUpdate(func(tx clientdb.ReadWriteTx) error {
+		return c.db.RemoveGCJoinRequest(tx, uid)
+	})
+}
+
+// RedeemGCJoinLink requests to join the GC of the given link. This performs KX
+// with the admin that created the link (if needed), after which the invite to
+// join the GC sent by the admin is automatically accepted.
+func (c *Client) RedeemGCJoinLink(link rpc.OOBGCJoinLink) error {
+	if c.linkedDevice {
+		return errLinkedDevice
+	}
+	if !link.Verify() {
+		return errInvalidGCJoinLink
+	}
+	if link.Slots == 0 {
+		return fmt.Errorf("%w: no slots", errInvalidGCJoinLink)
+	}
+	expires := time.Unix(link.Expires, 0)
+	if expires.Before(time.Now()) {
+		return errExpiredGCJoinLink
+	}
+	admin := link.Admin.Identity
+	if admin == c.PublicID() {
+		return fmt.Errorf("cannot redeem own gc join link")
+	}
+
+	// The request is first sent to a random slot. If the invite from the
+	// admin is not received in time (for example, because another user
+	// sent a request to the same slot), the request is sent again to the
+	// following slots (see retryRedeemedGCJoinLinks).
+	slot := uint32(c.mustRandomUint64() % uint64(link.Slots))
+	err := c.dbUpdate(func(tx clientdb.ReadWriteTx) error {
+		_, err := c.db.GetGC(tx, link.GC)
+		if err == nil {
+			return fmt.Errorf("already a member of gc %q", link.Name)
+		}
+		if !errors.Is(err, clientdb.ErrNotFound) {
+			return err
+		}
+		return c.db.SaveRedeemedGCJoinLink(tx, &clientdb.RedeemedGCJoinLink{
+			GC:          link.GC,
+			Admin:       admin,
+			Expires:     expires,
+			Redeemed:    time.Now(),
+			Link:        link,
+			Slot:        slot,
+			LastAttempt: time.Now(),
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	c.log.Infof("Requesting to join GC %q via join link from %s",
+		link.Name, admin)
+	return c.sendGCJoinRequest(&link, slot)
+}
+
+// sendGCJoinRequest sends a request to join the GC of the link to the given
+// slot of the link.
+func (c *Client) sendGCJoinRequest(link *rpc.OOBGCJoinLink, slot uint32) error {
+	// Only perform KX if the admin is not known yet.
+	admin := link.Admin.Identity
+	jr := rpc.RMOGCJoinRequest{
+		GC:     link.GC,
+		Seed:   link.Seed,
+		Invite: rpc.OOBPublicIdentityInvite{Public: c.id.Public},
+	}
+	if _, err := c.rul.byID(admin); err != nil {
+		jr.Invite, err = c.kxl.createInvite(nil, &link.Admin, nil, false)
+		if err != nil {
+			return err
+		}
+	}
+
+	rm := rawRM{
+		rv:       link.SlotRV(slot),
+		paidRMCB: c.kxl.makePaidForRMCB(admin, "gc.joinlink"),
+	}
+	var err error
+	rm.msg, err = rpc.EncryptRMO(jr, link.Admin, c.cfg.CompressLevel)
+	if err != nil {
+		return fmt.Errorf("unable to encrypt gc join request: %v", err)
+	}
+	return c.q.SendRM(rm)
+}
+
+// retryRedeemedGCJoinLinks sends again the requests to join the GCs of the
+// redeemed join links whose invites were not received in time, using the
+// next slot of each link. Expired links are removed.
+func (c *Client) retryRedeemedGCJoinLinks() error {
+	var retry []clientdb.RedeemedGCJoinLink
+	now := time.Now()
+	retryInterval := c.cfg.gcJoinLinkRetryInterval()
+	err := c.dbUpdate(func(tx clientdb.ReadWriteTx) error {
+		all, err := c.db.ListRedeemedGCJoinLinks(tx)
+		if err != nil {
+			return err
+		}
+		for _, r := range all {
+			if r.Expires.Before(now) {
+				c.log.Debugf("Removing expired redeemed join link "+
+					"of GC %s", r.GC)
+				if err := c.db.RemoveRedeemedGCJoinLink(tx, r.GC); err != nil {
+					return err
+				}
+				continue
+			}
+
+			// Links redeemed by older versions cannot be retried.
+			if r.Link.Slots == 0 || now.Sub(r.LastAttempt) < retryInterval {
+				continue
+			}
+			r.Slot = (r.Slot + 1) % r.Link.Slots
+			r.LastAttempt = now
+			if err := c.db.SaveRedeemedGCJoinLink(tx, &r); err != nil {
+				return err
+			}
+			retry = append(retry, r)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for i := range retry {
+		r := &retry[i]
+		c.log.Infof("Retrying request to join GC %q via join link from "+
+			"%s (slot %d)", r.Link.Name, r.Admin, r.Slot)
+		if err := c.sendGCJoinRequest(&r.Link, r.Slot); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runGCJoinLinkRetrier periodically retries the requests to join GCs of the
+// redeemed join links.
+func (c *Client) runGCJoinLinkRetrier(ctx context.Context) error {
+	for {
+		select {
+		case <-time.After(c.cfg.gcJoinLinkRetryInterval()):
+		case <-ctx.Done():
+			return nil
+		}
+
+		err := c.retryRedeemedGCJoinLinks()
+		if err != nil && !errors.Is(err, clientintf.ErrSubsysExiting) {
+			c.log.Errorf("Unable to retry redeemed GC join links: %v", err)
+		}
+	}
+}
+
+// maybeAcceptRedeemedGCInvite accepts the invitation to join the GC if it was
+// sent by the admin of a join link redeemed by the local client. It returns
+// true if the invitation was accepted.
+func (c *Client) maybeAcceptRedeemedGCInvite(ru *RemoteUser, iid uint64,
+	invite rpc.RMGroupInvite) (bool, error) {
+
+	var redeemed *clientdb.RedeemedGCJoinLink
+	err := c.dbUpdate(func(tx clientdb.ReadWriteTx) error {
+		var err error
+		redeemed, err = c.db.GetRedeemedGCJoinLink(tx, invite.ID)
+		if err != nil {
+			return err
+		}
+		if redeemed.Admin != ru.ID() {
+			return nil
+		}
+		return c.db.RemoveRedeemedGCJoinLink(tx, invite.ID)
+	})
+	if errors.Is(err, clientdb.ErrNotFound) || (err == nil && redeemed.Admin != ru.ID()) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	c.log.Infof("Accepting invitation to gc %q from redeemed join link", invite.Name)
+	return true, c.AcceptGroupChatInvite(iid)
+}
@@ -0,0 +1,197 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/companyzero/bisonrelay/client/clientdb"
+	"github.com/companyzero/bisonrelay/client/clientintf"
+	"github.com/companyzero/bisonrelay/zkidentity"
+)
+
+// gcMeshKXRecentThreshold is the interval during which a new mediate id
+// request is not made for a GC member after a previous one was made.
+const gcMeshKXRecentThreshold = time.Hour * 24
+
+// GCMeshKXProgress tracks the progress of requests to mediate KX with the
+// members of a GC that are not KX'd with the local client.
+type GCMeshKXProgress struct {
+	GC       GCID
+	Target   UserID
+	Mediator UserID
+	Sent     int
+	Total    int
+	Err      error
+}
+
+// gcMeshKXTargets returns the members of the GC that need a mediated KX and
+// the list of members that may mediate the KX, in order of preference.
+//
+// When olderOnly is true, only members that are listed before the local client
+// in the GC list are returned. Members are appended to the list as they join,
+// so this makes the newest member responsible for starting the KX, instead of
+// having both members attempt it concurrently.
+func (c *Client) gcMeshKXTargets(gcID zkidentity.ShortID,
+	prefMediator *UserID, olderOnly bool) (targets, mediators []UserID, err error) {
+
+	me := c.PublicID()
+	isKnown := func(uid UserID) bool {
+		_, err := c.rul.byID(uid)
+		return err == nil
+	}
+
+	err = c.dbView(func(tx clientdb.ReadTx) error {
+		gc, err := c.db.GetGC(tx, gcID)
+		if err != nil {
+			return err
+		}
+		if len(gc.Members) == 0 {
+			// Nothing to do in a GC without members.
+			return nil
+		}
+		gcBlockList, err := c.db.GetGCBlockList(tx, gcID)
+		if err != nil {
+			return err
+		}
+
+		// Admins are preferred as mediators, because they should
+		// have KX'd with every member they invited.
+		if prefMediator != nil && *prefMediator != me && isKnown(*prefMediator) {
+			mediators = append(mediators, *prefMediator)
+		}
+		admins := append(gc.Members[:1:1], gc.ExtraAdmins...)
+		for _, uid := range admins {
+			if uid != me && isKnown(uid) && !containsUserID(mediators, uid) {
+				mediators = append(mediators, uid)
+			}
+		}
+
+		for _, uid := range gcBlockList.FilterMembers(gc.Members) {
+			if uid == me && olderOnly {
+				break
+			}
+			if uid == me || isKnown(uid) || c.db.IsBlocked(tx, uid) {
+				continue
+			}
+			hasMI, err := c.db.HasAnyRecentMediateID(tx, uid,
+				gcMeshKXRecentThreshold)
+			if err != nil {
+				return err
+			}
+			if !hasMI {
+				targets = append(targets, uid)
+			}
+		}
+		return nil
+	})
+	return
+}
+
+// containsUserID returns true if uid is in the slice.
+func containsUserID(s []UserID, uid UserID) bool {
+	for i := range s {
+		if s[i] == uid {
+			return true
+		}
+	}
+	return false
+}
+
+// gcMeshKX requests a mediated KX with every member of the GC that is not KX'd
+// with the local client. Requests are sent one at a time, with a delay between
+// them, to avoid flooding the mediators. Only one mesh KX pass is executed for
+// any given GC at a time.
+func (c *Client) gcMeshKX(gcID zkidentity.ShortID, prefMediator *UserID, olderOnly bool) error {
+	if c.linkedDevice {
+		return nil
+	}
+
+	c.gcMeshKXMtx.Lock()
+	if _, ok := c.gcMeshKXRunning[gcID]; ok {
+		c.gcMeshKXMtx.Unlock()
+		return nil
+	}
+	c.gcMeshKXRunning[gcID] = struct{}{}
+	c.gcMeshKXMtx.Unlock()
+
+	defer func() {
+		c.gcMeshKXMtx.Lock()
+		delete(c.gcMeshKXRunning, gcID)
+		c.gcMeshKXMtx.Unlock()
+	}()
+
+	targets, mediators, err := c.gcMeshKXTargets(gcID, prefMediator, olderOnly)
+	if err != nil {
+		return err
+	}
+	if len(targets) == 0 {
+		return nil
+	}
+	if len(mediators) == 0 {
+		return fmt.Errorf("no GC admin available to mediate KX with "+
+			"%d members of GC %s", len(targets), gcID)
+	}
+
+	c.log.Infof("Starting mesh KX with %d members of GC %s", len(targets),
+		gcID)
+	delay := c.cfg.gcMeshKXDelay()
+	for i, target := range targets {
+		if i > 0 {
+			select {
+			case <-time.After(delay):
+			case <-c.ctx.Done():
+				return clientintf.ErrSubsysExiting
+			}
+		}
+
+		// The target may have KX'd with us in the meantime.
+		if _, err := c.rul.byID(target); err == nil {
+			continue
+		}
+
+		// Mediator is chosen in a round-robin fashion to spread the
+		// load among the admins.
+		mediator := mediators[i%len(mediators)]
+		err := c.RequestMediateIdentity(mediator, target)
+		if errors.Is(err, clientintf.ErrSubsysExiting) {
+			return err
+		}
+		if err != nil {
+			c.log.Warnf("Unable to request mesh KX with %s of GC %s "+
+				"via %s: %v", target, gcID, mediator, err)
+		}
+		if c.cfg.GCMeshKXProgress != nil {
+			c.cfg.GCMeshKXProgress(GCMeshKXProgress{
+				GC:       gcID,
+				Target:   target,
+				Mediator: mediator,
+				Sent:     i + 1,
+				Total:    len(targets),
+				Err:      err,
+			})
+		}
+	}
+	return nil
+}
+
+// startGCMeshKX starts a mesh KX pass for the GC in a goroutine.
+func (c *Client) startGCMeshKX(gcID zkidentity.ShortID, prefMediator *UserID, olderOnly bool) {
+	go func() {
+		err := c.gcMeshKX(gcID, prefMediator, olderOnly)
+		if err != nil && !errors.Is(err, clientintf.ErrSubsysExiting) {
+			c.log.Errorf("Unable to perform mesh KX in GC %s: %v",
+				gcID, err)
+		}
+	}()
+}
+
+// MeshKXGC requests a mediated KX with every member of the GC that is not KX'd
+// with the local client and for which there is no recent KX attempt. The
+// requests are made through the admins of the GC.
+//
+// Note that this is done automatically when the local client joins a GC or
+// receives updated GC lists.
+func (c *Client) MeshKXGC(gcID zkidentity.ShortID) error {
+	return c.gcMeshKX(gcID, nil, false)
+}
@@ -0,0 +1,169 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/companyzero/bisonrelay/client/clientdb"
+	"github.com/companyzero/bisonrelay/rpc"
+	"github.com/companyzero/bisonrelay/zkidentity"
+)
+
+// GetGCMetadata returns the metadata of the given GC. The returned metadata
+// has generation zero if no admin ever set the metadata of the GC.
+func (c *Client) GetGCMetadata(gcID zkidentity.ShortID) (rpc.RMGroupMetadata, error) {
+	var md rpc.RMGroupMetadata
+	err := c.dbView(func(tx clientdb.ReadTx) error {
+		if _, err := c.db.GetGC(tx, gcID); err != nil {
+			return err
+		}
+		var err error
+		md, err = c.db.GetGCMetadata(tx, gcID)
+		return err
+	})
+	return md, err
+}
+
+// ModifyGCMetadata applies f to the metadata of the given GC, then signs and
+// sends the updated metadata to the GC members. The local client must be an
+// admin of the GC.
+func (c *Client) ModifyGCMetadata(gcID zkidentity.ShortID, f func(md *rpc.RMGroupMetadata) error) error {
+	if c.linkedDevice {
+		return errLinkedDevice
+	}
+
+	var gc rpc.RMGroupList
+	var md rpc.RMGroupMetadata
+	err := c.dbUpdate(func(tx clientdb.ReadWriteTx) error {
+		var err error
+		gc, err = c.db.GetGC(tx, gcID)
+		if err != nil {
+			return err
+		}
+		if !gc.IsAdmin(c.PublicID()) {
+			return fmt.Errorf("cannot modify gc metadata: not an admin "+
+				"of gc %s", gcID)
+		}
+		md, err = c.db.GetGCMetadata(tx, gcID)
+		if err != nil {
+			return err
+		}
+
+		if err := f(&md); err != nil {
+			return err
+		}
+		md.ID = gcID
+		md.Generation += 1
+		md.Timestamp = time.Now().Unix()
+		md.Signer = c.PublicID()
+		h := md.SignedHash()
+		md.Signature = c.id.SignMessage(h[:])
+		return c.db.SaveGCMetadata(tx, md)
+	})
+	if err != nil {
+		return err
+	}
+
+	c.log.Infof("Updated metadata of GC %s (%q) to generation %d", gcID,
+		gc.Name, md.Generation)
+	c.sendToGCMembers(gcID, gc.Members, "metadata", md, nil)
+	return nil
+}
+
+// SetGCTopic sets the topic of the given GC.
+func (c *Client) SetGCTopic(gcID zkidentity.ShortID, topic string) error {
+	return c.ModifyGCMetadata(gcID, func(md *rpc.RMGroupMetadata) error {
+		md.Topic = topic
+		return nil
+	})
+}
+
+// PinGCMessage pins (or unpins, if pin is false) the message with the given
+// id in the GC.
+func (c *Client) PinGCMessage(gcID, msgID zkidentity.ShortID, pin bool) error {
+	if msgID.IsEmpty() {
+		return errEmptyMsgID
+	}
+	return c.ModifyGCMetadata(gcID, func(md *rpc.RMGroupMetadata) error {
+		if md.IsPinned(msgID) == pin {
+			if pin {
+				return fmt.Errorf("message %s is already pinned", msgID)
+			}
+			return fmt.Errorf("message %s is not pinned", msgID)
+		}
+		if pin {
+			md.Pinned = append(md.Pinned, msgID)
+		} else {
+			md.Pinned = removeID(md.Pinned, msgID)
+		}
+		return nil
+	})
+}
+
+// verifyGCMetadataSig verifies the signature of the GC metadata received from
+// the remote user. The signer must be an admin of the GC.
+func (c *Client) verifyGCMetadataSig(ru *RemoteUser, gc *rpc.RMGroupList,
+	md *rpc.RMGroupMetadata) error {
+
+	if !gc.IsAdmin(md.Signer) {
+		return fmt.Errorf("gc metadata %s signed by non-admin %s", md.ID,
+			md.Signer)
+	}
+	id, err := c.gcMemberIdentity(md.Signer)
+	if errors.Is(err, userNotFoundError{}) && gc.IsAdmin(ru.ID()) {
+		c.log.Warnf("Unable to verify signature of gc metadata %s: "+
+			"signer %s is not a known user", md.ID, md.Signer)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("unable to verify signature of gc metadata "+
+			"%s: %v", md.ID, err)
+	}
+	h := md.SignedHash()
+	if !id.VerifyMessage(h[:], md.Signature) {
+		return fmt.Errorf("gc metadata %s: %w", md.ID, errInvalidGCMetaSig)
+	}
+	return nil
+}
+
+// handleGCMetadata handles updated metadata of a GC, sent by one of its
+// members.
+func (c *Client) handleGCMetadata(ru *RemoteUser, md rpc.RMGroupMetadata) error {
+	var updated bool
+	err := c.dbUpdate(func(tx clientdb.ReadWriteTx) error {
+		gc, err := c.db.GetGC(tx, md.ID)
+		if err != nil {
+			return err
+		}
+		if !containsUserID(gc.Members, ru.ID()) {
+			return fmt.Errorf("received gc metadata %s from non-member",
+				md.ID)
+		}
+		if err := c.verifyGCMetadataSig(ru, &gc, &md); err != nil {
+			return err
+		}
+
+		oldMD, err := c.db.GetGCMetadata(tx, md.ID)
+		if err != nil {
+			return err
+		}
+		if md.Generation <= oldMD.Generation {
+			ru.log.Debugf("Ignoring gc metadata %s with old generation "+
+				"(%d <= %d)", md.ID, md.Generation, oldMD.Generation)
+			return nil
+		}
+		updated = true
+		return c.db.SaveGCMetadata(tx, md)
+	})
+	if err != nil || !updated {
+		return err
+	}
+
+	ru.log.Infof("Received metadata of GC %s (generation %d)", md.ID,
+		md.Generation)
+	if c.cfg.GCMetadataUpdated != nil {
+		c.cfg.GCMetadataUpdated(ru, md)
+	}
+	return nil
+}
@@ -0,0 +1,281 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/companyzero/bisonrelay/client/clientdb"
+	"github.com/companyzero/bisonrelay/rpc"
+	"github.com/companyzero/bisonrelay/zkidentity"
+)
+
+// gcResyncInterval is the minimum interval between automatic resyncs of a GC
+// with the same user.
+const gcResyncInterval = time.Minute
+
+type gcResyncKey struct {
+	gc  zkidentity.ShortID
+	uid UserID
+}
+
+// shouldResyncGC returns true if the GC was not resynced with the given user
+// recently. It also marks the GC as resynced now.
+func (c *Client) shouldResyncGC(gcID zkidentity.ShortID, uid UserID) bool {
+	key := gcResyncKey{gc: gcID, uid: uid}
+	now := time.Now()
+	c.gcResyncMtx.Lock()
+	defer c.gcResyncMtx.Unlock()
+	if last, ok := c.gcResyncs[key]; ok && now.Sub(last) < gcResyncInterval {
+		return false
+	}
+	c.gcResyncs[key] = now
+	return true
+}
+
+// sendGCUpdate sends a forced update with the local list of the GC to the
+// given members.
+func (c *Client) sendGCUpdate(gc rpc.RMGroupList, reason string,
+	members []zkidentity.ShortID) {
+
+	rm := rpc.RMGroupUpdate{
+		Reason:       reason,
+		NewGroupList: gc,
+	}
+	c.sendToGCMembers(gc.ID, members, "update", rm, nil)
+}
+
+// requestGCUpdate asks the owner of the GC to send its current list of the GC.
+func (c *Client) requestGCUpdate(gc rpc.RMGroupList) {
+	rm := rpc.RMGroupUpdateRequest{
+		ID:         gc.ID,
+		Generation: gc.Generation,
+	}
+	c.sendToGCMembers(gc.ID, gc.Members[:1], "updaterequest", rm, nil)
+}
+
+// ResyncGC resyncs the list of members of the GC. When the local client is an
+// admin of the GC, the local list is forcibly sent to all members. Otherwise,
+// the current list is requested from the owner of the GC.
+func (c *Client) ResyncGC(gcID zkidentity.ShortID) error {
+	if c.linkedDevice {
+		return errLinkedDevice
+	}
+
+	var gc rpc.RMGroupList
+	var members []zkidentity.ShortID
+	err := c.dbView(func(tx clientdb.ReadTx) error {
+		var err error
+		gc, err = c.db.GetGC(tx, gcID)
+		if err != nil {
+			return err
+		}
+		gcBlockList, err := c.db.GetGCBlockList(tx, gcID)
+		if err != nil {
+			return err
+		}
+		members = gcBlockList.FilterMembers(gc.Members)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(gc.Members) == 0 {
+		return fmt.Errorf("gc %s has no members", gcID)
+	}
+
+	if gc.IsAdmin(c.PublicID()) {
+		c.log.Infof("Sending forced resync of GC %q (generation %d)",
+			gc.Name, gc.Generation)
+		c.sendGCUpdate(gc, "forced resync", members)
+	} else {
+		c.log.Infof("Requesting resync of GC %q (generation %d)",
+			gc.Name, gc.Generation)
+		c.requestGCUpdate(gc)
+	}
+	return nil
+}
+
+// checkGCGeneration checks whether the generation of the GC list used by the
+// remote user to send a message matches the local one. When the local list is
+// outdated, the current list is requested from the owner of the GC. When the
+// remote user's list is outdated and the local client is an admin of the GC,
+// the local list is sent to the remote user.
+func (c *Client) checkGCGeneration(ru *RemoteUser, gc *rpc.RMGroupList,
+	generation uint64, isMember bool) {
+
+	if len(gc.Members) == 0 {
+		return
+	}
+
+	me := c.PublicID()
+	switch {
+	case generation > gc.Generation && !gc.IsOwner(me):
+		owner := gc.Members[0]
+		if !c.shouldResyncGC(gc.ID, owner) {
+			return
+		}
+		ru.log.Infof("Remote user sent message in GC %q with newer "+
+			"generation (%d > %d). Requesting resync.", gc.Name,
+			generation, gc.Generation)
+		c.requestGCUpdate(*gc)
+
+	case generation < gc.Generation && isMember && gc.IsAdmin(me):
+		if !c.shouldResyncGC(gc.ID, ru.ID()) {
+			return
+		}
+		ru.log.Infof("Remote user sent message in GC %q with older "+
+			"generation (%d < %d). Sending resync.", gc.Name,
+			generation, gc.Generation)
+		c.sendGCUpdate(*gc, "outdated generation",
+			[]zkidentity.ShortID{ru.ID()})
+	}
+}
+
+// handleGCUpdateRequest handles a request from a GC member to send the current
+// list of the GC.
+func (c *Client) handleGCUpdateRequest(ru *RemoteUser, req rpc.RMGroupUpdateRequest) error {
+	var gc rpc.RMGroupList
+	err := c.dbView(func(tx clientdb.ReadTx) error {
+		var err error
+		gc, err = c.db.GetGC(tx, req.ID)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if !gc.IsAdmin(c.PublicID()) {
+		return fmt.Errorf("received update request for gc %s where we "+
+			"are not an admin", gc.ID)
+	}
+	isMember := false
+	for i := range gc.Members {
+		if gc.Members[i] == ru.ID() {
+			isMember = true
+			break
+		}
+	}
+	if !isMember {
+		return fmt.Errorf("received update request for gc %s from "+
+			"non-member", gc.ID)
+	}
+	if !c.shouldResyncGC(gc.ID, ru.ID()) {
+		ru.log.Debugf("Ignoring repeated update request for GC %q",
+			gc.Name)
+		return nil
+	}
+
+	ru.log.Infof("Sending update of GC %q (generation %d, remote %d)",
+		gc.Name, gc.Generation, req.Generation)
+	c.sendGCUpdate(gc, "resync requested", []zkidentity.ShortID{ru.ID()})
+	return nil
+}
+
+// handleGCUpdate handles a forced update of the list of a GC sent by an admin.
+// Unlike regular list updates, the generation of the list may go backwards,
+// but only for lists produced by the owner of the GC after the local list, so
+// that members cannot replay old lists signed by an admin.
+func (c *Client) handleGCUpdate(ru *RemoteUser, gu rpc.RMGroupUpdate) error {
+	gl := gu.NewGroupList
+	me := c.PublicID()
+	var oldGeneration uint64
+	meRemoved := true
+	for i := range gl.Members {
+		if gl.Members[i] == me {
+			meRemoved = false
+			break
+		}
+	}
+
+	err := c.dbUpdate(func(tx clientdb.ReadWriteTx) error {
+		oldGC, err := c.db.GetGC(tx, gl.ID)
+		if err != nil {
+			return err
+		}
+		oldGeneration = oldGC.Generation
+
+		// Only admins may force updates of the list.
+		if !oldGC.IsAdmin(ru.ID()) {
+			return fmt.Errorf("received forced update of gc %s from "+
+				"non-admin %s", gl.ID, ru.ID())
+		}
+
+		// Unsigned lists (from older clients) are produced by the
+		// sender.
+		signer := gl.Signer
+		if signer.IsEmpty() {
+			signer = ru.ID()
+		}
+		isOlder := gl.Generation < oldGC.Generation
+		if isOlder && (!oldGC.IsOwner(signer) || gl.Timestamp <= oldGC.Timestamp) {
+			return fmt.Errorf("received forced update of gc %s with "+
+				"older generation %d (current %d)", gl.ID,
+				gl.Generation, oldGC.Generation)
+		}
+		if isOlder && meRemoved {
+			return fmt.Errorf("received forced update of gc %s with "+
+				"older generation %d (current %d) without the "+
+				"local client", gl.ID, gl.Generation,
+				oldGC.Generation)
+		}
+
+		// Ensure the list was produced by an existing admin.
+		if err := c.verifyGCListSig(ru, &oldGC, &gl, oldGC.IsAdmin); err != nil {
+			return err
+		}
+		if err := c.verifyGCAdmins(&oldGC, &gl); err != nil {
+			return err
+		}
+
+		// If we're no longer a member, we missed being removed from
+		// the GC.
+		if meRemoved {
+			if err := c.db.DeleteGC(tx, gl.ID); err != nil {
+				return err
+			}
+			aliasMap, err := c.db.SetGCAlias(tx, gl.ID, "")
+			if err != nil {
+				return err
+			}
+			c.setGCAlias(aliasMap)
+			return nil
+		}
+
+		if err = c.db.SaveGC(tx, gl); err != nil {
+			return fmt.Errorf("unable to save gc: %v", err)
+		}
+		return nil
+	})
+	if errors.Is(err, errUnknownGCSigner) {
+		c.queueGCListUntilKX(ru, &gl, err, true, func() error {
+			return c.handleGCUpdate(ru, gu)
+		})
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	c.syncGCsToDevices()
+
+	c.log.Infof("Received forced update of GC %q from %s (generation %d -> "+
+		"%d). Reason: %q", gl.ID.String(), ru, oldGeneration,
+		gl.Generation, gu.Reason)
+
+	if meRemoved {
+		if c.cfg.GCUserParted != nil {
+			c.cfg.GCUserParted(gl.ID, me, gu.Reason, true)
+		}
+		return nil
+	}
+
+	if c.cfg.GCListUpdated != nil {
+		var entry clientdb.GCAddressBookEntry
+		clientdb.RMGroupListToGCEntry(&gl, &entry)
+		c.cfg.GCListUpdated(entry)
+	}
+
+	ruID := ru.ID()
+	c.startGCMeshKX(gl.ID, &ruID, true)
+	return nil
+}
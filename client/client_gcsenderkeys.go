@@ -0,0 +1,641 @@
+package client
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/companyzero/bisonrelay/client/clientdb"
+	"github.com/companyzero/bisonrelay/client/clientintf"
+	"github.com/companyzero/bisonrelay/client/internal/lowlevel"
+	"github.com/companyzero/bisonrelay/rpc"
+	"github.com/companyzero/bisonrelay/sw"
+	"github.com/companyzero/bisonrelay/zkidentity"
+	"golang.org/x/sync/errgroup"
+)
+
+// Group RV flow (where Alice sends a message to a GC with Bob and Charlie):
+//
+// Every member generates a random sender key for each GC and sends it to the
+// other members (via their ratchets) in an RMGroupSenderKey. The members
+// subscribe to the RVs derived from the sender key and the next few counters.
+//
+// When every other member has shared its own key with Alice (signaling they
+// support receiving messages through group RVs), Alice encrypts a single copy
+// of the GC message with the key derived for the current counter and pushes it
+// to the corresponding RV, instead of sending one copy to each member through
+// their ratchets. The message is still signed by Alice, so members may not
+// forge messages from other members.
+//
+// When a member is removed from the GC, the remaining members rotate their
+// sender keys and send the new keys only to the remaining members.
+
+// gcIDOfGroupRVPayload returns the GC id of the given payload, if the payload
+// can be sent through the group RVs of a GC.
+func gcIDOfGroupRVPayload(msg interface{}) (zkidentity.ShortID, bool) {
+	switch msg := msg.(type) {
+	case rpc.RMGroupMessage:
+		return msg.ID, true
+	case rpc.RMMessageEdit:
+		return msg.GC, !msg.GC.IsEmpty()
+	case rpc.RMMessageRetract:
+		return msg.GC, !msg.GC.IsEmpty()
+	case rpc.RMMessageReaction:
+		return msg.GC, !msg.GC.IsEmpty()
+	default:
+		return zkidentity.ShortID{}, false
+	}
+}
+
+// rpcSenderKey converts the db sender key to its rpc representation.
+func rpcSenderKey(sk *clientdb.GCSenderKey) rpc.RMGroupSenderKey {
+	return rpc.RMGroupSenderKey{
+		ID:      sk.GC,
+		Epoch:   sk.Epoch,
+		Key:     sk.Key,
+		Counter: sk.Counter,
+	}
+}
+
+// newGCSenderKey generates a new random sender key for the local client.
+func (c *Client) newGCSenderKey(gcID zkidentity.ShortID, epoch uint64) *clientdb.GCSenderKey {
+	key := clientintf.RandomID()
+	return &clientdb.GCSenderKey{
+		GC:    gcID,
+		UID:   c.PublicID(),
+		Epoch: epoch,
+		Key:   key[:],
+	}
+}
+
+// updateGCSenderKey ensures the local sender key of the GC has been sent to
+// all current members, rotating it if any member that received it is no
+// longer in the GC. It returns true if messages to the GC may be sent through
+// the group RVs.
+func (c *Client) updateGCSenderKey(gcID zkidentity.ShortID) (bool, error) {
+	if c.linkedDevice {
+		return false, nil
+	}
+
+	// Group RVs are only used when the server allows multiple members to
+	// subscribe to them.
+	c.svrSharedRVsMtx.Lock()
+	canUseGroupRV := c.svrSharedRVs
+	c.svrSharedRVsMtx.Unlock()
+
+	me := c.PublicID()
+	var sk *clientdb.GCSenderKey
+	var sendTo []UserID
+	err := c.dbUpdate(func(tx clientdb.ReadWriteTx) error {
+		gc, err := c.db.GetGC(tx, gcID)
+		if err != nil {
+			return err
+		}
+		gcBlockList, err := c.db.GetGCBlockList(tx, gcID)
+		if err != nil {
+			return err
+		}
+
+		sk, err = c.db.GetGCSenderKey(tx, gcID, me)
+		if errors.Is(err, clientdb.ErrNotFound) {
+			sk = c.newGCSenderKey(gcID, 1)
+		} else if err != nil {
+			return err
+		}
+
+		// Rotate the key if any member that received it was removed.
+		for _, uid := range sk.Members {
+			if containsUserID(gc.Members, uid) {
+				continue
+			}
+			c.log.Debugf("Rotating sender key of GC %s due to "+
+				"removed member %s", gcID, uid)
+			sk = c.newGCSenderKey(gcID, sk.Epoch+1)
+			break
+		}
+
+		for _, uid := range gc.Members {
+			if uid == me {
+				continue
+			}
+
+			// Members ignored by the local client must not
+			// receive the messages pushed to the group RVs.
+			if gcBlockList.IsBlocked(uid) {
+				canUseGroupRV = false
+			}
+
+			if !containsUserID(sk.Members, uid) {
+				if _, err := c.rul.byID(uid); err != nil {
+					canUseGroupRV = false
+					continue
+				}
+				sendTo = append(sendTo, uid)
+				sk.Members = append(sk.Members, uid)
+			}
+
+			// Members that did not share their own key either do
+			// not support group RVs or have not received ours yet.
+			_, err := c.db.GetGCSenderKey(tx, gcID, uid)
+			if errors.Is(err, clientdb.ErrNotFound) {
+				canUseGroupRV = false
+			} else if err != nil {
+				return err
+			}
+		}
+		return c.db.SaveGCSenderKey(tx, sk)
+	})
+	if err != nil {
+		return false, err
+	}
+
+	if len(sendTo) > 0 {
+		c.log.Debugf("Sending sender key (epoch %d) of GC %s to %d members",
+			sk.Epoch, gcID, len(sendTo))
+		rm := rpcSenderKey(sk)
+		if err := c.sendWithSendQ("gcsenderkey", rm, sendTo...); err != nil {
+			return false, err
+		}
+	}
+	return canUseGroupRV, nil
+}
+
+// sendToGCGroupRV pushes a single copy of the message to the next group RV of
+// the local client's sender key of the GC.
+//
+// The counter of the sender key is only advanced after the server accepts the
+// message, so that a failed push does not create a gap in the group RVs.
+//
+// Only one message may be pushed at a time, otherwise multiple messages would
+// be pushed to the same RV. Use queueGCGroupRVPush to push in the background.
+func (c *Client) sendToGCGroupRV(gcID zkidentity.ShortID, nbMembers int,
+	payEvent string, msg interface{}, progressChan chan SendProgress) error {
+
+	var sk *clientdb.GCSenderKey
+	err := c.dbView(func(tx clientdb.ReadTx) error {
+		var err error
+		sk, err = c.db.GetGCSenderKey(tx, gcID, c.PublicID())
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	rsk := rpcSenderKey(sk)
+	counter := sk.Counter
+
+	data, err := rpc.ComposeCompressedRM(c.id, msg, c.cfg.CompressLevel)
+	if err != nil {
+		return err
+	}
+	box, err := sw.Seal(data, rsk.MsgKey(counter))
+	if err != nil {
+		return err
+	}
+
+	rm := rawRM{
+		pri:      priorityGC,
+		rv:       rsk.RV(counter),
+		msg:      box,
+		paidRMCB: c.kxl.makePaidForRMCB(gcID, payEvent),
+	}
+	if err := c.q.SendRM(rm); err != nil {
+		return err
+	}
+
+	// Advance the counter, unless the key was rotated in the meantime.
+	err = c.dbUpdate(func(tx clientdb.ReadWriteTx) error {
+		sk, err := c.db.GetGCSenderKey(tx, gcID, c.PublicID())
+		if err != nil {
+			return err
+		}
+		if sk.Epoch != rsk.Epoch || sk.Counter != counter {
+			return nil
+		}
+		sk.Counter += 1
+		return c.db.SaveGCSenderKey(tx, sk)
+	})
+	if err != nil {
+		return err
+	}
+
+	if progressChan != nil {
+		progressChan <- SendProgress{
+			Sent:  nbMembers,
+			Total: nbMembers,
+		}
+	}
+	return nil
+}
+
+// queueGCGroupRVPush pushes the message to the group RV of the GC in the
+// background, after the previously queued pushes to the same GC are done, so
+// that messages are received in the order they were sent. The result of the
+// push is passed to onDone.
+func (c *Client) queueGCGroupRVPush(gcID zkidentity.ShortID, nbMembers int,
+	payEvent string, msg interface{}, progressChan chan SendProgress,
+	onDone func(err error)) {
+
+	done := make(chan struct{})
+	c.gcGroupRVMtx.Lock()
+	if c.gcGroupRVLastPush == nil {
+		c.gcGroupRVLastPush = make(map[zkidentity.ShortID]chan struct{})
+	}
+	prev := c.gcGroupRVLastPush[gcID]
+	c.gcGroupRVLastPush[gcID] = done
+	c.gcGroupRVMtx.Unlock()
+
+	go func() {
+		if prev != nil {
+			<-prev
+		}
+		err := c.sendToGCGroupRV(gcID, nbMembers, payEvent, msg, progressChan)
+		close(done)
+
+		// Forget the chain of the GC if no other push was queued.
+		c.gcGroupRVMtx.Lock()
+		if c.gcGroupRVLastPush[gcID] == done {
+			delete(c.gcGroupRVLastPush, gcID)
+		}
+		c.gcGroupRVMtx.Unlock()
+
+		onDone(err)
+	}()
+}
+
+// canSendToGCGroupRV returns true if the message may be sent through the group
+// RVs of the GC, because the message and the members of the GC support it.
+func (c *Client) canSendToGCGroupRV(gcID zkidentity.ShortID, msg interface{}) bool {
+	if msgGCID, ok := gcIDOfGroupRVPayload(msg); !ok || msgGCID != gcID {
+		return false
+	}
+
+	canUseGroupRV, err := c.updateGCSenderKey(gcID)
+	if err != nil {
+		c.log.Warnf("Unable to update sender key of GC %s: %v", gcID, err)
+		return false
+	}
+	return canUseGroupRV
+}
+
+// gcGroupRVWindow is the number of group RVs of each remote member that are
+// subscribed at once, starting at the next expected counter. This allows
+// receiving further messages when a message pushed by the member is lost.
+const gcGroupRVWindow = 4
+
+// gcGroupRVSubscriber returns the id with which the local client subscribes
+// to the group RV of the given counter. The id is stable across sessions, so
+// that the server does not push again messages already ack'd by the local
+// client, but it is derived from the local private key, so that the server
+// cannot link the ids of different RVs and other members cannot compute it.
+func (c *Client) gcGroupRVSubscriber(rsk *rpc.RMGroupSenderKey, counter uint64) [32]byte {
+	key := rsk.SharedRVKey(counter)
+	h := sha256.New()
+	h.Write([]byte("brgcgrouprvsub"))
+	h.Write(key[:])
+	h.Write(c.id.PrivateSigKey[:])
+
+	var sub [32]byte
+	copy(sub[:], h.Sum(nil))
+	return sub
+}
+
+// subGCSenderKeyRVs subscribes to the group RVs of the sender key of a remote
+// GC member in the range [from, to).
+func (c *Client) subGCSenderKeyRVs(sk clientdb.GCSenderKey, from, to uint64) error {
+	rsk := rpcSenderKey(&sk)
+	var g errgroup.Group
+	for counter := from; counter < to; counter++ {
+		counter := counter
+		handler := func(blob lowlevel.RVBlob) error {
+			go c.recvGCGroupRVBlob(sk, counter, blob)
+			return nil
+		}
+		shared := rpc.SharedRendezvous{
+			Key:        rsk.SharedRVKey(counter),
+			Subscriber: c.gcGroupRVSubscriber(&rsk, counter),
+		}
+		g.Go(func() error { return c.rmgr.SubShared(shared, handler, nil) })
+	}
+	err := g.Wait()
+	if errors.Is(err, lowlevel.ErrRVAlreadySubscribed{}) {
+		// Not fatal: the window was already extended.
+		c.log.Debugf("Unexpected non-fatal error: %v", err)
+		err = nil
+	}
+	return err
+}
+
+// unsubGCSenderKeyRVs unsubscribes from the group RVs of the sender key of a
+// remote GC member in the range [from, to).
+func (c *Client) unsubGCSenderKeyRVs(sk clientdb.GCSenderKey, from, to uint64) error {
+	rsk := rpcSenderKey(&sk)
+	var g errgroup.Group
+	for counter := from; counter < to; counter++ {
+		rv := rsk.RV(counter)
+		g.Go(func() error { return c.rmgr.UnsubShared(rv) })
+	}
+	err := g.Wait()
+	if errors.Is(err, lowlevel.ErrRVAlreadyUnsubscribed{}) {
+		err = nil
+	}
+	return err
+}
+
+// listenGCSenderKey subscribes to the next group RVs of the sender key of a
+// remote GC member.
+func (c *Client) listenGCSenderKey(sk clientdb.GCSenderKey) error {
+	return c.subGCSenderKeyRVs(sk, sk.Counter, sk.Counter+gcGroupRVWindow)
+}
+
+// listenAllGCSenderKeys subscribes to the group RVs of all remote GC members.
+// Keys of members that are no longer in their GCs are removed.
+func (c *Client) listenAllGCSenderKeys() error {
+	me := c.PublicID()
+	var keys []clientdb.GCSenderKey
+	err := c.dbUpdate(func(tx clientdb.ReadWriteTx) error {
+		all, err := c.db.ListGCSenderKeys(tx)
+		if err != nil {
+			return err
+		}
+		for _, sk := range all {
+			if sk.UID == me {
+				continue
+			}
+			gc, err := c.db.GetGC(tx, sk.GC)
+			if err != nil && !errors.Is(err, clientdb.ErrNotFound) {
+				return err
+			}
+			if err == nil && containsUserID(gc.Members, sk.UID) {
+				keys = append(keys, sk)
+				continue
+			}
+			if err := c.db.RemoveGCSenderKey(tx, sk.GC, sk.UID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, sk := range keys {
+		if err := c.listenGCSenderKey(sk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// gcGroupRVReorderDelay is how long messages received in the group RVs of a
+// remote member wait for the messages pushed before them, before these are
+// considered lost.
+const gcGroupRVReorderDelay = time.Second
+
+// gcGroupRVRecvKey identifies a sender key of a remote GC member.
+type gcGroupRVRecvKey struct {
+	gc    zkidentity.ShortID
+	uid   UserID
+	epoch uint64
+}
+
+// gcGroupRVRecvQueue tracks the messages received in the group RVs of a
+// sender key that are waiting for the messages pushed before them.
+type gcGroupRVRecvQueue struct {
+	pending map[uint64]lowlevel.RVBlob
+	timer   *time.Timer
+}
+
+// minCounter returns the lowest counter of the pending messages.
+func (q *gcGroupRVRecvQueue) minCounter() uint64 {
+	first := true
+	var min uint64
+	for counter := range q.pending {
+		if first || counter < min {
+			min = counter
+			first = false
+		}
+	}
+	return min
+}
+
+// recvGCGroupRVBlob handles a message pushed by a remote member to the group
+// RV of the given counter. Messages are handled in the order they were pushed.
+func (c *Client) recvGCGroupRVBlob(sk clientdb.GCSenderKey, counter uint64,
+	blob lowlevel.RVBlob) {
+
+	key := gcGroupRVRecvKey{gc: sk.GC, uid: sk.UID, epoch: sk.Epoch}
+	c.gcGroupRVRecvMtx.Lock()
+	defer c.gcGroupRVRecvMtx.Unlock()
+
+	if c.gcGroupRVRecv == nil {
+		c.gcGroupRVRecv = make(map[gcGroupRVRecvKey]*gcGroupRVRecvQueue)
+	}
+	q := c.gcGroupRVRecv[key]
+	if q == nil {
+		q = &gcGroupRVRecvQueue{pending: make(map[uint64]lowlevel.RVBlob)}
+		c.gcGroupRVRecv[key] = q
+	}
+	q.pending[counter] = blob
+	c.processGCGroupRVQueue(sk, q, false)
+}
+
+// processGCGroupRVQueue handles the pending messages of the queue that follow
+// the last handled message. If skipGap is true, the first missing message is
+// considered lost and the following ones are handled.
+//
+// This must be called with gcGroupRVRecvMtx held.
+func (c *Client) processGCGroupRVQueue(sk clientdb.GCSenderKey,
+	q *gcGroupRVRecvQueue, skipGap bool) {
+
+	for len(q.pending) > 0 {
+		// Advance the counter past the next message, unless the key
+		// was rotated in the meantime (in which case the messages are
+		// handled as they are received).
+		var counter, prevCounter uint64
+		var advanced, wait bool
+		err := c.dbUpdate(func(tx clientdb.ReadWriteTx) error {
+			counter = q.minCounter()
+			dbsk, err := c.db.GetGCSenderKey(tx, sk.GC, sk.UID)
+			if errors.Is(err, clientdb.ErrNotFound) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if dbsk.Epoch != sk.Epoch || counter < dbsk.Counter {
+				return nil
+			}
+			if counter > dbsk.Counter && !skipGap {
+				wait = true
+				return nil
+			}
+			advanced = true
+			prevCounter = dbsk.Counter
+			dbsk.Counter = counter + 1
+			return c.db.SaveGCSenderKey(tx, dbsk)
+		})
+		if err != nil {
+			c.log.Errorf("Unable to update counter of sender key of "+
+				"%s in GC %s: %v", sk.UID, sk.GC, err)
+			return
+		}
+		if wait {
+			break
+		}
+
+		blob := q.pending[counter]
+		delete(q.pending, counter)
+		if advanced {
+			if counter > prevCounter {
+				c.log.Warnf("Skipped %d lost messages from %s in group "+
+					"RVs of GC %s", counter-prevCounter, sk.UID, sk.GC)
+				skipGap = false
+			}
+			go c.slideGCSenderKeyRVs(sk, prevCounter, counter+1)
+		}
+
+		err = c.handleGCGroupRVBlob(sk, counter, blob)
+		if err != nil && !errors.Is(err, clientintf.ErrSubsysExiting) {
+			c.log.Errorf("Unable to handle msg from %s in group "+
+				"RV of GC %s: %v", sk.UID, sk.GC, err)
+		}
+	}
+
+	key := gcGroupRVRecvKey{gc: sk.GC, uid: sk.UID, epoch: sk.Epoch}
+	switch {
+	case len(q.pending) == 0:
+		if q.timer != nil {
+			q.timer.Stop()
+		}
+		delete(c.gcGroupRVRecv, key)
+
+	case q.timer == nil:
+		// Wait for the missing messages.
+		q.timer = time.AfterFunc(gcGroupRVReorderDelay, func() {
+			c.gcGroupRVRecvMtx.Lock()
+			q.timer = nil
+			if c.gcGroupRVRecv[key] == q {
+				c.processGCGroupRVQueue(sk, q, true)
+			}
+			c.gcGroupRVRecvMtx.Unlock()
+		})
+	}
+}
+
+// slideGCSenderKeyRVs slides the window of subscribed group RVs of the sender
+// key after the counter advanced from prevCounter to counter.
+func (c *Client) slideGCSenderKeyRVs(sk clientdb.GCSenderKey, prevCounter, counter uint64) {
+	err := c.unsubGCSenderKeyRVs(sk, prevCounter, counter)
+	if err == nil {
+		from := prevCounter + gcGroupRVWindow
+		if from < counter {
+			from = counter
+		}
+		err = c.subGCSenderKeyRVs(sk, from, counter+gcGroupRVWindow)
+	}
+	if err != nil && !errors.Is(err, clientintf.ErrSubsysExiting) {
+		c.log.Errorf("Unable to update group RVs of %s in GC %s: %v",
+			sk.UID, sk.GC, err)
+	}
+}
+
+// handleGCGroupRVBlob handles a message pushed by a remote member to the group
+// RV of the given counter.
+func (c *Client) handleGCGroupRVBlob(sk clientdb.GCSenderKey, counter uint64,
+	blob lowlevel.RVBlob) error {
+
+	ru, err := c.rul.byID(sk.UID)
+	if err != nil {
+		return err
+	}
+
+	rsk := rpcSenderKey(&sk)
+	if len(blob.Decoded) < sw.MinPackedEncryptedSize {
+		return fmt.Errorf("group RV message too short")
+	}
+	data, ok := sw.Open(blob.Decoded, rsk.MsgKey(counter))
+	if !ok {
+		return fmt.Errorf("unable to decrypt group RV message")
+	}
+	h, p, err := rpc.DecomposeRM(ru.id, data)
+	if err != nil {
+		return fmt.Errorf("could not decode group RV message: %v", err)
+	}
+	if gcID, ok := gcIDOfGroupRVPayload(p); !ok || gcID != sk.GC {
+		return fmt.Errorf("invalid payload %T in group RV of GC %s", p,
+			sk.GC)
+	}
+
+	ru.log.Debugf("Received RM %q via group RV %s of GC %s", h.Command,
+		blob.ID, sk.GC)
+	c.handleUserRM(ru, h, p, blob.ServerTS)
+	if ru.rawRMHandler != nil {
+		ru.rawRMHandler(ru, h, data, blob.ServerTS)
+	}
+	return nil
+}
+
+// handleGCSenderKey handles a sender key shared by a GC member.
+func (c *Client) handleGCSenderKey(ru *RemoteUser, rsk rpc.RMGroupSenderKey) error {
+	if len(rsk.Key) != rpc.GroupSenderKeySize {
+		return fmt.Errorf("invalid sender key size %d", len(rsk.Key))
+	}
+
+	sk := clientdb.GCSenderKey{
+		GC:      rsk.ID,
+		UID:     ru.ID(),
+		Epoch:   rsk.Epoch,
+		Key:     rsk.Key,
+		Counter: rsk.Counter,
+	}
+	var isNew bool
+	var old *clientdb.GCSenderKey
+	err := c.dbUpdate(func(tx clientdb.ReadWriteTx) error {
+		gc, err := c.db.GetGC(tx, rsk.ID)
+		if err != nil {
+			return err
+		}
+		if !containsUserID(gc.Members, ru.ID()) {
+			return fmt.Errorf("%w: %s", errNotGCMember, ru.ID())
+		}
+
+		old, err = c.db.GetGCSenderKey(tx, rsk.ID, ru.ID())
+		switch {
+		case errors.Is(err, clientdb.ErrNotFound):
+			old = nil
+		case err != nil:
+			return err
+		case old.Epoch >= rsk.Epoch:
+			// Already have this (or a newer) key.
+			return nil
+		}
+		isNew = true
+		return c.db.SaveGCSenderKey(tx, &sk)
+	})
+	if err != nil {
+		return err
+	}
+
+	if isNew {
+		ru.log.Debugf("Received sender key (epoch %d) of GC %s",
+			rsk.Epoch, rsk.ID)
+
+		// Stop listening on the RVs of the previous key.
+		if old != nil {
+			err := c.unsubGCSenderKeyRVs(*old, old.Counter,
+				old.Counter+gcGroupRVWindow)
+			if err != nil {
+				return err
+			}
+		}
+		if err := c.listenGCSenderKey(sk); err != nil {
+			return err
+		}
+	}
+
+	// Ensure the member has our own key.
+	_, err = c.updateGCSenderKey(rsk.ID)
+	return err
+}
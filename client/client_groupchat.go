@@ -134,6 +134,7 @@ func (c *Client) NewGroupChat(name string) (zkidentity.ShortID, error) {
 				c.PublicID(),
 			},
 		}
+		c.signGCList(&gc)
 		if err = c.db.SaveGC(tx, gc); err != nil {
 			return fmt.Errorf("can't save gc %q (%s): %v", name, id.String(), err)
 		}
@@ -146,7 +147,11 @@ func (c *Client) NewGroupChat(name string) (zkidentity.ShortID, error) {
 
 		return nil
 	})
-	return id, err
+	if err != nil {
+		return id, err
+	}
+	c.syncGCsToDevices()
+	return id, nil
 }
 
 // InviteToGroupChat invites the given user to the given gc. The local user
@@ -159,7 +164,7 @@ func (c *Client) InviteToGroupChat(gcID zkidentity.ShortID, user UserID) error {
 
 	invite := rpc.RMGroupInvite{
 		ID:      gcID,
-		Expires: time.Now().Add(time.Hour * 24).Unix(),
+		Expires: time.Now().Add(c.cfg.gcInviteExpiration()).Unix(),
 	}
 
 	err = c.dbUpdate(func(tx clientdb.ReadWriteTx) error {
@@ -169,13 +174,20 @@ func (c *Client) InviteToGroupChat(gcID zkidentity.ShortID, user UserID) error {
 			return err
 		}
 
-		if len(gc.Members) == 0 || gc.Members[0] != c.PublicID() {
+		if !gc.IsAdmin(c.PublicID()) {
 			return fmt.Errorf("cannot create gc invite: not an admin of gc %s",
 				gcID)
 		}
 
 		invite.Name = gc.Name
 
+		// Let the invitee know what the GC is about.
+		md, err := c.db.GetGCMetadata(tx, gcID)
+		if err != nil {
+			return err
+		}
+		invite.Description = md.Description
+
 		// Generate an unused token.
 		for {
 			// The % 1000000 is to generate a shorter token and
@@ -212,6 +224,10 @@ func (c *Client) handleGCInvite(ru *RemoteUser, invite rpc.RMGroupInvite) error
 	if invite.ID.IsEmpty() {
 		return fmt.Errorf("cannot accept gc invite: gc id is empty")
 	}
+	if invite.IsExpired(time.Now()) {
+		return fmt.Errorf("cannot accept gc invite %s: %w", invite.ID,
+			errExpiredGCInvite)
+	}
 
 	invite.Name = strings.TrimSpace(invite.Name)
 	if invite.Name == "" {
@@ -242,6 +258,11 @@ func (c *Client) handleGCInvite(ru *RemoteUser, invite rpc.RMGroupInvite) error
 		return err
 	}
 
+	// Invites for GCs of redeemed join links are automatically accepted.
+	if accepted, err := c.maybeAcceptRedeemedGCInvite(ru, iid, invite); accepted || err != nil {
+		return err
+	}
+
 	// Let user know about it.
 	c.log.Infof("Received invitation to gc %q from user %s", invite.ID.String(), ru)
 	if c.cfg.GCInviteHandler != nil {
@@ -262,6 +283,9 @@ func (c *Client) AcceptGroupChatInvite(iid uint64) error {
 		if err != nil {
 			return err
 		}
+		if invite.IsExpired(time.Now()) {
+			return errExpiredGCInvite
+		}
 
 		if err := c.db.MarkGCInviteAccepted(tx, iid); err != nil {
 			return err
@@ -287,15 +311,22 @@ func (c *Client) AcceptGroupChatInvite(iid uint64) error {
 }
 
 // ListGCInvitesFor returns all GC invites received that were for the specified
-// gc name.
+// gc name. Expired invites are removed instead of returned.
 func (c *Client) ListGCInvitesFor(gcName string) ([]*clientdb.GCInvite, error) {
+	if err := c.removeExpiredGCInvites(); err != nil {
+		return nil, err
+	}
+
 	var invites []*clientdb.GCInvite
 	err := c.dbView(func(tx clientdb.ReadTx) error {
 		var err error
 		invites, err = c.db.ListGCInvites(tx, gcName)
 		return err
 	})
-	return invites, err
+	if err != nil {
+		return nil, err
+	}
+	return invites, nil
 }
 
 // sendToGCMembers sends the given message to all GC members of the given slice
@@ -315,13 +346,47 @@ func (c *Client) sendToGCMembers(gcID zkidentity.ShortID,
 
 		ids = append(ids, uid)
 	}
+
 	sqid, err := c.addToSendQ(payEvent, msg, priorityGC, ids...)
 	if err != nil {
 		c.log.Errorf("Unable to add gc msg to send queue: %v", err)
 	}
 
+	// Push a single copy of the message when all members support group
+	// RVs. If the push fails, the message is sent to each member
+	// individually.
+	if c.canSendToGCGroupRV(gcID, msg) {
+		c.queueGCGroupRVPush(gcID, len(ids), payEvent, msg, progressChan, func(err error) {
+			if errors.Is(err, clientintf.ErrSubsysExiting) {
+				return
+			}
+			if err == nil {
+				for _, id := range ids {
+					c.removeFromSendQ(sqid, id)
+				}
+				return
+			}
+			c.log.Warnf("Unable to push %T to group RV of GC %s, sending "+
+				"to individual members: %v", msg, gcID, err)
+			c.sendToGCMembersIndividually(gcID, members, sqid, payEvent,
+				msg, progressChan)
+		})
+		return
+	}
+
+	c.sendToGCMembersIndividually(gcID, members, sqid, payEvent, msg, progressChan)
+}
+
+// sendToGCMembersIndividually sends the given message to the GC members
+// through their individual ratchets.
+func (c *Client) sendToGCMembersIndividually(gcID zkidentity.ShortID,
+	members []zkidentity.ShortID, sqid clientdb.SendQID, payEvent string,
+	msg interface{}, progressChan chan SendProgress) {
+
+	localID := c.PublicID()
 	var progressMtx sync.Mutex
 	var sent, total int
+	var hasUnkxd bool
 
 	for _, id := range members {
 		if id == localID {
@@ -341,6 +406,7 @@ func (c *Client) sendToGCMembers(gcID zkidentity.ShortID,
 				c.log.Errorf("Error finding gc %q member %s in user list: %v",
 					gcID.String(), id, err)
 			}
+			hasUnkxd = true
 			continue
 		}
 
@@ -375,17 +441,28 @@ func (c *Client) sendToGCMembers(gcID zkidentity.ShortID,
 			}
 		}()
 	}
+
+	// Attempt to KX with the members that are not KX'd yet.
+	if hasUnkxd {
+		c.startGCMeshKX(gcID, nil, true)
+	}
 }
 
 // handleGCJoin handles a msg when a remote user is asking to join a GC we
 // administer (that is, responding to an invite previously sent by us).
 func (c *Client) handleGCJoin(ru *RemoteUser, invite rpc.RMGroupJoin) error {
 	var gc rpc.RMGroupList
+	var rejectErr error
 	updated := false
 	err := c.dbUpdate(func(tx clientdb.ReadWriteTx) error {
 		var err error
-		_, uid, iid, err := c.db.FindGCInvite(tx,
+		sentInvite, uid, iid, err := c.db.FindGCInvite(tx,
 			invite.ID, invite.Token)
+		if errors.Is(err, clientdb.ErrNotFound) {
+			// The invite was revoked or removed after expiring.
+			rejectErr = errUnknownGCInvite
+			return nil
+		}
 		if err != nil {
 			return err
 		}
@@ -402,8 +479,14 @@ func (c *Client) handleGCJoin(ru *RemoteUser, invite rpc.RMGroupJoin) error {
 		if err != nil {
 			return err
 		}
-		if gc.Members[0] != c.PublicID() {
-			return fmt.Errorf("cannot add gc member when not the gc admin")
+		if !gc.IsAdmin(c.PublicID()) {
+			return fmt.Errorf("cannot add gc member when not a gc admin")
+		}
+
+		// Stale invites are no longer valid.
+		if sentInvite.IsExpired(time.Now()) {
+			rejectErr = errExpiredGCInvite
+			return c.db.DelGCInvite(tx, iid)
 		}
 
 		// Ensure user is not on gc yet.
@@ -420,6 +503,7 @@ func (c *Client) handleGCJoin(ru *RemoteUser, invite rpc.RMGroupJoin) error {
 			gc.Members = append(gc.Members, uid)
 			gc.Generation += 1
 			gc.Timestamp = time.Now().Unix()
+			c.signGCList(&gc)
 			if err = c.db.SaveGC(tx, gc); err != nil {
 				return err
 			}
@@ -437,15 +521,38 @@ func (c *Client) handleGCJoin(ru *RemoteUser, invite rpc.RMGroupJoin) error {
 		return nil
 	})
 
-	if err != nil || !updated {
+	if err != nil {
 		return err
 	}
+	if rejectErr != nil && invite.Error != "" {
+		// The user rejected an invite that is no longer valid.
+		return nil
+	}
+	if rejectErr != nil {
+		// Let the user know the join failed, so that they do not wait
+		// for the GC list indefinitely.
+		rej := rpc.RMGroupJoinRejected{
+			ID:     invite.ID,
+			Token:  invite.Token,
+			Reason: rejectErr.Error(),
+		}
+		payEvent := fmt.Sprintf("gc.%s.joinrejected", invite.ID.ShortLogID())
+		if err := ru.sendRM(rej, payEvent); err != nil {
+			ru.log.Warnf("Unable to send GC join rejection: %v", err)
+		}
+		return fmt.Errorf("user %s attempted to join gc %s: %w", ru,
+			invite.ID, rejectErr)
+	}
+	if !updated {
+		return nil
+	}
+	c.syncGCsToDevices()
 
 	c.log.Infof("User %s joined gc %s (%q)", ru, gc.ID, gc.Name)
 
 	// Join fulfilled. Send new group list to every member except admin
 	// (us).
-	c.sendToGCMembers(gc.ID, gc.Members, "sendlist", gc, nil)
+	c.sendGCListToNewMember(gc, ru.ID())
 
 	if c.cfg.GCJoinHandler != nil {
 		var entry clientdb.GCAddressBookEntry
@@ -456,8 +563,41 @@ func (c *Client) handleGCJoin(ru *RemoteUser, invite rpc.RMGroupJoin) error {
 	return nil
 }
 
+// handleGCJoinRejected handles a msg from the admin of a GC rejecting our
+// acceptance of an invite to join the GC.
+func (c *Client) handleGCJoinRejected(ru *RemoteUser, rej rpc.RMGroupJoinRejected) error {
+	var invite rpc.RMGroupInvite
+	err := c.dbUpdate(func(tx clientdb.ReadWriteTx) error {
+		var uid UserID
+		var iid uint64
+		var err error
+		invite, uid, iid, err = c.db.FindGCInvite(tx, rej.ID, rej.Token)
+		if err != nil {
+			return err
+		}
+
+		// Ensure the rejection comes from the user that invited us.
+		if uid != ru.ID() {
+			return fmt.Errorf("received GC join rejection from user %s "+
+				"for invite received from user %s", ru.ID(), uid)
+		}
+
+		return c.db.DelGCInvite(tx, iid)
+	})
+	if err != nil {
+		return err
+	}
+
+	ru.log.Infof("Join of gc %q (%s) rejected: %q", invite.Name, rej.ID,
+		rej.Reason)
+	if c.cfg.GCJoinRejected != nil {
+		c.cfg.GCJoinRejected(ru, invite, rej.Reason)
+	}
+	return nil
+}
+
 // handleGCList handles updates to a GC metadata. The sending user must have
-// been the admin, otherwise this update is rejected.
+// been an admin, otherwise this update is rejected.
 func (c *Client) handleGCList(ru *RemoteUser, gl rpc.RMGroupList) error {
 	newGC := false
 	err := c.dbUpdate(func(tx clientdb.ReadWriteTx) error {
@@ -468,7 +608,7 @@ func (c *Client) handleGCList(ru *RemoteUser, gl rpc.RMGroupList) error {
 			return err
 		}
 
-		var gcName string
+		var gcName, gcDescr string
 		if newGC {
 			// This must have been an invite we accepted. Ensure
 			// this came from the expected user.
@@ -478,12 +618,13 @@ func (c *Client) handleGCList(ru *RemoteUser, gl rpc.RMGroupList) error {
 			}
 			found := false
 			for _, inv := range invites {
-				if !inv.Accepted {
+				if !inv.Accepted || inv.Invite.ID != gl.ID {
 					continue
 				}
 				if inv.User == ru.ID() {
 					found = true
 					gcName = inv.Invite.Name
+					gcDescr = inv.Invite.Description
 					break
 				}
 			}
@@ -492,23 +633,28 @@ func (c *Client) handleGCList(ru *RemoteUser, gl rpc.RMGroupList) error {
 					"list", gl.ID.String())
 			}
 
-			// Ensure we received this from the admin.
-			if gl.Members[0] != ru.ID() {
-				return fmt.Errorf("received gc list %q from non-admin",
-					gl.ID.String())
+			// Ensure the list was produced by an admin.
+			if err := c.verifyGCAdmins(nil, &gl); err != nil {
+				return err
+			}
+			if err := c.verifyGCListSig(ru, &gl, &gl, gl.IsAdmin); err != nil {
+				return err
 			}
 
 			// Clear out the invites.
 			for _, inv := range invites {
+				if inv.Invite.ID != gl.ID {
+					continue
+				}
 				if err := c.db.DelGCInvite(tx, inv.ID); err != nil {
 					return fmt.Errorf("unable to del gc invite: %v", err)
 				}
 			}
 		} else {
-			// Ensure we received this from the existing admin.
-			if oldGC.Members[0] != ru.ID() {
-				return fmt.Errorf("received gc list %q from non-admin",
-					oldGC.ID.String())
+			// Ensure the list was produced by an existing admin.
+			err := c.verifyGCListSig(ru, &oldGC, &gl, oldGC.IsAdmin)
+			if err != nil {
+				return err
 			}
 
 			// Ensure no backtrack on generation.
@@ -517,12 +663,24 @@ func (c *Client) handleGCList(ru *RemoteUser, gl rpc.RMGroupList) error {
 					"generation (%d < %d)", oldGC.ID.String(), gl.Generation,
 					oldGC.Generation)
 			}
+
+			if err := c.verifyGCAdmins(&oldGC, &gl); err != nil {
+				return err
+			}
 		}
 
 		// All is well. Update the local gc data.
 		if err = c.db.SaveGC(tx, gl); err != nil {
 			return fmt.Errorf("unable to save gc: %v", err)
 		}
+		if gcDescr != "" {
+			// Keep the description from the invite until the
+			// admins send the signed metadata of the GC.
+			md := rpc.RMGroupMetadata{ID: gl.ID, Description: gcDescr}
+			if err := c.db.SaveGCMetadata(tx, md); err != nil {
+				return fmt.Errorf("unable to save gc metadata: %v", err)
+			}
+		}
 		if gcName != "" {
 			// Check if already have this alias.
 			alias := gcName
@@ -541,9 +699,16 @@ func (c *Client) handleGCList(ru *RemoteUser, gl rpc.RMGroupList) error {
 
 		return nil
 	})
+	if errors.Is(err, errUnknownGCSigner) {
+		c.queueGCListUntilKX(ru, &gl, err, !newGC, func() error {
+			return c.handleGCList(ru, gl)
+		})
+		return nil
+	}
 	if err != nil {
 		return err
 	}
+	c.syncGCsToDevices()
 
 	if newGC {
 		c.log.Infof("Received first GC list of %q from %s", gl.ID.String(), ru)
@@ -557,31 +722,9 @@ func (c *Client) handleGCList(ru *RemoteUser, gl rpc.RMGroupList) error {
 		c.cfg.GCListUpdated(entry)
 	}
 
-	// Start kx with unknown members if we just joined the chat.
-	if !newGC {
-		return nil
-	}
-	me := c.PublicID()
-	for _, v := range gl.Members {
-		v := v
-		if v == me {
-			continue
-		}
-
-		_, err := c.rul.byID(v)
-		if !errors.Is(err, userNotFoundError{}) {
-			continue
-		}
-
-		// TODO: check if there are no inflight kx attempts yet.
-		go func() {
-			err := c.RequestMediateIdentity(ru.ID(), v)
-			if err != nil && !errors.Is(err, clientintf.ErrSubsysExiting) {
-				c.log.Errorf("Unable to autokx with %s via %s: %v",
-					v, ru, err)
-			}
-		}()
-	}
+	// Start kx with unknown members.
+	ruID := ru.ID()
+	c.startGCMeshKX(gl.ID, &ruID, true)
 
 	return nil
 }
@@ -589,7 +732,7 @@ func (c *Client) handleGCList(ru *RemoteUser, gl rpc.RMGroupList) error {
 // handleDelayedGCMessages is called by the gc message cacher when it's time
 // to let external callers know about new messages.
 func (c *Client) handleDelayedGCMessages(msgs []gcmcacher.Msg) {
-	if c.cfg.GCMsgHandler == nil {
+	if c.cfg.GCMsgHandler == nil && c.cfg.NotificationHandler == nil {
 		return
 	}
 
@@ -601,7 +744,10 @@ func (c *Client) handleDelayedGCMessages(msgs []gcmcacher.Msg) {
 			c.log.Warnf("Delayed GC message with unknown user %s", msg.UID)
 			continue
 		}
-		c.cfg.GCMsgHandler(user, msg.GCM, msg.TS)
+		if c.cfg.GCMsgHandler != nil {
+			c.cfg.GCMsgHandler(user, msg.GCM, msg.TS)
+		}
+		c.notifyGCMessage(user, &msg.GCM, msg.TS)
 	}
 }
 
@@ -619,6 +765,47 @@ type SendProgress struct {
 func (c *Client) GCMessage(gcID zkidentity.ShortID, msg string, mode rpc.MessageMode,
 	progressChan chan SendProgress) error {
 
+	_, err := c.GCMessageWithID(gcID, msg, mode, progressChan)
+	return err
+}
+
+// GCMessageWithID sends a message to the given GC and returns the id of the
+// message. Edits and retractions of this message reference this id.
+func (c *Client) GCMessageWithID(gcID zkidentity.ShortID, msg string, mode rpc.MessageMode,
+	progressChan chan SendProgress) (MsgID, error) {
+
+	id := clientintf.RandomID()
+	return id, c.gcMessage(gcID, id, MsgID{}, msg, mode, nil, progressChan, nil)
+}
+
+// GCMessageReply sends a message to the given GC as a reply to the message
+// with id replyTo and returns the id of the new message.
+func (c *Client) GCMessageReply(gcID zkidentity.ShortID, replyTo MsgID, msg string,
+	mode rpc.MessageMode, progressChan chan SendProgress) (MsgID, error) {
+
+	id := clientintf.RandomID()
+	return id, c.gcMessage(gcID, id, replyTo, msg, mode, nil, progressChan, nil)
+}
+
+// GCMessageWithMentions sends a message to the given GC that explicitly
+// mentions the given members and returns the id of the new message. replyTo
+// may be empty if the message is not a reply.
+func (c *Client) GCMessageWithMentions(gcID zkidentity.ShortID, replyTo MsgID, msg string,
+	mode rpc.MessageMode, mentions []UserID, progressChan chan SendProgress) (MsgID, error) {
+
+	id := clientintf.RandomID()
+	return id, c.gcMessage(gcID, id, replyTo, msg, mode, mentions, progressChan, nil)
+}
+
+// gcMessage sends a message to the given GC. replyTo is the id of the message
+// this one replies to, if any. mentions are the members explicitly mentioned
+// in the message. origin is the linked device that relayed the message, if it
+// was not sent by the local client.
+func (c *Client) gcMessage(gcID zkidentity.ShortID, id, replyTo MsgID, msg string,
+	mode rpc.MessageMode, mentions []UserID, progressChan chan SendProgress,
+	origin *zkidentity.ShortID) error {
+
+	now := time.Now()
 	var gc rpc.RMGroupList
 	var gcBlockList clientdb.GCBlockList
 	err := c.dbUpdate(func(tx clientdb.ReadWriteTx) error {
@@ -629,26 +816,67 @@ func (c *Client) GCMessage(gcID zkidentity.ShortID, msg string, mode rpc.Message
 		if gcBlockList, err = c.db.GetGCBlockList(tx, gcID); err != nil {
 			return err
 		}
+		for _, uid := range mentions {
+			if !containsUserID(gc.Members, uid) {
+				return fmt.Errorf("mentioned user %s is not a "+
+					"member of gc %s", uid, gcID)
+			}
+		}
 
 		gcAlias, err := c.GetGCAlias(gcID)
 		if err != nil {
 			gcAlias = gc.Name
 		}
 
-		return c.db.LogGCMsg(tx, gcAlias, gcID, false, c.id.Public.Nick, msg, time.Now())
+		return c.db.LogGCMsg(tx, gcAlias, gcID, clientdb.HistoryEntry{
+			Timestamp: now,
+			From:      c.id.Public.Nick,
+			FromUID:   c.id.Public.Identity,
+			Mode:      mode,
+			Message:   msg,
+			MsgID:     id,
+			ReplyTo:   replyTo,
+			Mentions:  mentions,
+		})
 	})
 	if err != nil {
 		return err
 	}
 
+	if c.linkedDevice {
+		return c.sendToPrimary(deviceMsg{
+			Type:      deviceMsgRelayGCM,
+			Timestamp: now,
+			GCID:      gcID,
+			Mode:      mode,
+			MsgID:     id,
+			ReplyTo:   replyTo,
+			Message:   msg,
+			Mentions:  mentions,
+		})
+	}
+
 	p := rpc.RMGroupMessage{
 		ID:         gcID,
 		Generation: gc.Generation,
 		Message:    msg,
 		Mode:       mode,
+		MsgID:      id,
+		ReplyTo:    replyTo,
+		Mentions:   mentions,
 	}
 	members := gcBlockList.FilterMembers(gc.Members)
 	c.sendToGCMembers(gcID, members, "msg", p, progressChan)
+	c.forwardToDevices(deviceMsg{
+		Type:      deviceMsgSentGCM,
+		Timestamp: now,
+		GCID:      gcID,
+		Mode:      mode,
+		MsgID:     id,
+		ReplyTo:   replyTo,
+		Message:   msg,
+		Mentions:  mentions,
+	}, origin)
 	return nil
 }
 
@@ -686,7 +914,16 @@ func (c *Client) handleGCMessage(ru *RemoteUser, gcm rpc.RMGroupMessage, ts time
 		if err != nil {
 			gcAlias = gc.Name
 		}
-		return c.db.LogGCMsg(tx, gcAlias, gcm.ID, false, ru.Nick(), gcm.Message, ts)
+		return c.db.LogGCMsg(tx, gcAlias, gcm.ID, clientdb.HistoryEntry{
+			Timestamp: ts,
+			From:      ru.Nick(),
+			FromUID:   ru.ID(),
+			Mode:      gcm.Mode,
+			Message:   gcm.Message,
+			MsgID:     gcm.MsgID,
+			ReplyTo:   gcm.ReplyTo,
+			Mentions:  gcm.Mentions,
+		})
 	})
 	if errors.Is(err, clientdb.ErrNotFound) {
 		// Remote user sent message on group chat we're no longer a
@@ -704,6 +941,9 @@ func (c *Client) handleGCMessage(ru *RemoteUser, gcm rpc.RMGroupMessage, ts time
 		return err
 	}
 
+	// Detect whether the local or remote list of members is outdated.
+	c.checkGCGeneration(ru, &gc, gcm.Generation, found)
+
 	if isBlocked {
 		c.log.Warnf("Received message in GC %q from blocked member %s",
 			gcAlias, ru)
@@ -727,14 +967,20 @@ func (c *Client) handleGCMessage(ru *RemoteUser, gcm rpc.RMGroupMessage, ts time
 // GetGC returns information about the given gc the local user participates in.
 func (c *Client) GetGC(gcID zkidentity.ShortID) (clientdb.GCAddressBookEntry, error) {
 	var gc rpc.RMGroupList
+	var retention rpc.RetentionPolicy
 	err := c.dbUpdate(func(tx clientdb.ReadWriteTx) error {
 		var err error
 		gc, err = c.db.GetGC(tx, gcID)
+		if err != nil {
+			return err
+		}
+		retention, err = c.db.GetGCRetention(tx, gcID)
 		return err
 	})
 
 	var entry clientdb.GCAddressBookEntry
 	clientdb.RMGroupListToGCEntry(&gc, &entry)
+	entry.Retention = retention
 	return entry, err
 }
 
@@ -761,7 +1007,8 @@ func (c *Client) ListGCs() ([]clientdb.GCAddressBookEntry, error) {
 	return gcs, err
 }
 
-// removeFromGC removes the given user from the GC.
+// removeFromGC removes the given user from the GC. If localUserMustBeAdmin is
+// true, the local user must be allowed to kick the user from the GC.
 //
 // Returns the old members of the gc and the new gc list.
 func (c *Client) removeFromGC(gcID zkidentity.ShortID, uid UserID,
@@ -780,9 +1027,10 @@ func (c *Client) removeFromGC(gcID zkidentity.ShortID, uid UserID,
 
 		oldMembers = gc.Members
 
-		if localUserMustBeAdmin && (len(oldMembers) == 0 || oldMembers[0] != c.PublicID()) {
-			return fmt.Errorf("local user is not the admin of the GC")
+		if localUserMustBeAdmin && !gc.CanKick(c.PublicID(), uid) {
+			return fmt.Errorf("local user is not allowed to kick user from the GC")
 		}
+		hadRole := gc.IsAdmin(uid) || gc.IsModerator(uid)
 
 		// Ensure the user is in the GC.
 		var newMembers []zkidentity.ShortID
@@ -801,6 +1049,23 @@ func (c *Client) removeFromGC(gcID zkidentity.ShortID, uid UserID,
 
 		gc.Members = newMembers
 		gc.Timestamp = time.Now().Unix()
+
+		// When the owner kicks a user with a role in the GC, the role
+		// is dropped so that it is not regained if the user rejoins
+		// the GC.
+		if localUserMustBeAdmin && hadRole && gc.IsOwner(c.PublicID()) {
+			c.signGCAdmins(&gc)
+		}
+
+		// The new list is signed by the kicker. When a member parts,
+		// the owner signs the new list and sends it to the remaining
+		// members, so that every member has a verifiable list.
+		if localUserMustBeAdmin {
+			c.signGCList(&gc)
+		} else if gc.IsOwner(c.PublicID()) {
+			gc.Generation += 1
+			c.signGCList(&gc)
+		}
 		if err = c.db.SaveGC(tx, gc); err != nil {
 			return err
 		}
@@ -809,12 +1074,13 @@ func (c *Client) removeFromGC(gcID zkidentity.ShortID, uid UserID,
 	if err != nil {
 		return nil, rpc.RMGroupList{}, err
 	}
+	c.syncGCsToDevices()
 
 	return oldMembers, gc, nil
 }
 
-// GCKick kicks the given user from the GC. This only works if we're the gc
-// admin.
+// GCKick kicks the given user from the GC. This only works if we're a gc
+// admin or a moderator and the user does not have a role in the GC.
 func (c *Client) GCKick(gcID zkidentity.ShortID, uid UserID, reason string) error {
 	oldMembers, gc, err := c.removeFromGC(gcID, uid, true)
 	if err != nil {
@@ -850,10 +1116,30 @@ func (c *Client) handleGCKick(ru *RemoteUser, rmgk rpc.RMGroupKick) error {
 			return err
 		}
 
-		// Ensure we received this from the existing admin.
-		if len(gc.Members) == 0 || gc.Members[0] != ru.ID() {
-			return fmt.Errorf("received gc kick %q from non-admin",
-				gc.ID.String())
+		// Ensure the new list was produced by an user allowed to
+		// kick.
+		canKick := func(uid UserID) bool {
+			return gc.CanKick(uid, rmgk.Member)
+		}
+		err = c.verifyGCListSig(ru, &gc, &rmgk.NewGroupList, canKick)
+		if err != nil {
+			return err
+		}
+
+		// Ensure the kick only removes the kicked member. Admins may
+		// send lists with any changes, so a list with a newer
+		// generation signed by an admin is accepted even if the local
+		// list is out of sync.
+		signer := rmgk.NewGroupList.Signer
+		if signer.IsEmpty() {
+			signer = ru.ID()
+		}
+		newer := rmgk.NewGroupList.Generation > gc.Generation
+		if !newer || !gc.IsAdmin(signer) {
+			err = verifyGCKickList(&gc, &rmgk.NewGroupList, rmgk.Member)
+			if err != nil {
+				return err
+			}
 		}
 
 		// Ensure no backtrack on generation.
@@ -862,6 +1148,9 @@ func (c *Client) handleGCKick(ru *RemoteUser, rmgk rpc.RMGroupKick) error {
 				"generation (%d < %d)", gc.ID.String(), rmgk.NewGroupList.Generation,
 				gc.Generation)
 		}
+		if err := c.verifyGCAdmins(&gc, &rmgk.NewGroupList); err != nil {
+			return err
+		}
 
 		// If we were kicked, remove gc from DB.
 		if meKicked {
@@ -882,9 +1171,16 @@ func (c *Client) handleGCKick(ru *RemoteUser, rmgk rpc.RMGroupKick) error {
 		}
 		return nil
 	})
+	if errors.Is(err, errUnknownGCSigner) {
+		c.queueGCListUntilKX(ru, &rmgk.NewGroupList, err, true, func() error {
+			return c.handleGCKick(ru, rmgk)
+		})
+		return nil
+	}
 	if err != nil {
 		return err
 	}
+	c.syncGCsToDevices()
 
 	us := UserID(rmgk.Member).String()
 	if ru, err := c.rul.byID(rmgk.Member); err == nil {
@@ -917,9 +1213,9 @@ func (c *Client) PartFromGC(gcID zkidentity.ShortID, reason string) error {
 			return err
 		}
 
-		// Ensure we're not leaving if we're admin.
-		if len(gc.Members) == 0 || gc.Members[0] == c.PublicID() {
-			return fmt.Errorf("cannot part from GC when we're the GC admin")
+		// Ensure we're not leaving if we're the owner.
+		if len(gc.Members) == 0 || gc.IsOwner(c.PublicID()) {
+			return fmt.Errorf("cannot part from GC when we're the GC owner")
 		}
 
 		return nil
@@ -944,6 +1240,7 @@ func (c *Client) PartFromGC(gcID zkidentity.ShortID, reason string) error {
 	if err != nil {
 		return err
 	}
+	c.syncGCsToDevices()
 
 	// Send GroupPart msg to all members.
 	rmgp := rpc.RMGroupPart{
@@ -955,10 +1252,13 @@ func (c *Client) PartFromGC(gcID zkidentity.ShortID, reason string) error {
 }
 
 func (c *Client) handleGCPart(ru *RemoteUser, rmgp rpc.RMGroupPart) error {
-	_, _, err := c.removeFromGC(rmgp.ID, ru.ID(), false)
+	_, gc, err := c.removeFromGC(rmgp.ID, ru.ID(), false)
 	if err != nil {
 		return err
 	}
+	if gc.IsOwner(c.PublicID()) {
+		c.sendToGCMembers(gc.ID, gc.Members, "sendlist", gc, nil)
+	}
 
 	c.log.Infof("User %s parting from GC %q. Reason: %q", ru, rmgp.ID.String(),
 		rmgp.Reason)
@@ -982,8 +1282,8 @@ func (c *Client) KillGroupChat(gcID zkidentity.ShortID, reason string) error {
 			return err
 		}
 
-		if len(gc.Members) == 0 || gc.Members[0] != c.PublicID() {
-			return fmt.Errorf("cannot kill GC: not an admin of gc %q",
+		if !gc.IsOwner(c.PublicID()) {
+			return fmt.Errorf("cannot kill GC: not the owner of gc %q",
 				gcID.String())
 		}
 
@@ -1003,6 +1303,7 @@ func (c *Client) KillGroupChat(gcID zkidentity.ShortID, reason string) error {
 	if err != nil {
 		return err
 	}
+	c.syncGCsToDevices()
 
 	c.log.Infof("Killed GC %s. Reason: %q", gcID.String(), reason)
 
@@ -1025,9 +1326,9 @@ func (c *Client) handleGCKill(ru *RemoteUser, rmgk rpc.RMGroupKill) error {
 			return err
 		}
 
-		// Ensure we received this from the existing admin.
-		if len(gc.Members) == 0 || gc.Members[0] != ru.ID() {
-			return fmt.Errorf("received gc kill %q from non-admin",
+		// Ensure we received this from the owner.
+		if !gc.IsOwner(ru.ID()) {
+			return fmt.Errorf("received gc kill %q from non-owner",
 				gc.ID.String())
 		}
 		if err := c.db.DeleteGC(tx, gc.ID); err != nil {
@@ -1043,6 +1344,7 @@ func (c *Client) handleGCKill(ru *RemoteUser, rmgk rpc.RMGroupKill) error {
 	if err != nil {
 		return err
 	}
+	c.syncGCsToDevices()
 
 	c.log.Infof("User %s killed GC %q. Reason: %q", ru, rmgk.ID.String(), rmgk.Reason)
 
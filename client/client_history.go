@@ -0,0 +1,34 @@
+package client
+
+import (
+	"github.com/companyzero/bisonrelay/client/clientdb"
+	"github.com/companyzero/bisonrelay/zkidentity"
+)
+
+// ReadPMHistory returns up to limit messages of the PM history with the given
+// user. Only messages with an id lower than before are returned (a before of
+// zero returns the most recent messages), which allows paging back through the
+// history by passing the id of the oldest message of the previous call.
+//
+// Messages are sorted from oldest to newest.
+func (c *Client) ReadPMHistory(uid UserID, before uint64, limit int) ([]clientdb.HistoryEntry, error) {
+	var res []clientdb.HistoryEntry
+	err := c.dbView(func(tx clientdb.ReadTx) error {
+		var err error
+		res, err = c.db.ReadPMHistory(tx, uid, before, limit)
+		return err
+	})
+	return res, err
+}
+
+// ReadGCHistory returns up to limit messages of the history of the given GC.
+// Pagination works the same as in ReadPMHistory.
+func (c *Client) ReadGCHistory(gcID zkidentity.ShortID, before uint64, limit int) ([]clientdb.HistoryEntry, error) {
+	var res []clientdb.HistoryEntry
+	err := c.dbView(func(tx clientdb.ReadTx) error {
+		var err error
+		res, err = c.db.ReadGCHistory(tx, gcID, before, limit)
+		return err
+	})
+	return res, err
+}
@@ -77,6 +77,15 @@ func (c *Client) takePostKXAction(ru *RemoteUser, act clientdb.PostKXAction) err
 		}
 
 		return c.GetUserPost(ru.ID(), pid, true)
+
+	case clientdb.PKXActionInviteGC:
+		// Invite the user that redeemed a GC join link.
+		var gcID zkidentity.ShortID
+		if err := gcID.FromString(act.Data); err != nil {
+			return err
+		}
+		return c.InviteToGroupChat(gcID, ru.ID())
+
 	default:
 		return fmt.Errorf("unknown post-kx action type")
 	}
@@ -117,6 +126,7 @@ func (c *Client) initRemoteUser(id *zkidentity.PublicIdentity, r *ratchet.Ratche
 	ru.log = c.cfg.logger(fmt.Sprintf("RUSR %x", id.Identity[:8]))
 	ru.logPayloads = c.cfg.logger(fmt.Sprintf("RMPL %x", id.Identity[:8]))
 	ru.rmHandler = c.handleUserRM
+	ru.rawRMHandler = c.forwardRMToDevices
 
 	oldRU, err := c.rul.add(ru)
 	oldUser := false
@@ -159,9 +169,17 @@ func (c *Client) initRemoteUser(id *zkidentity.PublicIdentity, r *ratchet.Ratche
 
 			// Log in the user chat that kx completed.
 			if oldEntry == nil {
-				c.db.LogPM(tx, id.Identity, true, "", "Completed KX", time.Now())
+				c.db.LogPM(tx, id.Identity, clientdb.HistoryEntry{
+					Timestamp: time.Now(),
+					Internal:  true,
+					Message:   "Completed KX",
+				})
 			} else {
-				c.db.LogPM(tx, id.Identity, true, "", "Re-done KX", time.Now())
+				c.db.LogPM(tx, id.Identity, clientdb.HistoryEntry{
+					Timestamp: time.Now(),
+					Internal:  true,
+					Message:   "Re-done KX",
+				})
 			}
 		}
 
@@ -228,14 +246,28 @@ func (c *Client) kxCompleted(public *zkidentity.PublicIdentity, r *ratchet.Ratch
 	if err != nil && !errors.Is(err, clientintf.ErrSubsysExiting) {
 		c.log.Errorf("unable to init user for completed kx: %v", err)
 	}
+	if err == nil {
+		c.forwardToDevices(deviceMsg{
+			Type:      deviceMsgUser,
+			Timestamp: time.Now(),
+			Identity:  public,
+		}, nil)
+	}
 
 	if c.cfg.KXCompleted != nil {
 		c.cfg.KXCompleted(ru)
 	}
+
+	if err == nil {
+		c.handleGCListsAwaitingKX(ru.ID())
+	}
 }
 
 // WriteNewInvite creates a new invite and writes it to the given writer.
 func (c *Client) WriteNewInvite(w io.Writer) (rpc.OOBPublicIdentityInvite, error) {
+	if c.linkedDevice {
+		return rpc.OOBPublicIdentityInvite{}, errLinkedDevice
+	}
 	return c.kxl.createInvite(w, nil, nil, false)
 }
 
@@ -248,11 +280,17 @@ func (c *Client) ReadInvite(r io.Reader) (rpc.OOBPublicIdentityInvite, error) {
 // AcceptInvite blocks until the remote party reponds with us accepting the
 // remote party's invitation. The invite should've been created by ReadInvite.
 func (c *Client) AcceptInvite(invite rpc.OOBPublicIdentityInvite) error {
+	if c.linkedDevice {
+		return errLinkedDevice
+	}
 	return c.kxl.acceptInvite(invite, false)
 }
 
 // ResetRatchet requests a ratchet reset with the given user.
 func (c *Client) ResetRatchet(uid UserID) error {
+	if c.linkedDevice {
+		return errLinkedDevice
+	}
 	ru, err := c.rul.byID(uid)
 	if err != nil {
 		return err
@@ -0,0 +1,241 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/companyzero/bisonrelay/client/clientdb"
+	"github.com/companyzero/bisonrelay/rpc"
+	"github.com/companyzero/bisonrelay/zkidentity"
+)
+
+// updateMsgHistory edits (or retracts, if retract is true) the message with
+// the given id sent by from. The message is looked up in the history of the
+// GC when gcID is filled or in the PM history with uid otherwise.
+func (c *Client) updateMsgHistory(tx clientdb.ReadWriteTx, uid UserID,
+	gcID zkidentity.ShortID, id MsgID, from UserID, msg string,
+	ts time.Time, retract bool) error {
+
+	switch {
+	case gcID.IsEmpty() && retract:
+		return c.db.RetractPMHistory(tx, uid, id, from)
+	case gcID.IsEmpty():
+		return c.db.EditPMHistory(tx, uid, id, from, msg, ts)
+	case retract:
+		return c.db.RetractGCHistory(tx, gcID, id, from)
+	default:
+		return c.db.EditGCHistory(tx, gcID, id, from, msg, ts)
+	}
+}
+
+// sendMsgChange applies a change (edit, retraction, reaction, etc) made by the
+// local client to the message with the given id, either exchanged with the
+// user uid or sent on the GC gcID. The local history is changed by
+// changeHistory and then rm is sent to the user or to the GC members.
+//
+// The message may not be in the local history (for example, when the
+// conversation has disappearing messages), so rm is sent even if
+// changeHistory returns clientdb.ErrNotFound.
+func (c *Client) sendMsgChange(uid UserID, gcID zkidentity.ShortID, id MsgID,
+	payEvent string, rm interface{},
+	changeHistory func(tx clientdb.ReadWriteTx) error) error {
+
+	if c.linkedDevice {
+		return errLinkedDevice
+	}
+	if id.IsEmpty() {
+		return errEmptyMsgID
+	}
+
+	// Ensure the target of the message exists before modifying the local
+	// history.
+	var members []UserID
+	if gcID.IsEmpty() {
+		if _, err := c.rul.byID(uid); err != nil {
+			return err
+		}
+	} else {
+		err := c.dbView(func(tx clientdb.ReadTx) error {
+			gc, err := c.db.GetGC(tx, gcID)
+			if err != nil {
+				return err
+			}
+			gcBlockList, err := c.db.GetGCBlockList(tx, gcID)
+			if err != nil {
+				return err
+			}
+			members = gcBlockList.FilterMembers(gc.Members)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	err := c.dbUpdate(changeHistory)
+	if err != nil && !errors.Is(err, clientdb.ErrNotFound) {
+		return err
+	}
+
+	if gcID.IsEmpty() {
+		return c.sendWithSendQ(payEvent, rm, uid)
+	}
+	c.sendToGCMembers(gcID, members, payEvent, rm, nil)
+	return nil
+}
+
+// sendMsgUpdate edits (or retracts, if retract is true) a message previously
+// sent by the local client, either to the user uid or to the GC gcID.
+func (c *Client) sendMsgUpdate(uid UserID, gcID zkidentity.ShortID, id MsgID,
+	msg string, retract bool) error {
+
+	var rm interface{}
+	var payEvent string
+	now := time.Now()
+	devMsg := deviceMsg{
+		Timestamp: now,
+		UID:       uid,
+		GCID:      gcID,
+		MsgID:     id,
+	}
+	if retract {
+		rm = rpc.RMMessageRetract{GC: gcID, ID: id}
+		payEvent = "messageretract"
+		devMsg.Type = deviceMsgSentRetract
+	} else {
+		rm = rpc.RMMessageEdit{GC: gcID, ID: id, Message: msg}
+		payEvent = "messageedit"
+		devMsg.Type = deviceMsgSentEdit
+		devMsg.Message = msg
+	}
+
+	err := c.sendMsgChange(uid, gcID, id, payEvent, rm, func(tx clientdb.ReadWriteTx) error {
+		return c.updateMsgHistory(tx, uid, gcID, id, c.PublicID(), msg,
+			now, retract)
+	})
+	if err != nil {
+		return err
+	}
+	c.forwardToDevices(devMsg, nil)
+	return nil
+}
+
+// EditPM replaces the contents of the PM with the given id previously sent to
+// the user.
+func (c *Client) EditPM(uid UserID, id MsgID, msg string) error {
+	return c.sendMsgUpdate(uid, zkidentity.ShortID{}, id, msg, false)
+}
+
+// RetractPM asks the user to remove the PM with the given id previously sent
+// to them. The contents of the message are also removed from the local
+// history.
+func (c *Client) RetractPM(uid UserID, id MsgID) error {
+	return c.sendMsgUpdate(uid, zkidentity.ShortID{}, id, "", true)
+}
+
+// EditGCMessage replaces the contents of the message with the given id
+// previously sent to the GC.
+func (c *Client) EditGCMessage(gcID zkidentity.ShortID, id MsgID, msg string) error {
+	return c.sendMsgUpdate(UserID{}, gcID, id, msg, false)
+}
+
+// RetractGCMessage asks the GC members to remove the message with the given id
+// previously sent to the GC. The contents of the message are also removed from
+// the local history.
+func (c *Client) RetractGCMessage(gcID zkidentity.ShortID, id MsgID) error {
+	return c.sendMsgUpdate(UserID{}, gcID, id, "", true)
+}
+
+// isUnblockedGCMember returns true if uid is a member of the GC that was not
+// blocked by the local client in the GC. Returns false if the GC does not
+// exist.
+func (c *Client) isUnblockedGCMember(tx clientdb.ReadTx, gcID zkidentity.ShortID,
+	uid UserID) (bool, error) {
+
+	gc, err := c.db.GetGC(tx, gcID)
+	if errors.Is(err, clientdb.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	gcBlockList, err := c.db.GetGCBlockList(tx, gcID)
+	if err != nil {
+		return false, err
+	}
+	return containsUserID(gc.Members, uid) && !gcBlockList.IsBlocked(uid), nil
+}
+
+// handleMsgUpdate applies an edit or retraction of a message sent by the
+// remote user. It returns false if the update should be ignored.
+func (c *Client) handleMsgUpdate(ru *RemoteUser, gcID zkidentity.ShortID,
+	id MsgID, msg string, ts time.Time, retract bool) (bool, error) {
+
+	if id.IsEmpty() {
+		return false, errEmptyMsgID
+	}
+	if ru.IsIgnored() {
+		ru.log.Tracef("Ignoring received message update")
+		return false, nil
+	}
+
+	var ignore bool
+	err := c.dbUpdate(func(tx clientdb.ReadWriteTx) error {
+		if !gcID.IsEmpty() {
+			// Ensure the remote user is a member of the GC and
+			// not blocked in it.
+			isMember, err := c.isUnblockedGCMember(tx, gcID, ru.ID())
+			if err != nil {
+				return err
+			}
+			if !isMember {
+				ignore = true
+				return nil
+			}
+		}
+
+		return c.updateMsgHistory(tx, ru.ID(), gcID, id, ru.ID(), msg, ts,
+			retract)
+	})
+	if errors.Is(err, clientdb.ErrNotFound) {
+		// The message was not stored, but the UI may still be
+		// displaying it.
+		err = nil
+	}
+	if errors.Is(err, clientdb.ErrNotMsgSender) {
+		return false, fmt.Errorf("remote user attempted to update "+
+			"message %s sent by another user", id)
+	}
+	return err == nil && !ignore, err
+}
+
+// handleMessageEdit handles an edit of a message previously sent by the remote
+// user.
+func (c *Client) handleMessageEdit(ru *RemoteUser, me rpc.RMMessageEdit, ts time.Time) error {
+	ok, err := c.handleMsgUpdate(ru, me.GC, me.ID, me.Message, ts, false)
+	if !ok {
+		return err
+	}
+
+	ru.log.Debugf("Remote user edited message %s", me.ID)
+	if c.cfg.MessageEditHandler != nil {
+		c.cfg.MessageEditHandler(ru, me, ts)
+	}
+	return nil
+}
+
+// handleMessageRetract handles the retraction of a message previously sent by
+// the remote user.
+func (c *Client) handleMessageRetract(ru *RemoteUser, mr rpc.RMMessageRetract, ts time.Time) error {
+	ok, err := c.handleMsgUpdate(ru, mr.GC, mr.ID, "", ts, true)
+	if !ok {
+		return err
+	}
+
+	ru.log.Debugf("Remote user retracted message %s", mr.ID)
+	if c.cfg.MessageRetractHandler != nil {
+		c.cfg.MessageRetractHandler(ru, mr, ts)
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package client
+
+import (
+	"time"
+
+	"github.com/companyzero/bisonrelay/client/clientdb"
+	"github.com/companyzero/bisonrelay/rpc"
+	"github.com/companyzero/bisonrelay/zkidentity"
+)
+
+// NotificationType is the type of event that generated a notification.
+type NotificationType string
+
+const (
+	// NotificationTypePM is the notification of a received PM.
+	NotificationTypePM NotificationType = "pm"
+
+	// NotificationTypeGCMessage is the notification of a received GC
+	// message that does not mention the local user.
+	NotificationTypeGCMessage NotificationType = "gcmessage"
+
+	// NotificationTypeGCMention is the notification of a received GC
+	// message that mentions the local user.
+	NotificationTypeGCMention NotificationType = "gcmention"
+)
+
+// Notification is an event that should be brought to the attention of the
+// local user.
+type Notification struct {
+	Type      NotificationType
+	From      UserID
+	FromNick  string
+	GC        zkidentity.ShortID
+	MsgID     MsgID
+	Message   string
+	Timestamp time.Time
+}
+
+// notifyPM generates the notification of a PM received from the remote user.
+func (c *Client) notifyPM(ru *RemoteUser, pm *rpc.RMPrivateMessage, ts time.Time) {
+	if c.cfg.NotificationHandler == nil {
+		return
+	}
+	c.cfg.NotificationHandler(Notification{
+		Type:      NotificationTypePM,
+		From:      ru.ID(),
+		FromNick:  ru.Nick(),
+		MsgID:     pm.ID,
+		Message:   pm.Message,
+		Timestamp: ts,
+	})
+}
+
+// notifyGCMessage generates the notification of a GC message received from the
+// remote user, if the notification policy of the GC allows it.
+func (c *Client) notifyGCMessage(ru *RemoteUser, gcm *rpc.RMGroupMessage, ts time.Time) {
+	if c.cfg.NotificationHandler == nil {
+		return
+	}
+
+	var mode clientdb.GCNotifyMode
+	err := c.dbView(func(tx clientdb.ReadTx) error {
+		var err error
+		mode, err = c.db.GetGCNotifyMode(tx, gcm.ID)
+		return err
+	})
+	if err != nil {
+		c.log.Warnf("Unable to read notify mode of GC %s: %v", gcm.ID, err)
+		return
+	}
+
+	mentioned := gcm.IsMentioned(c.PublicID())
+	if !mode.ShouldNotify(mentioned) {
+		return
+	}
+	typ := NotificationTypeGCMessage
+	if mentioned {
+		typ = NotificationTypeGCMention
+	}
+	c.cfg.NotificationHandler(Notification{
+		Type:      typ,
+		From:      ru.ID(),
+		FromNick:  ru.Nick(),
+		GC:        gcm.ID,
+		MsgID:     gcm.MsgID,
+		Message:   gcm.Message,
+		Timestamp: ts,
+	})
+}
+
+// SetGCNotifyMode sets the notification policy for the messages received in
+// the given GC.
+func (c *Client) SetGCNotifyMode(gcID zkidentity.ShortID, mode clientdb.GCNotifyMode) error {
+	err := c.dbUpdate(func(tx clientdb.ReadWriteTx) error {
+		return c.db.SetGCNotifyMode(tx, gcID, mode)
+	})
+	if err != nil {
+		return err
+	}
+	c.log.Infof("Set notify mode of GC %s to %s", gcID, mode)
+	return nil
+}
+
+// GetGCNotifyMode returns the notification policy for the messages received
+// in the given GC.
+func (c *Client) GetGCNotifyMode(gcID zkidentity.ShortID) (clientdb.GCNotifyMode, error) {
+	var mode clientdb.GCNotifyMode
+	err := c.dbView(func(tx clientdb.ReadTx) error {
+		if _, err := c.db.GetGC(tx, gcID); err != nil {
+			return err
+		}
+		var err error
+		mode, err = c.db.GetGCNotifyMode(tx, gcID)
+		return err
+	})
+	return mode, err
+}
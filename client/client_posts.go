@@ -595,6 +595,8 @@ func (c *Client) addStatusToPost(statusFrom clientintf.UserID, pms *rpc.PostMeta
 		statusType = "comment"
 	} else if _, ok := attr[rpc.RMPSHeart]; ok {
 		statusType = "heart"
+	} else if _, ok := attr[rpc.RMPSReaction]; ok {
+		statusType = "reaction"
 	}
 	c.log.Infof("New %s %x from %s on post %s", statusType, pms.Hash(), fromStr, pid)
 
@@ -628,12 +630,19 @@ func (c *Client) sendPostStatus(postFrom clientintf.UserID,
 	// Status is coming from the local client.
 	statusFrom := c.PublicID()
 
-	attr[rpc.RMPVersion] = strconv.Itoa(rpc.PostMetadataStatusVersion)
+	// Reactions require a newer version, so that they are signed. Other
+	// statuses keep the older version, which older clients accept.
+	version := rpc.PostMetadataStatusVersion
+	if _, ok := attr[rpc.RMPSReaction]; ok {
+		version = rpc.PostMetadataStatusReactionVersion
+	}
+
+	attr[rpc.RMPVersion] = strconv.Itoa(version)
 	attr[rpc.RMPIdentifier] = pid.String()
 	attr[rpc.RMPStatusFrom] = statusFrom.String()
 	attr[rpc.RMPNonce] = strconv.FormatUint(c.mustRandomUint64(), 16)
 	pms := rpc.PostMetadataStatus{
-		Version:    rpc.PostMetadataStatusVersion,
+		Version:    uint64(version),
 		From:       statusFrom.String(),
 		Link:       pid.String(),
 		Attributes: attr,
@@ -708,6 +717,48 @@ func (c *Client) CommentPost(postFrom clientintf.UserID, pid clientintf.PostID,
 	return c.sendPostStatus(postFrom, pid, attr)
 }
 
+// ReactToPost sends a reaction status update on the received post. If remove
+// is true, a previous reaction is removed.
+func (c *Client) ReactToPost(postFrom clientintf.UserID, pid clientintf.PostID,
+	reaction string, remove bool) error {
+
+	if !rpc.IsValidReaction(reaction) {
+		return errInvalidReaction
+	}
+
+	// Check against the status updates already relayed by the author, to
+	// avoid sending a status update that would be rejected.
+	var reacted bool
+	err := c.dbView(func(tx clientdb.ReadTx) error {
+		reactions, err := c.db.ListPostReactions(tx, postFrom, pid)
+		reacted = reactions.HasReacted(c.PublicID(), reaction)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if reacted != remove {
+		return clientdb.ErrDuplicateReaction
+	}
+
+	attr := map[string]string{
+		rpc.RMPSReaction: rpc.PostReactionValue(reaction, remove),
+	}
+	return c.sendPostStatus(postFrom, pid, attr)
+}
+
+// ListPostReactions returns the reactions to the specified post, aggregated
+// from its status updates.
+func (c *Client) ListPostReactions(from UserID, pid clientintf.PostID) (clientdb.Reactions, error) {
+	var res clientdb.Reactions
+	err := c.dbView(func(tx clientdb.ReadTx) error {
+		var err error
+		res, err = c.db.ListPostReactions(tx, from, pid)
+		return err
+	})
+	return res, err
+}
+
 func (c *Client) handlePostStatus(ru *RemoteUser, rmps rpc.RMPostStatus) error {
 	ru.log.Infof("Received status update on post %q", rmps.Link)
 
@@ -0,0 +1,122 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/companyzero/bisonrelay/client/clientdb"
+	"github.com/companyzero/bisonrelay/rpc"
+	"github.com/companyzero/bisonrelay/zkidentity"
+)
+
+// reactToMsgHistory adds (or removes, if remove is true) the reaction of from
+// to the message with the given id. The message is looked up in the history of
+// the GC when gcID is filled or in the PM history with uid otherwise.
+func (c *Client) reactToMsgHistory(tx clientdb.ReadWriteTx, uid UserID,
+	gcID zkidentity.ShortID, id MsgID, from UserID, reaction string,
+	remove bool) (clientdb.Reactions, error) {
+
+	if gcID.IsEmpty() {
+		return c.db.ReactToPMHistory(tx, uid, id, from, reaction, remove)
+	}
+	return c.db.ReactToGCHistory(tx, gcID, id, from, reaction, remove)
+}
+
+// sendMsgReaction adds (or removes, if remove is true) a reaction of the local
+// client to a message, either exchanged with the user uid or sent on the GC
+// gcID.
+func (c *Client) sendMsgReaction(uid UserID, gcID zkidentity.ShortID, id MsgID,
+	reaction string, remove bool) error {
+
+	if !rpc.IsValidReaction(reaction) {
+		return errInvalidReaction
+	}
+
+	rm := rpc.RMMessageReaction{
+		GC:       gcID,
+		ID:       id,
+		Reaction: reaction,
+		Remove:   remove,
+	}
+	err := c.sendMsgChange(uid, gcID, id, "messagereaction", rm, func(tx clientdb.ReadWriteTx) error {
+		_, err := c.reactToMsgHistory(tx, uid, gcID, id, c.PublicID(),
+			reaction, remove)
+		return err
+	})
+	if errors.Is(err, clientdb.ErrDuplicateReaction) {
+		if remove {
+			return fmt.Errorf("reaction %q to message %s not found",
+				reaction, id)
+		}
+		return fmt.Errorf("already reacted with %q to message %s",
+			reaction, id)
+	}
+	return err
+}
+
+// ReactToPM adds (or removes, if remove is true) a reaction to the PM with the
+// given id exchanged with the user.
+func (c *Client) ReactToPM(uid UserID, id MsgID, reaction string, remove bool) error {
+	return c.sendMsgReaction(uid, zkidentity.ShortID{}, id, reaction, remove)
+}
+
+// ReactToGCMessage adds (or removes, if remove is true) a reaction to the
+// message with the given id sent on the GC.
+func (c *Client) ReactToGCMessage(gcID zkidentity.ShortID, id MsgID, reaction string, remove bool) error {
+	return c.sendMsgReaction(UserID{}, gcID, id, reaction, remove)
+}
+
+// handleMessageReaction handles a reaction of the remote user to a PM or GC
+// message.
+func (c *Client) handleMessageReaction(ru *RemoteUser, mr rpc.RMMessageReaction, ts time.Time) error {
+	if mr.ID.IsEmpty() {
+		return errEmptyMsgID
+	}
+	if !rpc.IsValidReaction(mr.Reaction) {
+		return errInvalidReaction
+	}
+	if ru.IsIgnored() {
+		ru.log.Tracef("Ignoring received message reaction")
+		return nil
+	}
+
+	var ignore bool
+	var reactions clientdb.Reactions
+	err := c.dbUpdate(func(tx clientdb.ReadWriteTx) error {
+		if !mr.GC.IsEmpty() {
+			isMember, err := c.isUnblockedGCMember(tx, mr.GC, ru.ID())
+			if err != nil {
+				return err
+			}
+			if !isMember {
+				ignore = true
+				return nil
+			}
+		}
+
+		var err error
+		reactions, err = c.reactToMsgHistory(tx, ru.ID(), mr.GC, mr.ID,
+			ru.ID(), mr.Reaction, mr.Remove)
+		return err
+	})
+	switch {
+	case errors.Is(err, clientdb.ErrDuplicateReaction):
+		ru.log.Debugf("Ignoring duplicate reaction to message %s", mr.ID)
+		return nil
+	case errors.Is(err, clientdb.ErrNotFound):
+		// The message was not stored, but the UI may still be
+		// displaying it.
+	case err != nil:
+		return err
+	case ignore:
+		return nil
+	}
+
+	ru.log.Debugf("Remote user reacted with %q to message %s (remove %v)",
+		mr.Reaction, mr.ID, mr.Remove)
+	if c.cfg.MessageReactionHandler != nil {
+		c.cfg.MessageReactionHandler(ru, mr, reactions, ts)
+	}
+	return nil
+}
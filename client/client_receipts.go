@@ -0,0 +1,123 @@
+package client
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/companyzero/bisonrelay/client/clientdb"
+	"github.com/companyzero/bisonrelay/rpc"
+	"github.com/companyzero/bisonrelay/zkidentity"
+)
+
+// sendReceipt sends a receipt with the given status for the PM with the
+// specified id to the remote user. The receipt is not sent if the message has
+// no id (i.e. it was sent by an older client) or if the local client opted out
+// of sending receipts to the user.
+func (c *Client) sendReceipt(ru *RemoteUser, id MsgID, status rpc.ReceiptStatus) error {
+	if id.IsEmpty() {
+		return nil
+	}
+
+	var send bool
+	err := c.dbView(func(tx clientdb.ReadTx) error {
+		var err error
+		send, err = c.db.UserReceipts(tx, ru.ID())
+		return err
+	})
+	if err != nil || !send {
+		return err
+	}
+
+	rm := rpc.RMReceipt{
+		ID:        id,
+		Status:    status,
+		Timestamp: time.Now().Unix(),
+	}
+	return c.sendWithSendQ("receipt", rm, ru.ID())
+}
+
+// MarkPMRead sends a read receipt for the PM with the given id received from
+// the specified user. This should be called once the message is displayed to
+// the local user. If the messages exchanged with the user disappear after
+// being read, the message is also removed from the history.
+func (c *Client) MarkPMRead(uid UserID, id MsgID) error {
+	if id.IsEmpty() {
+		return nil
+	}
+	if c.linkedDevice {
+		return c.sendToPrimary(deviceMsg{
+			Type:      deviceMsgRelayReceipt,
+			Timestamp: time.Now(),
+			UID:       uid,
+			MsgID:     id,
+		})
+	}
+
+	ru, err := c.rul.byID(uid)
+	if err != nil {
+		return err
+	}
+	err = c.dbUpdate(func(tx clientdb.ReadWriteTx) error {
+		return c.db.RemoveReadPM(tx, uid, id, uid)
+	})
+	if err != nil {
+		return err
+	}
+	return c.sendReceipt(ru, id, rpc.ReceiptStatusRead)
+}
+
+// MarkGCMsgRead marks the message with the given id of the GC as read. If the
+// messages of the GC disappear after being read, the message and every message
+// logged before it are removed from the history. This should be called once
+// the message is displayed to the local user.
+func (c *Client) MarkGCMsgRead(gcID zkidentity.ShortID, id MsgID) error {
+	return c.dbUpdate(func(tx clientdb.ReadWriteTx) error {
+		return c.db.RemoveReadGCMsgs(tx, gcID, id)
+	})
+}
+
+// SetPMReceipts sets whether delivery and read receipts of PMs are sent to the
+// given user.
+func (c *Client) SetPMReceipts(uid UserID, send bool) error {
+	if _, err := c.rul.byID(uid); err != nil {
+		return err
+	}
+	return c.dbUpdate(func(tx clientdb.ReadWriteTx) error {
+		return c.db.SetUserReceipts(tx, uid, send)
+	})
+}
+
+// PMReceipts returns whether delivery and read receipts of PMs are sent to the
+// given user.
+func (c *Client) PMReceipts(uid UserID) (bool, error) {
+	var send bool
+	err := c.dbView(func(tx clientdb.ReadTx) error {
+		var err error
+		send, err = c.db.UserReceipts(tx, uid)
+		return err
+	})
+	return send, err
+}
+
+// handleReceipt handles a receipt for a PM sent by the local client.
+func (c *Client) handleReceipt(ru *RemoteUser, r rpc.RMReceipt) error {
+	switch r.Status {
+	case rpc.ReceiptStatusDelivered, rpc.ReceiptStatusRead:
+	default:
+		return fmt.Errorf("unknown receipt status %q", r.Status)
+	}
+
+	ru.log.Debugf("Received %s receipt for PM %s", r.Status, r.ID)
+	if r.Status == rpc.ReceiptStatusRead {
+		err := c.dbUpdate(func(tx clientdb.ReadWriteTx) error {
+			return c.db.RemoveReadPM(tx, ru.ID(), r.ID, c.PublicID())
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if c.cfg.PMReceiptHandler != nil {
+		c.cfg.PMReceiptHandler(ru, r)
+	}
+	return nil
+}
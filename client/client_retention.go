@@ -0,0 +1,212 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/companyzero/bisonrelay/client/clientdb"
+	"github.com/companyzero/bisonrelay/client/clientintf"
+	"github.com/companyzero/bisonrelay/rpc"
+	"github.com/companyzero/bisonrelay/zkidentity"
+)
+
+// retentionJanitorInterval is the interval between runs of the janitor that
+// removes messages that should no longer be kept.
+const retentionJanitorInterval = time.Hour
+
+// pruneExpiredMessages enforces the retention policy of every conversation.
+func (c *Client) pruneExpiredMessages() error {
+	return c.dbUpdate(func(tx clientdb.ReadWriteTx) error {
+		return c.db.PruneExpiredMessages(tx, time.Now())
+	})
+}
+
+// runRetentionJanitor periodically removes the messages that should no longer
+// be kept according to the retention policies of users and GCs.
+func (c *Client) runRetentionJanitor(ctx context.Context) error {
+	for {
+		if err := c.pruneExpiredMessages(); err != nil {
+			c.log.Errorf("Unable to prune expired messages: %v", err)
+		}
+
+		select {
+		case <-time.After(retentionJanitorInterval):
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// SetPMRetention sets the retention policy of the messages exchanged with the
+// given user and asks the user to honor the same policy. The policy applies to
+// the existing messages as well.
+func (c *Client) SetPMRetention(uid UserID, policy rpc.RetentionPolicy) error {
+	if c.linkedDevice {
+		return errLinkedDevice
+	}
+	if !policy.IsValid() {
+		return errInvalidRetention
+	}
+	ru, err := c.rul.byID(uid)
+	if err != nil {
+		return err
+	}
+
+	err = c.dbUpdate(func(tx clientdb.ReadWriteTx) error {
+		if err := c.db.SetUserRetention(tx, uid, policy, time.Time{}); err != nil {
+			return err
+		}
+		return c.db.PruneUserMessages(tx, uid, time.Now())
+	})
+	if err != nil {
+		return err
+	}
+
+	ru.log.Infof("Changed retention policy to %s", policy)
+	rm := rpc.RMRetentionPolicy{Policy: policy}
+	return c.sendWithSendQ("retentionpolicy", rm, uid)
+}
+
+// PMRetention returns the retention policy of the messages exchanged with the
+// given user.
+func (c *Client) PMRetention(uid UserID) (rpc.RetentionPolicy, error) {
+	var policy rpc.RetentionPolicy
+	err := c.dbView(func(tx clientdb.ReadTx) error {
+		var err error
+		policy, err = c.db.GetUserRetention(tx, uid)
+		return err
+	})
+	return policy, err
+}
+
+// SetGCRetention sets the retention policy of the messages of the given GC and
+// asks the GC members to honor the same policy. The local client must be the
+// GC admin. The policy applies to the existing messages as well.
+func (c *Client) SetGCRetention(gcID zkidentity.ShortID, policy rpc.RetentionPolicy) error {
+	if !policy.IsValid() {
+		return errInvalidRetention
+	}
+
+	var gc rpc.RMGroupList
+	err := c.dbUpdate(func(tx clientdb.ReadWriteTx) error {
+		var err error
+		gc, err = c.db.GetGC(tx, gcID)
+		if err != nil {
+			return err
+		}
+		if !gc.IsAdmin(c.PublicID()) {
+			return fmt.Errorf("cannot set gc retention: not an admin of gc %q",
+				gcID.String())
+		}
+		if err := c.db.SetGCRetention(tx, gcID, policy, time.Time{}); err != nil {
+			return err
+		}
+		return c.db.PruneGCMessages(tx, gcID, time.Now())
+	})
+	if err != nil {
+		return err
+	}
+
+	c.log.Infof("Changed retention policy of GC %s (%q) to %s", gcID,
+		gc.Name, policy)
+	rm := rpc.RMRetentionPolicy{GC: gcID, Policy: policy}
+	c.sendToGCMembers(gcID, gc.Members, "retentionpolicy", rm, nil)
+	return nil
+}
+
+// sendGCListToNewMember sends the updated list of the GC to every member
+// after uid joined it. If the GC does not keep messages forever or has
+// metadata, the retention policy and metadata are also sent to the new member,
+// after the list was sent so that the GC already exists when they are
+// received.
+func (c *Client) sendGCListToNewMember(gc rpc.RMGroupList, uid clientintf.UserID) {
+	var policy rpc.RetentionPolicy
+	var md rpc.RMGroupMetadata
+	err := c.dbView(func(tx clientdb.ReadTx) error {
+		var err error
+		policy, err = c.db.GetGCRetention(tx, gc.ID)
+		if err != nil {
+			return err
+		}
+		md, err = c.db.GetGCMetadata(tx, gc.ID)
+		return err
+	})
+	if err != nil {
+		c.log.Errorf("Unable to read retention policy and metadata of "+
+			"GC %s: %v", gc.ID, err)
+	}
+	if policy.Mode == rpc.RetentionModeForever && md.Generation == 0 {
+		c.sendToGCMembers(gc.ID, gc.Members, "sendlist", gc, nil)
+		return
+	}
+
+	others := make([]clientintf.UserID, 0, len(gc.Members))
+	for _, member := range gc.Members {
+		if member != uid {
+			others = append(others, member)
+		}
+	}
+	c.sendToGCMembers(gc.ID, others, "sendlist", gc, nil)
+	progressChan := make(chan SendProgress, 1)
+	c.sendToGCMembers(gc.ID, []clientintf.UserID{uid}, "sendlist", gc, progressChan)
+	go func() {
+		select {
+		case <-progressChan:
+		case <-c.ctx.Done():
+			return
+		}
+		newMember := []clientintf.UserID{uid}
+		if policy.Mode != rpc.RetentionModeForever {
+			rm := rpc.RMRetentionPolicy{GC: gc.ID, Policy: policy}
+			c.sendToGCMembers(gc.ID, newMember, "retentionpolicy", rm, nil)
+		}
+		if md.Generation > 0 {
+			c.sendToGCMembers(gc.ID, newMember, "metadata", md, nil)
+		}
+	}()
+}
+
+// handleRetentionPolicy handles a request from a remote user to honor a
+// retention policy. The policy only applies to the messages logged after it
+// was sent at ts: a remote user cannot remove the existing local history.
+func (c *Client) handleRetentionPolicy(ru *RemoteUser, rp rpc.RMRetentionPolicy,
+	ts time.Time) error {
+	if !rp.Policy.IsValid() {
+		return fmt.Errorf("%w: %s", errInvalidRetention, rp.Policy)
+	}
+
+	if rp.GC.IsEmpty() {
+		err := c.dbUpdate(func(tx clientdb.ReadWriteTx) error {
+			return c.db.SetUserRetention(tx, ru.ID(), rp.Policy, ts)
+		})
+		if err != nil {
+			return err
+		}
+		ru.log.Infof("Remote user changed retention policy to %s", rp.Policy)
+	} else {
+		err := c.dbUpdate(func(tx clientdb.ReadWriteTx) error {
+			gc, err := c.db.GetGC(tx, rp.GC)
+			if err != nil {
+				return err
+			}
+
+			// Ensure we received this from an admin.
+			if !gc.IsAdmin(ru.ID()) {
+				return fmt.Errorf("received gc retention policy %q "+
+					"from non-admin", rp.GC.String())
+			}
+			return c.db.SetGCRetention(tx, rp.GC, rp.Policy, ts)
+		})
+		if err != nil {
+			return err
+		}
+		ru.log.Infof("Changed retention policy of GC %s to %s", rp.GC,
+			rp.Policy)
+	}
+
+	if c.cfg.RetentionPolicyChanged != nil {
+		c.cfg.RetentionPolicyChanged(ru, rp.GC, rp.Policy)
+	}
+	return nil
+}
@@ -111,15 +111,32 @@ func (c *Client) innerHandleUserRM(ru *RemoteUser, h *rpc.RMHeader,
 		}
 
 		err := c.dbUpdate(func(tx clientdb.ReadWriteTx) error {
-			return c.db.LogPM(tx, ru.ID(), false, ru.Nick(), p.Message, ts)
+			return c.db.LogPM(tx, ru.ID(), clientdb.HistoryEntry{
+				Timestamp: ts,
+				From:      ru.Nick(),
+				FromUID:   ru.ID(),
+				Mode:      rpc.MessageMode(p.Mode),
+				Message:   p.Message,
+				MsgID:     p.ID,
+				ReplyTo:   p.ReplyTo,
+			})
 		})
 		if err != nil {
 			return err
 		}
 		ru.log.Debugf("Received private message of length %d", len(p.Message))
+		if !c.linkedDevice && !p.ID.IsEmpty() {
+			go func() {
+				err := c.sendReceipt(ru, p.ID, rpc.ReceiptStatusDelivered)
+				if err != nil {
+					ru.log.Warnf("Unable to send delivery receipt: %v", err)
+				}
+			}()
+		}
 		if c.cfg.PMHandler != nil {
 			c.cfg.PMHandler(ru, p, ts)
 		}
+		c.notifyPM(ru, &p, ts)
 
 	case rpc.RMGroupInvite:
 		return c.handleGCInvite(ru, p)
@@ -127,9 +144,24 @@ func (c *Client) innerHandleUserRM(ru *RemoteUser, h *rpc.RMHeader,
 	case rpc.RMGroupJoin:
 		return c.handleGCJoin(ru, p)
 
+	case rpc.RMGroupJoinRejected:
+		return c.handleGCJoinRejected(ru, p)
+
 	case rpc.RMGroupList:
 		return c.handleGCList(ru, p)
 
+	case rpc.RMGroupMetadata:
+		return c.handleGCMetadata(ru, p)
+
+	case rpc.RMGroupUpdate:
+		return c.handleGCUpdate(ru, p)
+
+	case rpc.RMGroupUpdateRequest:
+		return c.handleGCUpdateRequest(ru, p)
+
+	case rpc.RMGroupSenderKey:
+		return c.handleGCSenderKey(ru, p)
+
 	case rpc.RMGroupMessage:
 		if ru.IsIgnored() {
 			ru.log.Tracef("Ignoring received GC message")
@@ -170,6 +202,15 @@ func (c *Client) innerHandleUserRM(ru *RemoteUser, h *rpc.RMHeader,
 	case rpc.RMFTSendFile:
 		return c.handleFTSendFile(ru, p)
 
+	case rpc.RMFTFindSources:
+		return c.handleFTFindSources(ru, p)
+
+	case rpc.RMFTFindSourcesReply:
+		return c.handleFTFindSourcesReply(ru, p)
+
+	case rpc.RMFTSharedFolder:
+		return c.handleFTSharedFolder(ru, p)
+
 	case rpc.RMTransitiveMessage:
 		return c.handleTransitiveMsg(ru, p)
 
@@ -230,6 +271,21 @@ func (c *Client) innerHandleUserRM(ru *RemoteUser, h *rpc.RMHeader,
 	case rpc.RMKXSuggestion:
 		return c.handleKXSuggestion(ru, p)
 
+	case rpc.RMRetentionPolicy:
+		return c.handleRetentionPolicy(ru, p, ts)
+
+	case rpc.RMReceipt:
+		return c.handleReceipt(ru, p)
+
+	case rpc.RMMessageEdit:
+		return c.handleMessageEdit(ru, p, ts)
+
+	case rpc.RMMessageRetract:
+		return c.handleMessageRetract(ru, p, ts)
+
+	case rpc.RMMessageReaction:
+		return c.handleMessageReaction(ru, p, ts)
+
 	default:
 		return fmt.Errorf("Received unknown command %q payload %T",
 			h.Command, p)
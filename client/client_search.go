@@ -0,0 +1,18 @@
+package client
+
+import (
+	"github.com/companyzero/bisonrelay/client/clientdb"
+)
+
+// Search searches the local PM, GC and post history for messages that contain
+// every term of the query and that match the given filters. Results are
+// returned from newest to oldest.
+func (c *Client) Search(query string, filters clientdb.SearchFilters) ([]clientdb.SearchResult, error) {
+	var res []clientdb.SearchResult
+	err := c.dbView(func(tx clientdb.ReadTx) error {
+		var err error
+		res, err = c.db.Search(tx, query, filters)
+		return err
+	})
+	return res, err
+}
@@ -0,0 +1,695 @@
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/companyzero/bisonrelay/client/clientdb"
+	"github.com/companyzero/bisonrelay/client/clientintf"
+	"github.com/companyzero/bisonrelay/rpc"
+	"github.com/companyzero/bisonrelay/zkidentity"
+	"golang.org/x/exp/slices"
+)
+
+// The shared folders flow is:
+//
+//          Alice                                    Bob
+//         -------                                  -----
+//   ShareFolder()
+//   syncSharedFolder()
+//   (files are shared with Bob)
+//         \--------- RMFTSharedFolder -->
+//
+//                                              handleFTSharedFolder()
+//                                              AcceptSharedFolder()
+//                                              (files are downloaded with
+//                                              the fetch content flow)
+//
+// Alice's client periodically scans the folder for changes and sends an
+// updated manifest whenever any of its files change.
+
+// sharedFolderFileInfo is the info about a file found while scanning a local
+// shared folder.
+type sharedFolderFileInfo struct {
+	absPath string
+	size    uint64
+	modTime time.Time
+}
+
+// scanSharedFolderDir returns the regular files inside the given dir, keyed by
+// their slash-separated path relative to the dir.
+func scanSharedFolderDir(dir string) (map[string]sharedFolderFileInfo, error) {
+	res := make(map[string]sharedFolderFileInfo)
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() || strings.HasSuffix(p, ".brsync") {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		res[filepath.ToSlash(rel)] = sharedFolderFileInfo{
+			absPath: p,
+			size:    uint64(info.Size()),
+			modTime: info.ModTime(),
+		}
+		return nil
+	})
+	return res, err
+}
+
+// sharedFolderTargets returns the users the files of the given shared folder
+// should be shared with.
+func (c *Client) sharedFolderTargets(tx clientdb.ReadTx, sf *clientdb.SharedFolder) ([]UserID, error) {
+	if sf.UID != nil {
+		return []UserID{*sf.UID}, nil
+	}
+	if sf.GC == nil {
+		return nil, fmt.Errorf("shared folder %s has no target", sf.ID)
+	}
+	gc, err := c.db.GetGC(tx, *sf.GC)
+	if err != nil {
+		return nil, err
+	}
+	myID := c.PublicID()
+	res := make([]UserID, 0, len(gc.Members))
+	for _, uid := range gc.Members {
+		if uid == myID {
+			continue
+		}
+		if _, err := c.rul.byID(uid); err != nil {
+			// Not KX'd with this member.
+			continue
+		}
+		res = append(res, uid)
+	}
+	return res, nil
+}
+
+// sharedFolderManifest returns the manifest of the given shared folder.
+func sharedFolderManifest(sf *clientdb.SharedFolder) rpc.RMFTSharedFolder {
+	files := make([]rpc.SharedFolderFile, len(sf.Files))
+	for i, f := range sf.Files {
+		files[i] = rpc.SharedFolderFile{
+			Path:   f.Path,
+			FileID: f.FID.String(),
+			Hash:   f.Hash,
+			Size:   f.Size,
+			Cost:   f.Cost,
+		}
+	}
+	return rpc.RMFTSharedFolder{
+		ID:      sf.ID,
+		Name:    sf.Name,
+		Version: sf.Version,
+		GC:      sf.GC,
+		Files:   files,
+	}
+}
+
+// unshareFolderFile unshares the given file of a shared folder from the given
+// users.
+func (c *Client) unshareFolderFile(tx clientdb.ReadWriteTx, fid clientdb.FileID, uids []UserID) {
+	for i := range uids {
+		err := c.db.UnshareFile(tx, fid, &uids[i])
+		if err != nil && !errors.Is(err, clientdb.ErrNotFound) {
+			c.log.Warnf("Unable to unshare file %s from user %s: %v",
+				fid, uids[i], err)
+		}
+	}
+}
+
+// syncSharedFolder scans the given local shared folder for changes, updating
+// the shared files and sending the updated manifest of the folder to the
+// users it is shared with.
+func (c *Client) syncSharedFolder(id zkidentity.ShortID) error {
+	sign := func(hash []byte) ([]byte, error) {
+		sig := c.id.SignMessage(hash)
+		return sig[:], nil
+	}
+
+	var sf clientdb.SharedFolder
+	var changed bool
+	var removedTargets []UserID
+	err := c.dbUpdate(func(tx clientdb.ReadWriteTx) error {
+		var err error
+		sf, err = c.db.GetSharedFolder(tx, id)
+		if err != nil {
+			return err
+		}
+		targets, err := c.sharedFolderTargets(tx, &sf)
+		if err != nil {
+			return err
+		}
+		found, err := scanSharedFolderDir(sf.Path)
+		if err != nil {
+			return err
+		}
+
+		var newTargets []UserID
+		for _, uid := range targets {
+			if !slices.Contains(sf.Shares, uid) {
+				newTargets = append(newTargets, uid)
+			}
+		}
+		for _, uid := range sf.Shares {
+			if !slices.Contains(targets, uid) {
+				removedTargets = append(removedTargets, uid)
+			}
+		}
+		changed = len(newTargets) > 0 || len(removedTargets) > 0
+
+		// Unshare the files that were changed or removed first, given
+		// that shared files are keyed by their name.
+		var kept []clientdb.SharedFolderFile
+		keptPaths := make(map[string]struct{}, len(sf.Files))
+		for _, f := range sf.Files {
+			info, ok := found[f.Path]
+			if ok && info.size == f.Size && info.modTime.Equal(f.ModTime) {
+				c.unshareFolderFile(tx, f.FID, removedTargets)
+				kept = append(kept, f)
+				keptPaths[f.Path] = struct{}{}
+				continue
+			}
+			c.unshareFolderFile(tx, f.FID, sf.Shares)
+			changed = true
+		}
+
+		// Share the kept files with the new users.
+		files := make([]clientdb.SharedFolderFile, 0, len(found))
+		for _, f := range kept {
+			ok := true
+			for i := range newTargets {
+				_, _, err := c.db.ShareFile(tx, found[f.Path].absPath,
+					&newTargets[i], sf.Cost, "", sign)
+				if err != nil {
+					c.log.Warnf("Unable to share file %q of folder "+
+						"%s: %v", f.Path, sf.ID, err)
+					ok = false
+					break
+				}
+			}
+			if ok {
+				files = append(files, f)
+			}
+		}
+
+		// Share the new and changed files.
+		paths := make([]string, 0, len(found))
+		for p := range found {
+			if _, ok := keptPaths[p]; !ok {
+				paths = append(paths, p)
+			}
+		}
+		sort.Strings(paths)
+		for _, p := range paths {
+			info := found[p]
+			var md rpc.FileMetadata
+			var err error
+			for i := range targets {
+				_, md, err = c.db.ShareFile(tx, info.absPath,
+					&targets[i], sf.Cost, "", sign)
+				if err != nil {
+					break
+				}
+			}
+			if err != nil {
+				c.log.Warnf("Unable to share file %q of folder %s: %v",
+					p, sf.ID, err)
+				continue
+			}
+			if len(targets) == 0 {
+				// Only shared once there are users to share
+				// it with.
+				continue
+			}
+			files = append(files, clientdb.SharedFolderFile{
+				Path:    p,
+				FID:     md.MetadataHash(),
+				Hash:    md.Hash,
+				Size:    md.Size,
+				Cost:    md.Cost,
+				ModTime: info.modTime,
+			})
+			changed = true
+		}
+		sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+
+		if !changed {
+			return nil
+		}
+		sf.Files = files
+		sf.Shares = targets
+		sf.Version++
+		sf.Updated = time.Now()
+		return c.db.SaveSharedFolder(tx, &sf)
+	})
+	if err != nil || !changed {
+		return err
+	}
+
+	c.log.Infof("Shared folder %q (%s) updated to version %d with %d files",
+		sf.Name, sf.ID, sf.Version, len(sf.Files))
+	payEvent := fmt.Sprintf("ftsharedfolder.%s", sf.ID.ShortLogID())
+	if len(sf.Shares) > 0 {
+		err := c.sendWithSendQ(payEvent, sharedFolderManifest(&sf), sf.Shares...)
+		if err != nil {
+			return err
+		}
+	}
+	if len(removedTargets) > 0 {
+		rm := rpc.RMFTSharedFolder{ID: sf.ID, Name: sf.Name,
+			Version: sf.Version, GC: sf.GC, Removed: true}
+		return c.sendWithSendQ(payEvent, rm, removedTargets...)
+	}
+	return nil
+}
+
+// ShareFolder shares the files of the given local dir with either the given
+// user or the members of the given GC. The cost (in atoms) applies to each
+// file of the folder. The folder is periodically scanned for changes, which
+// are sent to the users it is shared with.
+func (c *Client) ShareFolder(dir string, uid *UserID, gcID *zkidentity.ShortID,
+	cost uint64) (clientdb.SharedFolder, error) {
+
+	var sf clientdb.SharedFolder
+	if (uid == nil) == (gcID == nil) {
+		return sf, fmt.Errorf("folder must be shared with either a user or a GC")
+	}
+
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return sf, err
+	}
+	if fi, err := os.Stat(dir); err != nil {
+		return sf, err
+	} else if !fi.IsDir() {
+		return sf, fmt.Errorf("%s is not a dir", dir)
+	}
+	if uid != nil {
+		if _, err := c.rul.byID(*uid); err != nil {
+			return sf, err
+		}
+	}
+
+	sf = clientdb.SharedFolder{
+		Name:    filepath.Base(dir),
+		Path:    dir,
+		UID:     uid,
+		GC:      gcID,
+		Cost:    cost,
+		Created: time.Now(),
+		Updated: time.Now(),
+	}
+	if _, err := rand.Read(sf.ID[:]); err != nil {
+		return sf, err
+	}
+	err = c.dbUpdate(func(tx clientdb.ReadWriteTx) error {
+		if gcID != nil {
+			if _, err := c.db.GetGC(tx, *gcID); err != nil {
+				return err
+			}
+		}
+		return c.db.SaveSharedFolder(tx, &sf)
+	})
+	if err != nil {
+		return sf, err
+	}
+	c.log.Infof("Sharing folder %q as %s", dir, sf.ID)
+
+	if err := c.syncSharedFolder(sf.ID); err != nil {
+		return sf, err
+	}
+	return c.GetSharedFolder(sf.ID)
+}
+
+// SyncSharedFolder scans the given local shared folder for changes, without
+// waiting for the next periodic scan.
+func (c *Client) SyncSharedFolder(id zkidentity.ShortID) error {
+	return c.syncSharedFolder(id)
+}
+
+// UnshareFolder stops sharing the given local folder. The users the folder was
+// shared with are notified, but keep the files they already downloaded.
+func (c *Client) UnshareFolder(id zkidentity.ShortID) error {
+	var sf clientdb.SharedFolder
+	err := c.dbUpdate(func(tx clientdb.ReadWriteTx) error {
+		var err error
+		sf, err = c.db.GetSharedFolder(tx, id)
+		if err != nil {
+			return err
+		}
+		for _, f := range sf.Files {
+			c.unshareFolderFile(tx, f.FID, sf.Shares)
+		}
+		return c.db.RemoveSharedFolder(tx, id)
+	})
+	if err != nil {
+		return err
+	}
+	c.log.Infof("Stopped sharing folder %q (%s)", sf.Name, sf.ID)
+
+	if len(sf.Shares) == 0 {
+		return nil
+	}
+	rm := rpc.RMFTSharedFolder{ID: sf.ID, Name: sf.Name,
+		Version: sf.Version + 1, GC: sf.GC, Removed: true}
+	payEvent := fmt.Sprintf("ftsharedfolder.%s", sf.ID.ShortLogID())
+	return c.sendWithSendQ(payEvent, rm, sf.Shares...)
+}
+
+// GetSharedFolder returns the local shared folder with the given id.
+func (c *Client) GetSharedFolder(id zkidentity.ShortID) (clientdb.SharedFolder, error) {
+	var sf clientdb.SharedFolder
+	err := c.dbView(func(tx clientdb.ReadTx) error {
+		var err error
+		sf, err = c.db.GetSharedFolder(tx, id)
+		return err
+	})
+	return sf, err
+}
+
+// ListSharedFolders lists the local folders shared with remote users.
+func (c *Client) ListSharedFolders() ([]clientdb.SharedFolder, error) {
+	var res []clientdb.SharedFolder
+	err := c.dbView(func(tx clientdb.ReadTx) error {
+		var err error
+		res, err = c.db.ListSharedFolders(tx)
+		return err
+	})
+	return res, err
+}
+
+// ListSubscribedFolders lists the folders shared by remote users with the
+// local client.
+func (c *Client) ListSubscribedFolders() ([]clientdb.SubscribedFolder, error) {
+	var res []clientdb.SubscribedFolder
+	err := c.dbView(func(tx clientdb.ReadTx) error {
+		var err error
+		res, err = c.db.ListSubscribedFolders(tx)
+		return err
+	})
+	return res, err
+}
+
+// AcceptSharedFolder starts syncing the given folder shared by the remote user
+// to the given local dir. New and changed files of the folder are then
+// downloaded automatically (without confirmation), paying for their chunks as
+// usual.
+func (c *Client) AcceptSharedFolder(owner UserID, id zkidentity.ShortID, localDir string) error {
+	ru, err := c.rul.byID(owner)
+	if err != nil {
+		return err
+	}
+	localDir, err = filepath.Abs(localDir)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(localDir, 0o700); err != nil {
+		return err
+	}
+
+	err = c.dbUpdate(func(tx clientdb.ReadWriteTx) error {
+		sf, err := c.db.GetSubscribedFolder(tx, owner, id)
+		if err != nil {
+			return err
+		}
+		if sf.Removed {
+			return fmt.Errorf("folder %s is no longer shared", id)
+		}
+		sf.LocalPath = localDir
+		return c.db.SaveSubscribedFolder(tx, &sf)
+	})
+	if err != nil {
+		return err
+	}
+	ru.log.Infof("Syncing shared folder %s to %q", id, localDir)
+
+	return c.syncSubscribedFolder(ru, id)
+}
+
+// RemoveSubscribedFolder stops syncing the given folder shared by the remote
+// user. Files already synced are kept. The folder is listed again (but not
+// synced) if the remote user sends an updated manifest.
+func (c *Client) RemoveSubscribedFolder(owner UserID, id zkidentity.ShortID) error {
+	return c.dbUpdate(func(tx clientdb.ReadWriteTx) error {
+		return c.db.RemoveSubscribedFolder(tx, owner, id)
+	})
+}
+
+// completedDownloadPath returns the path of the completed download of the
+// given file, if it has been downloaded.
+func (c *Client) completedDownloadPath(tx clientdb.ReadTx, fid clientdb.FileID) (string, bool) {
+	fd, err := c.db.GetFileDownload(tx, fid)
+	if err != nil || fd.CompletedName == "" {
+		return "", false
+	}
+	ru, err := c.rul.byID(fd.UID)
+	if err != nil {
+		return "", false
+	}
+	diskPath, err := c.db.CompletedFileDownloadPath(tx, ru.Nick(), fid)
+	if err != nil {
+		return "", false
+	}
+	if _, err := os.Stat(diskPath); err != nil {
+		return "", false
+	}
+	return diskPath, true
+}
+
+// syncSubscribedFolder writes the already downloaded files of the given folder
+// to its local path and starts the download of the pending ones.
+func (c *Client) syncSubscribedFolder(ru *RemoteUser, id zkidentity.ShortID) error {
+	var toDownload []clientdb.FileID
+	err := c.dbUpdate(func(tx clientdb.ReadWriteTx) error {
+		sf, err := c.db.GetSubscribedFolder(tx, ru.ID(), id)
+		if err != nil {
+			return err
+		}
+		if sf.LocalPath == "" || sf.Removed {
+			return nil
+		}
+
+		for _, f := range sf.PendingFiles() {
+			var fid clientdb.FileID
+			if err := fid.FromString(f.FileID); err != nil {
+				return err
+			}
+			if diskPath, ok := c.completedDownloadPath(tx, fid); ok {
+				err := c.db.WriteSubscribedFolderFile(tx, &sf,
+					f.Path, fid, diskPath)
+				if err != nil {
+					return err
+				}
+				continue
+			}
+			if _, err := c.db.GetFileDownload(tx, fid); err == nil {
+				// Download in progress.
+				continue
+			}
+			if !slices.Contains(toDownload, fid) {
+				toDownload = append(toDownload, fid)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, fid := range toDownload {
+		if err := c.GetUserContent(ru.ID(), fid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// syncSharedFolderDownload writes the completed download of the given file to
+// every synced folder of the remote user that includes the file.
+func (c *Client) syncSharedFolderDownload(ru *RemoteUser, fid clientdb.FileID, diskPath string) {
+	err := c.dbUpdate(func(tx clientdb.ReadWriteTx) error {
+		sfs, err := c.db.ListSubscribedFolders(tx)
+		if err != nil {
+			return err
+		}
+		for _, sf := range sfs {
+			sf := sf
+			if sf.Owner != ru.ID() || sf.LocalPath == "" || sf.Removed {
+				continue
+			}
+			for _, f := range sf.PendingFiles() {
+				if f.FileID != fid.String() {
+					continue
+				}
+				err := c.db.WriteSubscribedFolderFile(tx, &sf, f.Path,
+					fid, diskPath)
+				if err != nil {
+					return err
+				}
+				ru.log.Infof("Synced file %q of shared folder %q",
+					f.Path, sf.Name)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		ru.log.Errorf("Unable to sync downloaded file %s to shared "+
+			"folders: %v", fid, err)
+	}
+}
+
+// isSharedFolderFile returns true if the given file is part of a folder
+// shared by the remote user that is being synced.
+func (c *Client) isSharedFolderFile(uid UserID, fid clientdb.FileID) bool {
+	var res bool
+	_ = c.dbView(func(tx clientdb.ReadTx) error {
+		sfs, err := c.db.ListSubscribedFolders(tx)
+		if err != nil {
+			return err
+		}
+		for _, sf := range sfs {
+			if sf.Owner != uid || sf.LocalPath == "" || sf.Removed {
+				continue
+			}
+			for _, f := range sf.Files {
+				if f.FileID == fid.String() {
+					res = true
+					return nil
+				}
+			}
+		}
+		return nil
+	})
+	return res
+}
+
+// handleFTSharedFolder handles the manifest of a folder shared by the remote
+// user.
+func (c *Client) handleFTSharedFolder(ru *RemoteUser, rm rpc.RMFTSharedFolder) error {
+	for _, f := range rm.Files {
+		if !clientdb.IsValidSharedFolderPath(f.Path) {
+			return fmt.Errorf("invalid path %q in shared folder %s",
+				f.Path, rm.ID)
+		}
+		var fid clientdb.FileID
+		if err := fid.FromString(f.FileID); err != nil {
+			return fmt.Errorf("invalid file id in shared folder %s: %v",
+				rm.ID, err)
+		}
+	}
+
+	var sf clientdb.SubscribedFolder
+	var stale bool
+	err := c.dbUpdate(func(tx clientdb.ReadWriteTx) error {
+		var err error
+		sf, err = c.db.GetSubscribedFolder(tx, ru.ID(), rm.ID)
+		if errors.Is(err, clientdb.ErrNotFound) {
+			sf = clientdb.SubscribedFolder{ID: rm.ID, Owner: ru.ID()}
+		} else if err != nil {
+			return err
+		} else if rm.Version <= sf.Version {
+			stale = true
+			return nil
+		}
+
+		sf.Name = rm.Name
+		sf.GC = rm.GC
+		sf.Version = rm.Version
+		sf.Removed = rm.Removed
+		if !rm.Removed {
+			sf.Files = rm.Files
+		}
+		sf.Updated = time.Now()
+		return c.db.SaveSubscribedFolder(tx, &sf)
+	})
+	if err != nil {
+		return err
+	}
+	if stale {
+		ru.log.Debugf("Ignoring stale manifest of shared folder %s "+
+			"(version %d)", rm.ID, rm.Version)
+		return nil
+	}
+
+	if rm.Removed {
+		ru.log.Infof("Folder %q (%s) no longer shared", sf.Name, sf.ID)
+	} else {
+		ru.log.Infof("Received manifest of shared folder %q (%s) version "+
+			"%d with %d files", sf.Name, sf.ID, sf.Version, len(sf.Files))
+	}
+	if c.cfg.SharedFolderUpdated != nil {
+		c.cfg.SharedFolderUpdated(ru, sf)
+	}
+
+	if sf.LocalPath == "" || sf.Removed {
+		return nil
+	}
+	return c.syncSubscribedFolder(ru, sf.ID)
+}
+
+// runSharedFoldersJanitor periodically scans the local shared folders for
+// changes and restarts the sync of subscribed folders.
+func (c *Client) runSharedFoldersJanitor(ctx context.Context) error {
+	interval := c.cfg.sharedFoldersScanInterval()
+	for {
+		var sfs []clientdb.SharedFolder
+		var subs []clientdb.SubscribedFolder
+		err := c.dbView(func(tx clientdb.ReadTx) error {
+			var err error
+			sfs, err = c.db.ListSharedFolders(tx)
+			if err != nil {
+				return err
+			}
+			subs, err = c.db.ListSubscribedFolders(tx)
+			return err
+		})
+		if err != nil {
+			c.log.Errorf("Unable to list shared folders: %v", err)
+		}
+
+		for _, sf := range sfs {
+			err := c.syncSharedFolder(sf.ID)
+			if err != nil && !errors.Is(err, clientintf.ErrSubsysExiting) {
+				c.log.Errorf("Unable to sync shared folder %s: %v",
+					sf.ID, err)
+			}
+		}
+		for _, sf := range subs {
+			if sf.LocalPath == "" || sf.Removed {
+				continue
+			}
+			ru, err := c.rul.byID(sf.Owner)
+			if err != nil {
+				continue
+			}
+			err = c.syncSubscribedFolder(ru, sf.ID)
+			if err != nil && !errors.Is(err, clientintf.ErrSubsysExiting) {
+				ru.log.Errorf("Unable to sync subscribed folder "+
+					"%s: %v", sf.ID, err)
+			}
+		}
+
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
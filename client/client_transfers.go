@@ -0,0 +1,174 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/companyzero/bisonrelay/client/clientdb"
+	"github.com/companyzero/bisonrelay/client/clientintf"
+)
+
+// transferRMPriority returns the priority of the RMs of a file transfer, given
+// the base priority of the RMs and the priority of the transfer. RMs of
+// transfers never have a higher priority than GC messages.
+func transferRMPriority(base uint, prio clientdb.TransferPriority) uint {
+	switch {
+	case prio == clientdb.TransferPriorityHigh && base > priorityGC:
+		return base - 1
+	case prio == clientdb.TransferPriorityLow && base < priorityUpload:
+		return base + 1
+	default:
+		return base
+	}
+}
+
+// transferPriority returns the priority of the transfers of the given file.
+func (c *Client) transferPriority(tx clientdb.ReadTx, fid clientdb.FileID) clientdb.TransferPriority {
+	prio, err := c.db.GetTransferPriority(tx, fid)
+	if err != nil {
+		c.log.Warnf("Unable to read priority of transfer %s: %v", fid, err)
+		return clientdb.TransferPriorityNormal
+	}
+	return prio
+}
+
+// readOutstandingDownload reads the download of the given file, ensuring it
+// is not yet completed.
+func (c *Client) readOutstandingDownload(tx clientdb.ReadTx, fid clientdb.FileID) (clientdb.FileDownload, error) {
+	fd, err := c.db.GetFileDownload(tx, fid)
+	if err != nil {
+		return fd, err
+	}
+	if fd.CompletedName != "" {
+		return fd, fmt.Errorf("download of file %s already completed", fid)
+	}
+	return fd, nil
+}
+
+// PauseDownload pauses the download of the given file. Chunks that were
+// already requested may still be received, but no new chunks are requested
+// or paid for until the download is resumed.
+func (c *Client) PauseDownload(fid clientdb.FileID) error {
+	err := c.dbUpdate(func(tx clientdb.ReadWriteTx) error {
+		fd, err := c.readOutstandingDownload(tx, fid)
+		if err != nil {
+			return err
+		}
+		if fd.Paused {
+			return fmt.Errorf("download of file %s already paused", fid)
+		}
+		return c.db.SetFileDownloadPaused(tx, &fd, true)
+	})
+	if err != nil {
+		return err
+	}
+	c.log.Infof("Paused download of file %s", fid)
+	return nil
+}
+
+// ResumeDownload resumes a download previously paused with PauseDownload.
+func (c *Client) ResumeDownload(fid clientdb.FileID) error {
+	var fd clientdb.FileDownload
+	err := c.dbUpdate(func(tx clientdb.ReadWriteTx) error {
+		var err error
+		fd, err = c.readOutstandingDownload(tx, fid)
+		if err != nil {
+			return err
+		}
+		if !fd.Paused {
+			return fmt.Errorf("download of file %s is not paused", fid)
+		}
+		return c.db.SetFileDownloadPaused(tx, &fd, false)
+	})
+	if err != nil {
+		return err
+	}
+	c.log.Infof("Resumed download of file %s", fid)
+
+	// The metadata may not have been received yet, in which case the
+	// download will start once it is.
+	if fd.Metadata == nil {
+		return nil
+	}
+	go func() {
+		err := c.downloadChunks(fd)
+		if err != nil && !errors.Is(err, clientintf.ErrSubsysExiting) {
+			c.log.Errorf("Error downloading chunks of file %s: %v",
+				fd.FID, err)
+		}
+	}()
+	return nil
+}
+
+// CancelDownload cancels the download of the given file, removing all the
+// chunks already downloaded.
+func (c *Client) CancelDownload(fid clientdb.FileID) error {
+	err := c.dbUpdate(func(tx clientdb.ReadWriteTx) error {
+		if _, err := c.readOutstandingDownload(tx, fid); err != nil {
+			return err
+		}
+		return c.db.CancelFileDownload(tx, fid)
+	})
+	if err != nil {
+		return err
+	}
+	c.log.Infof("Canceled download of file %s", fid)
+	return nil
+}
+
+// CancelUpload cancels the upload of the given shared file to the given user.
+// Outstanding invoices for chunks of the file are discarded and further
+// requests for chunks from the user are rejected, until the user requests the
+// file again. Chunks that were already paid for are still sent.
+func (c *Client) CancelUpload(uid UserID, fid clientdb.FileID) error {
+	ru, err := c.rul.byID(uid)
+	if err != nil {
+		return err
+	}
+	err = c.dbUpdate(func(tx clientdb.ReadWriteTx) error {
+		return c.db.CancelFileUpload(tx, uid, fid)
+	})
+	if err != nil {
+		return err
+	}
+	ru.log.Infof("Canceled upload of file %s", fid)
+	return nil
+}
+
+// ListUploads lists the chunks of files that are waiting to be paid for or
+// sent to remote users.
+func (c *Client) ListUploads() ([]clientdb.ChunkUpload, error) {
+	var cups []clientdb.ChunkUpload
+	err := c.dbView(func(tx clientdb.ReadTx) error {
+		var err error
+		cups, err = c.db.ListOutstandingUploads(tx)
+		return err
+	})
+	return cups, err
+}
+
+// SetTransferPriority sets the priority of the transfers of the given file.
+// The file may be either one being downloaded or one that is shared by the
+// local client, in which case the priority applies to its uploads to all
+// users.
+func (c *Client) SetTransferPriority(fid clientdb.FileID, prio clientdb.TransferPriority) error {
+	err := c.dbUpdate(func(tx clientdb.ReadWriteTx) error {
+		return c.db.SetTransferPriority(tx, fid, prio)
+	})
+	if err != nil {
+		return err
+	}
+	c.log.Infof("Set priority of transfers of file %s to %s", fid, prio)
+	return nil
+}
+
+// GetTransferPriority returns the priority of the transfers of the given file.
+func (c *Client) GetTransferPriority(fid clientdb.FileID) (clientdb.TransferPriority, error) {
+	var prio clientdb.TransferPriority
+	err := c.dbView(func(tx clientdb.ReadTx) error {
+		var err error
+		prio, err = c.db.GetTransferPriority(tx, fid)
+		return err
+	})
+	return prio, err
+}
@@ -3,12 +3,12 @@ package clientdb
 import (
 	"context"
 	"crypto/rand"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 
@@ -50,8 +50,27 @@ type Config struct {
 	// no chunking.
 	ChunkSize int
 
+	// ContentDefinedChunking is set to chunk shared files with boundaries
+	// defined by their contents (with ChunkSize as the maximum chunk size)
+	// instead of fixed size chunks, such that new versions of a file share
+	// most of the chunks with previous versions.
+	ContentDefinedChunking bool
+
 	// DownloadsRoot is where to put final downloaded files.
 	DownloadsRoot string
+
+	// Encrypt requests that the DB be encrypted at rest. If the DB is not
+	// yet encrypted, the passphrase passed to UnlockDB() is used to derive
+	// the new encryption key. DBs that are already encrypted always need
+	// to be unlocked, regardless of this setting.
+	Encrypt bool
+
+	// Driver is the storage driver used to persist the DB data. One of
+	// DriverFilesystem (the default) or DriverSQLite.
+	//
+	// When DriverSQLite is used for the first time on an existing DB, the
+	// data of the filesystem driver is imported into the sqlite database.
+	Driver string
 }
 
 type DB struct {
@@ -75,8 +94,27 @@ type DB struct {
 	payStats map[string]UserPayStats
 
 	blockedIDs map[string]time.Time
+
+	// verifiedFiles tracks the completed downloads whose contents were
+	// verified to match their hash, keyed by path.
+	verifiedFiles map[string]verifiedFile
+
+	// key is the key used to encrypt the db data at rest. It is nil when
+	// the db is not encrypted.
+	key      *[32]byte
+	unlocked chan struct{}
+
+	// storage is where the DB data is persisted and stx is the storage
+	// transaction of the currently running View() or Update() call.
+	storage storage
+	stx     storageTx
+
+	// lockFile is held from New() until Run() returns.
+	lockFile *lockfile.LockFile
 }
 
+// New opens the DB. The lock file of the DB is held until Run() returns, so
+// Run() must be called on the returned DB.
 func New(cfg Config) (*DB, error) {
 	root, err := filepath.Abs(cfg.Root)
 	if err != nil {
@@ -105,15 +143,52 @@ func New(cfg Config) (*DB, error) {
 		return nil, err
 	}
 
-	if err := os.MkdirAll(filepath.Join(root, inboundDir), 0o700); err != nil {
-		return nil, err
-	}
 	if cfg.MsgsRoot != "" {
 		if err := os.MkdirAll(filepath.Join(cfg.MsgsRoot), 0o700); err != nil {
 			return nil, err
 		}
 	}
 
+	log := slog.Disabled
+	if cfg.Logger != nil {
+		log = cfg.Logger
+	}
+
+	// Get the lockfile before touching any of the db files. Use a small
+	// timeout so that we error out immediately if another process is
+	// using the db.
+	lfCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+	lockFilePath := filepath.Join(root, lockFileName)
+	lockFile, err := lockfile.Create(lfCtx, lockFilePath)
+	cancel()
+	if err != nil {
+		return nil, fmt.Errorf("%w %q: %v", errCreateLockFile, lockFilePath, err)
+	}
+	db, err := newLockedDB(cfg, root, downloadsDir, log)
+	if err != nil {
+		if err := lockFile.Close(); err != nil {
+			log.Errorf("Unable to close lock file: %v", err)
+		}
+		return nil, err
+	}
+	db.lockFile = lockFile
+	return db, nil
+}
+
+// newLockedDB opens the DB. It must be called with the db lock file held.
+func newLockedDB(cfg Config, root, downloadsDir string, log slog.Logger) (*DB, error) {
+	// Replace the existing data with a backup that was staged to be
+	// restored.
+	restoreFiles, err := readPendingRestore(root)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read backup to restore: %v", err)
+	}
+	if restoreFiles != nil {
+		if err := restoreBackup(cfg, root, log, restoreFiles); err != nil {
+			return nil, fmt.Errorf("unable to restore backup: %v", err)
+		}
+	}
+
 	// Create the idb db.
 	filename := filepath.Join(root, zkcServerDir, zkcServerFile)
 	idb, err := inidb.New(filename, true, 10)
@@ -128,57 +203,120 @@ func New(cfg Config) (*DB, error) {
 		return nil, err
 	}
 
-	log := slog.Disabled
-	if cfg.Logger != nil {
-		log = cfg.Logger
+	importFS := cfg.Driver == DriverSQLite && !fileExists(filepath.Join(root, sqliteDBFile))
+	storage, err := openStorage(cfg.Driver, root)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open db storage: %v", err)
 	}
 
-	blockedIDs := make(map[string]time.Time)
-	b, err := os.ReadFile(filepath.Join(root, blockedUsersFile))
-	if err != nil && !os.IsNotExist(err) {
+	db := &DB{
+		root:          root,
+		downloadsDir:  downloadsDir,
+		log:           log,
+		cfg:           cfg,
+		rnd:           rand.Reader,
+		running:       make(chan struct{}),
+		idb:           idb,
+		invites:       invites,
+		lastMsgTS:     make(map[string]time.Time),
+		blockedIDs:    make(map[string]time.Time),
+		verifiedFiles: make(map[string]verifiedFile),
+		payStats:      make(map[string]UserPayStats),
+		unlocked:      make(chan struct{}),
+		storage:       storage,
+	}
+
+	if importFS {
+		if err := db.importFSStorage(); err != nil {
+			storage.Close()
+			return nil, fmt.Errorf("unable to import fs data into sqlite: %v", err)
+		}
+	}
+	if err := storage.MkdirAll(filepath.Join(root, inboundDir)); err != nil {
+		storage.Close()
+		return nil, err
+	}
+
+	// Encrypted DBs can only be loaded after being unlocked.
+	if cfg.Encrypt || db.IsEncrypted() {
+		return db, nil
+	}
+
+	if err := db.inStorageTx(context.Background(), db.loadSealedData); err != nil {
+		storage.Close()
 		return nil, err
-	} else if err == nil {
-		err = json.Unmarshal(b, &blockedIDs)
+	}
+	close(db.unlocked)
+	return db, nil
+}
+
+// isFSOnlyFile returns true if the given file or dir of the db root is always
+// kept in the filesystem instead of the db storage (inidb files, the lock file,
+// etc).
+func (db *DB) isFSOnlyFile(name string) bool {
+	rel, err := filepath.Rel(db.root, name)
+	if err != nil {
+		return true
+	}
+	switch rel {
+	case lockFileName, zkcServerDir, invitesDir, dbKeyFile, dbKeyTmpFile, restoreFile,
+		restoreTmpDir, restoreOldDir, fsTxJournalDir:
+		return true
+	}
+	return strings.HasPrefix(rel, sqliteDBFile)
+}
+
+// importFSStorage imports the data stored by the filesystem driver into the
+// storage of the db. Files that are not kept in the storage (inidb files, the
+// lock file, etc) are not imported.
+func (db *DB) importFSStorage() error {
+	return db.inStorageTx(context.Background(), func() error {
+		n, err := copyStorage(db.fs(), &fsStorage{root: db.root}, db.root, db.isFSOnlyFile)
 		if err != nil {
-			return nil, err
+			return err
 		}
-	}
+		if n > 0 {
+			db.log.Infof("Imported %d files from the filesystem "+
+				"into the sqlite db", n)
+		}
+		return nil
+	})
+}
 
-	db := &DB{
-		root:         root,
-		downloadsDir: downloadsDir,
-		log:          log,
-		cfg:          cfg,
-		rnd:          rand.Reader,
-		running:      make(chan struct{}),
-		idb:          idb,
-		invites:      invites,
-		lastMsgTS:    make(map[string]time.Time),
-		blockedIDs:   blockedIDs,
-		payStats:     make(map[string]UserPayStats),
+// loadSealedData loads the data that is kept in memory by the DB and that may
+// have been encrypted.
+func (db *DB) loadSealedData() error {
+	// Try to read the blocked users file.
+	err := db.readJsonFile(filepath.Join(db.root, blockedUsersFile), &db.blockedIDs)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("error while loading blocked users file: %v", err)
 	}
 
 	// Try to read the pay stats file.
-	if err := db.readJsonFile(filepath.Join(root, payStatsFile), &db.payStats); err != nil {
+	if err := db.readJsonFile(filepath.Join(db.root, payStatsFile), &db.payStats); err != nil {
 		if !errors.Is(err, ErrNotFound) {
-			return nil, fmt.Errorf("error while loading pay stats file: %v", err)
+			return fmt.Errorf("error while loading pay stats file: %v", err)
 		}
 	}
 
-	return db, nil
+	// Failing to rebuild the search index is not fatal.
+	if err := db.reindexSearch(); err != nil {
+		db.log.Warnf("Unable to rebuild search index: %v", err)
+	}
+
+	return nil
 }
 
 // Run runs the DB. This should not be called twice for the same db.
 func (db *DB) Run(ctx context.Context) error {
-	// Attempt to get the lockfile with a small timeout so that we error
-	// out immediately instead of waiting until the outer context is
-	// canceled.
-	lfCtx, cancel := context.WithTimeout(ctx, time.Second)
-	lockFilePath := filepath.Join(db.root, lockFileName)
-	lockFile, err := lockfile.Create(lfCtx, lockFilePath)
-	cancel()
-	if err != nil {
-		return fmt.Errorf("%w %q: %v", errCreateLockFile, lockFilePath, err)
+	lockFile := db.lockFile
+
+	// Wait until the DB is unlocked before allowing it to be used.
+	select {
+	case <-db.unlocked:
+	case <-ctx.Done():
+		lockFile.Close()
+		return ctx.Err()
 	}
 
 	db.Lock()
@@ -188,6 +326,12 @@ func (db *DB) Run(ctx context.Context) error {
 
 	<-ctx.Done()
 
+	db.Lock()
+	if err := db.storage.Close(); err != nil {
+		db.log.Errorf("Unable to close db storage: %v", err)
+	}
+	db.Unlock()
+
 	if err := lockFile.Close(); err != nil {
 		db.log.Errorf("Unable to close lock file: %v", err)
 	}
@@ -204,7 +348,7 @@ func (db *DB) View(ctx context.Context, f func(tx ReadTx) error) error {
 	db.Lock()
 	ctx, cancel := multiCtx(ctx, db.runCtx)
 	tx := &rtx{ctx: ctx}
-	err := f(tx)
+	err := db.inStorageTx(context.Background(), func() error { return f(tx) })
 	cancel()
 	db.Unlock()
 	return err
@@ -216,7 +360,7 @@ func (db *DB) Update(ctx context.Context, f func(tx ReadWriteTx) error) error {
 	db.Lock()
 	ctx, cancel := multiCtx(ctx, db.runCtx)
 	tx := &wtx{ctx: ctx}
-	err := f(tx)
+	err := db.inStorageTx(context.Background(), func() error { return f(tx) })
 	cancel()
 	db.Unlock()
 	return err
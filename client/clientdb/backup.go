@@ -0,0 +1,503 @@
+package clientdb
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/companyzero/bisonrelay/sw"
+	"github.com/decred/slog"
+)
+
+const (
+	// restoreFile is the file where a backup is staged until it can be
+	// restored on the next start of the db.
+	restoreFile = "restore.pending"
+
+	// restoreTmpDir is where a backup is restored before replacing the
+	// existing data and restoreOldDir is where the existing data is
+	// moved to while it is being replaced.
+	restoreTmpDir = "restore.tmp"
+	restoreOldDir = "restore.old"
+
+	// restoredRatchetsFile lists the users whose ratchets were restored
+	// from a backup and that still need to be reset.
+	restoredRatchetsFile = "restoredratchets.json"
+
+	backupVersion = 1
+)
+
+// backupMagic is the first line of every backup file.
+var backupMagic = []byte("brbackup1\n")
+
+// backupFSFiles are the files (relative to the db root) that are kept in the
+// filesystem instead of the storage and that are part of a backup.
+var backupFSFiles = []string{
+	path.Join(zkcServerDir, zkcServerFile),
+	path.Join(invitesDir, "invites.ini"),
+	dbKeyFile,
+}
+
+// backupHeader is the unencrypted header of a backup file.
+type backupHeader struct {
+	Version int         `json:"version"`
+	Created time.Time   `json:"created"`
+	KDF     dbKeyParams `json:"kdf"`
+}
+
+// backupFile is a single file of a backup archive.
+type backupFile struct {
+	// Name is the slash-separated path of the file relative to the db
+	// root.
+	Name string
+	Data []byte
+}
+
+// backupFileName returns the full name in the db root of the given backup file.
+func backupFileName(root, name string) string {
+	return filepath.Join(root, filepath.FromSlash(name))
+}
+
+// isBackupFSFile returns true if the backup file must be restored to the
+// filesystem instead of to the db storage.
+func isBackupFSFile(name string) bool {
+	for _, fname := range backupFSFiles {
+		if name == fname {
+			return true
+		}
+	}
+	return false
+}
+
+// writeBackupArchive writes the files as a compressed tar archive.
+func writeBackupArchive(w io.Writer, files []backupFile) error {
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+	for _, f := range files {
+		hdr := &tar.Header{
+			Name:     f.Name,
+			Mode:     0o600,
+			Size:     int64(len(f.Data)),
+			Typeflag: tar.TypeReg,
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := tw.Write(f.Data); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+// readBackupArchive reads the files of an archive written with
+// writeBackupArchive.
+func readBackupArchive(b []byte) ([]backupFile, error) {
+	gr, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	tr := tar.NewReader(gr)
+	var files []backupFile
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		// Ensure the file does not escape the db root.
+		name := path.Clean(hdr.Name)
+		if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			return nil, fmt.Errorf("%w: file %q outside db root",
+				errInvalidBackup, hdr.Name)
+		}
+
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, backupFile{Name: name, Data: data})
+	}
+	return files, nil
+}
+
+// walkStorageFiles calls f for every file in the storage dir and its subdirs.
+// Files and dirs for which skip returns true are not visited.
+func walkStorageFiles(ops storageOps, dir string, skip func(string) bool,
+	f func(name string, data []byte) error) error {
+
+	entries, err := ops.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		name := filepath.Join(dir, entry.Name())
+		if skip(name) {
+			continue
+		}
+		if entry.IsDir() {
+			if err := walkStorageFiles(ops, name, skip, f); err != nil {
+				return err
+			}
+			continue
+		}
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		data, err := ops.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		if err := f(name, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ExportBackup writes a backup of the full db state to w. The backup is
+// encrypted with a key derived from the passphrase.
+//
+// Files are stored in the backup as they are stored in the db, therefore if
+// the db is encrypted at rest, the db passphrase will also be needed to unlock
+// the db after the backup is restored.
+func (db *DB) ExportBackup(tx ReadTx, w io.Writer, passphrase []byte) error {
+	if len(passphrase) == 0 {
+		return errEmptyPassphrase
+	}
+
+	var files []backupFile
+	addFile := func(name string, data []byte) error {
+		rel, err := filepath.Rel(db.root, name)
+		if err != nil {
+			return err
+		}
+		files = append(files, backupFile{Name: filepath.ToSlash(rel), Data: data})
+		return nil
+	}
+
+	for _, fname := range backupFSFiles {
+		fname = backupFileName(db.root, fname)
+		data, err := os.ReadFile(fname)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := addFile(fname, data); err != nil {
+			return err
+		}
+	}
+	err := walkStorageFiles(db.fs(), db.root, db.isFSOnlyFile, addFile)
+	if err != nil {
+		return fmt.Errorf("unable to read db files: %v", err)
+	}
+
+	return db.writeBackup(w, files, passphrase)
+}
+
+// writeBackup writes the files as a backup encrypted with a key derived from
+// the passphrase.
+func (db *DB) writeBackup(w io.Writer, files []backupFile, passphrase []byte) error {
+	archive := new(bytes.Buffer)
+	if err := writeBackupArchive(archive, files); err != nil {
+		return fmt.Errorf("unable to create backup archive: %v", err)
+	}
+
+	header := backupHeader{
+		Version: backupVersion,
+		Created: time.Now(),
+		KDF: dbKeyParams{
+			Salt:    make([]byte, 32),
+			Time:    dbKeyArgonTime,
+			Memory:  dbKeyArgonMemory,
+			Threads: dbKeyArgonThreads,
+		},
+	}
+	if _, err := io.ReadFull(db.rnd, header.KDF.Salt); err != nil {
+		return err
+	}
+	sealed, err := sw.Seal(archive.Bytes(), header.KDF.deriveKey(passphrase))
+	if err != nil {
+		return err
+	}
+	headerJson, err := json.Marshal(header)
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(w)
+	bw.Write(backupMagic)
+	bw.Write(headerJson)
+	bw.WriteRune('\n')
+	bw.Write(sealed)
+	return bw.Flush()
+}
+
+// openBackup decrypts the given backup, returning its (decrypted) archive.
+func openBackup(r io.Reader, passphrase []byte) ([]byte, error) {
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(backupMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || !bytes.Equal(magic, backupMagic) {
+		return nil, errNotABackup
+	}
+	headerJson, err := br.ReadBytes('\n')
+	if err != nil {
+		return nil, errNotABackup
+	}
+	var header backupHeader
+	if err := json.Unmarshal(headerJson, &header); err != nil {
+		return nil, fmt.Errorf("unable to decode backup header: %v", err)
+	}
+	if header.Version > backupVersion {
+		return nil, errBackupTooNew
+	}
+
+	sealed, err := io.ReadAll(br)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < sw.MinPackedEncryptedSize {
+		return nil, errWrongBackupPass
+	}
+	archive, ok := sw.Open(sealed, header.KDF.deriveKey(passphrase))
+	if !ok {
+		return nil, errWrongBackupPass
+	}
+	return archive, nil
+}
+
+// ImportBackup decrypts and validates a backup created with ExportBackup and
+// stages it to be restored. The backup replaces all the existing db state the
+// next time the db is opened with New().
+func (db *DB) ImportBackup(r io.Reader, passphrase []byte) error {
+	return db.stageRestore(r, passphrase, nil)
+}
+
+// stageRestore decrypts the backup and stages it to be restored. Besides the
+// validations common to every backup, the files are checked with validate (if
+// set).
+func (db *DB) stageRestore(r io.Reader, passphrase []byte, validate func([]backupFile) error) error {
+	if len(passphrase) == 0 {
+		return errEmptyPassphrase
+	}
+	archive, err := openBackup(r, passphrase)
+	if err != nil {
+		return err
+	}
+	files, err := readBackupArchive(archive)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errInvalidBackup, err)
+	}
+	var hasID bool
+	for _, f := range files {
+		hasID = hasID || f.Name == path.Join(zkcServerDir, zkcServerFile)
+	}
+	if !hasID {
+		return fmt.Errorf("%w: backup does not have a local identity",
+			errInvalidBackup)
+	}
+	if validate != nil {
+		if err := validate(files); err != nil {
+			return err
+		}
+	}
+
+	fname := filepath.Join(db.root, restoreFile)
+	tmpFname := fname + ".tmp"
+	if err := os.WriteFile(tmpFname, archive, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmpFname, fname)
+}
+
+// readPendingRestore returns the files of the backup staged to be restored in
+// the given db root. It returns nil if there is no staged backup.
+func readPendingRestore(root string) ([]backupFile, error) {
+	archive, err := os.ReadFile(filepath.Join(root, restoreFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return readBackupArchive(archive)
+}
+
+// restoreBackup restores the backup files into a temporary dir of the db root
+// and then replaces the existing data of the db root with the restored data.
+// The staged backup is only removed after the existing data is replaced, so
+// that an interrupted restore is done again on the next start of the db.
+//
+// This must be called with the db lock file held and before the db files are
+// opened.
+func restoreBackup(cfg Config, root string, log slog.Logger, files []backupFile) error {
+	tmpDir := filepath.Join(root, restoreTmpDir)
+	oldDir := filepath.Join(root, restoreOldDir)
+	for _, dir := range []string{tmpDir, oldDir} {
+		if err := os.RemoveAll(dir); err != nil {
+			return err
+		}
+	}
+
+	// Restore the files that are kept in the filesystem.
+	for _, f := range files {
+		if !isBackupFSFile(f.Name) {
+			continue
+		}
+		fname := backupFileName(tmpDir, f.Name)
+		if err := os.MkdirAll(filepath.Dir(fname), 0o700); err != nil {
+			return err
+		}
+		if err := os.WriteFile(fname, f.Data, 0o600); err != nil {
+			return err
+		}
+	}
+
+	// Restore the files that are kept in the db storage.
+	storage, err := openStorage(cfg.Driver, tmpDir)
+	if err != nil {
+		return err
+	}
+	tmpDB := &DB{cfg: cfg, log: log, root: tmpDir, storage: storage}
+	err = tmpDB.restoreStorageFiles(files)
+	if closeErr := storage.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	// Replace the existing data with the restored data.
+	if err := os.Mkdir(oldDir, 0o700); err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		switch entry.Name() {
+		case lockFileName, restoreFile, restoreTmpDir, restoreOldDir:
+			continue
+		}
+		err := os.Rename(filepath.Join(root, entry.Name()),
+			filepath.Join(oldDir, entry.Name()))
+		if err != nil {
+			return err
+		}
+	}
+	entries, err = os.ReadDir(tmpDir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		err := os.Rename(filepath.Join(tmpDir, entry.Name()),
+			filepath.Join(root, entry.Name()))
+		if err != nil {
+			return err
+		}
+	}
+	for _, dir := range []string{tmpDir, oldDir} {
+		if err := os.RemoveAll(dir); err != nil {
+			return err
+		}
+	}
+
+	log.Infof("Restored %d files from backup", len(files))
+	return os.Remove(filepath.Join(root, restoreFile))
+}
+
+// restoreStorageFiles writes the backup files that are kept in the db storage.
+// All restored ratchets are flagged to be reset, given that the remote users
+// may have moved their ratchets forward since the backup was made.
+func (db *DB) restoreStorageFiles(files []backupFile) error {
+	var restoredRatchets []UserID
+	err := db.inStorageTx(context.Background(), func() error {
+		for _, f := range files {
+			if isBackupFSFile(f.Name) {
+				continue
+			}
+			fname := backupFileName(db.root, f.Name)
+			if err := db.fs().MkdirAll(filepath.Dir(fname)); err != nil {
+				return err
+			}
+			if err := db.fs().WriteFile(fname, f.Data); err != nil {
+				return err
+			}
+
+			dir, base := path.Split(f.Name)
+			if base != ratchetFilename || path.Dir(path.Dir(dir)) != inboundDir {
+				continue
+			}
+			var uid UserID
+			if err := uid.FromString(path.Base(dir)); err != nil {
+				continue
+			}
+			restoredRatchets = append(restoredRatchets, uid)
+		}
+
+		fname := filepath.Join(db.root, restoredRatchetsFile)
+		return db.saveJsonFile(fname, restoredRatchets)
+	})
+	if err != nil {
+		return err
+	}
+
+	db.log.Infof("Restored %d ratchets to reset from backup",
+		len(restoredRatchets))
+	return nil
+}
+
+// ListRestoredRatchets lists the users whose ratchets were restored from a
+// backup and that have not yet been reset.
+func (db *DB) ListRestoredRatchets(tx ReadTx) ([]UserID, error) {
+	var res []UserID
+	err := db.readJsonFile(filepath.Join(db.root, restoredRatchetsFile), &res)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+	return res, nil
+}
+
+// RemoveRestoredRatchet removes the user from the list of restored ratchets
+// that need to be reset.
+func (db *DB) RemoveRestoredRatchet(tx ReadWriteTx, uid UserID) error {
+	uids, err := db.ListRestoredRatchets(tx)
+	if err != nil {
+		return err
+	}
+	for i := range uids {
+		if uids[i] != uid {
+			continue
+		}
+		uids = append(uids[:i], uids[i+1:]...)
+		fname := filepath.Join(db.root, restoredRatchetsFile)
+		if len(uids) == 0 {
+			return db.removeIfExists(fname)
+		}
+		return db.saveJsonFile(fname, uids)
+	}
+	return nil
+}
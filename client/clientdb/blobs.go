@@ -0,0 +1,450 @@
+package clientdb
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/companyzero/bisonrelay/rpc"
+	"golang.org/x/exp/slices"
+)
+
+// The blob store keeps the data of file chunks keyed by their hash, so that
+// chunks with the same content (for example, of a file that is both shared and
+// downloaded, or of a file shared with multiple names) are only stored once.
+//
+// Every chunk tracks the list of references to it (shared content dirs and
+// in-progress downloads). Chunks without references are removed by
+// DelUnreferencedBlobs.
+//
+// Completed downloads are also indexed by their file hash, so that files with
+// the same content are not downloaded again, and by the hashes of their chunks,
+// so that the chunks shared with other files (for example, new versions of a
+// file chunked with rpc.FileChunkingContentDefined) are not downloaded again.
+
+const (
+	blobsDir         = "blobs"
+	blobChunksDir    = "chunks"
+	blobFilesDir     = "files"
+	blobRefsExt      = ".refs"
+	blobLocationsExt = ".loc"
+)
+
+// blobRefShared is the reference of the chunks of the shared content with the
+// given name.
+func blobRefShared(name string) string {
+	return "shared:" + name
+}
+
+// blobRefDownload is the reference of the chunks of the download of the given
+// file.
+func blobRefDownload(fid FileID) string {
+	return "download:" + fid.String()
+}
+
+func (db *DB) chunkBlobFname(hash []byte) string {
+	hexHash := hex.EncodeToString(hash)
+	return filepath.Join(db.root, blobsDir, blobChunksDir, hexHash[:2], hexHash)
+}
+
+func (db *DB) fileBlobFname(fileHash string) string {
+	return filepath.Join(db.root, blobsDir, blobFilesDir, fileHash+".json")
+}
+
+// hasChunkBlob returns true if the chunk with the given hash is stored.
+func (db *DB) hasChunkBlob(hash []byte) bool {
+	return len(hash) > 0 && db.exists(db.chunkBlobFname(hash))
+}
+
+// readChunkBlob returns the data of the chunk with the given hash.
+func (db *DB) readChunkBlob(hash []byte) ([]byte, error) {
+	data, err := db.readFile(db.chunkBlobFname(hash))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("chunk %x: %w", hash, ErrNotFound)
+	}
+	return data, err
+}
+
+// addChunkBlobRef adds the given reference to the chunk with the given hash.
+func (db *DB) addChunkBlobRef(hash []byte, ref string) error {
+	fname := db.chunkBlobFname(hash) + blobRefsExt
+	var refs []string
+	if err := db.readJsonFile(fname, &refs); err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	if slices.Contains(refs, ref) {
+		return nil
+	}
+	refs = append(refs, ref)
+	return db.saveJsonFile(fname, refs)
+}
+
+// delChunkBlobRef removes the given reference from the chunk with the given
+// hash. The chunk data is kept until DelUnreferencedBlobs is called.
+func (db *DB) delChunkBlobRef(hash []byte, ref string) error {
+	fname := db.chunkBlobFname(hash) + blobRefsExt
+	var refs []string
+	err := db.readJsonFile(fname, &refs)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	i := slices.Index(refs, ref)
+	if i < 0 {
+		return nil
+	}
+	refs = slices.Delete(refs, i, i+1)
+	return db.saveJsonFile(fname, refs)
+}
+
+// putChunkBlob stores the data of the chunk with the given hash (if it is not
+// stored yet) and adds the given reference to it.
+func (db *DB) putChunkBlob(hash, data []byte, ref string) error {
+	if !db.hasChunkBlob(hash) {
+		fname := db.chunkBlobFname(hash)
+		if err := db.fs().MkdirAll(filepath.Dir(fname)); err != nil {
+			return err
+		}
+		if err := db.writeFile(fname, data); err != nil {
+			return fmt.Errorf("unable to write chunk blob: %w", err)
+		}
+	}
+	return db.addChunkBlobRef(hash, ref)
+}
+
+// chunkLocation is the location of a chunk inside a completed download.
+type chunkLocation struct {
+	Path   string `json:"path"`
+	Offset uint64 `json:"offset"`
+	Size   uint64 `json:"size"`
+}
+
+// addChunkLocations records the location of the chunks of the given file,
+// stored at path.
+func (db *DB) addChunkLocations(md *rpc.FileMetadata, path string) error {
+	var offset uint64
+	for _, ch := range md.Manifest {
+		loc := chunkLocation{Path: path, Offset: offset, Size: ch.Size}
+		offset += ch.Size
+
+		fname := db.chunkBlobFname(ch.Hash) + blobLocationsExt
+		var locs []chunkLocation
+		err := db.readJsonFile(fname, &locs)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+		if slices.Contains(locs, loc) {
+			continue
+		}
+		locs = append(locs, loc)
+		if err := db.saveJsonFile(fname, locs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readLocatedChunk reads the chunk with the given hash from one of the
+// completed downloads that contain it. The data is verified against the hash,
+// so files that were modified after being downloaded are skipped.
+func (db *DB) readLocatedChunk(hash []byte) ([]byte, bool) {
+	var locs []chunkLocation
+	err := db.readJsonFile(db.chunkBlobFname(hash)+blobLocationsExt, &locs)
+	if err != nil {
+		return nil, false
+	}
+	for _, loc := range locs {
+		if loc.Size > rpc.MaxChunkSize {
+			continue
+		}
+		f, err := os.Open(loc.Path)
+		if err != nil {
+			continue
+		}
+		data := make([]byte, loc.Size)
+		_, err = f.ReadAt(data, int64(loc.Offset))
+		f.Close()
+		if err != nil {
+			continue
+		}
+		if gotHash := sha256.Sum256(data); bytes.Equal(gotHash[:], hash) {
+			return data, true
+		}
+	}
+	return nil, false
+}
+
+// fileBlob tracks the completed downloads of files with a given hash.
+type fileBlob struct {
+	Hash  string   `json:"hash"`
+	Paths []string `json:"paths"`
+}
+
+// addFileBlobPath records the given path as a completed download of the file
+// with the given hash.
+func (db *DB) addFileBlobPath(fileHash, path string) error {
+	fname := db.fileBlobFname(fileHash)
+	fb := fileBlob{Hash: fileHash}
+	if err := db.readJsonFile(fname, &fb); err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	if slices.Contains(fb.Paths, path) {
+		return nil
+	}
+	fb.Paths = append(fb.Paths, path)
+	return db.saveJsonFile(fname, fb)
+}
+
+// verifiedFile is a version of a file on disk whose contents were verified to
+// match a hash.
+type verifiedFile struct {
+	hash    string
+	size    int64
+	modTime time.Time
+}
+
+// hashFile returns the hex-encoded hash of the contents of the file.
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// fileBlobPath returns the path of an existing completed download of a file
+// with the given hash and size. The contents of the file must match the hash,
+// so that files modified after being downloaded are not reused. Verified files
+// are tracked by their size and modification time, to avoid hashing them
+// every time.
+func (db *DB) fileBlobPath(fileHash string, size uint64) (string, bool) {
+	if fileHash == "" {
+		return "", false
+	}
+	var fb fileBlob
+	if err := db.readJsonFile(db.fileBlobFname(fileHash), &fb); err != nil {
+		return "", false
+	}
+	for _, path := range fb.Paths {
+		fi, err := os.Stat(path)
+		if err != nil || !fi.Mode().IsRegular() || uint64(fi.Size()) != size {
+			continue
+		}
+		vf := verifiedFile{hash: fileHash, size: fi.Size(), modTime: fi.ModTime()}
+		if db.verifiedFiles[path] == vf {
+			return path, true
+		}
+		if hash, err := hashFile(path); err != nil || hash != fileHash {
+			continue
+		}
+		db.verifiedFiles[path] = vf
+		return path, true
+	}
+	return "", false
+}
+
+// copyVerifiedFile copies the src file to dest. The copied contents must match
+// the given hash, otherwise dest is removed and an error is returned.
+func copyVerifiedFile(src, dest, fileHash string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	hasher := sha256.New()
+	_, err = io.Copy(io.MultiWriter(out, hasher), in)
+	if hash := hex.EncodeToString(hasher.Sum(nil)); err == nil && hash != fileHash {
+		err = fmt.Errorf("contents of %s do not match hash %s", src, fileHash)
+	}
+	if err != nil {
+		out.Close()
+		os.Remove(dest)
+		return err
+	}
+	return out.Close()
+}
+
+// linkOrCopyFile hard links the src file to dest, falling back to copying it
+// when a link cannot be created.
+func linkOrCopyFile(src, dest string) error {
+	if err := os.Link(src, dest); err == nil {
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dest)
+		return err
+	}
+	return out.Close()
+}
+
+// pruneChunkLocations removes the chunk locations of the given file that refer
+// to completed downloads that no longer exist on disk.
+func (db *DB) pruneChunkLocations(fname string) error {
+	var locs []chunkLocation
+	if err := db.readJsonFile(fname, &locs); err != nil {
+		db.log.Warnf("Unable to read chunk locations %s: %v", fname, err)
+		return nil
+	}
+	keep := locs[:0]
+	for _, loc := range locs {
+		if _, err := os.Stat(loc.Path); err == nil {
+			keep = append(keep, loc)
+		}
+	}
+	switch {
+	case len(keep) == 0:
+		return db.fs().Remove(fname)
+	case len(keep) < len(locs):
+		return db.saveJsonFile(fname, keep)
+	default:
+		return nil
+	}
+}
+
+// BlobStats are the stats of a cleanup of the blob store.
+type BlobStats struct {
+	Chunks int    `json:"chunks"`
+	Bytes  uint64 `json:"bytes"`
+}
+
+// DelUnreferencedBlobs removes the stored chunks that are no longer referenced
+// by any shared content or in-progress download, as well as the index entries
+// of completed downloads that no longer exist on disk.
+func (db *DB) DelUnreferencedBlobs(tx ReadWriteTx) (BlobStats, error) {
+	var stats BlobStats
+
+	// Drop the references of downloads that no longer exist (for example,
+	// because they were canceled).
+	downloadingDir := filepath.Join(db.root, downloadingDir)
+	contentDir := filepath.Join(db.root, contentDir)
+	isLiveRef := func(ref string) bool {
+		switch {
+		case strings.HasPrefix(ref, "download:"):
+			fname := filepath.Join(downloadingDir,
+				strings.TrimPrefix(ref, "download:")+contentMetaExt)
+			var fd FileDownload
+			if err := db.readJsonFile(fname, &fd); err != nil {
+				return false
+			}
+			return fd.CompletedName == ""
+		case strings.HasPrefix(ref, "shared:"):
+			return db.exists(filepath.Join(contentDir,
+				strings.TrimPrefix(ref, "shared:")))
+		default:
+			return true
+		}
+	}
+
+	pattern := filepath.Join(db.root, blobsDir, blobChunksDir, "*", "*")
+	files, err := db.fs().Glob(pattern)
+	if err != nil {
+		return stats, err
+	}
+	for _, fname := range files {
+		switch filepath.Ext(fname) {
+		case blobRefsExt:
+			continue
+		case blobLocationsExt:
+			if err := db.pruneChunkLocations(fname); err != nil {
+				return stats, err
+			}
+			continue
+		}
+
+		refsFname := fname + blobRefsExt
+		var refs []string
+		err := db.readJsonFile(refsFname, &refs)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			db.log.Warnf("Unable to read refs of blob %s: %v", fname, err)
+			continue
+		}
+		live := refs[:0]
+		for _, ref := range refs {
+			if isLiveRef(ref) {
+				live = append(live, ref)
+			}
+		}
+		if len(live) > 0 {
+			continue
+		}
+
+		fi, err := db.fs().Stat(fname)
+		if err != nil {
+			return stats, err
+		}
+		if err := db.fs().Remove(fname); err != nil {
+			return stats, err
+		}
+		if err := db.removeIfExists(refsFname); err != nil {
+			return stats, err
+		}
+		stats.Chunks++
+		stats.Bytes += uint64(fi.Size())
+	}
+
+	// Remove the entries of completed downloads that were removed from
+	// disk.
+	pattern = filepath.Join(db.root, blobsDir, blobFilesDir, "*.json")
+	files, err = db.fs().Glob(pattern)
+	if err != nil {
+		return stats, err
+	}
+	for _, fname := range files {
+		var fb fileBlob
+		if err := db.readJsonFile(fname, &fb); err != nil {
+			db.log.Warnf("Unable to read file blob %s: %v", fname, err)
+			continue
+		}
+		paths := fb.Paths[:0]
+		for _, path := range fb.Paths {
+			if _, err := os.Stat(path); err == nil {
+				paths = append(paths, path)
+			}
+		}
+		switch {
+		case len(paths) == 0:
+			err = db.fs().Remove(fname)
+		case len(paths) < len(fb.Paths):
+			fb.Paths = paths
+			err = db.saveJsonFile(fname, fb)
+		}
+		if err != nil {
+			return stats, err
+		}
+	}
+
+	if stats.Chunks > 0 {
+		db.log.Infof("Removed %d unreferenced chunks (%d bytes)",
+			stats.Chunks, stats.Bytes)
+	}
+	return stats, nil
+}
@@ -0,0 +1,96 @@
+package clientdb
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+	"math/bits"
+
+	"github.com/companyzero/bisonrelay/rpc"
+)
+
+// cdcGear is the table of random values used by the gear rolling hash of the
+// content-defined chunker. It is derived deterministically, so that the same
+// contents are always split at the same boundaries.
+var cdcGear = func() [256]uint64 {
+	var t [256]uint64
+	for i := range t {
+		h := sha256.Sum256([]byte{'b', 'r', 'c', 'd', 'c', byte(i)})
+		t[i] = binary.LittleEndian.Uint64(h[:])
+	}
+	return t
+}()
+
+// fileChunker splits a stream of data into chunks.
+type fileChunker struct {
+	chunking string
+	minSize  int
+	maxSize  int
+	mask     uint64
+}
+
+// newFileChunker returns a chunker for the given chunking mode, which creates
+// chunks of at most maxSize bytes.
+//
+// Content-defined chunks are at least maxSize/4 bytes, with an average of
+// about maxSize/2 bytes.
+func newFileChunker(chunking string, maxSize int) *fileChunker {
+	fc := &fileChunker{chunking: chunking, maxSize: maxSize}
+	if chunking == rpc.FileChunkingContentDefined {
+		fc.minSize = maxSize / 4
+		if avg := maxSize/2 - fc.minSize; avg > 1 {
+			// Use the high bits of the hash, which depend on more
+			// bytes of the rolling window.
+			nbBits := bits.Len(uint(avg)) - 1
+			fc.mask = ((1 << nbBits) - 1) << (64 - nbBits)
+		}
+	}
+	return fc
+}
+
+// boundary returns the size of the next chunk at the start of data. Data must
+// have maxSize bytes, unless the end of the stream was reached.
+func (fc *fileChunker) boundary(data []byte) int {
+	if len(data) > fc.maxSize {
+		data = data[:fc.maxSize]
+	}
+	if fc.chunking != rpc.FileChunkingContentDefined || len(data) <= fc.minSize {
+		return len(data)
+	}
+
+	var h uint64
+	for i := fc.minSize; i < len(data); i++ {
+		h = (h << 1) + cdcGear[data[i]]
+		if h&fc.mask == 0 {
+			return i + 1
+		}
+	}
+	return len(data)
+}
+
+// chunks calls f for every chunk of the data read from r.
+func (fc *fileChunker) chunks(r io.Reader, f func(chunk []byte) error) error {
+	buf := make([]byte, fc.maxSize)
+	var filled int
+	var eof bool
+	for {
+		if !eof && filled < len(buf) {
+			n, err := io.ReadFull(r, buf[filled:])
+			filled += n
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				eof = true
+			} else if err != nil {
+				return err
+			}
+		}
+		if filled == 0 {
+			return nil
+		}
+
+		n := fc.boundary(buf[:filled])
+		if err := f(buf[:n]); err != nil {
+			return err
+		}
+		filled = copy(buf, buf[n:filled])
+	}
+}
@@ -1,11 +1,11 @@
 package clientdb
 
 import (
+	"bytes"
 	"crypto/sha256"
 	"encoding/hex"
 	"errors"
 	"fmt"
-	"io"
 	"os"
 	"path/filepath"
 	"sort"
@@ -26,11 +26,14 @@ const (
 	contentHashSuffix     = ".filehash"
 	contentMetaHashSuffix = ".metahash"
 	downloadingDir        = "downloading"
+	canceledUploadExt     = ".canceled"
+	transferPriorityDir   = "transferprio"
 )
 
-// chunkFile creates a directory with appropriate chunks of the source file.
+// chunkFile creates a directory for the metadata of the source file and stores
+// its chunks (split according to the given chunking mode) in the blob store.
 // Returns the full hash of the file and final size.
-func (db *DB) chunkFile(srcFile, chunkDir string) ([]rpc.FileManifest, []byte, uint64, error) {
+func (db *DB) chunkFile(srcFile, chunkDir, chunking string) ([]rpc.FileManifest, []byte, uint64, error) {
 	f, err := os.Open(srcFile)
 	if err != nil {
 		return nil, nil, 0, err
@@ -44,55 +47,52 @@ func (db *DB) chunkFile(srcFile, chunkDir string) ([]rpc.FileManifest, []byte, u
 
 	fsize := uint64(fi.Size())
 	chunkSize := uint64(db.cfg.ChunkSize)
-	if chunkSize == 0 || chunkSize > fsize {
+	if chunkSize == 0 || (chunkSize > fsize && chunking == rpc.FileChunkingFixed) {
 		chunkSize = fsize
 	}
 
 	var (
-		chunks uint64
-		fm     = make([]rpc.FileManifest, 0,
-			(uint64(fi.Size())/chunkSize)+1)
+		chunks  uint64
+		fm      []rpc.FileManifest
 		fHasher = sha256.New()
 		size    uint64
 	)
+	if chunkSize > 0 {
+		fm = make([]rpc.FileManifest, 0, (fsize/chunkSize)+1)
+	}
 
-	if err := os.MkdirAll(chunkDir, 0o700); err != nil {
+	if err := db.fs().MkdirAll(chunkDir); err != nil {
 		return nil, nil, 0, err
 	}
 
-	buffer := make([]byte, chunkSize)
-	for {
-		n, err := f.Read(buffer)
-		if err != nil && err != io.EOF {
-			return nil, nil, 0, err
-		}
-		if n == 0 {
-			// Done with exact sized chunk
-			break
-		}
-
+	chunker := newFileChunker(chunking, int(chunkSize))
+	err = chunker.chunks(f, func(chunk []byte) error {
 		// Chunk digest
-		chunk := buffer[:n]
 		hash := sha256.Sum256(chunk)
 
 		fm = append(fm, rpc.FileManifest{
-			Index: chunks,
-			Size:  uint64(n),
-			Hash:  hash[:],
+			Index:  chunks,
+			Offset: size,
+			Size:   uint64(len(chunk)),
+			Hash:   hash[:],
 		})
 		chunks++
-		size += uint64(n)
+		size += uint64(len(chunk))
 
-		// Write chunk
-		chunkFilename := filepath.Join(chunkDir,
-			hex.EncodeToString(hash[:]))
-		err = os.WriteFile(chunkFilename, chunk, 0o600)
+		// Write chunk. Chunks already stored (for example, because they
+		// were downloaded or are part of another shared file) are
+		// reused.
+		err := db.putChunkBlob(hash[:], chunk, blobRefShared(filepath.Base(chunkDir)))
 		if err != nil {
-			return nil, nil, 0, fmt.Errorf("unable to write chunk file: %w", err)
+			return err
 		}
 
 		// Accumulate into global file hasher.
 		fHasher.Write(chunk)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, 0, err
 	}
 	return fm, fHasher.Sum(nil), size, nil
 }
@@ -117,7 +117,7 @@ func (db *DB) ShareFile(tx ReadWriteTx, fname string, uid *UserID,
 	// if it's not.
 	f.Filename = baseName
 	chunksPath := filepath.Join(db.root, contentDir, baseName)
-	if fileExists(chunksPath) {
+	if db.exists(chunksPath) {
 		// There needs to exists a file
 		// content/<baseName>/<fileHash>.fileHash, in the chunks dir,
 		// otherwise the files are different.
@@ -128,7 +128,7 @@ func (db *DB) ShareFile(tx ReadWriteTx, fname string, uid *UserID,
 		copy(f.FileHash[:], fileHash)
 		wantFileHashFile := f.FileHash.String() + contentHashSuffix
 		metaFname := filepath.Join(chunksPath, wantFileHashFile)
-		if !fileExists(metaFname) {
+		if !db.exists(metaFname) {
 			return f, md, fmt.Errorf("already shared a different file with name %s",
 				baseName)
 		}
@@ -140,16 +140,20 @@ func (db *DB) ShareFile(tx ReadWriteTx, fname string, uid *UserID,
 		}
 	} else {
 		md = rpc.FileMetadata{
-			Version:     rpc.FileMetadataVersion,
+			Version:     rpc.FileMetadataVersionFixedChunks,
 			Description: descr,
 			Cost:        cost,
 			Filename:    baseName,
 		}
+		if db.cfg.ContentDefinedChunking {
+			md.Version = rpc.FileMetadataVersion
+			md.Chunking = rpc.FileChunkingContentDefined
+		}
 
 		// File is being shared for the first time. Chunk the file.
 		var err error
 		var fhash []byte
-		md.Manifest, fhash, md.Size, err = db.chunkFile(fname, chunksPath)
+		md.Manifest, fhash, md.Size, err = db.chunkFile(fname, chunksPath, md.Chunking)
 		if err != nil {
 			return f, md, err
 		}
@@ -178,7 +182,7 @@ func (db *DB) ShareFile(tx ReadWriteTx, fname string, uid *UserID,
 	if uid != nil {
 		thisShare = uid.String()
 	}
-	if fileExists(metaMetaFname) {
+	if db.exists(metaMetaFname) {
 		if err := db.readJsonFile(metaMetaFname, &shares); err != nil {
 			return f, md, err
 		}
@@ -219,7 +223,7 @@ func (db *DB) ShareFile(tx ReadWriteTx, fname string, uid *UserID,
 func (db *DB) FindSharedFileID(tx ReadTx, fname string) (FileID, error) {
 	var fid FileID
 	chunksPath := filepath.Join(db.root, contentDir, fname)
-	files, err := filepath.Glob(chunksPath + "/*." + contentMetaHashSuffix)
+	files, err := db.fs().Glob(chunksPath + "/*." + contentMetaHashSuffix)
 	if err != nil {
 		return fid, err
 	}
@@ -258,14 +262,14 @@ func (db *DB) UnshareFile(tx ReadWriteTx, fid FileID, uid *UserID) error {
 	}
 
 	// Now, remove this share.
-	if err := os.Remove(shareFname); err != nil {
+	if err := db.fs().Remove(shareFname); err != nil {
 		return err
 	}
 
 	// Remove this share from list of content shares.
 	chunksPath := filepath.Join(db.root, contentDir, sf.Filename)
 	metaMetaFname := filepath.Join(chunksPath, sf.FID.String()+contentMetaHashSuffix)
-	if !fileExists(metaMetaFname) {
+	if !db.exists(metaMetaFname) {
 		// Shouldn't happen, but unshare was successful.
 		return nil
 	}
@@ -288,9 +292,21 @@ func (db *DB) UnshareFile(tx ReadWriteTx, fid FileID, uid *UserID) error {
 	}
 
 	if len(shares) == 0 {
-		// No more shares, remove content.
+		// No more shares, remove content. The chunks are removed from
+		// the blob store by DelUnreferencedBlobs once no other content
+		// references them.
 		db.log.Infof("Removing content due to no more shares: %q", sf.Filename)
-		return os.RemoveAll(chunksPath)
+		md, err := db.fileMetadataForSharedFile(&sf)
+		if err != nil {
+			return err
+		}
+		ref := blobRefShared(sf.Filename)
+		for _, fm := range md.Manifest {
+			if err := db.delChunkBlobRef(fm.Hash, ref); err != nil {
+				return err
+			}
+		}
+		return db.fs().RemoveAll(chunksPath)
 	}
 
 	// Still some shares. Save updated list of shares of this content.
@@ -304,7 +320,7 @@ func (db *DB) sharedFilesFromDirs(dirs []string) ([]SharedFile, error) {
 	var files []string
 
 	for _, v := range dirs {
-		dirEntries, err := os.ReadDir(v)
+		dirEntries, err := db.fs().ReadDir(v)
 		if err != nil && !os.IsNotExist(err) {
 			return nil, fmt.Errorf("unable to read content dir: %v", err)
 		}
@@ -364,13 +380,13 @@ func (db *DB) ListSharedFiles(tx ReadTx, uid *UserID) ([]rpc.FileMetadata, error
 // ListAllSharedFiles lists both globally and user shared files for all files.
 func (db *DB) ListAllSharedFiles(tx ReadTx) ([]SharedFileAndShares, error) {
 	dir := filepath.Join(db.root, contentDir)
-	if err := os.MkdirAll(dir, 0o700); err != nil {
+	if err := db.fs().MkdirAll(dir); err != nil {
 		return nil, fmt.Errorf("unable to make share dir: %v", err)
 	}
 
 	// List all .filehash files, which contains the file metadata.
 	pattern := filepath.Join(dir, "*", "*"+contentHashSuffix)
-	files, err := filepath.Glob(pattern)
+	files, err := db.fs().Glob(pattern)
 	if err != nil {
 		return nil, fmt.Errorf("unable to execute glob: %v", err)
 	}
@@ -502,19 +518,19 @@ func (db *DB) saveChunkUpload(cup *ChunkUpload) error {
 }
 
 // removeChunkUpload deletes the given chunk upload structure from the
-// filesystem.  If this is the last chunk, it also removes the parent file
+// db.  If this is the last chunk, it also removes the parent file
 // upload dir.
 func (db *DB) removeChunkUpload(cup *ChunkUpload) error {
 	dir := filepath.Join(db.root, inboundDir, cup.UID.String(), uploadsDir,
 		cup.FID.String())
 	fname := filepath.Join(dir, cup.CID.String())
-	if err := os.Remove(fname); err != nil {
+	if err := db.fs().Remove(fname); err != nil {
 		return err
 	}
 
 	// Remove upload dir if empty.
-	if dirExistsEmpty(dir) {
-		return os.Remove(dir)
+	if db.isEmptyDir(dir) {
+		return db.fs().Remove(dir)
 	}
 	return nil
 }
@@ -639,6 +655,11 @@ func (db *DB) fileMetadataForSharedFile(sf *SharedFile) (rpc.FileMetadata, error
 	return md, err
 }
 
+// GetSharedFileMetadata returns the metadata of the given shared file.
+func (db *DB) GetSharedFileMetadata(tx ReadTx, sf *SharedFile) (rpc.FileMetadata, error) {
+	return db.fileMetadataForSharedFile(sf)
+}
+
 // GetSharedFileChunkData returns the actual chunk data for a given shared file.
 func (db *DB) GetSharedFileChunkData(tx ReadTx, sf *SharedFile, chunkIdx int) ([]byte, error) {
 	md, err := db.fileMetadataForSharedFile(sf)
@@ -649,16 +670,76 @@ func (db *DB) GetSharedFileChunkData(tx ReadTx, sf *SharedFile, chunkIdx int) ([
 		return nil, fmt.Errorf("chunkIdx %d > len(chunks) %d",
 			chunkIdx, len(md.Manifest))
 	}
-	chunkHash := hex.EncodeToString(md.Manifest[chunkIdx].Hash)
+	hash := md.Manifest[chunkIdx].Hash
+	if db.hasChunkBlob(hash) {
+		return db.readChunkBlob(hash)
+	}
+
+	// Content shared before the blob store existed keeps its chunks in
+	// the content dir.
+	chunkHash := hex.EncodeToString(hash)
 	chunksPath := filepath.Join(db.root, contentDir, sf.Filename)
 	chunkFname := filepath.Join(chunksPath, chunkHash)
-	return os.ReadFile(chunkFname)
+	return db.readFile(chunkFname)
+}
+
+// CancelFileUpload removes all outstanding chunk uploads of the given file to
+// the given user and marks the upload as canceled, so that further chunks are
+// not sent to the user.
+func (db *DB) CancelFileUpload(tx ReadWriteTx, uid UserID, fid FileID) error {
+	uploadsPath := filepath.Join(db.root, inboundDir, uid.String(), uploadsDir)
+	if err := db.fs().RemoveAll(filepath.Join(uploadsPath, fid.String())); err != nil {
+		return err
+	}
+
+	fname := filepath.Join(uploadsPath, fid.String()+canceledUploadExt)
+	return db.saveJsonFile(fname, time.Now())
+}
+
+// IsFileUploadCanceled returns true if the upload of the given file to the
+// given user was canceled.
+func (db *DB) IsFileUploadCanceled(tx ReadTx, uid UserID, fid FileID) bool {
+	fname := filepath.Join(db.root, inboundDir, uid.String(), uploadsDir,
+		fid.String()+canceledUploadExt)
+	return db.exists(fname)
+}
+
+// ClearFileUploadCanceled removes the mark that the upload of the given file
+// to the given user was canceled.
+func (db *DB) ClearFileUploadCanceled(tx ReadWriteTx, uid UserID, fid FileID) error {
+	fname := filepath.Join(db.root, inboundDir, uid.String(), uploadsDir,
+		fid.String()+canceledUploadExt)
+	return db.removeIfExists(fname)
+}
+
+// SetTransferPriority sets the priority of the transfers (either download or
+// uploads) of the given file.
+func (db *DB) SetTransferPriority(tx ReadWriteTx, fid FileID, prio TransferPriority) error {
+	if !prio.IsValid() {
+		return fmt.Errorf("invalid transfer priority %q", prio)
+	}
+	fname := filepath.Join(db.root, transferPriorityDir, fid.String())
+	if prio == TransferPriorityNormal {
+		return db.removeIfExists(fname)
+	}
+	return db.saveJsonFile(fname, prio)
+}
+
+// GetTransferPriority returns the priority of the transfers of the given file.
+func (db *DB) GetTransferPriority(tx ReadTx, fid FileID) (TransferPriority, error) {
+	fname := filepath.Join(db.root, transferPriorityDir, fid.String())
+	var prio TransferPriority
+	err := db.readJsonFile(fname, &prio)
+	if errors.Is(err, ErrNotFound) {
+		return TransferPriorityNormal, nil
+	}
+	return prio, err
 }
 
 func (db *DB) ListOutstandingUploads(tx ReadTx) ([]ChunkUpload, error) {
 	// db/inbound/<userid>/uploads/<fid>/<cid>
 	pattern := filepath.Join(db.root, inboundDir, "*", uploadsDir, "*", "*")
-	files, err := filepath.Glob(pattern)
+	files, err := db.fs().Glob(pattern)
 	if err != nil {
 		return nil, err
 	}
@@ -708,6 +789,18 @@ func (db *DB) StartFileDownload(tx ReadWriteTx, uid UserID, fid FileID, isSentFi
 	return fd, nil
 }
 
+// GetFileDownload returns the download of the given file, regardless of the
+// user it is being downloaded from.
+func (db *DB) GetFileDownload(tx ReadTx, fid FileID) (FileDownload, error) {
+	var fd FileDownload
+	diskDir := filepath.Join(db.root, downloadingDir)
+	metaPath := filepath.Join(diskDir, fid.String()+contentMetaExt)
+	if err := db.readJsonFile(metaPath, &fd); err != nil {
+		return fd, fmt.Errorf("download of file %s: %w", fid, err)
+	}
+	return fd, nil
+}
+
 func (db *DB) ReadFileDownload(tx ReadTx, uid UserID, fid FileID) (FileDownload, error) {
 	var fd FileDownload
 
@@ -717,48 +810,192 @@ func (db *DB) ReadFileDownload(tx ReadTx, uid UserID, fid FileID) (FileDownload,
 		return fd, err
 	}
 
-	// TODO: support downloading from multiple users at the same time?
 	if fd.UID != uid {
 		return fd, fmt.Errorf("specified user not the download user")
 	}
 	return fd, nil
 }
 
+// ReadFileDownloadFromSource reads the download of the file with the given
+// file ID as shared by the given user. The user may be either the original
+// user from which the download was started or one of its additional sources,
+// in which case fid is the file ID of the file as shared by that source.
+func (db *DB) ReadFileDownloadFromSource(tx ReadTx, uid UserID, fid FileID) (FileDownload, error) {
+	fd, err := db.ReadFileDownload(tx, uid, fid)
+	if err == nil {
+		return fd, nil
+	}
+
+	fds, errList := db.ListOutstandingDownloads(tx)
+	if errList != nil {
+		return fd, errList
+	}
+	for _, fd := range fds {
+		if src := fd.source(uid); src != nil && src.FID == fid {
+			return fd, nil
+		}
+	}
+	return fd, err
+}
+
+// AddFileDownloadSource adds the given user as an additional source for the
+// download. The metadata must be of a file with the same content as the one
+// being downloaded and must be signed by the user.
+func (db *DB) AddFileDownloadSource(tx ReadWriteTx, fd *FileDownload,
+	id *zkidentity.PublicIdentity, md rpc.FileMetadata) error {
+
+	if fd.Metadata == nil {
+		return fmt.Errorf("file metadata is nil")
+	}
+	uid := id.Identity
+	if _, ok := fd.SourceFID(uid); ok {
+		return fmt.Errorf("user is already a source of the download")
+	}
+	if md.Hash != fd.Metadata.Hash || md.Size != fd.Metadata.Size {
+		return fmt.Errorf("source file does not match download file")
+	}
+	if len(md.Manifest) != len(fd.Metadata.Manifest) {
+		return fmt.Errorf("source file has different nb of chunks "+
+			"(got %d, want %d)", len(md.Manifest),
+			len(fd.Metadata.Manifest))
+	}
+	for i := range md.Manifest {
+		if !bytes.Equal(md.Manifest[i].Hash, fd.Metadata.Manifest[i].Hash) {
+			return fmt.Errorf("source file has different chunk %d", i)
+		}
+	}
+	fileHash, err := hex.DecodeString(md.Hash)
+	if err != nil {
+		return fmt.Errorf("invalid source file hash: %v", err)
+	}
+	sig, err := hex.DecodeString(md.Signature)
+	if err != nil || len(sig) != len(zkidentity.FixedSizeSignature{}) {
+		return fmt.Errorf("invalid source file signature")
+	}
+	var fixedSig zkidentity.FixedSizeSignature
+	copy(fixedSig[:], sig)
+	if !id.VerifyMessage(fileHash, fixedSig) {
+		return fmt.Errorf("source file signature is not from the user")
+	}
+
+	fd.Sources = append(fd.Sources, FileDownloadSource{
+		UID:      uid,
+		FID:      md.MetadataHash(),
+		Metadata: md,
+	})
+
+	diskDir := filepath.Join(db.root, downloadingDir)
+	metaPath := filepath.Join(diskDir, fd.FID.String()+contentMetaExt)
+	return db.saveJsonFile(metaPath, fd)
+}
+
+// MarkFileDownloadChunkRequested marks the given chunk as requested from the
+// given user.
+func (db *DB) MarkFileDownloadChunkRequested(tx ReadWriteTx, fd *FileDownload,
+	chunkIdx int, uid UserID) error {
+
+	if uid == fd.UID {
+		delete(fd.ChunkSources, chunkIdx)
+	} else {
+		if fd.ChunkSources == nil {
+			fd.ChunkSources = make(map[int]UserID)
+		}
+		fd.ChunkSources[chunkIdx] = uid
+	}
+	return db.ReplaceFileDownloadChunkState(tx, fd, chunkIdx,
+		ChunkStateRequestedChunk)
+}
+
 // CancelFileDownload removes the in-progress download from the DB.
 func (db *DB) CancelFileDownload(tx ReadWriteTx, fid FileID) error {
 	diskDir := filepath.Join(db.root, downloadingDir)
 	metaPath := filepath.Join(diskDir, fid.String()+contentMetaExt)
 	chunkDir := filepath.Join(diskDir, fid.String()+chunkDirSuffix)
 
-	err := os.Remove(metaPath)
-	if os.IsNotExist(err) {
+	var fd FileDownload
+	err := db.readJsonFile(metaPath, &fd)
+	if errors.Is(err, ErrNotFound) {
 		return fmt.Errorf("download of file %s: %v", fid, ErrNotFound)
 	}
 	if err != nil {
 		return err
 	}
+	if err := db.fs().Remove(metaPath); err != nil {
+		return err
+	}
+
+	// Drop the references to the downloaded chunks. They are removed from
+	// the blob store by DelUnreferencedBlobs.
+	if fd.Metadata != nil {
+		ref := blobRefDownload(fid)
+		for _, ch := range fd.Metadata.Manifest {
+			if err := db.delChunkBlobRef(ch.Hash, ref); err != nil {
+				return err
+			}
+		}
+	}
 
 	// Ignore errors when removing chunk dir since we've already removed the
 	// metadata file.
-	os.RemoveAll(chunkDir)
+	db.fs().RemoveAll(chunkDir)
 	return nil
 }
 
+// SetFileDownloadPaused sets whether the given download is paused. Chunks of
+// paused downloads are not requested or paid for.
+func (db *DB) SetFileDownloadPaused(tx ReadWriteTx, fd *FileDownload, paused bool) error {
+	if fd.CompletedName != "" {
+		return fmt.Errorf("download of file %s already completed", fd.FID)
+	}
+	fd.Paused = paused
+
+	diskDir := filepath.Join(db.root, downloadingDir)
+	metaPath := filepath.Join(diskDir, fd.FID.String()+contentMetaExt)
+	return db.saveJsonFile(metaPath, fd)
+}
+
 func (db *DB) UpdateFileDownloadMetadata(tx ReadWriteTx, fd *FileDownload,
 	md rpc.FileMetadata) error {
 
 	if fd.Metadata != nil {
 		return fmt.Errorf("cannot update file metadata: metadata already filled")
 	}
+	if err := md.VerifyManifest(); err != nil {
+		return fmt.Errorf("invalid file manifest: %w", err)
+	}
 	fd.Metadata = &md
 
-	diskDir := filepath.Join(db.root, downloadingDir)
-	metaPath := filepath.Join(diskDir, fd.FID.String()+contentMetaExt)
-	if err := db.saveJsonFile(metaPath, *fd); err != nil {
-		return err
+	// Reference the chunks that are already stored, so that they are not
+	// removed while the download is in progress. Chunks that are part of
+	// other completed downloads are copied to the blob store, so that they
+	// are not downloaded (and paid for) again. This is not needed when the
+	// entire file was already downloaded.
+	_, hasFile := db.fileBlobPath(md.Hash, md.Size)
+	ref := blobRefDownload(fd.FID)
+	for i, ch := range md.Manifest {
+		if db.hasChunkBlob(ch.Hash) {
+			if err := db.addChunkBlobRef(ch.Hash, ref); err != nil {
+				return err
+			}
+		} else if hasFile {
+			continue
+		} else if data, ok := db.readLocatedChunk(ch.Hash); ok {
+			if err := db.putChunkBlob(ch.Hash, data, ref); err != nil {
+				return err
+			}
+		} else {
+			continue
+		}
+
+		if fd.ChunkStates == nil {
+			fd.ChunkStates = make(map[int]ChunkState)
+		}
+		fd.ChunkStates[i] = ChunkStateDownloaded
 	}
 
-	return nil
+	diskDir := filepath.Join(db.root, downloadingDir)
+	metaPath := filepath.Join(diskDir, fd.FID.String()+contentMetaExt)
+	return db.saveJsonFile(metaPath, *fd)
 }
 
 func (db *DB) ReplaceFileDownloadInvoices(tx ReadWriteTx, fd *FileDownload,
@@ -816,7 +1053,6 @@ func (db *DB) SaveFileDownloadChunk(tx ReadWriteTx, user string, fd *FileDownloa
 	hasher := sha256.New()
 	hasher.Write(data)
 	hash := hasher.Sum(nil)
-	hashStr := hex.EncodeToString(hash)
 
 	if fd.Metadata == nil {
 		return "", fmt.Errorf("file metadata is nil")
@@ -828,13 +1064,7 @@ func (db *DB) SaveFileDownloadChunk(tx ReadWriteTx, user string, fd *FileDownloa
 	}
 
 	// Save the chunk.
-	diskDir := filepath.Join(db.root, downloadingDir)
-	chunkDir := filepath.Join(diskDir, fd.FID.String()+chunkDirSuffix)
-	chunkPath := filepath.Join(chunkDir, hashStr)
-	if err := os.MkdirAll(chunkDir, 0o700); err != nil {
-		return "", err
-	}
-	if err := os.WriteFile(chunkPath, data, 0o600); err != nil {
+	if err := db.putChunkBlob(hash, data, blobRefDownload(fd.FID)); err != nil {
 		return "", err
 	}
 
@@ -848,7 +1078,12 @@ func (db *DB) SaveFileDownloadChunk(tx ReadWriteTx, user string, fd *FileDownloa
 		return "", nil
 	}
 
-	// Assemble final file. First: figure out final name.
+	return db.assembleFileDownload(fd, user)
+}
+
+// fileDownloadDestName returns the name of the file where the given download
+// will be stored once completed.
+func (db *DB) fileDownloadDestName(fd *FileDownload, user string) (string, error) {
 	baseDestFileName := filepath.Join(db.downloadsDir, strescape.PathElement(user),
 		strescape.PathElement(fd.Metadata.Filename))
 	destFileName := baseDestFileName
@@ -862,6 +1097,84 @@ func (db *DB) SaveFileDownloadChunk(tx ReadWriteTx, user string, fd *FileDownloa
 	if err := os.MkdirAll(filepath.Dir(destFileName), 0o700); err != nil {
 		return "", err
 	}
+	return destFileName, nil
+}
+
+// readFileDownloadChunk returns the data of the chunk with the given hash of
+// the given download.
+func (db *DB) readFileDownloadChunk(fd *FileDownload, hash []byte) ([]byte, error) {
+	var data []byte
+	var err error
+	fname := db.chunkBlobFname(hash)
+	if db.hasChunkBlob(hash) {
+		data, err = db.readChunkBlob(hash)
+	} else {
+		// Downloads started before the blob store existed keep their
+		// chunks in a chunk dir.
+		chunkDir := filepath.Join(db.root, downloadingDir, fd.FID.String()+chunkDirSuffix)
+		fname = filepath.Join(chunkDir, hex.EncodeToString(hash))
+		data, err = db.readFile(fname)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Verify the stored chunk, so that a corrupted one is downloaded
+	// again when the download is restarted.
+	if gotHash := sha256.Sum256(data); !bytes.Equal(gotHash[:], hash) {
+		if err := db.fs().Remove(fname); err != nil {
+			db.log.Warnf("Unable to remove corrupted chunk %s: %v", fname, err)
+		}
+		return nil, fmt.Errorf("stored chunk %x failed verification", hash)
+	}
+	return data, nil
+}
+
+// completeFileDownload marks the download as completed, with its contents
+// stored in destFileName.
+func (db *DB) completeFileDownload(fd *FileDownload, destFileName string) error {
+	fd.CompletedName = filepath.Base(destFileName)
+	if fd.ChunkStates == nil {
+		fd.ChunkStates = make(map[int]ChunkState, len(fd.Metadata.Manifest))
+	}
+	for i := range fd.Metadata.Manifest {
+		fd.ChunkStates[i] = ChunkStateDownloaded
+	}
+	diskDir := filepath.Join(db.root, downloadingDir)
+	metaPath := filepath.Join(diskDir, fd.FID.String()+contentMetaExt)
+	if err := db.saveJsonFile(metaPath, fd); err != nil {
+		return err
+	}
+	if err := db.addFileBlobPath(fd.Metadata.Hash, destFileName); err != nil {
+		return err
+	}
+	if err := db.addChunkLocations(fd.Metadata, destFileName); err != nil {
+		return err
+	}
+
+	// Finally, clean up the chunks. They are removed from the blob store
+	// once no other content references them.
+	ref := blobRefDownload(fd.FID)
+	for _, ch := range fd.Metadata.Manifest {
+		if err := db.delChunkBlobRef(ch.Hash, ref); err != nil {
+			return err
+		}
+	}
+	chunkDir := filepath.Join(diskDir, fd.FID.String()+chunkDirSuffix)
+	if err := db.fs().RemoveAll(chunkDir); err != nil {
+		db.log.Errorf("Unable to remove chunk dir of completed download: %v", err)
+	}
+	return nil
+}
+
+// assembleFileDownload assembles the final file of a download for which all
+// chunks are available. Returns the name of the final file.
+func (db *DB) assembleFileDownload(fd *FileDownload, user string) (string, error) {
+	// Assemble final file. First: figure out final name.
+	destFileName, err := db.fileDownloadDestName(fd, user)
+	if err != nil {
+		return "", err
+	}
 	destFile, err := os.Create(destFileName)
 	if err != nil {
 		return "", err
@@ -870,10 +1183,9 @@ func (db *DB) SaveFileDownloadChunk(tx ReadWriteTx, user string, fd *FileDownloa
 	defer destFile.Close()
 
 	// Next: Copy over chunks, while accumulating final hash.
-	hasher = sha256.New()
+	hasher := sha256.New()
 	for _, ch := range fd.Metadata.Manifest {
-		chunkFname := filepath.Join(chunkDir, hex.EncodeToString(ch.Hash))
-		data, err := os.ReadFile(chunkFname)
+		data, err := db.readFileDownloadChunk(fd, ch.Hash)
 		if err != nil {
 			return "", err
 		}
@@ -884,24 +1196,54 @@ func (db *DB) SaveFileDownloadChunk(tx ReadWriteTx, user string, fd *FileDownloa
 	}
 
 	// Ensure final file hash is correct.
-	hash = hasher.Sum(nil)
-	hashStr = hex.EncodeToString(hash)
+	hashStr := hex.EncodeToString(hasher.Sum(nil))
 	if hashStr != fd.Metadata.Hash {
 		return "", fmt.Errorf("unexpected final file hash (got %s, want %s)",
 			hashStr, fd.Metadata.Hash)
 	}
-	fd.CompletedName = filepath.Base(destFileName)
-	metaPath := filepath.Join(diskDir, fd.FID.String()+contentMetaExt)
-	if err := db.saveJsonFile(metaPath, fd); err != nil {
+	if err := db.completeFileDownload(fd, destFileName); err != nil {
 		return "", err
 	}
 
-	// Finally, clean up the chunks.
-	if err := os.RemoveAll(chunkDir); err != nil {
-		db.log.Errorf("Unable to remove chunk dir of completed download: %v", err)
+	return destFileName, nil
+}
+
+// ReuseFileDownloadBlobs attempts to complete the given download without
+// fetching any chunks, either because a file with the same contents was
+// already downloaded or because all of its chunks are already stored (for
+// example, because the file is also shared by the local client). Returns the
+// name of the final file or an empty string if the download needs to fetch
+// chunks.
+func (db *DB) ReuseFileDownloadBlobs(tx ReadWriteTx, user string, fd *FileDownload) (string, error) {
+	if fd.Metadata == nil || fd.CompletedName != "" {
+		return "", nil
 	}
 
-	return destFileName, nil
+	if srcFileName, ok := db.fileBlobPath(fd.Metadata.Hash, fd.Metadata.Size); ok {
+		// The file is copied (instead of linked), so that modifying
+		// one of the files does not modify the other.
+		destFileName, err := db.fileDownloadDestName(fd, user)
+		if err != nil {
+			return "", err
+		}
+		err = copyVerifiedFile(srcFileName, destFileName, fd.Metadata.Hash)
+		if err == nil {
+			if err := db.completeFileDownload(fd, destFileName); err != nil {
+				return "", err
+			}
+			return destFileName, nil
+		}
+
+		// The file may have been modified after being verified.
+		db.log.Warnf("Unable to reuse file %s for download %s: %v",
+			srcFileName, fd.FID, err)
+		delete(db.verifiedFiles, srcFileName)
+	}
+
+	if len(db.MissingFileDownloadChunks(tx, fd)) != 0 {
+		return "", nil
+	}
+	return db.assembleFileDownload(fd, user)
 }
 
 func (db *DB) MissingFileDownloadChunks(tx ReadTx, fd *FileDownload) []int {
@@ -911,7 +1253,7 @@ func (db *DB) MissingFileDownloadChunks(tx ReadTx, fd *FileDownload) []int {
 
 	diskDir := filepath.Join(db.root, downloadingDir)
 	chunkDir := filepath.Join(diskDir, fd.FID.String()+chunkDirSuffix)
-	files, _ := os.ReadDir(chunkDir) // Safe to ignore error
+	files, _ := db.fs().ReadDir(chunkDir) // Safe to ignore error
 
 	// Aux map to know which files exist in chunk dir.
 	filesMap := make(map[string]struct{}, len(files))
@@ -919,9 +1261,13 @@ func (db *DB) MissingFileDownloadChunks(tx ReadTx, fd *FileDownload) []int {
 		filesMap[f.Name()] = struct{}{}
 	}
 
-	// Verify which chunk files already exist.
-	res := make([]int, 0, len(fd.Metadata.Manifest)-len(filesMap))
+	// Verify which chunks already exist, either in the blob store or in
+	// the chunk dir.
+	var res []int
 	for i, ch := range fd.Metadata.Manifest {
+		if db.hasChunkBlob(ch.Hash) {
+			continue
+		}
 		if _, ok := filesMap[hex.EncodeToString(ch.Hash)]; !ok {
 			res = append(res, i)
 		}
@@ -929,18 +1275,33 @@ func (db *DB) MissingFileDownloadChunks(tx ReadTx, fd *FileDownload) []int {
 	return res
 }
 
+// HasDownloadedFile returns true if the contents of the given file are
+// available locally, either because it was downloaded, because a file with the
+// same contents was downloaded or because it is shared by the local client.
 func (db *DB) HasDownloadedFile(tx ReadTx, fid zkidentity.ShortID) (bool, error) {
 	downDir := filepath.Join(db.root, downloadingDir)
 	metaFname := filepath.Join(downDir, fid.String()+contentMetaExt)
-	if !fileExists(metaFname) {
-		return false, nil
+	if db.exists(metaFname) {
+		var fd FileDownload
+		if err := db.readJsonFile(metaFname, &fd); err != nil {
+			return false, err
+		}
+		if fd.CompletedName != "" {
+			return true, nil
+		}
+		if fd.Metadata != nil {
+			if _, ok := db.fileBlobPath(fd.Metadata.Hash, fd.Metadata.Size); ok {
+				return true, nil
+			}
+		}
 	}
-	var fd FileDownload
-	if err := db.readJsonFile(metaFname, &fd); err != nil {
+
+	pattern := filepath.Join(db.root, contentDir, "*", fid.String()+contentMetaHashSuffix)
+	files, err := db.fs().Glob(pattern)
+	if err != nil {
 		return false, err
 	}
-
-	return fd.CompletedName != "", nil
+	return len(files) > 0, nil
 }
 
 // HasDownloadedFiles converts the given list of file metadata (possibly
@@ -959,18 +1320,23 @@ func (db *DB) HasDownloadedFiles(tx ReadTx, user string, uid UserID, files []rpc
 		}
 
 		metaFname := filepath.Join(downDir, res[i].FID.String()+contentMetaExt)
-		if !fileExists(metaFname) {
-			continue
+		if db.exists(metaFname) {
+			var fd FileDownload
+			if err := db.readJsonFile(metaFname, &fd); err != nil {
+				return nil, err
+			}
+			res[i].UID = fd.UID
+			if fd.CompletedName != "" {
+				res[i].DiskPath = filepath.Join(db.downloadsDir,
+					strescape.PathElement(user), fd.CompletedName)
+				continue
+			}
 		}
 
-		var fd FileDownload
-		if err := db.readJsonFile(metaFname, &fd); err != nil {
-			return nil, err
-		}
-		res[i].UID = fd.UID
-		if fd.CompletedName != "" {
-			res[i].DiskPath = filepath.Join(db.downloadsDir,
-				strescape.PathElement(user), fd.CompletedName)
+		// A file with the same contents may have been downloaded
+		// before.
+		if diskPath, ok := db.fileBlobPath(m.Hash, m.Size); ok {
+			res[i].DiskPath = diskPath
 		}
 	}
 
@@ -981,7 +1347,7 @@ func (db *DB) ListOutstandingDownloads(tx ReadTx) ([]FileDownload, error) {
 	diskDir := filepath.Join(db.root, downloadingDir)
 
 	pattern := diskDir + "/*" + contentMetaExt
-	files, err := filepath.Glob(pattern)
+	files, err := db.fs().Glob(pattern)
 	if err != nil {
 		return nil, err
 	}
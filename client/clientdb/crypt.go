@@ -0,0 +1,507 @@
+package clientdb
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/companyzero/bisonrelay/sw"
+	"golang.org/x/crypto/argon2"
+)
+
+const (
+	dbKeyFile = "dbkey.json"
+
+	// dbKeyTmpFile is the key file while the existing data is being
+	// encrypted. It is renamed to dbKeyFile once all data is sealed.
+	dbKeyTmpFile = "dbkey.json.tmp"
+
+	// Argon2id params used when creating a new db key.
+	dbKeyArgonTime    = 1
+	dbKeyArgonMemory  = 64 * 1024
+	dbKeyArgonThreads = 4
+
+	// sealedStrPrefix is the prefix for encrypted values stored in inidb
+	// files.
+	sealedStrPrefix = "sealed:"
+)
+
+var (
+	// sealedMagic is the prefix of every file (or record) that has been
+	// encrypted with the db key.
+	sealedMagic = []byte("brdbenc1")
+
+	// dbKeyCheckPlaintext is the plaintext sealed in the key file and used
+	// to verify whether a passphrase is correct.
+	dbKeyCheckPlaintext = []byte("bison relay client db key check")
+)
+
+// dbKeyParams are the params stored in the root of the db used to derive the
+// db encryption key from a passphrase.
+type dbKeyParams struct {
+	Salt    []byte `json:"salt"`
+	Time    uint32 `json:"time"`
+	Memory  uint32 `json:"memory"`
+	Threads uint8  `json:"threads"`
+	Check   []byte `json:"check"`
+}
+
+func (p *dbKeyParams) deriveKey(passphrase []byte) *[32]byte {
+	k := argon2.IDKey(passphrase, p.Salt, p.Time, p.Memory, p.Threads, 32)
+	key := new([32]byte)
+	copy(key[:], k)
+	return key
+}
+
+// IsEncrypted returns true if the db is encrypted at rest.
+func (db *DB) IsEncrypted() bool {
+	// A leftover temp key file means encryption was interrupted and some of
+	// the data may already be sealed.
+	return fileExists(filepath.Join(db.root, dbKeyFile)) ||
+		fileExists(filepath.Join(db.root, dbKeyTmpFile))
+}
+
+// NeedsUnlock returns true if UnlockDB must be called before the DB can be
+// used.
+func (db *DB) NeedsUnlock() bool {
+	select {
+	case <-db.unlocked:
+		return false
+	default:
+		return true
+	}
+}
+
+// UnlockDB derives the db encryption key from the passphrase. If the DB is not
+// yet encrypted, a new key is derived and the existing sensitive data is
+// encrypted with it.
+//
+// This must be called before Run() when NeedsUnlock() returns true.
+func (db *DB) UnlockDB(passphrase []byte) error {
+	if len(passphrase) == 0 {
+		return errEmptyPassphrase
+	}
+
+	db.Lock()
+	defer db.Unlock()
+
+	if !db.NeedsUnlock() {
+		return errAlreadyUnlocked
+	}
+
+	err := db.inStorageTx(context.Background(), func() error {
+		return db.unlockDB(passphrase)
+	})
+	if err != nil {
+		return err
+	}
+	close(db.unlocked)
+	return nil
+}
+
+// unlockDB derives the db key and loads the sealed data. This must be called
+// with the db lock held.
+func (db *DB) unlockDB(passphrase []byte) error {
+
+	keyFname := filepath.Join(db.root, dbKeyFile)
+	b, err := os.ReadFile(keyFname)
+	switch {
+	case err == nil:
+		if db.key, err = openDBKey(b, passphrase); err != nil {
+			return err
+		}
+
+	case os.IsNotExist(err):
+		// The key file is only written once the existing data is
+		// encrypted. If a previous attempt was interrupted, the data
+		// is (partially) sealed with the key of the temp key file, so
+		// that key is reused and the sealing re-run.
+		tmpKeyFname := filepath.Join(db.root, dbKeyTmpFile)
+		b, err := os.ReadFile(tmpKeyFname)
+		switch {
+		case err == nil:
+			if db.key, err = openDBKey(b, passphrase); err != nil {
+				return err
+			}
+			db.log.Infof("Resuming client db encryption")
+
+		case os.IsNotExist(err):
+			params := dbKeyParams{
+				Salt:    make([]byte, 32),
+				Time:    dbKeyArgonTime,
+				Memory:  dbKeyArgonMemory,
+				Threads: dbKeyArgonThreads,
+			}
+			if _, err := io.ReadFull(db.rnd, params.Salt); err != nil {
+				return err
+			}
+			key := params.deriveKey(passphrase)
+			if params.Check, err = sw.Seal(dbKeyCheckPlaintext, key); err != nil {
+				return err
+			}
+			// The key file is always kept in the filesystem,
+			// regardless of the storage driver, so that
+			// IsEncrypted() may check for it.
+			b, err := json.Marshal(params)
+			if err != nil {
+				return err
+			}
+			if err := os.WriteFile(tmpKeyFname, b, 0o600); err != nil {
+				return err
+			}
+			db.key = key
+
+		default:
+			return err
+		}
+
+		if err := db.sealExistingData(); err != nil {
+			return fmt.Errorf("unable to encrypt existing data: %v", err)
+		}
+		if err := os.Rename(tmpKeyFname, keyFname); err != nil {
+			return err
+		}
+		db.log.Infof("Enabled client db encryption")
+
+	default:
+		return err
+	}
+
+	return db.loadSealedData()
+}
+
+// openDBKey decodes the params of a key file and derives the db key from the
+// passphrase. It returns ErrWrongPassphrase if the key does not open the
+// check value of the file.
+func openDBKey(b, passphrase []byte) (*[32]byte, error) {
+	var params dbKeyParams
+	if err := json.Unmarshal(b, &params); err != nil {
+		return nil, fmt.Errorf("unable to decode db key file: %v", err)
+	}
+	key := params.deriveKey(passphrase)
+	if len(params.Check) < sw.MinPackedEncryptedSize {
+		return nil, fmt.Errorf("db key file check is too short")
+	}
+	check, ok := sw.Open(params.Check, key)
+	if !ok || !bytes.Equal(check, dbKeyCheckPlaintext) {
+		return nil, ErrWrongPassphrase
+	}
+	return key, nil
+}
+
+// sealData encrypts the data with the db key. If the db is not encrypted, it
+// returns the data as is.
+func (db *DB) sealData(data []byte) ([]byte, error) {
+	if db.key == nil {
+		return data, nil
+	}
+	box, err := sw.Seal(data, db.key)
+	if err != nil {
+		return nil, err
+	}
+	return append(sealedMagic[:len(sealedMagic):len(sealedMagic)], box...), nil
+}
+
+// openData decrypts data that was encrypted with sealData. Data that was not
+// encrypted (for example, data written before encryption was enabled) is
+// returned as is.
+func (db *DB) openData(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, sealedMagic) {
+		return data, nil
+	}
+	if db.key == nil {
+		return nil, errDBLocked
+	}
+	box := data[len(sealedMagic):]
+	if len(box) < sw.MinPackedEncryptedSize {
+		return nil, errSealedDataTooShort
+	}
+	res, ok := sw.Open(box, db.key)
+	if !ok {
+		return nil, errUnableToOpenSealedData
+	}
+	return res, nil
+}
+
+// sealString encrypts the string s, returning a value that is safe to store
+// in an inidb file.
+func (db *DB) sealString(s string) (string, error) {
+	if db.key == nil {
+		return s, nil
+	}
+	box, err := db.sealData([]byte(s))
+	if err != nil {
+		return "", err
+	}
+	return sealedStrPrefix + base64.StdEncoding.EncodeToString(box), nil
+}
+
+// openString decrypts a value encrypted with sealString.
+func (db *DB) openString(s string) (string, error) {
+	if !strings.HasPrefix(s, sealedStrPrefix) {
+		return s, nil
+	}
+	box, err := base64.StdEncoding.DecodeString(s[len(sealedStrPrefix):])
+	if err != nil {
+		return "", err
+	}
+	b, err := db.openData(box)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// sealLogLines encrypts each line of the given message log data with the db
+// key. Sealed lines are stored as strings returned by sealString, so that the
+// logs may still be appended to and pruned line by line. If the db is not
+// encrypted, it returns the data as is.
+func (db *DB) sealLogLines(data []byte) ([]byte, error) {
+	if db.key == nil {
+		return data, nil
+	}
+	res := new(bytes.Buffer)
+	for _, line := range strings.SplitAfter(string(data), "\n") {
+		if line == "" {
+			continue
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if !strings.HasPrefix(line, sealedStrPrefix) {
+			var err error
+			if line, err = db.sealString(line); err != nil {
+				return nil, err
+			}
+		}
+		res.WriteString(line)
+		res.WriteRune('\n')
+	}
+	return res.Bytes(), nil
+}
+
+// writeFile writes the file to the db storage, encrypting the data with the db
+// key.
+func (db *DB) writeFile(fname string, data []byte) error {
+	data, err := db.sealData(data)
+	if err != nil {
+		return err
+	}
+	return db.fs().WriteFile(fname, data)
+}
+
+// readFile reads the file from the db storage, decrypting the data with the db
+// key.
+func (db *DB) readFile(fname string) ([]byte, error) {
+	data, err := db.fs().ReadFile(fname)
+	if err != nil {
+		return nil, err
+	}
+	return db.openData(data)
+}
+
+// jsonRecordDecoder decodes a sequence of json records from a file written
+// with appendToJsonFile.
+type jsonRecordDecoder struct {
+	db  *DB
+	dec *json.Decoder
+}
+
+func (db *DB) newJsonRecordDecoder(r io.Reader) *jsonRecordDecoder {
+	return &jsonRecordDecoder{db: db, dec: json.NewDecoder(r)}
+}
+
+// Decode decodes the next record into v.
+func (d *jsonRecordDecoder) Decode(v interface{}) error {
+	var raw json.RawMessage
+	if err := d.dec.Decode(&raw); err != nil {
+		return err
+	}
+
+	// Sealed records are stored as base64 encoded json strings.
+	if len(raw) > 0 && raw[0] == '"' {
+		var box []byte
+		if err := json.Unmarshal(raw, &box); err != nil {
+			return err
+		}
+		b, err := d.db.openData(box)
+		if err != nil {
+			return err
+		}
+		raw = b
+	}
+	return json.Unmarshal(raw, v)
+}
+
+// resealFile encrypts the given file with the db key if it is not yet
+// encrypted.
+func (db *DB) resealFile(fname string) error {
+	data, err := db.fs().ReadFile(fname)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if bytes.HasPrefix(data, sealedMagic) {
+		return nil
+	}
+	return db.writeFile(fname, data)
+}
+
+// resealJsonRecordsFile encrypts each record of the given file, which was
+// written with appendToJsonFile, with the db key.
+func (db *DB) resealJsonRecordsFile(fname string) error {
+	data, err := db.fs().ReadFile(fname)
+	if err != nil {
+		return err
+	}
+	buf := new(bytes.Buffer)
+	dec := db.newJsonRecordDecoder(bytes.NewReader(data))
+	for {
+		var raw json.RawMessage
+		err := dec.Decode(&raw)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("unable to decode record of %s: %v", fname, err)
+		}
+		rec, err := db.marshalJsonRecord(raw)
+		if err != nil {
+			return err
+		}
+		buf.Write(rec)
+	}
+	return db.fs().WriteFile(fname, buf.Bytes())
+}
+
+// isJsonRecordsFile returns true if the given file of the db storage is
+// written with appendToJsonFile (and thus each of its records is individually
+// encrypted) instead of being written at once.
+func (db *DB) isJsonRecordsFile(fname string) bool {
+	rel, err := filepath.Rel(db.root, fname)
+	if err != nil {
+		return false
+	}
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	switch {
+	case len(parts) == 4 && parts[0] == historyDir:
+		return parts[3] != historyMetaFile
+	case len(parts) == 3 && parts[0] == searchDir:
+		return parts[1] == searchDocsDir || parts[1] == searchTermsDir
+	case len(parts) == 3 && parts[0] == inboundDir:
+		return parts[2] == payStatsFile
+	case len(parts) == 3 && parts[0] == postsDir:
+		return strings.HasSuffix(parts[2], postsStatusExt)
+	case len(parts) == 2 && parts[0] == postsDir:
+		return parts[1] == postsSubscribers || parts[1] == postsSubscriptions
+	default:
+		return false
+	}
+}
+
+// sealStorageDir encrypts every file of the db storage in the given dir (and
+// its subdirs) that is not yet encrypted.
+func (db *DB) sealStorageDir(dir string) error {
+	entries, err := db.fs().ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		fname := filepath.Join(dir, entry.Name())
+		switch {
+		case db.isFSOnlyFile(fname):
+		case fname == db.cfg.MsgsRoot:
+			// Message logs are sealed line by line in sealMsgLogs.
+		case entry.IsDir():
+			err = db.sealStorageDir(fname)
+		case db.isJsonRecordsFile(fname):
+			err = db.resealJsonRecordsFile(fname)
+		default:
+			err = db.resealFile(fname)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sealMsgLogs encrypts the lines of the message logs that are not yet
+// encrypted.
+func (db *DB) sealMsgLogs() error {
+	if db.cfg.MsgsRoot == "" {
+		return nil
+	}
+	fnames, err := filepath.Glob(filepath.Join(db.cfg.MsgsRoot, "*.log"))
+	if err != nil {
+		return err
+	}
+	for _, fname := range fnames {
+		data, err := os.ReadFile(fname)
+		if err != nil {
+			return err
+		}
+		if data, err = db.sealLogLines(data); err != nil {
+			return err
+		}
+		if err := os.WriteFile(fname, data, 0o600); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sealExistingData encrypts the data that was stored before db encryption was
+// enabled: the inidb records that hold key material, every file of the db
+// storage and the message logs. Data that is already encrypted is skipped, so
+// it may be called again after an interrupted attempt.
+func (db *DB) sealExistingData() error {
+	// Local and server identities.
+	for _, k := range []string{"myidentity", "serveridentity", "servercert"} {
+		v, err := db.idb.Get("", k)
+		if err != nil || strings.HasPrefix(v, sealedStrPrefix) {
+			continue
+		}
+		if v, err = db.sealString(v); err != nil {
+			return err
+		}
+		if err := db.idb.Set("", k, v); err != nil {
+			return err
+		}
+	}
+	if err := db.idb.Save(); err != nil {
+		return err
+	}
+
+	// GC invites.
+	for k, v := range db.invites.Records(invitesTable) {
+		if strings.HasPrefix(v, sealedStrPrefix) {
+			continue
+		}
+		sv, err := db.sealString(v)
+		if err != nil {
+			return err
+		}
+		if err := db.invites.Set(invitesTable, k, sv); err != nil {
+			return err
+		}
+	}
+	if err := db.invites.Save(); err != nil {
+		return err
+	}
+
+	if err := db.sealStorageDir(db.root); err != nil {
+		return err
+	}
+	return db.sealMsgLogs()
+}
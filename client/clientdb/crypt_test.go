@@ -0,0 +1,283 @@
+package clientdb
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/companyzero/bisonrelay/internal/assert"
+	"github.com/companyzero/bisonrelay/rpc"
+	"github.com/companyzero/bisonrelay/zkidentity"
+)
+
+// testSecret is stored in messages and posts of test dbs to check whether it
+// is written in plain text.
+const testSecret = "confidentialword"
+
+// newCryptTestDB creates (but does not run) a db of the given driver.
+func newCryptTestDB(t testing.TB, driver, root, msgsRoot string, encrypt bool) *DB {
+	t.Helper()
+	db, err := New(Config{
+		Root:          root,
+		MsgsRoot:      msgsRoot,
+		DownloadsRoot: filepath.Join(root, "downloads"),
+		Driver:        driver,
+		Encrypt:       encrypt,
+	})
+	assert.NilErr(t, err)
+	return db
+}
+
+// writeCryptTestData stores a PM, a post and a post comment that contain
+// testSecret. It returns the id of the user that sent them.
+func writeCryptTestData(t testing.TB, db *DB) UserID {
+	t.Helper()
+	id, err := zkidentity.New("bob", "bob")
+	assert.NilErr(t, err)
+	uid := id.Public.Identity
+	pid := PostID{0x01}
+	post := rpc.PostMetadata{
+		Version: rpc.PostMetadataVersion,
+		Attributes: map[string]string{
+			rpc.RMPIdentifier: pid.String(),
+			rpc.RMPMain:       "post " + testSecret,
+		},
+	}
+	comment := rpc.PostMetadataStatus{
+		Version: rpc.PostMetadataStatusVersion,
+		From:    uid.String(),
+		Link:    pid.String(),
+		Attributes: map[string]string{
+			rpc.RMPSComment: "comment " + testSecret,
+		},
+	}
+	err = db.Update(context.Background(), func(tx ReadWriteTx) error {
+		err := db.UpdateAddressBookEntry(tx, &id.Public, RawRVID{}, RawRVID{}, false)
+		if err != nil {
+			return err
+		}
+		err = db.LogPM(tx, uid, HistoryEntry{
+			Timestamp: time.Now(),
+			From:      "bob",
+			FromUID:   uid,
+			Message:   "pm " + testSecret,
+			MsgID:     zkidentity.ShortID{0x02},
+		})
+		if err != nil {
+			return err
+		}
+		if _, _, err := db.SaveReceivedPost(tx, uid, post); err != nil {
+			return err
+		}
+		if err := db.AddPostStatus(tx, uid, uid, pid, &comment); err != nil {
+			return err
+		}
+		return db.SubscribeToPosts(tx, uid)
+	})
+	assert.NilErr(t, err)
+	return uid
+}
+
+// assertCryptTestData asserts the data written by writeCryptTestData can be
+// read from the db.
+func assertCryptTestData(t testing.TB, db *DB, uid UserID) {
+	t.Helper()
+	err := db.View(context.Background(), func(tx ReadTx) error {
+		history, err := db.ReadPMHistory(tx, uid, 0, 0)
+		if err != nil {
+			return err
+		}
+		assert.DeepEqual(t, len(history), 1)
+		assert.DeepEqual(t, history[0].Message, "pm "+testSecret)
+
+		post, err := db.ReadPost(tx, uid, PostID{0x01})
+		if err != nil {
+			return err
+		}
+		assert.DeepEqual(t, post.Attributes[rpc.RMPMain], "post "+testSecret)
+		statuses, err := db.ListPostStatusUpdates(tx, uid, PostID{0x01})
+		if err != nil {
+			return err
+		}
+		assert.DeepEqual(t, len(statuses), 1)
+		subs, err := db.ListPostSubscribers(tx)
+		if err != nil {
+			return err
+		}
+		assert.DeepEqual(t, subs, []UserID{uid})
+
+		res, err := db.Search(tx, testSecret, SearchFilters{})
+		if err != nil {
+			return err
+		}
+		assert.DeepEqual(t, len(res), 3)
+		return nil
+	})
+	assert.NilErr(t, err)
+}
+
+// findPlaintext returns the name of the files of the db storage on root and
+// of the message logs that contain testSecret in plain text.
+func findPlaintext(t testing.TB, driver, root, msgsRoot string) []string {
+	t.Helper()
+	s, err := openStorage(driver, root)
+	assert.NilErr(t, err)
+	defer s.Close()
+
+	var res []string
+	var walk func(dir string)
+	walk = func(dir string) {
+		entries, err := s.ReadDir(dir)
+		assert.NilErr(t, err)
+		for _, entry := range entries {
+			fname := filepath.Join(dir, entry.Name())
+			if entry.IsDir() {
+				walk(fname)
+				continue
+			}
+			data, err := s.ReadFile(fname)
+			assert.NilErr(t, err)
+			if bytes.Contains(data, []byte(testSecret)) {
+				res = append(res, fname)
+			}
+		}
+	}
+	walk(root)
+
+	logs, err := filepath.Glob(filepath.Join(msgsRoot, "*.log"))
+	assert.NilErr(t, err)
+	for _, fname := range logs {
+		data, err := os.ReadFile(fname)
+		assert.NilErr(t, err)
+		if bytes.Contains(data, []byte(testSecret)) {
+			res = append(res, fname)
+		}
+	}
+	return res
+}
+
+// TestEncryptedDB tests that the data of an encrypted db is not stored in
+// plain text and that it can only be read after unlocking the db with the
+// right passphrase.
+func TestEncryptedDB(t *testing.T) {
+	for _, driver := range testDrivers {
+		driver := driver
+		t.Run(driver, func(t *testing.T) {
+			t.Parallel()
+			root, msgsRoot := t.TempDir(), t.TempDir()
+			passphrase := []byte("passphrase")
+
+			db := newCryptTestDB(t, driver, root, msgsRoot, true)
+			assert.BoolIs(t, db.NeedsUnlock(), true)
+			assert.NilErr(t, db.UnlockDB(passphrase))
+			assert.BoolIs(t, db.IsEncrypted(), true)
+			stop := runTestDB(t, db)
+			uid := writeCryptTestData(t, db)
+			assertCryptTestData(t, db, uid)
+			stop()
+			assert.DeepEqual(t, findPlaintext(t, driver, root, msgsRoot), []string(nil))
+
+			// The db must be unlocked with the same passphrase,
+			// even if encryption is not requested.
+			db = newCryptTestDB(t, driver, root, msgsRoot, false)
+			assert.BoolIs(t, db.NeedsUnlock(), true)
+			assert.ErrorIs(t, db.UnlockDB([]byte("wrong")), ErrWrongPassphrase)
+			assert.BoolIs(t, db.NeedsUnlock(), true)
+			assert.NilErr(t, db.UnlockDB(passphrase))
+			assert.BoolIs(t, db.NeedsUnlock(), false)
+			stop = runTestDB(t, db)
+			assertCryptTestData(t, db, uid)
+			stop()
+		})
+	}
+}
+
+// TestEncryptExistingDB tests that enabling encryption on an existing db
+// encrypts the data that was stored before.
+func TestEncryptExistingDB(t *testing.T) {
+	for _, driver := range testDrivers {
+		driver := driver
+		t.Run(driver, func(t *testing.T) {
+			t.Parallel()
+			root, msgsRoot := t.TempDir(), t.TempDir()
+
+			db := newCryptTestDB(t, driver, root, msgsRoot, false)
+			assert.BoolIs(t, db.NeedsUnlock(), false)
+			stop := runTestDB(t, db)
+			uid := writeCryptTestData(t, db)
+			stop()
+			if len(findPlaintext(t, driver, root, msgsRoot)) == 0 {
+				t.Fatalf("test data was not stored in plain text")
+			}
+
+			db = newCryptTestDB(t, driver, root, msgsRoot, true)
+			assert.BoolIs(t, db.NeedsUnlock(), true)
+			assert.NilErr(t, db.UnlockDB([]byte("passphrase")))
+			stop = runTestDB(t, db)
+			assertCryptTestData(t, db, uid)
+			stop()
+			assert.DeepEqual(t, findPlaintext(t, driver, root, msgsRoot), []string(nil))
+		})
+	}
+}
+
+// TestResumeEncryptExistingDB tests that enabling encryption may be resumed
+// after it was interrupted before the key file was written.
+func TestResumeEncryptExistingDB(t *testing.T) {
+	for _, driver := range testDrivers {
+		driver := driver
+		t.Run(driver, func(t *testing.T) {
+			t.Parallel()
+			root, msgsRoot := t.TempDir(), t.TempDir()
+			passphrase := []byte("passphrase")
+
+			localID, err := zkidentity.New("alice", "alice")
+			assert.NilErr(t, err)
+			db := newCryptTestDB(t, driver, root, msgsRoot, false)
+			stop := runTestDB(t, db)
+			uid := writeCryptTestData(t, db)
+			err = db.Update(context.Background(), func(tx ReadWriteTx) error {
+				return db.UpdateLocalID(tx, localID)
+			})
+			assert.NilErr(t, err)
+			stop()
+
+			db = newCryptTestDB(t, driver, root, msgsRoot, true)
+			assert.NilErr(t, db.UnlockDB(passphrase))
+			stop = runTestDB(t, db)
+			stop()
+
+			// Simulate the encryption being interrupted after the
+			// data was sealed but before the key file was renamed.
+			keyFname := filepath.Join(root, dbKeyFile)
+			tmpKeyFname := filepath.Join(root, dbKeyTmpFile)
+			assert.NilErr(t, os.Rename(keyFname, tmpKeyFname))
+
+			// The db must still be unlocked with the same
+			// passphrase, even if encryption is not requested.
+			db = newCryptTestDB(t, driver, root, msgsRoot, false)
+			assert.BoolIs(t, db.IsEncrypted(), true)
+			assert.BoolIs(t, db.NeedsUnlock(), true)
+			assert.ErrorIs(t, db.UnlockDB([]byte("wrong")), ErrWrongPassphrase)
+			assert.NilErr(t, db.UnlockDB(passphrase))
+			assert.BoolIs(t, fileExists(keyFname), true)
+			assert.BoolIs(t, fileExists(tmpKeyFname), false)
+			stop = runTestDB(t, db)
+			assertCryptTestData(t, db, uid)
+			err = db.View(context.Background(), func(tx ReadTx) error {
+				gotID, err := db.LocalID(tx)
+				if err != nil {
+					return err
+				}
+				assert.DeepEqual(t, gotID.Public, localID.Public)
+				return nil
+			})
+			assert.NilErr(t, err)
+			stop()
+			assert.DeepEqual(t, findPlaintext(t, driver, root, msgsRoot), []string(nil))
+		})
+	}
+}
@@ -0,0 +1,217 @@
+package clientdb
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/companyzero/bisonrelay/zkidentity"
+)
+
+const (
+	devicesDir     = "devices"
+	deviceLinksDir = "links"
+)
+
+func (db *DB) deviceLinkFname(id zkidentity.ShortID) string {
+	return filepath.Join(db.root, devicesDir, deviceLinksDir, id.String()+".json")
+}
+
+// SaveDeviceLink saves the given device link.
+func (db *DB) SaveDeviceLink(tx ReadWriteTx, link *DeviceLink) error {
+	return db.saveJsonFile(db.deviceLinkFname(link.DeviceID), link)
+}
+
+// GetDeviceLink returns the device link with the given id.
+func (db *DB) GetDeviceLink(tx ReadTx, id zkidentity.ShortID) (*DeviceLink, error) {
+	link := new(DeviceLink)
+	if err := db.readJsonFile(db.deviceLinkFname(id), link); err != nil {
+		return nil, err
+	}
+	return link, nil
+}
+
+// ListDeviceLinks lists all device links. On a linked device, this returns a
+// single link to the primary device.
+func (db *DB) ListDeviceLinks(tx ReadTx) ([]DeviceLink, error) {
+	dir := filepath.Join(db.root, devicesDir, deviceLinksDir)
+	entries, err := db.fs().ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]DeviceLink, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		var link DeviceLink
+		fname := filepath.Join(dir, entry.Name())
+		if err := db.readJsonFile(fname, &link); err != nil {
+			db.log.Warnf("Unable to read device link %s: %v", fname, err)
+			continue
+		}
+		res = append(res, link)
+	}
+	return res, nil
+}
+
+// RemoveDeviceLink removes the device link with the given id.
+func (db *DB) RemoveDeviceLink(tx ReadWriteTx, id zkidentity.ShortID) error {
+	fname := db.deviceLinkFname(id)
+	if !db.exists(fname) {
+		return ErrNotFound
+	}
+	return db.fs().Remove(fname)
+}
+
+// PrimaryDeviceLink returns the link to the primary device when this db is of
+// a linked device. It returns ErrNotFound otherwise.
+func (db *DB) PrimaryDeviceLink(tx ReadTx) (*DeviceLink, error) {
+	links, err := db.ListDeviceLinks(tx)
+	if err != nil {
+		return nil, err
+	}
+	for i := range links {
+		if links[i].Primary {
+			return &links[i], nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// LoadLinkedAddressBook returns the address book of a linked device. Linked
+// devices do not have ratchets with remote users, so the entries do not have
+// the ratchet set.
+func (db *DB) LoadLinkedAddressBook(tx ReadTx) ([]*AddressBookEntry, error) {
+	entries, err := db.fs().ReadDir(filepath.Join(db.root, inboundDir))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]*AddressBookEntry, 0, len(entries))
+	var id UserID
+	for _, v := range entries {
+		if err := id.FromString(v.Name()); err != nil {
+			db.log.Warnf("Unable to identify addressbook entry %s: %v",
+				v.Name(), err)
+			continue
+		}
+		entry, err := db.getBaseABEntry(id)
+		if err != nil {
+			db.log.Warnf("Unable to load addressbook entry %s: %v",
+				id, err)
+			continue
+		}
+		res = append(res, entry)
+	}
+	return res, nil
+}
+
+// isPairingFile returns true if the storage file with the given name (relative
+// to the db root) is sent to newly paired devices.
+func isPairingFile(name string) bool {
+	parts := strings.Split(name, "/")
+	switch parts[0] {
+	case inboundDir:
+		return len(parts) == 3 && parts[2] == identityFilename
+	case groupchatDir, postsDir:
+		return true
+	default:
+		return false
+	}
+}
+
+// ExportDevicePairing writes a pairing file for the given (already saved) link
+// to w. The pairing file has the same format as a backup and is encrypted with
+// a key derived from the passphrase. It has the local identity, the public
+// identity of every remote user, the GCs and the posts, but not the ratchets,
+// which are kept only in the primary device.
+//
+// As in backups, files are stored as they are stored in the db, therefore if
+// the db is encrypted at rest, the linked device is unlocked with the same db
+// passphrase.
+func (db *DB) ExportDevicePairing(tx ReadTx, w io.Writer, link *DeviceLink,
+	passphrase []byte) error {
+
+	if len(passphrase) == 0 {
+		return errEmptyPassphrase
+	}
+	if _, err := db.PrimaryDeviceLink(tx); err == nil {
+		return errNotPrimaryDevice
+	}
+
+	var files []backupFile
+	addFile := func(name string, data []byte) error {
+		rel, err := filepath.Rel(db.root, name)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if isBackupFSFile(rel) || isPairingFile(rel) {
+			files = append(files, backupFile{Name: rel, Data: data})
+		}
+		return nil
+	}
+
+	for _, fname := range []string{path.Join(zkcServerDir, zkcServerFile), dbKeyFile} {
+		fname = backupFileName(db.root, fname)
+		data, err := os.ReadFile(fname)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := addFile(fname, data); err != nil {
+			return err
+		}
+	}
+	err := walkStorageFiles(db.fs(), db.root, db.isFSOnlyFile, addFile)
+	if err != nil {
+		return fmt.Errorf("unable to read db files: %v", err)
+	}
+
+	// Link to this (primary) device.
+	primaryLink := *link
+	primaryLink.Primary = true
+	primaryLink.SendSeq, primaryLink.RecvSeq = 0, 0
+	linkData, err := json.Marshal(primaryLink)
+	if err != nil {
+		return err
+	}
+	if linkData, err = db.sealData(linkData); err != nil {
+		return err
+	}
+	files = append(files, backupFile{
+		Name: path.Join(devicesDir, deviceLinksDir, link.DeviceID.String()+".json"),
+		Data: linkData,
+	})
+
+	return db.writeBackup(w, files, passphrase)
+}
+
+// ImportDevicePairing decrypts a pairing file created with ExportDevicePairing
+// and stages it to be restored. As with ImportBackup, the pairing replaces all
+// the existing db state the next time the db is opened with New().
+func (db *DB) ImportDevicePairing(r io.Reader, passphrase []byte) error {
+	linksDir := path.Join(devicesDir, deviceLinksDir)
+	return db.stageRestore(r, passphrase, func(files []backupFile) error {
+		for _, f := range files {
+			if path.Dir(f.Name) == linksDir {
+				return nil
+			}
+		}
+		return fmt.Errorf("%w: file is not a device pairing", errInvalidBackup)
+	})
+}
@@ -2,4 +2,20 @@ package clientdb
 
 import "errors"
 
-var errCreateLockFile = errors.New("unable to create lock file")
+var (
+	errCreateLockFile         = errors.New("unable to create lock file")
+	errEmptyPassphrase        = errors.New("empty passphrase")
+	errAlreadyUnlocked        = errors.New("db is already unlocked")
+	errDBLocked               = errors.New("db is locked")
+	errSealedDataTooShort     = errors.New("sealed data is too short")
+	errUnableToOpenSealedData = errors.New("unable to decrypt sealed data")
+	errNotADir                = errors.New("not a directory")
+	errIsADir                 = errors.New("is a directory")
+	errDirNotEmpty            = errors.New("directory not empty")
+	errOutsideRoot            = errors.New("path is outside db root")
+	errNotABackup             = errors.New("file is not a client backup")
+	errBackupTooNew           = errors.New("backup version is not supported")
+	errWrongBackupPass        = errors.New("wrong backup passphrase or corrupted backup")
+	errInvalidBackup          = errors.New("invalid backup file")
+	errNotPrimaryDevice       = errors.New("only the primary device may pair new devices")
+)
@@ -16,6 +16,7 @@ import (
 	"github.com/companyzero/bisonrelay/internal/strescape"
 	"github.com/companyzero/bisonrelay/ratchet"
 	"github.com/companyzero/bisonrelay/ratchet/disk"
+	"github.com/companyzero/bisonrelay/rpc"
 	"github.com/companyzero/bisonrelay/zkidentity"
 )
 
@@ -53,6 +54,10 @@ func (db *DB) LocalID(tx ReadTx) (*zkidentity.FullIdentity, error) {
 	} else if err != nil {
 		return nil, fmt.Errorf("could not obtain myidentity record")
 	}
+	myidb64, err = db.openString(myidb64)
+	if err != nil {
+		return nil, fmt.Errorf("could not decrypt myidentity: %w", err)
+	}
 	myidJSON, err := base64.StdEncoding.DecodeString(myidb64)
 	if err != nil {
 		return nil, fmt.Errorf("could not decode myidentity")
@@ -72,8 +77,11 @@ func (db *DB) UpdateLocalID(tx ReadWriteTx, id *zkidentity.FullIdentity) error {
 		return fmt.Errorf("Could not marshal identity: %v", err)
 	}
 
-	err = db.idb.Set("", "myidentity",
-		base64.StdEncoding.EncodeToString(myid))
+	myidb64, err := db.sealString(base64.StdEncoding.EncodeToString(myid))
+	if err != nil {
+		return fmt.Errorf("could not encrypt myidentity: %v", err)
+	}
+	err = db.idb.Set("", "myidentity", myidb64)
 	if err != nil {
 		return fmt.Errorf("could not insert record myidentity")
 	}
@@ -107,6 +115,12 @@ func (db *DB) ServerID(tx ReadTx) ([]byte, zkidentity.PublicIdentity, error) {
 	if err != nil {
 		return fail(fmt.Errorf("could not obtain serveridentity record"))
 	}
+	if pib64, err = db.openString(pib64); err != nil {
+		return fail(fmt.Errorf("could not decrypt serveridentity: %w", err))
+	}
+	if pc64, err = db.openString(pc64); err != nil {
+		return fail(fmt.Errorf("could not decrypt servercert: %w", err))
+	}
 	piJSON, err := base64.StdEncoding.DecodeString(pib64)
 	if err != nil {
 		return fail(fmt.Errorf("could not decode serveridentity"))
@@ -131,13 +145,19 @@ func (db *DB) UpdateServerID(tx ReadWriteTx, tlsCert []byte, pid *zkidentity.Pub
 	if err != nil {
 		return fmt.Errorf("Could not marshal server identity: %v", err)
 	}
-	err = db.idb.Set("", "serveridentity",
-		base64.StdEncoding.EncodeToString(b))
+	pib64, err := db.sealString(base64.StdEncoding.EncodeToString(b))
+	if err != nil {
+		return fmt.Errorf("could not encrypt serveridentity: %v", err)
+	}
+	pc64, err := db.sealString(base64.StdEncoding.EncodeToString(tlsCert))
+	if err != nil {
+		return fmt.Errorf("could not encrypt servercert: %v", err)
+	}
+	err = db.idb.Set("", "serveridentity", pib64)
 	if err != nil {
 		return fmt.Errorf("could not insert record serveridentity: %v", err)
 	}
-	err = db.idb.Set("", "servercert",
-		base64.StdEncoding.EncodeToString(tlsCert))
+	err = db.idb.Set("", "servercert", pc64)
 	if err != nil {
 		return fmt.Errorf("could not insert record servercert: %v", err)
 	}
@@ -155,35 +175,21 @@ func (db *DB) UpdateRatchet(tx ReadWriteTx, r *ratchet.Ratchet, theirID zkidenti
 	if err != nil {
 		return fmt.Errorf("failed to marshal ratchet: %v", err)
 	}
+	jsonState, err = db.sealData(jsonState)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt ratchet: %v", err)
+	}
 
-	// save to tempfile
 	ids := hex.EncodeToString(theirID[:])
 	fullPath := filepath.Join(db.root, inboundDir, ids)
 
-	if err := os.MkdirAll(fullPath, 0o700); err != nil {
+	if err := db.fs().MkdirAll(fullPath); err != nil {
 		return fmt.Errorf("could not create ratchet dir: %v", err)
 	}
 
-	f, err := os.CreateTemp(fullPath, ratchetFilename)
-	if err != nil {
-		return fmt.Errorf("could not create ratchet file: %v", err)
-	}
-	if _, err = f.Write(jsonState); err != nil {
-		f.Close()
-		return fmt.Errorf("failed to write ratchet: %v", err)
-	}
-	if err = f.Sync(); err != nil {
-		f.Close()
-		return fmt.Errorf("unable to fsync ratchet data: %v", err)
-	}
-	if err = f.Close(); err != nil {
-		return fmt.Errorf("failed to close temporary ratchet file: %v", err)
-	}
-
-	// rename tempfile to actual file
 	filename := filepath.Join(fullPath, ratchetFilename)
-	if err = os.Rename(f.Name(), filename); err != nil {
-		return fmt.Errorf("could not rename ratchet file: %v", err)
+	if err := db.fs().WriteFile(filename, jsonState); err != nil {
+		return fmt.Errorf("failed to write ratchet: %v", err)
 	}
 
 	return nil
@@ -195,24 +201,29 @@ func (db *DB) UpdateAddressBookEntry(tx ReadWriteTx,
 	// make identity dirs
 	ids := hex.EncodeToString(id.Identity[:])
 	fullPath := filepath.Join(db.root, inboundDir, ids)
-	err := os.MkdirAll(fullPath, 0o700)
+	err := db.fs().MkdirAll(fullPath)
 	if err != nil {
 		return err
 	}
 
-	// save identity
+	// save identity, keeping the local settings of an existing entry.
 	ab := AddressBookEntry{
 		ID:           id,
 		MyResetRV:    myResetRV,
 		TheirResetRV: theirResetRV,
 		Ignored:      ignored,
 	}
+	if old, err := db.getBaseABEntry(id.Identity); err == nil {
+		ab.Retention = old.Retention
+		ab.RetentionSince = old.RetentionSince
+		ab.NoReceipts = old.NoReceipts
+	}
 	blob, err := json.Marshal(ab)
 	if err != nil {
 		return fmt.Errorf("unable to marshal AddressBookEntry: %v", err)
 	}
 	filename := filepath.Join(fullPath, identityFilename)
-	err = os.WriteFile(filename, blob, 0o600)
+	err = db.writeFile(filename, blob)
 	if err != nil {
 		return fmt.Errorf("write to %v: %v", filename, err)
 	}
@@ -223,8 +234,7 @@ func (db *DB) UpdateAddressBookEntry(tx ReadWriteTx,
 func (db *DB) AddressBookEntryExists(tx ReadTx, id UserID) bool {
 	fname := filepath.Join(db.root, inboundDir, id.String(),
 		identityFilename)
-	_, err := os.Stat(fname)
-	return err == nil
+	return db.exists(fname)
 }
 
 // getBaseABEntry returns the base address book entry, without the ratchet info
@@ -232,7 +242,7 @@ func (db *DB) AddressBookEntryExists(tx ReadTx, id UserID) bool {
 func (db *DB) getBaseABEntry(id UserID) (*AddressBookEntry, error) {
 	filename := filepath.Join(db.root, inboundDir, id.String(),
 		identityFilename)
-	blob, err := os.ReadFile(filename)
+	blob, err := db.readFile(filename)
 	if os.IsNotExist(err) {
 		return nil, fmt.Errorf("identity file %s: %w", id.String(), ErrNotFound)
 	}
@@ -252,6 +262,40 @@ func (db *DB) getBaseABEntry(id UserID) (*AddressBookEntry, error) {
 	return entry, nil
 }
 
+// updateBaseABEntry calls f to modify the base address book entry of the given
+// user and saves the modified entry.
+func (db *DB) updateBaseABEntry(id UserID, f func(entry *AddressBookEntry)) error {
+	entry, err := db.getBaseABEntry(id)
+	if err != nil {
+		return err
+	}
+	f(entry)
+	blob, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("unable to marshal AddressBookEntry: %v", err)
+	}
+	filename := filepath.Join(db.root, inboundDir, id.String(), identityFilename)
+	return db.writeFile(filename, blob)
+}
+
+// SetUserReceipts sets whether delivery and read receipts of PMs are sent to
+// the given user.
+func (db *DB) SetUserReceipts(tx ReadWriteTx, id UserID, send bool) error {
+	return db.updateBaseABEntry(id, func(entry *AddressBookEntry) {
+		entry.NoReceipts = !send
+	})
+}
+
+// UserReceipts returns whether delivery and read receipts of PMs are sent to
+// the given user.
+func (db *DB) UserReceipts(tx ReadTx, id UserID) (bool, error) {
+	entry, err := db.getBaseABEntry(id)
+	if err != nil {
+		return false, err
+	}
+	return !entry.NoReceipts, nil
+}
+
 func (db *DB) GetAddressBookEntry(tx ReadTx, id UserID,
 	localID *zkidentity.FullIdentity) (*AddressBookEntry, error) {
 
@@ -262,7 +306,7 @@ func (db *DB) GetAddressBookEntry(tx ReadTx, id UserID,
 
 	// Read Ratchet.
 	filename := filepath.Join(db.root, inboundDir, id.String(), ratchetFilename)
-	ratchetJSON, err := os.ReadFile(filename)
+	ratchetJSON, err := db.readFile(filename)
 	if err != nil {
 		return nil, fmt.Errorf("ReadFile ratchet: %v", err)
 	}
@@ -294,7 +338,7 @@ func (db *DB) GetAddressBookEntry(tx ReadTx, id UserID,
 // otherwise incomplete entries do not cause the addressbook loading to fail,
 // only diagnostic messages are returned in that case.
 func (db *DB) LoadAddressBook(tx ReadTx, localID *zkidentity.FullIdentity) ([]*AddressBookEntry, error) {
-	fi, err := os.ReadDir(filepath.Join(db.root, inboundDir))
+	fi, err := db.fs().ReadDir(filepath.Join(db.root, inboundDir))
 	if err != nil {
 		return nil, err
 	}
@@ -330,27 +374,22 @@ func (db *DB) StoreTransResetHalfKX(tx ReadWriteTx, r *ratchet.Ratchet, theirID
 	if err != nil {
 		return fmt.Errorf("failed to marshal ratchet: %v", err)
 	}
+	jsonState, err = db.sealData(jsonState)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt ratchet: %v", err)
+	}
 
 	ids := theirID.String()
 	dir := filepath.Join(db.root, inboundDir, ids)
-	if err := os.MkdirAll(dir, 0o700); err != nil {
+	if err := db.fs().MkdirAll(dir); err != nil {
 		return fmt.Errorf("could not create trans reset dir: %v", err)
 	}
 
 	filename := filepath.Join(dir, transResetFile)
-	f, err := os.Create(filename)
-	if err != nil {
-		return err
-	}
-	if _, err = f.Write(jsonState); err != nil {
-		f.Close()
+	if err := db.fs().WriteFile(filename, jsonState); err != nil {
 		return fmt.Errorf("could not write ratchet: %v", err)
 	}
-	if err = f.Sync(); err != nil {
-		f.Close()
-		return fmt.Errorf("unable to fsync ratchet data: %v", err)
-	}
-	return f.Close()
+	return nil
 }
 
 // LoadTransResetHalfKX returns the existing trans reset half kx.
@@ -360,7 +399,7 @@ func (db *DB) LoadTransResetHalfKX(tx ReadTx, id UserID,
 	// Read Ratchet.
 	dir := filepath.Join(db.root, inboundDir, id.String())
 	filename := filepath.Join(dir, transResetFile)
-	ratchetJSON, err := os.ReadFile(filename)
+	ratchetJSON, err := db.readFile(filename)
 	if err != nil {
 		return nil, fmt.Errorf("ReadFile ratchet: %v", err)
 	}
@@ -398,7 +437,7 @@ func (db *DB) LoadTransResetHalfKX(tx ReadTx, id UserID,
 func (db *DB) DeleteTransResetHalfKX(tx ReadWriteTx, id UserID) error {
 	dir := filepath.Join(db.root, inboundDir, id.String())
 	filename := filepath.Join(dir, transResetFile)
-	return os.Remove(filename)
+	return db.fs().Remove(filename)
 }
 
 func (db *DB) logMsg(logFname string, internal bool, from, msg string, ts time.Time) error {
@@ -439,7 +478,11 @@ func (db *DB) logMsg(logFname string, internal bool, from, msg string, ts time.T
 	b.WriteString(strescape.Content(msg))
 	b.WriteRune('\n')
 
-	_, err = f.Write(b.Bytes())
+	data, err := db.sealLogLines(b.Bytes())
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
 	if err != nil {
 		return err
 	}
@@ -466,25 +509,75 @@ func (db *DB) RemoveUser(tx ReadWriteTx, id UserID, block bool) error {
 		}
 	}
 	dir := filepath.Join(db.root, inboundDir, id.String())
-	return os.RemoveAll(dir)
+	return db.fs().RemoveAll(dir)
 }
 
-// LogPM logs a PM message from the given user.
-func (db *DB) LogPM(tx ReadWriteTx, uid UserID, internal bool, from, msg string, ts time.Time) error {
+// LogPM logs a PM message exchanged with the given user.
+func (db *DB) LogPM(tx ReadWriteTx, uid UserID, e HistoryEntry) error {
 	entry, err := db.getBaseABEntry(uid)
 	if err != nil {
 		return err
 	}
 
+	dir := filepath.Join(db.root, historyDir, historyPMDir, uid.String())
+	if err := db.appendHistoryEntry(dir, &e); err != nil {
+		return fmt.Errorf("unable to store PM history: %v", err)
+	}
+
+	// Disappearing messages are only kept in the history, from where they
+	// are removed once read. They are not indexed or written to the
+	// message logs, which can't be selectively pruned.
+	if entry.Retention.Mode == rpc.RetentionModeAfterRead {
+		return nil
+	}
+
+	if !e.Internal {
+		db.tryIndexSearchDoc(SearchResult{
+			Type:      SearchTypePM,
+			MsgID:     e.MsgID,
+			UID:       uid,
+			From:      e.From,
+			Timestamp: e.Timestamp,
+			Text:      e.Message,
+		})
+	}
+
 	nick := entry.ID.Nick
 	logFname := fmt.Sprintf("%s.%s.log", strescape.PathElement(nick), uid)
-	return db.logMsg(logFname, internal, from, msg, ts)
+	return db.logMsg(logFname, e.Internal, e.From, e.Message, e.Timestamp)
 }
 
 // LogGCMsg logs a GC message sent in the given GC.
 func (db *DB) LogGCMsg(tx ReadWriteTx, gcName string, gcID zkidentity.ShortID,
-	internal bool, from, msg string, ts time.Time) error {
+	e HistoryEntry) error {
+
+	retention, err := db.GetGCRetention(tx, gcID)
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Join(db.root, historyDir, historyGCDir, gcID.String())
+	if err := db.appendHistoryEntry(dir, &e); err != nil {
+		return fmt.Errorf("unable to store GC history: %v", err)
+	}
+
+	// Disappearing messages are only kept in the history, from where they
+	// are removed once read.
+	if retention.Mode == rpc.RetentionModeAfterRead {
+		return nil
+	}
+
+	if !e.Internal {
+		db.tryIndexSearchDoc(SearchResult{
+			Type:      SearchTypeGC,
+			MsgID:     e.MsgID,
+			GCID:      gcID,
+			From:      e.From,
+			Timestamp: e.Timestamp,
+			Text:      e.Message,
+		})
+	}
 
 	logFname := fmt.Sprintf("groupchat.%s.%s.log", strescape.PathElement(gcName), gcID)
-	return db.logMsg(logFname, internal, from, msg, ts)
+	return db.logMsg(logFname, e.Internal, e.From, e.Message, e.Timestamp)
 }
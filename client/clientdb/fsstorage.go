@@ -0,0 +1,469 @@
+package clientdb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// fsStorage is the storage that keeps each DB file as an individual file in
+// the filesystem.
+//
+// Changes performed in a tx are applied as soon as they are performed, after
+// recording how to undo them in a journal inside the DB root. Rolling back a
+// tx undoes the changes in reverse order and committing it removes the
+// journal. A journal left behind by an interrupted tx is rolled back when the
+// storage is opened.
+type fsStorage struct {
+	root string
+}
+
+// openFSStorage opens the filesystem storage of the DB rooted at root, rolling
+// back any interrupted tx.
+func openFSStorage(root string) (*fsStorage, error) {
+	s := &fsStorage{root: root}
+	if err := s.recoverJournal(); err != nil {
+		return nil, fmt.Errorf("unable to rollback interrupted tx: %w", err)
+	}
+	return s, nil
+}
+
+func (s *fsStorage) ReadFile(name string) ([]byte, error) {
+	return os.ReadFile(name)
+}
+
+// WriteFile writes the data to a temp file, then renames the temp file to the
+// passed filename.
+func (s *fsStorage) WriteFile(name string, data []byte) error {
+	dir := filepath.Dir(name)
+	tempFname := filepath.Join(dir, "."+filepath.Base(name)+".new")
+
+	f, err := os.Create(tempFname)
+	if err != nil {
+		return fmt.Errorf("unable to create temp file: %w", err)
+	}
+
+	// From this point on, there are no more early returns, so that the
+	// temp file is removed in case of errors.
+
+	_, err = f.Write(data)
+	if err != nil {
+		err = fmt.Errorf("unable to write temp file: %w", err)
+	}
+	if err == nil {
+		err = f.Sync()
+		if err != nil {
+			err = fmt.Errorf("unable to fsync temp file: %w", err)
+		}
+	}
+	if closeErr := f.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("unable to close temp file: %w", closeErr)
+	}
+	if err == nil {
+		err = os.Rename(tempFname, name)
+		if err != nil {
+			err = fmt.Errorf("unable to rename temp file to final file: %w", err)
+		}
+	}
+	if err != nil {
+		os.Remove(tempFname)
+	}
+	return err
+}
+
+func (s *fsStorage) AppendFile(name string, data []byte) error {
+	f, err := os.OpenFile(name, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (s *fsStorage) Stat(name string) (fs.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (s *fsStorage) ReadDir(name string) ([]fs.DirEntry, error) {
+	return os.ReadDir(name)
+}
+
+func (s *fsStorage) ReadDirFiles(name string) ([]storedFile, error) {
+	entries, err := os.ReadDir(name)
+	if err != nil {
+		return nil, err
+	}
+	var res []storedFile
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		info, err := entry.Info()
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		data, err := os.ReadFile(filepath.Join(name, entry.Name()))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, storedFile{info: info, data: data})
+	}
+	return res, nil
+}
+
+func (s *fsStorage) Glob(pattern string) ([]string, error) {
+	return filepath.Glob(pattern)
+}
+
+func (s *fsStorage) MkdirAll(name string) error {
+	return os.MkdirAll(name, 0o700)
+}
+
+func (s *fsStorage) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func (s *fsStorage) RemoveAll(name string) error {
+	return os.RemoveAll(name)
+}
+
+func (s *fsStorage) Begin(ctx context.Context) (storageTx, error) {
+	return &fsStorageTx{s: s}, nil
+}
+
+func (s *fsStorage) Close() error {
+	return nil
+}
+
+func (s *fsStorage) journalDir() string {
+	return filepath.Join(s.root, fsTxJournalDir)
+}
+
+func (s *fsStorage) journalFname() string {
+	return filepath.Join(s.journalDir(), fsTxJournalFile)
+}
+
+// recoverJournal rolls back the changes of a tx that was interrupted before
+// being committed or rolled back.
+func (s *fsStorage) recoverJournal() error {
+	if s.root == "" {
+		return nil
+	}
+	f, err := os.Open(s.journalFname())
+	if os.IsNotExist(err) {
+		// Either no interrupted tx or one interrupted after being
+		// committed.
+		return os.RemoveAll(s.journalDir())
+	}
+	if err != nil {
+		return err
+	}
+
+	// Records are written before their change is performed, so a partial
+	// last record means its change was not performed.
+	var records []fsUndoRecord
+	dec := json.NewDecoder(f)
+	for {
+		var rec fsUndoRecord
+		if err := dec.Decode(&rec); err != nil {
+			break
+		}
+		records = append(records, rec)
+	}
+	f.Close()
+
+	if err := undoFSRecords(records); err != nil {
+		return err
+	}
+	return os.RemoveAll(s.journalDir())
+}
+
+const (
+	fsTxJournalDir  = ".txjournal"
+	fsTxJournalFile = "journal"
+)
+
+// fsUndoRecord records how to undo a change performed in a tx of the
+// filesystem storage.
+type fsUndoRecord struct {
+	Op      string `json:"op"`
+	Path    string `json:"path"`
+	Existed bool   `json:"existed,omitempty"`
+	Size    int64  `json:"size,omitempty"`
+	Backup  string `json:"backup,omitempty"`
+}
+
+const (
+	fsUndoWrite     = "write"
+	fsUndoAppend    = "append"
+	fsUndoRemove    = "remove"
+	fsUndoRemoveDir = "removedir"
+	fsUndoRemoveAll = "removeall"
+	fsUndoMkdir     = "mkdir"
+)
+
+// undo undoes the change recorded by rec.
+func (rec *fsUndoRecord) undo() error {
+	ignoreNotExist := func(err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	switch rec.Op {
+	case fsUndoWrite:
+		if rec.Backup == "" {
+			return ignoreNotExist(os.Remove(rec.Path))
+		}
+		return ignoreNotExist(os.Rename(rec.Backup, rec.Path))
+	case fsUndoAppend:
+		if !rec.Existed {
+			return ignoreNotExist(os.Remove(rec.Path))
+		}
+		return ignoreNotExist(os.Truncate(rec.Path, rec.Size))
+	case fsUndoRemove, fsUndoRemoveAll:
+		return ignoreNotExist(os.Rename(rec.Backup, rec.Path))
+	case fsUndoRemoveDir:
+		return os.MkdirAll(rec.Path, 0o700)
+	case fsUndoMkdir:
+		return os.RemoveAll(rec.Path)
+	default:
+		return fmt.Errorf("unknown undo op %q", rec.Op)
+	}
+}
+
+// undoFSRecords undoes the changes of the records in reverse order.
+func undoFSRecords(records []fsUndoRecord) error {
+	for i := len(records) - 1; i >= 0; i-- {
+		if err := records[i].undo(); err != nil {
+			return fmt.Errorf("unable to undo %s of %s: %w",
+				records[i].Op, records[i].Path, err)
+		}
+	}
+	return nil
+}
+
+// fsStorageTx is a tx of the filesystem storage. The journal is only created
+// once the first change is performed, so read-only txs do not touch the
+// filesystem.
+type fsStorageTx struct {
+	s         *fsStorage
+	journal   *os.File
+	records   []fsUndoRecord
+	nbBackups int
+}
+
+// nextBackupFname returns the name of the file where the next backup of
+// changed files should be stored.
+func (tx *fsStorageTx) nextBackupFname() (string, error) {
+	if tx.journal == nil {
+		if err := tx.openJournal(); err != nil {
+			return "", err
+		}
+	}
+	tx.nbBackups++
+	return filepath.Join(tx.s.journalDir(), strconv.Itoa(tx.nbBackups)), nil
+}
+
+// record durably records rec in the journal. This must be called before the
+// recorded change is performed.
+func (tx *fsStorageTx) record(rec fsUndoRecord) error {
+	if tx.journal == nil {
+		if err := tx.openJournal(); err != nil {
+			return err
+		}
+	}
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.journal.Write(append(b, '\n')); err != nil {
+		return fmt.Errorf("unable to write tx journal: %w", err)
+	}
+	if err := tx.journal.Sync(); err != nil {
+		return fmt.Errorf("unable to fsync tx journal: %w", err)
+	}
+	tx.records = append(tx.records, rec)
+	return nil
+}
+
+func (tx *fsStorageTx) openJournal() error {
+	if err := os.MkdirAll(tx.s.journalDir(), 0o700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(tx.s.journalFname(), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("unable to create tx journal: %w", err)
+	}
+	tx.journal = f
+	return nil
+}
+
+// closeJournal removes the journal and the backups of the tx. Removing the
+// journal file is the point after which the tx is considered committed.
+func (tx *fsStorageTx) closeJournal() error {
+	if tx.journal == nil {
+		return nil
+	}
+	err := tx.journal.Close()
+	tx.journal = nil
+	tx.records = nil
+	if rmErr := os.Remove(tx.s.journalFname()); err == nil {
+		err = rmErr
+	}
+	if rmErr := os.RemoveAll(tx.s.journalDir()); err == nil {
+		err = rmErr
+	}
+	return err
+}
+
+func (tx *fsStorageTx) ReadFile(name string) ([]byte, error) {
+	return tx.s.ReadFile(name)
+}
+
+func (tx *fsStorageTx) WriteFile(name string, data []byte) error {
+	rec := fsUndoRecord{Op: fsUndoWrite, Path: name}
+	fi, err := os.Lstat(name)
+	switch {
+	case err == nil && fi.Mode().IsRegular():
+		// The file is replaced by a new one, so a hard link keeps
+		// the original contents.
+		if rec.Backup, err = tx.nextBackupFname(); err != nil {
+			return err
+		}
+		if err := linkOrCopyFile(name, rec.Backup); err != nil {
+			return fmt.Errorf("unable to backup file: %w", err)
+		}
+	case err == nil:
+		return tx.s.WriteFile(name, data)
+	case !os.IsNotExist(err):
+		return err
+	}
+	if err := tx.record(rec); err != nil {
+		return err
+	}
+	return tx.s.WriteFile(name, data)
+}
+
+func (tx *fsStorageTx) AppendFile(name string, data []byte) error {
+	rec := fsUndoRecord{Op: fsUndoAppend, Path: name}
+	fi, err := os.Stat(name)
+	switch {
+	case err == nil:
+		rec.Existed = true
+		rec.Size = fi.Size()
+	case !os.IsNotExist(err):
+		return err
+	}
+	if err := tx.record(rec); err != nil {
+		return err
+	}
+	return tx.s.AppendFile(name, data)
+}
+
+func (tx *fsStorageTx) Stat(name string) (fs.FileInfo, error) {
+	return tx.s.Stat(name)
+}
+
+func (tx *fsStorageTx) ReadDir(name string) ([]fs.DirEntry, error) {
+	return tx.s.ReadDir(name)
+}
+
+func (tx *fsStorageTx) ReadDirFiles(name string) ([]storedFile, error) {
+	return tx.s.ReadDirFiles(name)
+}
+
+func (tx *fsStorageTx) Glob(pattern string) ([]string, error) {
+	return tx.s.Glob(pattern)
+}
+
+func (tx *fsStorageTx) MkdirAll(name string) error {
+	// Find the topmost dir that will be created.
+	var missing string
+	for dir := filepath.Clean(name); ; dir = filepath.Dir(dir) {
+		if _, err := os.Stat(dir); err == nil {
+			break
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+		missing = dir
+		if filepath.Dir(dir) == dir {
+			break
+		}
+	}
+	if missing == "" {
+		return tx.s.MkdirAll(name)
+	}
+	if err := tx.record(fsUndoRecord{Op: fsUndoMkdir, Path: missing}); err != nil {
+		return err
+	}
+	return tx.s.MkdirAll(name)
+}
+
+func (tx *fsStorageTx) Remove(name string) error {
+	fi, err := os.Lstat(name)
+	if err != nil {
+		return err
+	}
+	if fi.IsDir() {
+		if err := tx.record(fsUndoRecord{Op: fsUndoRemoveDir, Path: name}); err != nil {
+			return err
+		}
+		return tx.s.Remove(name)
+	}
+
+	// Removing is done by moving the file to the journal dir.
+	backup, err := tx.nextBackupFname()
+	if err != nil {
+		return err
+	}
+	rec := fsUndoRecord{Op: fsUndoRemove, Path: name, Backup: backup}
+	if err := tx.record(rec); err != nil {
+		return err
+	}
+	return os.Rename(name, rec.Backup)
+}
+
+func (tx *fsStorageTx) RemoveAll(name string) error {
+	if _, err := os.Lstat(name); os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	// Removing is done by moving the file or dir to the journal dir.
+	backup, err := tx.nextBackupFname()
+	if err != nil {
+		return err
+	}
+	rec := fsUndoRecord{Op: fsUndoRemoveAll, Path: name, Backup: backup}
+	if err := tx.record(rec); err != nil {
+		return err
+	}
+	return os.Rename(name, rec.Backup)
+}
+
+func (tx *fsStorageTx) Commit() error {
+	return tx.closeJournal()
+}
+
+func (tx *fsStorageTx) Rollback() error {
+	if err := undoFSRecords(tx.records); err != nil {
+		return err
+	}
+	return tx.closeJournal()
+}
@@ -8,6 +8,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/companyzero/bisonrelay/inidb"
 	"github.com/companyzero/bisonrelay/rpc"
@@ -18,6 +19,9 @@ const (
 	gcAliasesFile  = "gcaliases.json"
 	invitesTable   = "invites"
 	gcBlockListExt = ".blocklist"
+	gcRetentionExt = ".retention"
+	gcMetadataExt  = ".metadata"
+	gcNotifyExt    = ".notify"
 )
 
 type GCInvite struct {
@@ -27,15 +31,21 @@ type GCInvite struct {
 	Accepted bool
 }
 
-func (i *GCInvite) marshal() (string, error) {
+// marshalGCInvite encodes the invite for storage in the invites db.
+func (db *DB) marshalGCInvite(i *GCInvite) (string, error) {
 	blob, err := json.Marshal(i)
 	if err != nil {
 		return "", fmt.Errorf("could not marshal invite record: %v", err)
 	}
-	return hex.EncodeToString(blob), nil
+	return db.sealString(hex.EncodeToString(blob))
 }
 
-func (i *GCInvite) unmarshal(s string) error {
+// unmarshalGCInvite decodes an invite stored in the invites db.
+func (db *DB) unmarshalGCInvite(s string, i *GCInvite) error {
+	s, err := db.openString(s)
+	if err != nil {
+		return err
+	}
 	blob, err := hex.DecodeString(s)
 	if err != nil {
 		return err
@@ -67,7 +77,7 @@ func (db *DB) AddGCInvite(tx ReadWriteTx, user UserID, invite rpc.RMGroupInvite)
 		return 0, err
 	}
 
-	blob, err := dbi.marshal()
+	blob, err := db.marshalGCInvite(&dbi)
 	if err != nil {
 		return 0, err
 	}
@@ -95,7 +105,7 @@ func (db *DB) GetGCInvite(tx ReadTx, inviteID uint64) (rpc.RMGroupInvite, UserID
 	}
 
 	var dbi GCInvite
-	err = dbi.unmarshal(blob)
+	err = db.unmarshalGCInvite(blob, &dbi)
 	if err != nil {
 		return invite, UserID{}, fmt.Errorf("unable to unmarshal db gc invite")
 	}
@@ -113,13 +123,13 @@ func (db *DB) MarkGCInviteAccepted(tx ReadWriteTx, inviteID uint64) error {
 	}
 
 	var dbi GCInvite
-	if err := dbi.unmarshal(blob); err != nil {
+	if err := db.unmarshalGCInvite(blob, &dbi); err != nil {
 		return fmt.Errorf("unable to unmarshal db gc invite")
 	}
 
 	dbi.Accepted = true
 
-	blob, err = dbi.marshal()
+	blob, err = db.marshalGCInvite(&dbi)
 	if err != nil {
 		return err
 	}
@@ -153,7 +163,7 @@ func (db *DB) ListGCInvites(tx ReadTx, name string) ([]*GCInvite, error) {
 	records := db.invites.Records(invitesTable)
 	for _, v := range records {
 		dbi := new(GCInvite)
-		err := dbi.unmarshal(v)
+		err := db.unmarshalGCInvite(v, dbi)
 		if err != nil {
 			return nil, fmt.Errorf("unable to unmarshal db gc invite")
 		}
@@ -164,6 +174,33 @@ func (db *DB) ListGCInvites(tx ReadTx, name string) ([]*GCInvite, error) {
 	return res, nil
 }
 
+// DelExpiredGCInvites removes the (sent and received) GC invites that expired
+// before the given time. It returns the removed invites.
+func (db *DB) DelExpiredGCInvites(tx ReadWriteTx, now time.Time) ([]*GCInvite, error) {
+	var res []*GCInvite
+	records := db.invites.Records(invitesTable)
+	for k, v := range records {
+		dbi := new(GCInvite)
+		if err := db.unmarshalGCInvite(v, dbi); err != nil {
+			return nil, fmt.Errorf("unable to unmarshal db gc invite")
+		}
+		if !dbi.Invite.IsExpired(now) {
+			continue
+		}
+		if err := db.invites.Del(invitesTable, k); err != nil {
+			return nil, err
+		}
+		res = append(res, dbi)
+	}
+	if len(res) == 0 {
+		return nil, nil
+	}
+	if err := db.invites.Save(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
 func (db *DB) FindGCInvite(tx ReadTx, gcID zkidentity.ShortID, token uint64) (rpc.RMGroupInvite, UserID, uint64, error) {
 	fail := func(err error) (rpc.RMGroupInvite, UserID, uint64, error) {
 		return rpc.RMGroupInvite{}, UserID{}, 0, err
@@ -177,7 +214,7 @@ func (db *DB) FindGCInvite(tx ReadTx, gcID zkidentity.ShortID, token uint64) (rp
 			return fail(fmt.Errorf("invalid invite key: %v", err))
 		}
 
-		err = dbi.unmarshal(v)
+		err = db.unmarshalGCInvite(v, &dbi)
 		if err != nil {
 			return fail(fmt.Errorf("unable to unmarshal db gc invite"))
 		}
@@ -192,7 +229,7 @@ func (db *DB) FindGCInvite(tx ReadTx, gcID zkidentity.ShortID, token uint64) (rp
 
 // readGC reads the gc from the given filename into gl.
 func (db *DB) readGC(filename string, gc *rpc.RMGroupList) error {
-	gcJSON, err := os.ReadFile(filename)
+	gcJSON, err := db.readFile(filename)
 	if err != nil && os.IsNotExist(err) {
 		return ErrNotFound
 	}
@@ -267,19 +304,20 @@ func (db *DB) SaveGC(tx ReadWriteTx, gc rpc.RMGroupList) error {
 func (db *DB) DeleteGC(tx ReadWriteTx, gcID zkidentity.ShortID) error {
 	gcDir := filepath.Join(db.root, groupchatDir)
 	filename := filepath.Join(gcDir, gcID.String())
-	if err := os.Remove(filename); err != nil {
+	if err := db.fs().Remove(filename); err != nil {
 		return err
 	}
-	blockListFname := filename + gcBlockListExt
-	if fileExists(blockListFname) {
-		return os.Remove(blockListFname)
+	for _, ext := range []string{gcBlockListExt, gcRetentionExt, gcMetadataExt, gcNotifyExt} {
+		if err := db.removeIfExists(filename + ext); err != nil {
+			return err
+		}
 	}
-	return nil
+	return db.RemoveGCSenderKeys(tx, gcID)
 }
 
 func (db *DB) ListGCs(tx ReadTx) ([]GCAddressBookEntry, error) {
 	gcDir := filepath.Join(db.root, groupchatDir)
-	entries, err := os.ReadDir(gcDir)
+	entries, err := db.fs().ReadDir(gcDir)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return nil, nil
@@ -294,7 +332,10 @@ func (db *DB) ListGCs(tx ReadTx) ([]GCAddressBookEntry, error) {
 		}
 
 		fname := filepath.Join(gcDir, v.Name())
-		if strings.HasSuffix(fname, gcBlockListExt) {
+		if strings.HasSuffix(fname, gcBlockListExt) ||
+			strings.HasSuffix(fname, gcRetentionExt) ||
+			strings.HasSuffix(fname, gcMetadataExt) ||
+			strings.HasSuffix(fname, gcNotifyExt) {
 			continue
 		}
 
@@ -308,6 +349,16 @@ func (db *DB) ListGCs(tx ReadTx) ([]GCAddressBookEntry, error) {
 
 		var entry GCAddressBookEntry
 		RMGroupListToGCEntry(&gc, &entry)
+		entry.Retention, err = db.GetGCRetention(tx, gc.ID)
+		if err != nil {
+			db.log.Warnf("Unable to read retention policy of gc %s: %v",
+				gc.ID, err)
+		}
+		entry.NotifyMode, err = db.GetGCNotifyMode(tx, gc.ID)
+		if err != nil {
+			db.log.Warnf("Unable to read notify mode of gc %s: %v",
+				gc.ID, err)
+		}
 		groups = append(groups, entry)
 	}
 
@@ -387,3 +438,53 @@ func (db *DB) GetGCBlockList(tx ReadTx, gcid zkidentity.ShortID) (GCBlockList, e
 	return entries, err
 
 }
+
+// SaveGCMetadata saves the metadata of the given GC.
+func (db *DB) SaveGCMetadata(tx ReadWriteTx, md rpc.RMGroupMetadata) error {
+	gcFname := filepath.Join(db.root, groupchatDir, md.ID.String())
+	if !db.exists(gcFname) {
+		return fmt.Errorf("gc %s: %w", md.ID, ErrNotFound)
+	}
+	return db.saveJsonFile(gcFname+gcMetadataExt, md)
+}
+
+// GetGCMetadata returns the metadata of the given GC. Returns an empty
+// metadata (with generation zero) if the GC does not have any metadata yet.
+func (db *DB) GetGCMetadata(tx ReadTx, gcID zkidentity.ShortID) (rpc.RMGroupMetadata, error) {
+	md := rpc.RMGroupMetadata{ID: gcID}
+	fname := filepath.Join(db.root, groupchatDir, gcID.String()+gcMetadataExt)
+	err := db.readJsonFile(fname, &md)
+	if errors.Is(err, ErrNotFound) {
+		return md, nil
+	}
+	return md, err
+}
+
+// SetGCNotifyMode sets the notification policy for the messages of the given
+// GC.
+func (db *DB) SetGCNotifyMode(tx ReadWriteTx, gcID zkidentity.ShortID, mode GCNotifyMode) error {
+	if !mode.IsValid() {
+		return fmt.Errorf("invalid gc notify mode %q", mode)
+	}
+	gcFname := filepath.Join(db.root, groupchatDir, gcID.String())
+	if !db.exists(gcFname) {
+		return fmt.Errorf("gc %s: %w", gcID, ErrNotFound)
+	}
+	fname := gcFname + gcNotifyExt
+	if mode == GCNotifyAll {
+		return db.removeIfExists(fname)
+	}
+	return db.saveJsonFile(fname, mode)
+}
+
+// GetGCNotifyMode returns the notification policy for the messages of the
+// given GC. GCs without an explicit policy notify about all messages.
+func (db *DB) GetGCNotifyMode(tx ReadTx, gcID zkidentity.ShortID) (GCNotifyMode, error) {
+	mode := GCNotifyAll
+	fname := filepath.Join(db.root, groupchatDir, gcID.String()+gcNotifyExt)
+	err := db.readJsonFile(fname, &mode)
+	if errors.Is(err, ErrNotFound) {
+		return GCNotifyAll, nil
+	}
+	return mode, err
+}
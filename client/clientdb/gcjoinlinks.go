@@ -0,0 +1,160 @@
+package clientdb
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/companyzero/bisonrelay/zkidentity"
+)
+
+const (
+	gcJoinLinksDir         = "gcjoinlinks"
+	gcJoinLinksCreatedDir  = "created"
+	gcJoinLinksRequestsDir = "requests"
+	gcJoinLinksRedeemedDir = "redeemed"
+)
+
+func (db *DB) gcJoinLinkFname(subdir string, id zkidentity.ShortID) string {
+	return filepath.Join(db.root, gcJoinLinksDir, subdir, id.String()+".json")
+}
+
+// listGCJoinLinkFiles calls f with the name of every json file in the given
+// subdir of the gc join links dir.
+func (db *DB) listGCJoinLinkFiles(subdir string, f func(fname string) error) error {
+	dir := filepath.Join(db.root, gcJoinLinksDir, subdir)
+	entries, err := db.fs().ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		if err := f(filepath.Join(dir, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeGCJoinLinkFile removes the given file, returning ErrNotFound if it does
+// not exist.
+func (db *DB) removeGCJoinLinkFile(fname string) error {
+	if !db.exists(fname) {
+		return ErrNotFound
+	}
+	return db.fs().Remove(fname)
+}
+
+// SaveGCJoinLink saves a join link created by the local client.
+func (db *DB) SaveGCJoinLink(tx ReadWriteTx, link *GCJoinLink) error {
+	fname := db.gcJoinLinkFname(gcJoinLinksCreatedDir, link.Link.Seed)
+	return db.saveJsonFile(fname, link)
+}
+
+// GetGCJoinLink returns the join link with the given seed.
+func (db *DB) GetGCJoinLink(tx ReadTx, seed zkidentity.ShortID) (*GCJoinLink, error) {
+	link := new(GCJoinLink)
+	fname := db.gcJoinLinkFname(gcJoinLinksCreatedDir, seed)
+	if err := db.readJsonFile(fname, link); err != nil {
+		return nil, err
+	}
+	return link, nil
+}
+
+// ListGCJoinLinks lists all join links created by the local client.
+func (db *DB) ListGCJoinLinks(tx ReadTx) ([]GCJoinLink, error) {
+	var res []GCJoinLink
+	err := db.listGCJoinLinkFiles(gcJoinLinksCreatedDir, func(fname string) error {
+		var link GCJoinLink
+		if err := db.readJsonFile(fname, &link); err != nil {
+			db.log.Warnf("Unable to read gc join link %s: %v", fname, err)
+			return nil
+		}
+		res = append(res, link)
+		return nil
+	})
+	return res, err
+}
+
+// RemoveGCJoinLink removes the join link with the given seed.
+func (db *DB) RemoveGCJoinLink(tx ReadWriteTx, seed zkidentity.ShortID) error {
+	return db.removeGCJoinLinkFile(db.gcJoinLinkFname(gcJoinLinksCreatedDir, seed))
+}
+
+// SaveGCJoinRequest saves a request to join a GC that needs approval from the
+// local client. Requests are keyed by the id of the requesting user.
+func (db *DB) SaveGCJoinRequest(tx ReadWriteTx, req *GCJoinRequest) error {
+	fname := db.gcJoinLinkFname(gcJoinLinksRequestsDir, req.Invite.Public.Identity)
+	return db.saveJsonFile(fname, req)
+}
+
+// GetGCJoinRequest returns the request to join a GC made by the given user.
+func (db *DB) GetGCJoinRequest(tx ReadTx, uid UserID) (*GCJoinRequest, error) {
+	req := new(GCJoinRequest)
+	fname := db.gcJoinLinkFname(gcJoinLinksRequestsDir, uid)
+	if err := db.readJsonFile(fname, req); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// ListGCJoinRequests lists the requests to join GCs that need approval.
+func (db *DB) ListGCJoinRequests(tx ReadTx) ([]GCJoinRequest, error) {
+	var res []GCJoinRequest
+	err := db.listGCJoinLinkFiles(gcJoinLinksRequestsDir, func(fname string) error {
+		var req GCJoinRequest
+		if err := db.readJsonFile(fname, &req); err != nil {
+			db.log.Warnf("Unable to read gc join request %s: %v", fname, err)
+			return nil
+		}
+		res = append(res, req)
+		return nil
+	})
+	return res, err
+}
+
+// RemoveGCJoinRequest removes the request to join a GC made by the given user.
+func (db *DB) RemoveGCJoinRequest(tx ReadWriteTx, uid UserID) error {
+	return db.removeGCJoinLinkFile(db.gcJoinLinkFname(gcJoinLinksRequestsDir, uid))
+}
+
+// SaveRedeemedGCJoinLink saves a join link redeemed by the local client.
+func (db *DB) SaveRedeemedGCJoinLink(tx ReadWriteTx, r *RedeemedGCJoinLink) error {
+	return db.saveJsonFile(db.gcJoinLinkFname(gcJoinLinksRedeemedDir, r.GC), r)
+}
+
+// GetRedeemedGCJoinLink returns the join link of the given GC redeemed by the
+// local client.
+func (db *DB) GetRedeemedGCJoinLink(tx ReadTx, gcID zkidentity.ShortID) (*RedeemedGCJoinLink, error) {
+	r := new(RedeemedGCJoinLink)
+	fname := db.gcJoinLinkFname(gcJoinLinksRedeemedDir, gcID)
+	if err := db.readJsonFile(fname, r); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// ListRedeemedGCJoinLinks lists the join links redeemed by the local client
+// whose invites were not received yet.
+func (db *DB) ListRedeemedGCJoinLinks(tx ReadTx) ([]RedeemedGCJoinLink, error) {
+	var res []RedeemedGCJoinLink
+	err := db.listGCJoinLinkFiles(gcJoinLinksRedeemedDir, func(fname string) error {
+		var r RedeemedGCJoinLink
+		if err := db.readJsonFile(fname, &r); err != nil {
+			db.log.Warnf("Unable to read redeemed gc join link %s: %v", fname, err)
+			return nil
+		}
+		res = append(res, r)
+		return nil
+	})
+	return res, err
+}
+
+// RemoveRedeemedGCJoinLink removes the redeemed join link of the given GC.
+func (db *DB) RemoveRedeemedGCJoinLink(tx ReadWriteTx, gcID zkidentity.ShortID) error {
+	return db.removeGCJoinLinkFile(db.gcJoinLinkFname(gcJoinLinksRedeemedDir, gcID))
+}
@@ -0,0 +1,63 @@
+package clientdb
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/companyzero/bisonrelay/zkidentity"
+)
+
+const gcSenderKeysDir = "gcsenderkeys"
+
+func (db *DB) gcSenderKeyFname(gcID zkidentity.ShortID, uid UserID) string {
+	return filepath.Join(db.root, gcSenderKeysDir, gcID.String(),
+		uid.String()+".json")
+}
+
+// SaveGCSenderKey saves the sender key of a member of a GC.
+func (db *DB) SaveGCSenderKey(tx ReadWriteTx, sk *GCSenderKey) error {
+	return db.saveJsonFile(db.gcSenderKeyFname(sk.GC, sk.UID), sk)
+}
+
+// GetGCSenderKey returns the sender key of the given member of the GC.
+func (db *DB) GetGCSenderKey(tx ReadTx, gcID zkidentity.ShortID, uid UserID) (*GCSenderKey, error) {
+	sk := new(GCSenderKey)
+	if err := db.readJsonFile(db.gcSenderKeyFname(gcID, uid), sk); err != nil {
+		return nil, err
+	}
+	return sk, nil
+}
+
+// ListGCSenderKeys lists the sender keys of all members of all GCs.
+func (db *DB) ListGCSenderKeys(tx ReadTx) ([]GCSenderKey, error) {
+	pattern := filepath.Join(db.root, gcSenderKeysDir, "*", "*.json")
+	files, err := db.fs().Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]GCSenderKey, 0, len(files))
+	for _, fname := range files {
+		var sk GCSenderKey
+		if err := db.readJsonFile(fname, &sk); err != nil {
+			db.log.Warnf("Unable to read gc sender key %s: %v", fname, err)
+			continue
+		}
+		res = append(res, sk)
+	}
+	return res, nil
+}
+
+// RemoveGCSenderKey removes the sender key of the given member of the GC.
+func (db *DB) RemoveGCSenderKey(tx ReadWriteTx, gcID zkidentity.ShortID, uid UserID) error {
+	err := db.fs().Remove(db.gcSenderKeyFname(gcID, uid))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// RemoveGCSenderKeys removes the sender keys of all members of the GC.
+func (db *DB) RemoveGCSenderKeys(tx ReadWriteTx, gcID zkidentity.ShortID) error {
+	return db.fs().RemoveAll(filepath.Join(db.root, gcSenderKeysDir, gcID.String()))
+}
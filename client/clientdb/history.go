@@ -0,0 +1,333 @@
+package clientdb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/companyzero/bisonrelay/rpc"
+	"github.com/companyzero/bisonrelay/zkidentity"
+)
+
+const (
+	historyDir      = "history"
+	historyPMDir    = "pm"
+	historyGCDir    = "gc"
+	historyMetaFile = "meta.json"
+
+	// historyEntriesPerSegment is the number of entries stored in each
+	// segment file of a conversation's history.
+	historyEntriesPerSegment = 256
+)
+
+// historyMeta is the metadata of the history of a single conversation.
+type historyMeta struct {
+	LastID uint64 `json:"last_id"`
+}
+
+// historySegmentFname returns the name of the segment file that stores the
+// entry with the given id.
+func historySegmentFname(dir string, id uint64) string {
+	return filepath.Join(dir, fmt.Sprintf("%08d", id/historyEntriesPerSegment))
+}
+
+// appendHistoryEntry assigns the next id of the conversation to the entry and
+// appends it to the history stored in dir.
+func (db *DB) appendHistoryEntry(dir string, e *HistoryEntry) error {
+	var meta historyMeta
+	metaFname := filepath.Join(dir, historyMetaFile)
+	err := db.readJsonFile(metaFname, &meta)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("unable to read history metadata: %v", err)
+	}
+	meta.LastID += 1
+	e.ID = meta.LastID
+
+	if err := db.appendToJsonFile(historySegmentFname(dir, e.ID), e); err != nil {
+		return err
+	}
+	return db.saveJsonFile(metaFname, meta)
+}
+
+// readHistory returns up to limit entries of the history stored in dir with an
+// id lower than before. A before of zero returns the most recent entries and a
+// limit <= 0 returns all matching entries. Entries are returned in the order
+// they were stored (oldest first).
+func (db *DB) readHistory(dir string, before uint64, limit int) ([]HistoryEntry, error) {
+	var meta historyMeta
+	err := db.readJsonFile(filepath.Join(dir, historyMetaFile), &meta)
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read history metadata: %v", err)
+	}
+
+	last := meta.LastID
+	if before > 0 && before <= last {
+		last = before - 1
+	}
+	if last == 0 {
+		return nil, nil
+	}
+
+	// Read segments from newest to oldest until enough entries have been
+	// collected.
+	var res []HistoryEntry
+	for seg := last / historyEntriesPerSegment; ; seg-- {
+		b, err := db.fs().ReadFile(historySegmentFname(dir, seg*historyEntriesPerSegment))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+
+		var segEntries []HistoryEntry
+		dec := db.newJsonRecordDecoder(bytes.NewReader(b))
+		for {
+			var e HistoryEntry
+			err := dec.Decode(&e)
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("unable to decode history entry: %v", err)
+			}
+			if e.ID <= last {
+				segEntries = append(segEntries, e)
+			}
+		}
+
+		res = append(segEntries, res...)
+		if (limit > 0 && len(res) >= limit) || seg == 0 {
+			break
+		}
+	}
+
+	if limit > 0 && len(res) > limit {
+		res = res[len(res)-limit:]
+	}
+	return res, nil
+}
+
+// ReadPMHistory returns up to limit entries of the PM history with the given
+// user that have an id lower than before. A before of zero returns the most
+// recent entries. Entries are sorted from oldest to newest.
+func (db *DB) ReadPMHistory(tx ReadTx, uid UserID, before uint64, limit int) ([]HistoryEntry, error) {
+	dir := filepath.Join(db.root, historyDir, historyPMDir, uid.String())
+	return db.readHistory(dir, before, limit)
+}
+
+// ReadGCHistory returns up to limit entries of the history of the given GC
+// that have an id lower than before. A before of zero returns the most recent
+// entries. Entries are sorted from oldest to newest.
+func (db *DB) ReadGCHistory(tx ReadTx, gcID zkidentity.ShortID, before uint64, limit int) ([]HistoryEntry, error) {
+	dir := filepath.Join(db.root, historyDir, historyGCDir, gcID.String())
+	return db.readHistory(dir, before, limit)
+}
+
+// updateHistoryEntry calls update on the entry of the history stored in dir
+// that has the given message id and stores the modified entry. If update
+// returns an error, the entry is not modified. Returns ErrNotFound if there is
+// no such entry.
+func (db *DB) updateHistoryEntry(dir string, msgID zkidentity.ShortID,
+	update func(e *HistoryEntry) error) (HistoryEntry, error) {
+
+	var meta historyMeta
+	err := db.readJsonFile(filepath.Join(dir, historyMetaFile), &meta)
+	if err != nil {
+		return HistoryEntry{}, err
+	}
+
+	// Edits usually target recent messages, so look for the entry starting
+	// from the newest segment.
+	for seg := meta.LastID/historyEntriesPerSegment + 1; seg > 0; seg-- {
+		fname := historySegmentFname(dir, (seg-1)*historyEntriesPerSegment)
+		b, err := db.fs().ReadFile(fname)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return HistoryEntry{}, err
+		}
+
+		var entries []HistoryEntry
+		found := -1
+		dec := db.newJsonRecordDecoder(bytes.NewReader(b))
+		for {
+			var e HistoryEntry
+			err := dec.Decode(&e)
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return HistoryEntry{}, fmt.Errorf("unable to decode history entry: %v", err)
+			}
+			if !e.Internal && e.MsgID == msgID {
+				found = len(entries)
+			}
+			entries = append(entries, e)
+		}
+		if found < 0 {
+			continue
+		}
+		if err := update(&entries[found]); err != nil {
+			return HistoryEntry{}, err
+		}
+
+		buf := new(bytes.Buffer)
+		for i := range entries {
+			rec, err := db.marshalJsonRecord(entries[i])
+			if err != nil {
+				return HistoryEntry{}, err
+			}
+			buf.Write(rec)
+		}
+		if err := db.fs().WriteFile(fname, buf.Bytes()); err != nil {
+			return HistoryEntry{}, err
+		}
+		return entries[found], nil
+	}
+
+	return HistoryEntry{}, ErrNotFound
+}
+
+// editHistoryEntry replaces the message of the entry with the given message id
+// and updates the search index accordingly. If retract is true, the message is
+// removed instead. The edited message is only indexed if index is true.
+func (db *DB) editHistoryEntry(dir string, doc SearchResult, from UserID,
+	msg string, ts time.Time, retract, index bool) error {
+
+	e, err := db.updateHistoryEntry(dir, doc.MsgID, func(e *HistoryEntry) error {
+		if e.FromUID != from {
+			return ErrNotMsgSender
+		}
+		if retract {
+			e.Message = ""
+			e.Retracted = true
+		} else {
+			e.Message = msg
+			e.Edited = ts
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Replace the indexed doc of the message, so that searches do not
+	// return the old contents.
+	err = db.pruneSearchIndex(func(d *SearchResult) bool {
+		return d.Type == doc.Type && d.MsgID == doc.MsgID &&
+			d.UID == doc.UID && d.GCID == doc.GCID
+	})
+	if err != nil {
+		return err
+	}
+	if !retract && index {
+		doc.From = e.From
+		doc.Timestamp = e.Timestamp
+		doc.Text = e.Message
+		db.tryIndexSearchDoc(doc)
+	}
+	return nil
+}
+
+// EditPMHistory replaces the message with the given id stored in the PM history
+// with the given user. The message must have been sent by from. Only the
+// structured history is modified: the plain text message logs are kept as is.
+func (db *DB) EditPMHistory(tx ReadWriteTx, uid UserID, msgID zkidentity.ShortID,
+	from UserID, msg string, ts time.Time) error {
+
+	entry, err := db.getBaseABEntry(uid)
+	if err != nil {
+		return err
+	}
+	index := entry.Retention.Mode != rpc.RetentionModeAfterRead
+
+	dir := filepath.Join(db.root, historyDir, historyPMDir, uid.String())
+	doc := SearchResult{Type: SearchTypePM, UID: uid, MsgID: msgID}
+	return db.editHistoryEntry(dir, doc, from, msg, ts, false, index)
+}
+
+// RetractPMHistory removes the contents of the message with the given id
+// stored in the PM history with the given user. The message must have been
+// sent by from.
+func (db *DB) RetractPMHistory(tx ReadWriteTx, uid UserID, msgID zkidentity.ShortID,
+	from UserID) error {
+
+	dir := filepath.Join(db.root, historyDir, historyPMDir, uid.String())
+	doc := SearchResult{Type: SearchTypePM, UID: uid, MsgID: msgID}
+	return db.editHistoryEntry(dir, doc, from, "", time.Time{}, true, false)
+}
+
+// EditGCHistory replaces the message with the given id stored in the history
+// of the given GC. The message must have been sent by from. Only the structured
+// history is modified: the plain text message logs are kept as is.
+func (db *DB) EditGCHistory(tx ReadWriteTx, gcID, msgID zkidentity.ShortID,
+	from UserID, msg string, ts time.Time) error {
+
+	retention, err := db.getGCRetention(gcID)
+	if err != nil {
+		return err
+	}
+	index := retention.Mode != rpc.RetentionModeAfterRead
+
+	dir := filepath.Join(db.root, historyDir, historyGCDir, gcID.String())
+	doc := SearchResult{Type: SearchTypeGC, GCID: gcID, MsgID: msgID}
+	return db.editHistoryEntry(dir, doc, from, msg, ts, false, index)
+}
+
+// RetractGCHistory removes the contents of the message with the given id
+// stored in the history of the given GC. The message must have been sent by
+// from.
+func (db *DB) RetractGCHistory(tx ReadWriteTx, gcID, msgID zkidentity.ShortID,
+	from UserID) error {
+
+	dir := filepath.Join(db.root, historyDir, historyGCDir, gcID.String())
+	doc := SearchResult{Type: SearchTypeGC, GCID: gcID, MsgID: msgID}
+	return db.editHistoryEntry(dir, doc, from, "", time.Time{}, true, false)
+}
+
+// reactToHistoryEntry adds (or removes) the reaction of the user from to the
+// entry with the given message id of the history stored in dir. It returns the
+// resulting reactions to the message.
+func (db *DB) reactToHistoryEntry(dir string, msgID zkidentity.ShortID,
+	from UserID, reaction string, remove bool) (Reactions, error) {
+
+	e, err := db.updateHistoryEntry(dir, msgID, func(e *HistoryEntry) error {
+		if e.Retracted {
+			return ErrNotFound
+		}
+		if e.Reactions == nil {
+			e.Reactions = make(Reactions)
+		}
+		if !e.Reactions.Apply(from, reaction, remove) {
+			return ErrDuplicateReaction
+		}
+		return nil
+	})
+	return e.Reactions, err
+}
+
+// ReactToPMHistory adds (or removes, if remove is true) the reaction of the
+// user from to the message with the given id stored in the PM history with the
+// given user.
+func (db *DB) ReactToPMHistory(tx ReadWriteTx, uid UserID, msgID zkidentity.ShortID,
+	from UserID, reaction string, remove bool) (Reactions, error) {
+
+	dir := filepath.Join(db.root, historyDir, historyPMDir, uid.String())
+	return db.reactToHistoryEntry(dir, msgID, from, reaction, remove)
+}
+
+// ReactToGCHistory adds (or removes, if remove is true) the reaction of the
+// user from to the message with the given id stored in the history of the
+// given GC.
+func (db *DB) ReactToGCHistory(tx ReadWriteTx, gcID, msgID zkidentity.ShortID,
+	from UserID, reaction string, remove bool) (Reactions, error) {
+
+	dir := filepath.Join(db.root, historyDir, historyGCDir, gcID.String())
+	return db.reactToHistoryEntry(dir, msgID, from, reaction, remove)
+}
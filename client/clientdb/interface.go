@@ -104,17 +104,94 @@ type AddressBookEntry struct {
 	MyResetRV    RawRVID                    `json:"myResetRV"`
 	TheirResetRV RawRVID                    `json:"theirResetRV"`
 	Ignored      bool                       `json:"ignored"`
+
+	// Retention is the policy for how long the messages exchanged with
+	// the user are kept.
+	Retention rpc.RetentionPolicy `json:"retention"`
+
+	// RetentionSince is the time from which Retention applies. Messages
+	// logged before it are not removed by the policy.
+	RetentionSince time.Time `json:"retentionSince,omitempty"`
+
+	// NoReceipts is true if the local client does not send delivery and
+	// read receipts of PMs to the user.
+	NoReceipts bool `json:"noReceipts"`
 }
 
 type GCAddressBookEntry struct {
 	ID      zkidentity.ShortID `json:"id"`
 	Members []UserID           `json:"members"`
+
+	// Admins are the members, besides the owner (Members[0]), that are
+	// admins of the GC. Moderators are the members that may only kick
+	// other members.
+	Admins     []UserID `json:"admins"`
+	Moderators []UserID `json:"moderators"`
+
+	// Retention is the policy for how long the messages of the GC are
+	// kept. This is only filled by ListGCs.
+	Retention rpc.RetentionPolicy `json:"retention"`
+
+	// NotifyMode is the notification policy for the messages of the GC.
+	// This is only filled by ListGCs.
+	NotifyMode GCNotifyMode `json:"notify_mode"`
+}
+
+// GCNotifyMode is the policy that determines which messages received in a GC
+// generate notifications for the local user.
+type GCNotifyMode string
+
+const (
+	// GCNotifyAll notifies about every message received in the GC.
+	GCNotifyAll GCNotifyMode = "all"
+
+	// GCNotifyMentions only notifies about messages that mention the
+	// local user.
+	GCNotifyMentions GCNotifyMode = "mentions"
+
+	// GCNotifyMuted does not notify about any message received in the
+	// GC.
+	GCNotifyMuted GCNotifyMode = "muted"
+)
+
+// IsValid returns true if this is a known notification mode.
+func (m GCNotifyMode) IsValid() bool {
+	switch m {
+	case GCNotifyAll, GCNotifyMentions, GCNotifyMuted:
+		return true
+	default:
+		return false
+	}
+}
+
+// ShouldNotify returns true if a message should generate a notification under
+// this mode. mentioned is true if the local user was mentioned in the message.
+func (m GCNotifyMode) ShouldNotify(mentioned bool) bool {
+	switch m {
+	case GCNotifyMuted:
+		return false
+	case GCNotifyMentions:
+		return mentioned
+	default:
+		return true
+	}
 }
 
 func RMGroupListToGCEntry(gc *rpc.RMGroupList, entry *GCAddressBookEntry) {
 	entry.ID = gc.ID
 	entry.Members = make([]UserID, len(gc.Members))
 	copy(entry.Members, gc.Members)
+	entry.Admins = nil
+	entry.Moderators = nil
+	for _, uid := range gc.Members {
+		if gc.IsOwner(uid) {
+			continue
+		} else if gc.IsAdmin(uid) {
+			entry.Admins = append(entry.Admins, uid)
+		} else if gc.IsModerator(uid) {
+			entry.Moderators = append(entry.Moderators, uid)
+		}
+	}
 }
 
 type SharedFile struct {
@@ -159,6 +236,75 @@ type FileDownload struct {
 	ChunkStates      map[int]ChunkState `json:"chunkstates"`
 	ChunkUpdatedTime map[int]time.Time  `json:"chunkupdttimes"`
 	IsSentFile       bool               `json:"is_sent_file"`
+	Paused           bool               `json:"paused,omitempty"`
+
+	// Sources are the additional users (other than UID) that share a
+	// file with the same content and from which chunks may be downloaded.
+	Sources []FileDownloadSource `json:"sources,omitempty"`
+
+	// ChunkSources tracks from which user each chunk was requested. Chunks
+	// not in this map are requested from UID.
+	ChunkSources map[int]UserID `json:"chunk_sources,omitempty"`
+}
+
+// FileDownloadSource is an additional source of a file download.
+type FileDownloadSource struct {
+	UID      UserID           `json:"uid"`
+	FID      FileID           `json:"fid"`
+	Metadata rpc.FileMetadata `json:"metadata"`
+}
+
+// SourceFID returns the file ID of the download as shared by the given user,
+// which is either the original user or one of the additional sources.
+func (fd *FileDownload) SourceFID(uid UserID) (FileID, bool) {
+	if uid == fd.UID {
+		return fd.FID, true
+	}
+	if src := fd.source(uid); src != nil {
+		return src.FID, true
+	}
+	return FileID{}, false
+}
+
+// source returns the additional source with the given user id.
+func (fd *FileDownload) source(uid UserID) *FileDownloadSource {
+	for i := range fd.Sources {
+		if fd.Sources[i].UID == uid {
+			return &fd.Sources[i]
+		}
+	}
+	return nil
+}
+
+// SourceUIDs returns the ids of all users from which the download may be
+// fetched, starting with the original user.
+func (fd *FileDownload) SourceUIDs() []UserID {
+	res := make([]UserID, 0, len(fd.Sources)+1)
+	res = append(res, fd.UID)
+	for i := range fd.Sources {
+		res = append(res, fd.Sources[i].UID)
+	}
+	return res
+}
+
+// SourceMetadata returns the metadata of the download as shared by the given
+// user.
+func (fd *FileDownload) SourceMetadata(uid UserID) *rpc.FileMetadata {
+	if uid == fd.UID {
+		return fd.Metadata
+	}
+	if src := fd.source(uid); src != nil {
+		return &src.Metadata
+	}
+	return nil
+}
+
+// ChunkSource returns the user from which the given chunk was requested.
+func (fd *FileDownload) ChunkSource(chunkIdx int) UserID {
+	if uid, ok := fd.ChunkSources[chunkIdx]; ok {
+		return uid
+	}
+	return fd.UID
 }
 
 func (fd *FileDownload) GetChunkState(chunkIdx int) ChunkState {
@@ -194,6 +340,86 @@ func (fd *FileDownload) CountChunks(atState ChunkState) int {
 	return res
 }
 
+// TransferPriority is the relative priority of a file transfer. Messages of
+// transfers with higher priority are sent before the ones of transfers with
+// lower priority.
+type TransferPriority string
+
+const (
+	TransferPriorityLow    TransferPriority = "low"
+	TransferPriorityNormal TransferPriority = "normal"
+	TransferPriorityHigh   TransferPriority = "high"
+)
+
+// IsValid returns true if this is a known transfer priority.
+func (p TransferPriority) IsValid() bool {
+	switch p {
+	case TransferPriorityLow, TransferPriorityNormal, TransferPriorityHigh:
+		return true
+	default:
+		return false
+	}
+}
+
+// SharedFolder is a local folder whose files are shared with a remote user or
+// with the members of a GC.
+type SharedFolder struct {
+	ID      zkidentity.ShortID  `json:"id"`
+	Name    string              `json:"name"`
+	Path    string              `json:"path"`
+	UID     *UserID             `json:"uid,omitempty"`
+	GC      *zkidentity.ShortID `json:"gc,omitempty"`
+	Cost    uint64              `json:"cost"`
+	Version uint64              `json:"version"`
+	Files   []SharedFolderFile  `json:"files"`
+	Created time.Time           `json:"created"`
+	Updated time.Time           `json:"updated"`
+
+	// Shares are the users the files of the folder are currently shared
+	// with.
+	Shares []UserID `json:"shares"`
+}
+
+// SharedFolderFile is a file of a local shared folder.
+type SharedFolderFile struct {
+	Path    string    `json:"path"`
+	FID     FileID    `json:"fid"`
+	Hash    string    `json:"hash"`
+	Size    uint64    `json:"size"`
+	Cost    uint64    `json:"cost"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+// SubscribedFolder is a folder shared by a remote user with the local client.
+// The files of the folder are only synced after a local path is set for it.
+type SubscribedFolder struct {
+	ID        zkidentity.ShortID     `json:"id"`
+	Owner     UserID                 `json:"owner"`
+	Name      string                 `json:"name"`
+	GC        *zkidentity.ShortID    `json:"gc,omitempty"`
+	Version   uint64                 `json:"version"`
+	Files     []rpc.SharedFolderFile `json:"files"`
+	LocalPath string                 `json:"local_path,omitempty"`
+	Removed   bool                   `json:"removed,omitempty"`
+	Updated   time.Time              `json:"updated"`
+
+	// Synced tracks the file ID of the last version of each file that was
+	// written to the local path.
+	Synced map[string]FileID `json:"synced,omitempty"`
+}
+
+// PendingFiles returns the files of the folder that are not synced yet.
+func (sf *SubscribedFolder) PendingFiles() []rpc.SharedFolderFile {
+	var res []rpc.SharedFolderFile
+	for _, f := range sf.Files {
+		if fid, ok := sf.Synced[f.Path]; ok && fid.String() == f.FileID {
+			continue
+		}
+		res = append(res, f)
+	}
+	return res
+}
+
 type ChunkUpload struct {
 	UID      UserID     `json:"uid"`
 	FID      FileID     `json:"fid"`
@@ -260,6 +486,7 @@ type PostKXActionType string
 const (
 	PKXActionKXSearch  PostKXActionType = "kx_search"
 	PKXActionFetchPost PostKXActionType = "fetch_post"
+	PKXActionInviteGC  PostKXActionType = "invite_gc"
 )
 
 type PostKXAction struct {
@@ -294,6 +521,230 @@ type UnackedRM struct {
 	PayEvent  string  `json:"pay_event"`
 }
 
+// HistoryEntry is a message stored in the history of a PM or GC conversation.
+type HistoryEntry struct {
+	// ID is the sequential id of the entry within its conversation. The
+	// first entry of a conversation has ID 1.
+	ID uint64 `json:"id"`
+
+	Timestamp time.Time `json:"timestamp"`
+
+	// From and FromUID are the nick and id of the sender. These are empty
+	// for internal entries.
+	From    string `json:"from"`
+	FromUID UserID `json:"from_uid"`
+
+	// Internal is true for entries generated by the client itself (for
+	// example, when a KX completes) as opposed to messages sent by a
+	// user.
+	Internal bool `json:"internal"`
+
+	Mode    rpc.MessageMode `json:"mode"`
+	Message string          `json:"message"`
+
+	// MsgID is the id assigned to the message by its sender. It is empty
+	// for internal entries and messages sent by older clients.
+	MsgID zkidentity.ShortID `json:"msg_id"`
+
+	// ReplyTo is the MsgID of the message this message replies to, if
+	// any.
+	ReplyTo zkidentity.ShortID `json:"reply_to"`
+
+	// Mentions are the ids of the users explicitly mentioned in a GC
+	// message.
+	Mentions []UserID `json:"mentions,omitempty"`
+
+	// Edited is the time the message was last edited by its sender. It is
+	// the zero time for messages that were never edited.
+	Edited time.Time `json:"edited"`
+
+	// Retracted is true if the sender retracted the message. The contents
+	// of retracted messages are not kept.
+	Retracted bool `json:"retracted"`
+
+	// Reactions are the reactions of users to the message.
+	Reactions Reactions `json:"reactions,omitempty"`
+}
+
+// Reactions are the reactions to a message or post, keyed by reaction, with
+// the ids of the users that reacted.
+type Reactions map[string][]UserID
+
+// Apply adds (or removes, if remove is true) the reaction of the given user.
+// It returns false if the reactions were not modified.
+func (r Reactions) Apply(uid UserID, reaction string, remove bool) bool {
+	users := r[reaction]
+	for i := range users {
+		if users[i] != uid {
+			continue
+		}
+		if !remove {
+			return false
+		}
+		users = append(users[:i:i], users[i+1:]...)
+		if len(users) == 0 {
+			delete(r, reaction)
+		} else {
+			r[reaction] = users
+		}
+		return true
+	}
+	if remove {
+		return false
+	}
+	r[reaction] = append(users, uid)
+	return true
+}
+
+// HasReacted returns true if the user reacted with the given reaction.
+func (r Reactions) HasReacted(uid UserID, reaction string) bool {
+	for _, id := range r[reaction] {
+		if id == uid {
+			return true
+		}
+	}
+	return false
+}
+
+// SearchResultType is the type of a message indexed for searching.
+type SearchResultType string
+
+const (
+	SearchTypePM          SearchResultType = "pm"
+	SearchTypeGC          SearchResultType = "gc"
+	SearchTypePost        SearchResultType = "post"
+	SearchTypePostComment SearchResultType = "comment"
+)
+
+// SearchFilters restrict the results of a search.
+type SearchFilters struct {
+	// Types restricts results to the given types. Empty means all types.
+	Types []SearchResultType `json:"types"`
+
+	// UID restricts results to PMs with the given user or posts (and
+	// comments on posts) received from the given user.
+	UID *UserID `json:"uid"`
+
+	// GCID restricts results to messages sent on the given GC.
+	GCID *zkidentity.ShortID `json:"gcid"`
+
+	// Since and Until restrict results to the given time range. A zero
+	// value is unbounded.
+	Since time.Time `json:"since"`
+	Until time.Time `json:"until"`
+
+	// Limit is the max number of results. Values <= 0 mean unlimited.
+	Limit int `json:"limit"`
+}
+
+// SearchResult is a message that matched a search.
+type SearchResult struct {
+	ID        uint64             `json:"id"`
+	Type      SearchResultType   `json:"type"`
+	UID       UserID             `json:"uid"`
+	GCID      zkidentity.ShortID `json:"gcid"`
+	PostID    PostID             `json:"pid"`
+	MsgID     zkidentity.ShortID `json:"msg_id"`
+	From      string             `json:"from"`
+	Timestamp time.Time          `json:"timestamp"`
+	Text      string             `json:"text"`
+}
+
+// GCJoinLink is a join link of an open GC created by the local client.
+type GCJoinLink struct {
+	Link rpc.OOBGCJoinLink `json:"link"`
+
+	// UsedSlots are the slots of the link that have already received a
+	// request to join the GC.
+	UsedSlots []uint32 `json:"used_slots"`
+
+	// Users are the users that requested to join the GC through the link.
+	// Repeated requests from these users do not use further slots.
+	Users []UserID `json:"users,omitempty"`
+}
+
+// HasUser returns true if the given user already requested to join the GC
+// through the link.
+func (l *GCJoinLink) HasUser(uid UserID) bool {
+	for _, u := range l.Users {
+		if u == uid {
+			return true
+		}
+	}
+	return false
+}
+
+// IsSlotUsed returns true if the given slot of the link was already used.
+func (l *GCJoinLink) IsSlotUsed(slot uint32) bool {
+	for _, s := range l.UsedSlots {
+		if s == slot {
+			return true
+		}
+	}
+	return false
+}
+
+// GCJoinRequest is a request to join an open GC received through a join link
+// that requires approval from the admin.
+type GCJoinRequest struct {
+	GC       zkidentity.ShortID          `json:"gc"`
+	Seed     zkidentity.ShortID          `json:"seed"`
+	Invite   rpc.OOBPublicIdentityInvite `json:"invite"`
+	Received time.Time                   `json:"received"`
+}
+
+// RedeemedGCJoinLink tracks a join link redeemed by the local client, such that
+// the invite sent by the admin to the GC is automatically accepted.
+type RedeemedGCJoinLink struct {
+	GC       zkidentity.ShortID `json:"gc"`
+	Admin    UserID             `json:"admin"`
+	Expires  time.Time          `json:"expires"`
+	Redeemed time.Time          `json:"redeemed"`
+
+	// Link is the redeemed link. Slot is the last slot of the link where
+	// the request to join the GC was sent (at LastAttempt).
+	Link        rpc.OOBGCJoinLink `json:"link"`
+	Slot        uint32            `json:"slot"`
+	LastAttempt time.Time         `json:"last_attempt"`
+}
+
+// GCSenderKey is a key used to encrypt (when it belongs to the local client) or
+// decrypt (when it belongs to another member) GC messages pushed to the group
+// RVs of a GC.
+type GCSenderKey struct {
+	GC      zkidentity.ShortID `json:"gc"`
+	UID     UserID             `json:"uid"`
+	Epoch   uint64             `json:"epoch"`
+	Key     []byte             `json:"key"`
+	Counter uint64             `json:"counter"` // Next counter to be used
+
+	// Members is the list of members the key was sent to. Only filled in
+	// the local client's key.
+	Members []UserID `json:"members,omitempty"`
+}
+
+// DeviceLink is the link between the primary device of an identity and one of
+// its linked devices. Each side of the link stores its own copy.
+type DeviceLink struct {
+	// DeviceID is the id of the linked device.
+	DeviceID zkidentity.ShortID `json:"device_id"`
+	Name     string             `json:"name"`
+
+	// Key is the key used to derive the RVs and encrypt the messages
+	// exchanged between the devices.
+	Key     [32]byte  `json:"key"`
+	Created time.Time `json:"created"`
+
+	// Primary is true if the link is stored in the linked device and
+	// points to the primary device.
+	Primary bool `json:"primary"`
+
+	// SendSeq and RecvSeq are the sequence numbers of the next messages
+	// to be sent and received through the link.
+	SendSeq uint64 `json:"send_seq"`
+	RecvSeq uint64 `json:"recv_seq"`
+}
+
 var (
 	LocalIDEmptyError       = errors.New("local ID is not initialized")
 	ServerIDEmptyError      = errors.New("server ID is not known")
@@ -302,4 +753,8 @@ var (
 	ErrPostStatusValidation = errors.New("invalid post status update")
 	ErrAlreadyExists        = errors.New("already exists")
 	ErrDuplicatePostStatus  = errors.New("duplicate post status")
+	ErrWrongPassphrase      = errors.New("wrong db passphrase")
+	ErrEmptySearchQuery     = errors.New("search query has no searchable terms")
+	ErrNotMsgSender         = errors.New("message was not sent by the user")
+	ErrDuplicateReaction    = errors.New("reaction was already applied")
 )
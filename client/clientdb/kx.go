@@ -13,7 +13,7 @@ import (
 
 func (db *DB) SaveKX(tx ReadWriteTx, kx KXData) error {
 	dir := filepath.Join(db.root, kxDir)
-	if err := os.MkdirAll(dir, 0o700); err != nil {
+	if err := db.fs().MkdirAll(dir); err != nil {
 		return fmt.Errorf("unable to make kx dir: %v", err)
 	}
 
@@ -23,23 +23,23 @@ func (db *DB) SaveKX(tx ReadWriteTx, kx KXData) error {
 	}
 
 	fname := filepath.Join(dir, kx.InitialRV.String())
-	if _, err := os.Stat(fname); !os.IsNotExist(err) {
+	if _, err := db.fs().Stat(fname); !os.IsNotExist(err) {
 		if err != nil {
 			return err
 		}
 		return fmt.Errorf("kx with initial RV %s: %w", kx.InitialRV, ErrAlreadyExists)
 	}
-	return os.WriteFile(fname, blob, 0o600)
+	return db.writeFile(fname, blob)
 }
 
 func (db *DB) DeleteKX(tx ReadWriteTx, initialRV RawRVID) error {
 	fname := filepath.Join(db.root, kxDir, initialRV.String())
-	return os.Remove(fname)
+	return db.fs().Remove(fname)
 }
 
 func (db *DB) GetKX(tx ReadTx, initialRV RawRVID) (KXData, error) {
 	fname := filepath.Join(db.root, kxDir, initialRV.String())
-	blob, err := os.ReadFile(fname)
+	blob, err := db.readFile(fname)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return KXData{}, fmt.Errorf("kx %s: %w",
@@ -58,7 +58,7 @@ func (db *DB) GetKX(tx ReadTx, initialRV RawRVID) (KXData, error) {
 
 func (db *DB) ListKXs(tx ReadTx) ([]KXData, error) {
 	dir := filepath.Join(db.root, kxDir)
-	dirEntries, err := os.ReadDir(dir)
+	dirEntries, err := db.fs().ReadDir(dir)
 	if err != nil && !os.IsNotExist(err) {
 		return nil, fmt.Errorf("unable to read content dir: %v", err)
 	}
@@ -70,7 +70,7 @@ func (db *DB) ListKXs(tx ReadTx) ([]KXData, error) {
 		}
 
 		fname := filepath.Join(dir, f.Name())
-		blob, err := os.ReadFile(fname)
+		blob, err := db.readFile(fname)
 		if err != nil {
 			return nil, err
 		}
@@ -115,7 +115,7 @@ func (db *DB) HasMediateID(tx ReadTx, mediator, target UserID) (MediateIDRequest
 func (db *DB) HasAnyRecentMediateID(tx ReadTx, target UserID, recentThreshold time.Duration) (bool, error) {
 	pattern := filepath.Join(db.root, inboundDir, "*", miRequestsDir,
 		target.String())
-	files, err := filepath.Glob(pattern)
+	files, err := db.fs().Glob(pattern)
 	if err != nil {
 		return false, err
 	}
@@ -144,17 +144,13 @@ func (db *DB) HasAnyRecentMediateID(tx ReadTx, target UserID, recentThreshold ti
 func (db *DB) RemoveMediateID(tx ReadWriteTx, mediator, target UserID) error {
 	filepath := filepath.Join(db.root, inboundDir, mediator.String(),
 		miRequestsDir, target.String())
-	err := os.Remove(filepath)
-	if os.IsNotExist(err) {
-		return nil // Not an error.
-	}
-	return err
+	return db.removeIfExists(filepath)
 }
 
 // ListMediateIDs lists all existing mediate id requests.
 func (db *DB) ListMediateIDs(tx ReadTx) ([]MediateIDRequest, error) {
 	pattern := filepath.Join(db.root, inboundDir, "*", miRequestsDir, "*")
-	files, err := filepath.Glob(pattern)
+	files, err := db.fs().Glob(pattern)
 	if err != nil {
 		return nil, err
 	}
@@ -220,14 +216,14 @@ func (db *DB) GetKXSearch(tx ReadTx, target UserID) (KXSearch, error) {
 // RemoveKXSearch removes the kx search for the given target if it exists.
 func (db *DB) RemoveKXSearch(tx ReadWriteTx, target UserID) error {
 	filename := filepath.Join(db.root, kxSearches, target.String())
-	return removeIfExists(filename)
+	return db.removeIfExists(filename)
 }
 
 // ListKXSearches lists the IDs of all outstanding users being KX searched for.
 func (db *DB) ListKXSearches(tx ReadTx) ([]UserID, error) {
 	dir := filepath.Join(db.root, kxSearches)
 	var res []UserID
-	files, err := os.ReadDir(dir)
+	files, err := db.fs().ReadDir(dir)
 	if os.IsNotExist(err) {
 		// No KX searches yet.
 		return nil, nil
@@ -302,9 +298,5 @@ func (db *DB) ListPostKXActions(tx ReadTx, target UserID) ([]PostKXAction, error
 // target user.
 func (db *DB) RemovePostKXActions(tx ReadWriteTx, target UserID) error {
 	filename := filepath.Join(db.root, postKXActionsDir, target.String())
-	err := os.Remove(filename)
-	if os.IsNotExist(err) {
-		return nil
-	}
-	return err
+	return db.removeIfExists(filename)
 }
@@ -1,7 +1,7 @@
 package clientdb
 
 import (
-	"encoding/json"
+	"bytes"
 	"os"
 	"path/filepath"
 	"sort"
@@ -59,18 +59,17 @@ func (db *DB) ListPayStats(tx ReadTx) (map[UserID]UserPayStats, error) {
 // given user. These are grouped by the first level.
 func (db *DB) SummarizeUserPayStats(tx ReadTx, uid UserID) ([]PayStatsSummary, error) {
 	fname := filepath.Join(db.root, inboundDir, uid.String(), payStatsFile)
-	f, err := os.Open(fname)
+	b, err := db.fs().ReadFile(fname)
 	if os.IsNotExist(err) {
 		// No stats.
 		return nil, nil
 	} else if err != nil {
 		return nil, err
 	}
-	defer f.Close()
 
 	feeTotal := PayStatsSummary{Prefix: "payfees"}
 
-	dec := json.NewDecoder(f)
+	dec := db.newJsonRecordDecoder(bytes.NewReader(b))
 	var evnt PayStatEvent
 	aux := make(map[string]*PayStatsSummary)
 	for err := dec.Decode(&evnt); err == nil; err = dec.Decode(&evnt) {
@@ -122,20 +121,20 @@ func (db *DB) ClearPayStats(tx ReadWriteTx, user *UserID) error {
 	if user == nil {
 		// Remove stats summary file.
 		statsFname := filepath.Join(db.root, payStatsFile)
-		if err := os.Remove(statsFname); err != nil && !os.IsNotExist(err) {
+		if err := db.fs().Remove(statsFname); err != nil && !os.IsNotExist(err) {
 			return err
 		}
 		db.payStats = make(map[string]UserPayStats)
 
 		// Remove all individual stats files.
 		pattern := filepath.Join(db.root, inboundDir, "*", payStatsFile)
-		files, err := filepath.Glob(pattern)
+		files, err := db.fs().Glob(pattern)
 		if err != nil {
 			return err
 		}
 
 		for _, f := range files {
-			if err := os.Remove(f); err != nil {
+			if err := db.fs().Remove(f); err != nil {
 				db.log.Warnf("Unable to remove pay stat file %s: %v", f, err)
 			}
 		}
@@ -151,7 +150,7 @@ func (db *DB) ClearPayStats(tx ReadWriteTx, user *UserID) error {
 	}
 
 	statsFname = filepath.Join(db.root, inboundDir, user.String(), payStatsFile)
-	if err := os.Remove(statsFname); err != nil && !os.IsNotExist(err) {
+	if err := db.fs().Remove(statsFname); err != nil && !os.IsNotExist(err) {
 		return err
 	}
 
@@ -1,12 +1,14 @@
 package clientdb
 
 import (
+	"bytes"
 	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"strconv"
@@ -56,19 +58,17 @@ func PostSummFromMetadata(post *rpc.PostMetadata, from UserID) PostSummary {
 // the local user.
 func (db *DB) SubscribeToPosts(tx ReadWriteTx, user UserID) error {
 	dir := filepath.Join(db.root, postsDir)
-	if err := os.MkdirAll(dir, 0700); err != nil {
+	if err := db.fs().MkdirAll(dir); err != nil {
 		return err
 	}
 	filename := filepath.Join(dir, postsSubscribers)
 
-	f, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE, 0600)
+	b, err := db.fs().ReadFile(filename)
 	if err != nil && !os.IsNotExist(err) {
 		return err
 	}
 	if err == nil {
-		defer f.Close()
-
-		d := json.NewDecoder(f)
+		d := db.newJsonRecordDecoder(bytes.NewReader(b))
 		for {
 			var s subscription
 			err = d.Decode(&s)
@@ -90,31 +90,24 @@ func (db *DB) SubscribeToPosts(tx ReadWriteTx, user UserID) error {
 		From:      user,
 		Timestamp: time.Now().Unix(),
 	}
-	e := json.NewEncoder(f)
-	err = e.Encode(s)
-	if err != nil {
-		return err
-	}
-
-	return nil
+	return db.appendToJsonFile(filename, s)
 }
 
 // UnsubscribeToPosts removes the subscription of the given user from the posts
 // of the local user.
 func (db *DB) UnsubscribeToPosts(tx ReadWriteTx, user UserID) error {
 	dir := filepath.Join(db.root, postsDir)
-	if err := os.MkdirAll(dir, 0700); err != nil {
+	if err := db.fs().MkdirAll(dir); err != nil {
 		return err
 	}
 	filename := filepath.Join(dir, postsSubscribers)
 
-	f, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE, 0600)
-	if err != nil {
+	b, err := db.fs().ReadFile(filename)
+	if err != nil && !os.IsNotExist(err) {
 		return err
 	}
-	defer f.Close()
 
-	d := json.NewDecoder(f)
+	d := db.newJsonRecordDecoder(bytes.NewReader(b))
 	unsubscribed := false
 	ss := make([]subscription, 0, 16)
 	for {
@@ -138,23 +131,16 @@ func (db *DB) UnsubscribeToPosts(tx ReadWriteTx, user UserID) error {
 	}
 
 	// If we get here we can write the file back
-	_, err = f.Seek(0, io.SeekStart)
-	if err != nil {
-		return err
-	}
-	err = f.Truncate(0)
-	if err != nil {
-		return err
-	}
-	e := json.NewEncoder(f)
+	buf := new(bytes.Buffer)
 	for k := range ss {
-		err = e.Encode(ss[k])
+		rec, err := db.marshalJsonRecord(ss[k])
 		if err != nil {
 			return err
 		}
+		buf.Write(rec)
 	}
 
-	return nil
+	return db.fs().WriteFile(filename, buf.Bytes())
 }
 
 // ListSubscribers lists all users that are subscribed to our posts.
@@ -162,16 +148,15 @@ func (db *DB) ListPostSubscribers(tx ReadTx) ([]UserID, error) {
 	dir := filepath.Join(db.root, postsDir)
 	filename := filepath.Join(dir, postsSubscribers)
 
-	f, err := os.Open(filename)
+	b, err := db.fs().ReadFile(filename)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return nil, nil
 		}
 		return nil, err
 	}
-	defer f.Close()
 
-	d := json.NewDecoder(f)
+	d := db.newJsonRecordDecoder(bytes.NewReader(b))
 	subs := make([]UserID, 0, 16)
 	for {
 		var s subscription
@@ -194,16 +179,15 @@ func (db *DB) IsPostSubscriber(tx ReadTx, uid UserID) (bool, error) {
 	dir := filepath.Join(db.root, postsDir)
 	filename := filepath.Join(dir, postsSubscribers)
 
-	f, err := os.Open(filename)
+	b, err := db.fs().ReadFile(filename)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return false, nil
 		}
 		return false, err
 	}
-	defer f.Close()
 
-	d := json.NewDecoder(f)
+	d := db.newJsonRecordDecoder(bytes.NewReader(b))
 	for {
 		var s subscription
 		err = d.Decode(&s)
@@ -249,7 +233,7 @@ func (db *DB) CreatePost(tx ReadWriteTx, post, descr string, fname string,
 	}
 
 	dir := filepath.Join(db.root, postsDir, me.Public.Identity.String())
-	if err := os.MkdirAll(dir, 0700); err != nil {
+	if err := db.fs().MkdirAll(dir); err != nil {
 		return summ, p, err
 	}
 
@@ -281,22 +265,23 @@ func (db *DB) CreatePost(tx ReadWriteTx, post, descr string, fname string,
 
 	// Save the post.
 	postFname := filepath.Join(dir, pid.String())
-	f, err := os.Create(postFname)
+	buf := new(bytes.Buffer)
+	w := json.NewEncoder(buf)
+	err := w.Encode(p)
 	if err != nil {
 		return summ, p, err
 	}
-	defer f.Close()
-	w := json.NewEncoder(f)
-	err = w.Encode(p)
-	if err != nil {
+	if err := db.writeFile(postFname, buf.Bytes()); err != nil {
 		return summ, p, err
 	}
 
-	finfo, err := f.Stat()
+	finfo, err := db.fs().Stat(postFname)
 	if err != nil {
 		return summ, p, err
 	}
 
+	db.indexPost(me.Public.Identity, pid, &p, finfo.ModTime())
+
 	summ = PostSummFromMetadata(&p, me.Public.Identity)
 	summ.Date = finfo.ModTime()
 	return summ, p, nil
@@ -326,6 +311,16 @@ func (db *DB) verifyPostStatusUpdate(statusFname string, from UserID, pid PostID
 				return fmt.Errorf("%w: empty comment", ErrPostStatusValidation)
 			}
 
+		case rpc.RMPSReaction:
+			// Reactions are not signed in older versions.
+			if pms.Version < rpc.PostMetadataStatusReactionVersion {
+				return fmt.Errorf("%w: reaction in status version %d",
+					ErrPostStatusValidation, pms.Version)
+			}
+			if _, _, err := rpc.ParsePostReactionValue(v); err != nil {
+				return fmt.Errorf("%w: %v", ErrPostStatusValidation, err)
+			}
+
 		case rpc.RMPSignature, rpc.RMPNonce, rpc.RMPFromNick, rpc.RMPTimestamp:
 			// Ignore.
 
@@ -354,24 +349,26 @@ func (db *DB) verifyPostStatusUpdate(statusFname string, from UserID, pid PostID
 	//
 	// TODO: this is slow as it involves loading the entire status update
 	// file. Please improve.
-	f, err := os.Open(statusFname)
+	b, err := db.fs().ReadFile(statusFname)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return nil
 		}
 		return err
 	}
-	defer f.Close()
 
 	fromStr := from.String()
 	_, hearting := attr[rpc.RMPSHeart]
 	_, commenting := attr[rpc.RMPSComment]
+	reaction, unreacting, _ := rpc.ParsePostReactionValue(attr[rpc.RMPSReaction])
+	reacting := reaction != ""
 
 	var lastHeart string
 	var lastComment string
+	var reacted bool
 	hash := pms.Hash()
 
-	d := json.NewDecoder(f)
+	d := db.newJsonRecordDecoder(bytes.NewReader(b))
 	for {
 		var old rpc.PostMetadataStatus
 		err := d.Decode(&old)
@@ -397,6 +394,12 @@ func (db *DB) verifyPostStatusUpdate(statusFname string, from UserID, pid PostID
 		if oldc, ok := old.Attributes[rpc.RMPSComment]; ok && commenting {
 			lastComment = oldc
 		}
+		if oldr, ok := old.Attributes[rpc.RMPSReaction]; ok && reacting {
+			r, remove, err := rpc.ParsePostReactionValue(oldr)
+			if err == nil && r == reaction {
+				reacted = !remove
+			}
+		}
 	}
 
 	if hearting && lastHeart == attr[rpc.RMPSHeart] {
@@ -405,6 +408,9 @@ func (db *DB) verifyPostStatusUpdate(statusFname string, from UserID, pid PostID
 	if commenting && lastComment == attr[rpc.RMPSComment] {
 		return fmt.Errorf("%w: cannot send the exact same comment twice", ErrPostStatusValidation)
 	}
+	if reacting && reacted != unreacting {
+		return fmt.Errorf("%w: cannot send the same reaction twice", ErrPostStatusValidation)
+	}
 
 	return nil
 }
@@ -417,7 +423,7 @@ func (db *DB) AddPostStatus(tx ReadWriteTx, postFrom, statusFrom UserID, pid Pos
 	// Ensure post exists.
 	dir := filepath.Join(db.root, postsDir, postFrom.String())
 	postFname := filepath.Join(dir, pid.String())
-	if _, err := os.Stat(postFname); err != nil {
+	if _, err := db.fs().Stat(postFname); err != nil {
 		return err
 	}
 
@@ -428,16 +434,11 @@ func (db *DB) AddPostStatus(tx ReadWriteTx, postFrom, statusFrom UserID, pid Pos
 	}
 
 	// Append to the status update of the post.
-	f, err := os.OpenFile(statusFname, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-	e := json.NewEncoder(f)
-	err = e.Encode(pms)
-	if err != nil {
+	if err := db.appendToJsonFile(statusFname, pms); err != nil {
 		return err
 	}
+
+	db.indexPostComment(postFrom, pid, pms, time.Now())
 	return nil
 }
 
@@ -455,25 +456,26 @@ func (db *DB) SaveReceivedPost(tx ReadWriteTx, from UserID, p rpc.PostMetadata)
 	}
 
 	dir := filepath.Join(db.root, postsDir, from.String())
-	if err := os.MkdirAll(dir, 0o700); err != nil {
+	if err := db.fs().MkdirAll(dir); err != nil {
 		return pid, summ, fmt.Errorf("unable to make received posts dir: %v", err)
 	}
 	fname := filepath.Join(dir, pid.String())
-	f, err := os.Create(fname)
-	if err != nil {
+	buf := new(bytes.Buffer)
+	w := json.NewEncoder(buf)
+	if err := w.Encode(p); err != nil {
 		return pid, summ, err
 	}
-	defer f.Close()
-	w := json.NewEncoder(f)
-	if err := w.Encode(p); err != nil {
+	if err := db.writeFile(fname, buf.Bytes()); err != nil {
 		return pid, summ, err
 	}
 
-	finfo, err := f.Stat()
+	finfo, err := db.fs().Stat(fname)
 	if err != nil {
 		return pid, summ, err
 	}
 
+	db.indexPost(from, pid, &p, finfo.ModTime())
+
 	summ = PostSummFromMetadata(&p, from)
 	summ.Date = finfo.ModTime()
 	return pid, summ, nil
@@ -489,11 +491,17 @@ func (db *DB) AddPostStatusUpdate(tx ReadWriteTx, from UserID, p rpc.PostMetadat
 		return UserID{}, rpc.PostMetadataStatus{}, err
 	}
 
-	if version, ok := p.Attributes[rpc.RMPVersion]; !ok {
+	var version uint64
+	if s, ok := p.Attributes[rpc.RMPVersion]; !ok {
 		return fail(fmt.Errorf("post status does not have a version field"))
-	} else if version != strconv.Itoa(rpc.PostMetadataStatusVersion) {
-		return fail(fmt.Errorf("cannot accept status updates with version different then %d",
-			rpc.PostMetadataStatusVersion))
+	} else if v, err := strconv.ParseUint(s, 10, 64); err != nil ||
+		v < rpc.PostMetadataStatusVersion ||
+		v > rpc.PostMetadataStatusReactionVersion {
+		return fail(fmt.Errorf("cannot accept status updates with version "+
+			"different then %d or %d", rpc.PostMetadataStatusVersion,
+			rpc.PostMetadataStatusReactionVersion))
+	} else {
+		version = v
 	}
 
 	if s, ok := p.Attributes[rpc.RMPIdentifier]; !ok {
@@ -510,7 +518,7 @@ func (db *DB) AddPostStatusUpdate(tx ReadWriteTx, from UserID, p rpc.PostMetadat
 	}
 
 	update := rpc.PostMetadataStatus{
-		Version:    rpc.PostMetadataStatusVersion,
+		Version:    version,
 		From:       statusFrom.String(),
 		Link:       pid.String(),
 		Attributes: p.Attributes,
@@ -523,37 +531,86 @@ func (db *DB) AddPostStatusUpdate(tx ReadWriteTx, from UserID, p rpc.PostMetadat
 	}
 
 	// Append to the status update of the post.
-	f, err := os.OpenFile(statusFname, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
-	if err != nil {
-		return fail(err)
-	}
-	defer f.Close()
-	e := json.NewEncoder(f)
-	err = e.Encode(update)
-	if err != nil {
+	if err := db.appendToJsonFile(statusFname, update); err != nil {
 		return fail(err)
 	}
 
+	db.indexPostComment(from, pid, &update, time.Now())
+
 	return statusFrom, update, nil
 }
 
 func (db *DB) readPost(fname string) (*rpc.PostMetadata, error) {
-	data, err := os.ReadFile(fname)
+	data, err := db.readFile(fname)
 	if err != nil {
 		return nil, err
 	}
+	return unmarshalPost(data)
+}
+
+func unmarshalPost(data []byte) (*rpc.PostMetadata, error) {
 	pm := new(rpc.PostMetadata)
-	err = json.Unmarshal(data, pm)
+	err := json.Unmarshal(data, pm)
 	if err != nil {
 		return nil, err
 	}
 	return pm, err
 }
 
+// readAuthorPosts reads all posts stored in the given author dir. For each
+// post, it calls f with the info of the post file and of its status file (if
+// it exists).
+func (db *DB) readAuthorPosts(authorDir string, f func(post *rpc.PostMetadata,
+	finfo, statusInfo fs.FileInfo)) error {
+
+	// Read all files at once, so that storage drivers may fetch the
+	// whole dir in a single query.
+	files, err := db.fs().ReadDirFiles(authorDir)
+	if err != nil {
+		return err
+	}
+	statusInfos := make(map[string]fs.FileInfo)
+	for _, file := range files {
+		name := file.info.Name()
+		if strings.HasSuffix(name, postsStatusExt) {
+			statusInfos[strings.TrimSuffix(name, postsStatusExt)] = file.info
+		}
+	}
+
+	for _, file := range files {
+		// Skip if it's the status update file.
+		name := file.info.Name()
+		if strings.HasSuffix(name, postsStatusExt) {
+			continue
+		}
+
+		fullPath := filepath.Join(authorDir, name)
+		pid := new(PostID)
+		if err := pid.FromString(name); err != nil {
+			db.log.Warnf("Entry %s is not a PostID: %v",
+				fullPath, err)
+			continue
+		}
+
+		data, err := db.openData(file.data)
+		if err != nil {
+			db.log.Warnf("Unable to decrypt post %s: %v", fullPath, err)
+			continue
+		}
+		post, err := unmarshalPost(data)
+		if err != nil {
+			db.log.Warnf("Unable to read post %s: %v", fullPath, err)
+			continue
+		}
+		f(post, file.info, statusInfos[name])
+	}
+	return nil
+}
+
 // ListPosts returns a summary of all received posts.
 func (db *DB) ListPosts(tx ReadTx) ([]PostSummary, error) {
 	rootDir := filepath.Join(db.root, postsDir)
-	authorDirs, err := os.ReadDir(rootDir)
+	authorDirs, err := db.fs().ReadDir(rootDir)
 	if os.IsNotExist(err) {
 		return nil, nil
 	} else if err != nil {
@@ -574,52 +631,22 @@ func (db *DB) ListPosts(tx ReadTx) ([]PostSummary, error) {
 			continue
 		}
 
-		postDirs, err := os.ReadDir(fullDir)
-		if err != nil {
-			return nil, err
-		}
-		for _, postFile := range postDirs {
-			if postFile.IsDir() {
-				continue
-			}
-
-			// Skip if it's the status update file.
-			if strings.HasSuffix(postFile.Name(), postsStatusExt) {
-				continue
-			}
-
-			fullPath := filepath.Join(fullDir, postFile.Name())
-			pid := new(PostID)
-			if err := pid.FromString(postFile.Name()); err != nil {
-				db.log.Warnf("Entry %s is not a PostID: %v",
-					fullPath, err)
-				continue
-			}
-
-			finfo, err := postFile.Info()
-			if err != nil {
-				db.log.Warnf("Unable to get FINFO of %s: %v",
-					fullPath, err)
-				continue
-			}
-
-			post, err := db.readPost(fullPath)
-			if err != nil {
-				db.log.Warnf("Unable to read post %s: %v", fullPath, err)
-				continue
-			}
+		err := db.readAuthorPosts(fullDir, func(post *rpc.PostMetadata,
+			finfo, statusInfo fs.FileInfo) {
 
 			// Check time of last status.
 			var lastStatusTime time.Time
-			statusFname := fullPath + postsStatusExt
-			if finfo, err := os.Stat(statusFname); err == nil {
-				lastStatusTime = finfo.ModTime()
+			if statusInfo != nil {
+				lastStatusTime = statusInfo.ModTime()
 			}
 
 			summ := PostSummFromMetadata(post, *from)
 			summ.Date = finfo.ModTime()
 			summ.LastStatusTS = lastStatusTime
 			res = append(res, summ)
+		})
+		if err != nil {
+			return nil, err
 		}
 	}
 
@@ -631,37 +658,13 @@ func (db *DB) ListUserPosts(tx ReadTx, from UserID) ([]rpc.PostMetadata, error)
 	rootDir := filepath.Join(db.root, postsDir)
 	authorDir := filepath.Join(rootDir, from.String())
 
-	postFiles, err := os.ReadDir(authorDir)
-	if err != nil {
-		return nil, err
-	}
 	var res []rpc.PostMetadata
-	for _, postFile := range postFiles {
-		if postFile.IsDir() {
-			continue
-		}
-
-		// Skip if it's the status update file.
-		if strings.HasSuffix(postFile.Name(), postsStatusExt) {
-			continue
-		}
-
-		fullPath := filepath.Join(authorDir, postFile.Name())
-		pid := new(PostID)
-		if err := pid.FromString(postFile.Name()); err != nil {
-			db.log.Warnf("Entry %s is not a PostID: %v",
-				fullPath, err)
-			continue
-		}
-
-		post, err := db.readPost(fullPath)
-		if err != nil {
-			db.log.Warnf("Unable to read post %s: %v", fullPath, err)
-			continue
-		}
+	err := db.readAuthorPosts(authorDir, func(post *rpc.PostMetadata, _, _ fs.FileInfo) {
 		res = append(res, *post)
+	})
+	if err != nil {
+		return nil, err
 	}
-
 	return res, nil
 }
 
@@ -683,7 +686,7 @@ func (db *DB) ReadPost(tx ReadTx, from UserID, post PostID) (rpc.PostMetadata, e
 func (db *DB) PostExists(tx ReadTx, from UserID, post PostID) (bool, error) {
 	filepath := filepath.Join(db.root, postsDir, from.String(),
 		post.String())
-	_, err := os.Stat(filepath)
+	_, err := db.fs().Stat(filepath)
 	if os.IsNotExist(err) {
 		return false, nil
 	}
@@ -696,7 +699,7 @@ func (db *DB) PostExists(tx ReadTx, from UserID, post PostID) (bool, error) {
 // ListPostRelayers lists everyone that has relayed (to us) the specified post.
 func (db *DB) ListPostRelayers(tx ReadTx, post PostID) ([]UserID, error) {
 	pattern := filepath.Join(db.root, postsDir, "*", post.String())
-	files, err := filepath.Glob(pattern)
+	files, err := db.fs().Glob(pattern)
 	if err != nil {
 		return nil, err
 	}
@@ -722,15 +725,19 @@ func (db *DB) ListPostStatusUpdates(tx ReadTx, from UserID,
 
 	statusFname := filepath.Join(db.root, postsDir, from.String(),
 		post.String()+postsStatusExt)
-	f, err := os.Open(statusFname)
+	return db.readPostStatusUpdates(statusFname)
+}
+
+// readPostStatusUpdates reads the status updates stored in the given file.
+func (db *DB) readPostStatusUpdates(statusFname string) ([]rpc.PostMetadataStatus, error) {
+	b, err := db.fs().ReadFile(statusFname)
 	if err != nil && os.IsNotExist(err) {
 		return nil, nil // Empty list of status updates.
 	} else if err != nil {
 		return nil, err
 	}
-	defer f.Close()
 
-	d := json.NewDecoder(f)
+	d := db.newJsonRecordDecoder(bytes.NewReader(b))
 	var res []rpc.PostMetadataStatus
 	for {
 		var pms rpc.PostMetadataStatus
@@ -746,71 +753,63 @@ func (db *DB) ListPostStatusUpdates(tx ReadTx, from UserID,
 	return res, nil
 }
 
-func (db *DB) replacePostSubscription(to UserID, add bool) error {
-	fname := filepath.Join(db.root, postsDir, postsSubscriptions)
-	if _, err := os.Stat(fname); os.IsNotExist(err) {
-		// Subscriptions file does not exist, so do the quick action.
-		if !add {
-			return nil // Nothing to do.
+// PostReactions aggregates the reactions sent as status updates of the given
+// post.
+func PostReactions(statuses []rpc.PostMetadataStatus) Reactions {
+	res := make(Reactions)
+	for i := range statuses {
+		v, ok := statuses[i].Attributes[rpc.RMPSReaction]
+		if !ok {
+			continue
 		}
-
-		// Add the subscription
-		sub := PostSubscription{To: to, Date: time.Now()}
-		f, err := os.OpenFile(fname, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+		reaction, remove, err := rpc.ParsePostReactionValue(v)
 		if err != nil {
-			return err
+			continue
 		}
-		defer f.Close()
-		enc := json.NewEncoder(f)
-		if err := enc.Encode(sub); err != nil {
-			return err
+		var from UserID
+		if err := from.FromString(statuses[i].From); err != nil {
+			continue
 		}
-		return nil
+		res.Apply(from, reaction, remove)
 	}
+	return res
+}
 
-	// Subscription file exists. Create a new one and copy over contents.
-
-	// Cleanup changes depending on which actions complete from now on, so
-	// close over a modifiable cleanup function.
-	cleanup := func() {}
-	defer func() { cleanup() }()
-
-	// Create new file and clean it up by deleting it as well.
-	newFname := filepath.Join(db.root, postsDir, "."+postsSubscriptions+".new")
-	newf, err := os.OpenFile(newFname, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+// ListPostReactions returns the aggregated reactions to the given post.
+func (db *DB) ListPostReactions(tx ReadTx, from UserID, post PostID) (Reactions, error) {
+	statuses, err := db.ListPostStatusUpdates(tx, from, post)
 	if err != nil {
-		return err
-	}
-	cleanup = func() {
-		newf.Close()
-		os.Remove(newFname)
+		return nil, err
 	}
+	return PostReactions(statuses), nil
+}
 
-	// Open old file for reading.
-	oldf, err := os.Open(fname)
-	if err != nil {
+func (db *DB) replacePostSubscription(to UserID, add bool) error {
+	fname := filepath.Join(db.root, postsDir, postsSubscriptions)
+	old, err := db.fs().ReadFile(fname)
+	if err != nil && !os.IsNotExist(err) {
 		return err
 	}
-	cleanup = func() {
-		oldf.Close()
-		newf.Close()
-		os.Remove(newFname)
+	if len(old) == 0 && !add {
+		return nil // Nothing to do.
 	}
 
-	// Start reading from the old file until we find the entry we want to
-	// replace (if it exists).
-	dec := json.NewDecoder(oldf)
-	enc := json.NewEncoder(newf)
+	// Copy over the existing entries, skipping the one we want to replace
+	// (if it exists).
+	buf := new(bytes.Buffer)
+	dec := db.newJsonRecordDecoder(bytes.NewReader(old))
 	var sub PostSubscription
 	for err = dec.Decode(&sub); err == nil; err = dec.Decode(&sub) {
 		if sub.To == to {
 			// Found it! Skip this entry.
 			continue
 		}
-		if eerr := enc.Encode(&sub); eerr != nil { // Rewrite old entry
+		rec, eerr := db.marshalJsonRecord(&sub) // Rewrite old entry
+		if eerr != nil {
 			err = eerr
 			break
 		}
+		buf.Write(rec)
 	}
 	if err != nil && !errors.Is(err, io.EOF) {
 		return err
@@ -819,37 +818,21 @@ func (db *DB) replacePostSubscription(to UserID, add bool) error {
 	// Write (or skip) the target entry.
 	if add {
 		sub := PostSubscription{To: to, Date: time.Now()}
-		if err = enc.Encode(sub); err != nil {
+		rec, err := db.marshalJsonRecord(sub)
+		if err != nil {
 			return err
 		}
+		buf.Write(rec)
 	}
 
-	if err := newf.Sync(); err != nil {
-		return err
-	}
-
-	// Close both files.
-	oldf.Close()
-	newf.Close()
-	cleanup = func() {
-		os.Remove(newFname)
-	}
-
-	// Rename new file to old file.
-	if err := os.Rename(newFname, fname); err != nil {
-		return err
-	}
-	cleanup = func() {}
-
-	// All done!
-	return nil
+	return db.fs().WriteFile(fname, buf.Bytes())
 }
 
 // StorePostSubscription stores that the local user has subscribed to the posts
 // of the given user.
 func (db *DB) StorePostSubscription(tx ReadWriteTx, to UserID) error {
 	dir := filepath.Join(db.root, postsDir)
-	if err := os.MkdirAll(dir, 0o700); err != nil {
+	if err := db.fs().MkdirAll(dir); err != nil {
 		return err
 	}
 
@@ -860,7 +843,7 @@ func (db *DB) StorePostSubscription(tx ReadWriteTx, to UserID) error {
 // posts of the given remote user.
 func (db *DB) StorePostUnsubscription(tx ReadWriteTx, to UserID) error {
 	dir := filepath.Join(db.root, postsDir)
-	if err := os.MkdirAll(dir, 0o700); err != nil {
+	if err := db.fs().MkdirAll(dir); err != nil {
 		return err
 	}
 
@@ -871,16 +854,14 @@ func (db *DB) StorePostUnsubscription(tx ReadWriteTx, to UserID) error {
 func (db *DB) ListPostSubscriptions(tx ReadTx) ([]PostSubscription, error) {
 	fname := filepath.Join(db.root, postsDir, postsSubscriptions)
 
-	// Open old file for reading.
-	f, err := os.Open(fname)
+	b, err := db.fs().ReadFile(fname)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return nil, nil
 		}
 		return nil, err
 	}
-	defer f.Close()
-	dec := json.NewDecoder(f)
+	dec := db.newJsonRecordDecoder(bytes.NewReader(b))
 	var sub PostSubscription
 	var res []PostSubscription
 
@@ -900,16 +881,14 @@ func (db *DB) ListPostSubscriptions(tx ReadTx) ([]PostSubscription, error) {
 func (db *DB) IsPostSubscription(tx ReadTx, uid UserID) (bool, error) {
 	fname := filepath.Join(db.root, postsDir, postsSubscriptions)
 
-	// Open old file for reading.
-	f, err := os.Open(fname)
+	b, err := db.fs().ReadFile(fname)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return false, nil
 		}
 		return false, err
 	}
-	defer f.Close()
-	dec := json.NewDecoder(f)
+	dec := db.newJsonRecordDecoder(bytes.NewReader(b))
 	var sub PostSubscription
 
 	// Iterate file.
@@ -934,16 +913,20 @@ func (db *DB) RelayPost(tx ReadWriteTx, from UserID, pid PostID,
 	dstFilename := filepath.Join(db.root, postsDir, me.Public.Identity.String(),
 		pid.String())
 
-	firstTime := !fileExists(dstFilename)
+	firstTime := !db.exists(dstFilename)
 	if firstTime {
-		if err := os.MkdirAll(filepath.Dir(dstFilename), 0o700); err != nil {
+		if err := db.fs().MkdirAll(filepath.Dir(dstFilename)); err != nil {
 			return rpc.PostMetadata{}, firstTime, err
 		}
 
 		// Relayed post does not exist. Copy from source to dest.
 		srcFilename := filepath.Join(db.root, postsDir, from.String(),
 			pid.String())
-		if err := copyFile(srcFilename, dstFilename); err != nil {
+		data, err := db.fs().ReadFile(srcFilename)
+		if err != nil {
+			return rpc.PostMetadata{}, firstTime, err
+		}
+		if err := db.fs().WriteFile(dstFilename, data); err != nil {
 			return rpc.PostMetadata{}, firstTime, err
 		}
 	}
@@ -0,0 +1,546 @@
+package clientdb
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/companyzero/bisonrelay/rpc"
+	"github.com/companyzero/bisonrelay/zkidentity"
+)
+
+// gcRetention is the retention policy of a GC as stored in the db.
+type gcRetention struct {
+	rpc.RetentionPolicy
+
+	// Since is the time from which the policy applies. Messages logged
+	// before it are not removed by the policy. It is empty when the policy
+	// applies to every message.
+	Since time.Time `json:"since,omitempty"`
+}
+
+// retentionExpired returns a function that reports whether a message logged at
+// the given time should be removed according to the policy, which applies to
+// the messages logged since the given time. It returns nil if no messages
+// should be removed based on their age.
+//
+// Messages of conversations that disappear after being read are not removed
+// based on their age, but when they are marked as read.
+func retentionExpired(policy rpc.RetentionPolicy, since, now time.Time) func(ts time.Time) bool {
+	if policy.Mode != rpc.RetentionModeDays {
+		return nil
+	}
+	cutoff := now.AddDate(0, 0, -int(policy.Days))
+	return func(ts time.Time) bool {
+		return ts.Before(cutoff) && !ts.Before(since)
+	}
+}
+
+// SetUserRetention sets the retention policy of the messages exchanged with
+// the given user. The policy only applies to the messages logged since the
+// given time, or to every message if since is empty.
+func (db *DB) SetUserRetention(tx ReadWriteTx, uid UserID, policy rpc.RetentionPolicy,
+	since time.Time) error {
+
+	return db.updateBaseABEntry(uid, func(entry *AddressBookEntry) {
+		entry.Retention = policy
+		entry.RetentionSince = since
+	})
+}
+
+// GetUserRetention returns the retention policy of the messages exchanged
+// with the given user.
+func (db *DB) GetUserRetention(tx ReadTx, uid UserID) (rpc.RetentionPolicy, error) {
+	entry, err := db.getBaseABEntry(uid)
+	if err != nil {
+		return rpc.RetentionPolicy{}, err
+	}
+	return entry.Retention, nil
+}
+
+// SetGCRetention sets the retention policy of the messages of the given GC.
+// The policy only applies to the messages logged since the given time, or to
+// every message if since is empty.
+func (db *DB) SetGCRetention(tx ReadWriteTx, gcID zkidentity.ShortID,
+	policy rpc.RetentionPolicy, since time.Time) error {
+
+	gcFname := filepath.Join(db.root, groupchatDir, gcID.String())
+	if !db.exists(gcFname) {
+		return fmt.Errorf("gc %s: %w", gcID, ErrNotFound)
+	}
+	fname := gcFname + gcRetentionExt
+	if policy.Mode == rpc.RetentionModeForever {
+		return db.removeIfExists(fname)
+	}
+	return db.saveJsonFile(fname, gcRetention{RetentionPolicy: policy, Since: since})
+}
+
+// getGCRetention returns the stored retention policy of the given GC.
+func (db *DB) getGCRetention(gcID zkidentity.ShortID) (gcRetention, error) {
+	var retention gcRetention
+	fname := filepath.Join(db.root, groupchatDir, gcID.String()+gcRetentionExt)
+	err := db.readJsonFile(fname, &retention)
+	if errors.Is(err, ErrNotFound) {
+		return retention, nil
+	}
+	return retention, err
+}
+
+// GetGCRetention returns the retention policy of the messages of the given
+// GC.
+func (db *DB) GetGCRetention(tx ReadTx, gcID zkidentity.ShortID) (rpc.RetentionPolicy, error) {
+	retention, err := db.getGCRetention(gcID)
+	return retention.RetentionPolicy, err
+}
+
+// pruneHistory removes the entries of the history stored in dir for which
+// remove returns true. The ids of the remaining entries are not changed.
+func (db *DB) pruneHistory(dir string, remove func(e *HistoryEntry) bool) error {
+	entries, err := db.fs().ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || entry.Name() == historyMetaFile {
+			continue
+		}
+		fname := filepath.Join(dir, entry.Name())
+		b, err := db.fs().ReadFile(fname)
+		if err != nil {
+			return err
+		}
+
+		removed := false
+		kept := new(bytes.Buffer)
+		dec := db.newJsonRecordDecoder(bytes.NewReader(b))
+		for {
+			var e HistoryEntry
+			err := dec.Decode(&e)
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return fmt.Errorf("unable to decode history entry: %v", err)
+			}
+			if remove(&e) {
+				removed = true
+				continue
+			}
+			rec, err := db.marshalJsonRecord(e)
+			if err != nil {
+				return err
+			}
+			kept.Write(rec)
+		}
+
+		switch {
+		case !removed:
+		case kept.Len() == 0:
+			err = db.fs().Remove(fname)
+		default:
+			err = db.fs().WriteFile(fname, kept.Bytes())
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pruneSearchIndex removes from the search index the docs for which remove
+// returns true.
+func (db *DB) pruneSearchIndex(remove func(doc *SearchResult) bool) error {
+	docsDir := filepath.Join(db.root, searchDir, searchDocsDir)
+	entries, err := db.fs().ReadDir(docsDir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	removedIDs := make(map[uint64]struct{})
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		fname := filepath.Join(docsDir, entry.Name())
+		b, err := db.fs().ReadFile(fname)
+		if err != nil {
+			return err
+		}
+
+		nbRemoved := len(removedIDs)
+		kept := new(bytes.Buffer)
+		dec := db.newJsonRecordDecoder(bytes.NewReader(b))
+		for {
+			var doc SearchResult
+			err := dec.Decode(&doc)
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return fmt.Errorf("unable to decode search doc: %v", err)
+			}
+			if remove(&doc) {
+				removedIDs[doc.ID] = struct{}{}
+				continue
+			}
+			rec, err := db.marshalJsonRecord(doc)
+			if err != nil {
+				return err
+			}
+			kept.Write(rec)
+		}
+
+		switch {
+		case nbRemoved == len(removedIDs):
+		case kept.Len() == 0:
+			err = db.fs().Remove(fname)
+		default:
+			err = db.fs().WriteFile(fname, kept.Bytes())
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if len(removedIDs) == 0 {
+		return nil
+	}
+
+	// Remove the postings of the removed docs, so that the terms of the
+	// removed messages are not kept in the index.
+	termsDir := filepath.Join(db.root, searchDir, searchTermsDir)
+	entries, err = db.fs().ReadDir(termsDir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		fname := filepath.Join(termsDir, entry.Name())
+		b, err := db.fs().ReadFile(fname)
+		if err != nil {
+			return err
+		}
+
+		removed := false
+		kept := new(bytes.Buffer)
+		dec := db.newJsonRecordDecoder(bytes.NewReader(b))
+		for {
+			var posting searchPosting
+			err := dec.Decode(&posting)
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return fmt.Errorf("unable to decode search posting: %v", err)
+			}
+			if _, ok := removedIDs[posting.Doc]; ok {
+				removed = true
+				continue
+			}
+			rec, err := db.marshalJsonRecord(posting)
+			if err != nil {
+				return err
+			}
+			kept.Write(rec)
+		}
+
+		switch {
+		case !removed:
+		case kept.Len() == 0:
+			err = db.fs().Remove(fname)
+		default:
+			err = db.fs().WriteFile(fname, kept.Bytes())
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pruneMsgLogs removes the lines for which expired returns true from the
+// message logs that match the given pattern.
+func (db *DB) pruneMsgLogs(pattern string, expired func(ts time.Time) bool) error {
+	if db.cfg.MsgsRoot == "" {
+		return nil
+	}
+
+	fnames, err := filepath.Glob(filepath.Join(db.cfg.MsgsRoot, pattern))
+	if err != nil {
+		return err
+	}
+	const tsLayout = "2006-01-02T15:04"
+	for _, fname := range fnames {
+		b, err := os.ReadFile(fname)
+		if err != nil {
+			return err
+		}
+
+		// Lines that do not start with a timestamp (for example, when
+		// the log was manually edited) follow the previous line.
+		removed, removeLine := false, true
+		kept := new(bytes.Buffer)
+		s := bufio.NewScanner(bytes.NewReader(b))
+		s.Buffer(nil, len(b)+1)
+		for s.Scan() {
+			line := s.Text()
+			plain, err := db.openString(line)
+			if err != nil {
+				return err
+			}
+			if len(plain) >= len(tsLayout) {
+				ts, err := time.ParseInLocation(tsLayout,
+					plain[:len(tsLayout)], time.Local)
+				if err == nil {
+					removeLine = expired(ts)
+				}
+			}
+			if removeLine {
+				removed = true
+				continue
+			}
+			kept.WriteString(line)
+			kept.WriteRune('\n')
+		}
+		if err := s.Err(); err != nil {
+			return err
+		}
+
+		switch {
+		case !removed:
+		case kept.Len() == 0:
+			err = os.Remove(fname)
+			delete(db.lastMsgTS, filepath.Base(fname))
+		default:
+			err = os.WriteFile(fname, kept.Bytes(), 0o600)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pruneUserPosts removes the posts received from the given user for which
+// expired returns true.
+func (db *DB) pruneUserPosts(uid UserID, expired func(ts time.Time) bool) error {
+	dir := filepath.Join(db.root, postsDir, uid.String())
+	entries, err := db.fs().ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasSuffix(entry.Name(), postsStatusExt) {
+			continue
+		}
+		var pid PostID
+		if err := pid.FromString(entry.Name()); err != nil {
+			continue
+		}
+		finfo, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		if !expired(finfo.ModTime()) {
+			continue
+		}
+		fname := filepath.Join(dir, entry.Name())
+		if err := db.fs().Remove(fname); err != nil {
+			return err
+		}
+		if err := db.removeIfExists(fname + postsStatusExt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PruneUserMessages removes the messages exchanged with the given user that
+// should no longer be kept according to the stored policy. When the policy
+// keeps messages for a number of days, posts received from the user are also
+// removed once they are older than that.
+func (db *DB) PruneUserMessages(tx ReadWriteTx, uid UserID, now time.Time) error {
+	entry, err := db.getBaseABEntry(uid)
+	if err != nil {
+		return err
+	}
+	return db.pruneUserMessages(uid, entry, now)
+}
+
+func (db *DB) pruneUserMessages(uid UserID, entry *AddressBookEntry, now time.Time) error {
+	expired := retentionExpired(entry.Retention, entry.RetentionSince, now)
+	if expired == nil {
+		return nil
+	}
+
+	dir := filepath.Join(db.root, historyDir, historyPMDir, uid.String())
+	err := db.pruneHistory(dir, func(e *HistoryEntry) bool {
+		return expired(e.Timestamp)
+	})
+	if err != nil {
+		return fmt.Errorf("unable to prune PM history: %v", err)
+	}
+	if err := db.pruneUserPosts(uid, expired); err != nil {
+		return fmt.Errorf("unable to prune posts: %v", err)
+	}
+	err = db.pruneSearchIndex(func(doc *SearchResult) bool {
+		if doc.UID != uid || !expired(doc.Timestamp) {
+			return false
+		}
+		switch doc.Type {
+		case SearchTypePM, SearchTypePost, SearchTypePostComment:
+			return true
+		default:
+			return false
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("unable to prune search index: %v", err)
+	}
+	if err := db.pruneMsgLogs("*."+uid.String()+".log", expired); err != nil {
+		return fmt.Errorf("unable to prune PM logs: %v", err)
+	}
+	return nil
+}
+
+// PruneGCMessages removes the messages of the given GC that should no longer
+// be kept according to the stored policy.
+func (db *DB) PruneGCMessages(tx ReadWriteTx, gcID zkidentity.ShortID, now time.Time) error {
+	retention, err := db.getGCRetention(gcID)
+	if err != nil {
+		return err
+	}
+	expired := retentionExpired(retention.RetentionPolicy, retention.Since, now)
+	if expired == nil {
+		return nil
+	}
+
+	dir := filepath.Join(db.root, historyDir, historyGCDir, gcID.String())
+	err = db.pruneHistory(dir, func(e *HistoryEntry) bool {
+		return expired(e.Timestamp)
+	})
+	if err != nil {
+		return fmt.Errorf("unable to prune GC history: %v", err)
+	}
+	err = db.pruneSearchIndex(func(doc *SearchResult) bool {
+		return doc.Type == SearchTypeGC && doc.GCID == gcID &&
+			expired(doc.Timestamp)
+	})
+	if err != nil {
+		return fmt.Errorf("unable to prune search index: %v", err)
+	}
+	if err := db.pruneMsgLogs("groupchat.*."+gcID.String()+".log", expired); err != nil {
+		return fmt.Errorf("unable to prune GC logs: %v", err)
+	}
+	return nil
+}
+
+// PruneExpiredMessages enforces the retention policies of every user and GC,
+// removing the messages that should no longer be kept.
+func (db *DB) PruneExpiredMessages(tx ReadWriteTx, now time.Time) error {
+	entries, err := db.fs().ReadDir(filepath.Join(db.root, inboundDir))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	var uid UserID
+	for _, v := range entries {
+		if err := uid.FromString(v.Name()); err != nil {
+			continue
+		}
+		entry, err := db.getBaseABEntry(uid)
+		if err != nil {
+			db.log.Warnf("Unable to load addressbook entry %s: %v",
+				uid, err)
+			continue
+		}
+		if err := db.pruneUserMessages(uid, entry, now); err != nil {
+			return err
+		}
+	}
+
+	gcs, err := db.ListGCs(tx)
+	if err != nil {
+		return err
+	}
+	for _, gc := range gcs {
+		if err := db.PruneGCMessages(tx, gc.ID, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RemoveReadPM removes the message with the given id sent by from from the PM
+// history with the given user, if the messages exchanged with the user
+// disappear after being read. It does nothing otherwise.
+func (db *DB) RemoveReadPM(tx ReadWriteTx, uid UserID, msgID zkidentity.ShortID,
+	from UserID) error {
+
+	entry, err := db.getBaseABEntry(uid)
+	if err != nil {
+		return err
+	}
+	if entry.Retention.Mode != rpc.RetentionModeAfterRead || msgID.IsEmpty() {
+		return nil
+	}
+
+	dir := filepath.Join(db.root, historyDir, historyPMDir, uid.String())
+	return db.pruneHistory(dir, func(e *HistoryEntry) bool {
+		return !e.Internal && e.MsgID == msgID && e.FromUID == from
+	})
+}
+
+// RemoveReadGCMsgs removes the message with the given id and every message
+// logged before it from the history of the given GC, if the messages of the
+// GC disappear after being read. It does nothing otherwise.
+func (db *DB) RemoveReadGCMsgs(tx ReadWriteTx, gcID, msgID zkidentity.ShortID) error {
+	retention, err := db.getGCRetention(gcID)
+	if err != nil {
+		return err
+	}
+	if retention.Mode != rpc.RetentionModeAfterRead || msgID.IsEmpty() {
+		return nil
+	}
+
+	// Find the entry of the read message first, so that the messages
+	// displayed before it are also removed. Nothing is modified on this
+	// pass.
+	dir := filepath.Join(db.root, historyDir, historyGCDir, gcID.String())
+	var readID uint64
+	err = db.pruneHistory(dir, func(e *HistoryEntry) bool {
+		if !e.Internal && e.MsgID == msgID {
+			readID = e.ID
+		}
+		return false
+	})
+	if err != nil {
+		return err
+	}
+	if readID == 0 {
+		return nil
+	}
+	return db.pruneHistory(dir, func(e *HistoryEntry) bool {
+		return e.ID <= readID
+	})
+}
@@ -27,7 +27,7 @@ type paidRV struct {
 func (db *DB) CleanupPaidRVs(tx ReadWriteTx, expirationDays int) error {
 	// Cleanup the paid RVs dir.
 	paidRVsDir := filepath.Join(db.root, paidRVsDir)
-	files, err := os.ReadDir(paidRVsDir)
+	files, err := db.fs().ReadDir(paidRVsDir)
 	if os.IsNotExist(err) {
 		return nil
 	}
@@ -47,7 +47,7 @@ func (db *DB) CleanupPaidRVs(tx ReadWriteTx, expirationDays int) error {
 			continue
 		}
 		if isDateLte(prv.TS, validLimit) {
-			err = os.Remove(filename)
+			err = db.fs().Remove(filename)
 			if err != nil {
 				db.log.Debugf("Unable to remove file %s: %v",
 					filename, err)
@@ -81,7 +81,7 @@ func (db *DB) IsRVPaid(tx ReadWriteTx, rv ratchet.RVPoint, expirationDays int) (
 	validLimit := time.Now().UTC().Add(-paidRVExpirationDuration)
 	if isDateLte(prv.TS, validLimit) {
 		// Not valid anymore.
-		if err := os.Remove(filename); err != nil {
+		if err := db.fs().Remove(filename); err != nil {
 			return false, err
 		}
 		return false, nil
@@ -101,7 +101,7 @@ func (db *DB) SaveRVPaid(tx ReadWriteTx, rv ratchet.RVPoint) error {
 // MarkRVUnpaid forcefully marks the given RV as unpaid.
 func (db *DB) MarkRVUnpaid(tx ReadWriteTx, rv ratchet.RVPoint) error {
 	filename := filepath.Join(db.root, paidRVsDir, rv.String())
-	err := os.Remove(filename)
+	err := db.fs().Remove(filename)
 	if os.IsNotExist(err) {
 		// Ignore unknown RVs.
 		return nil
@@ -0,0 +1,449 @@
+package clientdb
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/companyzero/bisonrelay/rpc"
+	"github.com/companyzero/bisonrelay/zkidentity"
+)
+
+const (
+	searchDir      = "search"
+	searchMetaFile = "meta.json"
+	searchDocsDir  = "docs"
+	searchTermsDir = "terms"
+
+	// searchDocsPerSegment is the number of docs stored in each docs
+	// segment file.
+	searchDocsPerSegment = 1024
+
+	// searchMinTermLen is the min number of runes in an indexed term.
+	searchMinTermLen = 2
+)
+
+// searchMeta is the metadata of the search index.
+type searchMeta struct {
+	NextID uint64 `json:"next_id"`
+}
+
+// searchPosting records that a term appears in a doc.
+type searchPosting struct {
+	Term string `json:"t"`
+	Doc  uint64 `json:"d"`
+}
+
+// searchTerms splits the text into the lowercase terms used in the search
+// index. Returned terms are unique.
+func searchTerms(text string) []string {
+	fields := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	seen := make(map[string]struct{}, len(fields))
+	res := make([]string, 0, len(fields))
+	for _, f := range fields {
+		if utf8.RuneCountInString(f) < searchMinTermLen {
+			continue
+		}
+		if _, ok := seen[f]; ok {
+			continue
+		}
+		seen[f] = struct{}{}
+		res = append(res, f)
+	}
+	return res
+}
+
+// searchTermFname returns the name of the file that stores the postings of the
+// given term. Terms are sharded into 256 files based on their hash.
+func (db *DB) searchTermFname(term string) string {
+	h := sha256.Sum256([]byte(term))
+	return filepath.Join(db.root, searchDir, searchTermsDir, hex.EncodeToString(h[:1]))
+}
+
+// searchDocsFname returns the name of the segment file that stores the given
+// doc.
+func (db *DB) searchDocsFname(id uint64) string {
+	return filepath.Join(db.root, searchDir, searchDocsDir,
+		fmt.Sprintf("%08d", id/searchDocsPerSegment))
+}
+
+// indexSearchDoc adds the given doc to the search index.
+func (db *DB) indexSearchDoc(doc SearchResult) error {
+	terms := searchTerms(doc.Text)
+	if len(terms) == 0 {
+		return nil
+	}
+
+	var meta searchMeta
+	metaFname := filepath.Join(db.root, searchDir, searchMetaFile)
+	err := db.readJsonFile(metaFname, &meta)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("unable to read search metadata: %v", err)
+	}
+	doc.ID = meta.NextID
+	meta.NextID += 1
+	if err := db.saveJsonFile(metaFname, meta); err != nil {
+		return err
+	}
+
+	if err := db.appendToJsonFile(db.searchDocsFname(doc.ID), doc); err != nil {
+		return err
+	}
+	for _, term := range terms {
+		posting := searchPosting{Term: term, Doc: doc.ID}
+		if err := db.appendToJsonFile(db.searchTermFname(term), posting); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tryIndexSearchDoc indexes the doc, logging any errors. Failing to index a doc
+// is not fatal for the operation that generated it.
+func (db *DB) tryIndexSearchDoc(doc SearchResult) {
+	if err := db.indexSearchDoc(doc); err != nil {
+		db.log.Warnf("Unable to index %s message for searching: %v",
+			doc.Type, err)
+	}
+}
+
+// searchTermDocs returns the set of docs that contain the given term.
+func (db *DB) searchTermDocs(term string) (map[uint64]struct{}, error) {
+	b, err := db.fs().ReadFile(db.searchTermFname(term))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	res := make(map[uint64]struct{})
+	dec := db.newJsonRecordDecoder(bytes.NewReader(b))
+	for {
+		var posting searchPosting
+		err := dec.Decode(&posting)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if posting.Term == term {
+			res[posting.Doc] = struct{}{}
+		}
+	}
+	return res, nil
+}
+
+// readSearchDocs reads the given docs from the index.
+func (db *DB) readSearchDocs(ids map[uint64]struct{}) ([]SearchResult, error) {
+	segments := make(map[uint64]struct{})
+	for id := range ids {
+		segments[id/searchDocsPerSegment] = struct{}{}
+	}
+
+	res := make([]SearchResult, 0, len(ids))
+	for seg := range segments {
+		b, err := db.fs().ReadFile(db.searchDocsFname(seg * searchDocsPerSegment))
+		if os.IsNotExist(err) {
+			// All docs of the segment were pruned.
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		dec := db.newJsonRecordDecoder(bytes.NewReader(b))
+		for {
+			var doc SearchResult
+			err := dec.Decode(&doc)
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := ids[doc.ID]; ok {
+				res = append(res, doc)
+			}
+		}
+	}
+	return res, nil
+}
+
+// matches returns true if the doc passes the filters.
+func (f *SearchFilters) matches(doc *SearchResult) bool {
+	if len(f.Types) > 0 {
+		found := false
+		for _, typ := range f.Types {
+			if typ == doc.Type {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if f.UID != nil && (doc.Type == SearchTypeGC || *f.UID != doc.UID) {
+		return false
+	}
+	if f.GCID != nil && (doc.Type != SearchTypeGC || *f.GCID != doc.GCID) {
+		return false
+	}
+	if !f.Since.IsZero() && doc.Timestamp.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && doc.Timestamp.After(f.Until) {
+		return false
+	}
+	return true
+}
+
+// Search returns the indexed messages that contain every term of the query and
+// that pass the filters. Results are sorted from newest to oldest.
+func (db *DB) Search(tx ReadTx, query string, filters SearchFilters) ([]SearchResult, error) {
+	terms := searchTerms(query)
+	if len(terms) == 0 {
+		return nil, ErrEmptySearchQuery
+	}
+
+	var ids map[uint64]struct{}
+	for i, term := range terms {
+		termIDs, err := db.searchTermDocs(term)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read search index: %v", err)
+		}
+		if i == 0 {
+			ids = termIDs
+		} else {
+			for id := range ids {
+				if _, ok := termIDs[id]; !ok {
+					delete(ids, id)
+				}
+			}
+		}
+		if len(ids) == 0 {
+			return nil, nil
+		}
+	}
+
+	docs, err := db.readSearchDocs(ids)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read search docs: %v", err)
+	}
+
+	res := docs[:0]
+	for i := range docs {
+		if filters.matches(&docs[i]) {
+			res = append(res, docs[i])
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Timestamp.Equal(res[j].Timestamp) {
+			return res[i].ID > res[j].ID
+		}
+		return res[i].Timestamp.After(res[j].Timestamp)
+	})
+	if filters.Limit > 0 && len(res) > filters.Limit {
+		res = res[:filters.Limit]
+	}
+	return res, nil
+}
+
+// indexPost indexes the contents of a post for searching. ts is the date the
+// post was stored.
+func (db *DB) indexPost(from UserID, pid PostID, p *rpc.PostMetadata, ts time.Time) {
+	text := p.Attributes[rpc.RMPMain]
+	if descr := p.Attributes[rpc.RMPDescription]; descr != "" {
+		text = descr + "\n" + text
+	}
+	db.tryIndexSearchDoc(SearchResult{
+		Type:      SearchTypePost,
+		UID:       from,
+		PostID:    pid,
+		From:      p.Attributes[rpc.RMPFromNick],
+		Timestamp: ts,
+		Text:      text,
+	})
+}
+
+// postStatusTimestamp returns the time the status update was created by its
+// author. The stored time is returned when the update does not have a valid
+// timestamp.
+func postStatusTimestamp(pms *rpc.PostMetadataStatus, stored time.Time) time.Time {
+	s, ok := pms.Attributes[rpc.RMPTimestamp]
+	if !ok {
+		return stored
+	}
+	ts, err := strconv.ParseInt(s, 16, 64)
+	if err != nil {
+		return stored
+	}
+	return time.Unix(ts, 0)
+}
+
+// indexPostComment indexes a comment made on a post. stored is the time the
+// comment was stored, which is used if the comment is not timestamped.
+func (db *DB) indexPostComment(postFrom UserID, pid PostID,
+	pms *rpc.PostMetadataStatus, stored time.Time) {
+
+	comment, ok := pms.Attributes[rpc.RMPSComment]
+	if !ok {
+		return
+	}
+	db.tryIndexSearchDoc(SearchResult{
+		Type:      SearchTypePostComment,
+		UID:       postFrom,
+		PostID:    pid,
+		From:      pms.Attributes[rpc.RMPFromNick],
+		Timestamp: postStatusTimestamp(pms, stored),
+		Text:      comment,
+	})
+}
+
+// indexHistory indexes the messages of the history stored in dir. The ids of
+// the docs are filled by f.
+func (db *DB) indexHistory(dir string, f func(doc *SearchResult)) error {
+	entries, err := db.readHistory(dir, 0, 0)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if e.Internal || e.Retracted {
+			continue
+		}
+		doc := SearchResult{
+			MsgID:     e.MsgID,
+			From:      e.From,
+			Timestamp: e.Timestamp,
+			Text:      e.Message,
+		}
+		f(&doc)
+		if err := db.indexSearchDoc(doc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reindexSearch rebuilds the search index from the stored messages and posts
+// when the index does not exist yet (for example, because the db was created
+// by a version that did not index messages).
+func (db *DB) reindexSearch() error {
+	metaFname := filepath.Join(db.root, searchDir, searchMetaFile)
+	if db.exists(metaFname) {
+		return nil
+	}
+
+	// Index the PMs.
+	pmDir := filepath.Join(db.root, historyDir, historyPMDir)
+	dirs, err := db.fs().ReadDir(pmDir)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, dir := range dirs {
+		var uid UserID
+		if err := uid.FromString(dir.Name()); err != nil {
+			continue
+		}
+		entry, err := db.getBaseABEntry(uid)
+		if err == nil && entry.Retention.Mode == rpc.RetentionModeAfterRead {
+			continue
+		}
+		err = db.indexHistory(filepath.Join(pmDir, dir.Name()), func(doc *SearchResult) {
+			doc.Type = SearchTypePM
+			doc.UID = uid
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// Index the GC messages.
+	gcDir := filepath.Join(db.root, historyDir, historyGCDir)
+	dirs, err = db.fs().ReadDir(gcDir)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, dir := range dirs {
+		var gcID zkidentity.ShortID
+		if err := gcID.FromString(dir.Name()); err != nil {
+			continue
+		}
+		retention, err := db.getGCRetention(gcID)
+		if err == nil && retention.Mode == rpc.RetentionModeAfterRead {
+			continue
+		}
+		err = db.indexHistory(filepath.Join(gcDir, dir.Name()), func(doc *SearchResult) {
+			doc.Type = SearchTypeGC
+			doc.GCID = gcID
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// Index the posts and their comments.
+	postsRoot := filepath.Join(db.root, postsDir)
+	dirs, err = db.fs().ReadDir(postsRoot)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, dir := range dirs {
+		var from UserID
+		if !dir.IsDir() || from.FromString(dir.Name()) != nil {
+			continue
+		}
+		authorDir := filepath.Join(postsRoot, dir.Name())
+		err := db.readAuthorPosts(authorDir, func(post *rpc.PostMetadata,
+			finfo, statusInfo fs.FileInfo) {
+
+			pid := PostSummFromMetadata(post, from).ID
+			db.indexPost(from, pid, post, finfo.ModTime())
+			if statusInfo == nil {
+				return
+			}
+			statusFname := filepath.Join(authorDir, pid.String()+postsStatusExt)
+			updates, err := db.readPostStatusUpdates(statusFname)
+			if err != nil {
+				db.log.Warnf("Unable to read status updates of "+
+					"post %s: %v", pid, err)
+				return
+			}
+			for i := range updates {
+				db.indexPostComment(from, pid, &updates[i],
+					statusInfo.ModTime())
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// Create the index metadata, even if nothing was indexed, so that the
+	// index is not rebuilt again.
+	var meta searchMeta
+	err = db.readJsonFile(metaFname, &meta)
+	if errors.Is(err, ErrNotFound) {
+		err = db.saveJsonFile(metaFname, meta)
+	}
+	if err != nil {
+		return err
+	}
+	db.log.Infof("Rebuilt search index with %d docs", meta.NextID)
+	return nil
+}
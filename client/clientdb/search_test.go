@@ -0,0 +1,86 @@
+package clientdb
+
+import (
+	"context"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/companyzero/bisonrelay/internal/assert"
+	"github.com/companyzero/bisonrelay/rpc"
+)
+
+// searchTimestamps returns the timestamps of the docs that match testSecret,
+// by text.
+func searchTimestamps(t testing.TB, db *DB) map[string]int64 {
+	t.Helper()
+	res := make(map[string]int64)
+	err := db.View(context.Background(), func(tx ReadTx) error {
+		docs, err := db.Search(tx, testSecret, SearchFilters{})
+		if err != nil {
+			return err
+		}
+		for _, doc := range docs {
+			res[doc.Text] = doc.Timestamp.Unix()
+		}
+		return nil
+	})
+	assert.NilErr(t, err)
+	return res
+}
+
+// TestReindexSearch tests that a missing search index is rebuilt with the
+// stored timestamps of the messages, posts and post comments.
+func TestReindexSearch(t *testing.T) {
+	for _, driver := range testDrivers {
+		driver := driver
+		t.Run(driver, func(t *testing.T) {
+			t.Parallel()
+			root, msgsRoot := t.TempDir(), t.TempDir()
+
+			db := newCryptTestDB(t, driver, root, msgsRoot, false)
+			stop := runTestDB(t, db)
+			uid := writeCryptTestData(t, db)
+
+			// Add a comment timestamped by its author.
+			commentTS := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+			comment := rpc.PostMetadataStatus{
+				Version: rpc.PostMetadataStatusVersion,
+				From:    uid.String(),
+				Link:    PostID{0x01}.String(),
+				Attributes: map[string]string{
+					rpc.RMPSComment:  "old comment " + testSecret,
+					rpc.RMPTimestamp: strconv.FormatInt(commentTS.Unix(), 16),
+				},
+			}
+			err := db.Update(context.Background(), func(tx ReadWriteTx) error {
+				return db.AddPostStatus(tx, uid, uid, PostID{0x01}, &comment)
+			})
+			assert.NilErr(t, err)
+			want := searchTimestamps(t, db)
+			assert.DeepEqual(t, len(want), 4)
+			assert.DeepEqual(t, want["old comment "+testSecret], commentTS.Unix())
+
+			// Remove the index. It is rebuilt when the db is opened
+			// again.
+			err = db.Update(context.Background(), func(tx ReadWriteTx) error {
+				return db.fs().RemoveAll(filepath.Join(db.root, searchDir))
+			})
+			assert.NilErr(t, err)
+			assert.DeepEqual(t, len(searchTimestamps(t, db)), 0)
+			stop()
+
+			db = newCryptTestDB(t, driver, root, msgsRoot, false)
+			stop = runTestDB(t, db)
+			assert.DeepEqual(t, searchTimestamps(t, db), want)
+			stop()
+
+			// The index is not rebuilt again.
+			db = newCryptTestDB(t, driver, root, msgsRoot, false)
+			stop = runTestDB(t, db)
+			assert.DeepEqual(t, searchTimestamps(t, db), want)
+			stop()
+		})
+	}
+}
@@ -14,7 +14,7 @@ func (db *DB) AddToSendQueue(tx ReadWriteTx, typ string, dests []UserID,
 	msg []byte, priority uint) (SendQID, error) {
 
 	dir := filepath.Join(db.root, sendqDir)
-	if err := os.MkdirAll(dir, 0o700); err != nil {
+	if err := db.fs().MkdirAll(dir); err != nil {
 		return SendQID{}, err
 	}
 
@@ -57,7 +57,7 @@ func (db *DB) RemoveFromSendQueue(tx ReadWriteTx, id SendQID, dest UserID) error
 
 	if len(el.Dests) == 0 {
 		// All dests sent. Remove file.
-		return os.Remove(fname)
+		return db.fs().Remove(fname)
 	}
 
 	// Save updated file.
@@ -79,7 +79,7 @@ func (ssq *sortableSendQ) Swap(i, j int) {
 // ListSendQueue lists all send queues registered.
 func (db *DB) ListSendQueue(tx ReadTx) ([]SendQueueElement, error) {
 	dir := filepath.Join(db.root, sendqDir)
-	entries, err := os.ReadDir(dir)
+	entries, err := db.fs().ReadDir(dir)
 	if os.IsNotExist(err) {
 		return nil, nil
 	}
@@ -110,7 +110,7 @@ func (db *DB) ListSendQueue(tx ReadTx) ([]SendQueueElement, error) {
 
 		if len(el.Dests) == 0 {
 			// Already sent all of these.
-			if err := os.Remove(fname); err != nil {
+			if err := db.fs().Remove(fname); err != nil {
 				db.log.Warnf("Unable to remove already sent "+
 					"sendq file: %s: %v", fname, err)
 			}
@@ -174,14 +174,14 @@ func (db *DB) RemoveUserUnackedRMWithRV(tx ReadWriteTx, uid UserID, rv RawRVID)
 		return false, nil
 	}
 
-	err := os.Remove(fname)
+	err := db.fs().Remove(fname)
 	return err == nil, err
 }
 
 // ListUnackedtUserRMs lists unacked RMs from all users.
 func (db *DB) ListUnackedUserRMs(tx ReadTx) ([]UnackedRM, error) {
 	pattern := filepath.Join(db.root, inboundDir, "*", unackedRMFile)
-	matches, err := filepath.Glob(pattern)
+	matches, err := db.fs().Glob(pattern)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,198 @@
+package clientdb
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/companyzero/bisonrelay/internal/strescape"
+	"github.com/companyzero/bisonrelay/zkidentity"
+)
+
+const (
+	sharedFoldersDir       = "sharedfolders"
+	sharedFoldersLocalDir  = "local"
+	sharedFoldersRemoteDir = "remote"
+)
+
+// IsValidSharedFolderPath returns true if p is a valid path of a file inside a
+// shared folder: a clean, relative, slash-separated path that does not escape
+// the folder.
+func IsValidSharedFolderPath(p string) bool {
+	if p == "" || strings.ContainsAny(p, "\\:\x00") || path.IsAbs(p) {
+		return false
+	}
+	if path.Clean(p) != p {
+		return false
+	}
+	return p != "." && p != ".." && !strings.HasPrefix(p, "../")
+}
+
+func (db *DB) sharedFolderFname(id zkidentity.ShortID) string {
+	return filepath.Join(db.root, sharedFoldersDir, sharedFoldersLocalDir,
+		id.String()+".json")
+}
+
+func (db *DB) subscribedFolderFname(owner UserID, id zkidentity.ShortID) string {
+	return filepath.Join(db.root, sharedFoldersDir, sharedFoldersRemoteDir,
+		owner.String(), id.String()+".json")
+}
+
+// SaveSharedFolder saves a folder shared by the local client.
+func (db *DB) SaveSharedFolder(tx ReadWriteTx, sf *SharedFolder) error {
+	return db.saveJsonFile(db.sharedFolderFname(sf.ID), sf)
+}
+
+// GetSharedFolder returns the folder shared by the local client with the given
+// id.
+func (db *DB) GetSharedFolder(tx ReadTx, id zkidentity.ShortID) (SharedFolder, error) {
+	var sf SharedFolder
+	if err := db.readJsonFile(db.sharedFolderFname(id), &sf); err != nil {
+		return sf, fmt.Errorf("shared folder %s: %w", id, err)
+	}
+	return sf, nil
+}
+
+// ListSharedFolders lists the folders shared by the local client.
+func (db *DB) ListSharedFolders(tx ReadTx) ([]SharedFolder, error) {
+	pattern := filepath.Join(db.root, sharedFoldersDir, sharedFoldersLocalDir, "*.json")
+	files, err := db.fs().Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]SharedFolder, 0, len(files))
+	for _, fname := range files {
+		var sf SharedFolder
+		if err := db.readJsonFile(fname, &sf); err != nil {
+			db.log.Warnf("Unable to read shared folder %s: %v", fname, err)
+			continue
+		}
+		res = append(res, sf)
+	}
+	return res, nil
+}
+
+// RemoveSharedFolder removes the folder shared by the local client with the
+// given id.
+func (db *DB) RemoveSharedFolder(tx ReadWriteTx, id zkidentity.ShortID) error {
+	fname := db.sharedFolderFname(id)
+	if !db.exists(fname) {
+		return fmt.Errorf("shared folder %s: %w", id, ErrNotFound)
+	}
+	return db.fs().Remove(fname)
+}
+
+// SaveSubscribedFolder saves a folder shared by a remote user.
+func (db *DB) SaveSubscribedFolder(tx ReadWriteTx, sf *SubscribedFolder) error {
+	return db.saveJsonFile(db.subscribedFolderFname(sf.Owner, sf.ID), sf)
+}
+
+// GetSubscribedFolder returns the folder with the given id shared by the given
+// remote user.
+func (db *DB) GetSubscribedFolder(tx ReadTx, owner UserID, id zkidentity.ShortID) (SubscribedFolder, error) {
+	var sf SubscribedFolder
+	if err := db.readJsonFile(db.subscribedFolderFname(owner, id), &sf); err != nil {
+		return sf, fmt.Errorf("subscribed folder %s: %w", id, err)
+	}
+	return sf, nil
+}
+
+// ListSubscribedFolders lists the folders shared by remote users.
+func (db *DB) ListSubscribedFolders(tx ReadTx) ([]SubscribedFolder, error) {
+	pattern := filepath.Join(db.root, sharedFoldersDir, sharedFoldersRemoteDir,
+		"*", "*.json")
+	files, err := db.fs().Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]SubscribedFolder, 0, len(files))
+	for _, fname := range files {
+		var sf SubscribedFolder
+		if err := db.readJsonFile(fname, &sf); err != nil {
+			db.log.Warnf("Unable to read subscribed folder %s: %v", fname, err)
+			continue
+		}
+		res = append(res, sf)
+	}
+	return res, nil
+}
+
+// RemoveSubscribedFolder removes the folder with the given id shared by the
+// given remote user. Files already synced to the local path of the folder are
+// not removed.
+func (db *DB) RemoveSubscribedFolder(tx ReadWriteTx, owner UserID, id zkidentity.ShortID) error {
+	fname := db.subscribedFolderFname(owner, id)
+	if !db.exists(fname) {
+		return fmt.Errorf("subscribed folder %s: %w", id, ErrNotFound)
+	}
+	return db.fs().Remove(fname)
+}
+
+// CompletedFileDownloadPath returns the path of the completed download of the
+// given file. The user is the nick of the user the file was downloaded from.
+func (db *DB) CompletedFileDownloadPath(tx ReadTx, user string, fid FileID) (string, error) {
+	fd, err := db.GetFileDownload(tx, fid)
+	if err != nil {
+		return "", err
+	}
+	if fd.CompletedName == "" {
+		return "", fmt.Errorf("download of file %s not completed", fid)
+	}
+	return filepath.Join(db.downloadsDir, strescape.PathElement(user),
+		fd.CompletedName), nil
+}
+
+// WriteSubscribedFolderFile copies the file at srcPath (the completed download
+// of the file with the given id) to the given path inside the local path of the
+// subscribed folder and marks it as synced.
+func (db *DB) WriteSubscribedFolderFile(tx ReadWriteTx, sf *SubscribedFolder,
+	filePath string, fid FileID, srcPath string) error {
+
+	if sf.LocalPath == "" {
+		return fmt.Errorf("subscribed folder %s does not have a local path", sf.ID)
+	}
+	if !IsValidSharedFolderPath(filePath) {
+		return fmt.Errorf("invalid shared folder path %q", filePath)
+	}
+
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	// Write to a temp file first, so that a partially written file is
+	// never left in the folder.
+	destPath := filepath.Join(sf.LocalPath, filepath.FromSlash(filePath))
+	if err := os.MkdirAll(filepath.Dir(destPath), 0o700); err != nil {
+		return err
+	}
+	tmpPath := destPath + ".brsync"
+	dest, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dest, src); err != nil {
+		dest.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := dest.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, destPath); err != nil {
+		return err
+	}
+
+	if sf.Synced == nil {
+		sf.Synced = make(map[string]FileID)
+	}
+	sf.Synced[filePath] = fid
+	return db.SaveSubscribedFolder(tx, sf)
+}
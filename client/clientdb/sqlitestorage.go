@@ -0,0 +1,499 @@
+package clientdb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+const (
+	sqliteDBFile = "clientdb.sqlite"
+
+	sqliteSchema = `
+CREATE TABLE IF NOT EXISTS files (
+	path TEXT NOT NULL PRIMARY KEY,
+	parent TEXT NOT NULL,
+	is_dir INTEGER NOT NULL,
+	data BLOB,
+	mod_time INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS files_parent ON files(parent);
+CREATE TABLE IF NOT EXISTS file_appends (
+	path TEXT NOT NULL,
+	data BLOB NOT NULL
+);
+CREATE INDEX IF NOT EXISTS file_appends_path ON file_appends(path);
+`
+)
+
+// sqlExecer is the subset of functions shared by sql.DB and sql.Tx.
+type sqlExecer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// sqliteStorage stores the DB files as rows of a table of an SQLite database.
+// Files are keyed by their slash-separated path relative to the DB root, which
+// allows listing a dir by querying its direct children.
+//
+// Data appended to a file is stored as separate rows of the file_appends
+// table, so that appending does not rewrite the existing contents of the
+// file.
+type sqliteStorage struct {
+	sqliteOps
+	sdb *sql.DB
+}
+
+// openSQLiteStorage opens (creating if needed) the sqlite storage of the DB
+// rooted at root.
+func openSQLiteStorage(root string) (*sqliteStorage, error) {
+	fname := filepath.Join(root, sqliteDBFile)
+	sdb, err := sql.Open("sqlite", fname)
+	if err != nil {
+		return nil, err
+	}
+
+	// All accesses are serialized by the DB, so a single connection is
+	// enough and ensures pragmas apply to every statement.
+	sdb.SetMaxOpenConns(1)
+
+	ctx := context.Background()
+	for _, stmt := range []string{
+		"PRAGMA journal_mode = WAL",
+		"PRAGMA synchronous = FULL",
+		"PRAGMA busy_timeout = 5000",
+		sqliteSchema,
+	} {
+		if _, err := sdb.ExecContext(ctx, stmt); err != nil {
+			sdb.Close()
+			return nil, err
+		}
+	}
+
+	s := &sqliteStorage{
+		sqliteOps: sqliteOps{ctx: ctx, root: root, e: sdb},
+		sdb:       sdb,
+	}
+	return s, nil
+}
+
+func (s *sqliteStorage) Begin(ctx context.Context) (storageTx, error) {
+	tx, err := s.sdb.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &sqliteStorageTx{
+		sqliteOps: sqliteOps{ctx: ctx, root: s.root, e: tx},
+		tx:        tx,
+	}, nil
+}
+
+func (s *sqliteStorage) Close() error {
+	return s.sdb.Close()
+}
+
+type sqliteStorageTx struct {
+	sqliteOps
+	tx *sql.Tx
+}
+
+func (tx *sqliteStorageTx) Commit() error   { return tx.tx.Commit() }
+func (tx *sqliteStorageTx) Rollback() error { return tx.tx.Rollback() }
+
+// sqliteOps implements storageOps on top of either the sqlite db or an open
+// transaction.
+type sqliteOps struct {
+	ctx  context.Context
+	root string
+	e    sqlExecer
+}
+
+// key returns the key used to store the given name. The root dir has an empty
+// key.
+func (s *sqliteOps) key(op, name string) (string, error) {
+	rel, err := filepath.Rel(s.root, name)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", &fs.PathError{Op: op, Path: name, Err: errOutsideRoot}
+	}
+	if rel == "." {
+		return "", nil
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// parentKey returns the key of the parent dir of the given key.
+func parentKey(key string) string {
+	parent := path.Dir(key)
+	if parent == "." {
+		return ""
+	}
+	return parent
+}
+
+// stat returns info about the entry with the given key.
+func (s *sqliteOps) stat(op, name, key string) (*sqliteFileInfo, error) {
+	if key == "" {
+		return &sqliteFileInfo{name: filepath.Base(s.root), isDir: true}, nil
+	}
+
+	var isDir bool
+	var size, modTime int64
+	row := s.e.QueryRowContext(s.ctx, "SELECT is_dir, COALESCE(length(data), 0) + "+
+		"(SELECT COALESCE(SUM(length(data)), 0) FROM file_appends WHERE path = files.path), "+
+		"mod_time FROM files WHERE path = ?", key)
+	err := row.Scan(&isDir, &size, &modTime)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	if err != nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	return &sqliteFileInfo{
+		name:    path.Base(key),
+		size:    size,
+		isDir:   isDir,
+		modTime: time.Unix(0, modTime),
+	}, nil
+}
+
+// mkdirAll creates the dir with the given key and its parents.
+func (s *sqliteOps) mkdirAll(op, name, key string) error {
+	now := time.Now().UnixNano()
+	for ; key != ""; key = parentKey(key) {
+		info, err := s.stat(op, name, key)
+		if err == nil {
+			if !info.isDir {
+				return &fs.PathError{Op: op, Path: name, Err: errNotADir}
+			}
+			return nil
+		}
+		_, err = s.e.ExecContext(s.ctx, "INSERT INTO files(path, parent, is_dir, data, mod_time) "+
+			"VALUES(?, ?, 1, NULL, ?)", key, parentKey(key), now)
+		if err != nil {
+			return &fs.PathError{Op: op, Path: name, Err: err}
+		}
+	}
+	return nil
+}
+
+// putFile stores the data as the contents of the file with the given key.
+func (s *sqliteOps) putFile(op, name, key string, data []byte) error {
+	if key == "" {
+		return &fs.PathError{Op: op, Path: name, Err: errIsADir}
+	}
+	if data == nil {
+		// Ensure empty files are not stored as NULL.
+		data = []byte{}
+	}
+	parent := parentKey(key)
+	if err := s.mkdirAll(op, name, parent); err != nil {
+		return err
+	}
+	res, err := s.e.ExecContext(s.ctx, "INSERT INTO files(path, parent, is_dir, data, mod_time) "+
+		"VALUES(?, ?, 0, ?, ?) "+
+		"ON CONFLICT(path) DO UPDATE SET data = excluded.data, mod_time = excluded.mod_time "+
+		"WHERE is_dir = 0", key, parent, data, time.Now().UnixNano())
+	if err != nil {
+		return &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return &fs.PathError{Op: op, Path: name, Err: errIsADir}
+	}
+	_, err = s.e.ExecContext(s.ctx, "DELETE FROM file_appends WHERE path = ?", key)
+	if err != nil {
+		return &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	return nil
+}
+
+// readAppends returns the data appended to the file with the given key.
+func (s *sqliteOps) readAppends(key string, data []byte) ([]byte, error) {
+	rows, err := s.e.QueryContext(s.ctx, "SELECT data FROM file_appends "+
+		"WHERE path = ? ORDER BY rowid", key)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var b []byte
+		if err := rows.Scan(&b); err != nil {
+			return nil, err
+		}
+		data = append(data, b...)
+	}
+	return data, rows.Err()
+}
+
+func (s *sqliteOps) ReadFile(name string) ([]byte, error) {
+	key, err := s.key("open", name)
+	if err != nil {
+		return nil, err
+	}
+	var isDir bool
+	var data []byte
+	row := s.e.QueryRowContext(s.ctx, "SELECT is_dir, data FROM files WHERE path = ?", key)
+	err = row.Scan(&isDir, &data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	if err != nil {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: err}
+	}
+	if isDir || key == "" {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: errIsADir}
+	}
+	if data, err = s.readAppends(key, data); err != nil {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: err}
+	}
+	return data, nil
+}
+
+func (s *sqliteOps) WriteFile(name string, data []byte) error {
+	key, err := s.key("write", name)
+	if err != nil {
+		return err
+	}
+	return s.putFile("write", name, key, data)
+}
+
+func (s *sqliteOps) AppendFile(name string, data []byte) error {
+	key, err := s.key("write", name)
+	if err != nil {
+		return err
+	}
+	info, err := s.stat("write", name, key)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return s.putFile("write", name, key, data)
+	case err != nil:
+		return err
+	case info.isDir:
+		return &fs.PathError{Op: "write", Path: name, Err: errIsADir}
+	}
+	_, err = s.e.ExecContext(s.ctx, "INSERT INTO file_appends(path, data) VALUES(?, ?)",
+		key, data)
+	if err == nil {
+		_, err = s.e.ExecContext(s.ctx, "UPDATE files SET mod_time = ? WHERE path = ?",
+			time.Now().UnixNano(), key)
+	}
+	if err != nil {
+		return &fs.PathError{Op: "write", Path: name, Err: err}
+	}
+	return nil
+}
+
+func (s *sqliteOps) Stat(name string) (fs.FileInfo, error) {
+	key, err := s.key("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return s.stat("stat", name, key)
+}
+
+func (s *sqliteOps) ReadDir(name string) ([]fs.DirEntry, error) {
+	key, err := s.key("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := s.stat("open", name, key)
+	if err != nil {
+		return nil, err
+	}
+	if !info.isDir {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errNotADir}
+	}
+
+	rows, err := s.e.QueryContext(s.ctx, "SELECT path, is_dir, COALESCE(length(data), 0) + "+
+		"(SELECT COALESCE(SUM(length(data)), 0) FROM file_appends WHERE path = files.path), "+
+		"mod_time FROM files WHERE parent = ? ORDER BY path", key)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+	defer rows.Close()
+
+	var res []fs.DirEntry
+	for rows.Next() {
+		var key string
+		var isDir bool
+		var size, modTime int64
+		if err := rows.Scan(&key, &isDir, &size, &modTime); err != nil {
+			return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+		}
+		info := &sqliteFileInfo{
+			name:    path.Base(key),
+			size:    size,
+			isDir:   isDir,
+			modTime: time.Unix(0, modTime),
+		}
+		res = append(res, fs.FileInfoToDirEntry(info))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+	return res, nil
+}
+
+func (s *sqliteOps) ReadDirFiles(name string) ([]storedFile, error) {
+	key, err := s.key("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := s.stat("open", name, key)
+	if err != nil {
+		return nil, err
+	}
+	if !info.isDir {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errNotADir}
+	}
+
+	fail := func(err error) ([]storedFile, error) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+
+	rows, err := s.e.QueryContext(s.ctx, "SELECT path, data, mod_time FROM files "+
+		"WHERE parent = ? AND is_dir = 0 ORDER BY path", key)
+	if err != nil {
+		return fail(err)
+	}
+	var res []storedFile
+	byKey := make(map[string]int)
+	for rows.Next() {
+		var key string
+		var data []byte
+		var modTime int64
+		if err := rows.Scan(&key, &data, &modTime); err != nil {
+			rows.Close()
+			return fail(err)
+		}
+		byKey[key] = len(res)
+		res = append(res, storedFile{
+			info: &sqliteFileInfo{name: path.Base(key), modTime: time.Unix(0, modTime)},
+			data: data,
+		})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fail(err)
+	}
+
+	rows, err = s.e.QueryContext(s.ctx, "SELECT a.path, a.data FROM file_appends a "+
+		"JOIN files f ON a.path = f.path WHERE f.parent = ? ORDER BY a.rowid", key)
+	if err != nil {
+		return fail(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var key string
+		var data []byte
+		if err := rows.Scan(&key, &data); err != nil {
+			return fail(err)
+		}
+		if i, ok := byKey[key]; ok {
+			res[i].data = append(res[i].data, data...)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fail(err)
+	}
+	for _, f := range res {
+		f.info.(*sqliteFileInfo).size = int64(len(f.data))
+	}
+	return res, nil
+}
+
+func (s *sqliteOps) Glob(pattern string) ([]string, error) {
+	return globStorage(s, s.root, pattern)
+}
+
+func (s *sqliteOps) MkdirAll(name string) error {
+	key, err := s.key("mkdir", name)
+	if err != nil {
+		return err
+	}
+	return s.mkdirAll("mkdir", name, key)
+}
+
+func (s *sqliteOps) Remove(name string) error {
+	key, err := s.key("remove", name)
+	if err != nil {
+		return err
+	}
+	info, err := s.stat("remove", name, key)
+	if err != nil {
+		return err
+	}
+	if info.isDir {
+		var hasChildren bool
+		row := s.e.QueryRowContext(s.ctx, "SELECT EXISTS(SELECT 1 FROM files WHERE parent = ?)", key)
+		if err := row.Scan(&hasChildren); err != nil {
+			return &fs.PathError{Op: "remove", Path: name, Err: err}
+		}
+		if hasChildren || key == "" {
+			return &fs.PathError{Op: "remove", Path: name, Err: errDirNotEmpty}
+		}
+	}
+	_, err = s.e.ExecContext(s.ctx, "DELETE FROM files WHERE path = ?", key)
+	if err == nil {
+		_, err = s.e.ExecContext(s.ctx, "DELETE FROM file_appends WHERE path = ?", key)
+	}
+	if err != nil {
+		return &fs.PathError{Op: "remove", Path: name, Err: err}
+	}
+	return nil
+}
+
+func (s *sqliteOps) RemoveAll(name string) error {
+	key, err := s.key("removeall", name)
+	if err != nil {
+		return err
+	}
+	if key == "" {
+		_, err = s.e.ExecContext(s.ctx, "DELETE FROM files")
+		if err == nil {
+			_, err = s.e.ExecContext(s.ctx, "DELETE FROM file_appends")
+		}
+	} else {
+		// Every descendant of key sorts between "key/" and "key0" ('0'
+		// is the character after '/').
+		for _, table := range []string{"files", "file_appends"} {
+			_, err = s.e.ExecContext(s.ctx, "DELETE FROM "+table+
+				" WHERE path = ? OR (path > ? AND path < ?)",
+				key, key+"/", key+"0")
+			if err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		return &fs.PathError{Op: "removeall", Path: name, Err: err}
+	}
+	return nil
+}
+
+// sqliteFileInfo is the fs.FileInfo for entries of the sqlite storage.
+type sqliteFileInfo struct {
+	name    string
+	size    int64
+	isDir   bool
+	modTime time.Time
+}
+
+func (fi *sqliteFileInfo) Name() string       { return fi.name }
+func (fi *sqliteFileInfo) Size() int64        { return fi.size }
+func (fi *sqliteFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *sqliteFileInfo) IsDir() bool        { return fi.isDir }
+func (fi *sqliteFileInfo) Sys() interface{}   { return nil }
+func (fi *sqliteFileInfo) Mode() fs.FileMode {
+	if fi.isDir {
+		return fs.ModeDir | 0o700
+	}
+	return 0o600
+}
@@ -0,0 +1,233 @@
+package clientdb
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// DriverFilesystem stores the DB data as individual files in the DB
+	// root dir.
+	DriverFilesystem = "fs"
+
+	// DriverSQLite stores the DB data in a single SQLite database inside
+	// the DB root dir.
+	DriverSQLite = "sqlite"
+)
+
+// storageOps are the operations a storage backend must provide to persist the
+// DB data. The DB data is modeled as a tree of files and dirs and every name
+// passed to these functions is a full path inside the DB root.
+//
+// Errors for missing files or dirs must satisfy os.IsNotExist().
+type storageOps interface {
+	// ReadFile returns the full contents of the given file.
+	ReadFile(name string) ([]byte, error)
+
+	// WriteFile replaces the contents of the given file. The file is
+	// either fully written or not modified at all.
+	WriteFile(name string, data []byte) error
+
+	// AppendFile appends the data to the given file, creating the file if
+	// it does not exist.
+	AppendFile(name string, data []byte) error
+
+	// Stat returns information about the given file or dir.
+	Stat(name string) (fs.FileInfo, error)
+
+	// ReadDir returns the entries of the given dir, sorted by name.
+	ReadDir(name string) ([]fs.DirEntry, error)
+
+	// ReadDirFiles returns the info and contents of the files (but not
+	// the dirs) of the given dir, sorted by name.
+	ReadDirFiles(name string) ([]storedFile, error)
+
+	// Glob returns the names of the files matching the pattern, using the
+	// same syntax as filepath.Glob().
+	Glob(pattern string) ([]string, error)
+
+	// MkdirAll creates the dir and any of its missing parents.
+	MkdirAll(name string) error
+
+	// Remove removes the file or empty dir.
+	Remove(name string) error
+
+	// RemoveAll removes the file or dir and everything it contains. It
+	// does not error if the name does not exist.
+	RemoveAll(name string) error
+}
+
+// storedFile is a file returned by ReadDirFiles.
+type storedFile struct {
+	info fs.FileInfo
+	data []byte
+}
+
+// storageTx is a transaction on a storage backend. Changes performed through
+// the tx are only visible after Commit() is called.
+type storageTx interface {
+	storageOps
+	Commit() error
+	Rollback() error
+}
+
+// storage is a backend that persists the DB data. The storageOps of the
+// storage itself are performed outside of any transaction.
+type storage interface {
+	storageOps
+
+	// Begin starts a new transaction. The DB only ever has one open
+	// transaction at a time.
+	Begin(ctx context.Context) (storageTx, error)
+
+	// Close closes the storage.
+	Close() error
+}
+
+// openStorage opens the storage backend for the given driver.
+func openStorage(driver, root string) (storage, error) {
+	switch driver {
+	case "", DriverFilesystem:
+		return openFSStorage(root)
+	case DriverSQLite:
+		return openSQLiteStorage(root)
+	default:
+		return nil, fmt.Errorf("unknown db driver %q", driver)
+	}
+}
+
+// fs returns the storage ops that should be used for the current operation.
+// This is the currently open transaction (when called from within View() or
+// Update()) or the storage itself.
+func (db *DB) fs() storageOps {
+	if db.stx != nil {
+		return db.stx
+	}
+	return db.storage
+}
+
+// inStorageTx runs f inside a new storage transaction. The transaction is
+// committed if f returns nil and rolled back otherwise.
+//
+// This must be called with the db lock held.
+func (db *DB) inStorageTx(ctx context.Context, f func() error) error {
+	stx, err := db.storage.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to start storage tx: %w", err)
+	}
+	db.stx = stx
+	err = f()
+	db.stx = nil
+	if err != nil {
+		if rbErr := stx.Rollback(); rbErr != nil {
+			db.log.Errorf("Unable to rollback storage tx: %v", rbErr)
+		}
+		return err
+	}
+	if err := stx.Commit(); err != nil {
+		return fmt.Errorf("unable to commit storage tx: %w", err)
+	}
+	return nil
+}
+
+// exists returns true if the given file or dir exists in the storage.
+func (db *DB) exists(name string) bool {
+	_, err := db.fs().Stat(name)
+	return err == nil
+}
+
+// isEmptyDir returns true if the given dir exists in the storage and is empty.
+func (db *DB) isEmptyDir(dir string) bool {
+	entries, err := db.fs().ReadDir(dir)
+	return err == nil && len(entries) == 0
+}
+
+// removeIfExists removes the file if it exists. If it does not exist, this
+// doesn't return an error.
+func (db *DB) removeIfExists(fname string) error {
+	err := db.fs().Remove(fname)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// globStorage implements filepath.Glob() semantics on top of the ReadDir() of
+// a storage. Only the components after the root are matched.
+func globStorage(ops storageOps, root, pattern string) ([]string, error) {
+	// Validate the pattern.
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+
+	rel, err := filepath.Rel(root, pattern)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return nil, fmt.Errorf("%w: %q", errOutsideRoot, pattern)
+	}
+
+	matches := []string{root}
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		var next []string
+		for _, dir := range matches {
+			entries, err := ops.ReadDir(dir)
+			if err != nil {
+				// Same as filepath.Glob(), ignore I/O errors.
+				continue
+			}
+			for _, entry := range entries {
+				if ok, _ := filepath.Match(part, entry.Name()); ok {
+					next = append(next, filepath.Join(dir, entry.Name()))
+				}
+			}
+		}
+		matches = next
+		if len(matches) == 0 {
+			break
+		}
+	}
+	return matches, nil
+}
+
+// copyStorage copies every file from the src storage to the dst storage. Files
+// for which skip returns true are not copied. If skip returns true for a dir,
+// the dir is not traversed.
+func copyStorage(dst storageOps, src storageOps, dir string, skip func(string) bool) (int, error) {
+	entries, err := src.ReadDir(dir)
+	if err != nil {
+		return 0, err
+	}
+	var count int
+	for _, entry := range entries {
+		name := filepath.Join(dir, entry.Name())
+		if skip(name) {
+			continue
+		}
+		if entry.IsDir() {
+			if err := dst.MkdirAll(name); err != nil {
+				return count, err
+			}
+			n, err := copyStorage(dst, src, name, skip)
+			count += n
+			if err != nil {
+				return count, err
+			}
+			continue
+		}
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		data, err := src.ReadFile(name)
+		if err != nil {
+			return count, err
+		}
+		if err := dst.WriteFile(name, data); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
@@ -0,0 +1,294 @@
+package clientdb
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/companyzero/bisonrelay/client/clientintf"
+	"github.com/companyzero/bisonrelay/internal/assert"
+	"github.com/companyzero/bisonrelay/rpc"
+)
+
+var testDrivers = []string{DriverFilesystem, DriverSQLite}
+
+// testStorage opens a storage of the given driver in a temp dir.
+func testStorage(t testing.TB, driver string) (storage, string) {
+	t.Helper()
+	root := t.TempDir()
+	s, err := openStorage(driver, root)
+	assert.NilErr(t, err)
+	t.Cleanup(func() { s.Close() })
+	return s, root
+}
+
+// testDB creates and runs a db of the given driver on the root dir.
+func testDB(t testing.TB, driver, root string) *DB {
+	t.Helper()
+	db, err := New(Config{
+		Root:          root,
+		DownloadsRoot: filepath.Join(root, "downloads"),
+		Driver:        driver,
+	})
+	assert.NilErr(t, err)
+	t.Cleanup(runTestDB(t, db))
+	return db
+}
+
+// runTestDB runs the db until the returned function is called.
+func runTestDB(t testing.TB, db *DB) func() {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	runErr := make(chan error, 1)
+	go func() { runErr <- db.Run(ctx) }()
+	select {
+	case <-db.RunStarted():
+	case err := <-runErr:
+		cancel()
+		t.Fatalf("db run errored: %v", err)
+	}
+	return func() {
+		cancel()
+		<-runErr
+	}
+}
+
+// assertFileData asserts the file has the given contents.
+func assertFileData(t testing.TB, ops storageOps, fname string, want string) {
+	t.Helper()
+	got, err := ops.ReadFile(fname)
+	assert.NilErr(t, err)
+	assert.DeepEqual(t, string(got), want)
+	fi, err := ops.Stat(fname)
+	assert.NilErr(t, err)
+	assert.DeepEqual(t, fi.Size(), int64(len(want)))
+}
+
+// assertNotExists asserts the file or dir does not exist.
+func assertNotExists(t testing.TB, ops storageOps, fname string) {
+	t.Helper()
+	_, err := ops.Stat(fname)
+	if !os.IsNotExist(err) {
+		t.Fatalf("unexpected error: got %v, want not exists", err)
+	}
+}
+
+// TestStorageOps tests that the storage drivers perform the same operations
+// with the same results.
+func TestStorageOps(t *testing.T) {
+	for _, driver := range testDrivers {
+		driver := driver
+		t.Run(driver, func(t *testing.T) {
+			t.Parallel()
+			s, root := testStorage(t, driver)
+			dir := filepath.Join(root, "a", "b")
+			fname := filepath.Join(dir, "file")
+
+			_, err := s.ReadFile(fname)
+			if !os.IsNotExist(err) {
+				t.Fatalf("unexpected error: got %v, want not exists", err)
+			}
+
+			assert.NilErr(t, s.MkdirAll(dir))
+			assert.NilErr(t, s.WriteFile(fname, []byte("first")))
+			assertFileData(t, s, fname, "first")
+
+			// Appends.
+			for _, data := range []string{" second", " third"} {
+				assert.NilErr(t, s.AppendFile(fname, []byte(data)))
+			}
+			assertFileData(t, s, fname, "first second third")
+
+			// Writing replaces the appended data.
+			assert.NilErr(t, s.WriteFile(fname, []byte("new")))
+			assertFileData(t, s, fname, "new")
+			assert.NilErr(t, s.AppendFile(fname, []byte(" data")))
+			assertFileData(t, s, fname, "new data")
+
+			// Appending creates missing files.
+			otherFname := filepath.Join(dir, "other")
+			assert.NilErr(t, s.AppendFile(otherFname, []byte("other")))
+			assertFileData(t, s, otherFname, "other")
+			assert.NilErr(t, s.MkdirAll(filepath.Join(dir, "subdir")))
+
+			entries, err := s.ReadDir(dir)
+			assert.NilErr(t, err)
+			var names []string
+			for _, entry := range entries {
+				names = append(names, entry.Name())
+			}
+			assert.DeepEqual(t, names, []string{"file", "other", "subdir"})
+
+			files, err := s.ReadDirFiles(dir)
+			assert.NilErr(t, err)
+			assert.DeepEqual(t, len(files), 2)
+			assert.DeepEqual(t, files[0].info.Name(), "file")
+			assert.DeepEqual(t, string(files[0].data), "new data")
+			assert.DeepEqual(t, files[0].info.Size(), int64(len("new data")))
+			assert.DeepEqual(t, files[1].info.Name(), "other")
+			assert.DeepEqual(t, string(files[1].data), "other")
+
+			matches, err := s.Glob(filepath.Join(root, "a", "*", "f*"))
+			assert.NilErr(t, err)
+			assert.DeepEqual(t, matches, []string{fname})
+
+			assert.NilErr(t, s.Remove(fname))
+			assertNotExists(t, s, fname)
+			assert.NilErr(t, s.RemoveAll(filepath.Join(root, "a")))
+			assertNotExists(t, s, otherFname)
+			assertNotExists(t, s, dir)
+			assert.NilErr(t, s.RemoveAll(filepath.Join(root, "a")))
+		})
+	}
+}
+
+// TestStorageTxRollback tests that rolling back a tx undoes all changes
+// performed in the tx.
+func TestStorageTxRollback(t *testing.T) {
+	for _, driver := range testDrivers {
+		driver := driver
+		t.Run(driver, func(t *testing.T) {
+			t.Parallel()
+			s, root := testStorage(t, driver)
+			dir := filepath.Join(root, "dir")
+			fname := filepath.Join(dir, "file")
+			appendFname := filepath.Join(dir, "append")
+			removedFname := filepath.Join(dir, "removed")
+			removedDir := filepath.Join(root, "removeddir")
+			newDir := filepath.Join(root, "new", "dir")
+
+			assert.NilErr(t, s.MkdirAll(dir))
+			assert.NilErr(t, s.WriteFile(fname, []byte("orig")))
+			assert.NilErr(t, s.WriteFile(appendFname, []byte("orig")))
+			assert.NilErr(t, s.WriteFile(removedFname, []byte("removed")))
+			assert.NilErr(t, s.MkdirAll(removedDir))
+			assert.NilErr(t, s.WriteFile(filepath.Join(removedDir, "f"), []byte("f")))
+
+			stx, err := s.Begin(context.Background())
+			assert.NilErr(t, err)
+			assert.NilErr(t, stx.WriteFile(fname, []byte("changed")))
+			assert.NilErr(t, stx.AppendFile(fname, []byte(" and appended")))
+			assert.NilErr(t, stx.WriteFile(fname, []byte("changed twice")))
+			assert.NilErr(t, stx.AppendFile(appendFname, []byte(" appended")))
+			assert.NilErr(t, stx.Remove(removedFname))
+			assert.NilErr(t, stx.RemoveAll(removedDir))
+			assert.NilErr(t, stx.MkdirAll(newDir))
+			assert.NilErr(t, stx.WriteFile(filepath.Join(newDir, "f"), []byte("new")))
+			assertFileData(t, stx, fname, "changed twice")
+			assertFileData(t, stx, appendFname, "orig appended")
+			assertNotExists(t, stx, removedFname)
+			assert.NilErr(t, stx.Rollback())
+
+			assertFileData(t, s, fname, "orig")
+			assertFileData(t, s, appendFname, "orig")
+			assertFileData(t, s, removedFname, "removed")
+			assertFileData(t, s, filepath.Join(removedDir, "f"), "f")
+			assertNotExists(t, s, filepath.Join(root, "new"))
+
+			// Committed changes are kept.
+			stx, err = s.Begin(context.Background())
+			assert.NilErr(t, err)
+			assert.NilErr(t, stx.AppendFile(appendFname, []byte(" appended")))
+			assert.NilErr(t, stx.Remove(removedFname))
+			assert.NilErr(t, stx.Commit())
+			assertFileData(t, s, appendFname, "orig appended")
+			assertNotExists(t, s, removedFname)
+			assertNotExists(t, s, filepath.Join(root, fsTxJournalDir))
+		})
+	}
+}
+
+// TestFSStorageRecovery tests that the changes of a tx of the filesystem
+// storage that was interrupted before being committed are undone when the
+// storage is reopened.
+func TestFSStorageRecovery(t *testing.T) {
+	s, root := testStorage(t, DriverFilesystem)
+	fname := filepath.Join(root, "file")
+	newFname := filepath.Join(root, "dir", "new")
+	assert.NilErr(t, s.WriteFile(fname, []byte("orig")))
+
+	stx, err := s.Begin(context.Background())
+	assert.NilErr(t, err)
+	assert.NilErr(t, stx.AppendFile(fname, []byte(" appended")))
+	assert.NilErr(t, stx.MkdirAll(filepath.Dir(newFname)))
+	assert.NilErr(t, stx.WriteFile(newFname, []byte("new")))
+
+	// Simulate a crash by reopening the storage without committing the
+	// tx.
+	stx.(*fsStorageTx).journal.Close()
+	s, err = openFSStorage(root)
+	assert.NilErr(t, err)
+	assertFileData(t, s, fname, "orig")
+	assertNotExists(t, s, filepath.Dir(newFname))
+	assertNotExists(t, s, filepath.Join(root, fsTxJournalDir))
+}
+
+// TestDBDrivers tests that the db keeps the same data with either driver.
+func TestDBDrivers(t *testing.T) {
+	for _, driver := range testDrivers {
+		driver := driver
+		t.Run(driver, func(t *testing.T) {
+			t.Parallel()
+			root := t.TempDir()
+			db := testDB(t, driver, root)
+			ctx := context.Background()
+
+			author := clientintf.UserID{0x01}
+			var pids []PostID
+			for i := 0; i < 3; i++ {
+				pid := PostID{byte(i + 1)}
+				pids = append(pids, pid)
+				post := rpc.PostMetadata{
+					Version: rpc.PostMetadataVersion,
+					Attributes: map[string]string{
+						rpc.RMPIdentifier: pid.String(),
+						rpc.RMPMain:       "post",
+					},
+				}
+				err := db.Update(ctx, func(tx ReadWriteTx) error {
+					_, _, err := db.SaveReceivedPost(tx, author, post)
+					return err
+				})
+				assert.NilErr(t, err)
+			}
+
+			subs := []UserID{{0x02}, {0x03}}
+			for _, uid := range subs {
+				err := db.Update(ctx, func(tx ReadWriteTx) error {
+					return db.SubscribeToPosts(tx, uid)
+				})
+				assert.NilErr(t, err)
+			}
+
+			// Changes of failed txs are rolled back.
+			errTest := errors.New("test error")
+			err := db.Update(ctx, func(tx ReadWriteTx) error {
+				if err := db.SubscribeToPosts(tx, UserID{0x04}); err != nil {
+					return err
+				}
+				return errTest
+			})
+			assert.ErrorIs(t, err, errTest)
+
+			var summs []PostSummary
+			var gotSubs []UserID
+			err = db.View(ctx, func(tx ReadTx) error {
+				var err error
+				if summs, err = db.ListPosts(tx); err != nil {
+					return err
+				}
+				gotSubs, err = db.ListPostSubscribers(tx)
+				return err
+			})
+			assert.NilErr(t, err)
+			assert.DeepEqual(t, len(summs), len(pids))
+			for i := range summs {
+				assert.DeepEqual(t, summs[i].ID, pids[i])
+				assert.DeepEqual(t, summs[i].From, author)
+			}
+			assert.DeepEqual(t, gotSubs, subs)
+		})
+	}
+}
@@ -1,11 +1,11 @@
 package clientdb
 
 import (
+	"bytes"
 	"context"
 	"crypto/sha256"
 	"encoding/binary"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -79,41 +79,6 @@ func fileExists(fname string) bool {
 	return true
 }
 
-// removeIfExists removes the filename if it exists. If it does not exist, this
-// doesn't return an error.
-func removeIfExists(fname string) error {
-	err := os.Remove(fname)
-	if os.IsNotExist(err) {
-		return nil
-	}
-	return err
-}
-
-// copyFile copies the src file to dst. Any existing file will be overwritten
-// and will not copy file attributes.
-func copyFile(src, dst string) error {
-	in, err := os.Open(src)
-	if err != nil {
-		return err
-
-	}
-	defer in.Close()
-
-	out, err := os.Create(dst)
-	if err != nil {
-		return err
-
-	}
-	defer out.Close()
-
-	_, err = io.Copy(out, in)
-	if err != nil {
-		return err
-
-	}
-	return out.Close()
-}
-
 func sha256File(fname string) ([]byte, error) {
 	f, err := os.Open(fname)
 	if err != nil {
@@ -145,7 +110,7 @@ func (db *DB) randomIDInDir(dir string) (clientintf.ID, error) {
 			return res, fmt.Errorf("out of entropy: %v", err)
 		}
 
-		if _, err := os.Stat(filepath.Join(dir, res.String())); os.IsNotExist(err) {
+		if _, err := db.fs().Stat(filepath.Join(dir, res.String())); os.IsNotExist(err) {
 			return res, nil
 		} else if err != nil {
 			return res, err
@@ -155,107 +120,73 @@ func (db *DB) randomIDInDir(dir string) (clientintf.ID, error) {
 	return res, fmt.Errorf("could not find random id in dir %s", dir)
 }
 
-// saveJsonFile saves the data to a temp file, then renames the temp file to
-// the passed filename.
+// saveJsonFile saves the data to the given file in the db storage, replacing
+// any existing contents. The data is encrypted if the db is encrypted.
 func (db *DB) saveJsonFile(fname string, data interface{}) error {
-	dir := filepath.Dir(fname)
-	base := filepath.Base(fname)
-	tempFname := filepath.Join(dir, "."+base+".new")
-
-	if err := os.MkdirAll(dir, 0o700); err != nil {
+	if err := db.fs().MkdirAll(filepath.Dir(fname)); err != nil {
 		return fmt.Errorf("unable to create dest dir: %w", err)
 	}
 
-	f, err := os.Create(tempFname)
-	if err != nil {
-		return fmt.Errorf("unable to create temp file: %w", err)
-	}
-
-	// From this point on, there are no more early returns, so that the
-	// temp file is removed in case of errors.
-
-	enc := json.NewEncoder(f)
-	err = enc.Encode(data)
-	if err != nil {
-		err = fmt.Errorf("unable to encode json contents: %w", err)
-	}
-	if err == nil {
-		err = f.Sync()
-		if err != nil {
-			err = fmt.Errorf("unable to fsync temp file: %w", err)
-		}
-	}
-	if err == nil {
-		err = f.Close()
-		f = nil
-		if err != nil {
-			err = fmt.Errorf("unable to close temp file: %w", err)
-		}
-	}
-	if err == nil {
-		err = os.Rename(tempFname, fname)
-		if err != nil {
-			err = fmt.Errorf("unable to rename temp file to final file: %w", err)
-		}
-	}
+	b, err := json.Marshal(data)
 	if err != nil {
-		if f != nil {
-			closeErr := f.Close()
-			if closeErr != nil {
-				db.log.Warnf("Unable to close temp file prior to cleanup: %v", err)
-			}
-		}
-		if remErr := os.Remove(tempFname); remErr != nil {
-			db.log.Warnf("Unable to remove temp file %s: %v", tempFname, err)
-		}
+		return fmt.Errorf("unable to encode json contents: %w", err)
 	}
-
-	return err
-}
-
-// dirExistsEmpty returs true if the given dir exists and is empty.
-func dirExistsEmpty(dir string) bool {
-	f, err := os.Open(dir)
+	b, err = db.sealData(append(b, '\n'))
 	if err != nil {
-		return false
+		return fmt.Errorf("unable to encrypt json contents: %w", err)
 	}
-
-	i, err := f.Readdir(1)
-	if err != nil && !errors.Is(err, io.EOF) {
-		return false
+	if err := db.fs().WriteFile(fname, b); err != nil {
+		return fmt.Errorf("unable to write json file: %w", err)
 	}
-	return len(i) == 0
+	return nil
 }
 
 // readJsonFile reads the first json message from the given filename and
 // decodes it into data.
 func (db *DB) readJsonFile(fname string, data interface{}) error {
-	f, err := os.Open(fname)
+	b, err := db.readFile(fname)
 	if os.IsNotExist(err) {
 		return ErrNotFound
 	} else if err != nil {
 		return err
 	}
 
-	defer f.Close()
-	dec := json.NewDecoder(f)
+	dec := json.NewDecoder(bytes.NewReader(b))
 	return dec.Decode(data)
 }
 
-// appendToJsonFile appends the given data to the file as a json entry.
+// marshalJsonRecord encodes the given data as a single json record line. When
+// the db is encrypted, the record is individually encrypted.
+func (db *DB) marshalJsonRecord(data interface{}) ([]byte, error) {
+	var record interface{} = data
+	if db.key != nil {
+		b, err := json.Marshal(data)
+		if err != nil {
+			return nil, err
+		}
+		if record, err = db.sealData(b); err != nil {
+			return nil, err
+		}
+	}
+
+	b, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+	return append(b, '\n'), nil
+}
+
+// appendToJsonFile appends the given data to the file as a json entry. When
+// the db is encrypted, each entry is individually encrypted. Files written by
+// this function should be read with newJsonRecordDecoder.
 func (db *DB) appendToJsonFile(fname string, data interface{}) error {
-	if err := os.MkdirAll(filepath.Dir(fname), 0o0700); err != nil {
+	if err := db.fs().MkdirAll(filepath.Dir(fname)); err != nil {
 		return err
 	}
 
-	f, err := os.OpenFile(fname, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	b, err := db.marshalJsonRecord(data)
 	if err != nil {
 		return err
 	}
-	enc := json.NewEncoder(f)
-	err = enc.Encode(data)
-	if closeErr := f.Close(); closeErr != nil {
-		db.log.Warnf("Unable to close appended file %s: %v", fname, closeErr)
-	}
-	return err
+	return db.fs().AppendFile(fname, b)
 }
@@ -58,6 +58,10 @@ type ServerSessionIntf interface {
 	PaymentRates() (uint64, uint64)
 	ExpirationDays() int
 
+	// SupportsSharedRVs returns true if the server supports subscribing to
+	// shared RVs.
+	SupportsSharedRVs() bool
+
 	// Context returns a context that gets cancelled once this session stops
 	// running.
 	Context() context.Context
@@ -4,6 +4,7 @@ import (
 	"fmt"
 
 	"github.com/companyzero/bisonrelay/client/clientintf"
+	"github.com/companyzero/bisonrelay/zkidentity"
 )
 
 var (
@@ -11,6 +12,23 @@ var (
 	errClientExiting     = fmt.Errorf("client: %w", clientintf.ErrSubsysExiting)
 	errAlreadyExists     = fmt.Errorf("already exists")
 	errUserBlocked       = fmt.Errorf("user is blocked")
+	errLinkedDevice      = fmt.Errorf("operation not supported on a linked device")
+	errDeviceUnlinked    = fmt.Errorf("device was unlinked from its primary device")
+	errInvalidRetention  = fmt.Errorf("invalid retention policy")
+	errEmptyMsgID        = fmt.Errorf("message id is empty")
+	errNotGCOwner        = fmt.Errorf("local user is not the owner of the GC")
+	errNotGCMember       = fmt.Errorf("user is not a member of the GC")
+	errInvalidAdminsSig  = fmt.Errorf("invalid signature of GC admins")
+	errInvalidGCListSig  = fmt.Errorf("invalid signature of GC list")
+	errUnsignedGCList    = fmt.Errorf("unsigned GC list")
+	errUnknownGCSigner   = fmt.Errorf("signer of GC list is not a known user")
+	errInvalidGCMetaSig  = fmt.Errorf("invalid signature of GC metadata")
+	errInvalidGCJoinLink = fmt.Errorf("invalid GC join link")
+	errExpiredGCJoinLink = fmt.Errorf("GC join link has expired")
+	errExpiredGCInvite   = fmt.Errorf("GC invite has expired")
+	errUnknownGCInvite   = fmt.Errorf("unknown GC invite")
+	errInvalidReaction   = fmt.Errorf("invalid reaction")
+	errMultiSourceDLOff  = fmt.Errorf("multi-source downloads are disabled")
 )
 
 type userNotFoundError struct {
@@ -26,6 +44,22 @@ func (err userNotFoundError) Is(target error) bool {
 	return ok
 }
 
+// unknownGCSignerError is returned when a GC list (or the set of admins of the
+// GC) was signed by a user that is not KX'd with the local client.
+type unknownGCSignerError struct {
+	gcID zkidentity.ShortID
+	uid  UserID
+}
+
+func (err unknownGCSignerError) Error() string {
+	return fmt.Sprintf("gc list %s signed by %s: %v", err.gcID, err.uid,
+		errUnknownGCSigner)
+}
+
+func (err unknownGCSignerError) Unwrap() error {
+	return errUnknownGCSigner
+}
+
 type alreadyHaveUserError struct {
 	id UserID
 }
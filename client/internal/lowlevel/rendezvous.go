@@ -32,6 +32,7 @@ type rdzvSub struct {
 	handler     RVHandler
 	subPaid     SubPaidHandler
 	subDoneChan chan error
+	shared      *rpc.SharedRendezvous
 }
 
 func (sub rdzvSub) replySubDone(err error, runDone chan struct{}) {
@@ -130,11 +131,24 @@ func NewRVManager(log slog.Logger, db RVManagerDB, subsDelayer func() <-chan tim
 // might be called multiple times if the rendezvous is registered and pushed
 // multiple times.
 func (rmgr *RVManager) Sub(rdzv RVID, handler RVHandler, subPaid SubPaidHandler) error {
+	return rmgr.sub(rdzv, handler, subPaid, nil)
+}
+
+// SubShared is similar to Sub, but subscribes to the shared RV of the given
+// subscription (see rpc.SharedRV), which may be subscribed by other clients at
+// the same time. If the server does not support shared RVs, this is the same
+// as calling Sub with the shared RV.
+func (rmgr *RVManager) SubShared(shared rpc.SharedRendezvous, handler RVHandler, subPaid SubPaidHandler) error {
+	return rmgr.sub(shared.RV(), handler, subPaid, &shared)
+}
+
+func (rmgr *RVManager) sub(rdzv RVID, handler RVHandler, subPaid SubPaidHandler, shared *rpc.SharedRendezvous) error {
 	sub := rdzvSub{
 		id:          rdzv,
 		handler:     handler,
 		subPaid:     subPaid,
 		subDoneChan: make(chan error),
+		shared:      shared,
 	}
 	select {
 	case rmgr.subChan <- sub:
@@ -168,6 +182,13 @@ func (rmgr *RVManager) Unsub(rdzv RVID) error {
 	}
 }
 
+// UnsubShared unsubscribes from the given rendezvous point, previously
+// subscribed with SubShared. The server keeps the RV for the other clients
+// that are still subscribed to it.
+func (rmgr *RVManager) UnsubShared(rdzv RVID) error {
+	return rmgr.Unsub(rdzv)
+}
+
 // BindToSession binds the rendezvous manager to the specified server session.
 //
 // Note: the rendezvous manager assumes the given session has been setup such
@@ -362,21 +383,35 @@ func (rmgr *RVManager) payForSubs(ctx context.Context, rlist []ratchet.RVPoint,
 // updatePayloadSubscriptions (re-)subscribes to all rendezvous points in subs on
 // the given server session.
 func (rmgr *RVManager) updatePayloadSubscriptions(ctx context.Context,
-	add, del []ratchet.RVPoint, subs map[RVID]rdzvSub, sess clientintf.ServerSessionIntf) error {
+	add []ratchet.RVPoint, addShared []rpc.SharedRendezvous,
+	del []ratchet.RVPoint, subs map[RVID]rdzvSub,
+	sess clientintf.ServerSessionIntf) error {
+
+	// Subscribe to shared RVs as regular RVs when the server does not
+	// support them.
+	allAdd := add[:len(add):len(add)]
+	for i := range addShared {
+		allAdd = append(allAdd, addShared[i].RV())
+	}
+	if !sess.SupportsSharedRVs() {
+		add = allAdd
+		addShared = nil
+	}
 
 	// Pay for the subs we haven't paid yet.
-	unpaidRVs, err := rmgr.payForSubs(ctx, add, subs, sess)
+	unpaidRVs, err := rmgr.payForSubs(ctx, allAdd, subs, sess)
 	if err != nil {
 		return err
 	}
 
-	rmgr.log.Debugf("Updating server subscription with +%d-%d RVs", len(add),
-		len(del))
+	rmgr.log.Debugf("Updating server subscription with +%d+%d-%d RVs", len(add),
+		len(addShared), len(del))
 
 	msg := rpc.Message{Command: rpc.TaggedCmdSubscribeRoutedMessages}
 	payload := &rpc.SubscribeRoutedMessages{
-		AddRendezvous: add,
-		DelRendezvous: del,
+		AddRendezvous:       add,
+		DelRendezvous:       del,
+		AddSharedRendezvous: addShared,
 	}
 
 	replyChan := make(chan interface{})
@@ -478,6 +513,7 @@ func (rmgr *RVManager) Run(ctx context.Context) error {
 
 	subs := make(map[RVID]rdzvSub)
 	var toAdd, toDel []RVID
+	var toAddShared []rpc.SharedRendezvous
 	var unsubs, requestedUnsubs []rdzvUnsub
 	var sess clientintf.ServerSessionIntf
 	var err error
@@ -524,7 +560,14 @@ loop:
 			for _, unsub := range requestedUnsubs {
 				toDel = append(toDel, unsub.id)
 			}
-			toAdd = rvMapKeys(subs)
+			toAdd, toAddShared = nil, nil
+			for id, sub := range subs {
+				if sub.shared != nil {
+					toAddShared = append(toAddShared, *sub.shared)
+				} else {
+					toAdd = append(toAdd, id)
+				}
+			}
 
 		case sub := <-rmgr.subChan:
 			if _, ok := subs[sub.id]; ok {
@@ -537,7 +580,11 @@ loop:
 
 			rmgr.log.Tracef("New subscription for RV %s", sub.id)
 
-			toAdd = append(toAdd, sub.id)
+			if sub.shared != nil {
+				toAddShared = append(toAddShared, *sub.shared)
+			} else {
+				toAdd = append(toAdd, sub.id)
+			}
 			subs[sub.id] = sub
 			if delayChan == nil {
 				delayChan = rmgr.subsDelayer()
@@ -646,13 +693,15 @@ loop:
 		unsubs = nil
 		delayChan = nil
 		needsUpdate = false
-		go func(add, del []ratchet.RVPoint, sess clientintf.ServerSessionIntf) {
+		go func(add []ratchet.RVPoint, addShared []rpc.SharedRendezvous,
+			del []ratchet.RVPoint, sess clientintf.ServerSessionIntf) {
 			select {
-			case updateResChan <- rmgr.updatePayloadSubscriptions(ctx, add, del, subs, sess):
+			case updateResChan <- rmgr.updatePayloadSubscriptions(ctx, add, addShared, del, subs, sess):
 			case <-ctx.Done():
 			}
-		}(toAdd, toDel, sess)
+		}(toAdd, toAddShared, toDel, sess)
 		toAdd = nil
+		toAddShared = nil
 		toDel = nil
 	}
 
@@ -78,6 +78,7 @@ type serverSession struct {
 	subPayRate     uint64 // Sub payment rate in MAtoms/byte
 	logPings       bool   // Whether to log ping/pong messages.
 	expirationDays int    // After When data is purged from server
+	sharedRVs      bool   // Whether server supports shared RVs
 
 	// Handler for pushed routed messages.
 	//
@@ -130,6 +131,10 @@ func (sess *serverSession) ExpirationDays() int {
 	return sess.expirationDays
 }
 
+func (sess *serverSession) SupportsSharedRVs() bool {
+	return sess.sharedRVs
+}
+
 // SendPRPC sends the given msg and payload to the server. This returns when
 // the msg has been sent with any errors generated during the send process.
 //
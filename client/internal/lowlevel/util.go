@@ -90,15 +90,6 @@ func joinRVList(rvs []ratchet.RVPoint) string {
 	return b.String()
 }
 
-// rvMapKeys returns all the keys in the given rv map.
-func rvMapKeys(m map[RVID]rdzvSub) []ratchet.RVPoint {
-	rlist := make([]ratchet.RVPoint, 0, len(m))
-	for id := range m {
-		rlist = append(rlist, id)
-	}
-	return rlist
-}
-
 // multiCtx returns a context that is canceled once any one of the passed
 // contexts are cancelled.
 //
@@ -365,6 +365,7 @@ func (m *mockServerSession) PayClient() clientintf.PaymentClient {
 }
 func (m *mockServerSession) PaymentRates() (uint64, uint64) { return 0, 0 }
 func (m *mockServerSession) ExpirationDays() int            { return 7 }
+func (m *mockServerSession) SupportsSharedRVs() bool        { return false }
 func (m *mockServerSession) Context() context.Context       { return context.Background() }
 
 type mockRM string
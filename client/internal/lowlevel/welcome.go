@@ -179,6 +179,7 @@ func (ck *ConnKeeper) attemptWelcome(conn clientintf.Conn, kx msgReaderWriter) (
 		ppr    uint64 = 0
 		spr    uint64 = 0
 		lnNode string = ""
+		srvs   bool   = false
 
 		// TODO: modify to zero once clients are updated to force
 		// server to send an appropriate value.
@@ -221,6 +222,9 @@ func (ck *ConnKeeper) attemptWelcome(conn clientintf.Conn, kx msgReaderWriter) (
 		case rpc.PropServerLNNode:
 			lnNode = v.Value
 
+		case rpc.PropSharedRVs:
+			srvs = v.Value == rpc.PropSharedRVsDefault
+
 		case rpc.PropExpirationDays:
 			expd, err = strconv.ParseInt(v.Value, 10, 32)
 			if err != nil {
@@ -309,6 +313,7 @@ func (ck *ConnKeeper) attemptWelcome(conn clientintf.Conn, kx msgReaderWriter) (
 	sess.pingInterval = ck.cfg.PingInterval
 	sess.pushedRoutedMsgsHandler = ck.cfg.PushedRoutedMsgsHandler
 	sess.expirationDays = int(expd)
+	sess.sharedRVs = srvs
 	sess.logPings = ck.cfg.LogPings
 
 	ck.log.Infof("Connected to server %s",
@@ -36,6 +36,7 @@ const (
 
 type UserID = clientintf.UserID
 type GCID = zkidentity.ShortID
+type MsgID = zkidentity.ShortID
 
 // RemoteIDFromStr converts the given string to a UserID. Returns an empty
 // uid if the string is not a valid UserID.
@@ -82,6 +83,11 @@ type RemoteUser struct {
 	sentRMChan      chan error
 	compressLevel   int
 
+	// linked is true for remote users of a linked device. These do not
+	// have a ratchet, so messages to them are relayed through the primary
+	// device.
+	linked bool
+
 	// mtx protects the following fields.
 	mtx     sync.Mutex
 	ignored bool
@@ -90,6 +96,10 @@ type RemoteUser struct {
 	// called as a goroutine.
 	rmHandler func(ru *RemoteUser, h *rpc.RMHeader, c interface{}, ts time.Time)
 
+	// rawRMHandler is called with the decrypted (but still encoded) RM
+	// after rmHandler has handled it.
+	rawRMHandler func(ru *RemoteUser, h *rpc.RMHeader, rm []byte, ts time.Time)
+
 	// rmHandlerWG tracks calls to the rmHandler that need to complete
 	// before run() returns.
 	rmHandlerWG sync.WaitGroup
@@ -209,6 +219,9 @@ func (ru *RemoteUser) queueRMPriority(payload interface{}, priority uint,
 	if priority > 4 {
 		return fmt.Errorf("priority must be max 4")
 	}
+	if ru.linked {
+		return errLinkedDevice
+	}
 
 	me, err := rpc.ComposeCompressedRM(ru.localID, payload, ru.compressLevel)
 	if err != nil {
@@ -317,10 +330,12 @@ func (ru *RemoteUser) sendTransitive(payload interface{}, payType string,
 }
 
 // sendPM sends a private message to this remote user.
-func (ru *RemoteUser) sendPM(msg string) error {
+func (ru *RemoteUser) sendPM(id, replyTo MsgID, msg string) error {
 	return ru.sendRMPriority(rpc.RMPrivateMessage{
 		Mode:    rpc.RMPrivateMessageModeNormal,
 		Message: msg,
+		ID:      id,
+		ReplyTo: replyTo,
 	}, "pm", priorityPM)
 }
 
@@ -550,6 +565,9 @@ func (ru *RemoteUser) handleReceivedEncrypted(recvBlob lowlevel.RVBlob) error {
 		ru.rmHandlerWG.Add(1)
 		go func() {
 			ru.rmHandler(ru, h, c, recvBlob.ServerTS)
+			if ru.rawRMHandler != nil {
+				ru.rawRMHandler(ru, h, cleartext, recvBlob.ServerTS)
+			}
 			ru.rmHandlerWG.Done()
 		}()
 	}
@@ -0,0 +1,88 @@
+package e2etests
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/companyzero/bisonrelay/client"
+	"github.com/companyzero/bisonrelay/client/clientdb"
+	"github.com/companyzero/bisonrelay/internal/assert"
+	"github.com/companyzero/bisonrelay/rpc"
+)
+
+// TestBackupRestore tests that a client can be restored from a backup into a
+// new db and that the restored ratchets are reset.
+func TestBackupRestore(t *testing.T) {
+	tcfg := testScaffoldCfg{}
+	ts := newTestScaffold(t, tcfg)
+	alice := ts.newClient("alice")
+	bob := ts.newClient("bob")
+	ts.kxUsers(alice, bob)
+
+	bobPMChan := make(chan string, 2)
+	bob.modifyHandlers(func() {
+		bob.onPM = func(user *client.RemoteUser, msg rpc.RMPrivateMessage, ts time.Time) {
+			bobPMChan <- msg.Message
+		}
+	})
+	assert.NilErr(t, alice.PM(bob.PublicID(), "before backup"))
+	assert.DeepEqual(t, assert.ChanWritten(t, bobPMChan), "before backup")
+
+	// Create the backup and stop alice's original client.
+	backup := new(bytes.Buffer)
+	passphrase := []byte("backup pass")
+	assert.NilErr(t, alice.ExportBackup(backup, passphrase))
+	alice.cancel()
+	assert.ChanWritten(t, alice.runC)
+
+	// Import the backup on a new client.
+	rootDir, err := os.MkdirTemp("", "br-client-alice2-*")
+	assert.NilErr(t, err)
+	t.Cleanup(func() { os.RemoveAll(rootDir) })
+	alice2 := ts.newClientWithOpts("alice2", rootDir, alice.id)
+	err = alice2.ImportBackup(bytes.NewReader(backup.Bytes()), []byte("wrong pass"))
+	if err == nil {
+		t.Fatal("unexpected success importing backup with wrong passphrase")
+	}
+	err = alice2.ImportBackup(bytes.NewReader(backup.Bytes()), passphrase)
+	assert.NilErr(t, err)
+
+	// Opening the db while the client is running fails without restoring
+	// the backup.
+	_, err = clientdb.New(clientdb.Config{
+		Root:          rootDir,
+		DownloadsRoot: filepath.Join(rootDir, "downloads"),
+	})
+	if err == nil {
+		t.Fatal("unexpected success opening db of running client")
+	}
+	_, err = os.Stat(filepath.Join(rootDir, "restore.pending"))
+	assert.NilErr(t, err)
+
+	// Restart the new client. It should reset the ratchet with bob.
+	bobKXChan := make(chan struct{}, 1)
+	bob.modifyHandlers(func() {
+		bob.onKXCompleted = func(user *client.RemoteUser) {
+			bobKXChan <- struct{}{}
+		}
+	})
+	alice2 = ts.recreateClient(alice2)
+	assert.ChanWritten(t, bobKXChan)
+	for _, fname := range []string{"restore.pending", "restore.tmp", "restore.old"} {
+		_, err = os.Stat(filepath.Join(rootDir, fname))
+		if !os.IsNotExist(err) {
+			t.Fatalf("unexpected error for %s: got %v, want not exists",
+				fname, err)
+		}
+	}
+
+	// Alice's restored client can message bob.
+	assert.DeepEqual(t, alice2.PublicID(), alice.PublicID())
+	_, err = alice2.UserNick(bob.PublicID())
+	assert.NilErr(t, err)
+	assert.NilErr(t, alice2.PM(bob.PublicID(), "after restore"))
+	assert.DeepEqual(t, assert.ChanWritten(t, bobPMChan), "after restore")
+}
@@ -0,0 +1,136 @@
+package e2etests
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/companyzero/bisonrelay/client"
+	"github.com/companyzero/bisonrelay/internal/assert"
+	"github.com/companyzero/bisonrelay/rpc"
+)
+
+// TestDedupedContent tests that files with the same contents are not
+// downloaded twice (unless the previous download was modified) and that the
+// local chunks of shared files are reused in downloads.
+func TestDedupedContent(t *testing.T) {
+	tcfg := testScaffoldCfg{}
+	ts := newTestScaffold(t, tcfg)
+	alice := ts.newClient("alice")
+	bob := ts.newClient("bob")
+	charlie := ts.newClient("charlie")
+	dave := ts.newClient("dave")
+	ts.kxUsers(alice, bob)
+	ts.kxUsers(bob, charlie)
+	ts.kxUsers(bob, dave)
+
+	bobDownloadChan := make(chan string, 1)
+	bob.modifyHandlers(func() {
+		bob.onFileDownload = func(user *client.RemoteUser, fm rpc.FileMetadata, diskPath string) {
+			bobDownloadChan <- diskPath
+		}
+	})
+	uploadChans := make(map[string]chan int)
+	for _, c := range []*testClient{alice, charlie, dave} {
+		c := c
+		uploadChan := make(chan int, 100)
+		uploadChans[c.name] = uploadChan
+		c.modifyHandlers(func() {
+			c.onFileUpload = func(user *client.RemoteUser, fm rpc.FileMetadata, chunkIdx int) {
+				uploadChan <- chunkIdx
+			}
+		})
+	}
+
+	assertDownload := func(data []byte) string {
+		t.Helper()
+		diskPath := assert.ChanWritten(t, bobDownloadChan)
+		got, err := os.ReadFile(diskPath)
+		assert.NilErr(t, err)
+		if !bytes.Equal(got, data) {
+			t.Fatalf("downloaded file does not match shared file")
+		}
+		return diskPath
+	}
+	assertReclaimed := func(wantChunks int) {
+		t.Helper()
+		stats, err := bob.ReclaimContentSpace()
+		assert.NilErr(t, err)
+		assert.DeepEqual(t, stats.Chunks, wantChunks)
+	}
+
+	// Alice and Charlie share the same file. Their metadata differs
+	// (because the signatures differ), so the file ids are different.
+	fname, data := writeRandomFile(t, 400)
+	aliceSF, _, err := alice.ShareFile(fname, nil, 0, false, "")
+	assert.NilErr(t, err)
+	charlieSF, _, err := charlie.ShareFile(fname, nil, 0, false, "")
+	assert.NilErr(t, err)
+	if aliceSF.FID == charlieSF.FID {
+		t.Fatalf("unexpected equal file ids")
+	}
+
+	// Bob downloads the file from Alice. Once completed, the downloaded
+	// chunks are no longer needed.
+	assert.NilErr(t, bob.GetUserContent(alice.PublicID(), aliceSF.FID))
+	alicePath := assertDownload(data)
+	assertReclaimed(50)
+	assertReclaimed(0)
+
+	// Bob already has a file with the same contents as Charlie's, so
+	// Charlie does not have to upload any chunks.
+	hasFile, err := bob.HasDownloadedFile(charlieSF.FID)
+	assert.NilErr(t, err)
+	assert.BoolIs(t, hasFile, false)
+	assert.NilErr(t, bob.GetUserContent(charlie.PublicID(), charlieSF.FID))
+	charliePath := assertDownload(data)
+	assert.ChanNotWritten(t, uploadChans["charlie"], time.Second)
+	hasFile, err = bob.HasDownloadedFile(charlieSF.FID)
+	assert.NilErr(t, err)
+	assert.BoolIs(t, hasFile, true)
+
+	// The downloads are independent copies of the file.
+	aliceFI, err := os.Stat(alicePath)
+	assert.NilErr(t, err)
+	charlieFI, err := os.Stat(charliePath)
+	assert.NilErr(t, err)
+	assert.BoolIs(t, os.SameFile(aliceFI, charlieFI), false)
+
+	// Bob modifies the previous downloads without changing their sizes,
+	// so Dave has to upload the chunks of the same file.
+	daveSF, _, err := dave.ShareFile(fname, nil, 0, false, "")
+	assert.NilErr(t, err)
+	_, modified := writeRandomFile(t, 400)
+	assert.NilErr(t, os.WriteFile(alicePath, modified, 0o600))
+	assert.NilErr(t, os.WriteFile(charliePath, modified, 0o600))
+	assert.NilErr(t, bob.GetUserContent(dave.PublicID(), daveSF.FID))
+	assertDownload(data)
+	assert.ChanWritten(t, uploadChans["dave"])
+	assertReclaimed(50)
+
+	// Drain the chunks uploaded by Alice.
+	for len(uploadChans["alice"]) > 0 {
+		<-uploadChans["alice"]
+	}
+
+	// Bob shares a new file that Alice also shares. Downloading it from
+	// Alice reuses the chunks of Bob's shared file.
+	_, data = writeRandomFile(t, 400)
+	fname = filepath.Join(t.TempDir(), "other.bin")
+	assert.NilErr(t, os.WriteFile(fname, data, 0o600))
+	bobSF, _, err := bob.ShareFile(fname, nil, 0, false, "")
+	assert.NilErr(t, err)
+	aliceSF, _, err = alice.ShareFile(fname, nil, 0, false, "")
+	assert.NilErr(t, err)
+	assert.NilErr(t, bob.GetUserContent(alice.PublicID(), aliceSF.FID))
+	assertDownload(data)
+	assert.ChanNotWritten(t, uploadChans["alice"], time.Second)
+
+	// The chunks are still referenced by Bob's shared file until it is
+	// unshared.
+	assertReclaimed(0)
+	assert.NilErr(t, bob.UnshareFile(bobSF.FID, nil))
+	assertReclaimed(50)
+}
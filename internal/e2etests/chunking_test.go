@@ -0,0 +1,106 @@
+package e2etests
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/companyzero/bisonrelay/client"
+	"github.com/companyzero/bisonrelay/internal/assert"
+	"github.com/companyzero/bisonrelay/rpc"
+)
+
+// TestContentDefinedChunking tests that downloading a new version of a file
+// chunked with content-defined chunking only fetches the changed chunks.
+func TestContentDefinedChunking(t *testing.T) {
+	tcfg := testScaffoldCfg{contentDefinedChunking: true}
+	ts := newTestScaffold(t, tcfg)
+	alice := ts.newClient("alice")
+	bob := ts.newClient("bob")
+	ts.kxUsers(alice, bob)
+
+	bobDownloadChan := make(chan string, 1)
+	bob.modifyHandlers(func() {
+		bob.onFileDownload = func(user *client.RemoteUser, fm rpc.FileMetadata, diskPath string) {
+			bobDownloadChan <- diskPath
+		}
+	})
+	aliceUploadChan := make(chan int, 1000)
+	alice.modifyHandlers(func() {
+		alice.onFileUpload = func(user *client.RemoteUser, fm rpc.FileMetadata, chunkIdx int) {
+			aliceUploadChan <- chunkIdx
+		}
+	})
+
+	assertDownload := func(data []byte) {
+		t.Helper()
+		diskPath := assert.ChanWritten(t, bobDownloadChan)
+		got, err := os.ReadFile(diskPath)
+		assert.NilErr(t, err)
+		if !bytes.Equal(got, data) {
+			t.Fatalf("downloaded file does not match shared file")
+		}
+	}
+	drainUploads := func() int {
+		time.Sleep(500 * time.Millisecond)
+		var n int
+		for len(aliceUploadChan) > 0 {
+			<-aliceUploadChan
+			n++
+		}
+		return n
+	}
+
+	// Alice shares the first version of the file.
+	_, data := writeRandomFile(t, 200)
+	fname := filepath.Join(t.TempDir(), "doc.bin")
+	assert.NilErr(t, os.WriteFile(fname, data, 0o600))
+	sf, md, err := alice.ShareFile(fname, nil, 0, false, "")
+	assert.NilErr(t, err)
+	assert.DeepEqual(t, md.Version, uint64(rpc.FileMetadataVersion))
+	assert.DeepEqual(t, md.Chunking, rpc.FileChunkingContentDefined)
+	assert.NilErr(t, md.VerifyManifest())
+
+	// Bob downloads it. The downloaded chunks are removed from the blob
+	// store, so that only the completed file can be used for later
+	// versions.
+	assert.NilErr(t, bob.GetUserContent(alice.PublicID(), sf.FID))
+	assertDownload(data)
+	drainUploads()
+	_, err = bob.ReclaimContentSpace()
+	assert.NilErr(t, err)
+
+	// Alice inserts some data in the middle of the file and shares the new
+	// version. Most of the chunks are the same as in the first version.
+	assert.NilErr(t, alice.UnshareFile(sf.FID, nil))
+	newData := append(append(append([]byte{}, data[:100]...),
+		[]byte("new data")...), data[100:]...)
+	assert.NilErr(t, os.WriteFile(fname, newData, 0o600))
+	newSF, newMD, err := alice.ShareFile(fname, nil, 0, false, "")
+	assert.NilErr(t, err)
+	oldHashes := make(map[string]struct{}, len(md.Manifest))
+	for _, ch := range md.Manifest {
+		oldHashes[string(ch.Hash)] = struct{}{}
+	}
+	var changedChunks int
+	for _, ch := range newMD.Manifest {
+		if _, ok := oldHashes[string(ch.Hash)]; !ok {
+			changedChunks++
+		}
+	}
+	if changedChunks == 0 || changedChunks > len(newMD.Manifest)/2 {
+		t.Fatalf("unexpected nb of changed chunks %d (total %d)",
+			changedChunks, len(newMD.Manifest))
+	}
+
+	// Bob downloads the new version. Only the changed chunks are uploaded
+	// by Alice.
+	assert.NilErr(t, bob.GetUserContent(alice.PublicID(), newSF.FID))
+	assertDownload(newData)
+	if uploaded := drainUploads(); uploaded == 0 || uploaded > changedChunks {
+		t.Fatalf("unexpected nb of uploaded chunks %d (changed %d)",
+			uploaded, changedChunks)
+	}
+}
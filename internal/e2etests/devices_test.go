@@ -0,0 +1,93 @@
+package e2etests
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/companyzero/bisonrelay/client"
+	"github.com/companyzero/bisonrelay/client/clientdb"
+	"github.com/companyzero/bisonrelay/internal/assert"
+	"github.com/companyzero/bisonrelay/rpc"
+)
+
+// TestLinkedDevices tests that a device linked to an identity receives the
+// PMs sent to the identity and can send PMs through the primary device.
+func TestLinkedDevices(t *testing.T) {
+	tcfg := testScaffoldCfg{}
+	ts := newTestScaffold(t, tcfg)
+	alice := ts.newClient("alice")
+	bob := ts.newClient("bob")
+	ts.kxUsers(alice, bob)
+
+	// Pair a new device to alice.
+	pairing := new(bytes.Buffer)
+	passphrase := []byte("pairing pass")
+	link, err := alice.PairDevice("laptop", pairing, passphrase)
+	assert.NilErr(t, err)
+
+	rootDir, err := os.MkdirTemp("", "br-client-alice2-*")
+	assert.NilErr(t, err)
+	t.Cleanup(func() { os.RemoveAll(rootDir) })
+	alice2 := ts.newClientWithOpts("alice2", rootDir, alice.id)
+	assert.NilErr(t, alice2.ImportDevicePairing(pairing, passphrase))
+	alice2 = ts.recreateClient(alice2)
+	assert.BoolIs(t, alice2.IsLinkedDevice(), true)
+	assert.DeepEqual(t, alice2.PublicID(), alice.PublicID())
+	_, err = alice2.UserNick(bob.PublicID())
+	assert.NilErr(t, err)
+
+	alicePMChan := make(chan string, 2)
+	alice.modifyHandlers(func() {
+		alice.onPM = func(user *client.RemoteUser, msg rpc.RMPrivateMessage, ts time.Time) {
+			alicePMChan <- msg.Message
+		}
+	})
+	alice2PMChan := make(chan string, 2)
+	alice2.modifyHandlers(func() {
+		alice2.onPM = func(user *client.RemoteUser, msg rpc.RMPrivateMessage, ts time.Time) {
+			alice2PMChan <- msg.Message
+		}
+	})
+	bobPMChan := make(chan string, 2)
+	bob.modifyHandlers(func() {
+		bob.onPM = func(user *client.RemoteUser, msg rpc.RMPrivateMessage, ts time.Time) {
+			bobPMChan <- msg.Message
+		}
+	})
+
+	// PMs from bob arrive on both devices.
+	assert.NilErr(t, bob.PM(alice.PublicID(), "to alice"))
+	assert.DeepEqual(t, assert.ChanWritten(t, alicePMChan), "to alice")
+	assert.DeepEqual(t, assert.ChanWritten(t, alice2PMChan), "to alice")
+
+	// PMs from the linked device are sent through the primary device and
+	// logged in both devices.
+	assert.NilErr(t, alice2.PM(bob.PublicID(), "from laptop"))
+	assert.DeepEqual(t, assert.ChanWritten(t, bobPMChan), "from laptop")
+	history, err := alice.ReadPMHistory(bob.PublicID(), 0, 1)
+	assert.NilErr(t, err)
+	assert.DeepEqual(t, history[0].Message, "from laptop")
+
+	// Reactions from bob arrive on the linked device.
+	alice2ReactionChan := make(chan rpc.RMMessageReaction, 1)
+	alice2.modifyHandlers(func() {
+		alice2.onMsgReaction = func(user *client.RemoteUser, reaction rpc.RMMessageReaction, reactions clientdb.Reactions) {
+			alice2ReactionChan <- reaction
+		}
+	})
+	assert.NilErr(t, bob.ReactToPM(alice.PublicID(), history[0].MsgID, ":+1:", false))
+	reaction := assert.ChanWritten(t, alice2ReactionChan)
+	assert.DeepEqual(t, reaction.ID, history[0].MsgID)
+	assert.DeepEqual(t, reaction.Reaction, ":+1:")
+
+	// After the device is revoked, it no longer receives PMs.
+	assert.NilErr(t, alice.RevokeDevice(link.DeviceID))
+	links, err := alice.ListDevices()
+	assert.NilErr(t, err)
+	assert.DeepEqual(t, len(links), 0)
+	assert.NilErr(t, bob.PM(alice.PublicID(), "after revoke"))
+	assert.DeepEqual(t, assert.ChanWritten(t, alicePMChan), "after revoke")
+	assert.ChanNotWritten(t, alice2PMChan, 500*time.Millisecond)
+}
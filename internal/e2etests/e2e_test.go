@@ -25,7 +25,12 @@ import (
 )
 
 type testScaffoldCfg struct {
-	showLog bool
+	showLog                 bool
+	gcInviteExpiration      time.Duration
+	gcJoinLinkRetryInterval time.Duration
+	multiSourceDownloads    bool
+	multiSourceChunkTimeout time.Duration
+	contentDefinedChunking  bool
 }
 
 type testConn struct {
@@ -72,11 +77,90 @@ func (tc *testConn) RemoteAddr() net.Addr {
 	return tc.netConn.RemoteAddr()
 }
 
+// testPayNet is a mock payment network shared by the clients of a test
+// scaffold. Invoices generated by one client may be paid by any other client.
+type testPayNet struct {
+	mtx      sync.Mutex
+	nextID   int
+	invoices map[string]*testInvoice
+}
+
+type testInvoice struct {
+	matoms int64
+	cb     func(int64)
+	paid   bool
+}
+
+// testPayClient is a payment client that generates and pays invoices in a
+// testPayNet.
+type testPayClient struct {
+	net *testPayNet
+}
+
+func (pc testPayClient) PayScheme() string { return rpc.PaySchemeFree }
+
+func (pc testPayClient) PayInvoice(ctx context.Context, invoice string) (int64, error) {
+	pc.net.mtx.Lock()
+	inv, ok := pc.net.invoices[invoice]
+	if ok && inv.paid {
+		pc.net.mtx.Unlock()
+		return 0, fmt.Errorf("invoice already paid")
+	}
+	if ok {
+		inv.paid = true
+	}
+	pc.net.mtx.Unlock()
+	if !ok {
+		return 0, fmt.Errorf("unknown invoice %q", invoice)
+	}
+	if inv.cb != nil {
+		go inv.cb(inv.matoms)
+	}
+	return 0, nil
+}
+
+func (pc testPayClient) PayInvoiceAmount(ctx context.Context, invoice string, _ int64) (int64, error) {
+	return pc.PayInvoice(ctx, invoice)
+}
+
+func (pc testPayClient) GetInvoice(ctx context.Context, matoms int64, cb func(int64)) (string, error) {
+	pc.net.mtx.Lock()
+	pc.net.nextID++
+	invoice := fmt.Sprintf("testinvoice-%d", pc.net.nextID)
+	pc.net.invoices[invoice] = &testInvoice{matoms: matoms, cb: cb}
+	pc.net.mtx.Unlock()
+	return invoice, nil
+}
+
+func (pc testPayClient) DecodeInvoice(ctx context.Context, invoice string) (clientintf.DecodedInvoice, error) {
+	pc.net.mtx.Lock()
+	inv, ok := pc.net.invoices[invoice]
+	pc.net.mtx.Unlock()
+	if !ok {
+		return clientintf.DecodedInvoice{}, fmt.Errorf("unknown invoice %q", invoice)
+	}
+	return clientintf.DecodedInvoice{
+		MAtoms:     inv.matoms,
+		ExpiryTime: time.Now().Add(time.Hour),
+	}, nil
+}
+
+func (pc testPayClient) IsInvoicePaid(ctx context.Context, _ int64, invoice string) error {
+	pc.net.mtx.Lock()
+	inv, ok := pc.net.invoices[invoice]
+	pc.net.mtx.Unlock()
+	if !ok || !inv.paid {
+		return fmt.Errorf("invoice not paid")
+	}
+	return nil
+}
+
 type testClient struct {
 	*client.Client
 	name    string
 	id      *zkidentity.FullIdentity
 	rootDir string
+	db      *clientdb.DB
 	ctx     context.Context
 	cancel  func()
 	runC    chan error
@@ -88,9 +172,24 @@ type testClient struct {
 	onPM            func(user *client.RemoteUser, msg rpc.RMPrivateMessage, ts time.Time)
 	onConnChanged   func(connected bool, pushRate, subRate uint64)
 	onInvitedToGC   func(user *client.RemoteUser, iid uint64, invite rpc.RMGroupInvite)
+	onGCJoinReject  func(user *client.RemoteUser, invite rpc.RMGroupInvite, reason string)
 	onGCMsg         func(user *client.RemoteUser, msg rpc.RMGroupMessage, ts time.Time)
 	onGCListUpdated func(gc clientdb.GCAddressBookEntry)
 	onGCUserParted  func(gcid client.GCID, uid clientintf.UserID, reason string, kicked bool)
+	onRetention     func(user *client.RemoteUser, gcID zkidentity.ShortID, policy rpc.RetentionPolicy)
+	onPMReceipt     func(user *client.RemoteUser, receipt rpc.RMReceipt)
+	onMsgEdit       func(user *client.RemoteUser, edit rpc.RMMessageEdit)
+	onMsgRetract    func(user *client.RemoteUser, retract rpc.RMMessageRetract)
+	onMsgReaction   func(user *client.RemoteUser, reaction rpc.RMMessageReaction, reactions clientdb.Reactions)
+	onPostRcvd      func(user *client.RemoteUser, summ clientdb.PostSummary, post rpc.PostMetadata)
+	onPostStatus    func(pid clientintf.PostID, statusFrom clientintf.UserID, status rpc.PostMetadataStatus)
+	onNotification  func(n client.Notification)
+	onGCJoinReq     func(req clientdb.GCJoinRequest)
+	onGCMeshKX      func(progress client.GCMeshKXProgress)
+	onGCMetadata    func(user *client.RemoteUser, md rpc.RMGroupMetadata)
+	onFileDownload  func(user *client.RemoteUser, fm rpc.FileMetadata, diskPath string)
+	onFileUpload    func(user *client.RemoteUser, fm rpc.FileMetadata, chunkIdx int)
+	onSharedFolder  func(user *client.RemoteUser, sf clientdb.SubscribedFolder)
 }
 
 // modifyHandlers calls f with the mutex held, so that the client handlers can
@@ -176,6 +275,7 @@ type testScaffold struct {
 	svr     *server.ZKS
 	svrRunC chan error
 	svrAddr string
+	payNet  *testPayNet
 }
 
 func (ts *testScaffold) newClientWithOpts(name string, rootDir string,
@@ -224,18 +324,28 @@ func (ts *testScaffold) newClientWithOpts(name string, rootDir string,
 		DownloadsRoot: filepath.Join(rootDir, "downloads"),
 		Logger:        dbLog,
 		ChunkSize:     8,
+
+		ContentDefinedChunking: ts.cfg.contentDefinedChunking,
 	}
 	db, err := clientdb.New(dbCfg)
 	assert.NilErr(ts.t, err)
 
 	cfg := client.Config{
-		ReconnectDelay: 500 * time.Millisecond,
-		Dialer:         dialer,
+		ReconnectDelay:     500 * time.Millisecond,
+		GCMeshKXDelay:      100 * time.Millisecond,
+		GCInviteExpiration: ts.cfg.gcInviteExpiration,
+		Dialer:             dialer,
+
+		GCJoinLinkRetryInterval: ts.cfg.gcJoinLinkRetryInterval,
+
+		MultiSourceDownloads:    ts.cfg.multiSourceDownloads,
+		MultiSourceChunkTimeout: ts.cfg.multiSourceChunkTimeout,
 		CertConfirmer: func(context.Context, *tls.ConnectionState,
 			*zkidentity.PublicIdentity) error {
 			return nil
 		},
 		DB:            db,
+		PayClient:     testPayClient{net: ts.payNet},
 		LocalIDIniter: idIniter,
 		Logger:        logBknd,
 
@@ -276,6 +386,15 @@ func (ts *testScaffold) newClientWithOpts(name string, rootDir string,
 			}
 		},
 
+		GCJoinRejected: func(user *client.RemoteUser, invite rpc.RMGroupInvite, reason string) {
+			tc.mtx.Lock()
+			f := tc.onGCJoinReject
+			tc.mtx.Unlock()
+			if f != nil {
+				f(user, invite, reason)
+			}
+		},
+
 		GCMsgHandler: func(user *client.RemoteUser, msg rpc.RMGroupMessage, ts time.Time) {
 			tc.mtx.Lock()
 			f := tc.onGCMsg
@@ -285,6 +404,33 @@ func (ts *testScaffold) newClientWithOpts(name string, rootDir string,
 			}
 		},
 
+		GCMeshKXProgress: func(progress client.GCMeshKXProgress) {
+			tc.mtx.Lock()
+			f := tc.onGCMeshKX
+			tc.mtx.Unlock()
+			if f != nil {
+				f(progress)
+			}
+		},
+
+		GCJoinRequested: func(req clientdb.GCJoinRequest) {
+			tc.mtx.Lock()
+			f := tc.onGCJoinReq
+			tc.mtx.Unlock()
+			if f != nil {
+				f(req)
+			}
+		},
+
+		GCMetadataUpdated: func(user *client.RemoteUser, md rpc.RMGroupMetadata) {
+			tc.mtx.Lock()
+			f := tc.onGCMetadata
+			tc.mtx.Unlock()
+			if f != nil {
+				f(user, md)
+			}
+		},
+
 		GCListUpdated: func(gc clientdb.GCAddressBookEntry) {
 			tc.mtx.Lock()
 			f := tc.onGCListUpdated
@@ -302,6 +448,107 @@ func (ts *testScaffold) newClientWithOpts(name string, rootDir string,
 				f(gcid, uid, reason, kicked)
 			}
 		},
+
+		PMReceiptHandler: func(user *client.RemoteUser, receipt rpc.RMReceipt) {
+			tc.mtx.Lock()
+			f := tc.onPMReceipt
+			tc.mtx.Unlock()
+			if f != nil {
+				f(user, receipt)
+			}
+		},
+
+		MessageEditHandler: func(user *client.RemoteUser, edit rpc.RMMessageEdit, ts time.Time) {
+			tc.mtx.Lock()
+			f := tc.onMsgEdit
+			tc.mtx.Unlock()
+			if f != nil {
+				f(user, edit)
+			}
+		},
+
+		MessageRetractHandler: func(user *client.RemoteUser, retract rpc.RMMessageRetract, ts time.Time) {
+			tc.mtx.Lock()
+			f := tc.onMsgRetract
+			tc.mtx.Unlock()
+			if f != nil {
+				f(user, retract)
+			}
+		},
+
+		MessageReactionHandler: func(user *client.RemoteUser, reaction rpc.RMMessageReaction,
+			reactions clientdb.Reactions, ts time.Time) {
+			tc.mtx.Lock()
+			f := tc.onMsgReaction
+			tc.mtx.Unlock()
+			if f != nil {
+				f(user, reaction, reactions)
+			}
+		},
+
+		NotificationHandler: func(n client.Notification) {
+			tc.mtx.Lock()
+			f := tc.onNotification
+			tc.mtx.Unlock()
+			if f != nil {
+				f(n)
+			}
+		},
+
+		PostReceived: func(user *client.RemoteUser, summ clientdb.PostSummary, post rpc.PostMetadata) {
+			tc.mtx.Lock()
+			f := tc.onPostRcvd
+			tc.mtx.Unlock()
+			if f != nil {
+				f(user, summ, post)
+			}
+		},
+
+		PostStatusReceived: func(user *client.RemoteUser, pid clientintf.PostID,
+			statusFrom clientintf.UserID, status rpc.PostMetadataStatus) {
+			tc.mtx.Lock()
+			f := tc.onPostStatus
+			tc.mtx.Unlock()
+			if f != nil {
+				f(pid, statusFrom, status)
+			}
+		},
+
+		RetentionPolicyChanged: func(user *client.RemoteUser, gcID zkidentity.ShortID, policy rpc.RetentionPolicy) {
+			tc.mtx.Lock()
+			f := tc.onRetention
+			tc.mtx.Unlock()
+			if f != nil {
+				f(user, gcID, policy)
+			}
+		},
+
+		FileDownloadCompleted: func(user *client.RemoteUser, fm rpc.FileMetadata, diskPath string) {
+			tc.mtx.Lock()
+			f := tc.onFileDownload
+			tc.mtx.Unlock()
+			if f != nil {
+				f(user, fm, diskPath)
+			}
+		},
+
+		FileUploadProgress: func(user *client.RemoteUser, fm rpc.FileMetadata, chunkIdx int) {
+			tc.mtx.Lock()
+			f := tc.onFileUpload
+			tc.mtx.Unlock()
+			if f != nil {
+				f(user, fm, chunkIdx)
+			}
+		},
+
+		SharedFolderUpdated: func(user *client.RemoteUser, sf clientdb.SubscribedFolder) {
+			tc.mtx.Lock()
+			f := tc.onSharedFolder
+			tc.mtx.Unlock()
+			if f != nil {
+				f(user, sf)
+			}
+		},
 	}
 	c, err := client.New(cfg)
 	assert.NilErr(ts.t, err)
@@ -314,6 +561,7 @@ func (ts *testScaffold) newClientWithOpts(name string, rootDir string,
 		cancel:  cancel,
 		id:      id,
 		rootDir: rootDir,
+		db:      db,
 		runC:    make(chan error, 1),
 	}
 	go func() { tc.runC <- c.Run(ctx) }()
@@ -422,6 +670,7 @@ func newTestScaffold(t *testing.T, cfg testScaffoldCfg) *testScaffold {
 		svr:     newTestServer(t, cfg.showLog),
 		svrRunC: make(chan error, 1),
 		showLog: cfg.showLog,
+		payNet:  &testPayNet{invoices: make(map[string]*testInvoice)},
 	}
 	go ts.run()
 
@@ -0,0 +1,187 @@
+package e2etests
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/companyzero/bisonrelay/client"
+	"github.com/companyzero/bisonrelay/client/clientdb"
+	"github.com/companyzero/bisonrelay/internal/assert"
+	"github.com/companyzero/bisonrelay/zkidentity"
+)
+
+// assertGCRoles asserts that the client eventually sees the given owner, admins
+// and moderators in the GC.
+func assertGCRoles(t testing.TB, c *testClient, gcID zkidentity.ShortID,
+	owner client.UserID, admins, mods []client.UserID) {
+
+	t.Helper()
+	var gc clientdb.GCAddressBookEntry
+	var err error
+	for i := 0; i < 100; i++ {
+		gc, err = c.GetGC(gcID)
+		if err == nil && len(gc.Members) > 0 && gc.Members[0] == owner &&
+			reflect.DeepEqual(gc.Admins, admins) &&
+			reflect.DeepEqual(gc.Moderators, mods) {
+			return
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+	t.Fatalf("Client %s did not see expected GC roles (err %v, list %v)",
+		c.name, err, gc)
+}
+
+// TestGCAdmins tests that the owner of a GC can add admins and moderators,
+// which can then manage the GC members according to their roles.
+func TestGCAdmins(t *testing.T) {
+	tcfg := testScaffoldCfg{}
+	ts := newTestScaffold(t, tcfg)
+	alice := ts.newClient("alice")
+	bob := ts.newClient("bob")
+	charlie := ts.newClient("charlie")
+	dave := ts.newClient("dave")
+	ts.kxUsers(alice, bob)
+	ts.kxUsers(alice, charlie)
+	ts.kxUsers(alice, dave)
+	ts.kxUsers(bob, charlie)
+	ts.kxUsers(bob, dave)
+	ts.kxUsers(charlie, dave)
+
+	gcID, err := alice.NewGroupChat("test gc")
+	assert.NilErr(t, err)
+	for _, c := range []*testClient{bob, charlie} {
+		acceptedChan := c.acceptNextGCInvite(gcID)
+		assert.NilErr(t, alice.InviteToGroupChat(gcID, c.PublicID()))
+		assert.NilErrFromChan(t, acceptedChan)
+		assertClientInGC(t, c, gcID)
+	}
+
+	// Only the owner may add admins.
+	if err := bob.AddGCAdmin(gcID, charlie.PublicID(), false); err == nil {
+		t.Fatalf("unexpected nil error adding admin by non-owner")
+	}
+
+	// Alice makes bob an admin and charlie a moderator.
+	assert.NilErr(t, alice.AddGCAdmin(gcID, bob.PublicID(), false))
+	assert.NilErr(t, alice.AddGCAdmin(gcID, charlie.PublicID(), true))
+	admins := []client.UserID{bob.PublicID()}
+	mods := []client.UserID{charlie.PublicID()}
+	for _, c := range []*testClient{alice, bob, charlie} {
+		assertGCRoles(t, c, gcID, alice.PublicID(), admins, mods)
+	}
+
+	// Bob, as an admin, invites dave.
+	acceptedChan := dave.acceptNextGCInvite(gcID)
+	assert.NilErr(t, bob.InviteToGroupChat(gcID, dave.PublicID()))
+	assert.NilErrFromChan(t, acceptedChan)
+	assertClientInGC(t, dave, gcID)
+	assertGCRoles(t, dave, gcID, alice.PublicID(), admins, mods)
+
+	// Charlie, as a moderator, cannot kick bob or kill the GC, but can
+	// kick dave.
+	if err := charlie.GCKick(gcID, bob.PublicID(), "no reason"); err == nil {
+		t.Fatalf("unexpected nil error kicking admin by moderator")
+	}
+	if err := charlie.KillGroupChat(gcID, "no reason"); err == nil {
+		t.Fatalf("unexpected nil error killing GC by moderator")
+	}
+	alicePartedChan := alice.nextGCUserPartedIs(gcID, dave.PublicID(), true)
+	assert.NilErr(t, charlie.GCKick(gcID, dave.PublicID(), "no reason"))
+	assert.NilErrFromChan(t, alicePartedChan)
+
+	// Alice transfers the ownership of the GC to bob and remains an
+	// admin.
+	assert.NilErr(t, alice.TransferGCOwnership(gcID, bob.PublicID()))
+	admins = []client.UserID{alice.PublicID()}
+	for _, c := range []*testClient{alice, bob, charlie} {
+		assertGCRoles(t, c, gcID, bob.PublicID(), admins, mods)
+	}
+	if err := alice.RemoveGCAdmin(gcID, charlie.PublicID()); err == nil {
+		t.Fatalf("unexpected nil error removing admin by old owner")
+	}
+
+	// Bob removes the role of charlie.
+	assert.NilErr(t, bob.RemoveGCAdmin(gcID, charlie.PublicID()))
+	for _, c := range []*testClient{alice, bob, charlie} {
+		assertGCRoles(t, c, gcID, bob.PublicID(), admins, nil)
+	}
+}
+
+// TestGCListSignedAfterPart tests that the owner of a GC signs and sends the
+// updated list after a member parts, and that the remaining members accept it.
+func TestGCListSignedAfterPart(t *testing.T) {
+	tcfg := testScaffoldCfg{}
+	ts := newTestScaffold(t, tcfg)
+	alice := ts.newClient("alice")
+	bob := ts.newClient("bob")
+	charlie := ts.newClient("charlie")
+	ts.kxUsers(alice, bob)
+	ts.kxUsers(alice, charlie)
+	ts.kxUsers(bob, charlie)
+
+	gcID, err := alice.NewGroupChat("test gc")
+	assert.NilErr(t, err)
+	for _, c := range []*testClient{bob, charlie} {
+		acceptedChan := c.acceptNextGCInvite(gcID)
+		assert.NilErr(t, alice.InviteToGroupChat(gcID, c.PublicID()))
+		assert.NilErrFromChan(t, acceptedChan)
+		assertClientInGC(t, c, gcID)
+	}
+	gc, err := bob.GetGC(gcID)
+	assert.NilErr(t, err)
+	assert.DeepEqual(t, len(gc.Members), 3)
+
+	// Charlie parts. Bob receives the new list signed by alice.
+	bobListChan := make(chan clientdb.GCAddressBookEntry, 2)
+	bob.modifyHandlers(func() {
+		bob.onGCListUpdated = func(gc clientdb.GCAddressBookEntry) {
+			bobListChan <- gc
+		}
+	})
+	alicePartedChan := alice.nextGCUserPartedIs(gcID, charlie.PublicID(), false)
+	assert.NilErr(t, charlie.PartFromGC(gcID, "bye"))
+	assert.NilErrFromChan(t, alicePartedChan)
+	gc = assert.ChanWritten(t, bobListChan)
+	assert.DeepEqual(t, gc.Members, []client.UserID{alice.PublicID(), bob.PublicID()})
+
+	// The owner can keep adding members with signed lists.
+	acceptedChan := charlie.acceptNextGCInvite(gcID)
+	assert.NilErr(t, alice.InviteToGroupChat(gcID, charlie.PublicID()))
+	assert.NilErrFromChan(t, acceptedChan)
+	assertClientInGC(t, charlie, gcID)
+	gc = assert.ChanWritten(t, bobListChan)
+	assert.DeepEqual(t, len(gc.Members), 3)
+}
+
+// TestGCAdminInviteUnknownOwner tests that a user invited by an admin of a GC
+// whose owner is not known to the user only accepts the list of the GC after
+// KX'ing with the owner and verifying the set of admins.
+func TestGCAdminInviteUnknownOwner(t *testing.T) {
+	tcfg := testScaffoldCfg{}
+	ts := newTestScaffold(t, tcfg)
+	alice := ts.newClient("alice")
+	bob := ts.newClient("bob")
+	charlie := ts.newClient("charlie")
+	ts.kxUsers(alice, bob)
+	ts.kxUsers(bob, charlie)
+
+	gcID, err := alice.NewGroupChat("test gc")
+	assert.NilErr(t, err)
+	acceptedChan := bob.acceptNextGCInvite(gcID)
+	assert.NilErr(t, alice.InviteToGroupChat(gcID, bob.PublicID()))
+	assert.NilErrFromChan(t, acceptedChan)
+	assertClientInGC(t, bob, gcID)
+	assert.NilErr(t, alice.AddGCAdmin(gcID, bob.PublicID(), false))
+	admins := []client.UserID{bob.PublicID()}
+	assertGCRoles(t, bob, gcID, alice.PublicID(), admins, nil)
+
+	// Bob invites charlie, which KXs with alice before joining the GC.
+	acceptedChan = charlie.acceptNextGCInvite(gcID)
+	assert.NilErr(t, bob.InviteToGroupChat(gcID, charlie.PublicID()))
+	assert.NilErrFromChan(t, acceptedChan)
+	assertClientInGC(t, charlie, gcID)
+	assertGCRoles(t, charlie, gcID, alice.PublicID(), admins, nil)
+	_, err = charlie.UserNick(alice.PublicID())
+	assert.NilErr(t, err)
+}
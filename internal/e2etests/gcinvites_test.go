@@ -0,0 +1,105 @@
+package e2etests
+
+import (
+	"testing"
+	"time"
+
+	"github.com/companyzero/bisonrelay/client"
+	"github.com/companyzero/bisonrelay/client/clientdb"
+	"github.com/companyzero/bisonrelay/internal/assert"
+	"github.com/companyzero/bisonrelay/rpc"
+)
+
+// TestGCRevokeInvite tests that admins can list and revoke outstanding GC
+// invites and that users attempting to join the GC with revoked invites are
+// told the join was rejected.
+func TestGCRevokeInvite(t *testing.T) {
+	tcfg := testScaffoldCfg{}
+	ts := newTestScaffold(t, tcfg)
+	alice := ts.newClient("alice")
+	bob := ts.newClient("bob")
+	ts.kxUsers(alice, bob)
+
+	gcID, err := alice.NewGroupChat("test gc")
+	assert.NilErr(t, err)
+
+	inviteChan := make(chan uint64, 1)
+	bob.modifyHandlers(func() {
+		bob.onInvitedToGC = func(user *client.RemoteUser, iid uint64, invite rpc.RMGroupInvite) {
+			inviteChan <- iid
+		}
+	})
+	assert.NilErr(t, alice.InviteToGroupChat(gcID, bob.PublicID()))
+	iid := assert.ChanWritten(t, inviteChan)
+
+	// Alice sees the outstanding invite.
+	invites, err := alice.ListSentGCInvites(gcID)
+	assert.NilErr(t, err)
+	assert.DeepEqual(t, len(invites), 1)
+	assert.DeepEqual(t, invites[0].User, bob.PublicID())
+
+	// Alice revokes the invite.
+	assert.NilErr(t, alice.RevokeGCInvite(gcID, bob.PublicID()))
+	invites, err = alice.ListSentGCInvites(gcID)
+	assert.NilErr(t, err)
+	assert.DeepEqual(t, len(invites), 0)
+
+	// Bob accepts the invite, but does not join the GC. Alice rejects the
+	// join and Bob removes the invite.
+	bobGCListChan := make(chan clientdb.GCAddressBookEntry, 1)
+	bobRejectChan := make(chan string, 1)
+	bob.modifyHandlers(func() {
+		bob.onGCListUpdated = func(gc clientdb.GCAddressBookEntry) {
+			bobGCListChan <- gc
+		}
+		bob.onGCJoinReject = func(user *client.RemoteUser, invite rpc.RMGroupInvite, reason string) {
+			bobRejectChan <- reason
+		}
+	})
+	assert.NilErr(t, bob.AcceptGroupChatInvite(iid))
+	assert.DeepEqual(t, assert.ChanWritten(t, bobRejectChan), "unknown GC invite")
+	assert.ChanNotWritten(t, bobGCListChan, 500*time.Millisecond)
+	invites, err = bob.ListGCInvitesFor(gcID.String())
+	assert.NilErr(t, err)
+	assert.DeepEqual(t, len(invites), 0)
+}
+
+// TestGCInviteExpiration tests that expired GC invites cannot be accepted and
+// are removed instead of listed.
+func TestGCInviteExpiration(t *testing.T) {
+	tcfg := testScaffoldCfg{gcInviteExpiration: time.Second}
+	ts := newTestScaffold(t, tcfg)
+	alice := ts.newClient("alice")
+	bob := ts.newClient("bob")
+	ts.kxUsers(alice, bob)
+
+	gcID, err := alice.NewGroupChat("test gc")
+	assert.NilErr(t, err)
+
+	inviteChan := make(chan uint64, 1)
+	bob.modifyHandlers(func() {
+		bob.onInvitedToGC = func(user *client.RemoteUser, iid uint64, invite rpc.RMGroupInvite) {
+			inviteChan <- iid
+		}
+	})
+	assert.NilErr(t, alice.InviteToGroupChat(gcID, bob.PublicID()))
+	iid := assert.ChanWritten(t, inviteChan)
+	invites, err := bob.ListGCInvitesFor(gcID.String())
+	assert.NilErr(t, err)
+	assert.DeepEqual(t, len(invites), 1)
+
+	// Wait until the invite expires.
+	time.Sleep(2100 * time.Millisecond)
+
+	// The invite is no longer listed on either side.
+	invites, err = alice.ListSentGCInvites(gcID)
+	assert.NilErr(t, err)
+	assert.DeepEqual(t, len(invites), 0)
+	invites, err = bob.ListGCInvitesFor(gcID.String())
+	assert.NilErr(t, err)
+	assert.DeepEqual(t, len(invites), 0)
+
+	// Bob's invite was removed, so it can no longer be accepted.
+	err = bob.AcceptGroupChatInvite(iid)
+	assert.ErrorIs(t, err, clientdb.ErrNotFound)
+}
@@ -0,0 +1,94 @@
+package e2etests
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/companyzero/bisonrelay/client/clientdb"
+	"github.com/companyzero/bisonrelay/internal/assert"
+)
+
+// TestGCJoinLink tests that users that have not performed KX with the admin of
+// a GC can join it by redeeming a join link.
+func TestGCJoinLink(t *testing.T) {
+	tcfg := testScaffoldCfg{}
+	ts := newTestScaffold(t, tcfg)
+	alice := ts.newClient("alice")
+	bob := ts.newClient("bob")
+	charlie := ts.newClient("charlie")
+
+	gcID, err := alice.NewGroupChat("open gc")
+	assert.NilErr(t, err)
+
+	// Bob redeems a link that does not need approval.
+	var b bytes.Buffer
+	_, err = alice.CreateGCJoinLink(gcID, time.Hour, 2, false, &b)
+	assert.NilErr(t, err)
+	link, err := bob.ReadGCJoinLink(&b)
+	assert.NilErr(t, err)
+	assert.NilErr(t, bob.RedeemGCJoinLink(link))
+	assertClientInGC(t, bob, gcID)
+
+	// Bob cannot redeem the link again.
+	if err := bob.RedeemGCJoinLink(link); err == nil {
+		t.Fatalf("unexpected nil error redeeming link twice")
+	}
+
+	// Charlie redeems a link that needs approval.
+	reqChan := make(chan clientdb.GCJoinRequest, 1)
+	alice.modifyHandlers(func() {
+		alice.onGCJoinReq = func(req clientdb.GCJoinRequest) {
+			reqChan <- req
+		}
+	})
+	link, err = alice.CreateGCJoinLink(gcID, time.Hour, 1, true, nil)
+	assert.NilErr(t, err)
+	assert.NilErr(t, charlie.RedeemGCJoinLink(link))
+	req := assert.ChanWritten(t, reqChan)
+	assert.DeepEqual(t, req.Invite.Public.Identity, charlie.PublicID())
+	reqs, err := alice.ListGCJoinRequests()
+	assert.NilErr(t, err)
+	assert.DeepEqual(t, len(reqs), 1)
+	assert.NilErr(t, alice.ApproveGCJoinRequest(charlie.PublicID()))
+	assertClientInGC(t, charlie, gcID)
+	reqs, err = alice.ListGCJoinRequests()
+	assert.NilErr(t, err)
+	assert.DeepEqual(t, len(reqs), 0)
+
+	// Expired links cannot be redeemed.
+	link, err = alice.CreateGCJoinLink(gcID, -time.Hour, 1, false, nil)
+	assert.NilErr(t, err)
+	if err := ts.newClient("dave").RedeemGCJoinLink(link); err == nil {
+		t.Fatalf("unexpected nil error redeeming expired link")
+	}
+}
+
+// TestGCJoinLinkAdminOffline tests that multiple users may redeem a join link
+// while the admin is offline, even if their requests are sent to the same slot
+// of the link.
+func TestGCJoinLinkAdminOffline(t *testing.T) {
+	tcfg := testScaffoldCfg{gcJoinLinkRetryInterval: 500 * time.Millisecond}
+	ts := newTestScaffold(t, tcfg)
+	alice := ts.newClient("alice")
+	bob := ts.newClient("bob")
+	charlie := ts.newClient("charlie")
+
+	gcID, err := alice.NewGroupChat("open gc")
+	assert.NilErr(t, err)
+	link, err := alice.CreateGCJoinLink(gcID, time.Hour, 2, false, nil)
+	assert.NilErr(t, err)
+
+	// Alice goes offline and both Bob and Charlie redeem the link. Their
+	// requests are retried on the other slot while Alice is offline.
+	alice.cancel()
+	assert.ChanWritten(t, alice.runC)
+	assert.NilErr(t, bob.RedeemGCJoinLink(link))
+	assert.NilErr(t, charlie.RedeemGCJoinLink(link))
+	time.Sleep(2 * time.Second)
+
+	// Once Alice is back online, both join the GC.
+	alice = ts.newClientWithOpts(alice.name, alice.rootDir, alice.id)
+	assertClientInGC(t, bob, gcID)
+	assertClientInGC(t, charlie, gcID)
+}
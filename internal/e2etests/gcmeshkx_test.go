@@ -0,0 +1,57 @@
+package e2etests
+
+import (
+	"testing"
+	"time"
+
+	"github.com/companyzero/bisonrelay/client"
+	"github.com/companyzero/bisonrelay/internal/assert"
+)
+
+// TestGCMeshKX tests that members that join a GC automatically KX with the
+// existing members that they did not know.
+func TestGCMeshKX(t *testing.T) {
+	tcfg := testScaffoldCfg{}
+	ts := newTestScaffold(t, tcfg)
+	alice := ts.newClient("alice")
+	bob := ts.newClient("bob")
+	charlie := ts.newClient("charlie")
+	dave := ts.newClient("dave")
+	ts.kxUsers(alice, bob)
+	ts.kxUsers(alice, charlie)
+	ts.kxUsers(alice, dave)
+
+	gcID, err := alice.NewGroupChat("test gc")
+	assert.NilErr(t, err)
+	for _, c := range []*testClient{bob, charlie} {
+		acceptedChan := c.acceptNextGCInvite(gcID)
+		assert.NilErr(t, alice.InviteToGroupChat(gcID, c.PublicID()))
+		assert.NilErrFromChan(t, acceptedChan)
+		assertClientInGC(t, c, gcID)
+	}
+	assertClientsKXd(t, bob, charlie)
+
+	// Dave joins and mediates KX with both bob and charlie via alice.
+	progressChan := make(chan client.GCMeshKXProgress, 2)
+	dave.modifyHandlers(func() {
+		dave.onGCMeshKX = func(progress client.GCMeshKXProgress) {
+			progressChan <- progress
+		}
+	})
+	acceptedChan := dave.acceptNextGCInvite(gcID)
+	assert.NilErr(t, alice.InviteToGroupChat(gcID, dave.PublicID()))
+	assert.NilErrFromChan(t, acceptedChan)
+	for i := 1; i <= 2; i++ {
+		progress := assert.ChanWritten(t, progressChan)
+		assert.NilErr(t, progress.Err)
+		assert.DeepEqual(t, progress.Mediator, alice.PublicID())
+		assert.DeepEqual(t, progress.Sent, i)
+		assert.DeepEqual(t, progress.Total, 2)
+	}
+	assertClientsKXd(t, dave, bob)
+	assertClientsKXd(t, dave, charlie)
+
+	// Nothing else needs to be KX'd.
+	assert.NilErr(t, dave.MeshKXGC(gcID))
+	assert.ChanNotWritten(t, progressChan, 500*time.Millisecond)
+}
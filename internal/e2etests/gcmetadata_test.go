@@ -0,0 +1,88 @@
+package e2etests
+
+import (
+	"testing"
+
+	"github.com/companyzero/bisonrelay/client"
+	"github.com/companyzero/bisonrelay/internal/assert"
+	"github.com/companyzero/bisonrelay/rpc"
+	"github.com/companyzero/bisonrelay/zkidentity"
+)
+
+// TestGCMetadata tests that the metadata of a GC is sent to its members when
+// modified by an admin and to new members when they join the GC.
+func TestGCMetadata(t *testing.T) {
+	tcfg := testScaffoldCfg{}
+	ts := newTestScaffold(t, tcfg)
+	alice := ts.newClient("alice")
+	bob := ts.newClient("bob")
+	charlie := ts.newClient("charlie")
+	ts.kxUsers(alice, bob)
+	ts.kxUsers(alice, charlie)
+
+	gcID, err := alice.NewGroupChat("test gc")
+	assert.NilErr(t, err)
+	acceptedChan := bob.acceptNextGCInvite(gcID)
+	assert.NilErr(t, alice.InviteToGroupChat(gcID, bob.PublicID()))
+	assert.NilErrFromChan(t, acceptedChan)
+	assertClientInGC(t, bob, gcID)
+
+	bobMDChan := make(chan rpc.RMGroupMetadata, 3)
+	bob.modifyHandlers(func() {
+		bob.onGCMetadata = func(user *client.RemoteUser, md rpc.RMGroupMetadata) {
+			bobMDChan <- md
+		}
+	})
+
+	// Alice modifies the metadata. Bob receives it.
+	descr, rules := "gc for testing", "be nice"
+	err = alice.ModifyGCMetadata(gcID, func(md *rpc.RMGroupMetadata) error {
+		md.Description = descr
+		md.Rules = rules
+		return nil
+	})
+	assert.NilErr(t, err)
+	md := assert.ChanWritten(t, bobMDChan)
+	assert.DeepEqual(t, md.Description, descr)
+	assert.DeepEqual(t, md.Rules, rules)
+
+	topic := "testing topic"
+	assert.NilErr(t, alice.SetGCTopic(gcID, topic))
+	md = assert.ChanWritten(t, bobMDChan)
+	assert.DeepEqual(t, md.Topic, topic)
+	assert.DeepEqual(t, md.Description, descr)
+
+	msgID := zkidentity.ShortID{0: 0x01}
+	assert.NilErr(t, alice.PinGCMessage(gcID, msgID, true))
+	md = assert.ChanWritten(t, bobMDChan)
+	assert.DeepEqual(t, md.Pinned, []zkidentity.ShortID{msgID})
+	gotMD, err := bob.GetGCMetadata(gcID)
+	assert.NilErr(t, err)
+	assert.DeepEqual(t, gotMD, md)
+
+	// Bob is not an admin, so he cannot modify the metadata.
+	if err := bob.SetGCTopic(gcID, "bob's topic"); err == nil {
+		t.Fatalf("unexpected success in setting topic by non-admin")
+	}
+
+	// Charlie receives the description in the invite and the full
+	// metadata after joining.
+	charlieMDChan := make(chan rpc.RMGroupMetadata, 1)
+	charlie.modifyHandlers(func() {
+		charlie.onGCMetadata = func(user *client.RemoteUser, md rpc.RMGroupMetadata) {
+			charlieMDChan <- md
+		}
+	})
+	inviteChan := make(chan rpc.RMGroupInvite, 1)
+	charlie.modifyHandlers(func() {
+		charlie.onInvitedToGC = func(user *client.RemoteUser, iid uint64, invite rpc.RMGroupInvite) {
+			inviteChan <- invite
+			go charlie.AcceptGroupChatInvite(iid)
+		}
+	})
+	assert.NilErr(t, alice.InviteToGroupChat(gcID, charlie.PublicID()))
+	invite := assert.ChanWritten(t, inviteChan)
+	assert.DeepEqual(t, invite.Description, descr)
+	md = assert.ChanWritten(t, charlieMDChan)
+	assert.DeepEqual(t, md, gotMD)
+}
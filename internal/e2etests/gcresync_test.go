@@ -0,0 +1,57 @@
+package e2etests
+
+import (
+	"testing"
+	"time"
+
+	"github.com/companyzero/bisonrelay/client/clientdb"
+	"github.com/companyzero/bisonrelay/internal/assert"
+)
+
+// TestGCResync tests that members can request a resync of the GC list from the
+// owner and that admins can force a resync of all members.
+func TestGCResync(t *testing.T) {
+	tcfg := testScaffoldCfg{}
+	ts := newTestScaffold(t, tcfg)
+	alice := ts.newClient("alice")
+	bob := ts.newClient("bob")
+	charlie := ts.newClient("charlie")
+	ts.kxUsers(alice, bob)
+	ts.kxUsers(alice, charlie)
+	ts.kxUsers(bob, charlie)
+
+	gcID, err := alice.NewGroupChat("test gc")
+	assert.NilErr(t, err)
+	for _, c := range []*testClient{bob, charlie} {
+		acceptedChan := c.acceptNextGCInvite(gcID)
+		assert.NilErr(t, alice.InviteToGroupChat(gcID, c.PublicID()))
+		assert.NilErrFromChan(t, acceptedChan)
+		assertClientInGC(t, c, gcID)
+	}
+	assertClientUpToDate(t, alice)
+
+	bobListChan := make(chan clientdb.GCAddressBookEntry, 2)
+	bob.modifyHandlers(func() {
+		bob.onGCListUpdated = func(gc clientdb.GCAddressBookEntry) {
+			bobListChan <- gc
+		}
+	})
+	charlieListChan := make(chan clientdb.GCAddressBookEntry, 2)
+	charlie.modifyHandlers(func() {
+		charlie.onGCListUpdated = func(gc clientdb.GCAddressBookEntry) {
+			charlieListChan <- gc
+		}
+	})
+
+	// Bob requests a resync. Only bob receives the update.
+	assert.NilErr(t, bob.ResyncGC(gcID))
+	gc := assert.ChanWritten(t, bobListChan)
+	assert.DeepEqual(t, len(gc.Members), 3)
+	assert.ChanNotWritten(t, charlieListChan, 500*time.Millisecond)
+
+	// Alice forces a resync of every member.
+	assert.NilErr(t, alice.ResyncGC(gcID))
+	assert.ChanWritten(t, bobListChan)
+	gc = assert.ChanWritten(t, charlieListChan)
+	assert.DeepEqual(t, gc.Members[0], alice.PublicID())
+}
@@ -0,0 +1,168 @@
+package e2etests
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/companyzero/bisonrelay/client"
+	"github.com/companyzero/bisonrelay/client/clientdb"
+	"github.com/companyzero/bisonrelay/internal/assert"
+	"github.com/companyzero/bisonrelay/rpc"
+)
+
+// TestGCSenderKeys tests that GC messages keep flowing once members start
+// sending them through the group RVs and that kicked members stop receiving
+// messages after the sender keys are rotated.
+func TestGCSenderKeys(t *testing.T) {
+	tcfg := testScaffoldCfg{}
+	ts := newTestScaffold(t, tcfg)
+	alice := ts.newClient("alice")
+	bob := ts.newClient("bob")
+	charlie := ts.newClient("charlie")
+	ts.kxUsers(alice, bob)
+	ts.kxUsers(alice, charlie)
+
+	gcID, err := alice.NewGroupChat("test gc")
+	assert.NilErr(t, err)
+	for _, c := range []*testClient{bob, charlie} {
+		acceptedChan := c.acceptNextGCInvite(gcID)
+		assert.NilErr(t, alice.InviteToGroupChat(gcID, c.PublicID()))
+		assert.NilErrFromChan(t, acceptedChan)
+		assertClientInGC(t, c, gcID)
+	}
+	assertClientsKXd(t, bob, charlie)
+
+	clients := []*testClient{alice, bob, charlie}
+	msgChans := make(map[*testClient]chan string, len(clients))
+	for _, c := range clients {
+		c := c
+		msgChan := make(chan string, 10)
+		msgChans[c] = msgChan
+		c.modifyHandlers(func() {
+			c.onGCMsg = func(user *client.RemoteUser, msg rpc.RMGroupMessage, ts time.Time) {
+				msgChan <- msg.Message
+			}
+		})
+	}
+
+	// Send multiple rounds of messages. The first ones are sent through
+	// the individual RVs, while the later ones are sent through the
+	// group RVs, after the sender keys have been exchanged.
+	for i := 0; i < 3; i++ {
+		for _, sender := range clients {
+			msg := fmt.Sprintf("msg %d from %s", i, sender.name)
+			assert.NilErr(t, sender.GCMessage(gcID, msg, rpc.MessageModeNormal, nil))
+			for _, c := range clients {
+				if c == sender {
+					continue
+				}
+				assert.DeepEqual(t, assert.ChanWritten(t, msgChans[c]), msg)
+			}
+		}
+		time.Sleep(250 * time.Millisecond)
+	}
+
+	// Alice kicks charlie.
+	bobPartedChan := bob.nextGCUserPartedIs(gcID, charlie.PublicID(), true)
+	assert.NilErr(t, alice.GCKick(gcID, charlie.PublicID(), "no reason"))
+	assert.NilErrFromChan(t, bobPartedChan)
+
+	// Charlie no longer receives messages from alice and bob.
+	for _, sender := range []*testClient{alice, bob} {
+		for i := 0; i < 2; i++ {
+			msg := fmt.Sprintf("msg %d from %s after kick", i, sender.name)
+			assert.NilErr(t, sender.GCMessage(gcID, msg, rpc.MessageModeNormal, nil))
+			other := alice
+			if sender == alice {
+				other = bob
+			}
+			assert.DeepEqual(t, assert.ChanWritten(t, msgChans[other]), msg)
+			assert.ChanNotWritten(t, msgChans[charlie], 250*time.Millisecond)
+		}
+	}
+}
+
+// TestGCSenderKeysLostPush tests that GC messages keep flowing through the
+// group RVs after a message pushed to one of them is lost.
+func TestGCSenderKeysLostPush(t *testing.T) {
+	tcfg := testScaffoldCfg{}
+	ts := newTestScaffold(t, tcfg)
+	alice := ts.newClient("alice")
+	bob := ts.newClient("bob")
+	ts.kxUsers(alice, bob)
+
+	gcID, err := alice.NewGroupChat("test gc")
+	assert.NilErr(t, err)
+	acceptedChan := bob.acceptNextGCInvite(gcID)
+	assert.NilErr(t, alice.InviteToGroupChat(gcID, bob.PublicID()))
+	assert.NilErrFromChan(t, acceptedChan)
+	assertClientInGC(t, bob, gcID)
+
+	bobMsgChan := make(chan string, 10)
+	bob.modifyHandlers(func() {
+		bob.onGCMsg = func(user *client.RemoteUser, msg rpc.RMGroupMessage, ts time.Time) {
+			bobMsgChan <- msg.Message
+		}
+	})
+	aliceMsgChan := make(chan string, 10)
+	alice.modifyHandlers(func() {
+		alice.onGCMsg = func(user *client.RemoteUser, msg rpc.RMGroupMessage, ts time.Time) {
+			aliceMsgChan <- msg.Message
+		}
+	})
+
+	aliceSenderKey := func() *clientdb.GCSenderKey {
+		t.Helper()
+		var sk *clientdb.GCSenderKey
+		err := alice.db.View(ts.ctx, func(tx clientdb.ReadTx) error {
+			var err error
+			sk, err = alice.db.GetGCSenderKey(tx, gcID, alice.PublicID())
+			return err
+		})
+		assert.NilErr(t, err)
+		return sk
+	}
+	sendMsgs := func(prefix string, n int) {
+		t.Helper()
+		for i := 0; i < n; i++ {
+			msg := fmt.Sprintf("%s %d", prefix, i)
+			assert.NilErr(t, alice.GCMessage(gcID, msg, rpc.MessageModeNormal, nil))
+			assert.DeepEqual(t, assert.ChanWritten(t, bobMsgChan), msg)
+		}
+	}
+
+	// Exchange messages until the sender keys are shared and alice sends
+	// through the group RVs.
+	for i := 0; i < 2; i++ {
+		sendMsgs("msg", 1)
+		msg := fmt.Sprintf("reply %d", i)
+		assert.NilErr(t, bob.GCMessage(gcID, msg, rpc.MessageModeNormal, nil))
+		assert.DeepEqual(t, assert.ChanWritten(t, aliceMsgChan), msg)
+		time.Sleep(250 * time.Millisecond)
+	}
+	sendMsgs("group rv msg", 2)
+	time.Sleep(250 * time.Millisecond)
+	sk := aliceSenderKey()
+	if sk.Counter == 0 {
+		t.Fatalf("alice did not send through the group RVs")
+	}
+	counter := sk.Counter
+
+	// Simulate a message that was pushed to the group RV but never
+	// reached bob by skipping the next counter.
+	err = alice.db.Update(ts.ctx, func(tx clientdb.ReadWriteTx) error {
+		sk.Counter += 1
+		return alice.db.SaveGCSenderKey(tx, sk)
+	})
+	assert.NilErr(t, err)
+
+	// Bob still receives the next messages, including more messages than
+	// the window of group RVs he initially subscribed to.
+	sendMsgs("after lost msg", 6)
+	time.Sleep(250 * time.Millisecond)
+	if sk = aliceSenderKey(); sk.Counter != counter+7 {
+		t.Fatalf("unexpected counter of sender key: got %d, want %d",
+			sk.Counter, counter+7)
+	}
+}
@@ -0,0 +1,62 @@
+package e2etests
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/companyzero/bisonrelay/client"
+	"github.com/companyzero/bisonrelay/internal/assert"
+	"github.com/companyzero/bisonrelay/rpc"
+)
+
+// TestPMHistory tests that the PM history can be paged back through.
+func TestPMHistory(t *testing.T) {
+	tcfg := testScaffoldCfg{}
+	ts := newTestScaffold(t, tcfg)
+	alice := ts.newClient("alice")
+	bob := ts.newClient("bob")
+	ts.kxUsers(alice, bob)
+
+	bobPMChan := make(chan string, 5)
+	bob.modifyHandlers(func() {
+		bob.onPM = func(user *client.RemoteUser, msg rpc.RMPrivateMessage, ts time.Time) {
+			bobPMChan <- msg.Message
+		}
+	})
+
+	const nbMsgs = 5
+	for i := 0; i < nbMsgs; i++ {
+		msg := fmt.Sprintf("msg %d", i)
+		assert.NilErr(t, alice.PM(bob.PublicID(), msg))
+		assert.DeepEqual(t, assert.ChanWritten(t, bobPMChan), msg)
+	}
+
+	// The first entry is the internal KX completed message.
+	all, err := bob.ReadPMHistory(alice.PublicID(), 0, 0)
+	assert.NilErr(t, err)
+	assert.DeepEqual(t, len(all), nbMsgs+1)
+	assert.DeepEqual(t, all[0].Internal, true)
+	for i, e := range all[1:] {
+		assert.DeepEqual(t, e.Message, fmt.Sprintf("msg %d", i))
+		assert.DeepEqual(t, e.FromUID, alice.PublicID())
+	}
+
+	// Page back through the history.
+	page, err := bob.ReadPMHistory(alice.PublicID(), 0, 2)
+	assert.NilErr(t, err)
+	assert.DeepEqual(t, page, all[len(all)-2:])
+	page, err = bob.ReadPMHistory(alice.PublicID(), page[0].ID, 2)
+	assert.NilErr(t, err)
+	assert.DeepEqual(t, page, all[len(all)-4:len(all)-2])
+	page, err = bob.ReadPMHistory(alice.PublicID(), page[0].ID, 5)
+	assert.NilErr(t, err)
+	assert.DeepEqual(t, page, all[:len(all)-4])
+
+	// Alice's history records her own messages.
+	page, err = alice.ReadPMHistory(bob.PublicID(), 0, 1)
+	assert.NilErr(t, err)
+	assert.DeepEqual(t, len(page), 1)
+	assert.DeepEqual(t, page[0].FromUID, alice.PublicID())
+	assert.DeepEqual(t, page[0].Message, fmt.Sprintf("msg %d", nbMsgs-1))
+}
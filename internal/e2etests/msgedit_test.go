@@ -0,0 +1,155 @@
+package e2etests
+
+import (
+	"testing"
+	"time"
+
+	"github.com/companyzero/bisonrelay/client"
+	"github.com/companyzero/bisonrelay/client/clientdb"
+	"github.com/companyzero/bisonrelay/internal/assert"
+	"github.com/companyzero/bisonrelay/rpc"
+)
+
+// assertLastHistoryEntry asserts that the last entry of the history has the
+// given message id and contents.
+func assertLastHistoryEntry(t testing.TB, history []clientdb.HistoryEntry,
+	id client.MsgID, msg string, retracted bool) {
+
+	t.Helper()
+	if len(history) == 0 {
+		t.Fatalf("empty history")
+	}
+	e := history[len(history)-1]
+	assert.DeepEqual(t, e.MsgID, id)
+	assert.DeepEqual(t, e.Message, msg)
+	assert.BoolIs(t, e.Retracted, retracted)
+}
+
+// TestEditRetractPM tests that PMs can be edited and retracted by their
+// sender.
+func TestEditRetractPM(t *testing.T) {
+	tcfg := testScaffoldCfg{}
+	ts := newTestScaffold(t, tcfg)
+	alice := ts.newClient("alice")
+	bob := ts.newClient("bob")
+	ts.kxUsers(alice, bob)
+
+	bobPMChan := make(chan rpc.RMPrivateMessage, 1)
+	bobEditChan := make(chan rpc.RMMessageEdit, 1)
+	bobRetractChan := make(chan rpc.RMMessageRetract, 1)
+	bob.modifyHandlers(func() {
+		bob.onPM = func(user *client.RemoteUser, msg rpc.RMPrivateMessage, ts time.Time) {
+			bobPMChan <- msg
+		}
+		bob.onMsgEdit = func(user *client.RemoteUser, edit rpc.RMMessageEdit) {
+			bobEditChan <- edit
+		}
+		bob.onMsgRetract = func(user *client.RemoteUser, retract rpc.RMMessageRetract) {
+			bobRetractChan <- retract
+		}
+	})
+
+	id, err := alice.PMWithID(bob.PublicID(), "my pasword is hunter2")
+	assert.NilErr(t, err)
+	assert.ChanWritten(t, bobPMChan)
+
+	// Alice fixes the typo.
+	assert.NilErr(t, alice.EditPM(bob.PublicID(), id, "my password is hunter2"))
+	edit := assert.ChanWritten(t, bobEditChan)
+	assert.DeepEqual(t, edit.ID, id)
+	assert.DeepEqual(t, edit.Message, "my password is hunter2")
+	history, err := bob.ReadPMHistory(alice.PublicID(), 0, 0)
+	assert.NilErr(t, err)
+	assertLastHistoryEntry(t, history, id, "my password is hunter2", false)
+	res, err := bob.Search("pasword", clientdb.SearchFilters{})
+	assert.NilErr(t, err)
+	assert.DeepEqual(t, len(res), 0)
+	res, err = bob.Search("password", clientdb.SearchFilters{})
+	assert.NilErr(t, err)
+	assert.DeepEqual(t, len(res), 1)
+
+	// Alice retracts the message. Its contents are removed from the
+	// history and search index of both users.
+	assert.NilErr(t, alice.RetractPM(bob.PublicID(), id))
+	retract := assert.ChanWritten(t, bobRetractChan)
+	assert.DeepEqual(t, retract.ID, id)
+	for _, c := range []*testClient{alice, bob} {
+		other := alice
+		if c == alice {
+			other = bob
+		}
+		history, err := c.ReadPMHistory(other.PublicID(), 0, 0)
+		assert.NilErr(t, err)
+		assertLastHistoryEntry(t, history, id, "", true)
+		res, err := c.Search("hunter2", clientdb.SearchFilters{})
+		assert.NilErr(t, err)
+		assert.DeepEqual(t, len(res), 0)
+	}
+
+	// Bob cannot edit a message sent by alice.
+	if err := bob.EditPM(alice.PublicID(), id, "forged"); err == nil {
+		t.Fatalf("unexpected nil error editing message sent by other user")
+	}
+}
+
+// TestEditRetractGCMessage tests that GC messages can be edited and retracted
+// by their sender.
+func TestEditRetractGCMessage(t *testing.T) {
+	tcfg := testScaffoldCfg{}
+	ts := newTestScaffold(t, tcfg)
+	alice := ts.newClient("alice")
+	bob := ts.newClient("bob")
+	ts.kxUsers(alice, bob)
+
+	gcID, err := alice.NewGroupChat("test gc")
+	assert.NilErr(t, err)
+	bobAcceptedChan := bob.acceptNextGCInvite(gcID)
+	assert.NilErr(t, alice.InviteToGroupChat(gcID, bob.PublicID()))
+	assert.NilErrFromChan(t, bobAcceptedChan)
+	assertClientInGC(t, bob, gcID)
+
+	aliceGCMsgChan := make(chan rpc.RMGroupMessage, 1)
+	aliceEditChan := make(chan rpc.RMMessageEdit, 1)
+	aliceRetractChan := make(chan rpc.RMMessageRetract, 1)
+	alice.modifyHandlers(func() {
+		alice.onGCMsg = func(user *client.RemoteUser, msg rpc.RMGroupMessage, ts time.Time) {
+			aliceGCMsgChan <- msg
+		}
+		alice.onMsgEdit = func(user *client.RemoteUser, edit rpc.RMMessageEdit) {
+			aliceEditChan <- edit
+		}
+		alice.onMsgRetract = func(user *client.RemoteUser, retract rpc.RMMessageRetract) {
+			aliceRetractChan <- retract
+		}
+	})
+
+	id, err := bob.GCMessageWithID(gcID, "first version", rpc.MessageModeNormal, nil)
+	assert.NilErr(t, err)
+	gcm := assert.ChanWritten(t, aliceGCMsgChan)
+	assert.DeepEqual(t, gcm.MsgID, id)
+
+	// Bob edits the message.
+	assert.NilErr(t, bob.EditGCMessage(gcID, id, "second version"))
+	edit := assert.ChanWritten(t, aliceEditChan)
+	assert.DeepEqual(t, edit.GC, gcID)
+	assert.DeepEqual(t, edit.ID, id)
+	history, err := alice.ReadGCHistory(gcID, 0, 0)
+	assert.NilErr(t, err)
+	assertLastHistoryEntry(t, history, id, "second version", false)
+
+	// Alice cannot edit a message sent by bob.
+	if err := alice.EditGCMessage(gcID, id, "forged"); err == nil {
+		t.Fatalf("unexpected nil error editing message sent by other user")
+	}
+
+	// Bob retracts the message.
+	assert.NilErr(t, bob.RetractGCMessage(gcID, id))
+	retract := assert.ChanWritten(t, aliceRetractChan)
+	assert.DeepEqual(t, retract.GC, gcID)
+	assert.DeepEqual(t, retract.ID, id)
+	for _, c := range []*testClient{alice, bob} {
+		history, err := c.ReadGCHistory(gcID, 0, 0)
+		assert.NilErr(t, err)
+		assertLastHistoryEntry(t, history, id, "", true)
+	}
+}
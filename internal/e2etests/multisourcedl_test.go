@@ -0,0 +1,170 @@
+package e2etests
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/companyzero/bisonrelay/client"
+	"github.com/companyzero/bisonrelay/client/clientdb"
+	"github.com/companyzero/bisonrelay/internal/assert"
+	"github.com/companyzero/bisonrelay/rpc"
+)
+
+// writeRandomFile writes a file with random data of the given size and returns
+// its path.
+func writeRandomFile(t testing.TB, size int) (string, []byte) {
+	t.Helper()
+	data := make([]byte, size)
+	_, err := rand.Read(data)
+	assert.NilErr(t, err)
+	fname := filepath.Join(t.TempDir(), "shared.bin")
+	assert.NilErr(t, os.WriteFile(fname, data, 0o600))
+	return fname, data
+}
+
+// readFileDownload reads the download of the given file from the client's DB.
+func readFileDownload(t testing.TB, tc *testClient, uid clientdb.UserID,
+	fid clientdb.FileID) clientdb.FileDownload {
+
+	t.Helper()
+	var fd clientdb.FileDownload
+	err := tc.db.View(context.Background(), func(tx clientdb.ReadTx) error {
+		var err error
+		fd, err = tc.db.ReadFileDownload(tx, uid, fid)
+		return err
+	})
+	assert.NilErr(t, err)
+	return fd
+}
+
+// createMultiSourceGC creates a GC owned by admin with the given members, so
+// that they are considered plausible sources of the files shared by each
+// other.
+func createMultiSourceGC(t testing.TB, admin *testClient, members ...*testClient) {
+	t.Helper()
+	gcID, err := admin.NewGroupChat("sources gc")
+	assert.NilErr(t, err)
+	for _, c := range members {
+		acceptedChan := c.acceptNextGCInvite(gcID)
+		assert.NilErr(t, admin.InviteToGroupChat(gcID, c.PublicID()))
+		assert.NilErrFromChan(t, acceptedChan)
+		assertClientInGC(t, c, gcID)
+	}
+}
+
+// TestMultiSourceDownload tests that the chunks of a file are downloaded from
+// the users that share a file with the same content and a GC with the
+// original user.
+func TestMultiSourceDownload(t *testing.T) {
+	tcfg := testScaffoldCfg{multiSourceDownloads: true}
+	ts := newTestScaffold(t, tcfg)
+	alice := ts.newClient("alice")
+	bob := ts.newClient("bob")
+	charlie := ts.newClient("charlie")
+	dave := ts.newClient("dave")
+	ts.kxUsers(alice, bob)
+	ts.kxUsers(bob, charlie)
+	ts.kxUsers(bob, dave)
+	createMultiSourceGC(t, bob, alice, charlie)
+
+	bobDownloadChan := make(chan string, 1)
+	bob.modifyHandlers(func() {
+		bob.onFileDownload = func(user *client.RemoteUser, fm rpc.FileMetadata, diskPath string) {
+			bobDownloadChan <- diskPath
+		}
+	})
+
+	// Alice, Charlie and Dave share the same file, but Dave is not a
+	// member of a GC with Alice.
+	fname, data := writeRandomFile(t, 400)
+	sf, _, err := alice.ShareFile(fname, nil, 0, false, "")
+	assert.NilErr(t, err)
+	_, _, err = charlie.ShareFile(fname, nil, 0, false, "")
+	assert.NilErr(t, err)
+	_, _, err = dave.ShareFile(fname, nil, 0, false, "")
+	assert.NilErr(t, err)
+
+	// Bob downloads the file from Alice.
+	assert.NilErr(t, bob.GetUserContent(alice.PublicID(), sf.FID))
+	diskPath := assert.ChanWritten(t, bobDownloadChan)
+	got, err := os.ReadFile(diskPath)
+	assert.NilErr(t, err)
+	if !bytes.Equal(got, data) {
+		t.Fatalf("downloaded file does not match shared file")
+	}
+	assert.DeepEqual(t, filepath.Base(filepath.Dir(diskPath)), "alice")
+
+	// Charlie (but not Dave) was a source of the download and some of the
+	// chunks were requested from Charlie.
+	fd := readFileDownload(t, bob, alice.PublicID(), sf.FID)
+	assert.DeepEqual(t, len(fd.Sources), 1)
+	assert.DeepEqual(t, fd.Sources[0].UID, charlie.PublicID())
+	var nbCharlieChunks int
+	for _, uid := range fd.ChunkSources {
+		if uid == charlie.PublicID() {
+			nbCharlieChunks++
+		}
+	}
+	if nbCharlieChunks == 0 {
+		t.Fatalf("no chunks were requested from charlie")
+	}
+}
+
+// TestMultiSourceDownloadFallback tests that the chunks requested from a
+// source that goes offline are requested from the other sources.
+func TestMultiSourceDownloadFallback(t *testing.T) {
+	tcfg := testScaffoldCfg{
+		multiSourceDownloads:    true,
+		multiSourceChunkTimeout: time.Second,
+	}
+	ts := newTestScaffold(t, tcfg)
+	alice := ts.newClient("alice")
+	bob := ts.newClient("bob")
+	charlie := ts.newClient("charlie")
+	ts.kxUsers(alice, bob)
+	ts.kxUsers(bob, charlie)
+	createMultiSourceGC(t, bob, alice, charlie)
+
+	bobDownloadChan := make(chan string, 1)
+	bob.modifyHandlers(func() {
+		bob.onFileDownload = func(user *client.RemoteUser, fm rpc.FileMetadata, diskPath string) {
+			bobDownloadChan <- diskPath
+		}
+	})
+
+	fname, data := writeRandomFile(t, 400)
+	sf, _, err := alice.ShareFile(fname, nil, 0, false, "")
+	assert.NilErr(t, err)
+	_, _, err = charlie.ShareFile(fname, nil, 0, false, "")
+	assert.NilErr(t, err)
+
+	// Bob starts the download. As soon as Charlie is added as a source,
+	// Charlie goes offline.
+	assert.NilErr(t, bob.GetUserContent(alice.PublicID(), sf.FID))
+	for i := 0; ; i++ {
+		fds, err := bob.ListDownloads()
+		assert.NilErr(t, err)
+		if len(fds) == 1 && len(fds[0].Sources) == 1 {
+			break
+		}
+		if i == 300 {
+			t.Fatalf("timeout waiting for charlie to be added as source")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	charlie.cancel()
+	assert.ChanWritten(t, charlie.runC)
+
+	// The download completes with the chunks sent by Alice.
+	diskPath := assert.ChanWritten(t, bobDownloadChan)
+	got, err := os.ReadFile(diskPath)
+	assert.NilErr(t, err)
+	if !bytes.Equal(got, data) {
+		t.Fatalf("downloaded file does not match shared file")
+	}
+}
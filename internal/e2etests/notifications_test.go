@@ -0,0 +1,128 @@
+package e2etests
+
+import (
+	"testing"
+	"time"
+
+	"github.com/companyzero/bisonrelay/client"
+	"github.com/companyzero/bisonrelay/client/clientdb"
+	"github.com/companyzero/bisonrelay/client/clientintf"
+	"github.com/companyzero/bisonrelay/internal/assert"
+	"github.com/companyzero/bisonrelay/rpc"
+)
+
+// TestGCNotifyModes tests that the notifications generated by GC messages
+// respect the notification policy of the GC and the mentions of the messages.
+func TestGCNotifyModes(t *testing.T) {
+	tcfg := testScaffoldCfg{}
+	ts := newTestScaffold(t, tcfg)
+	alice := ts.newClient("alice")
+	bob := ts.newClient("bob")
+	charlie := ts.newClient("charlie")
+	ts.kxUsers(alice, bob)
+	ts.kxUsers(alice, charlie)
+
+	gcID, err := alice.NewGroupChat("test gc")
+	assert.NilErr(t, err)
+	for _, c := range []*testClient{bob, charlie} {
+		acceptedChan := c.acceptNextGCInvite(gcID)
+		assert.NilErr(t, alice.InviteToGroupChat(gcID, c.PublicID()))
+		assert.NilErrFromChan(t, acceptedChan)
+		assertClientInGC(t, c, gcID)
+	}
+
+	bobNotifyChan := make(chan client.Notification, 5)
+	bob.modifyHandlers(func() {
+		bob.onNotification = func(n client.Notification) {
+			bobNotifyChan <- n
+		}
+	})
+	charlieNotifyChan := make(chan client.Notification, 5)
+	charlie.modifyHandlers(func() {
+		charlie.onNotification = func(n client.Notification) {
+			charlieNotifyChan <- n
+		}
+	})
+
+	// The default mode notifies about every message.
+	mode, err := bob.GetGCNotifyMode(gcID)
+	assert.NilErr(t, err)
+	assert.DeepEqual(t, mode, clientdb.GCNotifyAll)
+	id, err := alice.GCMessageWithID(gcID, "hello all", rpc.MessageModeNormal, nil)
+	assert.NilErr(t, err)
+	n := assert.ChanWritten(t, bobNotifyChan)
+	assert.DeepEqual(t, n.Type, client.NotificationTypeGCMessage)
+	assert.DeepEqual(t, n.GC, gcID)
+	assert.DeepEqual(t, n.MsgID, id)
+	assert.DeepEqual(t, n.From, alice.PublicID())
+	assert.ChanWritten(t, charlieNotifyChan)
+
+	// Bob only wants to be notified of mentions. The first message is not
+	// notified, while the second one (that mentions bob) is.
+	assert.NilErr(t, bob.SetGCNotifyMode(gcID, clientdb.GCNotifyMentions))
+	assert.NilErr(t, alice.GCMessage(gcID, "not for bob", rpc.MessageModeNormal, nil))
+	mentions := []clientintf.UserID{bob.PublicID()}
+	id, err = alice.GCMessageWithMentions(gcID, clientintf.ID{}, "@bob hi",
+		rpc.MessageModeNormal, mentions, nil)
+	assert.NilErr(t, err)
+	n = assert.ChanWritten(t, bobNotifyChan)
+	assert.DeepEqual(t, n.Type, client.NotificationTypeGCMention)
+	assert.DeepEqual(t, n.MsgID, id)
+	assert.DeepEqual(t, n.Message, "@bob hi")
+	n = assert.ChanWritten(t, charlieNotifyChan)
+	assert.DeepEqual(t, n.Message, "not for bob")
+	n = assert.ChanWritten(t, charlieNotifyChan)
+	assert.DeepEqual(t, n.Type, client.NotificationTypeGCMessage)
+	history, err := bob.ReadGCHistory(gcID, 0, 0)
+	assert.NilErr(t, err)
+	assert.DeepEqual(t, history[len(history)-1].Mentions, mentions)
+
+	// Bob mutes the GC. Not even mentions are notified, but the message
+	// is still received.
+	bobGCMsgChan := make(chan rpc.RMGroupMessage, 1)
+	bob.modifyHandlers(func() {
+		bob.onGCMsg = func(user *client.RemoteUser, msg rpc.RMGroupMessage, ts time.Time) {
+			bobGCMsgChan <- msg
+		}
+	})
+	assert.NilErr(t, bob.SetGCNotifyMode(gcID, clientdb.GCNotifyMuted))
+	_, err = alice.GCMessageWithMentions(gcID, clientintf.ID{}, "@bob again",
+		rpc.MessageModeNormal, mentions, nil)
+	assert.NilErr(t, err)
+	assert.ChanWritten(t, bobGCMsgChan)
+	assert.ChanNotWritten(t, bobNotifyChan, 500*time.Millisecond)
+
+	// Mentioning a non-member fails.
+	dave := ts.newClient("dave")
+	ts.kxUsers(alice, dave)
+	mentions = []clientintf.UserID{dave.PublicID()}
+	_, err = alice.GCMessageWithMentions(gcID, clientintf.ID{}, "@dave",
+		rpc.MessageModeNormal, mentions, nil)
+	if err == nil {
+		t.Fatalf("unexpected nil error when mentioning non-member")
+	}
+}
+
+// TestPMNotification tests that received PMs generate notifications.
+func TestPMNotification(t *testing.T) {
+	tcfg := testScaffoldCfg{}
+	ts := newTestScaffold(t, tcfg)
+	alice := ts.newClient("alice")
+	bob := ts.newClient("bob")
+	ts.kxUsers(alice, bob)
+
+	bobNotifyChan := make(chan client.Notification, 1)
+	bob.modifyHandlers(func() {
+		bob.onNotification = func(n client.Notification) {
+			bobNotifyChan <- n
+		}
+	})
+
+	id, err := alice.PMWithID(bob.PublicID(), "ping")
+	assert.NilErr(t, err)
+	n := assert.ChanWritten(t, bobNotifyChan)
+	assert.DeepEqual(t, n.Type, client.NotificationTypePM)
+	assert.DeepEqual(t, n.From, alice.PublicID())
+	assert.DeepEqual(t, n.MsgID, id)
+	assert.DeepEqual(t, n.Message, "ping")
+}
@@ -0,0 +1,186 @@
+package e2etests
+
+import (
+	"testing"
+	"time"
+
+	"github.com/companyzero/bisonrelay/client"
+	"github.com/companyzero/bisonrelay/client/clientdb"
+	"github.com/companyzero/bisonrelay/client/clientintf"
+	"github.com/companyzero/bisonrelay/internal/assert"
+	"github.com/companyzero/bisonrelay/rpc"
+)
+
+// TestPMReactions tests that users can react to PMs.
+func TestPMReactions(t *testing.T) {
+	tcfg := testScaffoldCfg{}
+	ts := newTestScaffold(t, tcfg)
+	alice := ts.newClient("alice")
+	bob := ts.newClient("bob")
+	ts.kxUsers(alice, bob)
+
+	bobPMChan := make(chan rpc.RMPrivateMessage, 1)
+	bob.modifyHandlers(func() {
+		bob.onPM = func(user *client.RemoteUser, msg rpc.RMPrivateMessage, ts time.Time) {
+			bobPMChan <- msg
+		}
+	})
+	aliceReactionChan := make(chan clientdb.Reactions, 1)
+	alice.modifyHandlers(func() {
+		alice.onMsgReaction = func(user *client.RemoteUser, reaction rpc.RMMessageReaction, reactions clientdb.Reactions) {
+			aliceReactionChan <- reactions
+		}
+	})
+
+	id, err := alice.PMWithID(bob.PublicID(), "lunch?")
+	assert.NilErr(t, err)
+	assert.ChanWritten(t, bobPMChan)
+
+	// Bob reacts to alice's message.
+	assert.NilErr(t, bob.ReactToPM(alice.PublicID(), id, ":+1:", false))
+	reactions := assert.ChanWritten(t, aliceReactionChan)
+	assert.BoolIs(t, reactions.HasReacted(bob.PublicID(), ":+1:"), true)
+	for _, c := range []*testClient{alice, bob} {
+		other := alice
+		if c == alice {
+			other = bob
+		}
+		history, err := c.ReadPMHistory(other.PublicID(), 0, 0)
+		assert.NilErr(t, err)
+		e := history[len(history)-1]
+		assert.DeepEqual(t, e.MsgID, id)
+		assert.BoolIs(t, e.Reactions.HasReacted(bob.PublicID(), ":+1:"), true)
+	}
+
+	// Reacting twice with the same reaction fails.
+	if err := bob.ReactToPM(alice.PublicID(), id, ":+1:", false); err == nil {
+		t.Fatalf("unexpected nil error on duplicate reaction")
+	}
+
+	// Invalid reactions are rejected.
+	if err := bob.ReactToPM(alice.PublicID(), id, "not a reaction", false); err == nil {
+		t.Fatalf("unexpected nil error on invalid reaction")
+	}
+
+	// Bob removes the reaction.
+	assert.NilErr(t, bob.ReactToPM(alice.PublicID(), id, ":+1:", true))
+	reactions = assert.ChanWritten(t, aliceReactionChan)
+	assert.BoolIs(t, reactions.HasReacted(bob.PublicID(), ":+1:"), false)
+}
+
+// TestGCReactions tests that GC members can react to GC messages.
+func TestGCReactions(t *testing.T) {
+	tcfg := testScaffoldCfg{}
+	ts := newTestScaffold(t, tcfg)
+	alice := ts.newClient("alice")
+	bob := ts.newClient("bob")
+	charlie := ts.newClient("charlie")
+	ts.kxUsers(alice, bob)
+	ts.kxUsers(alice, charlie)
+
+	gcID, err := alice.NewGroupChat("test gc")
+	assert.NilErr(t, err)
+	for _, c := range []*testClient{bob, charlie} {
+		acceptedChan := c.acceptNextGCInvite(gcID)
+		assert.NilErr(t, alice.InviteToGroupChat(gcID, c.PublicID()))
+		assert.NilErrFromChan(t, acceptedChan)
+		assertClientInGC(t, c, gcID)
+	}
+	assertClientsKXd(t, bob, charlie)
+
+	bobGCMsgChan := make(chan rpc.RMGroupMessage, 1)
+	bob.modifyHandlers(func() {
+		bob.onGCMsg = func(user *client.RemoteUser, msg rpc.RMGroupMessage, ts time.Time) {
+			bobGCMsgChan <- msg
+		}
+	})
+	aliceReactionChan := make(chan rpc.RMMessageReaction, 1)
+	alice.modifyHandlers(func() {
+		alice.onMsgReaction = func(user *client.RemoteUser, reaction rpc.RMMessageReaction, reactions clientdb.Reactions) {
+			aliceReactionChan <- reaction
+		}
+	})
+	charlieReactionChan := make(chan clientdb.Reactions, 1)
+	charlie.modifyHandlers(func() {
+		charlie.onMsgReaction = func(user *client.RemoteUser, reaction rpc.RMMessageReaction, reactions clientdb.Reactions) {
+			charlieReactionChan <- reactions
+		}
+	})
+
+	id, err := charlie.GCMessageWithID(gcID, "meeting at noon", rpc.MessageModeNormal, nil)
+	assert.NilErr(t, err)
+	assert.ChanWritten(t, bobGCMsgChan)
+
+	// Bob reacts to charlie's message. Both alice and charlie receive the
+	// reaction.
+	assert.NilErr(t, bob.ReactToGCMessage(gcID, id, "✅", false))
+	reaction := assert.ChanWritten(t, aliceReactionChan)
+	assert.DeepEqual(t, reaction.GC, gcID)
+	assert.DeepEqual(t, reaction.ID, id)
+	assert.DeepEqual(t, reaction.Reaction, "✅")
+	reactions := assert.ChanWritten(t, charlieReactionChan)
+	assert.BoolIs(t, reactions.HasReacted(bob.PublicID(), "✅"), true)
+
+	history, err := charlie.ReadGCHistory(gcID, 0, 0)
+	assert.NilErr(t, err)
+	e := history[len(history)-1]
+	assert.DeepEqual(t, e.MsgID, id)
+	assert.DeepEqual(t, len(e.Reactions["✅"]), 1)
+}
+
+// TestPostReactions tests that users can react to posts.
+func TestPostReactions(t *testing.T) {
+	tcfg := testScaffoldCfg{}
+	ts := newTestScaffold(t, tcfg)
+	alice := ts.newClient("alice")
+	bob := ts.newClient("bob")
+	ts.kxUsers(alice, bob)
+
+	bobPostChan := make(chan clientdb.PostSummary, 1)
+	bobStatusChan := make(chan rpc.PostMetadataStatus, 1)
+	bob.modifyHandlers(func() {
+		bob.onPostRcvd = func(user *client.RemoteUser, summ clientdb.PostSummary, post rpc.PostMetadata) {
+			bobPostChan <- summ
+		}
+		bob.onPostStatus = func(pid clientintf.PostID, statusFrom clientintf.UserID, status rpc.PostMetadataStatus) {
+			bobStatusChan <- status
+		}
+	})
+	aliceStatusChan := make(chan rpc.PostMetadataStatus, 1)
+	alice.modifyHandlers(func() {
+		alice.onPostStatus = func(pid clientintf.PostID, statusFrom clientintf.UserID, status rpc.PostMetadataStatus) {
+			if statusFrom == bob.PublicID() {
+				aliceStatusChan <- status
+			}
+		}
+	})
+
+	assert.NilErr(t, bob.SubscribeToPosts(alice.PublicID()))
+	post, err := alice.CreatePost("new post", "")
+	assert.NilErr(t, err)
+	summ := assert.ChanWritten(t, bobPostChan)
+	assert.DeepEqual(t, summ.ID, post.ID)
+
+	// Bob reacts to the post.
+	assert.NilErr(t, bob.ReactToPost(alice.PublicID(), post.ID, "🎉", false))
+	status := assert.ChanWritten(t, aliceStatusChan)
+	assert.DeepEqual(t, status.Attributes[rpc.RMPSReaction],
+		rpc.PostReactionValue("🎉", false))
+	reactions, err := alice.ListPostReactions(alice.PublicID(), post.ID)
+	assert.NilErr(t, err)
+	assert.BoolIs(t, reactions.HasReacted(bob.PublicID(), "🎉"), true)
+
+	// Once alice relays the reaction back to bob, he cannot add the same
+	// reaction twice.
+	assert.ChanWritten(t, bobStatusChan)
+	err = bob.ReactToPost(alice.PublicID(), post.ID, "🎉", false)
+	assert.ErrorIs(t, err, clientdb.ErrDuplicateReaction)
+
+	// Bob removes the reaction.
+	assert.NilErr(t, bob.ReactToPost(alice.PublicID(), post.ID, "🎉", true))
+	assert.ChanWritten(t, aliceStatusChan)
+	assert.ChanWritten(t, bobStatusChan)
+	reactions, err = alice.ListPostReactions(alice.PublicID(), post.ID)
+	assert.NilErr(t, err)
+	assert.BoolIs(t, reactions.HasReacted(bob.PublicID(), "🎉"), false)
+}
@@ -0,0 +1,58 @@
+package e2etests
+
+import (
+	"testing"
+	"time"
+
+	"github.com/companyzero/bisonrelay/client"
+	"github.com/companyzero/bisonrelay/internal/assert"
+	"github.com/companyzero/bisonrelay/rpc"
+)
+
+// TestPMReceipts tests that delivery and read receipts are sent for PMs and
+// that users can opt out of sending them.
+func TestPMReceipts(t *testing.T) {
+	tcfg := testScaffoldCfg{}
+	ts := newTestScaffold(t, tcfg)
+	alice := ts.newClient("alice")
+	bob := ts.newClient("bob")
+	ts.kxUsers(alice, bob)
+
+	receiptChan := make(chan rpc.RMReceipt, 2)
+	alice.modifyHandlers(func() {
+		alice.onPMReceipt = func(user *client.RemoteUser, receipt rpc.RMReceipt) {
+			receiptChan <- receipt
+		}
+	})
+	bobPMChan := make(chan rpc.RMPrivateMessage, 2)
+	bob.modifyHandlers(func() {
+		bob.onPM = func(user *client.RemoteUser, msg rpc.RMPrivateMessage, ts time.Time) {
+			bobPMChan <- msg
+		}
+	})
+
+	// Bob sends a delivery receipt once he receives the PM.
+	id, err := alice.PMWithID(bob.PublicID(), "hello bob")
+	assert.NilErr(t, err)
+	pm := assert.ChanWritten(t, bobPMChan)
+	assert.DeepEqual(t, pm.ID, id)
+	receipt := assert.ChanWritten(t, receiptChan)
+	assert.DeepEqual(t, receipt.ID, id)
+	assert.DeepEqual(t, receipt.Status, rpc.ReceiptStatusDelivered)
+
+	// Bob sends a read receipt once the PM is displayed.
+	assert.NilErr(t, bob.MarkPMRead(alice.PublicID(), pm.ID))
+	receipt = assert.ChanWritten(t, receiptChan)
+	assert.DeepEqual(t, receipt.ID, id)
+	assert.DeepEqual(t, receipt.Status, rpc.ReceiptStatusRead)
+
+	// After bob opts out, no more receipts are sent to alice.
+	assert.NilErr(t, bob.SetPMReceipts(alice.PublicID(), false))
+	send, err := bob.PMReceipts(alice.PublicID())
+	assert.NilErr(t, err)
+	assert.BoolIs(t, send, false)
+	assert.NilErr(t, alice.PM(bob.PublicID(), "hello again"))
+	pm = assert.ChanWritten(t, bobPMChan)
+	assert.NilErr(t, bob.MarkPMRead(alice.PublicID(), pm.ID))
+	assert.ChanNotWritten(t, receiptChan, 500*time.Millisecond)
+}
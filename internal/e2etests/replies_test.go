@@ -0,0 +1,76 @@
+package e2etests
+
+import (
+	"testing"
+	"time"
+
+	"github.com/companyzero/bisonrelay/client"
+	"github.com/companyzero/bisonrelay/internal/assert"
+	"github.com/companyzero/bisonrelay/rpc"
+)
+
+// TestReplies tests that replies to PMs and GC messages reference the original
+// message and that the reference is stored in the history.
+func TestReplies(t *testing.T) {
+	tcfg := testScaffoldCfg{}
+	ts := newTestScaffold(t, tcfg)
+	alice := ts.newClient("alice")
+	bob := ts.newClient("bob")
+	ts.kxUsers(alice, bob)
+
+	alicePMChan := make(chan rpc.RMPrivateMessage, 1)
+	aliceGCMsgChan := make(chan rpc.RMGroupMessage, 1)
+	alice.modifyHandlers(func() {
+		alice.onPM = func(user *client.RemoteUser, msg rpc.RMPrivateMessage, ts time.Time) {
+			alicePMChan <- msg
+		}
+		alice.onGCMsg = func(user *client.RemoteUser, msg rpc.RMGroupMessage, ts time.Time) {
+			aliceGCMsgChan <- msg
+		}
+	})
+	bobPMChan := make(chan rpc.RMPrivateMessage, 1)
+	bobGCMsgChan := make(chan rpc.RMGroupMessage, 1)
+	bob.modifyHandlers(func() {
+		bob.onPM = func(user *client.RemoteUser, msg rpc.RMPrivateMessage, ts time.Time) {
+			bobPMChan <- msg
+		}
+		bob.onGCMsg = func(user *client.RemoteUser, msg rpc.RMGroupMessage, ts time.Time) {
+			bobGCMsgChan <- msg
+		}
+	})
+
+	// Bob replies to a PM from alice.
+	pmID, err := alice.PMWithID(bob.PublicID(), "lunch?")
+	assert.NilErr(t, err)
+	pm := assert.ChanWritten(t, bobPMChan)
+	assert.BoolIs(t, pm.ReplyTo.IsEmpty(), true)
+	replyID, err := bob.PMReply(alice.PublicID(), pm.ID, "sure")
+	assert.NilErr(t, err)
+	reply := assert.ChanWritten(t, alicePMChan)
+	assert.DeepEqual(t, reply.ID, replyID)
+	assert.DeepEqual(t, reply.ReplyTo, pmID)
+	history, err := alice.ReadPMHistory(bob.PublicID(), 0, 0)
+	assert.NilErr(t, err)
+	assert.DeepEqual(t, history[len(history)-1].ReplyTo, pmID)
+
+	// Alice replies to a GC message from bob.
+	gcID, err := alice.NewGroupChat("test gc")
+	assert.NilErr(t, err)
+	bobAcceptedChan := bob.acceptNextGCInvite(gcID)
+	assert.NilErr(t, alice.InviteToGroupChat(gcID, bob.PublicID()))
+	assert.NilErrFromChan(t, bobAcceptedChan)
+	assertClientInGC(t, bob, gcID)
+
+	gcMsgID, err := bob.GCMessageWithID(gcID, "where?", rpc.MessageModeNormal, nil)
+	assert.NilErr(t, err)
+	gcm := assert.ChanWritten(t, aliceGCMsgChan)
+	gcReplyID, err := alice.GCMessageReply(gcID, gcm.MsgID, "downtown",
+		rpc.MessageModeNormal, nil)
+	assert.NilErr(t, err)
+	gcReply := assert.ChanWritten(t, bobGCMsgChan)
+	assert.DeepEqual(t, gcReply.MsgID, gcReplyID)
+	assert.DeepEqual(t, gcReply.ReplyTo, gcMsgID)
+	history, err = bob.ReadGCHistory(gcID, 0, 0)
+	assert.NilErr(t, err)
+	assert.DeepEqual(t, history[len(history)-1].ReplyTo, gcMsgID)
+}
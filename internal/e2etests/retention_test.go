@@ -0,0 +1,221 @@
+package e2etests
+
+import (
+	"testing"
+	"time"
+
+	"github.com/companyzero/bisonrelay/client"
+	"github.com/companyzero/bisonrelay/client/clientdb"
+	"github.com/companyzero/bisonrelay/internal/assert"
+	"github.com/companyzero/bisonrelay/rpc"
+	"github.com/companyzero/bisonrelay/zkidentity"
+)
+
+// assertPMRetention asserts that c has the given retention policy with the
+// other user and the given number of stored PMs with them.
+func assertPMRetention(t testing.TB, c, other *testClient, policy rpc.RetentionPolicy,
+	nbMsgs int) {
+
+	t.Helper()
+	gotPolicy, err := c.PMRetention(other.PublicID())
+	assert.NilErr(t, err)
+	assert.DeepEqual(t, gotPolicy, policy)
+	history, err := c.ReadPMHistory(other.PublicID(), 0, 0)
+	assert.NilErr(t, err)
+	var gotMsgs []clientdb.HistoryEntry
+	for _, e := range history {
+		if !e.Internal {
+			gotMsgs = append(gotMsgs, e)
+		}
+	}
+	assert.DeepEqual(t, len(gotMsgs), nbMsgs)
+}
+
+// TestPMRetention tests that a retention policy set on a PM conversation is
+// honored by both sides of the conversation.
+func TestPMRetention(t *testing.T) {
+	tcfg := testScaffoldCfg{}
+	ts := newTestScaffold(t, tcfg)
+	alice := ts.newClient("alice")
+	bob := ts.newClient("bob")
+	ts.kxUsers(alice, bob)
+
+	alicePMChan := make(chan rpc.RMPrivateMessage, 1)
+	alice.modifyHandlers(func() {
+		alice.onPM = func(user *client.RemoteUser, msg rpc.RMPrivateMessage, ts time.Time) {
+			alicePMChan <- msg
+		}
+	})
+	aliceEditChan := make(chan rpc.RMMessageEdit, 1)
+	alice.modifyHandlers(func() {
+		alice.onMsgEdit = func(user *client.RemoteUser, edit rpc.RMMessageEdit) {
+			aliceEditChan <- edit
+		}
+	})
+	bobRetentionChan := make(chan rpc.RetentionPolicy, 1)
+	bobReceiptChan := make(chan rpc.RMReceipt, 10)
+	bob.modifyHandlers(func() {
+		bob.onRetention = func(user *client.RemoteUser, gcID zkidentity.ShortID, policy rpc.RetentionPolicy) {
+			bobRetentionChan <- policy
+		}
+		bob.onPMReceipt = func(user *client.RemoteUser, receipt rpc.RMReceipt) {
+			bobReceiptChan <- receipt
+		}
+	})
+
+	// Messages are stored before the policy is set.
+	assert.NilErr(t, bob.PM(alice.PublicID(), "stored message"))
+	assert.DeepEqual(t, assert.ChanWritten(t, alicePMChan).Message, "stored message")
+	res, err := alice.Search("stored", clientdb.SearchFilters{})
+	assert.NilErr(t, err)
+	assert.DeepEqual(t, len(res), 1)
+
+	// Alice enables disappearing messages. The existing messages were
+	// already read, so they are kept on both sides.
+	afterRead := rpc.RetentionPolicy{Mode: rpc.RetentionModeAfterRead}
+	assert.NilErr(t, alice.SetPMRetention(bob.PublicID(), afterRead))
+	assert.DeepEqual(t, assert.ChanWritten(t, bobRetentionChan), afterRead)
+	assertPMRetention(t, alice, bob, afterRead, 1)
+	assertPMRetention(t, bob, alice, afterRead, 1)
+
+	// New messages are stored until they are read, so they may still be
+	// edited. They are not indexed for search.
+	id, err := bob.PMWithID(alice.PublicID(), "disappearing message")
+	assert.NilErr(t, err)
+	assert.DeepEqual(t, assert.ChanWritten(t, alicePMChan).ID, id)
+	assertPMRetention(t, alice, bob, afterRead, 2)
+	assertPMRetention(t, bob, alice, afterRead, 2)
+	assert.NilErr(t, bob.EditPM(alice.PublicID(), id, "edited message"))
+	assert.ChanWritten(t, aliceEditChan)
+	history, err := alice.ReadPMHistory(bob.PublicID(), 0, 0)
+	assert.NilErr(t, err)
+	assert.DeepEqual(t, history[len(history)-1].Message, "edited message")
+	res, err = alice.Search("message", clientdb.SearchFilters{})
+	assert.NilErr(t, err)
+	assert.DeepEqual(t, len(res), 1)
+
+	// Once alice reads the message, it is removed on both sides.
+	assert.NilErr(t, alice.MarkPMRead(bob.PublicID(), id))
+	for {
+		receipt := assert.ChanWritten(t, bobReceiptChan)
+		if receipt.Status == rpc.ReceiptStatusRead {
+			assert.DeepEqual(t, receipt.ID, id)
+			break
+		}
+	}
+	assertPMRetention(t, alice, bob, afterRead, 1)
+	assertPMRetention(t, bob, alice, afterRead, 1)
+
+	// Bob asks alice to keep messages for a week. The policy only applies
+	// to alice's messages logged after she received it, while the existing
+	// messages are pruned on bob's side.
+	weekly := rpc.RetentionPolicy{Mode: rpc.RetentionModeDays, Days: 7}
+	aliceRetentionChan := make(chan rpc.RetentionPolicy, 1)
+	alice.modifyHandlers(func() {
+		alice.onRetention = func(user *client.RemoteUser, gcID zkidentity.ShortID, policy rpc.RetentionPolicy) {
+			aliceRetentionChan <- policy
+		}
+	})
+	time.Sleep(time.Second) // Received timestamps have a 1s resolution.
+	assert.NilErr(t, bob.SetPMRetention(alice.PublicID(), weekly))
+	assert.DeepEqual(t, assert.ChanWritten(t, aliceRetentionChan), weekly)
+	assert.NilErr(t, bob.PM(alice.PublicID(), "weekly message"))
+	assert.DeepEqual(t, assert.ChanWritten(t, alicePMChan).Message, "weekly message")
+	assertPMRetention(t, alice, bob, weekly, 2)
+	assertPMRetention(t, bob, alice, weekly, 2)
+	later := time.Now().AddDate(0, 0, 8)
+	for _, c := range []*testClient{alice, bob} {
+		other := alice
+		if c == alice {
+			other = bob
+		}
+		err := c.db.Update(ts.ctx, func(tx clientdb.ReadWriteTx) error {
+			return c.db.PruneUserMessages(tx, other.PublicID(), later)
+		})
+		assert.NilErr(t, err)
+	}
+	assertPMRetention(t, alice, bob, weekly, 1)
+	assertPMRetention(t, bob, alice, weekly, 0)
+	res, err = alice.Search("stored", clientdb.SearchFilters{})
+	assert.NilErr(t, err)
+	assert.DeepEqual(t, len(res), 1)
+
+	// Invalid policies are rejected.
+	invalid := rpc.RetentionPolicy{Mode: rpc.RetentionModeDays}
+	if err := alice.SetPMRetention(bob.PublicID(), invalid); err == nil {
+		t.Fatalf("unexpected nil error setting invalid retention policy")
+	}
+}
+
+// TestGCRetention tests that a retention policy set by the GC admin is honored
+// by the GC members, including those that join after the policy was set.
+func TestGCRetention(t *testing.T) {
+	tcfg := testScaffoldCfg{}
+	ts := newTestScaffold(t, tcfg)
+	alice := ts.newClient("alice")
+	bob := ts.newClient("bob")
+	charlie := ts.newClient("charlie")
+	ts.kxUsers(alice, bob)
+	ts.kxUsers(alice, charlie)
+
+	gcID, err := alice.NewGroupChat("test gc")
+	assert.NilErr(t, err)
+	bobAcceptedChan := bob.acceptNextGCInvite(gcID)
+	assert.NilErr(t, alice.InviteToGroupChat(gcID, bob.PublicID()))
+	assert.NilErrFromChan(t, bobAcceptedChan)
+	assertClientInGC(t, bob, gcID)
+
+	retentionChan := make(chan zkidentity.ShortID, 2)
+	onRetention := func(user *client.RemoteUser, gcID zkidentity.ShortID, policy rpc.RetentionPolicy) {
+		retentionChan <- gcID
+	}
+	bob.modifyHandlers(func() { bob.onRetention = onRetention })
+	charlie.modifyHandlers(func() { charlie.onRetention = onRetention })
+	gcMsgChan := make(chan string, 2)
+	onGCMsg := func(user *client.RemoteUser, msg rpc.RMGroupMessage, ts time.Time) {
+		gcMsgChan <- msg.Message
+	}
+	bob.modifyHandlers(func() { bob.onGCMsg = onGCMsg })
+	charlie.modifyHandlers(func() { charlie.onGCMsg = onGCMsg })
+
+	// Only the admin may set the policy.
+	weekly := rpc.RetentionPolicy{Mode: rpc.RetentionModeDays, Days: 7}
+	if err := bob.SetGCRetention(gcID, weekly); err == nil {
+		t.Fatalf("unexpected nil error setting retention policy as non-admin")
+	}
+
+	// Alice enables disappearing messages in the GC.
+	afterRead := rpc.RetentionPolicy{Mode: rpc.RetentionModeAfterRead}
+	assert.NilErr(t, alice.SetGCRetention(gcID, afterRead))
+	assert.DeepEqual(t, assert.ChanWritten(t, retentionChan), gcID)
+	gc, err := bob.GetGC(gcID)
+	assert.NilErr(t, err)
+	assert.DeepEqual(t, gc.Retention, afterRead)
+
+	// Charlie joins and also receives the policy.
+	charlieAcceptedChan := charlie.acceptNextGCInvite(gcID)
+	assert.NilErr(t, alice.InviteToGroupChat(gcID, charlie.PublicID()))
+	assert.NilErrFromChan(t, charlieAcceptedChan)
+	assert.DeepEqual(t, assert.ChanWritten(t, retentionChan), gcID)
+	gc, err = charlie.GetGC(gcID)
+	assert.NilErr(t, err)
+	assert.DeepEqual(t, gc.Retention, afterRead)
+
+	// Messages are stored until they are read.
+	id, err := alice.GCMessageWithID(gcID, "disappearing", rpc.MessageModeNormal, nil)
+	assert.NilErr(t, err)
+	assert.DeepEqual(t, assert.ChanWritten(t, gcMsgChan), "disappearing")
+	assert.DeepEqual(t, assert.ChanWritten(t, gcMsgChan), "disappearing")
+	for _, c := range []*testClient{alice, bob, charlie} {
+		history, err := c.ReadGCHistory(gcID, 0, 0)
+		assert.NilErr(t, err)
+		assert.DeepEqual(t, len(history), 1)
+	}
+	assert.NilErr(t, bob.MarkGCMsgRead(gcID, id))
+	history, err := bob.ReadGCHistory(gcID, 0, 0)
+	assert.NilErr(t, err)
+	assert.DeepEqual(t, len(history), 0)
+	history, err = charlie.ReadGCHistory(gcID, 0, 0)
+	assert.NilErr(t, err)
+	assert.DeepEqual(t, len(history), 1)
+}
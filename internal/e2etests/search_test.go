@@ -0,0 +1,68 @@
+package e2etests
+
+import (
+	"testing"
+	"time"
+
+	"github.com/companyzero/bisonrelay/client"
+	"github.com/companyzero/bisonrelay/client/clientdb"
+	"github.com/companyzero/bisonrelay/internal/assert"
+	"github.com/companyzero/bisonrelay/rpc"
+)
+
+// TestSearchPMs tests that PMs exchanged between users are searchable.
+func TestSearchPMs(t *testing.T) {
+	tcfg := testScaffoldCfg{}
+	ts := newTestScaffold(t, tcfg)
+	alice := ts.newClient("alice")
+	bob := ts.newClient("bob")
+	ts.kxUsers(alice, bob)
+
+	bobPMChan := make(chan string, 3)
+	bob.modifyHandlers(func() {
+		bob.onPM = func(user *client.RemoteUser, msg rpc.RMPrivateMessage, ts time.Time) {
+			bobPMChan <- msg.Message
+		}
+	})
+
+	msgs := []string{
+		"the quick brown fox",
+		"jumps over the lazy dog",
+		"the Quick red fox",
+	}
+	for _, msg := range msgs {
+		assert.NilErr(t, alice.PM(bob.PublicID(), msg))
+		assert.DeepEqual(t, assert.ChanWritten(t, bobPMChan), msg)
+	}
+
+	// Search for a term in multiple messages. Results are returned newest
+	// first.
+	res, err := bob.Search("quick fox", clientdb.SearchFilters{})
+	assert.NilErr(t, err)
+	assert.DeepEqual(t, len(res), 2)
+	assert.DeepEqual(t, res[0].Text, msgs[2])
+	assert.DeepEqual(t, res[1].Text, msgs[0])
+	assert.DeepEqual(t, res[0].UID, alice.PublicID())
+	assert.DeepEqual(t, res[0].Type, clientdb.SearchTypePM)
+
+	// Alice also finds the messages she sent.
+	res, err = alice.Search("lazy", clientdb.SearchFilters{})
+	assert.NilErr(t, err)
+	assert.DeepEqual(t, len(res), 1)
+	assert.DeepEqual(t, res[0].UID, bob.PublicID())
+
+	// Filters are applied to the results.
+	res, err = bob.Search("fox", clientdb.SearchFilters{Limit: 1})
+	assert.NilErr(t, err)
+	assert.DeepEqual(t, len(res), 1)
+	res, err = bob.Search("fox", clientdb.SearchFilters{
+		Types: []clientdb.SearchResultType{clientdb.SearchTypeGC},
+	})
+	assert.NilErr(t, err)
+	assert.DeepEqual(t, len(res), 0)
+
+	// Terms not in any message do not match.
+	res, err = bob.Search("quick cat", clientdb.SearchFilters{})
+	assert.NilErr(t, err)
+	assert.DeepEqual(t, len(res), 0)
+}
@@ -0,0 +1,163 @@
+package e2etests
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/companyzero/bisonrelay/client"
+	"github.com/companyzero/bisonrelay/client/clientdb"
+	"github.com/companyzero/bisonrelay/internal/assert"
+)
+
+// writeFolderFile writes a file with the given contents inside a folder.
+func writeFolderFile(t testing.TB, dir, path, data string) {
+	t.Helper()
+	fname := filepath.Join(dir, filepath.FromSlash(path))
+	assert.NilErr(t, os.MkdirAll(filepath.Dir(fname), 0o700))
+	assert.NilErr(t, os.WriteFile(fname, []byte(data), 0o600))
+}
+
+// assertFolderFile asserts that the file with the given path inside a folder
+// eventually has the given contents.
+func assertFolderFile(t testing.TB, dir, path, data string) {
+	t.Helper()
+	fname := filepath.Join(dir, filepath.FromSlash(path))
+	var got []byte
+	var err error
+	for i := 0; i < 200; i++ {
+		got, err = os.ReadFile(fname)
+		if err == nil && bytes.Equal(got, []byte(data)) {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Fatalf("file %s does not have expected contents (err %v, got %q, "+
+		"want %q)", path, err, got, data)
+}
+
+// TestSharedFolder tests sharing a folder with a single user.
+func TestSharedFolder(t *testing.T) {
+	tcfg := testScaffoldCfg{}
+	ts := newTestScaffold(t, tcfg)
+	alice := ts.newClient("alice")
+	bob := ts.newClient("bob")
+	ts.kxUsers(alice, bob)
+
+	bobFolderChan := make(chan clientdb.SubscribedFolder, 1)
+	bob.modifyHandlers(func() {
+		bob.onSharedFolder = func(user *client.RemoteUser, sf clientdb.SubscribedFolder) {
+			bobFolderChan <- sf
+		}
+	})
+
+	aliceDir := t.TempDir()
+	bobDir := filepath.Join(t.TempDir(), "synced")
+	writeFolderFile(t, aliceDir, "first.txt", "first file")
+	writeFolderFile(t, aliceDir, "sub/second.txt", "second file")
+
+	// Alice shares the folder with Bob.
+	bobID := bob.PublicID()
+	aliceSF, err := alice.ShareFolder(aliceDir, &bobID, nil, 0)
+	assert.NilErr(t, err)
+	assert.DeepEqual(t, len(aliceSF.Files), 2)
+	sf := assert.ChanWritten(t, bobFolderChan)
+	assert.DeepEqual(t, sf.ID, aliceSF.ID)
+	assert.DeepEqual(t, sf.Version, uint64(1))
+	assert.DeepEqual(t, len(sf.Files), 2)
+
+	// Bob accepts the folder and its files are synced.
+	assert.NilErr(t, bob.AcceptSharedFolder(alice.PublicID(), sf.ID, bobDir))
+	assertFolderFile(t, bobDir, "first.txt", "first file")
+	assertFolderFile(t, bobDir, "sub/second.txt", "second file")
+
+	// Alice changes one file and adds another one. The changes are synced.
+	writeFolderFile(t, aliceDir, "first.txt", "first file changed")
+	writeFolderFile(t, aliceDir, "sub/third.txt", "third file")
+	assert.NilErr(t, alice.SyncSharedFolder(sf.ID))
+	sf = assert.ChanWritten(t, bobFolderChan)
+	assert.DeepEqual(t, sf.Version, uint64(2))
+	assert.DeepEqual(t, len(sf.Files), 3)
+	assertFolderFile(t, bobDir, "first.txt", "first file changed")
+	assertFolderFile(t, bobDir, "sub/third.txt", "third file")
+
+	// Syncing without changes does not send a new manifest.
+	assert.NilErr(t, alice.SyncSharedFolder(sf.ID))
+	assert.ChanNotWritten(t, bobFolderChan, time.Second)
+
+	// Alice stops sharing the folder. Bob keeps the synced files.
+	assert.NilErr(t, alice.UnshareFolder(sf.ID))
+	sf = assert.ChanWritten(t, bobFolderChan)
+	assert.BoolIs(t, sf.Removed, true)
+	assertFolderFile(t, bobDir, "sub/second.txt", "second file")
+	sfs, err := alice.ListSharedFolders()
+	assert.NilErr(t, err)
+	assert.DeepEqual(t, len(sfs), 0)
+}
+
+// TestSharedFolderGC tests sharing a folder with the members of a GC.
+func TestSharedFolderGC(t *testing.T) {
+	tcfg := testScaffoldCfg{}
+	ts := newTestScaffold(t, tcfg)
+	alice := ts.newClient("alice")
+	bob := ts.newClient("bob")
+	charlie := ts.newClient("charlie")
+	ts.kxUsers(alice, bob)
+	ts.kxUsers(alice, charlie)
+
+	gcID, err := alice.NewGroupChat("test gc")
+	assert.NilErr(t, err)
+	acceptedChan := bob.acceptNextGCInvite(gcID)
+	assert.NilErr(t, alice.InviteToGroupChat(gcID, bob.PublicID()))
+	assert.NilErrFromChan(t, acceptedChan)
+	assertClientInGC(t, bob, gcID)
+
+	folderChans := make(map[string]chan clientdb.SubscribedFolder)
+	for _, c := range []*testClient{bob, charlie} {
+		c := c
+		folderChan := make(chan clientdb.SubscribedFolder, 1)
+		folderChans[c.name] = folderChan
+		c.modifyHandlers(func() {
+			c.onSharedFolder = func(user *client.RemoteUser, sf clientdb.SubscribedFolder) {
+				folderChan <- sf
+			}
+		})
+	}
+
+	// Alice shares the folder with the GC. Only Bob is a member.
+	aliceDir := t.TempDir()
+	writeFolderFile(t, aliceDir, "notes.txt", "gc notes")
+	aliceSF, err := alice.ShareFolder(aliceDir, nil, &gcID, 0)
+	assert.NilErr(t, err)
+	sf := assert.ChanWritten(t, folderChans["bob"])
+	assert.DeepEqual(t, sf.GC, &gcID)
+	assert.ChanNotWritten(t, folderChans["charlie"], time.Second)
+
+	bobDir := t.TempDir()
+	assert.NilErr(t, bob.AcceptSharedFolder(alice.PublicID(), sf.ID, bobDir))
+	assertFolderFile(t, bobDir, "notes.txt", "gc notes")
+
+	// Charlie joins the GC and receives the folder on the next scan.
+	acceptedChan = charlie.acceptNextGCInvite(gcID)
+	assert.NilErr(t, alice.InviteToGroupChat(gcID, charlie.PublicID()))
+	assert.NilErrFromChan(t, acceptedChan)
+	assertClientInGC(t, charlie, gcID)
+	for i := 0; ; i++ {
+		gc, err := alice.GetGC(gcID)
+		assert.NilErr(t, err)
+		if len(gc.Members) == 3 {
+			break
+		}
+		if i == 100 {
+			t.Fatalf("timeout waiting for charlie to join gc")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	assert.NilErr(t, alice.SyncSharedFolder(aliceSF.ID))
+	sf = assert.ChanWritten(t, folderChans["charlie"])
+	charlieDir := t.TempDir()
+	assert.NilErr(t, charlie.AcceptSharedFolder(alice.PublicID(), sf.ID, charlieDir))
+	assertFolderFile(t, charlieDir, "notes.txt", "gc notes")
+}
@@ -0,0 +1,158 @@
+package e2etests
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/companyzero/bisonrelay/client"
+	"github.com/companyzero/bisonrelay/client/clientdb"
+	"github.com/companyzero/bisonrelay/internal/assert"
+	"github.com/companyzero/bisonrelay/rpc"
+)
+
+// downloadedChunks returns the number of chunks of the given file downloaded
+// by the client.
+func downloadedChunks(t testing.TB, tc *testClient, fid clientdb.FileID) int {
+	t.Helper()
+	fds, err := tc.ListDownloads()
+	assert.NilErr(t, err)
+	for _, fd := range fds {
+		if fd.FID == fid {
+			return fd.CountChunks(clientdb.ChunkStateDownloaded)
+		}
+	}
+	return 0
+}
+
+// TestPauseResumeDownload tests that paused downloads do not progress until
+// they are resumed.
+func TestPauseResumeDownload(t *testing.T) {
+	tcfg := testScaffoldCfg{}
+	ts := newTestScaffold(t, tcfg)
+	alice := ts.newClient("alice")
+	bob := ts.newClient("bob")
+	ts.kxUsers(alice, bob)
+
+	bobDownloadChan := make(chan string, 1)
+	bob.modifyHandlers(func() {
+		bob.onFileDownload = func(user *client.RemoteUser, fm rpc.FileMetadata, diskPath string) {
+			bobDownloadChan <- diskPath
+		}
+	})
+
+	fname, data := writeRandomFile(t, 400)
+	sf, _, err := alice.ShareFile(fname, nil, 0, false, "")
+	assert.NilErr(t, err)
+
+	// Bob starts the download and pauses it as soon as the first chunks
+	// are received.
+	assert.NilErr(t, bob.GetUserContent(alice.PublicID(), sf.FID))
+	for i := 0; downloadedChunks(t, bob, sf.FID) == 0; i++ {
+		if i == 300 {
+			t.Fatalf("timeout waiting for first chunk")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.NilErr(t, bob.PauseDownload(sf.FID))
+
+	// Wait for the chunks that were already requested to be received. The
+	// download should not progress afterwards.
+	time.Sleep(time.Second)
+	gotChunks := downloadedChunks(t, bob, sf.FID)
+	assert.ChanNotWritten(t, bobDownloadChan, time.Second)
+	assert.DeepEqual(t, downloadedChunks(t, bob, sf.FID), gotChunks)
+
+	// Resuming the download completes it.
+	assert.NilErr(t, bob.ResumeDownload(sf.FID))
+	diskPath := assert.ChanWritten(t, bobDownloadChan)
+	got, err := os.ReadFile(diskPath)
+	assert.NilErr(t, err)
+	if !bytes.Equal(got, data) {
+		t.Fatalf("downloaded file does not match shared file")
+	}
+
+	// Pausing a completed download fails.
+	if err := bob.PauseDownload(sf.FID); err == nil {
+		t.Fatalf("unexpected success pausing completed download")
+	}
+}
+
+// TestCancelTransfers tests canceling uploads and downloads.
+func TestCancelTransfers(t *testing.T) {
+	tcfg := testScaffoldCfg{}
+	ts := newTestScaffold(t, tcfg)
+	alice := ts.newClient("alice")
+	bob := ts.newClient("bob")
+	ts.kxUsers(alice, bob)
+
+	bobDownloadChan := make(chan string, 1)
+	bob.modifyHandlers(func() {
+		bob.onFileDownload = func(user *client.RemoteUser, fm rpc.FileMetadata, diskPath string) {
+			bobDownloadChan <- diskPath
+		}
+	})
+	aliceUploadChan := make(chan int, 100)
+	alice.modifyHandlers(func() {
+		alice.onFileUpload = func(user *client.RemoteUser, fm rpc.FileMetadata, chunkIdx int) {
+			aliceUploadChan <- chunkIdx
+		}
+	})
+
+	fname, data := writeRandomFile(t, 400)
+	sf, _, err := alice.ShareFile(fname, nil, 0, false, "")
+	assert.NilErr(t, err)
+
+	// Alice cancels the upload as soon as the first chunk is sent. Bob
+	// does not complete the download.
+	assert.NilErr(t, bob.GetUserContent(alice.PublicID(), sf.FID))
+	assert.ChanWritten(t, aliceUploadChan)
+	assert.NilErr(t, alice.CancelUpload(bob.PublicID(), sf.FID))
+	assert.ChanNotWritten(t, bobDownloadChan, 2*time.Second)
+
+	// Bob cancels the download.
+	assert.NilErr(t, bob.CancelDownload(sf.FID))
+	fds, err := bob.ListDownloads()
+	assert.NilErr(t, err)
+	assert.DeepEqual(t, len(fds), 0)
+
+	// Requesting the file again restarts the upload.
+	assert.NilErr(t, bob.GetUserContent(alice.PublicID(), sf.FID))
+	diskPath := assert.ChanWritten(t, bobDownloadChan)
+	got, err := os.ReadFile(diskPath)
+	assert.NilErr(t, err)
+	if !bytes.Equal(got, data) {
+		t.Fatalf("downloaded file does not match shared file")
+	}
+}
+
+// TestTransferPriority tests setting the priority of transfers.
+func TestTransferPriority(t *testing.T) {
+	tcfg := testScaffoldCfg{}
+	ts := newTestScaffold(t, tcfg)
+	alice := ts.newClient("alice")
+
+	fname, _ := writeRandomFile(t, 400)
+	sf, _, err := alice.ShareFile(fname, nil, 0, false, "")
+	assert.NilErr(t, err)
+
+	prio, err := alice.GetTransferPriority(sf.FID)
+	assert.NilErr(t, err)
+	assert.DeepEqual(t, prio, clientdb.TransferPriorityNormal)
+
+	assert.NilErr(t, alice.SetTransferPriority(sf.FID, clientdb.TransferPriorityHigh))
+	prio, err = alice.GetTransferPriority(sf.FID)
+	assert.NilErr(t, err)
+	assert.DeepEqual(t, prio, clientdb.TransferPriorityHigh)
+
+	assert.NilErr(t, alice.SetTransferPriority(sf.FID, clientdb.TransferPriorityNormal))
+	prio, err = alice.GetTransferPriority(sf.FID)
+	assert.NilErr(t, err)
+	assert.DeepEqual(t, prio, clientdb.TransferPriorityNormal)
+
+	err = alice.SetTransferPriority(sf.FID, clientdb.TransferPriority("urgent"))
+	if err == nil {
+		t.Fatalf("unexpected success setting invalid priority")
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"compress/zlib"
 	"crypto/rand"
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -14,6 +15,7 @@ import (
 	"github.com/companyzero/bisonrelay/sw"
 	"github.com/companyzero/bisonrelay/zkidentity"
 	"github.com/companyzero/sntrup4591761"
+	"github.com/decred/dcrd/crypto/blake256"
 )
 
 // OOOPublicIdentityInvite is an unencrypted OOB command which contains all
@@ -83,6 +85,88 @@ func UnmarshalOOBPublicIdentityInviteFile(filename string) (*OOBPublicIdentityIn
 	return &pii, nil
 }
 
+// OOBGCJoinLink is an out-of-band link that allows anyone that has it to join
+// an open GC. The link is signed by the GC admin that created it. Users that
+// redeem the link send an RMOGCJoinRequest to one of the link's slot RVs,
+// which the admin uses to perform a KX (if needed) and then invite the user to
+// the GC.
+//
+// Since each RV can only be used once, the link has a number of slots and
+// each redeeming user picks one at random.
+type OOBGCJoinLink struct {
+	GC            zkidentity.ShortID            `json:"gc"`
+	Name          string                        `json:"name"`
+	Admin         zkidentity.PublicIdentity     `json:"admin"`
+	Seed          zkidentity.ShortID            `json:"seed"`
+	Slots         uint32                        `json:"slots"`
+	Expires       int64                         `json:"expires"`
+	NeedsApproval bool                          `json:"needs_approval"`
+	Signature     zkidentity.FixedSizeSignature `json:"signature"`
+}
+
+const OOBCGCJoinLink = "oobgcjoinlink"
+
+// Hash returns the hash of the link contents that are signed by the admin.
+func (l *OOBGCJoinLink) Hash() [32]byte {
+	h := blake256.New()
+	var b [8]byte
+	writeUint64 := func(v uint64) {
+		binary.LittleEndian.PutUint64(b[:], v)
+		h.Write(b[:])
+	}
+
+	h.Write(l.GC[:])
+	writeUint64(uint64(len(l.Name)))
+	h.Write([]byte(l.Name))
+	h.Write(l.Admin.Identity[:])
+	h.Write(l.Seed[:])
+	writeUint64(uint64(l.Slots))
+	writeUint64(uint64(l.Expires))
+	if l.NeedsApproval {
+		writeUint64(1)
+	} else {
+		writeUint64(0)
+	}
+
+	var res [32]byte
+	copy(res[:], h.Sum(nil))
+	return res
+}
+
+// Verify verifies the link was signed by its admin.
+func (l *OOBGCJoinLink) Verify() bool {
+	if !l.Admin.Verify() || !l.Admin.VerifyIdentity() {
+		return false
+	}
+	h := l.Hash()
+	return l.Admin.VerifyMessage(h[:], l.Signature)
+}
+
+// SlotRV returns the RV where requests to join the GC using the given slot
+// are sent.
+func (l *OOBGCJoinLink) SlotRV(slot uint32) ratchet.RVPoint {
+	h := blake256.New()
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], slot)
+	h.Write(l.Seed[:])
+	h.Write(b[:])
+
+	var res ratchet.RVPoint
+	copy(res[:], h.Sum(nil))
+	return res
+}
+
+// RMOGCJoinRequest is sent by a user redeeming an OOBGCJoinLink to one of the
+// link's slot RVs. Invite is a KX invite created by the redeeming user, which
+// the admin accepts to complete the KX before inviting the user to the GC.
+type RMOGCJoinRequest struct {
+	GC     zkidentity.ShortID      `json:"gc"`
+	Seed   zkidentity.ShortID      `json:"seed"`
+	Invite OOBPublicIdentityInvite `json:"invite"`
+}
+
+const RMOCGCJoinRequest = "ogcjoinrequest"
+
 // NewHalfRatchetKX creates a new half ratchet between two identities. It returns
 // the half ratchet and a random key exchange structure.
 func NewHalfRatchetKX(us *zkidentity.FullIdentity, them zkidentity.PublicIdentity) (*ratchet.Ratchet, *ratchet.KeyExchange, error) {
@@ -281,6 +365,9 @@ func ComposeRMO(rm interface{}, zlibLevel int) ([]byte, error) {
 	case RMOFullKX:
 		h.Command = RMOCFullKX
 
+	case RMOGCJoinRequest:
+		h.Command = RMOCGCJoinRequest
+
 	default:
 		return nil, fmt.Errorf("unknown oob routed message "+
 			"type: %T", rm)
@@ -346,6 +433,11 @@ func DecomposeRMO(mb []byte) (*RMOHeader, interface{}, error) {
 		err = pmd.Decode(&fkx)
 		payload = fkx
 
+	case RMOCGCJoinRequest:
+		var jr RMOGCJoinRequest
+		err = pmd.Decode(&jr)
+		payload = jr
+
 	default:
 		return nil, nil, fmt.Errorf("unknown oob "+
 			"message command: %v", h.Command)
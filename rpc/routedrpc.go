@@ -8,7 +8,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/companyzero/bisonrelay/ratchet"
 	"github.com/companyzero/bisonrelay/zkidentity"
@@ -50,6 +53,14 @@ const (
 type RMPrivateMessage struct {
 	Mode    uint32 `json:"mode"`
 	Message string `json:"message"`
+
+	// ID is a random identifier of the message, used to reference it in
+	// receipts, edits, retractions and replies. Messages sent by older
+	// clients have an empty ID.
+	ID zkidentity.ShortID `json:"id"`
+
+	// ReplyTo is the ID of the message this message replies to, if any.
+	ReplyTo zkidentity.ShortID `json:"reply_to"`
 }
 
 type RMBlock struct {
@@ -143,6 +154,141 @@ type RMKXSuggestion struct {
 	Target zkidentity.PublicIdentity
 }
 
+// ReceiptStatus is the status of a message reported by a receipt.
+type ReceiptStatus string
+
+const (
+	// ReceiptStatusDelivered is reported once the message is received
+	// and decrypted by the remote client.
+	ReceiptStatusDelivered ReceiptStatus = "delivered"
+
+	// ReceiptStatusRead is reported once the message is displayed to the
+	// remote user.
+	ReceiptStatusRead ReceiptStatus = "read"
+)
+
+const RMCReceipt = "receipt"
+
+// RMReceipt is sent by the receiver of a private message to acknowledge that
+// the message with the given ID reached the specified status.
+type RMReceipt struct {
+	ID        zkidentity.ShortID `json:"id"`
+	Status    ReceiptStatus      `json:"status"`
+	Timestamp int64              `json:"timestamp"`
+}
+
+// RetentionMode is the mode of a message retention policy.
+type RetentionMode string
+
+const (
+	// RetentionModeForever keeps messages forever.
+	RetentionModeForever RetentionMode = ""
+
+	// RetentionModeDays keeps messages for a number of days.
+	RetentionModeDays RetentionMode = "days"
+
+	// RetentionModeAfterRead does not store messages after they are
+	// displayed.
+	RetentionModeAfterRead RetentionMode = "afterread"
+)
+
+// RetentionPolicy is the policy for how long the messages of a conversation
+// are kept.
+type RetentionPolicy struct {
+	Mode RetentionMode `json:"mode"`
+	Days uint32        `json:"days,omitempty"`
+}
+
+// IsValid returns true if the retention policy is valid.
+func (rp RetentionPolicy) IsValid() bool {
+	switch rp.Mode {
+	case RetentionModeForever, RetentionModeAfterRead:
+		return rp.Days == 0
+	case RetentionModeDays:
+		return rp.Days > 0
+	default:
+		return false
+	}
+}
+
+func (rp RetentionPolicy) String() string {
+	switch rp.Mode {
+	case RetentionModeForever:
+		return "keep forever"
+	case RetentionModeDays:
+		return fmt.Sprintf("keep for %d days", rp.Days)
+	case RetentionModeAfterRead:
+		return "disappear after read"
+	default:
+		return fmt.Sprintf("unknown mode %q", rp.Mode)
+	}
+}
+
+const RMCRetentionPolicy = "retentionpolicy"
+
+// RMRetentionPolicy is sent to request the remote user to honor the retention
+// policy on the messages of the conversation with the sender. If GC is
+// filled, the policy applies to the messages of that GC instead and is only
+// honored when sent by the GC admin.
+type RMRetentionPolicy struct {
+	GC     zkidentity.ShortID `json:"gc"`
+	Policy RetentionPolicy    `json:"policy"`
+}
+
+const RMCMessageEdit = "messageedit"
+
+// RMMessageEdit is sent to replace the contents of a message previously sent
+// by the sender. If GC is filled, the message was sent in that GC, otherwise
+// it was sent as a PM to the receiver.
+type RMMessageEdit struct {
+	GC      zkidentity.ShortID `json:"gc"`
+	ID      zkidentity.ShortID `json:"id"`
+	Message string             `json:"message"`
+}
+
+const RMCMessageRetract = "messageretract"
+
+// RMMessageRetract is sent to request the removal of a message previously sent
+// by the sender. If GC is filled, the message was sent in that GC, otherwise
+// it was sent as a PM to the receiver.
+type RMMessageRetract struct {
+	GC zkidentity.ShortID `json:"gc"`
+	ID zkidentity.ShortID `json:"id"`
+}
+
+const RMCMessageReaction = "messagereaction"
+
+// RMMessageReaction is sent to add (or remove, if Remove is true) a reaction
+// of the sender to a message. If GC is filled, the message was sent in that GC,
+// otherwise it was sent as a PM between the sender and the receiver.
+type RMMessageReaction struct {
+	GC       zkidentity.ShortID `json:"gc"`
+	ID       zkidentity.ShortID `json:"id"`
+	Reaction string             `json:"reaction"`
+	Remove   bool               `json:"remove,omitempty"`
+}
+
+// MaxReactionLen is the maximum length (in bytes) of a reaction.
+const MaxReactionLen = 32
+
+// IsValidReaction returns true if the reaction can be sent to messages and
+// posts. Reactions are short strings (usually a single emoji or a short code
+// such as ":+1:") without any whitespace.
+func IsValidReaction(reaction string) bool {
+	if reaction == "" || len(reaction) > MaxReactionLen {
+		return false
+	}
+	if !utf8.ValidString(reaction) {
+		return false
+	}
+	for _, r := range reaction {
+		if unicode.IsSpace(r) || unicode.IsControl(r) {
+			return false
+		}
+	}
+	return true
+}
+
 // ComposeCompressedRM creates a blobified message that has a header and a
 // payload that can then be encrypted and transmitted to the other side. The
 // contents are zlib compressed with the specified level.
@@ -197,6 +343,21 @@ func ComposeCompressedRM(from *zkidentity.FullIdentity, rm interface{}, zlibLeve
 	case RMKXSuggestion:
 		h.Command = RMCKXSuggestion
 
+	case RMRetentionPolicy:
+		h.Command = RMCRetentionPolicy
+
+	case RMReceipt:
+		h.Command = RMCReceipt
+
+	case RMMessageEdit:
+		h.Command = RMCMessageEdit
+
+	case RMMessageRetract:
+		h.Command = RMCMessageRetract
+
+	case RMMessageReaction:
+		h.Command = RMCMessageReaction
+
 	// Group chat
 	case RMGroupInvite:
 		h.Command = RMCGroupInvite
@@ -204,6 +365,9 @@ func ComposeCompressedRM(from *zkidentity.FullIdentity, rm interface{}, zlibLeve
 	case RMGroupJoin:
 		h.Command = RMCGroupJoin
 
+	case RMGroupJoinRejected:
+		h.Command = RMCGroupJoinRejected
+
 	case RMGroupPart:
 		h.Command = RMCGroupPart
 
@@ -216,9 +380,18 @@ func ComposeCompressedRM(from *zkidentity.FullIdentity, rm interface{}, zlibLeve
 	case RMGroupUpdate:
 		h.Command = RMCGroupUpdate
 
+	case RMGroupUpdateRequest:
+		h.Command = RMCGroupUpdateRequest
+
+	case RMGroupSenderKey:
+		h.Command = RMCGroupSenderKey
+
 	case RMGroupList:
 		h.Command = RMCGroupList
 
+	case RMGroupMetadata:
+		h.Command = RMCGroupMetadata
+
 	case RMGroupMessage:
 		h.Command = RMCGroupMessage
 
@@ -247,6 +420,15 @@ func ComposeCompressedRM(from *zkidentity.FullIdentity, rm interface{}, zlibLeve
 	case RMFTSendFile:
 		h.Command = RMCFTSendFile
 
+	case RMFTFindSources:
+		h.Command = RMCFTFindSources
+
+	case RMFTFindSourcesReply:
+		h.Command = RMCFTFindSourcesReply
+
+	case RMFTSharedFolder:
+		h.Command = RMCFTSharedFolder
+
 	// User
 	case RMUser:
 		h.Command = RMCUser
@@ -451,6 +633,31 @@ func DecomposeRM(id *zkidentity.PublicIdentity, mb []byte) (*RMHeader, interface
 		err = pmd.Decode(&kxsg)
 		payload = kxsg
 
+	case RMCRetentionPolicy:
+		var rp RMRetentionPolicy
+		err = pmd.Decode(&rp)
+		payload = rp
+
+	case RMCReceipt:
+		var r RMReceipt
+		err = pmd.Decode(&r)
+		payload = r
+
+	case RMCMessageEdit:
+		var me RMMessageEdit
+		err = pmd.Decode(&me)
+		payload = me
+
+	case RMCMessageRetract:
+		var mr RMMessageRetract
+		err = pmd.Decode(&mr)
+		payload = mr
+
+	case RMCMessageReaction:
+		var mr RMMessageReaction
+		err = pmd.Decode(&mr)
+		payload = mr
+
 		// Group vhat
 	case RMCGroupInvite:
 		var groupInvite RMGroupInvite
@@ -462,6 +669,11 @@ func DecomposeRM(id *zkidentity.PublicIdentity, mb []byte) (*RMHeader, interface
 		err = pmd.Decode(&groupJoin)
 		payload = groupJoin
 
+	case RMCGroupJoinRejected:
+		var joinRejected RMGroupJoinRejected
+		err = pmd.Decode(&joinRejected)
+		payload = joinRejected
+
 	case RMCGroupPart:
 		var groupPart RMGroupPart
 		err = pmd.Decode(&groupPart)
@@ -482,11 +694,26 @@ func DecomposeRM(id *zkidentity.PublicIdentity, mb []byte) (*RMHeader, interface
 		err = pmd.Decode(&groupUpdate)
 		payload = groupUpdate
 
+	case RMCGroupUpdateRequest:
+		var groupUpdateReq RMGroupUpdateRequest
+		err = pmd.Decode(&groupUpdateReq)
+		payload = groupUpdateReq
+
+	case RMCGroupSenderKey:
+		var senderKey RMGroupSenderKey
+		err = pmd.Decode(&senderKey)
+		payload = senderKey
+
 	case RMCGroupList:
 		var groupList RMGroupList
 		err = pmd.Decode(&groupList)
 		payload = groupList
 
+	case RMCGroupMetadata:
+		var metadata RMGroupMetadata
+		err = pmd.Decode(&metadata)
+		payload = metadata
+
 	// File transfer
 	case RMCFTList:
 		var ftList RMFTList
@@ -528,6 +755,21 @@ func DecomposeRM(id *zkidentity.PublicIdentity, mb []byte) (*RMHeader, interface
 		err = pmd.Decode(&ftSendFile)
 		payload = ftSendFile
 
+	case RMCFTFindSources:
+		var ftFindSources RMFTFindSources
+		err = pmd.Decode(&ftFindSources)
+		payload = ftFindSources
+
+	case RMCFTFindSourcesReply:
+		var ftFindSourcesReply RMFTFindSourcesReply
+		err = pmd.Decode(&ftFindSourcesReply)
+		payload = ftFindSourcesReply
+
+	case RMCFTSharedFolder:
+		var ftSharedFolder RMFTSharedFolder
+		err = pmd.Decode(&ftSharedFolder)
+		payload = ftSharedFolder
+
 	case RMCGroupMessage:
 		var groupMessage RMGroupMessage
 		err = pmd.Decode(&groupMessage)
@@ -680,6 +922,12 @@ type RMGroupInvite struct {
 	Expires     int64              `json:"expires"`     // unix time when this invite expires
 }
 
+// IsExpired returns true if the invite expired before the given time. Invites
+// without an expiration time never expire.
+func (gi *RMGroupInvite) IsExpired(now time.Time) bool {
+	return gi.Expires > 0 && now.Unix() > gi.Expires
+}
+
 const RMCGroupInvite = "groupinvite"
 
 // RMGroupJoin instructs inviter that a user did or did not join the group.
@@ -692,6 +940,16 @@ type RMGroupJoin struct {
 
 const RMCGroupJoin = "groupjoin"
 
+// RMGroupJoinRejected is sent by a GC admin to a user whose RMGroupJoin was
+// rejected (for example, because the invite expired or was revoked).
+type RMGroupJoinRejected struct {
+	ID     zkidentity.ShortID `json:"id"`     // group id
+	Token  uint64             `json:"token"`  // invite token
+	Reason string             `json:"reason"` // why the join was rejected
+}
+
+const RMCGroupJoinRejected = "groupjoinrejected"
+
 // RMGroupPart is sent to tell the group chat that a user has departed.
 type RMGroupPart struct {
 	// XXX who sent this?
@@ -729,9 +987,71 @@ type RMGroupUpdate struct {
 
 const RMCGroupUpdate = "groupupdate"
 
-// RMGroupList, currently we detect spoofing by ensuring the origin of the
-// message.  This may not be sufficient and we may have to add a signature of
-// sorts.  For now roll with this assumption.
+// RMGroupUpdateRequest is sent by a member to an admin of the GC when it
+// detects its list may be out of sync. Generation is the generation of the
+// list of the requesting member. The admin replies with an RMGroupUpdate.
+type RMGroupUpdateRequest struct {
+	ID         zkidentity.ShortID `json:"id"`
+	Generation uint64             `json:"generation"`
+}
+
+const RMCGroupUpdateRequest = "groupupdaterequest"
+
+// GroupSenderKeySize is the size of the keys shared in RMGroupSenderKey.
+const GroupSenderKeySize = 32
+
+// RMGroupSenderKey is sent by a GC member to the other members to share the key
+// it uses to encrypt the messages it pushes to the group RVs of the GC. A single
+// copy of each message is pushed to the RV derived from the key and the
+// message counter, instead of one copy per member. The key is rotated (and the
+// epoch incremented) when a member is removed from the GC.
+type RMGroupSenderKey struct {
+	ID      zkidentity.ShortID `json:"id"`
+	Epoch   uint64             `json:"epoch"`
+	Key     []byte             `json:"key"`
+	Counter uint64             `json:"counter"` // Next counter to be used
+}
+
+// hash returns the hash of the key, counter and the given
+// domain separation tag.
+func (sk *RMGroupSenderKey) hash(tag string, counter uint64) [32]byte {
+	h := blake256.New()
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], counter)
+	h.Write([]byte(tag))
+	h.Write(sk.Key)
+	h.Write(b[:])
+
+	var res [32]byte
+	copy(res[:], h.Sum(nil))
+	return res
+}
+
+// SharedRVKey returns the key of the shared RV where the message with the
+// given counter is pushed.
+func (sk *RMGroupSenderKey) SharedRVKey(counter uint64) [32]byte {
+	return sk.hash("rv", counter)
+}
+
+// RV returns the RV where the message with the given counter is pushed.
+func (sk *RMGroupSenderKey) RV(counter uint64) ratchet.RVPoint {
+	key := sk.SharedRVKey(counter)
+	return SharedRV(&key)
+}
+
+// MsgKey returns the key used to encrypt the message with the given counter.
+func (sk *RMGroupSenderKey) MsgKey(counter uint64) *[32]byte {
+	key := sk.hash("key", counter)
+	return &key
+}
+
+const RMCGroupSenderKey = "groupsenderkey"
+
+// RMGroupList is the list of members of a GC. The list is signed by the admin
+// that last modified it, so that members may forward the list to third parties
+// (such as late joiners) that can verify it without trusting the relaying
+// user. Lists from older clients are not signed, in which case spoofing is
+// detected by ensuring the origin of the message is an admin.
 type RMGroupList struct {
 	ID         zkidentity.ShortID `json:"id"` // group id
 	Name       string             `json:"name"`
@@ -741,16 +1061,237 @@ type RMGroupList struct {
 	// all participants, [0] is administrator
 	// receiver must check [0] == originator
 	Members []zkidentity.ShortID `json:"members"`
+
+	// ExtraAdmins are the members, besides the owner (Members[0]), that
+	// may invite and kick members and send updated lists. Moderators may
+	// only kick members that have no role in the GC.
+	ExtraAdmins []zkidentity.ShortID `json:"extra_admins,omitempty"`
+	Moderators  []zkidentity.ShortID `json:"moderators,omitempty"`
+
+	// AdminsGeneration is incremented every time the owner or the sets of
+	// admins and moderators change. AdminsSig is the signature by the
+	// owner that made the change over AdminsHash().
+	AdminsGeneration uint64                        `json:"admins_generation,omitempty"`
+	AdminsSig        zkidentity.FixedSizeSignature `json:"admins_sig"`
+
+	// Signer is the admin (or moderator, for lists sent in kicks) that
+	// produced this list and Signature is its signature over SignedHash().
+	Signer    zkidentity.ShortID            `json:"signer"`
+	Signature zkidentity.FixedSizeSignature `json:"signature"`
+}
+
+// isMember returns true if uid is in the list of members of the GC.
+func (gl *RMGroupList) isMember(uid zkidentity.ShortID) bool {
+	for i := range gl.Members {
+		if gl.Members[i] == uid {
+			return true
+		}
+	}
+	return false
+}
+
+// IsOwner returns true if uid is the owner of the GC.
+func (gl *RMGroupList) IsOwner(uid zkidentity.ShortID) bool {
+	return len(gl.Members) > 0 && gl.Members[0] == uid
+}
+
+// IsAdmin returns true if uid is the owner or one of the extra admins of the
+// GC.
+func (gl *RMGroupList) IsAdmin(uid zkidentity.ShortID) bool {
+	if gl.IsOwner(uid) {
+		return true
+	}
+	for i := range gl.ExtraAdmins {
+		if gl.ExtraAdmins[i] == uid {
+			return gl.isMember(uid)
+		}
+	}
+	return false
+}
+
+// IsModerator returns true if uid is one of the moderators of the GC.
+func (gl *RMGroupList) IsModerator(uid zkidentity.ShortID) bool {
+	for i := range gl.Moderators {
+		if gl.Moderators[i] == uid {
+			return gl.isMember(uid)
+		}
+	}
+	return false
+}
+
+// CanKick returns true if uid may kick member from the GC. Admins may kick any
+// member except the owner and moderators may kick members without a role.
+func (gl *RMGroupList) CanKick(uid, member zkidentity.ShortID) bool {
+	switch {
+	case gl.IsOwner(member):
+		return false
+	case gl.IsOwner(uid):
+		return true
+	case gl.IsAdmin(member):
+		return false
+	case gl.IsAdmin(uid):
+		return true
+	case gl.IsModerator(member):
+		return false
+	default:
+		return gl.IsModerator(uid)
+	}
+}
+
+// SignedHash returns the hash of the contents of the list that are signed by
+// the Signer.
+func (gl *RMGroupList) SignedHash() [32]byte {
+	h := blake256.New()
+	var b [8]byte
+	writeUint64 := func(v uint64) {
+		binary.LittleEndian.PutUint64(b[:], v)
+		h.Write(b[:])
+	}
+
+	h.Write(gl.ID[:])
+	writeUint64(uint64(len(gl.Name)))
+	h.Write([]byte(gl.Name))
+	writeUint64(gl.Generation)
+	writeUint64(uint64(gl.Timestamp))
+	writeUint64(uint64(len(gl.Members)))
+	for i := range gl.Members {
+		h.Write(gl.Members[i][:])
+	}
+	adminsHash := gl.AdminsHash()
+	h.Write(adminsHash[:])
+	h.Write(gl.AdminsSig[:])
+	h.Write(gl.Signer[:])
+
+	var res [32]byte
+	copy(res[:], h.Sum(nil))
+	return res
+}
+
+// AdminsHash returns the hash of the owner and the sets of admins and
+// moderators of the GC. This is the data signed in AdminsSig.
+func (gl *RMGroupList) AdminsHash() [32]byte {
+	h := blake256.New()
+	var b [8]byte
+	writeIDs := func(ids []zkidentity.ShortID) {
+		binary.LittleEndian.PutUint64(b[:], uint64(len(ids)))
+		h.Write(b[:])
+		for i := range ids {
+			h.Write(ids[i][:])
+		}
+	}
+
+	h.Write(gl.ID[:])
+	binary.LittleEndian.PutUint64(b[:], gl.AdminsGeneration)
+	h.Write(b[:])
+	var owner zkidentity.ShortID
+	if len(gl.Members) > 0 {
+		owner = gl.Members[0]
+	}
+	h.Write(owner[:])
+	writeIDs(gl.ExtraAdmins)
+	writeIDs(gl.Moderators)
+
+	var res [32]byte
+	copy(res[:], h.Sum(nil))
+	return res
 }
 
 const RMCGroupList = "grouplist"
 
+// RMGroupMetadata is the descriptive metadata of a GC, managed by its admins.
+// Like RMGroupList, it is signed by the admin that last modified it.
+type RMGroupMetadata struct {
+	ID         zkidentity.ShortID `json:"id"`         // group id
+	Generation uint64             `json:"generation"` // incremented every time metadata changes
+	Timestamp  int64              `json:"timestamp"`  // unix time last generation changed
+
+	Topic       string `json:"topic"`
+	Description string `json:"description"`
+	Rules       string `json:"rules,omitempty"`
+
+	// AvatarHash is the hash of the avatar image of the GC, if any.
+	AvatarHash []byte `json:"avatar_hash,omitempty"`
+
+	// Pinned are the MsgIDs of the pinned messages of the GC.
+	Pinned []zkidentity.ShortID `json:"pinned,omitempty"`
+
+	Signer    zkidentity.ShortID            `json:"signer"`
+	Signature zkidentity.FixedSizeSignature `json:"signature"`
+}
+
+// IsPinned returns true if the given message is pinned in the GC.
+func (md *RMGroupMetadata) IsPinned(msgID zkidentity.ShortID) bool {
+	for i := range md.Pinned {
+		if md.Pinned[i] == msgID {
+			return true
+		}
+	}
+	return false
+}
+
+// SignedHash returns the hash of the contents of the metadata that are signed
+// by the Signer.
+func (md *RMGroupMetadata) SignedHash() [32]byte {
+	h := blake256.New()
+	var b [8]byte
+	writeUint64 := func(v uint64) {
+		binary.LittleEndian.PutUint64(b[:], v)
+		h.Write(b[:])
+	}
+	writeBytes := func(s []byte) {
+		writeUint64(uint64(len(s)))
+		h.Write(s)
+	}
+
+	h.Write(md.ID[:])
+	writeUint64(md.Generation)
+	writeUint64(uint64(md.Timestamp))
+	writeBytes([]byte(md.Topic))
+	writeBytes([]byte(md.Description))
+	writeBytes([]byte(md.Rules))
+	writeBytes(md.AvatarHash)
+	writeUint64(uint64(len(md.Pinned)))
+	for i := range md.Pinned {
+		h.Write(md.Pinned[i][:])
+	}
+	h.Write(md.Signer[:])
+
+	var res [32]byte
+	copy(res[:], h.Sum(nil))
+	return res
+}
+
+const RMCGroupMetadata = "groupmetadata"
+
 // RMGroupMessage is a message to a group.
 type RMGroupMessage struct {
 	ID         zkidentity.ShortID `json:"id"`         // group name
 	Generation uint64             `json:"generation"` // Generation used
 	Message    string             `json:"message"`    // Actual message
 	Mode       MessageMode        `json:"mode"`       // 0 regular mode, 1 /me
+
+	// MsgID is a random identifier of the message, used to reference it
+	// in edits, retractions and replies. Messages sent by older clients
+	// have an empty MsgID.
+	MsgID zkidentity.ShortID `json:"msg_id"`
+
+	// ReplyTo is the MsgID of the message this message replies to, if
+	// any.
+	ReplyTo zkidentity.ShortID `json:"reply_to"`
+
+	// Mentions are the ids of the GC members explicitly mentioned in the
+	// message.
+	Mentions []zkidentity.ShortID `json:"mentions,omitempty"`
+}
+
+// IsMentioned returns true if the given user is mentioned in the message.
+func (gcm *RMGroupMessage) IsMentioned(uid zkidentity.ShortID) bool {
+	for i := range gcm.Mentions {
+		if gcm.Mentions[i] == uid {
+			return true
+		}
+	}
+	return false
 }
 
 const RMCGroupMessage = "groupmessage"
@@ -775,8 +1316,24 @@ type FileManifest struct {
 	Index uint64 `json:"index"`
 	Size  uint64 `json:"size"`
 	Hash  []byte `json:"hash"`
+
+	// Offset is the offset of the chunk in the file. Only filled in
+	// metadata of version 2 and higher.
+	Offset uint64 `json:"offset,omitempty"`
 }
 
+const (
+	// FileChunkingFixed is the chunking mode where all chunks of a file
+	// (except the last one) have the same size.
+	FileChunkingFixed = ""
+
+	// FileChunkingContentDefined is the chunking mode where the chunk
+	// boundaries are defined by a rolling hash of the file contents, such
+	// that changes to a file only change the chunks around the changed
+	// data.
+	FileChunkingContentDefined = "cdc"
+)
+
 type FileMetadata struct {
 	Version     uint64            `json:"version"`
 	Cost        uint64            `json:"cost"`
@@ -788,9 +1345,22 @@ type FileMetadata struct {
 	Manifest    []FileManifest    `json:"manifest"` // len == number of chunks
 	Signature   string            `json:"signature"`
 	Attributes  map[string]string `json:"attributes,omitempty"`
+
+	// Chunking is the mode used to chunk the file. Only set in metadata
+	// of version 2 and higher.
+	Chunking string `json:"chunking,omitempty"`
 }
 
-const FileMetadataVersion = 1
+const (
+	// FileMetadataVersionFixedChunks is the version of the metadata of
+	// files chunked with FileChunkingFixed.
+	FileMetadataVersionFixedChunks = 1
+
+	// FileMetadataVersion is the current version of the file metadata.
+	// Version 2 adds the chunking mode and the chunk offsets, which are
+	// also committed to by the metadata hash.
+	FileMetadataVersion = 2
+)
 
 // MetadataHash calculates the hash of the metadata info. Note that the specific
 // information that is hashed depends on the version of the metadata.
@@ -815,11 +1385,57 @@ func (fm *FileMetadata) MetadataHash() [32]byte {
 
 	// In the future, add new fields conditional on the metadata version so
 	// that old versions will still calculate the same hash.
+	if fm.Version >= 2 {
+		writeStr(fm.Chunking)
+		writeUint64(uint64(len(fm.Manifest)))
+		for _, ch := range fm.Manifest {
+			writeUint64(ch.Index)
+			writeUint64(ch.Offset)
+			writeUint64(ch.Size)
+			h.Write(ch.Hash)
+		}
+	}
 
 	copy(b[:], h.Sum(nil))
 	return b
 }
 
+// VerifyManifest verifies the manifest of the file is consistent with its
+// size and version.
+func (fm *FileMetadata) VerifyManifest() error {
+	switch {
+	case fm.Version < 2 && fm.Chunking != FileChunkingFixed:
+		return fmt.Errorf("chunking mode %q not supported in metadata "+
+			"version %d", fm.Chunking, fm.Version)
+	case fm.Chunking != FileChunkingFixed && fm.Chunking != FileChunkingContentDefined:
+		return fmt.Errorf("unknown chunking mode %q", fm.Chunking)
+	}
+
+	var offset uint64
+	for i, ch := range fm.Manifest {
+		if ch.Index != uint64(i) {
+			return fmt.Errorf("chunk %d has index %d", i, ch.Index)
+		}
+		if fm.Version >= 2 && ch.Offset != offset {
+			return fmt.Errorf("chunk %d has offset %d (want %d)",
+				i, ch.Offset, offset)
+		}
+		if ch.Size == 0 || ch.Size > MaxChunkSize {
+			return fmt.Errorf("chunk %d has invalid size %d", i, ch.Size)
+		}
+		if len(ch.Hash) != sha256.Size {
+			return fmt.Errorf("chunk %d has invalid hash length %d",
+				i, len(ch.Hash))
+		}
+		offset += ch.Size
+	}
+	if offset != fm.Size {
+		return fmt.Errorf("sum of chunk sizes %d != file size %d",
+			offset, fm.Size)
+	}
+	return nil
+}
+
 type RMFTListReply struct {
 	Global []FileMetadata `json:"global,omitempty"`
 	Shared []FileMetadata `json:"shared,omitempty"`
@@ -886,6 +1502,49 @@ type RMFTSendFile struct {
 
 const RMCFTSendFile = "ftsendfile"
 
+// RMFTFindSources asks a remote user whether it shares a file with the given
+// content hash, so that chunks of the file may be downloaded from it.
+type RMFTFindSources struct {
+	Hash string `json:"hash"` // Equals FileMetadata.Hash
+}
+
+const RMCFTFindSources = "ftfindsources"
+
+// RMFTFindSourcesReply lists the metadata of the files shared by the remote
+// user that have the requested content hash.
+type RMFTFindSourcesReply struct {
+	Hash  string         `json:"hash"`
+	Files []FileMetadata `json:"files"`
+}
+
+const RMCFTFindSourcesReply = "ftfindsourcesreply"
+
+// SharedFolderFile is a file inside a shared folder. The full metadata of the
+// file is fetched with RMFTGet.
+type SharedFolderFile struct {
+	Path   string `json:"path"` // Slash-separated, relative to the folder
+	FileID string `json:"file_id"`
+	Hash   string `json:"hash"` // Equals FileMetadata.Hash
+	Size   uint64 `json:"size"`
+	Cost   uint64 `json:"cost"`
+}
+
+// RMFTSharedFolder is the manifest of a folder shared by the remote user. It
+// is sent whenever the contents of the folder change. Version increases on
+// every change to the folder.
+type RMFTSharedFolder struct {
+	ID      zkidentity.ShortID  `json:"id"`
+	Name    string              `json:"name"`
+	Version uint64              `json:"version"`
+	GC      *zkidentity.ShortID `json:"gc,omitempty"`
+	Files   []SharedFolderFile  `json:"files"`
+
+	// Removed is set when the folder is no longer shared.
+	Removed bool `json:"removed,omitempty"`
+}
+
+const RMCFTSharedFolder = "ftsharedfolder"
+
 // RMUser retrieves user attributes such as status, profile etc. Attributes is a
 // key value store that is used to describe the user attributes.
 type RMUser struct{}
@@ -941,12 +1600,43 @@ const RMCPostStatus = "poststatus"
 const RMCPostStatusReply = "poststatusreply"
 
 const (
-	RMPSHeart    = "heart"   // Heart a post
-	RMPSComment  = "comment" // Comment on a post
-	RMPSHeartYes = "1"       // +1 heart
-	RMPSHeartNo  = "0"       // -1 heart
+	RMPSHeart    = "heart"    // Heart a post
+	RMPSComment  = "comment"  // Comment on a post
+	RMPSReaction = "reaction" // React to a post
+	RMPSHeartYes = "1"        // +1 heart
+	RMPSHeartNo  = "0"        // -1 heart
+
+	RMPSReactionAdd    = "+" // Prefix of reactions being added
+	RMPSReactionRemove = "-" // Prefix of reactions being removed
 )
 
+// PostReactionValue returns the value of the RMPSReaction attribute of a post
+// status that adds (or removes, if remove is true) the given reaction.
+func PostReactionValue(reaction string, remove bool) string {
+	if remove {
+		return RMPSReactionRemove + reaction
+	}
+	return RMPSReactionAdd + reaction
+}
+
+// ParsePostReactionValue parses the value of the RMPSReaction attribute of a
+// post status.
+func ParsePostReactionValue(v string) (reaction string, remove bool, err error) {
+	switch {
+	case strings.HasPrefix(v, RMPSReactionAdd):
+		reaction = v[len(RMPSReactionAdd):]
+	case strings.HasPrefix(v, RMPSReactionRemove):
+		reaction = v[len(RMPSReactionRemove):]
+		remove = true
+	default:
+		return "", false, fmt.Errorf("reaction %q does not have a valid prefix", v)
+	}
+	if !IsValidReaction(reaction) {
+		return "", false, fmt.Errorf("invalid reaction %q", reaction)
+	}
+	return reaction, remove, nil
+}
+
 // RMPostSubscribe subscribes to new posts from a user.
 type RMPostsSubscribe struct{}
 
@@ -1054,12 +1744,21 @@ func (pm *PostMetadataStatus) Hash() [32]byte {
 		binary.LittleEndian.PutUint64(b[:], i)
 		h.Write(b[:])
 	}
+	wstr := func(s string) {
+		// Starting with the reactions version, values are prefixed
+		// with their length, so that a value cannot be moved to a
+		// different attribute.
+		if pm.Version >= PostMetadataStatusReactionVersion {
+			writeUint64(uint64(len(s)))
+		}
+		h.Write([]byte(s))
+	}
 	wattr := func(key string) {
-		h.Write([]byte(pm.Attributes[key]))
+		wstr(pm.Attributes[key])
 	}
 
 	writeUint64(pm.Version)
-	h.Write([]byte(pm.From))
+	wstr(pm.From)
 	wattr(RMPIdentifier) // Identifier is the parent post.
 	wattr(RMPDescription)
 	wattr(RMPMain)
@@ -1067,6 +1766,9 @@ func (pm *PostMetadataStatus) Hash() [32]byte {
 	wattr(RMPParent)
 	wattr(RMPSHeart)
 	wattr(RMPSComment)
+	if pm.Version >= PostMetadataStatusReactionVersion {
+		wattr(RMPSReaction)
+	}
 	wattr(RMPNonce)
 
 	// RMPFromNick is not added because it's filled by post sharer.
@@ -1082,12 +1784,18 @@ func (pm *PostMetadataStatus) Hash() [32]byte {
 
 const PostMetadataStatusVersion = 1
 
+// PostMetadataStatusReactionVersion is the version of post status updates
+// that react to a post. Reactions are only part of the hash (and therefore
+// signed) starting with this version.
+const PostMetadataStatusReactionVersion = 2
+
 // IsPostStatus returns true when the map of attributes (possibly) corresponds
 // to a post status update.
 func IsPostStatus(attrs map[string]string) bool {
 	// The current version of post status does not have a differentiating
 	// entry between status and post, so we infer based on the presence of
-	// either a comment or heart entry, which are the currently supported
-	// status updates.
-	return attrs[RMPSComment] != "" || attrs[RMPSHeart] != ""
+	// either a comment, heart or reaction entry, which are the currently
+	// supported status updates.
+	return attrs[RMPSComment] != "" || attrs[RMPSHeart] != "" ||
+		attrs[RMPSReaction] != ""
 }
@@ -5,6 +5,7 @@ import (
 	"compress/zlib"
 	"encoding/hex"
 	"errors"
+	"strings"
 	"testing"
 )
 
@@ -327,7 +328,7 @@ func TestMetadataStatusHash(t *testing.T) {
 	}, {
 		name:     "big version",
 		pms:      PostMetadataStatus{Version: 0xac5be174813d6559},
-		wantHash: "77c4e7a2c54f60f73b742f2cc654dc3e1770a863b76cfd9b10dfbb06179dd449",
+		wantHash: "1d7e226fa7885f47be96c927722d0c25e42689ad52a9f53a327b36ed4cfb69f9",
 	}, {
 		name:     "v1 with from",
 		pms:      PostMetadataStatus{Version: 1, From: "0001020304"},
@@ -356,6 +357,33 @@ func TestMetadataStatusHash(t *testing.T) {
 			},
 		},
 		wantHash: "e280e0cd9347f3ec8a29e1b9e80c634d92ca9eb6557eac88651edcf183e7a45d",
+	}, {
+		// Reactions are not hashed in v1.
+		name: "v1 with reaction",
+		pms: PostMetadataStatus{
+			Version:    1,
+			Attributes: map[string]string{RMPSReaction: "+👍"},
+		},
+		wantHash: "8ea40918f0472ddddd8ee06fabfebcdeca0cad2fe4a069c7e4172b819c2ee507",
+	}, {
+		name:     "empty v2 pms",
+		pms:      PostMetadataStatus{Version: 2},
+		wantHash: "295d7b8d5d58b536690ea519ac268276004c966d73dd91308812ced256fd1fcc",
+	}, {
+		name: "v2 with reaction",
+		pms: PostMetadataStatus{
+			Version:    2,
+			Attributes: map[string]string{RMPSReaction: "+👍"},
+		},
+		wantHash: "4fd3b0acaaac45d0d75b15a89d1818e367cbc6bb234009464ced565296e75948",
+	}, {
+		// Differs from the reaction with the same value.
+		name: "v2 with comment",
+		pms: PostMetadataStatus{
+			Version:    2,
+			Attributes: map[string]string{RMPSComment: "+👍"},
+		},
+		wantHash: "f6a4c9c06dd5f0d96ecb77bbb246404a81edd735542a7cdbcefa9b3d57b506b4",
 	}}
 
 	for _, tc := range tests {
@@ -370,3 +398,125 @@ func TestMetadataStatusHash(t *testing.T) {
 		})
 	}
 }
+
+func TestParsePostReactionValue(t *testing.T) {
+	tests := []struct {
+		name       string
+		v          string
+		wantReact  string
+		wantRemove bool
+		wantErr    bool
+	}{
+		{name: "add emoji", v: "+👍", wantReact: "👍"},
+		{name: "remove emoji", v: "-👍", wantReact: "👍", wantRemove: true},
+		{name: "short code", v: "+:heart:", wantReact: ":heart:"},
+		{name: "no prefix", v: "👍", wantErr: true},
+		{name: "empty reaction", v: "+", wantErr: true},
+		{name: "whitespace", v: "+a b", wantErr: true},
+		{name: "too long", v: "+" + strings.Repeat("a", MaxReactionLen+1), wantErr: true},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			react, remove, err := ParsePostReactionValue(tc.v)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("unexpected success parsing %q", tc.v)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if react != tc.wantReact || remove != tc.wantRemove {
+				t.Fatalf("unexpected result: got (%q, %v), want (%q, %v)",
+					react, remove, tc.wantReact, tc.wantRemove)
+			}
+			if v := PostReactionValue(react, remove); v != tc.v {
+				t.Fatalf("unexpected value: got %q, want %q", v, tc.v)
+			}
+		})
+	}
+}
+
+func TestFileMetadataManifest(t *testing.T) {
+	hash := bytes.Repeat([]byte{0x01}, 32)
+	newMD := func(version uint64, chunking string, sizes ...uint64) FileMetadata {
+		fm := FileMetadata{Version: version, Chunking: chunking}
+		for i, size := range sizes {
+			fm.Manifest = append(fm.Manifest, FileManifest{
+				Index:  uint64(i),
+				Offset: fm.Size,
+				Size:   size,
+				Hash:   hash,
+			})
+			fm.Size += size
+		}
+		return fm
+	}
+
+	tests := []struct {
+		name    string
+		fm      FileMetadata
+		wantErr bool
+	}{{
+		name: "v1 fixed chunks",
+		fm:   newMD(FileMetadataVersionFixedChunks, FileChunkingFixed, 8, 8, 3),
+	}, {
+		name: "v2 content-defined chunks",
+		fm:   newMD(FileMetadataVersion, FileChunkingContentDefined, 5, 8, 2),
+	}, {
+		name:    "content-defined chunks in v1",
+		fm:      newMD(FileMetadataVersionFixedChunks, FileChunkingContentDefined, 5),
+		wantErr: true,
+	}, {
+		name:    "unknown chunking",
+		fm:      newMD(FileMetadataVersion, "other", 5),
+		wantErr: true,
+	}, {
+		name: "wrong size",
+		fm: func() FileMetadata {
+			fm := newMD(FileMetadataVersion, FileChunkingContentDefined, 5, 8)
+			fm.Size++
+			return fm
+		}(),
+		wantErr: true,
+	}, {
+		name: "wrong offset",
+		fm: func() FileMetadata {
+			fm := newMD(FileMetadataVersion, FileChunkingContentDefined, 5, 8)
+			fm.Manifest[1].Offset++
+			return fm
+		}(),
+		wantErr: true,
+	}, {
+		name:    "empty chunk",
+		fm:      newMD(FileMetadataVersion, FileChunkingContentDefined, 5, 0),
+		wantErr: true,
+	}}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.fm.VerifyManifest()
+			if tc.wantErr != (err != nil) {
+				t.Fatalf("unexpected error: got %v, want error %v",
+					err, tc.wantErr)
+			}
+		})
+	}
+
+	// The hash of v1 metadata does not commit to the manifest, while the
+	// hash of v2 metadata does.
+	for _, version := range []uint64{FileMetadataVersionFixedChunks, FileMetadataVersion} {
+		fm := newMD(version, FileChunkingFixed, 8, 8)
+		hash := fm.MetadataHash()
+		fm.Manifest[1].Hash = bytes.Repeat([]byte{0x02}, 32)
+		changed := fm.MetadataHash() != hash
+		if changed != (version >= 2) {
+			t.Fatalf("unexpected hash change in version %d: %v",
+				version, changed)
+		}
+	}
+}
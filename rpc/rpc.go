@@ -18,6 +18,7 @@ import (
 	"time"
 
 	"github.com/companyzero/bisonrelay/ratchet"
+	"github.com/decred/dcrd/crypto/blake256"
 )
 
 type MessageMode uint32
@@ -109,6 +110,40 @@ type RouteMessageReply struct {
 type SubscribeRoutedMessages struct {
 	AddRendezvous []ratchet.RVPoint // Add to subscribed RVs
 	DelRendezvous []ratchet.RVPoint // Del from subscribed RVs
+
+	// AddSharedRendezvous are added to the subscribed RVs as shared RVs.
+	// Shared RVs may be subscribed by multiple sessions at the same time
+	// and their payload is not removed after being ack'd (it is only
+	// expired). Only used if the server sends the PropSharedRVs property.
+	AddSharedRendezvous []SharedRendezvous `json:",omitempty"`
+}
+
+// SharedRendezvous is a subscription to a shared RV.
+type SharedRendezvous struct {
+	// Key is the preimage of the shared RV (see SharedRV). Subscribing
+	// with the preimage ensures sessions may only subscribe to shared RVs
+	// in a namespace that does not collide with the RVs of ratchets.
+	Key [32]byte `json:"key"`
+
+	// Subscriber identifies the subscriber across sessions, so that
+	// payloads it has already ack'd are not pushed to it again.
+	Subscriber [32]byte `json:"subscriber"`
+}
+
+// RV returns the shared RV of the subscription.
+func (s *SharedRendezvous) RV() ratchet.RVPoint {
+	return SharedRV(&s.Key)
+}
+
+// SharedRV returns the shared RV derived from the given key.
+func SharedRV(key *[32]byte) ratchet.RVPoint {
+	h := blake256.New()
+	h.Write([]byte("sharedrv"))
+	h.Write(key[:])
+
+	var rv ratchet.RVPoint
+	copy(rv[:], h.Sum(nil))
+	return rv
 }
 
 type SubscribeRoutedMessagesReply struct {
@@ -202,6 +237,11 @@ const (
 	// from the server automatically.
 	PropExpirationDays        = "expirationdays"
 	PropExpirationDaysDefault = 7
+
+	// PropSharedRVs signals the server supports subscribing to shared RVs
+	// (see SubscribeRoutedMessages.AddSharedRendezvous).
+	PropSharedRVs        = "sharedrvs"
+	PropSharedRVsDefault = "1"
 )
 
 var (
@@ -245,6 +285,11 @@ var (
 		Value:    "",
 		Required: false,
 	}
+	DefaultPropSharedRVs = ServerProperty{
+		Key:      PropSharedRVs,
+		Value:    PropSharedRVsDefault,
+		Required: false,
+	}
 
 	// All properties must exist in this array.
 	SupportedServerProperties = []ServerProperty{
@@ -260,6 +305,7 @@ var (
 
 		// optional
 		DefaultPropServerLNNode,
+		DefaultPropSharedRVs,
 	}
 )
 
@@ -15,6 +15,54 @@ import (
 	"github.com/companyzero/bisonrelay/server/serverdb"
 )
 
+// sharedRVAcks tracks the subscribers that ack'd the payload of a shared RV.
+type sharedRVAcks struct {
+	firstAck    time.Time
+	subscribers map[[32]byte]struct{}
+}
+
+// ackSharedRV records that the subscriber ack'd the payload of the shared RV.
+// The payload itself is not removed, because other subscribers may still
+// fetch it.
+func (z *ZKS) ackSharedRV(rv ratchet.RVPoint, subscriber [32]byte) {
+	z.Lock()
+	acks := z.sharedAcks[rv]
+	if acks == nil {
+		acks = &sharedRVAcks{
+			firstAck:    z.now(),
+			subscribers: make(map[[32]byte]struct{}),
+		}
+		z.sharedAcks[rv] = acks
+	}
+	acks.subscribers[subscriber] = struct{}{}
+	z.Unlock()
+}
+
+// isSharedRVAcked returns true if the subscriber already ack'd the payload of
+// the shared RV.
+func (z *ZKS) isSharedRVAcked(rv ratchet.RVPoint, subscriber [32]byte) bool {
+	z.Lock()
+	defer z.Unlock()
+	acks := z.sharedAcks[rv]
+	if acks == nil {
+		return false
+	}
+	_, ok := acks.subscribers[subscriber]
+	return ok
+}
+
+// expireSharedAcks forgets the acks of shared RVs first ack'd before the given
+// date (whose payloads have already been expired).
+func (z *ZKS) expireSharedAcks(date time.Time) {
+	z.Lock()
+	for rv, acks := range z.sharedAcks {
+		if acks.firstAck.Before(date) {
+			delete(z.sharedAcks, rv)
+		}
+	}
+	z.Unlock()
+}
+
 // maybePushRM pushes the given RM to the appropriate session if there is an
 // online session that is expecting it.
 func (z *ZKS) maybePushRM(r rpc.RouteMessage) {
@@ -24,6 +72,9 @@ func (z *ZKS) maybePushRM(r rpc.RouteMessage) {
 	if sc, ok := z.subscribers[r.Rendezvous]; ok {
 		sc.msgC <- r.Rendezvous
 	}
+	for sc := range z.sharedSubscribers[r.Rendezvous] {
+		sc.msgC <- r.Rendezvous
+	}
 	z.Unlock()
 }
 
@@ -101,6 +152,18 @@ func (z *ZKS) handleSubscribeRoutedMessages(ctx context.Context, msg rpc.Message
 
 	var payload rpc.SubscribeRoutedMessagesReply
 
+	if len(r.AddSharedRendezvous) > 0 && !z.settings.SharedRVs {
+		payload.Error = "shared RVs are not allowed"
+		sc.writer <- &RPCWrapper{
+			Message: rpc.Message{
+				Command: rpc.TaggedCmdSubscribeRoutedMessagesReply,
+				Tag:     msg.Tag,
+			},
+			Payload: payload,
+		}
+		return nil
+	}
+
 	if err := z.areSubsPaid(ctx, &r, sc); errors.Is(err, rpc.ErrUnpaidSubscriptionRV{}) {
 		// This specific error (unpaid RV) is returned to the client and
 		// then the client session is forcibly closed.
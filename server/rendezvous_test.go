@@ -9,6 +9,7 @@ import (
 	"github.com/companyzero/bisonrelay/client/clientintf"
 	"github.com/companyzero/bisonrelay/ratchet"
 	"github.com/companyzero/bisonrelay/rpc"
+	"github.com/companyzero/bisonrelay/session"
 	"github.com/decred/slog"
 )
 
@@ -83,3 +84,114 @@ func TestIgnoresDupeRVPush(t *testing.T) {
 		// Success.
 	}
 }
+
+// TestSharedRVAcks ensures the payload of a shared RV is pushed to every
+// subscriber, except to subscribers that already ack'd it.
+func TestSharedRVAcks(t *testing.T) {
+	svr := newTestServer(t)
+	errChan := runTestServer(t, svr)
+	addr := serverBoundAddr(t, svr)
+	dialer := clientintf.NetDialer(addr, slog.Disabled)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	// connect returns a new session with the server and a chan where the
+	// messages pushed by the server are written.
+	connect := func() (*session.KX, chan rpc.Message) {
+		t.Helper()
+		conn, _, err := dialer(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		kx := kxServerConn(t, conn)
+		pushes := make(chan rpc.Message, 10)
+		go func() {
+			for {
+				rawMsg, err := kx.Read()
+				if err != nil {
+					return
+				}
+				msg, _ := decodeServerMsg(t, rawMsg)
+				if msg.Command == rpc.TaggedCmdPushRoutedMessage {
+					pushes <- msg
+				}
+			}
+		}()
+		return kx, pushes
+	}
+	subscribe := func(kx *session.KX, subs rpc.SubscribeRoutedMessages) {
+		t.Helper()
+		msg := rpc.Message{Command: rpc.TaggedCmdSubscribeRoutedMessages}
+		writeServerMsg(t, kx, msg, subs)
+	}
+	assertPushed := func(pushes chan rpc.Message) rpc.Message {
+		t.Helper()
+		select {
+		case msg := <-pushes:
+			return msg
+		case err := <-errChan:
+			t.Fatalf("unexpected run() error: %v", err)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timeout waiting for pushed message")
+		}
+		return rpc.Message{}
+	}
+	assertNotPushed := func(pushes chan rpc.Message) {
+		t.Helper()
+		select {
+		case <-pushes:
+			t.Fatalf("unexpected pushed message")
+		case <-time.After(time.Second):
+		}
+	}
+
+	key := [32]byte{31: 0x01}
+	rv := rpc.SharedRV(&key)
+	subA := rpc.SharedRendezvous{Key: key, Subscriber: [32]byte{0: 0x0a}}
+	subB := rpc.SharedRendezvous{Key: key, Subscriber: [32]byte{0: 0x0b}}
+
+	// A subscribes to the shared RV, then C may not subscribe to it as a
+	// regular RV.
+	kxA, pushesA := connect()
+	subscribe(kxA, rpc.SubscribeRoutedMessages{
+		AddSharedRendezvous: []rpc.SharedRendezvous{subA},
+	})
+	kxC, pushesC := connect()
+	subscribe(kxC, rpc.SubscribeRoutedMessages{
+		AddRendezvous: []ratchet.RVPoint{rv},
+	})
+	time.Sleep(100 * time.Millisecond)
+
+	// Push a payload to the shared RV. Only A receives it.
+	rm := rpc.RouteMessage{
+		Rendezvous: rv,
+		Message:    []byte{0x01, 0x02, 0x03},
+	}
+	writeServerMsg(t, kxC, rpc.Message{Command: rpc.TaggedCmdRouteMessage, Tag: 1}, rm)
+	push := assertPushed(pushesA)
+	assertNotPushed(pushesC)
+
+	// A acks the payload.
+	ack := rpc.Message{Command: rpc.TaggedCmdAcknowledge, Tag: push.Tag}
+	writeServerMsg(t, kxA, ack, rpc.Acknowledge{})
+	for i := 0; !svr.isSharedRVAcked(rv, subA.Subscriber); i++ {
+		if i > 100 {
+			t.Fatalf("timeout waiting for ack")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The payload is not pushed again when A resubscribes on a new
+	// session, but it is pushed to B.
+	kxA2, pushesA2 := connect()
+	subscribe(kxA2, rpc.SubscribeRoutedMessages{
+		AddSharedRendezvous: []rpc.SharedRendezvous{subA},
+	})
+	kxB, pushesB := connect()
+	subscribe(kxB, rpc.SubscribeRoutedMessages{
+		AddSharedRendezvous: []rpc.SharedRendezvous{subB},
+	})
+	assertPushed(pushesB)
+	assertNotPushed(pushesA2)
+}
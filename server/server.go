@@ -58,6 +58,14 @@ type ZKS struct {
 	// subscribers track which session is subscribed to which RVPoint.
 	subscribers map[ratchet.RVPoint]*sessionContext
 
+	// sharedSubscribers track which sessions are subscribed to which
+	// shared RVPoint.
+	sharedSubscribers map[ratchet.RVPoint]map[*sessionContext]struct{}
+
+	// sharedAcks track which subscribers ack'd the payload of which shared
+	// RVPoint.
+	sharedAcks map[ratchet.RVPoint]*sharedRVAcks
+
 	// Not mutex entries
 	db          serverdb.ServerDB
 	settings    *settings.Settings
@@ -122,7 +130,14 @@ func (z *ZKS) writeMessage(kx *session.KX, msg *RPCWrapper) error {
 
 func (z *ZKS) welcome(kx *session.KX) error {
 	var err error
-	properties := rpc.SupportedServerProperties
+	properties := make([]rpc.ServerProperty, 0, len(rpc.SupportedServerProperties))
+	for _, v := range rpc.SupportedServerProperties {
+		// Only advertise shared RVs when they are allowed.
+		if v.Key == rpc.PropSharedRVs && !z.settings.SharedRVs {
+			continue
+		}
+		properties = append(properties, v)
+	}
 	for k, v := range properties {
 		switch v.Key {
 		case rpc.PropTagDepth:
@@ -332,6 +347,10 @@ func (z *ZKS) expirationLoop(ctx context.Context) error {
 			}
 		}
 
+		// The payloads of shared RVs first ack'd before the expired
+		// dates are gone, so their acks are no longer needed.
+		z.expireSharedAcks(expirationDate.Add(-day))
+
 		// Schedule expiration for the next day, UTC time.
 		whenNextExpire := time.Date(now.Year(), now.Month(), now.Day()+1,
 			0, 0, 0, 0, time.UTC)
@@ -423,8 +442,11 @@ func NewServer(cfg *settings.Settings) (*ZKS, error) {
 		logConn:     logBknd.logger("CONN"),
 		subscribers: make(map[ratchet.RVPoint]*sessionContext),
 		pingLimit:   rpc.PingLimit,
-		dbCtx:       dbCtx,
-		dbCtxCancel: dbCtxCancel,
+
+		dbCtx:             dbCtx,
+		dbCtxCancel:       dbCtxCancel,
+		sharedSubscribers: make(map[ratchet.RVPoint]map[*sessionContext]struct{}),
+		sharedAcks:        make(map[ratchet.RVPoint]*sharedRVAcks),
 	}
 
 	z.log.Infof("Settings %v", spew.Sdump(z.settings))
@@ -94,12 +94,26 @@ func (z *ZKS) sessionSubscribe(ctx context.Context, sc *sessionContext) error {
 	// operations and lock contention for memory consumption.
 	sessSubs := make(map[ratchet.RVPoint]struct{})
 
+	// Track shared subscriptions (and their subscriber) for this session.
+	// Payloads of shared RVs are not removed when ack'd, because other
+	// sessions may still fetch them.
+	sessSharedSubs := make(map[ratchet.RVPoint][32]byte)
+	delSharedSub := func(rv ratchet.RVPoint) {
+		delete(z.sharedSubscribers[rv], sc)
+		if len(z.sharedSubscribers[rv]) == 0 {
+			delete(z.sharedSubscribers, rv)
+		}
+	}
+
 	defer func() {
 		// Remove all of this session's subscriptions.
 		z.Lock()
 		for rv := range sessSubs {
 			delete(z.subscribers, rv)
 		}
+		for rv := range sessSharedSubs {
+			delSharedSub(rv)
+		}
 		z.Unlock()
 		sc.log.Tracef("subscribers quit: %v", sessSubs)
 	}()
@@ -118,6 +132,12 @@ loop:
 			z.Lock()
 			// Remove subscriptions that were deleted.
 			for _, rv := range s.DelRendezvous {
+				if _, ok := sessSharedSubs[rv]; ok {
+					delSharedSub(rv)
+					delete(sessSharedSubs, rv)
+					z.stats.activeSubs.add(-1)
+					continue
+				}
 				if _, ok := sessSubs[rv]; !ok {
 					continue
 				}
@@ -129,12 +149,14 @@ loop:
 			// Add new subscriptions.
 			for i := 0; i < len(rvsToCheck); i++ {
 				rv := rvsToCheck[i]
-				if other, ok := z.subscribers[rv]; ok && sc != other {
+				other, ok := z.subscribers[rv]
+				if (ok && sc != other) || len(z.sharedSubscribers[rv]) > 0 {
 					// Someone tried to subscribe to an RV
 					// that another session was already
 					// subscribed to. Skip this RV.
 					copy(rvsToCheck[i:], rvsToCheck[i+1:])
 					rvsToCheck = rvsToCheck[:len(rvsToCheck)-1]
+					i--
 					continue
 				}
 				z.subscribers[rv] = sc
@@ -142,6 +164,27 @@ loop:
 				z.stats.subsRecv.add(1)
 				z.stats.activeSubs.add(1)
 			}
+
+			// Add new shared subscriptions.
+			for _, shared := range s.AddSharedRendezvous {
+				rv := shared.RV()
+				if _, ok := sessSharedSubs[rv]; ok {
+					continue
+				}
+				if _, ok := z.subscribers[rv]; ok {
+					// The RV is exclusively subscribed
+					// by a session. Skip this RV.
+					continue
+				}
+				if z.sharedSubscribers[rv] == nil {
+					z.sharedSubscribers[rv] = make(map[*sessionContext]struct{})
+				}
+				z.sharedSubscribers[rv][sc] = struct{}{}
+				sessSharedSubs[rv] = shared.Subscriber
+				rvsToCheck = append(rvsToCheck, rv)
+				z.stats.subsRecv.add(1)
+				z.stats.activeSubs.add(1)
+			}
 			z.Unlock()
 
 			sc.log.Tracef("subscribers added %v deleted %v",
@@ -156,6 +199,13 @@ loop:
 		case rv := <-sc.msgAckC:
 			sc.log.Tracef("subscribers ackd: %v", rv)
 
+			// Shared RVs are only removed once they expire, but
+			// are not pushed again to the same subscriber.
+			if subscriber, ok := sessSharedSubs[rv]; ok {
+				z.ackSharedRV(rv, subscriber)
+				continue loop
+			}
+
 			// Ackd rv. Delete from db.
 			err := z.db.RemovePayload(z.dbCtx, rv)
 			if err != nil {
@@ -168,6 +218,11 @@ loop:
 
 		// Among the new RVs added, see if any are already stored.
 		for _, rv := range rvsToCheck {
+			subscriber, ok := sessSharedSubs[rv]
+			if ok && z.isSharedRVAcked(rv, subscriber) {
+				continue
+			}
+
 			msgPayload, err := z.db.FetchPayload(z.dbCtx, rv)
 			if err != nil {
 				sc.log.Errorf("subscribers FetchContent: %v", err)
@@ -222,6 +277,10 @@ loop:
 		delete(z.subscribers, rv)
 		z.stats.activeSubs.add(-1)
 	}
+	for rv := range sessSharedSubs {
+		delSharedSub(rv)
+		z.stats.activeSubs.add(-1)
+	}
 	z.Unlock()
 
 	return ctx.Err()
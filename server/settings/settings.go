@@ -36,7 +36,8 @@ type Settings struct {
 	InitSessTimeout time.Duration // How long to wait for session on a new connection
 
 	// policy section
-	ExpirationDays int // How many days after which to expire data
+	ExpirationDays int  // How many days after which to expire data
+	SharedRVs      bool // Whether to allow subscribing to shared RVs
 
 	// payment section
 	PayScheme         string
@@ -86,6 +87,7 @@ func New() *Settings {
 
 		// Policy
 		ExpirationDays: rpc.PropExpirationDaysDefault,
+		SharedRVs:      true,
 
 		// payment
 		PayScheme:         "free",
@@ -239,6 +241,11 @@ func (s *Settings) Load(filename string) error {
 	}
 	s.ExpirationDays = expirationDays
 
+	err = iniBool(cfg, &s.SharedRVs, "policy", "sharedrvs")
+	if err != nil && !errors.Is(err, errIniNotFound) {
+		return err
+	}
+
 	return nil
 }
 
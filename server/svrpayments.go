@@ -335,7 +335,11 @@ func (z *ZKS) areSubsPaid(ctx context.Context, r *rpc.SubscribeRoutedMessages, s
 	}
 
 	// Store in DB the new unpaid items.
-	for _, rv := range r.AddRendezvous {
+	addRVs := r.AddRendezvous[:len(r.AddRendezvous):len(r.AddRendezvous)]
+	for i := range r.AddSharedRendezvous {
+		addRVs = append(addRVs, r.AddSharedRendezvous[i].RV())
+	}
+	for _, rv := range addRVs {
 		if paid, err := z.db.IsSubscriptionPaid(ctx, rv); err != nil {
 			return err
 		} else if paid {